@@ -5,6 +5,7 @@ package ainterface
 
 import (
 	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/protos"
 )
 
 type Consensus interface {
@@ -13,6 +14,12 @@ type Consensus interface {
 	GetRoundInterval() int64
 }
 
+// MessageHandler is implemented by the consensus services which exchange
+// messages of their own with the peers.
+type MessageHandler interface {
+	HandleMessage(msg protos.Message)
+}
+
 type BlockNode interface {
 	Hash() common.Hash
 	StateRoot() common.Hash
@@ -98,6 +98,12 @@ type ContractManager interface {
 		chainConfig *params.ChainConfig,
 		miners []string) ([]common.Address, []uint32, error)
 
+	// Get the admins and the validators of consensus_poa
+	GetAdminsAndValidators(
+		block *asiutil.Block,
+		stateDB vm.StateDB,
+		chainConfig *params.ChainConfig) ([]common.Address, []common.Address, error)
+
 	IsLimit(block *asiutil.Block,
 		stateDB vm.StateDB, asset *protos.Asset) int
 
@@ -6,9 +6,11 @@ package main
 
 import (
 	"fmt"
+	"github.com/AsimovNetwork/asimov/blockchain/indexers"
 	"github.com/AsimovNetwork/asimov/chaincfg"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/consensus"
 	"github.com/AsimovNetwork/asimov/database"
-	"github.com/AsimovNetwork/asimov/database/dbdriver"
 	"github.com/AsimovNetwork/asimov/database/dbimpl/ethdb"
 	"github.com/AsimovNetwork/asimov/limits"
 	"github.com/AsimovNetwork/asimov/logger"
@@ -53,6 +55,16 @@ func flowdMain(serverChan chan<- *servers.NodeServer) error {
 		return err
 	}
 
+	// Make sure the consensus engine is registered.  A private chain may
+	// add its own engine by importing its package, which registers itself
+	// with consensus.RegisterEngine.
+	if consensus.LookupEngine(cfg.Consensustype) == nil {
+		err := fmt.Errorf("unknown consensus type %q, supported types are %v",
+			cfg.Consensustype, consensus.SupportedEngines())
+		fmt.Fprintln(os.Stderr, err)
+		return err
+	}
+
 	// Initialize logger rotation.  After logger rotation has been initialized, the
 	// logger variables may be used.
 	logger.InitLogRotator(filepath.Join(cfg.LogDir, defaultLogFilename))
@@ -67,6 +79,15 @@ func flowdMain(serverChan chan<- *servers.NodeServer) error {
 		return err
 	}
 
+	// The blocks of a bft chain are only valid with the commit certificate
+	// gathered by the bft consensus.
+	if chaincfg.ActiveNetParams.BFT && cfg.Consensustype != common.GetConsensusName(common.BFT) {
+		err := fmt.Errorf("the chain requires the bft consensus, not %q",
+			cfg.Consensustype)
+		mainLog.Error(err)
+		return err
+	}
+
 	// Get a channel that will be closed when a shutdown signal has been
 	// triggered either from an OS signal such as SIGINT (Ctrl+C) or from
 	// another subsystem such as the RPC server.
@@ -114,6 +135,16 @@ func flowdMain(serverChan chan<- *servers.NodeServer) error {
 		return nil
 	}
 
+	// Run as a light client if requested.
+	if cfg.SPV {
+		if err := spvMain(cfg, interrupt); err != nil {
+			mainLog.Errorf("%v", err)
+			return err
+		}
+
+		return nil
+	}
+
 	// Load the block database.
 	db, err := loadBlockDB(cfg)
 	if err != nil {
@@ -126,6 +157,16 @@ func flowdMain(serverChan chan<- *servers.NodeServer) error {
 		db.Close()
 	}()
 
+	// Drop indexes and exit if requested.
+	if cfg.DropBloomIndex {
+		if err := indexers.DropBloomIndex(db, interrupt); err != nil {
+			mainLog.Errorf("%v", err)
+			return err
+		}
+
+		return nil
+	}
+
 	// Load StateDB
 	stateDB, err := ethdb.NewLDBDatabase(cfg.StateDir, 768, 1024)
 	if err != nil {
@@ -138,6 +179,24 @@ func flowdMain(serverChan chan<- *servers.NodeServer) error {
 		stateDB.Close()
 	}()
 
+	// Export the chain state and exit if requested.
+	if cfg.ExportSnapshot != "" {
+		if err := exportSnapshot(db, stateDB, cfg); err != nil {
+			mainLog.Errorf("%v", err)
+			return err
+		}
+
+		return nil
+	}
+
+	// Bootstrap the chain state from a snapshot if requested.
+	if cfg.ImportSnapshot != "" {
+		if err := importSnapshot(db, stateDB, cfg); err != nil {
+			mainLog.Errorf("%v", err)
+			return err
+		}
+	}
+
 	// Return now if an interrupt signal was triggered.
 	if interruptRequested(interrupt) {
 		return nil
@@ -193,24 +252,9 @@ func loadBlockDB(cfg *chaincfg.FConfig) (database.Transactor, error) {
 	dbPath := blockDbPath(cfg.DataDir, database.FFLDB)
 
 	mainLog.Infof("Loading block database from '%s'", dbPath)
-	db, err := dbdriver.Open(database.FFLDB, dbPath, chaincfg.ActiveNetParams.Net)
+	db, err := openDB(cfg.DataDir, dbPath)
 	if err != nil {
-		// Return the error if it's not because the database doesn't
-		// exist.
-		if dbErr, ok := err.(database.Error); !ok || dbErr.ErrorCode !=
-			database.ErrDbDoesNotExist {
-			return nil, err
-		}
-
-		// Create the db if it does not exist.
-		err = os.MkdirAll(cfg.DataDir, 0700)
-		if err != nil {
-			return nil, err
-		}
-		db, err = dbdriver.Create(database.FFLDB, dbPath, chaincfg.ActiveNetParams.Net)
-		if err != nil {
-			return nil, err
-		}
+		return nil, err
 	}
 
 	mainLog.Info("Block database loaded")
@@ -12,6 +12,8 @@ import (
 
 	"github.com/AsimovNetwork/asimov/asiutil/gcs"
 	"github.com/AsimovNetwork/asimov/protos"
+	"github.com/AsimovNetwork/asimov/txscript"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/types"
 )
 
 const (
@@ -294,6 +296,73 @@ func BuildBasicFilter(block *protos.MsgBlock, prevOutScripts [][]byte) (*gcs.Fil
 		return nil, err
 	}
 
+	addBasicEntries(b, block, prevOutScripts)
+	return b.Build()
+}
+
+// BuildContractFilter builds a contract GCS filter from a block. A contract
+// GCS filter contains everything a basic one does, along with the addresses of
+// the contracts called or created by the transactions of the block, the
+// addresses and topics of the logs in their receipts, and the output scripts
+// of the virtual transactions of the block.
+func BuildContractFilter(block *protos.MsgBlock, prevOutScripts [][]byte,
+	vblock *protos.MsgVBlock, receipts types.Receipts) (*gcs.Filter, error) {
+
+	blockHash := block.BlockHash()
+	b := WithKeyHash(&blockHash)
+
+	_, err := b.Key()
+	if err != nil {
+		return nil, err
+	}
+
+	addBasicEntries(b, block, prevOutScripts)
+
+	// Add the addresses of the contracts called by the outputs.
+	for _, tx := range block.Transactions {
+		for _, txOut := range tx.TxOut {
+			class, addrs, _, err := txscript.ExtractPkScriptAddrs(txOut.PkScript)
+			if err != nil || (class != txscript.CallTy && class != txscript.VoteTy) {
+				continue
+			}
+			for _, addr := range addrs {
+				b.AddEntry(addr.ScriptAddress())
+			}
+		}
+	}
+
+	// The virtual transactions move the assets held by the contracts.
+	if vblock != nil {
+		for _, tx := range vblock.VTransactions {
+			for _, txOut := range tx.TxOut {
+				if len(txOut.PkScript) == 0 {
+					continue
+				}
+
+				b.AddEntry(txOut.PkScript)
+			}
+		}
+	}
+
+	// Add the created contracts and the logs the contracts emitted.
+	for _, receipt := range receipts {
+		if receipt.ContractAddress != (common.Address{}) {
+			b.AddEntry(receipt.ContractAddress.Bytes())
+		}
+		for _, log := range receipt.Logs {
+			b.AddEntry(log.Address.Bytes())
+			for i := range log.Topics {
+				b.AddHash(&log.Topics[i])
+			}
+		}
+	}
+
+	return b.Build()
+}
+
+// addBasicEntries adds the entries of a basic filter of the passed block to
+// the builder.
+func addBasicEntries(b *GCSBuilder, block *protos.MsgBlock, prevOutScripts [][]byte) {
 	// In order to build a basic filter, we'll range over the entire block,
 	// adding each whole script itself.
 	for _, tx := range block.Transactions {
@@ -317,8 +386,6 @@ func BuildBasicFilter(block *protos.MsgBlock, prevOutScripts [][]byte) (*gcs.Fil
 
 		b.AddEntry(prevScript)
 	}
-
-	return b.Build()
 }
 
 // GetFilterHash returns the double-SHA256 of the filter.
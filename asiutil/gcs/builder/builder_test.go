@@ -12,7 +12,9 @@ import (
 	"github.com/AsimovNetwork/asimov/asiutil/gcs"
 	"github.com/AsimovNetwork/asimov/asiutil/gcs/builder"
 	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/protos"
 	"github.com/AsimovNetwork/asimov/txscript"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/types"
 )
 
 var (
@@ -245,3 +247,82 @@ func BuilderTest(b *builder.GCSBuilder, hash *common.Hash, p uint8, addrBytes []
 		t.Fatal("Filter size increased with duplicate items")
 	}
 }
+
+// TestBuildContractFilter ensures a contract filter matches the contracts
+// called and created by a block, their logs and the virtual transaction
+// outputs, along with the entries of the basic filter.
+func TestBuildContractFilter(t *testing.T) {
+	contract := common.HexToAddress("0x630000000000000000000000000000000000000065")
+	created := common.HexToAddress("0x630000000000000000000000000000000000000066")
+	user := common.HexToAddress("0x66ca348138ef2bec32528bf67678012833c2a56e1b")
+	topic := common.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef")
+
+	callScript, err := txscript.PayToAddrScript(&contract)
+	if err != nil {
+		t.Fatalf("PayToAddrScript err %v", err)
+	}
+	userScript, err := txscript.PayToAddrScript(&user)
+	if err != nil {
+		t.Fatalf("PayToAddrScript err %v", err)
+	}
+	prevScript := []byte{0x76, 0xa9}
+
+	tx := protos.NewMsgTx(protos.TxVersion)
+	tx.AddTxOut(protos.NewContractTxOut(0, callScript, protos.Asset{}, nil))
+	block := &protos.MsgBlock{Transactions: []*protos.MsgTx{tx}}
+
+	vtx := protos.NewMsgTx(protos.TxVersion)
+	vtx.AddTxOut(protos.NewTxOut(1, userScript, protos.Asset{}))
+	vblock := &protos.MsgVBlock{VTransactions: []*protos.MsgTx{vtx}}
+
+	receipts := types.Receipts{{
+		ContractAddress: created,
+		Logs: []*types.Log{{
+			Address: contract,
+			Topics:  []common.Hash{topic},
+		}},
+	}}
+
+	basic, err := builder.BuildBasicFilter(block, [][]byte{prevScript})
+	if err != nil {
+		t.Fatalf("BuildBasicFilter err %v", err)
+	}
+	filter, err := builder.BuildContractFilter(block, [][]byte{prevScript},
+		vblock, receipts)
+	if err != nil {
+		t.Fatalf("BuildContractFilter err %v", err)
+	}
+
+	blockHash := block.BlockHash()
+	key := builder.DeriveKey(&blockHash)
+	entries := map[string][]byte{
+		"call script":     callScript,
+		"previous script": prevScript,
+		"called contract": contract.Bytes(),
+		"vtx output":      userScript,
+		"created":         created.Bytes(),
+		"log topic":       topic.Bytes(),
+	}
+	for name, entry := range entries {
+		match, err := filter.Match(key, entry)
+		if err != nil {
+			t.Fatalf("Match %s err %v", name, err)
+		}
+		if !match {
+			t.Errorf("contract filter does not match the %s", name)
+		}
+	}
+
+	// The basic filter does not hold the contract entries.
+	match, err := basic.Match(key, topic.Bytes())
+	if err != nil {
+		t.Fatalf("Match err %v", err)
+	}
+	if match {
+		t.Errorf("basic filter matches the log topic")
+	}
+	if filter.N() <= basic.N() {
+		t.Errorf("contract filter holds %d entries, not more than the %d of "+
+			"the basic one", filter.N(), basic.N())
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// Package proof verifies the merkle proofs returned by the getTxProof and
+// getProof RPCs of asimovd.  It does not need a node or a database, so that
+// other chains and their relayers are able to check a transaction was
+// included in a block, or an account or a contract storage slot had a value
+// at a block, given only the block header.
+//
+// A transaction proof is checked against the MerkleRoot of the header.  An
+// account proof is checked against the StateRoot of the header, and a storage
+// proof against the storage root of the proven account.
+package proof
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/crypto"
+	"github.com/AsimovNetwork/asimov/vm/fvm/rlp"
+	"github.com/AsimovNetwork/asimov/vm/fvm/trie"
+)
+
+var (
+	// EmptyRoot is the root hash of an empty trie, which is the storage
+	// root of the accounts without storage, and of the accounts which do
+	// not exist.
+	EmptyRoot = common.HexToHash("56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421")
+
+	// ErrRootMismatch is returned when a transaction proof does not lead to
+	// the merkle root of the block.
+	ErrRootMismatch = errors.New("merkle root mismatch")
+)
+
+// hashMerkleBranches returns the double sha256 of the concatenation of the
+// left and right nodes of a transaction merkle tree.
+func hashMerkleBranches(left, right *common.Hash) common.Hash {
+	var buf [common.HashLength * 2]byte
+	copy(buf[:common.HashLength], left[:])
+	copy(buf[common.HashLength:], right[:])
+	return common.DoubleHashH(buf[:])
+}
+
+// TxMerkleRoot returns the merkle root calculated from the hash of the
+// transaction at the passed index of a block and its merkle branch, from the
+// bottom up.
+func TxMerkleRoot(txHash common.Hash, index uint32, branch []common.Hash) (common.Hash, error) {
+	if len(branch) < 32 && index>>uint(len(branch)) != 0 {
+		return common.Hash{}, fmt.Errorf("index %d out of a tree of depth %d",
+			index, len(branch))
+	}
+	root := txHash
+	for i := range branch {
+		if index&1 == 0 {
+			root = hashMerkleBranches(&root, &branch[i])
+		} else {
+			root = hashMerkleBranches(&branch[i], &root)
+		}
+		index >>= 1
+	}
+	return root, nil
+}
+
+// VerifyTxProof checks the transaction at the passed index of a block is
+// proven by the merkle branch against the merkle root of the block.  Since a
+// node without a right sibling is hashed with itself, the index of the last
+// transaction of a block is not unique, the transaction itself is.
+func VerifyTxProof(merkleRoot common.Hash, txHash common.Hash, index uint32,
+	branch []common.Hash) error {
+
+	root, err := TxMerkleRoot(txHash, index, branch)
+	if err != nil {
+		return err
+	}
+	if root != merkleRoot {
+		return ErrRootMismatch
+	}
+	return nil
+}
+
+// Account is the state of an account as stored in the state trie.
+type Account struct {
+	Nonce    uint64
+	Balance  *big.Int
+	Root     common.Hash // merkle root of the storage trie
+	CodeHash []byte
+}
+
+// nodeSet is a set of trie nodes keyed by their keccak256 hash, which
+// serves the nodes of a proof to the trie verifier.
+type nodeSet map[string][]byte
+
+func (s nodeSet) Get(key []byte) ([]byte, error) {
+	if node, ok := s[string(key)]; ok {
+		return node, nil
+	}
+	return nil, errors.New("not found")
+}
+
+func (s nodeSet) Has(key []byte) (bool, error) {
+	_, ok := s[string(key)]
+	return ok, nil
+}
+
+// verifyTrieProof returns the value proven by the trie nodes for the key
+// hashed by keccak256, and nil when the proof proves the absence of the key.
+func verifyTrieProof(root common.Hash, key []byte, proof [][]byte) ([]byte, error) {
+	if root == EmptyRoot {
+		return nil, nil
+	}
+	nodes := make(nodeSet, len(proof))
+	for _, node := range proof {
+		nodes[string(crypto.Keccak256(node))] = node
+	}
+	value, _, err := trie.VerifyProof(root, crypto.Keccak256(key), nodes)
+	return value, err
+}
+
+// VerifyAccountProof checks the proof of an account against the state root
+// of a block, and returns the proven account.  The account is nil when the
+// proof proves it does not exist.
+func VerifyAccountProof(stateRoot common.Hash, address common.Address,
+	proof [][]byte) (*Account, error) {
+
+	value, err := verifyTrieProof(stateRoot, address[:], proof)
+	if err != nil || value == nil {
+		return nil, err
+	}
+	var account Account
+	if err := rlp.DecodeBytes(value, &account); err != nil {
+		return nil, fmt.Errorf("bad account %x: %v", address[:], err)
+	}
+	return &account, nil
+}
+
+// VerifyStorageProof checks the proof of a storage slot against the storage
+// root of an account, and returns the proven value.  The value is zero when
+// the proof proves the slot is not set.
+func VerifyStorageProof(storageRoot common.Hash, key common.Hash,
+	proof [][]byte) (common.Hash, error) {
+
+	value, err := verifyTrieProof(storageRoot, key[:], proof)
+	if err != nil || value == nil {
+		return common.Hash{}, err
+	}
+	var content []byte
+	if err := rlp.DecodeBytes(value, &content); err != nil {
+		return common.Hash{}, fmt.Errorf("bad storage value of %x: %v", key[:], err)
+	}
+	if len(content) > common.HashLength || bytes.HasPrefix(content, []byte{0}) {
+		return common.Hash{}, fmt.Errorf("bad storage value of %x", key[:])
+	}
+	return common.BytesToHash(content), nil
+}
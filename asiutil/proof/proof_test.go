@@ -0,0 +1,151 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package proof
+
+import (
+	"testing"
+
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/blockchain"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/database/dbimpl/ethdb"
+	"github.com/AsimovNetwork/asimov/protos"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/state"
+)
+
+func TestVerifyTxProof(t *testing.T) {
+	txs := make([]*asiutil.Tx, 0, 5)
+	for i := 0; i < 5; i++ {
+		tx := protos.NewMsgTx(protos.TxVersion)
+		tx.LockTime = uint32(i)
+		txs = append(txs, asiutil.NewTx(tx))
+	}
+	merkles := blockchain.BuildMerkleTreeStore(txs)
+	root := *merkles[len(merkles)-1]
+
+	for i, tx := range txs {
+		var branch []common.Hash
+		for _, sibling := range blockchain.BuildMerkleBranch(merkles, i) {
+			branch = append(branch, *sibling)
+		}
+		if err := VerifyTxProof(root, *tx.Hash(), uint32(i), branch); err != nil {
+			t.Errorf("VerifyTxProof of tx %d err %v", i, err)
+		}
+
+		// The proof does not hold for another position or transaction.
+		// The last transaction is hashed with itself, so that it is
+		// proven at the position of its missing sibling too.
+		if i^1 < len(txs) {
+			if err := VerifyTxProof(root, *tx.Hash(), uint32(i^1), branch); err == nil {
+				t.Errorf("VerifyTxProof of tx %d accepted the index %d", i, i^1)
+			}
+		}
+		if err := VerifyTxProof(root, *txs[(i+1)%5].Hash(), uint32(i), branch); err == nil {
+			t.Errorf("VerifyTxProof of tx %d accepted another transaction", i)
+		}
+		if err := VerifyTxProof(root, *tx.Hash(), uint32(i)+8, branch); err == nil {
+			t.Errorf("VerifyTxProof of tx %d accepted an index out of the tree", i)
+		}
+	}
+}
+
+func TestVerifyStateProof(t *testing.T) {
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(ethdb.NewMemDatabase()))
+	if err != nil {
+		t.Fatalf("state.New err %v", err)
+	}
+	contract := common.Address{0x63, 0x01}
+	empty := common.Address{0x66, 0x02}
+	missing := common.Address{0x66, 0x03}
+	key, unset := common.Hash{0x01}, common.Hash{0x02}
+	value := common.Hash{31: 0x2a}
+	statedb.SetNonce(contract, 3)
+	statedb.SetCode(contract, []byte{0x60, 0x00})
+	statedb.SetState(contract, key, value)
+	statedb.SetNonce(empty, 1)
+	for i := byte(0); i < 100; i++ {
+		statedb.SetNonce(common.Address{0x66, 0x10, i}, 1)
+	}
+	stateRoot, err := statedb.Commit(false)
+	if err != nil {
+		t.Fatalf("Commit err %v", err)
+	}
+
+	// The account and its storage are proven.
+	accountProof, err := statedb.GetProof(contract)
+	if err != nil {
+		t.Fatalf("GetProof err %v", err)
+	}
+	account, err := VerifyAccountProof(stateRoot, contract, accountProof)
+	if err != nil || account == nil {
+		t.Fatalf("VerifyAccountProof got %v, err %v", account, err)
+	}
+	if account.Nonce != 3 || common.BytesToHash(account.CodeHash) != statedb.GetCodeHash(contract) {
+		t.Errorf("proven account got nonce %d and code hash %x", account.Nonce, account.CodeHash)
+	}
+	if account.Root != statedb.StorageTrie(contract).Hash() {
+		t.Errorf("proven account got storage root %v, want %v", account.Root,
+			statedb.StorageTrie(contract).Hash())
+	}
+	for _, test := range []struct {
+		key   common.Hash
+		value common.Hash
+	}{
+		{key, value},
+		{unset, common.Hash{}},
+	} {
+		storageProof, err := statedb.GetStorageProof(contract, test.key)
+		if err != nil {
+			t.Fatalf("GetStorageProof err %v", err)
+		}
+		got, err := VerifyStorageProof(account.Root, test.key, storageProof)
+		if err != nil || got != test.value {
+			t.Errorf("VerifyStorageProof of %v got %v, err %v, want %v",
+				test.key, got, err, test.value)
+		}
+	}
+
+	// An account without storage has the empty root.
+	account, err = VerifyAccountProof(stateRoot, empty, mustProve(t, statedb, empty))
+	if err != nil || account == nil || account.Root != EmptyRoot {
+		t.Fatalf("VerifyAccountProof of an account without storage got %v, err %v", account, err)
+	}
+	if got, err := VerifyStorageProof(EmptyRoot, key, nil); err != nil || got != (common.Hash{}) {
+		t.Errorf("VerifyStorageProof of the empty root got %v, err %v", got, err)
+	}
+
+	// The absence of an account is proven.
+	account, err = VerifyAccountProof(stateRoot, missing, mustProve(t, statedb, missing))
+	if err != nil || account != nil {
+		t.Errorf("VerifyAccountProof of a missing account got %v, err %v", account, err)
+	}
+
+	// The proof of an account does not hold for another account, another
+	// root or tampered nodes.
+	if account, err := VerifyAccountProof(stateRoot, empty, accountProof); err == nil && account != nil {
+		t.Errorf("VerifyAccountProof accepted the proof of another account")
+	}
+	if _, err := VerifyAccountProof(common.Hash{0x01}, contract, accountProof); err == nil {
+		t.Errorf("VerifyAccountProof accepted another state root")
+	}
+	tampered := make([][]byte, len(accountProof))
+	for i := range accountProof {
+		tampered[i] = append([]byte{}, accountProof[i]...)
+	}
+	last := tampered[len(tampered)-1]
+	last[len(last)-1] ^= 0xff
+	if _, err := VerifyAccountProof(stateRoot, contract, tampered); err == nil {
+		t.Errorf("VerifyAccountProof accepted a tampered proof")
+	}
+}
+
+// mustProve returns the proof of an account.
+func mustProve(t *testing.T, statedb *state.StateDB, addr common.Address) [][]byte {
+	proof, err := statedb.GetProof(addr)
+	if err != nil {
+		t.Fatalf("GetProof err %v", err)
+	}
+	return proof
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package psbt
+
+import (
+	"bytes"
+)
+
+// Combine merges the PSBTs of the cosigners of a transaction into a new
+// PSBT.  All packets must share the same unsigned transaction.  Partial
+// signatures and unknowns are merged, for every other field the first packet
+// holding a value wins.  A final signature script found in any packet makes
+// the input final in the result.
+func Combine(packets ...*Packet) (*Packet, error) {
+	if len(packets) == 0 {
+		return nil, ErrInvalidPsbtFormat
+	}
+
+	base := packets[0]
+	var buf bytes.Buffer
+	if err := base.UnsignedTx.Serialize(&buf); err != nil {
+		return nil, err
+	}
+	baseTx := buf.Bytes()
+
+	combined, err := NewFromUnsignedTx(base.UnsignedTx.Copy())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range packets {
+		var other bytes.Buffer
+		if err := p.UnsignedTx.Serialize(&other); err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(baseTx, other.Bytes()) {
+			return nil, ErrMismatchedUnsignedTx
+		}
+		if err := p.SanityCheck(); err != nil {
+			return nil, err
+		}
+
+		combined.Unknowns = mergeUnknowns(combined.Unknowns, p.Unknowns)
+		for i := range p.Inputs {
+			combineInput(&combined.Inputs[i], &p.Inputs[i])
+		}
+		for i := range p.Outputs {
+			out, in := &combined.Outputs[i], &p.Outputs[i]
+			if out.RedeemScript == nil {
+				out.RedeemScript = in.RedeemScript
+			}
+			out.Unknowns = mergeUnknownPtrs(out.Unknowns, in.Unknowns)
+		}
+	}
+
+	// Inputs which became final drop their signing data.
+	for i := range combined.Inputs {
+		pInput := &combined.Inputs[i]
+		if pInput.FinalScriptSig != nil {
+			pInput.PartialSigs = nil
+			pInput.SighashType = 0
+			pInput.RedeemScript = nil
+		}
+	}
+
+	if err := combined.SanityCheck(); err != nil {
+		return nil, err
+	}
+	return combined, nil
+}
+
+// combineInput merges the fields of the input in into out.
+func combineInput(out, in *PInput) {
+	if out.PrevOut == nil {
+		out.PrevOut = in.PrevOut
+	}
+	if out.SighashType == 0 {
+		out.SighashType = in.SighashType
+	}
+	if out.RedeemScript == nil {
+		out.RedeemScript = in.RedeemScript
+	}
+	if out.FinalScriptSig == nil {
+		out.FinalScriptSig = in.FinalScriptSig
+	}
+
+sigLoop:
+	for _, ps := range in.PartialSigs {
+		for _, x := range out.PartialSigs {
+			if bytes.Equal(x.PubKey, ps.PubKey) {
+				continue sigLoop
+			}
+		}
+		out.PartialSigs = append(out.PartialSigs, ps)
+	}
+
+	out.Unknowns = mergeUnknownPtrs(out.Unknowns, in.Unknowns)
+}
+
+// mergeUnknowns appends the unknowns of b whose keys are not in a.
+func mergeUnknowns(a, b []unknown) []unknown {
+next:
+	for _, u := range b {
+		for _, x := range a {
+			if bytes.Equal(x.Key, u.Key) {
+				continue next
+			}
+		}
+		a = append(a, u)
+	}
+	return a
+}
+
+// mergeUnknownPtrs appends the unknowns of b whose keys are not in a.
+func mergeUnknownPtrs(a, b []*unknown) []*unknown {
+next:
+	for _, u := range b {
+		for _, x := range a {
+			if bytes.Equal(x.Key, u.Key) {
+				continue next
+			}
+		}
+		a = append(a, u)
+	}
+	return a
+}
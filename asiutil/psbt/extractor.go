@@ -0,0 +1,39 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Copyright (c) 2018 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package psbt
+
+// The Extractor requires provision of a single PSBT in which all necessary
+// signatures are encoded, and uses it to construct a fully valid network
+// serialized transaction.
+
+import (
+	"github.com/AsimovNetwork/asimov/protos"
+)
+
+// Extract takes a finalized psbt.Packet and outputs a finalized transaction
+// instance.  Note that if the PSBT is in-complete, then an error
+// ErrIncompletePSBT will be returned.  As the extracted transaction has been
+// fully finalized, it will be ready for network broadcast once returned.
+func Extract(p *Packet) (*protos.MsgTx, error) {
+	// If the packet isn't complete, then we'll return an error as it
+	// doesn't have all the required witness data.
+	if !p.IsComplete() {
+		return nil, ErrIncompletePSBT
+	}
+
+	// First, we'll make a sallow copy of the underlying unsigned
+	// transaction (with inputs and outputs) so we don't mutate it during
+	// our population of the final signature scripts.
+	finalTx := p.UnsignedTx.Copy()
+
+	// For each input, we'll now populate the sigScript of the final
+	// transaction from the finalized input of the packet.
+	for i, tin := range finalTx.TxIn {
+		tin.SignatureScript = p.Inputs[i].FinalScriptSig
+	}
+
+	return finalTx, nil
+}
@@ -0,0 +1,229 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Copyright (c) 2018 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package psbt
+
+// The Finalizer requires provision of a single PSBT input in which all
+// necessary signatures are encoded, and uses it to construct a fully valid
+// final signature script.  The PSBT is updated in place.
+
+import (
+	"bytes"
+
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/txscript"
+)
+
+// InputStatus describes the signatures an input has and still needs.
+type InputStatus struct {
+	// Class is the class of the script the signatures commit to, which is
+	// the redeem script for pay-to-script-hash inputs.
+	Class txscript.ScriptClass
+
+	// Required is the number of signatures the input needs.
+	Required int
+
+	// Signed is the number of partial signatures usable for the input.
+	Signed int
+
+	// MissingPubKeys are the public keys which can still sign the input.
+	// It is empty for pay-to-pubkey-hash inputs, which only know the
+	// hash of the key, see MissingPubKeyHash.
+	MissingPubKeys [][]byte
+
+	// MissingPubKeyHash is the hash of the public key which has to sign a
+	// pay-to-pubkey-hash input that is not signed yet.
+	MissingPubKeyHash []byte
+}
+
+// signatures matches the partial signatures of the input at index inIndex
+// with the keys of the script the input spends.  It returns the usable
+// signatures in script order, their public keys and the status of the input.
+func signatures(p *Packet, inIndex int) ([][]byte, [][]byte, *InputStatus, error) {
+	script, err := p.signingScript(inIndex)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	pInput := p.Inputs[inIndex]
+	sigFor := func(pubKey []byte) []byte {
+		for _, ps := range pInput.PartialSigs {
+			if bytes.Equal(ps.PubKey, pubKey) {
+				return ps.Signature
+			}
+		}
+		return nil
+	}
+
+	status := &InputStatus{Class: txscript.GetScriptClass(script)}
+	var sigs, pubKeys [][]byte
+	switch status.Class {
+	case txscript.PubKeyHashTy:
+		// The hash of the key is the data push of the script, prefixed
+		// with the address id.
+		pushes, err := txscript.PushedData(script)
+		if err != nil || len(pushes) != 1 {
+			return nil, nil, nil, ErrUnsupportedScriptType
+		}
+		status.Required = 1
+		for _, ps := range pInput.PartialSigs {
+			keyHash := append([]byte{common.PubKeyHashAddrID},
+				common.Hash160(ps.PubKey)...)
+			if bytes.Equal(keyHash, pushes[0]) {
+				sigs = append(sigs, ps.Signature)
+				pubKeys = append(pubKeys, ps.PubKey)
+				break
+			}
+		}
+		if len(sigs) == 0 {
+			status.MissingPubKeyHash = pushes[0][1:]
+		}
+
+	case txscript.PubKeyTy, txscript.MultiSigTy:
+		keys, err := txscript.PushedData(script)
+		if err != nil {
+			return nil, nil, nil, ErrUnsupportedScriptType
+		}
+		status.Required = 1
+		if status.Class == txscript.MultiSigTy {
+			_, status.Required, err = txscript.CalcMultiSigStats(script)
+			if err != nil {
+				return nil, nil, nil, ErrUnsupportedScriptType
+			}
+		}
+		// The signatures have to be in the order of the keys in the
+		// script.
+		for _, key := range keys {
+			sig := sigFor(key)
+			if sig == nil {
+				status.MissingPubKeys = append(status.MissingPubKeys, key)
+				continue
+			}
+			if len(sigs) < status.Required {
+				sigs = append(sigs, sig)
+				pubKeys = append(pubKeys, key)
+			}
+		}
+
+	default:
+		return nil, nil, nil, ErrUnsupportedScriptType
+	}
+
+	status.Signed = len(sigs)
+	if status.Signed == status.Required {
+		status.MissingPubKeys = nil
+	}
+	return sigs, pubKeys, status, nil
+}
+
+// Status returns the signing status of the input at index inIndex.  The
+// previous output of the input, and the redeem script of a
+// pay-to-script-hash input, must be known.
+func (p *Packet) Status(inIndex int) (*InputStatus, error) {
+	if inIndex < 0 || inIndex >= len(p.Inputs) {
+		return nil, ErrInvalidPsbtFormat
+	}
+	_, _, status, err := signatures(p, inIndex)
+	return status, err
+}
+
+// isFinalized considers this input finalized if it contains a final
+// signature script.
+func isFinalized(p *Packet, inIndex int) bool {
+	return p.Inputs[inIndex].FinalScriptSig != nil
+}
+
+// isFinalizable checks whether the structure of the entry for the input of
+// the psbt.Packet at index inIndex contains sufficient information to
+// finalize this input.
+func isFinalizable(p *Packet, inIndex int) bool {
+	_, _, status, err := signatures(p, inIndex)
+	return err == nil && status.Signed == status.Required
+}
+
+// MaybeFinalize attempts to finalize the input at index inIndex in the PSBT p,
+// returning true with no error if it succeeds, OR if the input has already
+// been finalized.
+func MaybeFinalize(p *Packet, inIndex int) (bool, error) {
+	if isFinalized(p, inIndex) {
+		return true, nil
+	}
+
+	if !isFinalizable(p, inIndex) {
+		return false, ErrNotFinalizable
+	}
+
+	if err := Finalize(p, inIndex); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// MaybeFinalizeAll attempts to finalize all inputs of the psbt.Packet that are
+// not already finalized, and returns an error if it fails to do so.
+func MaybeFinalizeAll(p *Packet) error {
+
+	for i := range p.UnsignedTx.TxIn {
+		success, err := MaybeFinalize(p, i)
+		if err != nil || !success {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Finalize assumes that the provided psbt.Packet struct has all partial
+// signatures and redeem scripts necessary to complete the input at index
+// inIndex.  It builds the final signature script of the input and removes
+// the data only needed during signing.
+func Finalize(p *Packet, inIndex int) error {
+	if inIndex < 0 || inIndex >= len(p.Inputs) {
+		return ErrInvalidPsbtFormat
+	}
+	if isFinalized(p, inIndex) {
+		return ErrInputAlreadyFinalized
+	}
+
+	sigs, pubKeys, status, err := signatures(p, inIndex)
+	if err != nil {
+		return err
+	}
+	if status.Signed != status.Required {
+		return ErrNotFinalizable
+	}
+
+	builder := txscript.NewScriptBuilder()
+	switch status.Class {
+	case txscript.PubKeyHashTy:
+		builder.AddData(sigs[0]).AddData(pubKeys[0])
+	case txscript.PubKeyTy:
+		builder.AddData(sigs[0])
+	case txscript.MultiSigTy:
+		// OP_CHECKMULTISIG of asimov does not pop the extra dummy item
+		// of the reference implementation, so only the signatures are
+		// pushed.
+		for _, sig := range sigs {
+			builder.AddData(sig)
+		}
+	}
+
+	pInput := &p.Inputs[inIndex]
+	if pInput.RedeemScript != nil {
+		builder.AddData(pInput.RedeemScript)
+	}
+	finalScriptSig, err := builder.Script()
+	if err != nil {
+		return err
+	}
+
+	// The signing data is useless once the input is final.
+	pInput.FinalScriptSig = finalScriptSig
+	pInput.PartialSigs = nil
+	pInput.SighashType = 0
+	pInput.RedeemScript = nil
+
+	return p.SanityCheck()
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Copyright (c) 2018 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package psbt
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/AsimovNetwork/asimov/common/serialization"
+)
+
+// POutput is a struct encapsulating all the data that can be attached
+// to any specific output of the PSBT.  The amount, asset and data of the
+// output itself are part of the unsigned transaction.
+type POutput struct {
+	RedeemScript []byte
+	Unknowns     []*unknown
+}
+
+// NewPsbtOutput creates an instance of PsbtOutput; the redeemScript is
+// allowed to be `nil`.
+func NewPsbtOutput(redeemScript []byte) *POutput {
+	return &POutput{
+		RedeemScript: redeemScript,
+	}
+}
+
+// deserialize attempts to recode a new POutput from the passed io.Reader.
+func (po *POutput) deserialize(r io.Reader) error {
+	for {
+		keyint, keydata, err := getKey(r)
+		if err != nil {
+			return err
+		}
+		if keyint == -1 {
+			// Reached separator byte
+			break
+		}
+
+		value, err := serialization.ReadVarBytes(
+			r, 0, maxPsbtValueLength, "PSBT value",
+		)
+		if err != nil {
+			return err
+		}
+
+		switch OutputType(keyint) {
+
+		case RedeemScriptOutputType:
+			if po.RedeemScript != nil {
+				return ErrDuplicateKey
+			}
+			if keydata != nil {
+				return ErrInvalidKeydata
+			}
+			po.RedeemScript = value
+
+		default:
+			// A fall through case for any proprietary types.
+			keyintanddata := []byte{byte(keyint)}
+			keyintanddata = append(keyintanddata, keydata...)
+			newUnknown := &unknown{
+				Key:   keyintanddata,
+				Value: value,
+			}
+
+			// Duplicate key+keydata are not permitted.
+			for _, x := range po.Unknowns {
+				if bytes.Equal(x.Key, newUnknown.Key) {
+					return ErrDuplicateKey
+				}
+			}
+
+			po.Unknowns = append(po.Unknowns, newUnknown)
+		}
+	}
+
+	return nil
+}
+
+// serialize attempts to write out the target POutput into the passed
+// io.Writer.
+func (po *POutput) serialize(w io.Writer) error {
+	if po.RedeemScript != nil {
+		err := serializeKVPairWithType(
+			w, uint8(RedeemScriptOutputType), nil, po.RedeemScript,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, kv := range po.Unknowns {
+		err := serializeKVPair(w, kv.Key, kv.Value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,173 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package psbt
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/common/address"
+	"github.com/AsimovNetwork/asimov/crypto"
+	"github.com/AsimovNetwork/asimov/protos"
+	"github.com/AsimovNetwork/asimov/txscript"
+)
+
+// newMultiSigPacket returns the keys of a 2-of-3 multisig, its redeem script
+// and a packet spending a pay-to-script-hash output of it.  The spent output
+// carries a custom asset and data to check they survive the serialization.
+func newMultiSigPacket(t *testing.T) ([]*crypto.PrivateKey, []byte, *Packet) {
+	keys := make([]*crypto.PrivateKey, 3)
+	pubKeys := make([]*address.AddressPubKey, 3)
+	for i := range keys {
+		key, err := crypto.NewPrivateKey(crypto.S256())
+		if err != nil {
+			t.Fatalf("NewPrivateKey: %v", err)
+		}
+		keys[i] = key
+		pubKeys[i], err = address.NewAddressPubKey(key.PubKey().SerializeCompressed())
+		if err != nil {
+			t.Fatalf("NewAddressPubKey: %v", err)
+		}
+	}
+	redeemScript, err := txscript.MultiSigScript(pubKeys, 2)
+	if err != nil {
+		t.Fatalf("MultiSigScript: %v", err)
+	}
+
+	asset := *protos.NewAsset(protos.DivisibleAsset, 1, 7)
+	prevHash := common.HexToHash("0102")
+	tx := protos.NewMsgTx(protos.TxVersion)
+	tx.AddTxIn(protos.NewTxIn(protos.NewOutPoint(&prevHash, 1), nil))
+	addr, _ := common.NewAddressWithId(common.PubKeyHashAddrID, common.Hash160([]byte{1}))
+	pkScript, _ := txscript.PayToAddrScript(addr)
+	tx.AddTxOut(protos.NewContractTxOut(900, pkScript, asset, []byte{0xca, 0xfe}))
+
+	p, err := NewFromUnsignedTx(tx)
+	if err != nil {
+		t.Fatalf("NewFromUnsignedTx: %v", err)
+	}
+	u, _ := NewUpdater(p)
+	prevOut := protos.NewContractTxOut(1000, payToScriptHash(redeemScript), asset, []byte{0xbe, 0xef})
+	if err := u.AddInPrevOut(prevOut, 0); err != nil {
+		t.Fatalf("AddInPrevOut: %v", err)
+	}
+	if err := u.AddInRedeemScript(redeemScript, 0); err != nil {
+		t.Fatalf("AddInRedeemScript: %v", err)
+	}
+	return keys, redeemScript, p
+}
+
+// roundTrip serializes and parses the packet again.
+func roundTrip(t *testing.T, p *Packet) *Packet {
+	b64, err := p.B64Encode()
+	if err != nil {
+		t.Fatalf("B64Encode: %v", err)
+	}
+	parsed, err := NewFromRawBytes(bytes.NewReader([]byte(b64)), true)
+	if err != nil {
+		t.Fatalf("NewFromRawBytes: %v", err)
+	}
+	return parsed
+}
+
+func TestMultiSigFlow(t *testing.T) {
+	keys, _, p := newMultiSigPacket(t)
+
+	parsed := roundTrip(t, p)
+	prevOut := parsed.Inputs[0].PrevOut
+	if prevOut.Value != 1000 || !bytes.Equal(prevOut.Data, []byte{0xbe, 0xef}) ||
+		prevOut.Asset != *protos.NewAsset(protos.DivisibleAsset, 1, 7) {
+		t.Fatalf("previous output did not survive serialization: %+v", prevOut)
+	}
+	if !bytes.Equal(parsed.UnsignedTx.TxOut[0].Data, []byte{0xca, 0xfe}) {
+		t.Fatalf("output data did not survive serialization")
+	}
+
+	// Two cosigners sign their own copy.
+	first, second := roundTrip(t, p), roundTrip(t, p)
+	u, _ := NewUpdater(first)
+	if err := u.Sign(0, keys[2]); err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	u, _ = NewUpdater(second)
+	if err := u.Sign(0, keys[0]); err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+
+	status, err := first.Status(0)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if status.Class != txscript.MultiSigTy || status.Required != 2 ||
+		status.Signed != 1 || len(status.MissingPubKeys) != 2 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	if err := Finalize(first, 0); err != ErrNotFinalizable {
+		t.Fatalf("got error %v, want %v", err, ErrNotFinalizable)
+	}
+	if _, err := Extract(first); err != ErrIncompletePSBT {
+		t.Fatalf("got error %v, want %v", err, ErrIncompletePSBT)
+	}
+
+	combined, err := Combine(roundTrip(t, first), roundTrip(t, second))
+	if err != nil {
+		t.Fatalf("Combine: %v", err)
+	}
+	if err := MaybeFinalizeAll(combined); err != nil {
+		t.Fatalf("MaybeFinalizeAll: %v", err)
+	}
+	combined = roundTrip(t, combined)
+	if !combined.IsComplete() {
+		t.Fatalf("packet is not complete")
+	}
+	tx, err := Extract(combined)
+	if err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+
+	vm, err := txscript.NewEngine(prevOut.PkScript, tx, 0,
+		txscript.StandardVerifyFlags, prevOut.Value, &prevOut.Asset, 0)
+	if err != nil {
+		t.Fatalf("NewEngine: %v", err)
+	}
+	if err := vm.Execute(); err != nil {
+		t.Fatalf("final script does not verify: %v", err)
+	}
+}
+
+func TestInvalidSignatures(t *testing.T) {
+	keys, _, p := newMultiSigPacket(t)
+	u, _ := NewUpdater(p)
+
+	// A signature of another input index does not verify.
+	sig, err := txscript.RawTxInSignature(p.UnsignedTx, 0, []byte{txscript.OP_TRUE},
+		txscript.SigHashAll, keys[0])
+	if err != nil {
+		t.Fatalf("RawTxInSignature: %v", err)
+	}
+	err = u.addPartialSignature(0, sig, keys[0].PubKey().SerializeCompressed())
+	if err != ErrInvalidSignatureForInput {
+		t.Fatalf("got error %v, want %v", err, ErrInvalidSignatureForInput)
+	}
+
+	if err := u.Sign(0, keys[1]); err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if err := u.Sign(0, keys[1]); err != ErrDuplicateKey {
+		t.Fatalf("got error %v, want %v", err, ErrDuplicateKey)
+	}
+
+	// Packets of different transactions can not be combined.
+	_, _, other := newMultiSigPacket(t)
+	other.UnsignedTx.TxOut[0].Value++
+	if _, err := Combine(p, other); err != ErrMismatchedUnsignedTx {
+		t.Fatalf("got error %v, want %v", err, ErrMismatchedUnsignedTx)
+	}
+
+	if _, err := NewFromRawBytes(bytes.NewReader([]byte("psbt")), false); err == nil {
+		t.Fatalf("expected error for truncated magic")
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Copyright (c) 2018 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package psbt
+
+// GlobalType is the set of types that are used at the global scope level
+// within the PSBT.
+type GlobalType uint8
+
+const (
+	// UnsignedTxType is the global scope key that houses the unsigned
+	// transaction of the PSBT.  The value is the transaction in the
+	// standard serialization, including the asset and data of every
+	// output.  The transaction MUST have empty signature scripts.
+	UnsignedTxType GlobalType = 0
+)
+
+// InputType is the set of types that are defined for each input included
+// within the PSBT.
+type InputType uint32
+
+const (
+	// PrevOutType is the key type of the previous output spent by the
+	// input.  The value is the amount, public key script, asset and data
+	// of the output, which is everything a signer needs to know about the
+	// coin it signs away.
+	PrevOutType InputType = 1
+
+	// PartialSigType is the key type of a partial signature.  The key is
+	// the public key of the signer and the value is the signature with the
+	// sighash type appended.
+	PartialSigType InputType = 2
+
+	// SighashType is the key type of the sighash type the signers have to
+	// use for the input.
+	SighashType InputType = 3
+
+	// RedeemScriptInputType is the key type of the redeem script of a
+	// pay-to-script-hash input.
+	RedeemScriptInputType InputType = 4
+
+	// FinalScriptSigType is the key type of the final signature script of
+	// the input, filled in by the finalizer.
+	FinalScriptSigType InputType = 7
+)
+
+// OutputType is the set of types defined per output within the PSBT.
+type OutputType uint32
+
+const (
+	// RedeemScriptOutputType is the key type of the redeem script of a
+	// pay-to-script-hash output.
+	RedeemScriptOutputType OutputType = 0
+)
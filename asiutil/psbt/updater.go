@@ -0,0 +1,235 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Copyright (c) 2018 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package psbt
+
+// The Updater requires provision of a single PSBT and is able to add data to
+// both input and output sections.  It can be called repeatedly to add more
+// data.  It also allows addition of signatures via the Sign function, which
+// verifies every signature before adding it.
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/crypto"
+	"github.com/AsimovNetwork/asimov/protos"
+	"github.com/AsimovNetwork/asimov/txscript"
+)
+
+// Updater encapsulates the role 'Updater' as specified in BIP174; it accepts
+// Psbt structs and has methods to add fields to the inputs and outputs.
+type Updater struct {
+	Upsbt *Packet
+}
+
+// NewUpdater returns a new instance of Updater, if the passed Psbt struct is
+// in a valid form, else an error.
+func NewUpdater(p *Packet) (*Updater, error) {
+	if err := p.SanityCheck(); err != nil {
+		return nil, err
+	}
+
+	return &Updater{Upsbt: p}, nil
+
+}
+
+// AddInPrevOut adds the output spent by the input at index inIndex.  The
+// output must carry the amount, public key script, asset and data exactly as
+// they are found in the UTXO set.
+func (u *Updater) AddInPrevOut(prevOut *protos.TxOut, inIndex int) error {
+	if inIndex >= len(u.Upsbt.Inputs) {
+		return ErrInvalidPsbtFormat
+	}
+	u.Upsbt.Inputs[inIndex].PrevOut = prevOut
+
+	if err := u.Upsbt.SanityCheck(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// AddInSighashType adds the sighash type information for an input.  The
+// sighash type is passed as a 32 bit unsigned integer, along with the index
+// for the input. An error is returned if addition of this key-value pair to
+// the Psbt fails.
+func (u *Updater) AddInSighashType(sighashType txscript.SigHashType,
+	inIndex int) error {
+
+	if inIndex >= len(u.Upsbt.Inputs) {
+		return ErrInvalidPsbtFormat
+	}
+	u.Upsbt.Inputs[inIndex].SighashType = sighashType
+
+	if err := u.Upsbt.SanityCheck(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// AddInRedeemScript adds the redeem script information for an input.  The
+// redeem script is passed serialized, as a byte slice, along with the index of
+// the input. An error is returned if addition of this key-value pair to the
+// Psbt fails.
+func (u *Updater) AddInRedeemScript(redeemScript []byte,
+	inIndex int) error {
+
+	if inIndex >= len(u.Upsbt.Inputs) {
+		return ErrInvalidPsbtFormat
+	}
+	u.Upsbt.Inputs[inIndex].RedeemScript = redeemScript
+
+	if err := u.Upsbt.SanityCheck(); err != nil {
+		return ErrInvalidPsbtFormat
+	}
+
+	return nil
+}
+
+// AddOutRedeemScript takes a redeem script as a byte slice and appends it to
+// the output at index outIndex.
+func (u *Updater) AddOutRedeemScript(redeemScript []byte,
+	outIndex int) error {
+
+	if outIndex >= len(u.Upsbt.Outputs) {
+		return ErrInvalidPsbtFormat
+	}
+	u.Upsbt.Outputs[outIndex].RedeemScript = redeemScript
+
+	if err := u.Upsbt.SanityCheck(); err != nil {
+		return ErrInvalidPsbtFormat
+	}
+
+	return nil
+}
+
+// signingScript returns the script the signatures of the input at index
+// inIndex commit to: the redeem script for pay-to-script-hash inputs and the
+// public key script of the previous output otherwise.
+func (p *Packet) signingScript(inIndex int) ([]byte, error) {
+	pInput := p.Inputs[inIndex]
+	if pInput.PrevOut == nil {
+		return nil, errors.New("previous output of the input is unknown")
+	}
+	if !txscript.IsPayToScriptHash(pInput.PrevOut.PkScript) {
+		return pInput.PrevOut.PkScript, nil
+	}
+	if pInput.RedeemScript == nil {
+		return nil, errors.New("redeem script of the input is unknown")
+	}
+	if !bytes.Equal(payToScriptHash(pInput.RedeemScript), pInput.PrevOut.PkScript) {
+		return nil, errors.New("redeem script does not match the previous output")
+	}
+	return pInput.RedeemScript, nil
+}
+
+// sighashType returns the sighash type the signatures of the input at index
+// inIndex have to use.
+func (p *Packet) sighashType(inIndex int) txscript.SigHashType {
+	if p.Inputs[inIndex].SighashType != 0 {
+		return p.Inputs[inIndex].SighashType
+	}
+	return txscript.SigHashAll
+}
+
+// addPartialSignature allows the Updater role to insert fields of type partial
+// signature into a Psbt, consisting of both the pubkey (as keydata) and the
+// ECDSA signature (as value).  The signature is verified against the
+// signature hash of the input before it is added.
+func (u *Updater) addPartialSignature(inIndex int, sig []byte,
+	pubkey []byte) error {
+
+	partialSig := PartialSig{
+		PubKey: pubkey, Signature: sig,
+	}
+
+	// First validate the passed (sig, pub).
+	if !partialSig.checkValid() {
+		return ErrInvalidPsbtFormat
+	}
+	if inIndex >= len(u.Upsbt.Inputs) {
+		return ErrInvalidPsbtFormat
+	}
+
+	pInput := u.Upsbt.Inputs[inIndex]
+
+	// First check; don't add duplicates.
+	for _, x := range pInput.PartialSigs {
+		if bytes.Equal(x.PubKey, partialSig.PubKey) {
+			return ErrDuplicateKey
+		}
+	}
+
+	// The signature must use the sighash type of the input.
+	hashType := txscript.SigHashType(sig[len(sig)-1])
+	if hashType != u.Upsbt.sighashType(inIndex) {
+		return ErrInvalidSigHashFlags
+	}
+
+	script, err := u.Upsbt.signingScript(inIndex)
+	if err != nil {
+		return err
+	}
+	hash, err := txscript.CalcSignatureHash(script, hashType,
+		u.Upsbt.UnsignedTx, inIndex)
+	if err != nil {
+		return err
+	}
+	signature, _ := crypto.ParseDERSignature(sig[:len(sig)-1], crypto.S256())
+	pubKey, _ := crypto.ParsePubKey(pubkey, crypto.S256())
+	if !signature.Verify(hash, pubKey) {
+		return ErrInvalidSignatureForInput
+	}
+
+	// Attach the signature and sanity check the result.
+	u.Upsbt.Inputs[inIndex].PartialSigs = append(
+		u.Upsbt.Inputs[inIndex].PartialSigs, &partialSig,
+	)
+
+	if err := u.Upsbt.SanityCheck(); err != nil {
+		return err
+	}
+
+	// Addition of a non-duplicate-key partial signature cannot violate
+	// sanity-check rules.
+	return nil
+}
+
+// Sign signs the input at index inIndex with the private key and adds the
+// signature as a partial signature.  The previous output, and the redeem
+// script of a pay-to-script-hash input, must already be known.  Inputs which
+// are already finalized can not be signed.
+func (u *Updater) Sign(inIndex int, privKey *crypto.PrivateKey) error {
+	if inIndex >= len(u.Upsbt.Inputs) {
+		return ErrInvalidPsbtFormat
+	}
+	if isFinalized(u.Upsbt, inIndex) {
+		return ErrInputAlreadyFinalized
+	}
+
+	script, err := u.Upsbt.signingScript(inIndex)
+	if err != nil {
+		return err
+	}
+	sig, err := txscript.RawTxInSignature(u.Upsbt.UnsignedTx, inIndex,
+		script, u.Upsbt.sighashType(inIndex), privKey)
+	if err != nil {
+		return err
+	}
+	pubKey := (*crypto.PublicKey)(&privKey.PublicKey).SerializeCompressed()
+	return u.addPartialSignature(inIndex, sig, pubKey)
+}
+
+// payToScriptHash returns the public key script paying to the hash of the
+// redeem script.
+func payToScriptHash(redeemScript []byte) []byte {
+	scriptHash := append([]byte{common.ScriptHashAddrID},
+		common.Hash160(redeemScript)...)
+	script, _ := txscript.NewScriptBuilder().AddOp(txscript.OP_HASH160).
+		AddData(scriptHash).AddOp(txscript.OP_IFLAG_EQUAL).Script()
+	return script
+}
@@ -0,0 +1,154 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Copyright (c) 2018 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package psbt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/common/serialization"
+	"github.com/AsimovNetwork/asimov/protos"
+)
+
+// maxPsbtValueLength is the size of the largest value we'll successfully
+// deserialize from the wire, which is bound by the size of the largest
+// transaction serialization.
+const maxPsbtValueLength = 4000000
+
+// maxPsbtKeyLength is the length of the largest key that we'll successfully
+// deserialize from the wire.  Anything more will return ErrInvalidKeydata.
+const maxPsbtKeyLength = 10000
+
+// unknown is a struct encapsulating a key-value pair for which the key type is
+// unknown by this package; these fields are allowed in both the 'Global' and
+// the 'Input' section of a PSBT.
+type unknown struct {
+	Key   []byte
+	Value []byte
+}
+
+// getKey retrieves a single key - both the key type and the keydata (if
+// present) from the stream and returns the key type as an integer, or -1 if
+// the key was of zero length.  This integer is is used to indicate the
+// presence of a separator byte which indicates the end of a given key-value
+// pair list, and the keydata as a byte slice or nil if none is present.
+func getKey(r io.Reader) (int, []byte, error) {
+	// For the key, we read the varint separately, instead of using the
+	// available ReadVarBytes, because we have a specific treatment of 0x00
+	// here.
+	count, err := serialization.ReadVarInt(r, 0)
+	if err != nil {
+		return -1, nil, ErrInvalidPsbtFormat
+	}
+	if count == 0 {
+		// A separator indicates end of key-value pair list.
+		return -1, nil, nil
+	}
+
+	// Check that we don't attempt to decode a dangerously large key.
+	if count > maxPsbtKeyLength {
+		return -1, nil, ErrInvalidKeydata
+	}
+
+	// Next, we ready out the designated number of bytes, which may include
+	// a type, key, and optional data.
+	keyTypeAndData := make([]byte, count)
+	if _, err := io.ReadFull(r, keyTypeAndData[:]); err != nil {
+		return -1, nil, err
+	}
+
+	keyType := int(keyTypeAndData[0])
+
+	// Note that the second return value will usually be empty, since most
+	// keys contain no more than the key type byte.
+	if len(keyTypeAndData) == 1 {
+		return keyType, nil, nil
+	}
+
+	// Otherwise, we return the key, along with any data that it may
+	// contain.
+	return keyType, keyTypeAndData[1:], nil
+}
+
+// readTxOut is a limited version of the deserialization of a transaction
+// output, reading the fields in the order they are serialized by
+// serializeTxOut.
+func readTxOut(txout []byte) (*protos.TxOut, error) {
+	if len(txout) < 8+1+common.AssetLength+1 {
+		return nil, ErrInvalidPsbtFormat
+	}
+	r := bytes.NewReader(txout)
+
+	var value uint64
+	if err := binary.Read(r, binary.LittleEndian, &value); err != nil {
+		return nil, ErrInvalidPsbtFormat
+	}
+	pkScript, err := serialization.ReadVarBytes(r, 0, maxPsbtValueLength,
+		"public key script")
+	if err != nil {
+		return nil, ErrInvalidPsbtFormat
+	}
+	asset := make([]byte, common.AssetLength)
+	if _, err := io.ReadFull(r, asset); err != nil {
+		return nil, ErrInvalidPsbtFormat
+	}
+	data, err := serialization.ReadVarBytes(r, 0, maxPsbtValueLength,
+		"output data")
+	if err != nil {
+		return nil, ErrInvalidPsbtFormat
+	}
+	if r.Len() != 0 {
+		return nil, ErrInvalidPsbtFormat
+	}
+
+	return protos.NewContractTxOut(int64(value), pkScript,
+		*protos.AssetFromBytes(asset), data), nil
+}
+
+// serializeTxOut serializes the amount, public key script, asset and data of
+// a transaction output.
+func serializeTxOut(txout *protos.TxOut) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.LittleEndian, uint64(txout.Value)); err != nil {
+		return nil, err
+	}
+	if err := serialization.WriteVarBytes(&buf, 0, txout.PkScript); err != nil {
+		return nil, err
+	}
+	buf.Write(txout.Asset.Bytes())
+	if err := serialization.WriteVarBytes(&buf, 0, txout.Data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// serializeKVPair writes out a kv pair using a varbyte prefix for each key
+// and value.
+func serializeKVPair(w io.Writer, key []byte, value []byte) error {
+	err := serialization.WriteVarBytes(w, 0, key)
+	if err != nil {
+		return err
+	}
+
+	return serialization.WriteVarBytes(w, 0, value)
+}
+
+// serializeKVPairWithType writes out to the passed writer a type coupled with
+// a key.
+func serializeKVPairWithType(w io.Writer, kt uint8, keydata []byte,
+	value []byte) error {
+
+	// If the key has no data, then we write a blank slice.
+	if keydata == nil {
+		keydata = []byte{}
+	}
+
+	// The final key to be written is: {type} || {keyData}
+	serializedKey := append([]byte{kt}, keydata...)
+	return serializeKVPair(w, serializedKey, value)
+}
@@ -58,6 +58,23 @@ func (b *BlockChain) maybeAcceptBlock(block *asiutil.Block, vblock *asiutil.VBlo
 		}
 	}
 
+	// The blocks of a bft chain must be committed by the validators.  The
+	// blocks which are not executed are committed to by a checkpoint.
+	if b.chainParams.BFT && flags&common.BFNoExecute != common.BFNoExecute {
+		err = b.checkCommit(block, prevNode)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	// The other chains have no commit certificate, and the certificate is
+	// not covered by the block hash, so a block must not carry one.
+	if !b.chainParams.BFT && len(block.MsgBlock().Commit) > 0 {
+		str := fmt.Sprintf("block %v carries a commit certificate on a chain "+
+			"which does not use the bft consensus", block.Hash())
+		return false, ruleError(ErrBadCommit, str)
+	}
+
 	// Insert the block into the database if it's not already there.  Even
 	// though it is possible the block will ultimately fail to connect, it
 	// has already passed all proof-of-work and validity tests which means
@@ -64,7 +64,7 @@ func TestCalculateBalance(t *testing.T) {
 			if err != nil {
 				return err
 			}
-			err = dbPutUtxoView(dbTx, view)
+			err = dbPutUtxoView(dbTx, view, nil)
 			if err != nil {
 				return err
 			}
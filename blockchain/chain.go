@@ -15,6 +15,7 @@ import (
 	"github.com/AsimovNetwork/asimov/cache"
 	"github.com/AsimovNetwork/asimov/chaincfg"
 	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/crypto/muhash"
 	"github.com/AsimovNetwork/asimov/database"
 	"github.com/AsimovNetwork/asimov/protos"
 	"github.com/AsimovNetwork/asimov/rpcs/rawdb"
@@ -45,10 +46,6 @@ const (
 
 )
 
-var (
-	errExecutionRevertedString     = "fvm: execution reverted"
-)
-
 // BlockLocator is used to help locate a specific block.  The algorithm for
 // building the block locator is to add the hashes in reverse order until
 // the genesis block is reached.  In order to keep the list of locator hashes
@@ -177,6 +174,10 @@ type BlockChain struct {
 	stateLock     sync.RWMutex
 	stateSnapshot *BestState
 
+	// utxoHash is the rolling hash of the utxo set as of the best block.
+	// It is protected by the chain lock.
+	utxoHash *muhash.MuHash
+
 	// The following caches are used to efficiently keep track of the
 	// current deployment threshold state of each rule change deployment.
 	//
@@ -210,6 +211,15 @@ type BlockChain struct {
 	notificationsLock sync.RWMutex
 	notifications     []NotificationCallback
 	stateCache        state.Database // State database to reuse between imports (contains state cache)
+	stateGc           *stateGc       // Tracks the retained states, nil in archive gc mode
+	snapshotHeight    int32          // Height of the snapshot the chain was bootstrapped from
+
+	// finalized is the latest block of the main chain signed by more than
+	// two thirds of the weight of its round's validators.  It and its
+	// ancestors can not be detached by a reorganization.  blockSigners
+	// tracks the signers of the recent blocks above it.
+	finalized    *blockNode
+	blockSigners map[common.Hash]*blockSigners
 
 	// rpcv2.0
 	ethDB         database.Database
@@ -624,6 +634,14 @@ func (b *BlockChain) connectBlock(node *blockNode, block *asiutil.Block,
 	blockSize := uint64(block.MsgBlock().SerializeSize())
 	state := newBestState(node, blockSize, numTxns,
 		curTotalTxns+numTxns, block.MsgBlock().Header.Timestamp)
+	utxoHash := b.utxoHash.Clone()
+
+	// Store the contract state of the block, which was computed when the
+	// block was checked or produced.  The blocks which were not executed
+	// have no state to store.
+	if err := b.commitState(node, node.stateRoot); err != nil {
+		return err
+	}
 
 	// save receipts
 	if receipts != nil {
@@ -632,25 +650,37 @@ func (b *BlockChain) connectBlock(node *blockNode, block *asiutil.Block,
 	}
 	b.PostChainEvents(nil, allLogs)
 
+	// Gather the signatures of the block, the block they finalize is stored
+	// along with the block.
+	finalized := b.updateFinality(node, block)
+
 	// Atomically insert info into the database.
 	err = b.db.Update(func(dbTx database.Tx) error {
-		// Update best block state.
-		err := dbPutBestState(dbTx, state)
-		if err != nil {
-			return err
-		}
-
 		// Add the block hash and height to the block index which tracks
 		// the main chain.
-		err = dbPutBlockIndex(dbTx, block.Hash(), node.height)
+		err := dbPutBlockIndex(dbTx, block.Hash(), node.height)
 		if err != nil {
 			return err
 		}
 
+		if finalized != nil {
+			err = dbPutFinalizedBlock(dbTx, finalized)
+			if err != nil {
+				return err
+			}
+		}
+
 		// Update the utxo set using the state of the utxo view.  This
 		// entails removing all of the utxos spent and adding the new
 		// ones created by the block.
-		err = dbPutUtxoView(dbTx, view)
+		err = dbPutUtxoView(dbTx, view, utxoHash)
+		if err != nil {
+			return err
+		}
+
+		// Update best block state, along with the hash of the updated
+		// utxo set.
+		err = dbPutBestState(dbTx, state, utxoHash)
 		if err != nil {
 			return err
 		}
@@ -696,6 +726,7 @@ func (b *BlockChain) connectBlock(node *blockNode, block *asiutil.Block,
 		return nil
 	})
 	if err != nil {
+		b.revertFinality(node, block)
 		return err
 	}
 
@@ -705,10 +736,12 @@ func (b *BlockChain) connectBlock(node *blockNode, block *asiutil.Block,
 	// Prune fully spent entries and mark all entries in the view unmodified
 	// now that the modifications have been committed to the database.
 	view.Commit()
+	b.utxoHash = utxoHash
 
 	// This node is now the end of the best chain.
 	b.bestChain.SetTip(node)
 	b.BestSnapshot()
+	b.setFinalized(finalized, node)
 
 	// Update the state for the best block.  Notice how this replaces the
 	// entire struct instead of updating the existing one.  This effectively
@@ -725,6 +758,7 @@ func (b *BlockChain) connectBlock(node *blockNode, block *asiutil.Block,
 	b.chainLock.Unlock()
 	data := []interface{}{block, vblock, node}
 	b.sendNotification(NTBlockConnected, data)
+	b.PostChainEvents([]interface{}{ChainHeadEvent{Block: block}}, nil)
 	b.updateFees(block)
 	b.chainLock.Lock()
 
@@ -773,17 +807,12 @@ func (b *BlockChain) disconnectBlock(node *blockNode, block *asiutil.Block, view
 	newTotalTxns := curTotalTxns - uint64(len(block.MsgBlock().Transactions))
 	state := newBestState(prevNode, blockSize, numTxns,
 		newTotalTxns, prevNode.GetTime())
+	utxoHash := b.utxoHash.Clone()
 
 	err = b.db.Update(func(dbTx database.Tx) error {
-		// Update best block state.
-		err := dbPutBestState(dbTx, state)
-		if err != nil {
-			return err
-		}
-
 		// Remove the block hash and height from the block index which
 		// tracks the main chain.
-		err = dbRemoveBlockIndex(dbTx, block.Hash(), node.height)
+		err := dbRemoveBlockIndex(dbTx, block.Hash(), node.height)
 		if err != nil {
 			return err
 		}
@@ -791,7 +820,14 @@ func (b *BlockChain) disconnectBlock(node *blockNode, block *asiutil.Block, view
 		// Update the utxo set using the state of the utxo view.  This
 		// entails restoring all of the utxos spent and removing the new
 		// ones created by the block.
-		err = dbPutUtxoView(dbTx, view)
+		err = dbPutUtxoView(dbTx, view, utxoHash)
+		if err != nil {
+			return err
+		}
+
+		// Update best block state, along with the hash of the updated
+		// utxo set.
+		err = dbPutBestState(dbTx, state, utxoHash)
 		if err != nil {
 			return err
 		}
@@ -846,9 +882,11 @@ func (b *BlockChain) disconnectBlock(node *blockNode, block *asiutil.Block, view
 	// Prune fully spent entries and mark all entries in the view unmodified
 	// now that the modifications have been committed to the database.
 	view.Commit()
+	b.utxoHash = utxoHash
 
 	// This node's parent is now the end of the best chain.
 	b.bestChain.SetTip(node.parent)
+	b.revertFinality(node, block)
 
 	// Update the state for the best block.  Notice how this replaces the
 	// entire struct instead of updating the existing one.  This effectively
@@ -864,6 +902,9 @@ func (b *BlockChain) disconnectBlock(node *blockNode, block *asiutil.Block, view
 	// updating wallets.
 	b.chainLock.Unlock()
 	b.sendNotification(NTBlockDisconnected, []interface{}{block, vblock, node.parent})
+	if removedLogs := b.fetchRemovedLogs(block); len(removedLogs) > 0 {
+		b.PostChainEvents([]interface{}{core.RemovedLogsEvent{Logs: removedLogs}}, nil)
+	}
 	b.chainLock.Lock()
 
 	return nil
@@ -939,6 +980,19 @@ func (b *BlockChain) reorganizeChain(detachNodes, attachNodes *list.List) error
 		}
 	}
 
+	// Ensure no finalized block is detached.
+	if detachNodes.Len() != 0 && b.finalized != nil {
+		lastDetachNode := detachNodes.Back().Value.(*blockNode)
+		if lastDetachNode.height <= b.finalized.height {
+			errStr := fmt.Sprintf("reorganize nodes to detach include "+
+				"the finalized block %v at height %d -- last detach "+
+				"node %v at height %d", &b.finalized.hash,
+				b.finalized.height, &lastDetachNode.hash,
+				lastDetachNode.height)
+			return ruleError(ErrFinalizedBlock, errStr)
+		}
+	}
+
 	// Ensure the provided nodes are for the same fork point.
 	if attachNodes.Len() != 0 && detachNodes.Len() != 0 {
 		firstAttachNode := attachNodes.Front().Value.(*blockNode)
@@ -1193,6 +1247,13 @@ func (b *BlockChain) sendFee(fees map[protos.Asset]int32) {
 
 func (b *BlockChain) updateFees(block *asiutil.Block) {
 	stateDB, err := state.New(block.MsgBlock().Header.StateRoot, b.stateCache)
+	if err != nil {
+		// The state of the blocks connected without executing them is
+		// not available yet.
+		log.Debugf("updateFees: state of block %v is not available: %v",
+			block.Hash(), err)
+		return
+	}
 	fees, err := b.contractManager.GetFees(block,
 		stateDB, chaincfg.ActiveNetParams.FvmParam)
 	if err != nil {
@@ -1220,6 +1281,9 @@ func (b *BlockChain) updateFees(block *asiutil.Block) {
 // The flags modify the behavior of this function as follows:
 //  - BFFastAdd: Avoids several expensive transaction validation operations.
 //    This is useful when using checkpoints.
+//  - BFNoExecute: Connects the passed virtual block instead of executing the
+//    contracts of the block.  This is used for the blocks up to the pivot of
+//    a state sync, which is the latest checkpoint.
 //
 // This function MUST be called with the chain state lock held (for writes).
 func (b *BlockChain) connectBestChain(node *blockNode, block *asiutil.Block, vblock *asiutil.VBlock,
@@ -1243,6 +1307,16 @@ func (b *BlockChain) connectBestChain(node *blockNode, block *asiutil.Block, vbl
 		// Skip checks if node has already been fully validated.
 		fastAdd = (fastAdd || b.index.NodeStatus(node).KnownValid()) && vblock != nil
 
+		// The blocks which are not executed must come with their virtual
+		// block, and are connected the same way as in the fast add case.
+		if flags&common.BFNoExecute == common.BFNoExecute {
+			if vblock == nil {
+				return false, common.AssertError("connectBestChain called " +
+					"without the virtual block of a block which is not executed")
+			}
+			fastAdd = true
+		}
+
 		pSlot := b.bestChain.Tip().slot
 		pRound := b.bestChain.Tip().round.Round
 		curHeader := block.MsgBlock().Header
@@ -1376,6 +1450,14 @@ func (b *BlockChain) connectBestChain(node *blockNode, block *asiutil.Block, vbl
 func (b *BlockChain) ConnectTransaction(block *asiutil.Block, txidx int, view *txo.UtxoViewpoint, tx *asiutil.Tx,
 	stxos *[]txo.SpentTxOut, stateDB *state.StateDB, fee int64) (
 	receipt *types.Receipt, err error, gasUsed uint64, vtx *protos.MsgTx, feeLockItems map[protos.Asset]*txo.LockItem) {
+	return b.connectTransaction(block, txidx, view, tx, stxos, stateDB, fee, b.GetVmConfig())
+}
+
+// connectTransaction is the implementation of ConnectTransaction which runs
+// the contract executions of the transaction with the passed vm config.
+func (b *BlockChain) connectTransaction(block *asiutil.Block, txidx int, view *txo.UtxoViewpoint, tx *asiutil.Tx,
+	stxos *[]txo.SpentTxOut, stateDB *state.StateDB, fee int64, vmConfig *vm.Config) (
+	receipt *types.Receipt, err error, gasUsed uint64, vtx *protos.MsgTx, feeLockItems map[protos.Asset]*txo.LockItem) {
 
 	scriptClass := txscript.NonStandardTy
 	txbaseGas := uint64(tx.MsgTx().SerializeSize() * common.GasPerByte)
@@ -1387,6 +1469,13 @@ func (b *BlockChain) ConnectTransaction(block *asiutil.Block, txidx int, view *t
 	contractAddr := common.Address{}
 	var callerAddr common.Address
 	var vmtx *virtualtx.VirtualTransaction
+	var revertReason []byte
+
+	// The upgraded system contracts must be deployed before any of the
+	// transactions of the block calls them.
+	if err = b.deployContractUpgrades(block, stateDB); err != nil {
+		return
+	}
 
 	defer func() {
 		view.AddViewTx(tx.Hash(), tx.MsgTx())
@@ -1423,6 +1512,9 @@ func (b *BlockChain) ConnectTransaction(block *asiutil.Block, txidx int, view *t
 			receipt.Logs = stateDB.GetLogs(*tx.Hash())
 			receipt.Bloom = types.CreateBloom(types.Receipts{receipt})
 			receipt.GasUsed = gasUsed
+			if executeVMFailed {
+				receipt.RevertReason = revertReason
+			}
 		}
 		if executeVMFailed {
 			vtx, err = b.connectContractRollback(view, block.Height(), stxos, txidx, tx)
@@ -1432,7 +1524,7 @@ func (b *BlockChain) ConnectTransaction(block *asiutil.Block, txidx int, view *t
 		}
 	}()
 	if coinbase {
-		vmtx, err, snapshot = b.connectCoinbaseTX(block, view, tx, stxos, stateDB, fee)
+		vmtx, err, snapshot = b.connectCoinbaseTX(block, view, tx, stxos, stateDB, fee, vmConfig)
 		leftOverGas = 0
 		feeLockItems = view.AddTxOuts(tx.Hash(), tx.MsgTx(), true, block.Height())
 		return
@@ -1516,7 +1608,7 @@ func (b *BlockChain) ConnectTransaction(block *asiutil.Block, txidx int, view *t
 		contractAddr = addrs[0].StandardAddress()
 	}
 
-	vmtx, err, leftOverGas, contractAddr, snapshot = b.connectContract(block, view, stateDB, callerAddr, contractAddr, txOut, stxos, tx, scriptClass, leftOverGas, nil, fee)
+	vmtx, err, leftOverGas, contractAddr, snapshot, revertReason = b.connectContract(block, view, stateDB, callerAddr, contractAddr, txOut, stxos, tx, scriptClass, leftOverGas, nil, fee, vmConfig)
 
 	if err != nil {
 		log.Info("handle vm excute error", err)
@@ -1595,7 +1687,8 @@ func (b *BlockChain) connectCoinbaseTX(block *asiutil.Block,
 	view *txo.UtxoViewpoint,
 	tx *asiutil.Tx, stxos *[]txo.SpentTxOut,
 	db *state.StateDB,
-	fee int64) (vtx *virtualtx.VirtualTransaction, err error, snapshot int) {
+	fee int64,
+	vmConfig *vm.Config) (vtx *virtualtx.VirtualTransaction, err error, snapshot int) {
 
 	gas := uint64(math.MaxUint64)
 	poaAddr := chaincfg.OfficialAddress
@@ -1612,8 +1705,8 @@ func (b *BlockChain) connectCoinbaseTX(block *asiutil.Block,
 			return
 		}
 		if scriptClass == txscript.CallTy && len(txOut.Data) > 0 {
-			vtx, err, _, _, tempsnapshot = b.connectContract(block, view, db, poaAddr, addrs[0].StandardAddress(), txOut,
-				stxos, tx, scriptClass, gas, vtx, fee)
+			vtx, err, _, _, tempsnapshot, _ = b.connectContract(block, view, db, poaAddr, addrs[0].StandardAddress(), txOut,
+				stxos, tx, scriptClass, gas, vtx, fee, vmConfig)
 			// coinbase tx is not allowed be failed
 			if err != nil {
 				str := fmt.Sprintf("coinbase tx call contract failed %v", err)
@@ -1684,7 +1777,8 @@ func (b *BlockChain) connectVTX(view *txo.UtxoViewpoint, vtx *asiutil.Tx, height
 	return nil
 }
 
-// execute contract in the tx.
+// execute contract in the tx.  When the execution is reverted the revert
+// payload returned by the contract is returned along with the error.
 func (b *BlockChain) connectContract(
 	block *asiutil.Block,
 	view *txo.UtxoViewpoint,
@@ -1696,8 +1790,10 @@ func (b *BlockChain) connectContract(
 	contractCode txscript.ScriptClass,
 	gas uint64,
 	vtx *virtualtx.VirtualTransaction,
-	fee int64) (
-	vtxr *virtualtx.VirtualTransaction, err error, leftOverGas uint64, newContractAddr common.Address, snapshot int) {
+	fee int64,
+	vmConfig *vm.Config) (
+	vtxr *virtualtx.VirtualTransaction, err error, leftOverGas uint64, newContractAddr common.Address, snapshot int,
+	revertReason []byte) {
 
 	log.Debug("connectContract enter", contractCode)
 	defer func() {
@@ -1720,7 +1816,7 @@ func (b *BlockChain) connectContract(
 	gasPrice := new(big.Int).Mul(big.NewInt(fee), big.NewInt(10000))
 	gasPrice = new(big.Int).Div(gasPrice, big.NewInt(int64(tx.MsgTx().TxContract.GasLimit)))
 	context := fvm.NewFVMContext(caller, gasPrice, block, b, view, voteValue)
-	vmenv := vm.NewFVMWithVtx(context, stateDB, chaincfg.ActiveNetParams.FvmParam, *b.GetVmConfig(), vtx)
+	vmenv := vm.NewFVMWithVtx(context, stateDB, chaincfg.ActiveNetParams.FvmParam, *vmConfig, vtx)
 	var ret []byte
 	switch contractCode {
 	case txscript.VoteTy:
@@ -1728,8 +1824,9 @@ func (b *BlockChain) connectContract(
 	case txscript.CallTy:
 		ret,leftOverGas, snapshot, err = b.executeContract(vmenv, caller, targetContractAddr, out.Data, &out.Asset, out.Value, leftOverGas)
 		if err != nil {
-			if err.Error() == errExecutionRevertedString{
+			if err == vm.ErrExecutionReverted {
 				cache.PutExecuteError(tx.Hash().String(),common.Bytes2Hex(ret))
+				revertReason = ret
 			}
 			return
 		}
@@ -1740,7 +1837,7 @@ func (b *BlockChain) connectContract(
 			return
 		}
 	case txscript.CreateTy:
-		newContractAddr, leftOverGas, snapshot, err = b.createContract(vmenv, caller, view, block, stateDB, tx, out, leftOverGas)
+		newContractAddr, leftOverGas, snapshot, revertReason, err = b.createContract(vmenv, caller, view, block, stateDB, tx, out, leftOverGas)
 		if err != nil {
 			return
 		}
@@ -1788,7 +1885,8 @@ func (b *BlockChain) executeContract(
 	return
 }
 
-// call vm to create a contract.
+// call vm to create a contract.  When the constructor reverts the revert
+// payload is returned along with the error.
 func (b *BlockChain) createContract(
 	vmenv *vm.FVM,
 	callerAddr common.Address,
@@ -1797,20 +1895,20 @@ func (b *BlockChain) createContract(
 	stateDB *state.StateDB,
 	tx *asiutil.Tx,
 	out *protos.TxOut,
-	gas uint64) (newAddr common.Address, leftOverGas uint64, snapshot int, err error) {
+	gas uint64) (newAddr common.Address, leftOverGas uint64, snapshot int, revertReason []byte, err error) {
 
 	leftOverGas = gas
 
 	category, templateName, constructor, ok := DecodeCreateContractData(out.Data)
 	if !ok {
 		errStr := fmt.Sprintf("create contract, incorrect data protocol")
-		return common.Address{}, leftOverGas, -1, ruleError(ErrBadContractData, errStr)
+		return common.Address{}, leftOverGas, -1, nil, ruleError(ErrBadContractData, errStr)
 	}
 	byteCode, ok, leftOverGas := b.GetByteCode(view, block, leftOverGas,
 		stateDB, chaincfg.ActiveNetParams.FvmParam, category, templateName)
 	if !ok {
 		errStr := fmt.Sprintf("create contract, get byte code from template error")
-		return common.Address{}, leftOverGas, -1, ruleError(ErrBadContractByteCode, errStr)
+		return common.Address{}, leftOverGas, -1, nil, ruleError(ErrBadContractByteCode, errStr)
 	}
 
 	var inputHash []byte = nil
@@ -1820,8 +1918,11 @@ func (b *BlockChain) createContract(
 
 	t1 := time.Now()
 	// append(byteCode, constructor...) concat in Create
-	_, newAddr, leftOverGas, snapshot, err = vmenv.Create(vm.AccountRef(callerAddr), byteCode, leftOverGas, big.NewInt(out.Value), &out.Asset, inputHash, constructor, false)
+	ret, newAddr, leftOverGas, snapshot, err := vmenv.Create(vm.AccountRef(callerAddr), byteCode, leftOverGas, big.NewInt(out.Value), &out.Asset, inputHash, constructor, false)
 	if err != nil {
+		if err == vm.ErrExecutionReverted {
+			revertReason = ret
+		}
 		errStr := fmt.Sprint("create contract error ", err)
 		err = ruleError(ErrFailedCreateContract, errStr)
 		return
@@ -1832,7 +1933,7 @@ func (b *BlockChain) createContract(
 	if err != nil {
 		errStr := fmt.Sprint("init template error ", category, templateName, newAddr, err)
 		err = ruleError(ErrFailedInitTemplate, errStr)
-		return common.Address{}, leftOverGas, snapshot, err
+		return common.Address{}, leftOverGas, snapshot, nil, err
 	}
 
 	t2 := time.Now()
@@ -2649,6 +2750,17 @@ func (b *BlockChain) GetVmConfig() *vm.Config {
 	return &b.vmConfig
 }
 
+// ChainHeadEvent is posted when a block has been connected to the end of the
+// main chain.
+type ChainHeadEvent struct {
+	Block *asiutil.Block
+}
+
+// SubscribeChainHeadEvent registers a subscription of ChainHeadEvent.
+func (b *BlockChain) SubscribeChainHeadEvent(ch chan<- ChainHeadEvent) event.Subscription {
+	return b.scope.Track(b.chainHeadFeed.Subscribe(ch))
+}
+
 // SubscribeLogsEvent registers a subscription of []*types.Log.
 func (b *BlockChain) SubscribeLogsEvent(ch chan<- []*types.Log) event.Subscription {
 	return b.scope.Track(b.logsFeed.Subscribe(ch))
@@ -2672,18 +2784,31 @@ func (b *BlockChain) PostChainEvents(events []interface{}, logs []*types.Log) {
 		fmt.Println(logs)
 		b.logsFeed.Send(logs)
 	}
-	// for _, event := range events {
-	// 	switch ev := event.(type) {
-	// 	case ChainEvent:
-	// 		bc.chainFeed.Send(ev)
-	//
-	// 	case ChainHeadEvent:
-	// 		bc.chainHeadFeed.Send(ev)
-	//
-	// 	case ChainSideEvent:
-	// 		bc.chainSideFeed.Send(ev)
-	// 	}
-	// }
+	for _, event := range events {
+		switch ev := event.(type) {
+		case ChainHeadEvent:
+			b.chainHeadFeed.Send(ev)
+
+		case core.RemovedLogsEvent:
+			b.rmLogsFeed.Send(ev)
+		}
+	}
+}
+
+// fetchRemovedLogs loads the logs generated by the passed block from its
+// stored receipts and marks them as removed.  It is used to notify log
+// subscribers when the block is disconnected from the main chain.
+func (b *BlockChain) fetchRemovedLogs(block *asiutil.Block) []*types.Log {
+	receipts := rawdb.ReadReceipts(b.ethDB, *block.Hash(), uint64(block.Height()))
+	var logs []*types.Log
+	for _, receipt := range receipts {
+		for _, l := range receipt.Logs {
+			removed := *l
+			removed.Removed = true
+			logs = append(logs, &removed)
+		}
+	}
+	return logs
 }
 
 // FetchTemplate return decoded values for template data.
@@ -2702,6 +2827,16 @@ func (b *BlockChain) FetchTemplate(view *txo.UtxoViewpoint, hash *common.Hash) (
 	}
 	blockRegion, err := b.templateIndex.FetchBlockRegion(hash[:])
 	if err != nil {
+		// The templates created before the snapshot the chain was
+		// bootstrapped from are kept apart.
+		var data []byte
+		b.db.View(func(dbTx database.Tx) error {
+			data = dbFetchSnapshotTemplate(dbTx, hash)
+			return nil
+		})
+		if data != nil {
+			return DecodeTemplateContractData(data)
+		}
 		return 0, nil, nil, nil, nil, ruleError(ErrInvalidTemplate, "no template in db "+err.Error())
 	}
 
@@ -2726,14 +2861,9 @@ func (b *BlockChain) FetchTemplate(view *txo.UtxoViewpoint, hash *common.Hash) (
 //         mapping             new candidates for validators
 func (b *BlockChain) CountRoundMinerInfo(round uint32, thisnode *blockNode) (sortedValidatorList common.AddressList,
 	expectedBlocks []uint16, actualBlocks []uint16, mapping map[string]*ainterface.ValidatorInfo, err error) {
-	var preroundLastNode *blockNode
-	for node := thisnode; node != nil; node = node.parent {
-		if node.round.Round < round {
-			preroundLastNode = node
-			break
-		}
-	}
-	_, weightMap, err := b.GetValidatorsByNode(round, preroundLastNode)
+	// The validators of the round are read from the state the consensus
+	// reads them from, ValidatorActivationDelay rounds earlier on POA.
+	_, weightMap, err := b.GetValidatorsByNode(round, b.validatorsNodeFrom(thisnode, round))
 	if err != nil {
 		return nil, nil, nil, nil, err
 	}
@@ -2864,10 +2994,14 @@ func New(config *Config, fconfig *chaincfg.FConfig) (*BlockChain, error) {
 	}
 
 	vmConfig := &vm.Config{}
+	var gc *stateGc
 
 	if fconfig != nil {
 		vmConfig.EWASMInterpreter = fconfig.EwasmOptions
 		vmConfig.FVMInterpreter = fconfig.EvmOptions
+		if fconfig.GcMode == chaincfg.GcModeFull {
+			gc = newStateGc(fconfig.StateRetention)
+		}
 	}
 
 	params := config.ChainParams
@@ -2885,6 +3019,7 @@ func New(config *Config, fconfig *chaincfg.FConfig) (*BlockChain, error) {
 		warningCaches:       newThresholdCaches(vbNumBits),
 		deploymentCaches:    newThresholdCaches(chaincfg.DefinedDeployments),
 		stateCache:          state.NewDatabase(config.StateDB),
+		stateGc:             gc,
 		ethDB:               config.StateDB,
 		templateIndex:       config.TemplateIndex,
 		roundManager:        config.RoundManager,
@@ -2905,6 +3040,14 @@ func New(config *Config, fconfig *chaincfg.FConfig) (*BlockChain, error) {
 		return nil, err
 	}
 
+	// The state of the best chain is lost when the node was not shut down
+	// cleanly while only the states of the recent blocks are kept.
+	if b.stateGc != nil {
+		if err := b.regenerateState(b.bestChain.Tip()); err != nil {
+			return nil, err
+		}
+	}
+
 	// Initialize and catch up all of the currently active optional indexes
 	// as needed.
 	if config.IndexManager != nil {
@@ -2922,6 +3065,12 @@ func New(config *Config, fconfig *chaincfg.FConfig) (*BlockChain, error) {
 		return nil, err
 	}
 
+	// Load the finalized block, which needs the validators of the recent
+	// rounds.
+	if err := b.initFinality(); err != nil {
+		return nil, err
+	}
+
 	log.Infof("Chain state (height %d, hash %v, totaltx %d)",
 		bestNode.height, bestNode.hash, b.stateSnapshot.TotalTxns)
 
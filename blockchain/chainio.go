@@ -13,6 +13,7 @@ import (
 	"github.com/AsimovNetwork/asimov/blockchain/txo"
 	"github.com/AsimovNetwork/asimov/chaincfg"
 	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/crypto/muhash"
 	"github.com/AsimovNetwork/asimov/database"
 	"github.com/AsimovNetwork/asimov/protos"
 	"github.com/AsimovNetwork/asimov/txscript"
@@ -500,9 +501,9 @@ func utxoEntryHeaderCode(entry *txo.UtxoEntry) (uint64, error) {
 	return headerCode, nil
 }
 
-// serializeUtxoEntry returns the entry serialized to a format that is suitable
+// SerializeUtxoEntry returns the entry serialized to a format that is suitable
 // for long-term storage.  The format is described in detail above.
-func serializeUtxoEntry(entry *txo.UtxoEntry) ([]byte, error) {
+func SerializeUtxoEntry(entry *txo.UtxoEntry) ([]byte, error) {
 	// Spent outputs have no serialization.
 	if entry.IsSpent() {
 		return nil, nil
@@ -608,8 +609,9 @@ func dbFetchUtxoEntry(dbTx database.Tx, outpoint protos.OutPoint) (*txo.UtxoEntr
 // dbPutUtxoView uses an existing database transaction to update the utxo set
 // in the database based on the provided utxo view contents and state.  In
 // particular, only the entries that have been marked as modified are written
-// to the database.
-func dbPutUtxoView(dbTx database.Tx, view *txo.UtxoViewpoint) error {
+// to the database.  The passed rolling hash of the utxo set is updated along,
+// unless it is nil.
+func dbPutUtxoView(dbTx database.Tx, view *txo.UtxoViewpoint, utxoHash *muhash.MuHash) error {
 	utxoBucket := dbTx.Metadata().Bucket(utxoSetBucketName)
 	for outpoint, entry := range view.Entries() {
 		// No need to update the database if the entry was not modified.
@@ -617,6 +619,15 @@ func dbPutUtxoView(dbTx database.Tx, view *txo.UtxoViewpoint) error {
 			continue
 		}
 
+		// Remove the replaced entry from the utxo hash.
+		if utxoHash != nil {
+			key := outpointKey(outpoint)
+			if old := utxoBucket.Get(*key); old != nil {
+				utxoHash.Remove(utxoHashElement(*key, old))
+			}
+			recycleOutpointKey(key)
+		}
+
 		// Remove the utxo entry if it is spent.
 		if entry.IsSpent() {
 			key := outpointKey(outpoint)
@@ -630,7 +641,7 @@ func dbPutUtxoView(dbTx database.Tx, view *txo.UtxoViewpoint) error {
 		}
 
 		// Serialize and store the utxo entry.
-		serialized, err := serializeUtxoEntry(entry)
+		serialized, err := SerializeUtxoEntry(entry)
 		if err != nil {
 			return err
 		}
@@ -643,6 +654,9 @@ func dbPutUtxoView(dbTx database.Tx, view *txo.UtxoViewpoint) error {
 		if err != nil {
 			return err
 		}
+		if utxoHash != nil {
+			utxoHash.Add(utxoHashElement(*key, serialized))
+		}
 	}
 
 	return nil
@@ -769,7 +783,7 @@ func dbPutBalance(dbTx database.Tx, view *txo.UtxoViewpoint) error {
 			continue
 		}
 		// Serialize and store the utxo entry.
-		serialized, err := serializeUtxoEntry(entry)
+		serialized, err := SerializeUtxoEntry(entry)
 		if err != nil {
 			return err
 		}
@@ -1008,12 +1022,16 @@ func dbFetchHeightByHash(dbTx database.Tx, hash *common.Hash) (int32, error) {
 //
 // The serialized format is:
 //
-//   <block hash><block height><total txns><work sum length><work sum>
+//   <block hash><block height><total txns>[<utxo hash>]
 //
 //   Field             Type             Size
 //   block hash        common.Hash   common.HashLength
 //   block height      uint32           4 bytes
 //   total txns        uint64           8 bytes
+//   utxo hash         muhash.MuHash    muhash.SerializedSize
+//
+// The utxo hash is the rolling hash of the utxo set, see utxoHashElement.  It
+// is missing from the chain states written by older versions.
 // -----------------------------------------------------------------------------
 
 // bestChainState represents the data to be stored the database for the current
@@ -1022,13 +1040,14 @@ type bestChainState struct {
 	hash      common.Hash
 	height    uint32
 	totalTxns uint64
+	utxoHash  []byte
 }
 
 // serializeBestChainState returns the serialization of the passed block best
 // chain state.  This is data to be stored in the chain state bucket.
 func serializeBestChainState(state bestChainState) []byte {
 	// Calculate the full size needed to serialize the chain state.
-	serializedLen := common.HashLength + 4 + 8
+	serializedLen := common.HashLength + 4 + 8 + len(state.utxoHash)
 
 	// Serialize the chain state.
 	serializedData := make([]byte, serializedLen)
@@ -1037,6 +1056,8 @@ func serializeBestChainState(state bestChainState) []byte {
 	byteOrder.PutUint32(serializedData[offset:], state.height)
 	offset += 4
 	byteOrder.PutUint64(serializedData[offset:], state.totalTxns)
+	offset += 8
+	copy(serializedData[offset:], state.utxoHash)
 	return serializedData[:]
 }
 
@@ -1060,17 +1081,22 @@ func deserializeBestChainState(serializedData []byte) (bestChainState, error) {
 	state.height = byteOrder.Uint32(serializedData[offset : offset+4])
 	offset += 4
 	state.totalTxns = byteOrder.Uint64(serializedData[offset : offset+8])
+	offset += 8
+	if len(serializedData) >= int(offset)+muhash.SerializedSize {
+		state.utxoHash = common.CopyBytes(serializedData[offset : offset+muhash.SerializedSize])
+	}
 	return state, nil
 }
 
 // dbPutBestState uses an existing database transaction to update the best chain
 // state with the given parameters.
-func dbPutBestState(dbTx database.Tx, snapshot *BestState) error {
+func dbPutBestState(dbTx database.Tx, snapshot *BestState, utxoHash *muhash.MuHash) error {
 	// Serialize the current best chain state.
 	serializedData := serializeBestChainState(bestChainState{
 		hash:      snapshot.Hash,
 		height:    uint32(snapshot.Height),
 		totalTxns: snapshot.TotalTxns,
+		utxoHash:  utxoHash.Serialize(),
 	})
 
 	// Store the current best chain state into the database.
@@ -1154,6 +1180,7 @@ func (b *BlockChain) createChainState(chainStartTime int64) error {
 	for _, tx := range genesisBlock.Transactions()[:] {
 		view.AddTxOuts(tx.Hash(), tx.MsgTx(), false, genesisBlock.Height())
 	}
+	utxoHash := muhash.New()
 
 	// Create the initial the database chain state including creating the
 	// necessary index buckets and inserting the genesis block.
@@ -1223,18 +1250,18 @@ func (b *BlockChain) createChainState(chainStartTime int64) error {
 			return err
 		}
 
-		// Store the current best chain state into the database.
-		err = dbPutBestState(dbTx, b.stateSnapshot)
+		err = dbPutBalance(dbTx, view)
 		if err != nil {
 			return err
 		}
 
-		err = dbPutBalance(dbTx, view)
+		err = dbPutUtxoView(dbTx, view, utxoHash)
 		if err != nil {
 			return err
 		}
 
-		err = dbPutUtxoView(dbTx, view)
+		// Store the current best chain state into the database.
+		err = dbPutBestState(dbTx, b.stateSnapshot, utxoHash)
 		if err != nil {
 			return err
 		}
@@ -1242,7 +1269,11 @@ func (b *BlockChain) createChainState(chainStartTime int64) error {
 		// Store the genesis block into the database.
 		return dbStoreBlock(dbTx, genesisBlock)
 	})
-	return err
+	if err != nil {
+		return err
+	}
+	b.utxoHash = utxoHash
+	return nil
 }
 
 // initChainState attempts to load and initialize the chain state from the
@@ -1347,6 +1378,11 @@ func (b *BlockChain) initChainState(chainStartTime int64) error {
 			i++
 		}
 
+		b.snapshotHeight, err = dbFetchSnapshotHeight(dbTx)
+		if err != nil {
+			return err
+		}
+
 		// Set the best chain view to the stored best state.
 		tip := b.index.LookupNode(&state.hash)
 		if tip == nil {
@@ -1391,12 +1427,39 @@ func (b *BlockChain) initChainState(chainStartTime int64) error {
 		b.stateSnapshot = newBestState(tip, blockSize,
 			numTxns, state.totalTxns, tip.GetTime())
 
+		if state.utxoHash != nil {
+			b.utxoHash, err = muhash.Deserialize(state.utxoHash)
+			if err != nil {
+				return database.Error{
+					ErrorCode:   database.ErrCorruption,
+					Description: fmt.Sprintf("corrupt utxo set hash: %v", err),
+				}
+			}
+		}
+
 		return nil
 	})
 
 	if err != nil {
 		return err
 	}
+
+	// The chain states written by older versions have no utxo set hash, so
+	// compute it once from the utxo set.
+	if b.utxoHash == nil {
+		log.Infof("Computing the utxo set hash...")
+		err = b.db.Update(func(dbTx database.Tx) error {
+			utxoHash, err := dbComputeUtxoHash(dbTx)
+			if err != nil {
+				return err
+			}
+			b.utxoHash = utxoHash
+			return dbPutBestState(dbTx, b.stateSnapshot, utxoHash)
+		})
+		if err != nil {
+			return err
+		}
+	}
 	// As we might have updated the index after it was loaded, we'll
 	// attempt to flush the index to the DB. This will only result in a
 	// write if the elements are dirty, so it'll usually be a noop.
@@ -1449,6 +1512,45 @@ func dbFetchHeaderByHash(dbTx database.Tx, hash *common.Hash) (*protos.BlockHead
 	return &header, nil
 }
 
+// FetchStateRoots returns the state roots of the best chain tip of the passed
+// database and of its ancestors, up to the passed count, starting with the tip.
+// It is meant for offline tools which do not load the full chain.
+func FetchStateRoots(db database.Transactor, count int32) ([]common.Hash, error) {
+	var roots []common.Hash
+	err := db.View(func(dbTx database.Tx) error {
+		serializedData := dbTx.Metadata().Get(chainStateKeyName)
+		if serializedData == nil {
+			return fmt.Errorf("the chain state is not initialized")
+		}
+		state, err := deserializeBestChainState(serializedData)
+		if err != nil {
+			return err
+		}
+
+		// The blocks preceding the snapshot the chain was bootstrapped
+		// from are not available.
+		snapshotHeight, err := dbFetchSnapshotHeight(dbTx)
+		if err != nil {
+			return err
+		}
+
+		hash := state.hash
+		for i := int32(0); i < count; i++ {
+			header, err := dbFetchHeaderByHash(dbTx, &hash)
+			if err != nil {
+				return err
+			}
+			roots = append(roots, header.StateRoot)
+			if header.PrevBlock == (common.Hash{}) || header.Height <= snapshotHeight {
+				break
+			}
+			hash = header.PrevBlock
+		}
+		return nil
+	})
+	return roots, err
+}
+
 // dbFetchBlockByNode uses an existing database transaction to retrieve the
 // raw block for the provided node, deserialize it, and return a vvsutil.Block
 // with the height set.
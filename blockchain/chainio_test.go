@@ -11,6 +11,7 @@ import (
 	"github.com/AsimovNetwork/asimov/blockchain/txo"
 	"github.com/AsimovNetwork/asimov/common"
 	"github.com/AsimovNetwork/asimov/common/hexutil"
+	"github.com/AsimovNetwork/asimov/crypto/muhash"
 	"reflect"
 	"testing"
 
@@ -759,14 +760,14 @@ func TestUtxoSerialization(t *testing.T) {
 
 	for i, test := range tests {
 		// Ensure the utxo entry serializes to the expected value.
-		gotBytes, err := serializeUtxoEntry(test.entry)
+		gotBytes, err := SerializeUtxoEntry(test.entry)
 		if err != nil {
-			t.Errorf("serializeUtxoEntry #%d (%s) unexpected error: %v", i, test.name, err)
+			t.Errorf("SerializeUtxoEntry #%d (%s) unexpected error: %v", i, test.name, err)
 			continue
 		}
 		gotHex := hexutil.Encode(gotBytes)
 		if gotHex != test.serialized {
-			t.Errorf("serializeUtxoEntry #%d (%s): mismatched - got %s, want %s",
+			t.Errorf("SerializeUtxoEntry #%d (%s): mismatched - got %s, want %s",
 				i, test.name, gotHex, test.serialized)
 			continue
 		}
@@ -918,6 +919,17 @@ func TestBestChainStateSerialization(t *testing.T) {
 			},
 			serialized: hexToBytes("4860eb18bf1b1620e37e9490fc8a427514416fd75159ab86688e9a8300000000010000000200000000000000"),
 		},
+		{
+			name: "block 1 with utxo set hash",
+			state: bestChainState{
+				hash:      *newHashFromStr("00000000839a8e6886ab5951d76f411475428afc90947ee320161bbf18eb6048"),
+				height:    1,
+				totalTxns: 2,
+				utxoHash:  muhash.New().Serialize(),
+			},
+			serialized: append(hexToBytes("4860eb18bf1b1620e37e9490fc8a427514416fd75159ab86688e9a8300000000010000000200000000000000"),
+				muhash.New().Serialize()...),
+		},
 	}
 
 	for i, test := range tests {
@@ -1106,4 +1118,48 @@ func TestDbFetchBalance(t *testing.T) {
 			t.Log(err)
 		}
 	}
-}
\ No newline at end of file
+}
+
+// TestFetchStateRoots ensures the state roots of the best chain are fetched
+// from the database starting with the tip.
+func TestFetchStateRoots(t *testing.T) {
+	parivateKeyList := []string{
+		"0xd0f0461b7b4d26cf370e6c73b58ef7fa26e8e30853a8cee901ed42cf0879cb6e", //privateKey0
+	}
+	accList, netParam, chain, teardownFunc, err := createFakeChainByPrivateKeys(parivateKeyList, 10)
+	defer teardownFunc()
+	if err != nil {
+		t.Fatalf("create fake chain error %v", err)
+	}
+
+	validators, filters, _ := chain.GetValidatorsByNode(1, chain.bestChain.tip())
+	for i := 0; i < 3; i++ {
+		block, _, err := createAndSignBlock(netParam, accList, validators, filters, chain, 1,
+			uint16(i), chain.bestChain.height(), protos.Asset{}, 0,
+			validators[i], nil, 0, chain.bestChain.tip())
+		if err != nil {
+			t.Fatalf("create block error %v", err)
+		}
+		if _, _, err = chain.ProcessBlock(block, nil, nil, nil, common.BFNone); err != nil {
+			t.Fatalf("ProcessBlock err %v", err)
+		}
+	}
+
+	roots, err := FetchStateRoots(chain.db, 2)
+	if err != nil {
+		t.Fatalf("FetchStateRoots err %v", err)
+	}
+	tip := chain.bestChain.tip()
+	if len(roots) != 2 || roots[0] != tip.stateRoot || roots[1] != tip.parent.stateRoot {
+		t.Errorf("FetchStateRoots got %v, want tip and parent roots", roots)
+	}
+
+	// The walk stops at the genesis block.
+	roots, err = FetchStateRoots(chain.db, tip.height+10)
+	if err != nil {
+		t.Fatalf("FetchStateRoots err %v", err)
+	}
+	if len(roots) != int(tip.height)+1 {
+		t.Errorf("FetchStateRoots got %d roots, want %d", len(roots), tip.height+1)
+	}
+}
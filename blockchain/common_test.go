@@ -74,6 +74,10 @@ func (m *ManagerTmp) Init(chain fvm.ChainContext, dataBytes [] byte) error {
 	if err != nil {
 		return err
 	}
+	err = syscontract.UpgradeContracts(cMap, chaincfg.ActiveNetParams.ContractUpgrades)
+	if err != nil {
+		return err
+	}
 	m.chain = chain
 	m.genesisDataCache = cMap
 	m.assetsUnrestrictedCache = make(map[protos.Asset]struct{})
@@ -159,6 +163,44 @@ func (m *ManagerTmp) GetSignedUpValidators(
 	return validators, round32, nil
 }
 
+func (m *ManagerTmp) GetAdminsAndValidators(
+	block *asiutil.Block,
+	stateDB vm.StateDB,
+	chainConfig *params.ChainConfig) ([]common.Address, []common.Address, error) {
+
+	gas := uint64(common.SystemContractReadOnlyGas)
+	officialAddr := chaincfg.OfficialAddress
+	contract := m.GetActiveContractByHeight(block.Height(), common.ConsensusPOA)
+	if contract == nil {
+		errStr := fmt.Sprintf("Failed to get active contract %s, %d", common.ConsensusPOA, block.Height())
+		return nil, nil, common.AssertError(errStr)
+	}
+	proxyAddr, abi := vm.ConvertSystemContractAddress(common.ConsensusPOA), contract.AbiInfo
+
+	funcName := common.ContractConsensusPOA_GetAdminsAndValidatorsFunction()
+	runCode, err := fvm.PackFunctionArgs(abi, funcName)
+	if err != nil {
+		return nil, nil, err
+	}
+	result, _, err := fvm.CallReadOnlyFunction(officialAddr, block, m.chain, stateDB, chainConfig,
+		gas, proxyAddr, runCode)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	admins := make([]common.Address, 0)
+	validators := make([]common.Address, 0)
+	outData := []interface{}{
+		&admins,
+		&validators,
+	}
+	err = fvm.UnPackFunctionResult(abi, &outData, funcName, result)
+	if err != nil {
+		return nil, nil, err
+	}
+	return admins, validators, nil
+}
+
 
 func (m *ManagerTmp) GetContractAddressByAsset(
 	gas uint64,
@@ -284,6 +284,18 @@ const (
 
 	// ErrFailedSerializedBlock indicates failed to get serialized bytes for block
 	ErrFailedSerializedBlock
+
+	// ErrBadEvidence indicates an evidence does not prove a validator
+	// produced or signed two conflicting blocks
+	ErrBadEvidence
+
+	// ErrFinalizedBlock indicates a reorganization would detach a block
+	// which is already finalized.
+	ErrFinalizedBlock
+
+	// ErrBadCommit indicates a block of a bft chain does not carry the
+	// precommits of more than two thirds of the validators.
+	ErrBadCommit
 )
 
 // Map of ErrorCode values back to their constant names for pretty printing.
@@ -360,6 +372,9 @@ var errorCodeStrings = map[ErrorCode]string{
 	ErrStxoMismatch:         "ErrStxoMismatch",
 	ErrNotInMainChain:       "ErrNotInMainChain",
 	ErrFailedSerializedBlock: "ErrFailedSerializedBlock",
+	ErrBadEvidence:          "ErrBadEvidence",
+	ErrFinalizedBlock:       "ErrFinalizedBlock",
+	ErrBadCommit:            "ErrBadCommit",
 }
 
 // String returns the ErrorCode as a human-readable name.
@@ -0,0 +1,114 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/AsimovNetwork/asimov/database"
+	"github.com/AsimovNetwork/asimov/protos"
+)
+
+// CheckEvidenceSanity performs checks on an evidence which do not depend on
+// the chain.  The conflicting blocks of the evidence must be different, in the
+// order of their hash, at the same round and slot for a double proposal or the
+// same height for a double sign, and both signed by the offender.
+func CheckEvidenceSanity(evidence *protos.MsgEvidence) error {
+	switch evidence.Type {
+	case protos.EvidenceDoubleProposal:
+		first, second := &evidence.Headers[0], &evidence.Headers[1]
+		if first.Round != second.Round || first.SlotIndex != second.SlotIndex {
+			str := fmt.Sprintf("headers are at round %d slot %d and round %d slot %d",
+				first.Round, first.SlotIndex, second.Round, second.SlotIndex)
+			return ruleError(ErrBadEvidence, str)
+		}
+		if first.CoinBase != second.CoinBase {
+			str := fmt.Sprintf("headers are produced by %v and %v",
+				first.CoinBase, second.CoinBase)
+			return ruleError(ErrBadEvidence, str)
+		}
+		firstHash, secondHash := first.BlockHash(), second.BlockHash()
+		if bytes.Compare(firstHash[:], secondHash[:]) >= 0 {
+			str := fmt.Sprintf("headers %v and %v are not in the order of their hash",
+				firstHash, secondHash)
+			return ruleError(ErrBadEvidence, str)
+		}
+		if err := AddressVerifySignature(firstHash[:], &first.CoinBase, first.SigData[:]); err != nil {
+			return err
+		}
+		return AddressVerifySignature(secondHash[:], &second.CoinBase, second.SigData[:])
+
+	case protos.EvidenceDoubleSign:
+		first, second := &evidence.Signs[0], &evidence.Signs[1]
+		if first.BlockHeight != second.BlockHeight {
+			str := fmt.Sprintf("signatures are at height %d and %d",
+				first.BlockHeight, second.BlockHeight)
+			return ruleError(ErrBadEvidence, str)
+		}
+		if first.Signer != second.Signer {
+			str := fmt.Sprintf("signatures are signed by %v and %v",
+				first.Signer, second.Signer)
+			return ruleError(ErrBadEvidence, str)
+		}
+		if bytes.Compare(first.BlockHash[:], second.BlockHash[:]) >= 0 {
+			str := fmt.Sprintf("signatures of %v and %v are not in the order of "+
+				"the block hash", first.BlockHash, second.BlockHash)
+			return ruleError(ErrBadEvidence, str)
+		}
+		if err := AddressVerifySignature(first.BlockHash[:], &first.Signer, first.Signature[:]); err != nil {
+			return err
+		}
+		return AddressVerifySignature(second.BlockHash[:], &second.Signer, second.Signature[:])
+	}
+
+	str := fmt.Sprintf("unknown evidence type %d", evidence.Type)
+	return ruleError(ErrBadEvidence, str)
+}
+
+// CheckEvidence checks the sanity of an evidence, and that the offender is a
+// validator.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) CheckEvidence(evidence *protos.MsgEvidence) error {
+	if err := CheckEvidenceSanity(evidence); err != nil {
+		return err
+	}
+	offender := evidence.Offender()
+	if !b.roundManager.HasValidator(offender) {
+		str := fmt.Sprintf("the offender %v is not a validator", offender)
+		return ruleError(ErrValidatorMismatch, str)
+	}
+	return nil
+}
+
+// DetectDoubleProposal returns the evidence of a double proposal when the
+// main chain has a different block produced by the producer of the passed
+// header for the same round and slot, or nil otherwise.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) DetectDoubleProposal(header *protos.BlockHeader) *protos.MsgEvidence {
+	node := b.GetNodeByRoundSlot(header.Round, header.SlotIndex)
+	if node == nil || node.coinbase != header.CoinBase || node.hash == header.BlockHash() {
+		return nil
+	}
+
+	var stored *protos.BlockHeader
+	err := b.db.View(func(dbTx database.Tx) error {
+		var err error
+		stored, err = dbFetchHeaderByHash(dbTx, &node.hash)
+		return err
+	})
+	if err != nil {
+		log.Warnf("Failed to fetch the header of block %v: %v", node.hash, err)
+		return nil
+	}
+
+	evidence := protos.NewDoubleProposalEvidence(stored, header)
+	if err := CheckEvidenceSanity(evidence); err != nil {
+		return nil
+	}
+	return evidence
+}
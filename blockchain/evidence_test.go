@@ -0,0 +1,96 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/crypto"
+	"github.com/AsimovNetwork/asimov/protos"
+)
+
+// TestCheckEvidenceSanity ensures evidences are only accepted when they prove
+// two conflicting blocks signed by the offender.
+func TestCheckEvidenceSanity(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey err %v", err)
+	}
+	offender, _ := common.NewAddressWithId(common.PubKeyHashAddrID,
+		common.Hash160(crypto.CompressPubkey(&key.PublicKey)))
+	sign := func(hash common.Hash) [protos.HashSignLen]byte {
+		var sig [protos.HashSignLen]byte
+		signature, err := crypto.Sign(hash[:], key)
+		if err != nil {
+			t.Fatalf("Sign err %v", err)
+		}
+		copy(sig[:], signature)
+		return sig
+	}
+	header := func(timestamp int64) *protos.BlockHeader {
+		header := &protos.BlockHeader{
+			Timestamp: timestamp,
+			Round:     2,
+			SlotIndex: 1,
+			Height:    5,
+			CoinBase:  *offender,
+		}
+		header.SigData = sign(header.BlockHash())
+		return header
+	}
+	blockSign := func(hash common.Hash) *protos.MsgBlockSign {
+		return &protos.MsgBlockSign{
+			BlockHeight: 5,
+			BlockHash:   hash,
+			Signer:      *offender,
+			Signature:   sign(hash),
+		}
+	}
+
+	proposal := protos.NewDoubleProposalEvidence(header(1), header(2))
+	doubleSign := protos.NewDoubleSignEvidence(blockSign(common.Hash{0x01}),
+		blockSign(common.Hash{0x02}))
+	for _, evidence := range []*protos.MsgEvidence{proposal, doubleSign} {
+		if err := CheckEvidenceSanity(evidence); err != nil {
+			t.Errorf("CheckEvidenceSanity of %v err %v", evidence.Type, err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		tamper func(evidence *protos.MsgEvidence)
+	}{
+		{"same block", func(e *protos.MsgEvidence) { e.Headers[1] = e.Headers[0] }},
+		{"other slot", func(e *protos.MsgEvidence) { e.Headers[1].SlotIndex++ }},
+		{"swapped", func(e *protos.MsgEvidence) { e.Headers[0], e.Headers[1] = e.Headers[1], e.Headers[0] }},
+		{"bad signature", func(e *protos.MsgEvidence) { e.Headers[0].SigData[1] ^= 0xff }},
+		{"other type", func(e *protos.MsgEvidence) { e.Type = protos.EvidenceDoubleSign }},
+	}
+	for _, test := range tests {
+		evidence := *proposal
+		test.tamper(&evidence)
+		if err := CheckEvidenceSanity(&evidence); err == nil {
+			t.Errorf("CheckEvidenceSanity accepted a double proposal with %s", test.name)
+		}
+	}
+
+	tests = []struct {
+		name   string
+		tamper func(evidence *protos.MsgEvidence)
+	}{
+		{"same block", func(e *protos.MsgEvidence) { e.Signs[1] = e.Signs[0] }},
+		{"other height", func(e *protos.MsgEvidence) { e.Signs[1].BlockHeight++ }},
+		{"other signer", func(e *protos.MsgEvidence) { e.Signs[1].Signer[1] ^= 0xff }},
+		{"bad signature", func(e *protos.MsgEvidence) { e.Signs[1].Signature[1] ^= 0xff }},
+	}
+	for _, test := range tests {
+		evidence := *doubleSign
+		test.tamper(&evidence)
+		if err := CheckEvidenceSanity(&evidence); err == nil {
+			t.Errorf("CheckEvidenceSanity accepted a double sign with %s", test.name)
+		}
+	}
+}
@@ -0,0 +1,313 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/database"
+	"github.com/AsimovNetwork/asimov/protos"
+)
+
+// finalizedBlockKeyName is the name of the db key used to store the hash of
+// the latest finalized block.
+var finalizedBlockKeyName = []byte("finalizedblock")
+
+// blockSigners tracks the validators which signed a block of the main chain,
+// as gathered from the signatures included in the later blocks.
+type blockSigners struct {
+	node    *blockNode
+	signers map[common.Address]struct{}
+}
+
+// isFinalWeight returns whether the weight gathered by a block is more than
+// two thirds of the total weight of the validators of its round.
+func isFinalWeight(weight, total uint32) bool {
+	return total > 0 && weight*3 > total*2
+}
+
+// voteRound returns the round of the chain whose validators vote on the block
+// following the passed node in the bft consensus, which is the round of the
+// slot after the one of the node.
+func (b *BlockChain) voteRound(prevNode *blockNode) uint32 {
+	round := prevNode.round.Round
+	if round == 0 || prevNode.slot+1 >= b.chainParams.RoundSize {
+		round++
+	}
+	return round
+}
+
+// checkCommit ensures a block of a bft chain carries the precommits for it of
+// more than two thirds of the validators voting at its height.
+//
+// This function MUST be called with the chain state lock held (for reads).
+func (b *BlockChain) checkCommit(block *asiutil.Block, prevNode *blockNode) error {
+	validators, err := b.GetPOAValidators(b.voteRound(prevNode))
+	if err != nil {
+		return err
+	}
+	return verifyCommit(block, validators)
+}
+
+// verifyCommit ensures the commit certificate of the block holds the valid
+// precommits for it of more than two thirds of the validators.  The precommits
+// must be from the same round, and each validator counts once.
+func verifyCommit(block *asiutil.Block, validators []common.Address) error {
+	commit := block.MsgBlock().Commit
+	if len(commit) == 0 {
+		str := fmt.Sprintf("block %v has no commit certificate", block.Hash())
+		return ruleError(ErrBadCommit, str)
+	}
+
+	voters := make(map[common.Address]struct{}, len(validators))
+	for _, validator := range validators {
+		voters[validator] = struct{}{}
+	}
+
+	signers := make(map[common.Address]struct{}, len(commit))
+	for _, vote := range commit {
+		if vote.Type != protos.VotePrecommit || vote.Height != block.Height() ||
+			vote.Round != commit[0].Round || vote.BlockHash != *block.Hash() {
+			str := fmt.Sprintf("commit certificate of block %v holds a "+
+				"vote for another round or block", block.Hash())
+			return ruleError(ErrBadCommit, str)
+		}
+		if _, exists := voters[vote.Validator]; !exists {
+			str := fmt.Sprintf("commit certificate of block %v holds a "+
+				"vote of %v which is not a validator", block.Hash(),
+				vote.Validator)
+			return ruleError(ErrBadCommit, str)
+		}
+		if _, exists := signers[vote.Validator]; exists {
+			str := fmt.Sprintf("commit certificate of block %v holds "+
+				"several votes of %v", block.Hash(), vote.Validator)
+			return ruleError(ErrBadCommit, str)
+		}
+		signHash := vote.SignHash()
+		err := AddressVerifySignature(signHash[:], &vote.Validator, vote.Signature[:])
+		if err != nil {
+			str := fmt.Sprintf("commit certificate of block %v holds an "+
+				"invalid vote of %v: %v", block.Hash(), vote.Validator, err)
+			return ruleError(ErrBadCommit, str)
+		}
+		signers[vote.Validator] = struct{}{}
+	}
+
+	if !isFinalWeight(uint32(len(signers)), uint32(len(voters))) {
+		str := fmt.Sprintf("commit certificate of block %v holds %d votes "+
+			"of %d validators", block.Hash(), len(signers), len(voters))
+		return ruleError(ErrBadCommit, str)
+	}
+	return nil
+}
+
+// signedWeight returns the weight gathered by a block of the main chain, which
+// is the weight of its producer plus the weights of its signers, and the total
+// weight of the validators of its round.
+//
+// This function MUST be called with the chain state lock held (for reads).
+func (b *BlockChain) signedWeight(node *blockNode) (uint32, uint32, error) {
+	_, weightMap, err := b.GetValidatorsByNode(node.round.Round,
+		b.validatorsNodeFrom(node, node.round.Round))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var total uint32
+	for _, weight := range weightMap {
+		total += uint32(weight)
+	}
+	weight := uint32(weightMap[node.coinbase])
+	if entry, exists := b.blockSigners[node.hash]; exists {
+		for signer := range entry.signers {
+			if signer != node.coinbase {
+				weight += uint32(weightMap[signer])
+			}
+		}
+	}
+	return weight, total, nil
+}
+
+// updateFinality gathers the signatures included in a block being connected
+// to the main chain, and returns the highest block it finalizes, which is the
+// highest signed block reaching more than two thirds of the weight of its
+// round's validators, or nil when there is none.  The caller stores the
+// returned block with dbPutFinalizedBlock and then sets it with setFinalized.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) updateFinality(node *blockNode, block *asiutil.Block) *blockNode {
+	if b.finalized == nil {
+		return nil
+	}
+
+	// A block committed by the bft consensus is final once connected.
+	if b.chainParams.BFT && len(block.MsgBlock().Commit) > 0 &&
+		node.height > b.finalized.height {
+		return node
+	}
+
+	touched := map[*blockNode]struct{}{node: {}}
+	for _, sig := range block.MsgBlock().PreBlockSigs {
+		if sig.BlockHeight <= b.finalized.height {
+			continue
+		}
+		signed := node.Ancestor(sig.BlockHeight)
+		if signed == nil || signed.hash != sig.BlockHash {
+			continue
+		}
+		entry, exists := b.blockSigners[signed.hash]
+		if !exists {
+			entry = &blockSigners{
+				node:    signed,
+				signers: make(map[common.Address]struct{}),
+			}
+			b.blockSigners[signed.hash] = entry
+		}
+		entry.signers[sig.Signer] = struct{}{}
+		touched[signed] = struct{}{}
+	}
+
+	candidates := make([]*blockNode, 0, len(touched))
+	for candidate := range touched {
+		candidates = append(candidates, candidate)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].height > candidates[j].height
+	})
+	for _, candidate := range candidates {
+		if candidate.height <= b.finalized.height {
+			break
+		}
+		weight, total, err := b.signedWeight(candidate)
+		if err != nil {
+			log.Warnf("Failed to get the validators of round %d: %v",
+				candidate.round.Round, err)
+			continue
+		}
+		if isFinalWeight(weight, total) {
+			return candidate
+		}
+	}
+	return nil
+}
+
+// revertFinality removes the signatures included in a block disconnected from
+// the main chain, or which failed to connect.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) revertFinality(node *blockNode, block *asiutil.Block) {
+	for _, sig := range block.MsgBlock().PreBlockSigs {
+		if entry, exists := b.blockSigners[sig.BlockHash]; exists {
+			delete(entry.signers, sig.Signer)
+		}
+	}
+	delete(b.blockSigners, node.hash)
+}
+
+// dbPutFinalizedBlock stores the hash of the latest finalized block.
+func dbPutFinalizedBlock(dbTx database.Tx, node *blockNode) error {
+	return dbTx.Metadata().Put(finalizedBlockKeyName, node.hash[:])
+}
+
+// setFinalized marks the passed block of the main chain, and its ancestors,
+// as irreversible, once it is stored as the finalized block.  The blocks below
+// it, or too deep to be signed by the blocks following the tip, do not need
+// to be tracked anymore.  A margin is kept for the blocks which are attached
+// again after a reorganization.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) setFinalized(node *blockNode, tip *blockNode) {
+	if node != nil {
+		b.finalized = node
+		log.Debugf("Finalized block %v at height %d", node.hash, node.height)
+	}
+	for hash, entry := range b.blockSigners {
+		if entry.node.height <= b.finalized.height ||
+			entry.node.height+2*common.BlockSignDepth < tip.height {
+			delete(b.blockSigners, hash)
+		}
+	}
+}
+
+// initFinality loads the latest finalized block and gathers the signatures of
+// the recent blocks of the main chain again.
+//
+// This function MUST be called after the round manager is initialized.
+func (b *BlockChain) initFinality() error {
+	b.finalized = b.bestChain.Genesis()
+	b.blockSigners = make(map[common.Hash]*blockSigners)
+
+	var finalized *blockNode
+	err := b.db.View(func(dbTx database.Tx) error {
+		serialized := dbTx.Metadata().Get(finalizedBlockKeyName)
+		if serialized == nil {
+			return nil
+		}
+		if len(serialized) != common.HashLength {
+			return fmt.Errorf("corrupt finalized block hash of %d bytes",
+				len(serialized))
+		}
+		hash := common.BytesToHash(serialized)
+		finalized = b.index.LookupNode(&hash)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if finalized != nil && b.bestChain.Contains(finalized) {
+		b.finalized = finalized
+	}
+
+	// The blocks may be missing below the snapshot height of a bootstrapped
+	// chain, so the failures to load them are ignored.
+	tip := b.bestChain.Tip()
+	height := tip.height - 2*common.BlockSignDepth
+	if height <= b.finalized.height {
+		height = b.finalized.height + 1
+	}
+	for ; height <= tip.height; height++ {
+		node := b.bestChain.NodeByHeight(height)
+		var block *asiutil.Block
+		err := b.db.View(func(dbTx database.Tx) error {
+			var err error
+			block, err = dbFetchBlockByNode(dbTx, node)
+			return err
+		})
+		if err != nil {
+			continue
+		}
+		finalized := b.updateFinality(node, block)
+		if finalized != nil {
+			err = b.db.Update(func(dbTx database.Tx) error {
+				return dbPutFinalizedBlock(dbTx, finalized)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		b.setFinalized(finalized, node)
+	}
+	return nil
+}
+
+// FinalizedBlock returns the hash and height of the latest finalized block.
+// Once a block gathers the signatures of more than two thirds of the weight of
+// its round's validators, it and its ancestors can not be detached from the
+// main chain anymore.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) FinalizedBlock() (common.Hash, int32) {
+	b.chainLock.RLock()
+	defer b.chainLock.RUnlock()
+
+	node := b.finalized
+	if node == nil {
+		node = b.bestChain.Genesis()
+	}
+	return node.hash, node.height
+}
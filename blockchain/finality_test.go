@@ -0,0 +1,437 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"testing"
+
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/crypto"
+	"github.com/AsimovNetwork/asimov/database"
+	"github.com/AsimovNetwork/asimov/protos"
+)
+
+// finalityTestKeys are the private keys of the validators of the chains of
+// the finality tests.
+var finalityTestKeys = []string{
+	"0xd0f0461b7b4d26cf370e6c73b58ef7fa26e8e30853a8cee901ed42cf0879cb6e",
+	"0xd07f68f78fc58e3dc8ea72ff69784aa9542c452a4ee66b2665fa3cccb48441c2",
+	"0x77366e621236e71a77236e0858cd652e92c3af0908527b5bd1542992c4d7cace",
+}
+
+// newFinalityTestChain returns a chain of the validators of the private keys,
+// with the blocks of the first slots of round 1 connected to it.
+func newFinalityTestChain(t *testing.T, privateKeys []string, blocks int) (
+	[]crypto.Account, *BlockChain, func()) {
+	accList, netParam, chain, teardownFunc, err := createFakeChainByPrivateKeys(privateKeys, 3)
+	if err != nil {
+		t.Fatalf("create fake chain error %v", err)
+	}
+	validators, filters, _ := chain.GetValidatorsByNode(1, chain.bestChain.tip())
+	for slot := uint16(0); slot < uint16(blocks); slot++ {
+		block, _, err := createAndSignBlock(netParam, accList, validators, filters,
+			chain, 1, slot, chain.bestChain.height(), protos.Asset{}, 0,
+			validators[slot], nil, 0, chain.bestChain.tip())
+		if err != nil {
+			teardownFunc()
+			t.Fatalf("create block error %v", err)
+		}
+		if _, _, err = chain.ProcessBlock(block, nil, nil, nil, common.BFNone); err != nil {
+			teardownFunc()
+			t.Fatalf("ProcessBlock error %v", err)
+		}
+	}
+	return accList, chain, teardownFunc
+}
+
+// dbFetchFinalizedBlock returns the hash of the finalized block stored in the
+// database, or nil when there is none.
+func dbFetchFinalizedBlock(t *testing.T, chain *BlockChain) []byte {
+	var serialized []byte
+	err := chain.db.View(func(dbTx database.Tx) error {
+		serialized = dbTx.Metadata().Get(finalizedBlockKeyName)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("View error %v", err)
+	}
+	return serialized
+}
+
+// TestIsFinalWeight ensures a block is only finalized by more than two thirds
+// of the weight of the validators.
+func TestIsFinalWeight(t *testing.T) {
+	tests := []struct {
+		weight uint32
+		total  uint32
+		want   bool
+	}{
+		{0, 0, false},
+		{1, 1, true},
+		{2, 3, false},
+		{3, 3, true},
+		{6, 9, false},
+		{7, 9, true},
+		{14, 21, false},
+		{15, 21, true},
+	}
+	for _, test := range tests {
+		if got := isFinalWeight(test.weight, test.total); got != test.want {
+			t.Errorf("isFinalWeight(%d, %d) = %v, want %v", test.weight,
+				test.total, got, test.want)
+		}
+	}
+}
+
+// TestVerifyCommit ensures a block of a bft chain is only accepted with the
+// valid precommits of more than two thirds of the validators.
+func TestVerifyCommit(t *testing.T) {
+	privateKeys := []string{
+		"0xd0f0461b7b4d26cf370e6c73b58ef7fa26e8e30853a8cee901ed42cf0879cb6e",
+		"0xd07f68f78fc58e3dc8ea72ff69784aa9542c452a4ee66b2665fa3cccb48441c2",
+		"0x77366e621236e71a77236e0858cd652e92c3af0908527b5bd1542992c4d7cace",
+		"0x0101010101010101010101010101010101010101010101010101010101010101",
+	}
+	accounts := make([]*crypto.Account, 0, len(privateKeys))
+	for _, key := range privateKeys {
+		acc, err := crypto.NewAccount(key)
+		if err != nil {
+			t.Fatalf("NewAccount error %v", err)
+		}
+		accounts = append(accounts, acc)
+	}
+	// The last account is not a validator.
+	validators := []common.Address{
+		*accounts[0].Address, *accounts[1].Address, *accounts[2].Address,
+	}
+
+	header := protos.BlockHeader{Height: 10, Round: 2, SlotIndex: 3}
+	blockHash := header.BlockHash()
+	precommit := func(acc *crypto.Account, round int32, hash common.Hash) *protos.MsgVote {
+		vote := &protos.MsgVote{
+			Type:      protos.VotePrecommit,
+			Height:    header.Height,
+			Round:     round,
+			BlockHash: hash,
+			Validator: *acc.Address,
+		}
+		signHash := vote.SignHash()
+		sig, err := crypto.Sign(signHash[:], (*ecdsa.PrivateKey)(&acc.PrivateKey))
+		if err != nil {
+			t.Fatalf("Sign error %v", err)
+		}
+		copy(vote.Signature[:], sig)
+		return vote
+	}
+	prevote := precommit(accounts[2], 0, blockHash)
+	prevote.Type = protos.VotePrevote
+	forged := precommit(accounts[2], 0, blockHash)
+	forged.Validator = *accounts[1].Address
+
+	tests := []struct {
+		name   string
+		commit []*protos.MsgVote
+		valid  bool
+	}{
+		{"no commit", nil, false},
+		{"all validators", []*protos.MsgVote{
+			precommit(accounts[0], 0, blockHash),
+			precommit(accounts[1], 0, blockHash),
+			precommit(accounts[2], 0, blockHash),
+		}, true},
+		{"two thirds", []*protos.MsgVote{
+			precommit(accounts[0], 0, blockHash),
+			precommit(accounts[1], 0, blockHash),
+		}, false},
+		{"duplicate vote", []*protos.MsgVote{
+			precommit(accounts[0], 0, blockHash),
+			precommit(accounts[1], 0, blockHash),
+			precommit(accounts[1], 0, blockHash),
+		}, false},
+		{"not a validator", []*protos.MsgVote{
+			precommit(accounts[0], 0, blockHash),
+			precommit(accounts[1], 0, blockHash),
+			precommit(accounts[3], 0, blockHash),
+		}, false},
+		{"another block", []*protos.MsgVote{
+			precommit(accounts[0], 0, blockHash),
+			precommit(accounts[1], 0, blockHash),
+			precommit(accounts[2], 0, common.Hash{}),
+		}, false},
+		{"another round", []*protos.MsgVote{
+			precommit(accounts[0], 0, blockHash),
+			precommit(accounts[1], 0, blockHash),
+			precommit(accounts[2], 1, blockHash),
+		}, false},
+		{"prevote", []*protos.MsgVote{
+			precommit(accounts[0], 0, blockHash),
+			precommit(accounts[1], 0, blockHash),
+			prevote,
+		}, false},
+		{"forged signature", []*protos.MsgVote{
+			precommit(accounts[0], 0, blockHash),
+			precommit(accounts[2], 0, blockHash),
+			forged,
+		}, false},
+	}
+	for _, test := range tests {
+		block := asiutil.NewBlock(&protos.MsgBlock{
+			Header: header,
+			Commit: test.commit,
+		})
+		err := verifyCommit(block, validators)
+		if test.valid {
+			if err != nil {
+				t.Errorf("%s: verifyCommit error %v", test.name, err)
+			}
+			continue
+		}
+		if rerr, ok := err.(RuleError); !ok || rerr.ErrorCode != ErrBadCommit {
+			t.Errorf("%s: verifyCommit error %v, want %v", test.name, err,
+				ErrBadCommit)
+		}
+	}
+}
+
+// TestBFTRequiresCommit ensures a bft chain refuses the blocks without commit
+// certificate.
+func TestBFTRequiresCommit(t *testing.T) {
+	privateKeys := []string{
+		"0xd0f0461b7b4d26cf370e6c73b58ef7fa26e8e30853a8cee901ed42cf0879cb6e",
+	}
+	accList, netParam, chain, teardownFunc, err := createFakeChainByPrivateKeys(privateKeys, 10)
+	if err != nil {
+		t.Fatalf("create fake chain error %v", err)
+	}
+	defer teardownFunc()
+	chain.chainParams.BFT = true
+
+	validators, filters, _ := chain.GetValidatorsByNode(1, chain.bestChain.tip())
+	block, _, err := createAndSignBlock(netParam, accList, validators, filters,
+		chain, 1, 0, chain.bestChain.height(), protos.Asset{}, 0,
+		validators[0], nil, 0, chain.bestChain.tip())
+	if err != nil {
+		t.Fatalf("create block error %v", err)
+	}
+	_, _, err = chain.ProcessBlock(block, nil, nil, nil, common.BFNone)
+	if rerr, ok := err.(RuleError); !ok || rerr.ErrorCode != ErrBadCommit {
+		t.Fatalf("ProcessBlock error %v, want %v", err, ErrBadCommit)
+	}
+	if chain.bestChain.height() != 0 {
+		t.Errorf("the block without commit certificate was connected")
+	}
+}
+
+// TestCommitRequiresBFT ensures a chain which does not use the bft consensus
+// refuses the blocks carrying a commit certificate.
+func TestCommitRequiresBFT(t *testing.T) {
+	privateKeys := []string{
+		"0xd0f0461b7b4d26cf370e6c73b58ef7fa26e8e30853a8cee901ed42cf0879cb6e",
+	}
+	accList, netParam, chain, teardownFunc, err := createFakeChainByPrivateKeys(privateKeys, 10)
+	if err != nil {
+		t.Fatalf("create fake chain error %v", err)
+	}
+	defer teardownFunc()
+
+	validators, filters, _ := chain.GetValidatorsByNode(1, chain.bestChain.tip())
+	block, _, err := createAndSignBlock(netParam, accList, validators, filters,
+		chain, 1, 0, chain.bestChain.height(), protos.Asset{}, 0,
+		validators[0], nil, 0, chain.bestChain.tip())
+	if err != nil {
+		t.Fatalf("create block error %v", err)
+	}
+	block.MsgBlock().Commit = []*protos.MsgVote{{
+		Type:      protos.VotePrecommit,
+		Height:    block.Height(),
+		BlockHash: *block.Hash(),
+		Validator: *validators[0],
+	}}
+	_, _, err = chain.ProcessBlock(block, nil, nil, nil, common.BFNone)
+	if rerr, ok := err.(RuleError); !ok || rerr.ErrorCode != ErrBadCommit {
+		t.Fatalf("ProcessBlock error %v, want %v", err, ErrBadCommit)
+	}
+
+	block.MsgBlock().Commit = nil
+	if _, _, err = chain.ProcessBlock(block, nil, nil, nil, common.BFNone); err != nil {
+		t.Fatalf("ProcessBlock error %v", err)
+	}
+	if chain.bestChain.height() != 1 {
+		t.Errorf("the block without commit certificate was not connected")
+	}
+}
+
+// TestUpdateFinality ensures a block is finalized once the signatures of the
+// later blocks gather more than two thirds of the weight of its validators.
+func TestUpdateFinality(t *testing.T) {
+	_, chain, teardownFunc := newFinalityTestChain(t, finalityTestKeys, 2)
+	defer teardownFunc()
+
+	if chain.finalized.height != 0 {
+		t.Fatalf("got finalized block at height %d, want the genesis block",
+			chain.finalized.height)
+	}
+	tip := chain.bestChain.Tip()
+	signed := tip.parent
+	validators, _, _ := chain.GetValidatorsByNode(1, chain.bestChain.Genesis())
+	signers := make([]common.Address, 0, len(validators))
+	for _, validator := range validators {
+		if *validator != signed.coinbase {
+			signers = append(signers, *validator)
+		}
+	}
+	signBlock := func(hash common.Hash, signers ...common.Address) *asiutil.Block {
+		sigs := make([]*protos.MsgBlockSign, 0, len(signers))
+		for _, signer := range signers {
+			sigs = append(sigs, &protos.MsgBlockSign{
+				BlockHeight: signed.height,
+				BlockHash:   hash,
+				Signer:      signer,
+			})
+		}
+		return asiutil.NewBlock(&protos.MsgBlock{PreBlockSigs: sigs})
+	}
+
+	// The signature of another block at the same height is ignored.
+	if finalized := chain.updateFinality(tip, signBlock(common.Hash{0x01}, signers...)); finalized != nil {
+		t.Errorf("the signatures of another block finalized block %d", finalized.height)
+	}
+	// The producer and a signer hold two thirds of the weight.
+	if finalized := chain.updateFinality(tip, signBlock(signed.hash, signers[0])); finalized != nil {
+		t.Errorf("two thirds of the weight finalized block %d", finalized.height)
+	}
+	if entry := chain.blockSigners[signed.hash]; entry == nil || len(entry.signers) != 1 {
+		t.Fatalf("the signature of block %d is not tracked", signed.height)
+	}
+	finalized := chain.updateFinality(tip, signBlock(signed.hash, signers[1]))
+	if finalized != signed {
+		t.Fatalf("got finalized block %v, want block %d", finalized, signed.height)
+	}
+
+	chain.setFinalized(finalized, tip)
+	if chain.finalized != signed {
+		t.Errorf("got finalized block at height %d, want %d", chain.finalized.height,
+			signed.height)
+	}
+	if _, exists := chain.blockSigners[signed.hash]; exists {
+		t.Errorf("the signatures of the finalized block are still tracked")
+	}
+	// The signatures of the finalized blocks are not tracked anymore.
+	if finalized := chain.updateFinality(tip, signBlock(signed.hash, signers...)); finalized != nil {
+		t.Errorf("the finalized block %d is finalized again", finalized.height)
+	}
+	if _, exists := chain.blockSigners[signed.hash]; exists {
+		t.Errorf("the signatures of the finalized block are tracked again")
+	}
+}
+
+// TestConnectBlockFinality ensures the block finalized by a connected block is
+// stored along with it.
+func TestConnectBlockFinality(t *testing.T) {
+	// The producer of a block holds all the weight of its round.
+	_, chain, teardownFunc := newFinalityTestChain(t, finalityTestKeys[:1], 1)
+	defer teardownFunc()
+
+	tip := chain.bestChain.Tip()
+	if chain.finalized != tip {
+		t.Fatalf("got finalized block at height %d, want %d", chain.finalized.height,
+			tip.height)
+	}
+	if stored := dbFetchFinalizedBlock(t, chain); !bytes.Equal(stored, tip.hash[:]) {
+		t.Errorf("got stored finalized block %x, want %v", stored, tip.hash)
+	}
+}
+
+// TestInitFinality ensures the finalized block stored in the database is
+// restored when the chain is loaded.
+func TestInitFinality(t *testing.T) {
+	_, chain, teardownFunc := newFinalityTestChain(t, finalityTestKeys, 2)
+	defer teardownFunc()
+
+	tip := chain.bestChain.Tip()
+	tests := []struct {
+		name   string
+		stored *blockNode
+		want   *blockNode
+	}{
+		{"stored block", tip.parent, tip.parent},
+		{"unknown block", &blockNode{hash: common.Hash{0x01}}, chain.bestChain.Genesis()},
+	}
+	for _, test := range tests {
+		err := chain.db.Update(func(dbTx database.Tx) error {
+			return dbPutFinalizedBlock(dbTx, test.stored)
+		})
+		if err != nil {
+			t.Fatalf("%s: Update error %v", test.name, err)
+		}
+		chain.finalized = nil
+		if err := chain.initFinality(); err != nil {
+			t.Fatalf("%s: initFinality error %v", test.name, err)
+		}
+		if chain.finalized != test.want {
+			t.Errorf("%s: got finalized block at height %d, want %d", test.name,
+				chain.finalized.height, test.want.height)
+		}
+	}
+}
+
+// TestReorganizeFinalizedBlock ensures a reorganization does not detach a
+// finalized block.
+func TestReorganizeFinalizedBlock(t *testing.T) {
+	accList, netParam, chain, teardownFunc, err := createFakeChainByPrivateKeys(finalityTestKeys[:1], 3)
+	if err != nil {
+		t.Fatalf("create fake chain error %v", err)
+	}
+	defer teardownFunc()
+
+	validators, filters, _ := chain.GetValidatorsByNode(1, chain.bestChain.tip())
+	genesis := chain.bestChain.Genesis()
+	var side *asiutil.Block
+	for slot := uint16(0); slot < 2; slot++ {
+		block, _, err := createAndSignBlock(netParam, accList, validators, filters,
+			chain, 1, slot, chain.bestChain.height(), protos.Asset{}, 0,
+			validators[slot], nil, 0, chain.bestChain.tip())
+		if err != nil {
+			t.Fatalf("create block error %v", err)
+		}
+		if slot == 0 {
+			// A block of the next slot on the genesis block.
+			side, _, err = createAndSignBlock(netParam, accList, validators, filters,
+				chain, 1, 1, 0, protos.Asset{}, 0, validators[1], nil, 0, genesis)
+			if err != nil {
+				t.Fatalf("create block error %v", err)
+			}
+		}
+		if _, _, err = chain.ProcessBlock(block, nil, nil, nil, common.BFNone); err != nil {
+			t.Fatalf("ProcessBlock error %v", err)
+		}
+	}
+	tip := chain.bestChain.Tip()
+	if chain.finalized != tip {
+		t.Fatalf("got finalized block at height %d, want %d", chain.finalized.height,
+			tip.height)
+	}
+	if _, _, err = chain.ProcessBlock(side, nil, nil, nil, common.BFNone); err != nil {
+		t.Fatalf("ProcessBlock side block error %v", err)
+	}
+	sideNode := chain.index.LookupNode(side.Hash())
+	if sideNode == nil {
+		t.Fatalf("the side block is not in the block index")
+	}
+
+	chain.chainLock.Lock()
+	detachNodes, attachNodes := chain.getReorganizeNodes(sideNode)
+	err = chain.reorganizeChain(detachNodes, attachNodes)
+	chain.chainLock.Unlock()
+	if rerr, ok := err.(RuleError); !ok || rerr.ErrorCode != ErrFinalizedBlock {
+		t.Fatalf("reorganizeChain error %v, want %v", err, ErrFinalizedBlock)
+	}
+	if chain.bestChain.Tip() != tip {
+		t.Errorf("the reorganization detached the finalized block")
+	}
+}
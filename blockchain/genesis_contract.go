@@ -4,7 +4,13 @@
 package blockchain
 
 import (
+	"math/big"
+
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/chaincfg"
 	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/vm/fvm"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/state"
 	"github.com/AsimovNetwork/asimov/vm/fvm/core/vm"
 )
 
@@ -16,8 +22,15 @@ func (b *BlockChain) GetSystemContractInfo(delegateAddr common.ContractCode) (co
 	if snapshot != nil {
 		blockHeight = snapshot.Height
 	}
+	return b.GetSystemContractInfoByHeight(delegateAddr, blockHeight)
+}
 
-	contract := b.contractManager.GetActiveContractByHeight(blockHeight, delegateAddr)
+// GetSystemContractInfoByHeight returns the delegate address, the original
+// address and the abi information of the version of a system contract active
+// in the block at the given height.
+func (b *BlockChain) GetSystemContractInfoByHeight(delegateAddr common.ContractCode,
+	height int32) (common.Address, []byte, string) {
+	contract := b.contractManager.GetActiveContractByHeight(height, delegateAddr)
 	if contract == nil {
 		return common.Address{}, nil, ""
 	}
@@ -25,6 +38,50 @@ func (b *BlockChain) GetSystemContractInfo(delegateAddr common.ContractCode) (co
 	return vm.ConvertSystemContractAddress(delegateAddr), contract.Address, contract.AbiInfo
 }
 
+// systemContracts lists the system contracts, which may be upgraded.
+var systemContracts = []common.ContractCode{
+	common.GenesisOrganization,
+	common.RegistryCenter,
+	common.TemplateWarehouse,
+	common.ConsensusSatoshiPlus,
+	common.ConsensusPOA,
+	common.ValidatorCommittee,
+}
+
+// deployContractUpgrades deploys the upgraded versions of the system contracts
+// active in the block which are not deployed yet.  They are created from the
+// official address like the genesis versions, so they are deployed at the
+// addresses of their contract info.  The upgraded versions keep the storage of
+// the proxies, so they are not initialized.
+func (b *BlockChain) deployContractUpgrades(block *asiutil.Block, stateDB *state.StateDB) error {
+	var vmenv *vm.FVM
+	sender := vm.AccountRef(chaincfg.OfficialAddress)
+	for _, delegateAddr := range systemContracts {
+		height := block.Height()
+		for {
+			contract := b.contractManager.GetActiveContractByHeight(height, delegateAddr)
+			if contract == nil || contract.BlockHeight == 0 {
+				break
+			}
+			if stateDB.GetCodeSize(common.BytesToAddress(contract.Address)) > 0 {
+				break
+			}
+			if vmenv == nil {
+				context := fvm.NewFVMContext(chaincfg.OfficialAddress, new(big.Int).SetInt64(1), block, b, nil, nil)
+				vmenv = vm.NewFVM(context, stateDB, chaincfg.ActiveNetParams.FvmParam, *b.GetVmConfig())
+			}
+			byteCode := common.Hex2Bytes(contract.Code)
+			_, addr, _, _, err := vmenv.Create(sender, byteCode, uint64(4604216000), common.Big0, &asiutil.AsimovAsset, byteCode, nil, true)
+			if err != nil {
+				return err
+			}
+			log.Infof("Deploy the version %s of system contract %s at %s", contract.Name, delegateAddr, addr.Hex())
+			height = contract.BlockHeight - 1
+		}
+	}
+	return nil
+}
+
 // GetTemplateWarehouseInfo returns two values.
 // @return templateWarehouseAddr the address of system contract named templateWarehouse
 //         templateWarehouseAbi  abi of system contract named templateWarehouse
@@ -0,0 +1,102 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/AsimovNetwork/asimov/chaincfg"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/crypto"
+	"github.com/AsimovNetwork/asimov/protos"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/state"
+)
+
+// TestContractUpgrade ensures an upgraded version of a system contract is
+// deployed at its activation height, and the proxy of the contract delegates
+// the calls to it over the storage of the proxy.
+func TestContractUpgrade(t *testing.T) {
+	privateKeys := []string{
+		"0xd0f0461b7b4d26cf370e6c73b58ef7fa26e8e30853a8cee901ed42cf0879cb6e",
+	}
+	accList, netParam, chain, teardownFunc, err := createFakeChainByPrivateKeys(privateKeys, 10)
+	if err != nil {
+		t.Fatalf("create fake chain error %v", err)
+	}
+	defer teardownFunc()
+
+	// The new version has the code of the genesis version, followed by
+	// 32 bytes which change its address.
+	height := chain.bestChain.height() + 1
+	manager := chain.contractManager.(*ManagerTmp)
+	genesis := manager.genesisDataCache[common.ConsensusPOA][0]
+	code := common.Hex2Bytes(genesis.Code)
+	code = append(code, common.HexToHash("0x01").Bytes()...)
+	upgradeAddr, err := crypto.CreateContractAddress(chaincfg.OfficialAddress[:], nil, code)
+	if err != nil {
+		t.Fatalf("CreateContractAddress error %v", err)
+	}
+	manager.genesisDataCache[common.ConsensusPOA] = append(
+		manager.genesisDataCache[common.ConsensusPOA], chaincfg.ContractInfo{
+			Name:        "ConsensusPOAV2",
+			Address:     upgradeAddr.Bytes(),
+			Code:        common.Bytes2Hex(code),
+			AbiInfo:     genesis.AbiInfo,
+			BlockHeight: height,
+		})
+
+	_, addr, _ := chain.GetSystemContractInfoByHeight(common.ConsensusPOA, height-1)
+	if !bytes.Equal(addr, genesis.Address) {
+		t.Fatalf("the upgrade of consensus_poa is active before its height")
+	}
+	_, addr, _ = chain.GetSystemContractInfoByHeight(common.ConsensusPOA, height)
+	if !bytes.Equal(addr, upgradeAddr.Bytes()) {
+		t.Fatalf("the upgrade of consensus_poa is not active at its height")
+	}
+	stateDB, _ := state.New(chain.bestChain.tip().stateRoot, chain.stateCache)
+	if stateDB.GetCodeSize(upgradeAddr) != 0 {
+		t.Fatalf("the upgrade of consensus_poa is deployed before its height")
+	}
+	tip, err := chain.BlockByHeight(height - 1)
+	if err != nil {
+		t.Fatalf("BlockByHeight error %v", err)
+	}
+	fvmParam := chaincfg.ActiveNetParams.FvmParam
+	_, poaValidators, err := chain.contractManager.GetAdminsAndValidators(tip, stateDB, fvmParam)
+	if err != nil {
+		t.Fatalf("GetAdminsAndValidators error %v", err)
+	}
+
+	validators, filters, _ := chain.GetValidatorsByNode(1, chain.bestChain.tip())
+	block, _, err := createAndSignBlock(netParam, accList, validators, filters,
+		chain, 1, 0, chain.bestChain.height(), protos.Asset{}, 0,
+		validators[0], nil, 0, chain.bestChain.tip())
+	if err != nil {
+		t.Fatalf("create block error %v", err)
+	}
+	if _, _, err = chain.ProcessBlock(block, nil, nil, nil, common.BFNone); err != nil {
+		t.Fatalf("ProcessBlock error %v", err)
+	}
+	stateDB, _ = state.New(chain.bestChain.tip().stateRoot, chain.stateCache)
+	if stateDB.GetCodeSize(upgradeAddr) == 0 {
+		t.Fatalf("the upgrade of consensus_poa is not deployed at its height")
+	}
+
+	// The new version reads the storage of the proxy.
+	_, upgraded, err := chain.contractManager.GetAdminsAndValidators(block, stateDB, fvmParam)
+	if err != nil {
+		t.Fatalf("GetAdminsAndValidators error %v", err)
+	}
+	if len(upgraded) == 0 || len(upgraded) != len(poaValidators) {
+		t.Fatalf("got %d validators after the upgrade, want %d", len(upgraded),
+			len(poaValidators))
+	}
+	for i := range upgraded {
+		if upgraded[i] != poaValidators[i] {
+			t.Errorf("validator %d changed from %x to %x", i, poaValidators[i], upgraded[i])
+		}
+	}
+}
@@ -0,0 +1,346 @@
+// Copyright (c) 2018-2020. The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+package indexers
+
+import (
+	"encoding/binary"
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/blockchain"
+	"github.com/AsimovNetwork/asimov/blockchain/txo"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/common/bitutil"
+	"github.com/AsimovNetwork/asimov/database"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/bloombits"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/types"
+)
+
+const (
+	// bloomIndexName is the human-readable name for the index.
+	bloomIndexName = "bloom bits index"
+
+	// BloomSectionSize is the number of blocks covered by a single section
+	// of rotated bloom bits.
+	BloomSectionSize = 4096
+)
+
+var (
+	// bloomIndexParentBucketKey is the key of the bloom bits index and the
+	// name of the parent bucket used to house it.
+	bloomIndexParentBucketKey = []byte("bloomindexparentbucket")
+
+	// bloomByHeightBucketName is the name of the db bucket used to house
+	// the blooms of the blocks in the section which is not complete yet.
+	bloomByHeightBucketName = []byte("bloombyheightidx")
+
+	// bloomBitsBucketName is the name of the db bucket used to house the
+	// rotated bloom bits of the complete sections.
+	bloomBitsBucketName = []byte("bloombitsbysectionidx")
+)
+
+// -----------------------------------------------------------------------------
+// The bloom bits index groups the main chain into sections of BloomSectionSize
+// blocks.  Once the last block of a section is connected, the log blooms of
+// all its blocks are rotated into 2048 bit vectors, one per bloom bit, with a
+// bit per block.  A log query then only loads the few vectors selected by its
+// addresses and topics to find the candidate blocks of a whole section.
+//
+// The blooms of the blocks of the pending section are kept per height until
+// the section is complete.  Empty blooms and all-zero vectors are not stored.
+//
+// The serialized format for keys and values in the bloom by height bucket is:
+//   <height> = <bloom>
+//
+//   Field           Type              Size
+//   height          uint32            4 bytes (big endian)
+//   bloom           types.Bloom       256 bytes
+//
+// The serialized format for keys and values in the bloom bits bucket is:
+//   <section><bit> = <compressed bits>
+//
+//   Field           Type              Size
+//   section         uint32            4 bytes (big endian)
+//   bit             uint16            2 bytes (big endian)
+//   compressed bits []byte            variable, bitutil.CompressBytes of
+//                                     BloomSectionSize/8 bytes
+// -----------------------------------------------------------------------------
+
+// bloomHeightKey returns the key of the pending bloom of the given height.
+// Big endian keeps the entries ordered by height.
+func bloomHeightKey(height int32) []byte {
+	key := make([]byte, 4)
+	binary.BigEndian.PutUint32(key, uint32(height))
+	return key
+}
+
+// bloomBitsKey returns the key of the bit vector of the given section.
+func bloomBitsKey(section uint32, bit uint) []byte {
+	key := make([]byte, 6)
+	binary.BigEndian.PutUint32(key, section)
+	binary.BigEndian.PutUint16(key[4:], uint16(bit))
+	return key
+}
+
+// dbFetchBloomBits returns the decompressed bit vector of a section.  A
+// missing entry is an all-zero vector.
+func dbFetchBloomBits(dbTx database.Tx, section uint32, bit uint) ([]byte, error) {
+	bucket := dbTx.Metadata().Bucket(bloomIndexParentBucketKey).Bucket(bloomBitsBucketName)
+	comp := bucket.Get(bloomBitsKey(section, bit))
+	return bitutil.DecompressBytes(comp, BloomSectionSize/8)
+}
+
+// BloomIndex implements a rotated bloom bits index over the log blooms of the
+// blocks in the main chain.
+type BloomIndex struct {
+	db database.Transactor
+}
+
+// Ensure the BloomIndex type implements the Indexer interface.
+var _ blockchain.Indexer = (*BloomIndex)(nil)
+
+// Init initializes the bloom bits index.  This is part of the Indexer
+// interface.
+func (idx *BloomIndex) Init() error {
+	return nil // Nothing to do.
+}
+
+// Key returns the database key to use for the index as a byte slice.  This is
+// part of the Indexer interface.
+func (idx *BloomIndex) Key() []byte {
+	return bloomIndexParentBucketKey
+}
+
+// Name returns the human-readable name of the index.  This is part of the
+// Indexer interface.
+func (idx *BloomIndex) Name() string {
+	return bloomIndexName
+}
+
+// Create is invoked when the indexer manager determines the index needs to
+// be created for the first time.  It creates the parent bucket along with the
+// pending bloom and bloom bits buckets.
+func (idx *BloomIndex) Create(dbTx database.Tx) error {
+	parent, err := dbTx.Metadata().CreateBucket(bloomIndexParentBucketKey)
+	if err != nil {
+		return err
+	}
+	if _, err = parent.CreateBucket(bloomByHeightBucketName); err != nil {
+		return err
+	}
+	_, err = parent.CreateBucket(bloomBitsBucketName)
+	return err
+}
+
+// Check is invoked each time the node is started.  It creates any bucket of
+// the index which does not exist yet.
+func (idx *BloomIndex) Check(dbTx database.Tx) error {
+	parent, err := dbTx.Metadata().CreateBucketIfNotExists(bloomIndexParentBucketKey)
+	if err != nil {
+		return err
+	}
+	if _, err = parent.CreateBucketIfNotExists(bloomByHeightBucketName); err != nil {
+		return err
+	}
+	_, err = parent.CreateBucketIfNotExists(bloomBitsBucketName)
+	return err
+}
+
+// ConnectBlock is invoked by the index manager when a new block has been
+// connected to the main chain.  The bloom of the block is stored with the
+// pending section, and the section is rotated into bloom bits once its last
+// block is connected.  This is part of the Indexer interface.
+func (idx *BloomIndex) ConnectBlock(dbTx database.Tx, block *asiutil.Block,
+	_ []txo.SpentTxOut, _ *asiutil.VBlock) error {
+
+	parent := dbTx.Metadata().Bucket(bloomIndexParentBucketKey)
+	blooms := parent.Bucket(bloomByHeightBucketName)
+
+	height := block.Height()
+	bloom := block.MsgBlock().Bloom
+	if bloom != (types.Bloom{}) {
+		if err := blooms.Put(bloomHeightKey(height), bloom[:]); err != nil {
+			return err
+		}
+	}
+
+	if (height+1)%BloomSectionSize != 0 {
+		return nil
+	}
+
+	// The section is complete, rotate the blooms of all its blocks.
+	section := uint32(height / BloomSectionSize)
+	first := height + 1 - BloomSectionSize
+	gen, err := bloombits.NewGenerator(BloomSectionSize)
+	if err != nil {
+		return err
+	}
+	for i := int32(0); i < BloomSectionSize; i++ {
+		var bloom types.Bloom
+		if data := blooms.Get(bloomHeightKey(first + i)); data != nil {
+			bloom = types.BytesToBloom(data)
+		}
+		if err := gen.AddBloom(uint(i), bloom); err != nil {
+			return err
+		}
+	}
+
+	bits := parent.Bucket(bloomBitsBucketName)
+	for i := uint(0); i < types.BloomBitLength; i++ {
+		bitset, err := gen.Bitset(i)
+		if err != nil {
+			return err
+		}
+		comp := bitutil.CompressBytes(bitset)
+		if len(comp) == 0 {
+			continue
+		}
+		if err := bits.Put(bloomBitsKey(section, i), comp); err != nil {
+			return err
+		}
+	}
+
+	// The pending blooms are covered by the bloom bits now.
+	for i := int32(0); i < BloomSectionSize; i++ {
+		if err := blooms.Delete(bloomHeightKey(first + i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DisconnectBlock is invoked by the index manager when a block has been
+// disconnected from the main chain.  The bloom of the block is removed, and
+// when the block completed a section the remaining blooms of that section are
+// restored from its bloom bits.  This is part of the Indexer interface.
+func (idx *BloomIndex) DisconnectBlock(dbTx database.Tx, block *asiutil.Block,
+	_ []txo.SpentTxOut, _ *asiutil.VBlock) error {
+
+	parent := dbTx.Metadata().Bucket(bloomIndexParentBucketKey)
+	blooms := parent.Bucket(bloomByHeightBucketName)
+
+	height := block.Height()
+	if (height+1)%BloomSectionSize != 0 {
+		return blooms.Delete(bloomHeightKey(height))
+	}
+
+	section := uint32(height / BloomSectionSize)
+	var bitsets [types.BloomBitLength][]byte
+	for i := uint(0); i < types.BloomBitLength; i++ {
+		bitset, err := dbFetchBloomBits(dbTx, section, i)
+		if err != nil {
+			return err
+		}
+		bitsets[i] = bitset
+	}
+
+	first := height + 1 - BloomSectionSize
+	for i := int32(0); i < BloomSectionSize-1; i++ {
+		bloom := bloombits.Bloom(bitsets, uint(i))
+		if bloom == (types.Bloom{}) {
+			continue
+		}
+		if err := blooms.Put(bloomHeightKey(first+i), bloom[:]); err != nil {
+			return err
+		}
+	}
+
+	bits := parent.Bucket(bloomBitsBucketName)
+	for i := uint(0); i < types.BloomBitLength; i++ {
+		if err := bits.Delete(bloomBitsKey(section, i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FetchBlockRegion is not supported by the bloom bits index.  This is part of
+// the Indexer interface.
+func (idx *BloomIndex) FetchBlockRegion(key []byte) (*database.BlockRegion, error) {
+	return nil, nil
+}
+
+// MatchHeights returns the heights between begin and end, inclusive, of the
+// blocks whose blooms may contain logs matching the filters.  The filters are
+// groups of keys as described by bloombits.NewMatcher.  Only the complete
+// sections of the index are searched, so the returned next height is the
+// first height of the range which was not covered and still has to be
+// checked block by block.
+//
+// This function is safe for concurrent access.
+func (idx *BloomIndex) MatchHeights(begin, end int32, filters [][][]byte) ([]int32, int32, error) {
+	var heights []int32
+	next, last := begin, end
+	err := idx.db.View(func(dbTx database.Tx) error {
+		_, tipHeight, err := dbFetchIndexerTip(dbTx, bloomIndexParentBucketKey)
+		if err != nil {
+			return err
+		}
+		sections := (tipHeight + 1) / BloomSectionSize
+		if indexed := sections*BloomSectionSize - 1; last > indexed {
+			last = indexed
+		}
+
+		matcher := bloombits.NewMatcher(BloomSectionSize, filters)
+		for next <= last {
+			section := uint32(next / BloomSectionSize)
+			first := int32(section) * BloomSectionSize
+			matches, err := matcher.Match(func(bit uint) ([]byte, error) {
+				return dbFetchBloomBits(dbTx, section, bit)
+			})
+			if err != nil {
+				return err
+			}
+			for _, m := range matches {
+				height := first + int32(m)
+				if height >= next && height <= last {
+					heights = append(heights, height)
+				}
+			}
+			next = first + BloomSectionSize
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, begin, err
+	}
+	if next > end {
+		next = end + 1
+	}
+	return heights, next, nil
+}
+
+// NewBloomIndex returns a new instance of an indexer that is used to create
+// rotated bloom bits of the log blooms of all blocks in the main chain.
+//
+// It implements the Indexer interface which plugs into the IndexManager that
+// in turn is used by the blockchain package.  This allows the index to be
+// seamlessly maintained along with the chain.
+func NewBloomIndex(db database.Transactor) *BloomIndex {
+	log.Info("Bloom bits index is enabled")
+	return &BloomIndex{db: db}
+}
+
+// DropBloomIndex drops the bloom bits index from the provided database if it
+// exists.
+func DropBloomIndex(db database.Transactor, interrupt <-chan struct{}) error {
+	return dropIndex(db, bloomIndexParentBucketKey, bloomIndexName, interrupt)
+}
+
+// BloomFilterKeys converts log criteria into the key groups understood by
+// MatchHeights.
+func BloomFilterKeys(addresses []common.Address, topics [][]common.Hash) [][][]byte {
+	filters := make([][][]byte, 0, len(topics)+1)
+	keys := make([][]byte, 0, len(addresses))
+	for _, addr := range addresses {
+		keys = append(keys, addr.Bytes())
+	}
+	filters = append(filters, keys)
+	for _, sub := range topics {
+		keys := make([][]byte, 0, len(sub))
+		for _, topic := range sub {
+			keys = append(keys, topic.Bytes())
+		}
+		filters = append(filters, keys)
+	}
+	return filters
+}
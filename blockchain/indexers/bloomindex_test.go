@@ -0,0 +1,217 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package indexers
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/chaincfg"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/database"
+	"github.com/AsimovNetwork/asimov/database/dbdriver"
+	"github.com/AsimovNetwork/asimov/protos"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/types"
+)
+
+// newBloomTestBlock returns a block of the given height whose bloom holds the
+// passed addresses.
+func newBloomTestBlock(height int32, addresses ...common.Address) *asiutil.Block {
+	msgBlock := &protos.MsgBlock{}
+	msgBlock.Header.Height = height
+	for _, addr := range addresses {
+		msgBlock.Bloom.Add(new(big.Int).SetBytes(addr.Bytes()))
+	}
+	return asiutil.NewBlock(msgBlock)
+}
+
+// bloomIndexEntries returns the number of pending blooms and bloom bits
+// stored by the index.
+func bloomIndexEntries(t *testing.T, db database.Transactor) (int, int) {
+	var pending, bits int
+	err := db.View(func(dbTx database.Tx) error {
+		parent := dbTx.Metadata().Bucket(bloomIndexParentBucketKey)
+		err := parent.Bucket(bloomByHeightBucketName).ForEach(func(k, v []byte) error {
+			pending++
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return parent.Bucket(bloomBitsBucketName).ForEach(func(k, v []byte) error {
+			bits++
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatalf("View err %v", err)
+	}
+	return pending, bits
+}
+
+func TestBloomIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bloomindex")
+	if err != nil {
+		t.Fatalf("TempDir err %v", err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := dbdriver.Create("ffldb", filepath.Join(dir, "blocks"), chaincfg.DevelopNetParams.Net)
+	if err != nil {
+		t.Fatalf("Create err %v", err)
+	}
+	defer db.Close()
+
+	idx := NewBloomIndex(db)
+	err = db.Update(func(dbTx database.Tx) error {
+		if _, err := dbTx.Metadata().CreateBucket(indexTipsBucketName); err != nil {
+			return err
+		}
+		if err := idx.Create(dbTx); err != nil {
+			return err
+		}
+		return dbPutIndexerTip(dbTx, idx.Key(), &common.Hash{}, -1)
+	})
+	if err != nil {
+		t.Fatalf("Create index err %v", err)
+	}
+
+	watched := common.HexToAddress("0x66e3054b411051da5492aec7a823b00cb3add772d7")
+	other := common.HexToAddress("0x6632c6ca48ac6a0c86e9b70f4e96f8e9b3cd88d1c6")
+	matchHeights := map[int32]bool{5: true, BloomSectionSize - 1: true, BloomSectionSize + 1: true}
+	blockOf := func(height int32) *asiutil.Block {
+		if matchHeights[height] {
+			return newBloomTestBlock(height, watched)
+		}
+		if height%2 == 0 {
+			return newBloomTestBlock(height, other)
+		}
+		return newBloomTestBlock(height)
+	}
+	connect := func(height int32) {
+		err := db.Update(func(dbTx database.Tx) error {
+			if err := idx.ConnectBlock(dbTx, blockOf(height), nil, nil); err != nil {
+				return err
+			}
+			return dbPutIndexerTip(dbTx, idx.Key(), &common.Hash{}, height)
+		})
+		if err != nil {
+			t.Fatalf("ConnectBlock %d err %v", height, err)
+		}
+	}
+	disconnect := func(height int32) {
+		err := db.Update(func(dbTx database.Tx) error {
+			if err := idx.DisconnectBlock(dbTx, blockOf(height), nil, nil); err != nil {
+				return err
+			}
+			return dbPutIndexerTip(dbTx, idx.Key(), &common.Hash{}, height-1)
+		})
+		if err != nil {
+			t.Fatalf("DisconnectBlock %d err %v", height, err)
+		}
+	}
+	filters := BloomFilterKeys([]common.Address{watched}, nil)
+
+	// Until its last block, the blooms of the section are pending and no
+	// height is matched from the index.
+	for height := int32(0); height < BloomSectionSize-1; height++ {
+		connect(height)
+	}
+	if pending, bits := bloomIndexEntries(t, db); pending != BloomSectionSize/2+1 || bits != 0 {
+		t.Fatalf("pending section has %d blooms and %d bits", pending, bits)
+	}
+	heights, next, err := idx.MatchHeights(0, BloomSectionSize-2, filters)
+	if err != nil {
+		t.Fatalf("MatchHeights err %v", err)
+	}
+	if len(heights) != 0 || next != 0 {
+		t.Fatalf("pending section matched %v, next %d", heights, next)
+	}
+
+	// Connecting the last block rotates the section into bloom bits.
+	connect(BloomSectionSize - 1)
+	pending, bits := bloomIndexEntries(t, db)
+	if pending != 0 || bits == 0 {
+		t.Fatalf("complete section has %d blooms and %d bits", pending, bits)
+	}
+	connect(BloomSectionSize)
+	connect(BloomSectionSize + 1)
+	heights, next, err = idx.MatchHeights(0, BloomSectionSize+1, filters)
+	if err != nil {
+		t.Fatalf("MatchHeights err %v", err)
+	}
+	if expected := []int32{5, BloomSectionSize - 1}; !reflect.DeepEqual(heights, expected) {
+		t.Errorf("matched %v, expected %v", heights, expected)
+	}
+	if next != BloomSectionSize {
+		t.Errorf("next height %d, expected %d", next, BloomSectionSize)
+	}
+	heights, _, err = idx.MatchHeights(6, BloomSectionSize-2, filters)
+	if err != nil {
+		t.Fatalf("MatchHeights err %v", err)
+	}
+	if len(heights) != 0 {
+		t.Errorf("matched %v out of the range", heights)
+	}
+
+	// Disconnecting the last block of the section restores its pending
+	// blooms, and connecting it again rotates the same bits.
+	disconnect(BloomSectionSize + 1)
+	disconnect(BloomSectionSize)
+	disconnect(BloomSectionSize - 1)
+	if pending, bits := bloomIndexEntries(t, db); pending != BloomSectionSize/2+1 || bits != 0 {
+		t.Fatalf("disconnected section has %d blooms and %d bits", pending, bits)
+	}
+	err = db.View(func(dbTx database.Tx) error {
+		blooms := dbTx.Metadata().Bucket(bloomIndexParentBucketKey).Bucket(bloomByHeightBucketName)
+		for _, height := range []int32{5, 6, BloomSectionSize - 2} {
+			data := blooms.Get(bloomHeightKey(height))
+			if types.BytesToBloom(data) != blockOf(height).MsgBlock().Bloom {
+				t.Errorf("restored bloom of height %d mismatch", height)
+			}
+		}
+		if blooms.Get(bloomHeightKey(7)) != nil {
+			t.Errorf("restored an empty bloom")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("View err %v", err)
+	}
+	connect(BloomSectionSize - 1)
+	if _, rebuilt := bloomIndexEntries(t, db); rebuilt != bits {
+		t.Errorf("reconnected section has %d bits, expected %d", rebuilt, bits)
+	}
+	heights, _, err = idx.MatchHeights(0, BloomSectionSize-1, filters)
+	if err != nil {
+		t.Fatalf("MatchHeights err %v", err)
+	}
+	if expected := []int32{5, BloomSectionSize - 1}; !reflect.DeepEqual(heights, expected) {
+		t.Errorf("matched %v after reconnect, expected %v", heights, expected)
+	}
+
+	// Dropping the index removes its buckets and its tip.
+	if err := DropBloomIndex(db, nil); err != nil {
+		t.Fatalf("DropBloomIndex err %v", err)
+	}
+	err = db.View(func(dbTx database.Tx) error {
+		meta := dbTx.Metadata()
+		if meta.Bucket(bloomIndexParentBucketKey) != nil {
+			t.Errorf("bloom index bucket not dropped")
+		}
+		tips := meta.Bucket(indexTipsBucketName)
+		if tips.Get(idx.Key()) != nil || tips.Get(indexDropKey(idx.Key())) != nil {
+			t.Errorf("bloom index tip not dropped")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("View err %v", err)
+	}
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/AsimovNetwork/asimov/blockchain"
 	"github.com/AsimovNetwork/asimov/database"
 	"github.com/AsimovNetwork/asimov/protos"
+	"github.com/AsimovNetwork/asimov/rpcs/rawdb"
 )
 
 const (
@@ -22,9 +23,13 @@ const (
 	cfIndexName = "committed filter index"
 )
 
-// Committed filters come in one flavor currently: basic. They are generated
-// and dropped in pairs, and both are indexed by a block's hash.  Besides
-// holding different content, they also live in different buckets.
+// Committed filters come in two flavors: basic and contract. They are generated
+// and dropped together, and all are indexed by a block's hash.  Besides
+// holding different content, they also live in different buckets.  The
+// contract filters need the receipts of the blocks, which are missing for the
+// blocks connected without execution by the state sync.  Those blocks have no
+// contract filter, and since a filter header commits to the previous ones, the
+// blocks following them have no contract filter header either.
 var (
 	// cfIndexParentBucketKey is the name of the parent bucket used to
 	// house the index. The rest of the buckets live below this bucket.
@@ -34,18 +39,21 @@ var (
 	// block hashes to cfilters.
 	cfIndexKeys = [][]byte{
 		[]byte("cf0byhashidx"),
+		[]byte("cf1byhashidx"),
 	}
 
 	// cfHeaderKeys is an array of db bucket names used to house indexes of
 	// block hashes to cf headers.
 	cfHeaderKeys = [][]byte{
 		[]byte("cf0headerbyhashidx"),
+		[]byte("cf1headerbyhashidx"),
 	}
 
 	// cfHashKeys is an array of db bucket names used to house indexes of
 	// block hashes to cf hashes.
 	cfHashKeys = [][]byte{
 		[]byte("cf0hashbyhashidx"),
+		[]byte("cf1hashbyhashidx"),
 	}
 
 	maxFilterType = uint8(len(cfHeaderKeys) - 1)
@@ -76,7 +84,8 @@ func dbDeleteFilterIdxEntry(dbTx database.Tx, key []byte, h *common.Hash) error
 
 // CfIndex implements a committed filter (cf) by hash index.
 type CfIndex struct {
-	db database.Transactor
+	db      database.Transactor
+	stateDB database.Database
 }
 
 // Ensure the CfIndex type implements the Indexer interface.
@@ -112,8 +121,8 @@ func (idx *CfIndex) Name() string {
 }
 
 // Create is invoked when the indexer manager determines the index needs to
-// be created for the first time. It creates buckets for the two hash-based cf
-// indexes (regular only currently).
+// be created for the first time. It creates buckets for the hash-based cf
+// indexes of every filter type.
 func (idx *CfIndex) Create(dbTx database.Tx) error {
 	meta := dbTx.Metadata()
 
@@ -156,6 +165,20 @@ func (idx *CfIndex) Check(dbTx database.Tx) error {
 		return err
 	}
 
+	// The filter headers of a type chain every block, so the index is
+	// rebuilt from the genesis block when it lacks the buckets of a type
+	// added after it was created.
+	for _, bucketName := range cfIndexKeys {
+		if cfIndexParentBucket.Bucket(bucketName) == nil {
+			log.Infof("Rebuilding the %s for the new filter types", cfIndexName)
+			err = dbPutIndexerTip(dbTx, cfIndexParentBucketKey, &common.Hash{}, -1)
+			if err != nil {
+				return err
+			}
+			break
+		}
+	}
+
 	for _, bucketName := range cfIndexKeys {
 		_, err = cfIndexParentBucket.CreateBucketIfNotExists(bucketName)
 		if err != nil {
@@ -225,6 +248,11 @@ func storeFilter(dbTx database.Tx, block *asiutil.Block, f *gcs.Filter,
 			return err
 		}
 
+		// The contract filter header chain stops at the first block
+		// connected without its receipts.
+		if pfh == nil && filterType == protos.GCSFilterContract {
+			return nil
+		}
 		if len(pfh) != common.HashLength {
 			return common.AssertError(fmt.Sprintf("invalid hash length of %v, want %v", len(pfh),
 				common.HashLength))
@@ -257,7 +285,28 @@ func (idx *CfIndex) ConnectBlock(dbTx database.Tx, block *asiutil.Block,
 		return err
 	}
 
-	return storeFilter(dbTx, block, f, protos.GCSFilterRegular)
+	err = storeFilter(dbTx, block, f, protos.GCSFilterRegular)
+	if err != nil {
+		return err
+	}
+
+	var msgvblock *protos.MsgVBlock
+	if vblock != nil {
+		msgvblock = vblock.MsgVBlock()
+	}
+	// The blocks connected without execution have no receipts, so no
+	// contract filter.
+	receipts := rawdb.ReadReceipts(idx.stateDB, *block.Hash(), uint64(block.Height()))
+	if receipts == nil {
+		return nil
+	}
+	f, err = builder.BuildContractFilter(block.MsgBlock(), prevScripts,
+		msgvblock, receipts)
+	if err != nil {
+		return err
+	}
+
+	return storeFilter(dbTx, block, f, protos.GCSFilterContract)
 }
 
 // DisconnectBlock is invoked by the index manager when a block has been
@@ -375,6 +424,14 @@ func (idx *CfIndex) FilterHashesByBlockHashes(blockHashes []*common.Hash,
 	return idx.entriesByBlockHashes(cfHashKeys, filterType, blockHashes)
 }
 
+// HasContractFilters returns whether the index holds the contract filter
+// header of the passed block, which commits to the contract filters of all its
+// ancestors.
+func (idx *CfIndex) HasContractFilters(h *common.Hash) bool {
+	header, err := idx.entryByBlockHash(cfHeaderKeys, protos.GCSFilterContract, h)
+	return err == nil && len(header) == common.HashLength
+}
+
 func (idx *CfIndex) FetchBlockRegion(thash []byte) (*database.BlockRegion, error) {
 	return nil, nil
 }
@@ -386,7 +443,10 @@ func (idx *CfIndex) FetchBlockRegion(thash []byte) (*database.BlockRegion, error
 // It implements the Indexer interface which plugs into the IndexManager that
 // in turn is used by the blockchain package. This allows the index to be
 // seamlessly maintained along with the chain.
-func NewCfIndex(db database.Transactor) *CfIndex {
+//
+// The receipts the contract filters commit to are read from the passed state
+// database.
+func NewCfIndex(db database.Transactor, stateDB database.Database) *CfIndex {
 	log.Info("Committed filter index is enabled")
-	return &CfIndex{db: db}
+	return &CfIndex{db: db, stateDB: stateDB}
 }
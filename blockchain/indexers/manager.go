@@ -187,7 +187,10 @@ func (m *Manager) maybeFinishDrops(interrupt <-chan struct{}) error {
 		}
 
 		log.Infof("Resuming %s drop", indexer.Name())
-
+		err := dropIndex(m.db, indexer.Key(), indexer.Name(), interrupt)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -348,6 +351,38 @@ func (m *Manager) Init(chain *blockchain.BlockChain, interrupt <-chan struct{})
 		}
 	}
 
+	// The blocks preceding the snapshot the chain was bootstrapped from are
+	// not available, so the indexes only cover the blocks after it.
+	if snapshotHeight := chain.SnapshotHeight(); snapshotHeight > 0 {
+		snapshotHash, err := chain.BlockHashByHeight(snapshotHeight)
+		if err != nil {
+			return err
+		}
+		err = m.db.Update(func(dbTx database.Tx) error {
+			for _, indexer := range m.enabledIndexes {
+				idxKey := indexer.Key()
+				_, height, err := dbFetchIndexerTip(dbTx, idxKey)
+				if err != nil {
+					return err
+				}
+				if height >= snapshotHeight {
+					continue
+				}
+
+				log.Infof("Starting %s at the snapshot height %d",
+					indexer.Name(), snapshotHeight)
+				err = dbPutIndexerTip(dbTx, idxKey, snapshotHash, snapshotHeight)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
 	// Fetch the current tip heights for each index along with tracking the
 	// lowest one so the catchup code only needs to start at the earliest
 	// block and is able to skip connecting the block for the indexes that
@@ -510,3 +545,147 @@ func NewManager(db database.Transactor, enabledIndexes []blockchain.Indexer) *Ma
 		enabledIndexes: enabledIndexes,
 	}
 }
+
+// incrementalDrop uses multiple database updates to remove the key/value pairs
+// of the given bucket, including the ones of its sub buckets, so a large
+// index does not have to be deleted in a single transaction.
+func incrementalDrop(db database.Transactor, bucketPath [][]byte, idxName string,
+	interrupt <-chan struct{}) error {
+
+	bucketOf := func(dbTx database.Tx) database.Bucket {
+		bucket := dbTx.Metadata()
+		for _, key := range bucketPath {
+			bucket = bucket.Bucket(key)
+			if bucket == nil {
+				return nil
+			}
+		}
+		return bucket
+	}
+
+	// Sub buckets are emptied first.
+	var subBuckets [][]byte
+	err := db.View(func(dbTx database.Tx) error {
+		bucket := bucketOf(dbTx)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEachBucket(func(k []byte) error {
+			subBuckets = append(subBuckets, append([]byte(nil), k...))
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+	for _, sub := range subBuckets {
+		subPath := append(append([][]byte(nil), bucketPath...), sub)
+		if err := incrementalDrop(db, subPath, idxName, interrupt); err != nil {
+			return err
+		}
+	}
+
+	const maxDeletions = 2000000
+	var totalDeleted uint64
+	for numDeleted := maxDeletions; numDeleted == maxDeletions; {
+		numDeleted = 0
+		err := db.Update(func(dbTx database.Tx) error {
+			bucket := bucketOf(dbTx)
+			if bucket == nil {
+				return nil
+			}
+			cursor := bucket.Cursor()
+			for ok := cursor.First(); ok && numDeleted < maxDeletions; ok = cursor.Next() {
+				// Sub buckets are removed with their parent.
+				if cursor.Value() == nil {
+					continue
+				}
+				if err := cursor.Delete(); err != nil {
+					return err
+				}
+				numDeleted++
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if numDeleted > 0 {
+			totalDeleted += uint64(numDeleted)
+			log.Infof("Deleted %d keys (%d total) from %s",
+				numDeleted, totalDeleted, idxName)
+		}
+
+		if interruptRequested(interrupt) {
+			return errInterruptRequested
+		}
+	}
+	return nil
+}
+
+// dropIndex drops the passed index from the database.  Since indexes can be
+// massive, it deletes the index in multiple database transactions in order to
+// keep memory usage to reasonable levels.  It also marks the drop in progress
+// so the drop can be resumed if it is stopped before it is done before the
+// index can be used again.
+func dropIndex(db database.Transactor, idxKey []byte, idxName string, interrupt <-chan struct{}) error {
+	// Nothing to do if the index doesn't already exist.
+	var needsDelete bool
+	err := db.View(func(dbTx database.Tx) error {
+		indexesBucket := dbTx.Metadata().Bucket(indexTipsBucketName)
+		if indexesBucket != nil && indexesBucket.Get(idxKey) != nil {
+			needsDelete = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !needsDelete {
+		log.Infof("Not dropping %s because it does not exist", idxName)
+		return nil
+	}
+
+	// Mark that the index is in the process of being dropped so that it
+	// can be resumed on the next start if interrupted before the process is
+	// complete.
+	log.Infof("Dropping all %s entries.  This might take a while...",
+		idxName)
+	err = db.Update(func(dbTx database.Tx) error {
+		indexesBucket := dbTx.Metadata().Bucket(indexTipsBucketName)
+		return indexesBucket.Put(indexDropKey(idxKey), idxKey)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = incrementalDrop(db, [][]byte{idxKey}, idxName, interrupt)
+	if err != nil {
+		return err
+	}
+
+	// Remove the index tip, index bucket, and in-progress drop flag now
+	// that all index entries have been removed.
+	err = db.Update(func(dbTx database.Tx) error {
+		meta := dbTx.Metadata()
+		indexesBucket := meta.Bucket(indexTipsBucketName)
+		if err := indexesBucket.Delete(idxKey); err != nil {
+			return err
+		}
+
+		if meta.Bucket(idxKey) != nil {
+			if err := meta.DeleteBucket(idxKey); err != nil {
+				return err
+			}
+		}
+
+		return indexesBucket.Delete(indexDropKey(idxKey))
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Dropped %s", idxName)
+	return nil
+}
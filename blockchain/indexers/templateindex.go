@@ -209,6 +209,31 @@ func (idx *TemplateIndex) FetchBlockRegion(txhash []byte) (*database.BlockRegion
 	return region, err
 }
 
+// ForEachTemplate invokes the passed function with the hash and the data of
+// every template in the index of the passed database.  It is meant for the
+// chain state snapshots, which carry the templates since the blocks housing
+// them are not part of the snapshots.
+func ForEachTemplate(db database.Transactor, fn func(hash *common.Hash, data []byte) error) error {
+	return db.View(func(dbTx database.Tx) error {
+		templateIndex := dbTx.Metadata().Bucket(templateIndexKey)
+		if templateIndex == nil {
+			return nil
+		}
+		return templateIndex.ForEach(func(k, _ []byte) error {
+			region, err := dbFetchTemplateIndexEntry(dbTx, k)
+			if err != nil {
+				return err
+			}
+			data, err := dbTx.FetchBlockRegion(region)
+			if err != nil {
+				return err
+			}
+			hash := common.BytesToHash(k)
+			return fn(&hash, data)
+		})
+	})
+}
+
 // NewTemplateIndex returns a new instance of an indexer that is used to create a
 // mapping of the hashes of all templates in the blockchain to the respective
 // block, location within the block, and size of the template.
@@ -107,3 +107,30 @@ func BuildMerkleTreeStore(transactions []*asiutil.Tx) []*common.Hash {
 	return merkles
 }
 
+// BuildMerkleBranch returns the sibling hashes on the path from the leaf at
+// the passed index to the root of a merkle tree stored as a linear array by
+// BuildMerkleTreeStore, from the bottom up.  When a node has no right
+// sibling, the node itself is returned in place of the sibling since the
+// parent is calculated by concatenating the node with itself.
+//
+// The leaf is proven by hashing it with the branch, and an index bit of 1 at
+// a level means the node is the right child at that level.
+func BuildMerkleBranch(merkles []*common.Hash, index int) []*common.Hash {
+	width := (len(merkles) + 1) / 2
+	if index < 0 || index >= width || merkles[index] == nil {
+		return nil
+	}
+
+	branch := make([]*common.Hash, 0)
+	offset := 0
+	for ; width > 1; width /= 2 {
+		sibling := merkles[offset+(index^1)]
+		if sibling == nil {
+			sibling = merkles[offset+index]
+		}
+		branch = append(branch, sibling)
+		offset += width
+		index /= 2
+	}
+	return branch
+}
@@ -6,6 +6,7 @@ package blockchain
 
 import (
 	"fmt"
+	"github.com/AsimovNetwork/asimov/asiutil"
 	"github.com/AsimovNetwork/asimov/protos"
 	"testing"
 	"github.com/AsimovNetwork/asimov/chaincfg"
@@ -35,3 +36,38 @@ func TestMerkle(t *testing.T) {
 			calculatedMerkleRoot, wantMerkle)
 	}
 }
+
+// TestMerkleBranch ensures the branches built by BuildMerkleBranch lead from
+// every transaction to the merkle root, whatever the number of transactions.
+func TestMerkleBranch(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		txs := make([]*asiutil.Tx, 0, n)
+		for i := 0; i < n; i++ {
+			tx := protos.NewMsgTx(protos.TxVersion)
+			tx.LockTime = uint32(i)
+			txs = append(txs, asiutil.NewTx(tx))
+		}
+		merkles := BuildMerkleTreeStore(txs)
+		root := merkles[len(merkles)-1]
+
+		for i, tx := range txs {
+			branch := BuildMerkleBranch(merkles, i)
+			hash := tx.Hash()
+			index := i
+			for _, sibling := range branch {
+				if index&1 == 0 {
+					hash = HashMerkleBranches(hash, sibling)
+				} else {
+					hash = HashMerkleBranches(sibling, hash)
+				}
+				index >>= 1
+			}
+			if *hash != *root {
+				t.Errorf("branch of tx %d of %d leads to %v, want %v", i, n, hash, root)
+			}
+		}
+		if branch := BuildMerkleBranch(merkles, n); branch != nil {
+			t.Errorf("got a branch for tx %d of %d", n, n)
+		}
+	}
+}
@@ -200,6 +200,17 @@ func (b *BlockChain) ProcessBlock(block *asiutil.Block, vblock *asiutil.VBlock,
 		return false, false, ruleError(ErrDuplicateBlock, str)
 	}
 
+	// The blocks which are not executed must be committed to by a
+	// checkpoint, since neither their virtual block nor their signatures
+	// are verified.
+	if flags&common.BFNoExecute == common.BFNoExecute {
+		checkpoint := b.LatestCheckpoint()
+		if checkpoint == nil || block.Height() > checkpoint.Height {
+			return false, false, common.AssertError("ProcessBlock called " +
+				"without executing a block past the latest checkpoint")
+		}
+	}
+
 	fastAdd := flags&common.BFFastAdd == common.BFFastAdd
 	if !fastAdd {
 		// parent may results nil
@@ -0,0 +1,416 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/blockchain/txo"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/database"
+	"github.com/AsimovNetwork/asimov/protos"
+	"github.com/AsimovNetwork/asimov/txscript"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/state"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/types"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/vm"
+)
+
+// ReplayedTx describes the outcome of the re-execution of a transaction of a
+// block of the main chain.
+type ReplayedTx struct {
+	Tx      *asiutil.Tx
+	Receipt *types.Receipt
+	GasUsed uint64
+
+	// Vtx is the virtual transaction generated by the contract executions
+	// of the transaction, or nil when they transferred no assets.
+	Vtx *protos.MsgTx
+
+	// Storage are the contract storage slots changed by the transaction.
+	Storage []StorageChange
+
+	// Balances are the balances changed by the transaction and its virtual
+	// transaction.
+	Balances []BalanceChange
+}
+
+// StorageChange is a contract storage slot changed by a replayed transaction.
+type StorageChange struct {
+	Address common.Address
+	Key     common.Hash
+	Pre     common.Hash
+	Post    common.Hash
+}
+
+// BalanceChange is the balance of an asset held by an address changed by a
+// replayed transaction.  The balance of an indivisible asset is the number of
+// vouchers held.
+type BalanceChange struct {
+	Address common.Address
+	Asset   protos.Asset
+	Pre     int64
+	Post    int64
+}
+
+// ReplayTracerFunc returns the tracer which is attached to the contract
+// executions of the transaction at the passed index of a replayed block, or
+// nil to replay it without tracing.
+type ReplayTracerFunc func(txIdx int, tx *asiutil.Tx) vm.Tracer
+
+// ReplayBlock re-executes the transactions of the main chain block with the
+// passed hash on top of the state of its parent, up to and including the one
+// at index last, or all of them when last is negative.
+//
+// The utxos spent by the block are restored from the spend journal, so the
+// inputs are the same the block was connected with.  Note the balances read
+// by contracts also include the outputs of the current utxo set, which makes
+// the replay of blocks deep in the chain an approximation.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) ReplayBlock(hash *common.Hash, last int, tracerFn ReplayTracerFunc) ([]*ReplayedTx, error) {
+	node := b.index.LookupNode(hash)
+	if node == nil || !b.bestChain.Contains(node) {
+		return nil, fmt.Errorf("block %v is not in the main chain", hash)
+	}
+	if node.parent == nil {
+		return nil, fmt.Errorf("block %v has no parent state to replay on", hash)
+	}
+
+	block, view, err := b.fetchReplayView(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	statedb, err := state.New(node.parent.stateRoot, b.stateCache)
+	if err != nil {
+		return nil, fmt.Errorf("state of block %v is not available: %v",
+			node.parent.hash, err)
+	}
+
+	transactions := block.Transactions()
+	if last < 0 || last >= len(transactions) {
+		last = len(transactions) - 1
+	}
+	replayed := make([]*ReplayedTx, 0, last+1)
+	deltas := make([][]balanceDelta, 0, last+1)
+	for i, tx := range transactions[:last+1] {
+		fee, _, err := CheckTransactionInputs(tx, node.height, view, b)
+		if err != nil {
+			return nil, err
+		}
+
+		recorder := newStorageRecorder()
+		if tracerFn != nil {
+			recorder.tracer = tracerFn(i, tx)
+		}
+		vmConfig := *b.GetVmConfig()
+		vmConfig.Debug = true
+		vmConfig.Tracer = recorder
+
+		var spent []txo.SpentTxOut
+		statedb.Prepare(*tx.Hash(), *block.Hash(), i)
+		receipt, err, gasUsed, vtx, _ := b.connectTransaction(block, i, view,
+			tx, &spent, statedb, fee, &vmConfig)
+		if err != nil {
+			return nil, err
+		}
+		replayed = append(replayed, &ReplayedTx{
+			Tx:      tx,
+			Receipt: receipt,
+			GasUsed: gasUsed,
+			Vtx:     vtx,
+			Storage: recorder.changes(statedb),
+		})
+		deltas = append(deltas, balanceDeltas(view, tx.MsgTx(), vtx, spent))
+	}
+
+	// The balances before each transaction are the ones at the parent
+	// block plus the changes made by the previous transactions of the
+	// block.
+	balances := make(map[balanceKey]int64)
+	for _, txDeltas := range deltas {
+		for _, delta := range txDeltas {
+			balances[delta.key] = 0
+		}
+	}
+	err = b.fetchParentBalances(node, balances)
+	if err != nil {
+		return nil, err
+	}
+	for i, txDeltas := range deltas {
+		changes := make([]BalanceChange, 0, len(txDeltas))
+		for _, delta := range txDeltas {
+			pre := balances[delta.key]
+			balances[delta.key] = pre + delta.amount
+			changes = append(changes, BalanceChange{
+				Address: delta.key.address,
+				Asset:   delta.key.asset,
+				Pre:     pre,
+				Post:    pre + delta.amount,
+			})
+		}
+		replayed[i].Balances = changes
+	}
+
+	return replayed, nil
+}
+
+// fetchParentBalances sets the balances of the passed addresses and assets
+// to the ones they held at the parent of the passed main chain block.  They
+// are made of the outputs of the current utxo set which were created up to
+// the parent, and of the outputs created up to the parent which the later
+// blocks spent, as recorded by their spend journals.
+func (b *BlockChain) fetchParentBalances(node *blockNode, balances map[balanceKey]int64) error {
+	b.chainLock.RLock()
+	defer b.chainLock.RUnlock()
+
+	if !b.bestChain.Contains(node) {
+		return fmt.Errorf("block %v is not in the main chain", node.hash)
+	}
+	height := node.parent.height
+	addBalance := func(pkScript []byte, asset *protos.Asset, amount int64, created int32) {
+		if asset == nil || amount == 0 || created > height {
+			return
+		}
+		_, addrs, _, err := txscript.ExtractPkScriptAddrs(pkScript)
+		if err != nil || len(addrs) == 0 {
+			return
+		}
+		key := balanceKey{addrs[0].StandardAddress(), *asset}
+		if _, ok := balances[key]; !ok {
+			return
+		}
+		if asset.IsIndivisible() {
+			amount = 1
+		}
+		balances[key] += amount
+	}
+
+	addresses := make(map[common.Address]struct{})
+	for key := range balances {
+		addresses[key.address] = struct{}{}
+	}
+	err := b.db.View(func(dbTx database.Tx) error {
+		for address := range addresses {
+			entryPairs, err := dbFetchBalance(dbTx, address[:])
+			if err != nil {
+				return err
+			}
+			for _, entryPair := range *entryPairs {
+				entry := entryPair.Value
+				if entry.IsSpent() {
+					continue
+				}
+				addBalance(entry.PkScript(), entry.Asset(), entry.Amount(),
+					entry.BlockHeight())
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for later := b.bestChain.Tip(); later != nil && later.height > height; later = later.parent {
+		block, vblock, err := asiutil.GetBlockPair(b.db, &later.hash)
+		if err != nil {
+			return err
+		}
+		var stxos []txo.SpentTxOut
+		err = b.db.View(func(dbTx database.Tx) error {
+			stxos, err = dbFetchSpendJournalEntry(dbTx, block, vblock)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		for i := range stxos {
+			addBalance(stxos[i].PkScript, stxos[i].Asset, stxos[i].Amount,
+				stxos[i].Height)
+		}
+	}
+	return nil
+}
+
+// fetchReplayView loads the main chain block with the passed hash and the
+// view of its parent for every output the block creates or spends, which is
+// what the transactions of the block have to be connected with to re-execute
+// them.
+func (b *BlockChain) fetchReplayView(hash *common.Hash) (*asiutil.Block, *txo.UtxoViewpoint, error) {
+	block, vblock, err := asiutil.GetBlockPair(b.db, hash)
+	if err != nil {
+		return nil, nil, err
+	}
+	var stxos []txo.SpentTxOut
+	err = b.db.View(func(dbTx database.Tx) error {
+		stxos, err = dbFetchSpendJournalEntry(dbTx, block, vblock)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Disconnecting the block from an empty view yields the view of its
+	// parent for every output the block creates or spends.
+	view := txo.NewUtxoViewpoint()
+	err = disconnectTransactions(view, b.db, block, stxos, vblock)
+	if err != nil {
+		return nil, nil, err
+	}
+	return block, view, nil
+}
+
+// balanceKey identifies the balance of an asset held by an address.
+type balanceKey struct {
+	address common.Address
+	asset   protos.Asset
+}
+
+// balanceDelta is the change of a balance made by a replayed transaction.
+type balanceDelta struct {
+	key    balanceKey
+	amount int64
+}
+
+// balanceDeltas returns the changes of the balances made by a replayed
+// transaction and its virtual transaction, which are the outputs they created
+// less the ones they spent.  The change of an indivisible asset is the number
+// of vouchers gained or lost.
+func balanceDeltas(view *txo.UtxoViewpoint, tx *protos.MsgTx, vtx *protos.MsgTx,
+	spent []txo.SpentTxOut) []balanceDelta {
+
+	deltas := make(map[balanceKey]int64)
+	var keys []balanceKey
+	addDelta := func(pkScript []byte, asset *protos.Asset, amount int64) {
+		if asset == nil || amount == 0 {
+			return
+		}
+		_, addrs, _, err := txscript.ExtractPkScriptAddrs(pkScript)
+		if err != nil || len(addrs) == 0 {
+			return
+		}
+		if asset.IsIndivisible() {
+			if amount > 0 {
+				amount = 1
+			} else {
+				amount = -1
+			}
+		}
+		key := balanceKey{addrs[0].StandardAddress(), *asset}
+		if _, ok := deltas[key]; !ok {
+			keys = append(keys, key)
+		}
+		deltas[key] += amount
+	}
+
+	for i := range spent {
+		addDelta(spent[i].PkScript, spent[i].Asset, -spent[i].Amount)
+	}
+	// The outputs are read back from the view since the outputs funding a
+	// contract creation are reassigned to the new contract.
+	for _, msgTx := range []*protos.MsgTx{tx, vtx} {
+		if msgTx == nil {
+			continue
+		}
+		prevOut := protos.OutPoint{Hash: msgTx.TxHash()}
+		for txOutIdx := range msgTx.TxOut {
+			prevOut.Index = uint32(txOutIdx)
+			entry := view.LookupEntry(prevOut)
+			if entry == nil {
+				continue
+			}
+			addDelta(entry.PkScript(), entry.Asset(), entry.Amount())
+		}
+	}
+
+	changes := make([]balanceDelta, 0, len(keys))
+	for _, key := range keys {
+		if deltas[key] == 0 {
+			continue
+		}
+		changes = append(changes, balanceDelta{key, deltas[key]})
+	}
+	return changes
+}
+
+// storageRecorder is an FVM tracer which records the value of every storage
+// slot before it is first written by a transaction.  It forwards all events
+// to the tracer of the transaction, if any.
+type storageRecorder struct {
+	tracer vm.Tracer
+
+	pre   map[common.Address]map[common.Hash]common.Hash
+	slots []StorageChange
+}
+
+// newStorageRecorder returns a new storage recorder.
+func newStorageRecorder() *storageRecorder {
+	return &storageRecorder{
+		pre: make(map[common.Address]map[common.Hash]common.Hash),
+	}
+}
+
+// CaptureStart implements the vm.Tracer interface.
+func (r *storageRecorder) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	if r.tracer == nil {
+		return nil
+	}
+	return r.tracer.CaptureStart(from, to, create, input, gas, value)
+}
+
+// CaptureState implements the vm.Tracer interface to record the value of
+// the slots written by SSTORE.
+func (r *storageRecorder) CaptureState(env *vm.FVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if op == vm.SSTORE && err == nil && len(stack.Data()) >= 2 {
+		addr := contract.Address()
+		key := common.BigToHash(stack.Back(0))
+		slots, ok := r.pre[addr]
+		if !ok {
+			slots = make(map[common.Hash]common.Hash)
+			r.pre[addr] = slots
+		}
+		if _, ok := slots[key]; !ok {
+			slots[key] = env.StateDB.GetState(addr, key)
+			r.slots = append(r.slots, StorageChange{Address: addr, Key: key})
+		}
+	}
+	if r.tracer == nil {
+		return nil
+	}
+	return r.tracer.CaptureState(env, pc, op, gas, cost, memory, stack, contract, depth, err)
+}
+
+// CaptureFault implements the vm.Tracer interface.
+func (r *storageRecorder) CaptureFault(env *vm.FVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if r.tracer == nil {
+		return nil
+	}
+	return r.tracer.CaptureFault(env, pc, op, gas, cost, memory, stack, contract, depth, err)
+}
+
+// CaptureEnd implements the vm.Tracer interface.
+func (r *storageRecorder) CaptureEnd(output []byte, gasUsed uint64, t time.Duration, err error) error {
+	if r.tracer == nil {
+		return nil
+	}
+	return r.tracer.CaptureEnd(output, gasUsed, t, err)
+}
+
+// changes returns the recorded slots whose value in the passed state differs
+// from the one they had before they were first written.
+func (r *storageRecorder) changes(statedb *state.StateDB) []StorageChange {
+	var changes []StorageChange
+	for _, slot := range r.slots {
+		slot.Pre = r.pre[slot.Address][slot.Key]
+		slot.Post = statedb.GetState(slot.Address, slot.Key)
+		if slot.Pre != slot.Post {
+			changes = append(changes, slot)
+		}
+	}
+	return changes
+}
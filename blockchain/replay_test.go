@@ -0,0 +1,166 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/blockchain/txo"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/protos"
+	"github.com/AsimovNetwork/asimov/rpcs/rawdb"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/vm"
+)
+
+// TestReplayBlock ensures the replay of a main chain block reproduces the
+// receipts it was connected with.
+func TestReplayBlock(t *testing.T) {
+	parivateKeyList := []string{
+		"0xd0f0461b7b4d26cf370e6c73b58ef7fa26e8e30853a8cee901ed42cf0879cb6e", //privateKey0
+	}
+	accList, netParam, chain, teardownFunc, err := createFakeChainByPrivateKeys(parivateKeyList, 10)
+	defer teardownFunc()
+	if err != nil {
+		t.Fatalf("create fake chain error %v", err)
+	}
+
+	validators, filters, _ := chain.GetValidatorsByNode(1, chain.bestChain.tip())
+	for i := 0; i < 3; i++ {
+		block, _, err := createAndSignBlock(netParam, accList, validators, filters, chain, 1,
+			uint16(i), chain.bestChain.height(), protos.Asset{}, 0,
+			validators[i], nil, 0, chain.bestChain.tip())
+		if err != nil {
+			t.Fatalf("create block error %v", err)
+		}
+		if _, _, err = chain.ProcessBlock(block, nil, nil, nil, common.BFNone); err != nil {
+			t.Fatalf("ProcessBlock err %v", err)
+		}
+	}
+
+	tip := chain.bestChain.tip()
+	block, err := chain.BlockByHash(&tip.hash)
+	if err != nil {
+		t.Fatalf("BlockByHash err %v", err)
+	}
+	tracers := make(map[int]*vm.CallTracer)
+	replayed, err := chain.ReplayBlock(&tip.hash, -1, func(i int, tx *asiutil.Tx) vm.Tracer {
+		tracers[i] = vm.NewCallTracer()
+		return tracers[i]
+	})
+	if err != nil {
+		t.Fatalf("ReplayBlock err %v", err)
+	}
+	if len(replayed) != len(block.Transactions()) {
+		t.Fatalf("replayed %d txs, want %d", len(replayed), len(block.Transactions()))
+	}
+	if len(tracers) != len(replayed) {
+		t.Errorf("got %d tracers, want %d", len(tracers), len(replayed))
+	}
+
+	receipts := rawdb.ReadReceipts(chain.EthDB(), tip.hash, uint64(tip.height))
+	var got []*replayedReceipt
+	for _, r := range replayed {
+		if r.Receipt != nil {
+			got = append(got, &replayedReceipt{r.Receipt.TxHash, r.Receipt.PostState, r.Receipt.GasUsed})
+		}
+	}
+	if len(got) != len(receipts) {
+		t.Fatalf("replayed %d receipts, want %d", len(got), len(receipts))
+	}
+	for i, receipt := range receipts {
+		want := &replayedReceipt{receipt.TxHash, receipt.PostState, receipt.GasUsed}
+		if got[i].txHash != want.txHash || string(got[i].postState) != string(want.postState) ||
+			got[i].gasUsed != want.gasUsed {
+			t.Errorf("receipt %d mismatch: got %+v, want %+v", i, got[i], want)
+		}
+	}
+
+	// The coinbase only credits the miner.
+	coinbase := replayed[len(replayed)-1]
+	if len(coinbase.Balances) == 0 {
+		t.Errorf("coinbase changed no balances")
+	}
+	for _, change := range coinbase.Balances {
+		if change.Post <= change.Pre {
+			t.Errorf("coinbase balance of %v not credited: pre %d, post %d",
+				change.Address, change.Pre, change.Post)
+		}
+	}
+
+	// The balances follow each other over the transactions of the block,
+	// and end at the balances of the tip.
+	balanceOf := func(address common.Address, asset protos.Asset) int64 {
+		balance, err := chain.CalculateBalance(txo.NewUtxoViewpoint(), block, address, &asset, 0)
+		if err != nil {
+			t.Fatalf("CalculateBalance err %v", err)
+		}
+		return balance
+	}
+	type key struct {
+		address common.Address
+		asset   protos.Asset
+	}
+	tipDeltas := make(map[key]int64)
+	last := make(map[key]int64)
+	for _, r := range replayed {
+		for _, change := range r.Balances {
+			k := key{change.Address, change.Asset}
+			if post, ok := last[k]; ok && change.Pre != post {
+				t.Errorf("balance of %v starts at %d, previous transaction ended at %d",
+					change.Address, change.Pre, post)
+			}
+			last[k] = change.Post
+			tipDeltas[k] += change.Post - change.Pre
+		}
+	}
+	for k, post := range last {
+		if balance := balanceOf(k.address, k.asset); post != balance {
+			t.Errorf("balance of %v ends at %d, want %d", k.address, post, balance)
+		}
+	}
+
+	// The balances of an older block are the ones of the tip less the
+	// changes of the later blocks.
+	replayed, err = chain.ReplayBlock(&tip.parent.hash, -1, nil)
+	if err != nil {
+		t.Fatalf("ReplayBlock err %v", err)
+	}
+	last = make(map[key]int64)
+	for _, r := range replayed {
+		for _, change := range r.Balances {
+			last[key{change.Address, change.Asset}] = change.Post
+		}
+	}
+	if len(last) == 0 {
+		t.Errorf("the parent of the tip changed no balances")
+	}
+	for k, post := range last {
+		want := balanceOf(k.address, k.asset) - tipDeltas[k]
+		if post != want {
+			t.Errorf("balance of %v ends at %d in the parent of the tip, want %d",
+				k.address, post, want)
+		}
+	}
+
+	// Replaying only the first transaction.
+	replayed, err = chain.ReplayBlock(&tip.hash, 0, nil)
+	if err != nil || len(replayed) != 1 {
+		t.Errorf("ReplayBlock of first tx: got %d txs, err %v", len(replayed), err)
+	}
+
+	// The genesis block and unknown blocks can not be replayed.
+	if _, err = chain.ReplayBlock(&chain.bestChain.Genesis().hash, -1, nil); err == nil {
+		t.Errorf("ReplayBlock of the genesis block: expected error")
+	}
+	if _, err = chain.ReplayBlock(&common.Hash{0x01}, -1, nil); err == nil {
+		t.Errorf("ReplayBlock of an unknown block: expected error")
+	}
+}
+
+type replayedReceipt struct {
+	txHash    common.Hash
+	postState []byte
+	gasUsed   uint64
+}
@@ -0,0 +1,1034 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"sort"
+
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/blockchain/txo"
+	"github.com/AsimovNetwork/asimov/chaincfg"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/common/serialization"
+	"github.com/AsimovNetwork/asimov/crypto"
+	"github.com/AsimovNetwork/asimov/crypto/muhash"
+	"github.com/AsimovNetwork/asimov/database"
+	"github.com/AsimovNetwork/asimov/protos"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/state"
+)
+
+// -----------------------------------------------------------------------------
+// A chain state snapshot holds everything a node needs to validate the blocks
+// following a main chain block without replaying the chain up to it.
+//
+// The serialized format is a header followed by a sequence of records:
+//
+//   <magic><version><net><block hash><block height><total txns>
+//   <num state roots><state roots>
+//   <record>...<end record>
+//
+//   Field             Type             Size
+//   magic             uint32           4 bytes
+//   version           uint32           4 bytes
+//   net               uint32           4 bytes
+//   block hash        common.Hash      common.HashLength
+//   block height      uint32           4 bytes
+//   total txns        uint64           8 bytes
+//   num state roots   VLQ              variable
+//   state roots       []common.Hash    common.HashLength each
+//
+// Each record is a type byte followed by a type dependent number of variable
+// length byte fields:
+//
+//   - block index rows, the key and value of the block index bucket entry of
+//     every main chain block up to the snapshot one, in order of height
+//   - utxos, the outpoint key, serialized utxo entry and lock item of every
+//     unspent output, in order of key
+//   - bucket entries, the bucket name, key and value of the entries of the
+//     asset, signature and consensus buckets
+//   - templates, the hash and data of every contract template
+//   - state nodes, the hash and blob of every trie node and contract code
+//     reachable from the state roots
+//   - blocks, the type and bytes of the snapshot block and its virtual block
+//   - end, the number of utxos, their commitment and the rolling hash of the
+//     utxo set
+//
+// The first state root is the one of the snapshot block.  The other ones are
+// the states the validation of the next blocks depends on, such as the state
+// the validators of the current round are elected from.
+//
+// The utxo commitment is the sha256 hash of the fields of every utxo record
+// in order, each serialized as a variable length byte array.  The rolling hash
+// is the one the chain state keeps for the utxo set, which ignores the lock
+// items, so it can be compared with the one reported by other nodes.
+// -----------------------------------------------------------------------------
+
+const (
+	// snapshotMagic identifies a chain state snapshot.
+	snapshotMagic uint32 = 0x50414e53
+
+	// snapshotVersion is the version of the snapshot format.
+	snapshotVersion uint32 = 1
+
+	// maxSnapshotFieldSize is the maximum size of a snapshot record field.
+	maxSnapshotFieldSize = 1 << 25
+
+	// snapshotBatchSize is the number of records written to the block
+	// database per transaction when importing a snapshot.
+	snapshotBatchSize = 10000
+)
+
+// Snapshot record types.
+const (
+	snapshotBlockRow byte = iota + 1
+	snapshotUtxo
+	snapshotBucketEntry
+	snapshotTemplate
+	snapshotStateNode
+	snapshotBlock
+	snapshotEnd
+)
+
+var (
+	// snapshotBaseKeyName is the name of the db key used to store the block
+	// the chain was bootstrapped from, when it was imported from a snapshot.
+	snapshotBaseKeyName = []byte("snapshotbase")
+
+	// snapshotTemplateBucketName is the name of the db bucket used to house
+	// the contract templates created before the snapshot the chain was
+	// bootstrapped from, since the blocks housing them are not available.
+	snapshotTemplateBucketName = []byte("snapshottemplates")
+)
+
+// SnapshotConfig describes the content of an exported or imported chain state
+// snapshot.
+type SnapshotConfig struct {
+	// Height is the height of the main chain block whose state is
+	// exported.  Zero selects the best block.
+	Height int32
+
+	// Buckets are the names of additional metadata buckets which are
+	// exported as they are, such as the ones of the consensus.  An
+	// imported snapshot may only write to these buckets and to the asset
+	// and signature ones.
+	Buckets [][]byte
+
+	// UtxoSetHash is the rolling hash of the utxo set of the snapshot
+	// block, obtained from a trusted source such as the gettxoutsetinfo
+	// RPC of another node.  It is required by the import, since nothing
+	// else in the snapshot commits to the utxo set.
+	UtxoSetHash *common.Hash
+
+	// Templates invokes the passed function for every contract template
+	// known to the template index.  The templates created after the
+	// snapshot block are skipped by the export.
+	Templates func(fn func(hash *common.Hash, data []byte) error) error
+}
+
+// SnapshotInfo describes an exported or imported chain state snapshot.
+type SnapshotInfo struct {
+	Hash       common.Hash
+	Height     int32
+	StateRoot  common.Hash
+	Utxos      uint64
+	UtxoHash   common.Hash
+	StateNodes uint64
+	Templates  uint64
+
+	// UtxoSetHash is the rolling hash of the utxo set, the one reported by
+	// the gettxoutsetinfo RPC.
+	UtxoSetHash common.Hash
+}
+
+// writeSnapshotRecord writes a snapshot record of the passed type and fields.
+func writeSnapshotRecord(w io.Writer, kind byte, fields ...[]byte) error {
+	if err := serialization.WriteUint8(w, kind); err != nil {
+		return err
+	}
+	for _, field := range fields {
+		if err := serialization.WriteVarBytes(w, 0, field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readSnapshotFields reads the passed number of fields of a snapshot record.
+func readSnapshotFields(r io.Reader, n int) ([][]byte, error) {
+	fields := make([][]byte, n)
+	for i := range fields {
+		field, err := serialization.ReadVarBytes(r, 0, maxSnapshotFieldSize,
+			"snapshot field")
+		if err != nil {
+			return nil, err
+		}
+		fields[i] = field
+	}
+	return fields, nil
+}
+
+// hashSnapshotFields adds the passed snapshot record fields to a commitment.
+func hashSnapshotFields(h hash.Hash, fields ...[]byte) {
+	for _, field := range fields {
+		serialization.WriteVarBytes(h, 0, field)
+	}
+}
+
+// snapshotRow is a row of the block index of a snapshot.
+type snapshotRow struct {
+	key    []byte
+	value  []byte
+	header *protos.BlockHeader
+}
+
+// dbFetchMainChainRows returns the block index rows of the main chain, in
+// order of height, along with the best chain state.
+func dbFetchMainChainRows(dbTx database.Tx) ([]*snapshotRow, *bestChainState, error) {
+	serializedData := dbTx.Metadata().Get(chainStateKeyName)
+	if serializedData == nil {
+		return nil, nil, fmt.Errorf("the chain state is not initialized")
+	}
+	best, err := deserializeBestChainState(serializedData)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows := make(map[common.Hash]*snapshotRow)
+	blockIndexBucket := dbTx.Metadata().Bucket(blockIndexBucketName)
+	cursor := blockIndexBucket.Cursor()
+	for ok := cursor.First(); ok; ok = cursor.Next() {
+		header, _, _, err := deserializeBlockRow(cursor.Value())
+		if err != nil {
+			return nil, nil, err
+		}
+		rows[header.BlockHash()] = &snapshotRow{
+			key:    common.CopyBytes(cursor.Key()),
+			value:  common.CopyBytes(cursor.Value()),
+			header: header,
+		}
+	}
+
+	mainChain := make([]*snapshotRow, best.height+1)
+	hash := best.hash
+	for height := int32(best.height); height >= 0; height-- {
+		row, ok := rows[hash]
+		if !ok {
+			return nil, nil, fmt.Errorf("block %v (height %d) is missing "+
+				"from the block index", hash, height)
+		}
+		mainChain[height] = row
+		hash = row.header.PrevBlock
+	}
+	return mainChain, &best, nil
+}
+
+// ExportSnapshot writes a snapshot of the chain state of the passed databases
+// at the main chain block selected by the passed config.  The state of the
+// blocks following it is rolled back in memory using the spend journal, so the
+// databases are left untouched.
+//
+// The databases must not be used by a running chain while the snapshot is
+// written.  The contract state of the snapshot block must be persisted, which
+// is always the case for archive nodes.
+func ExportSnapshot(db database.Transactor, stateDB database.Database,
+	params *chaincfg.Params, config *SnapshotConfig, w io.Writer) (*SnapshotInfo, error) {
+
+	var rows []*snapshotRow
+	var best *bestChainState
+	err := db.View(func(dbTx database.Tx) error {
+		var err error
+		rows, best, err = dbFetchMainChainRows(dbTx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tipHeight := int32(best.height)
+	height := config.Height
+	if height == 0 {
+		height = tipHeight
+	}
+	if height < 1 || height > tipHeight {
+		return nil, fmt.Errorf("snapshot height %d is out of range [1, %d]",
+			height, tipHeight)
+	}
+	snapRow := rows[height]
+	info := &SnapshotInfo{
+		Hash:      snapRow.header.BlockHash(),
+		Height:    height,
+		StateRoot: snapRow.header.StateRoot,
+	}
+
+	// Roll the utxo set back to the snapshot block, forgetting about the
+	// signatures and the templates of the following blocks.
+	view := txo.NewUtxoViewpoint()
+	totalTxns := best.totalTxns
+	rolledBack := make(map[common.Hash]struct{})
+	for h := tipHeight; h > height; h-- {
+		hash := rows[h].header.BlockHash()
+		block, vblock, err := asiutil.GetBlockPair(db, &hash)
+		if err != nil {
+			return nil, err
+		}
+		var stxos []txo.SpentTxOut
+		err = db.View(func(dbTx database.Tx) error {
+			stxos, err = dbFetchSpendJournalEntry(dbTx, block, vblock)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		err = disconnectTransactions(view, db, block, stxos, vblock)
+		if err != nil {
+			return nil, err
+		}
+
+		totalTxns -= uint64(len(block.MsgBlock().Transactions))
+		for _, tx := range block.Transactions() {
+			rolledBack[*tx.Hash()] = struct{}{}
+		}
+		for _, sign := range block.Signs() {
+			rolledBack[*sign.Hash()] = struct{}{}
+		}
+	}
+
+	// Select the state the validators of the round of the snapshot block
+	// are elected from.
+	roots := []common.Hash{info.StateRoot}
+	for h := height - 1; h >= 0; h-- {
+		if rows[h].header.Round < snapRow.header.Round {
+			if rows[h].header.StateRoot != info.StateRoot {
+				roots = append(roots, rows[h].header.StateRoot)
+			}
+			break
+		}
+	}
+
+	// Write the header.
+	for _, field := range []uint32{snapshotMagic, snapshotVersion, uint32(params.Net)} {
+		if err := serialization.WriteUint32(w, field); err != nil {
+			return nil, err
+		}
+	}
+	if err := serialization.WriteNBytes(w, info.Hash[:]); err != nil {
+		return nil, err
+	}
+	if err := serialization.WriteUint32(w, uint32(height)); err != nil {
+		return nil, err
+	}
+	if err := serialization.WriteUint64(w, totalTxns); err != nil {
+		return nil, err
+	}
+	if err := serialization.WriteVarInt(w, 0, uint64(len(roots))); err != nil {
+		return nil, err
+	}
+	for _, root := range roots {
+		if err := serialization.WriteNBytes(w, root[:]); err != nil {
+			return nil, err
+		}
+	}
+
+	// Write the block index.
+	for _, row := range rows[:height+1] {
+		err := writeSnapshotRecord(w, snapshotBlockRow, row.key, row.value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Write the utxo set, merging the rolled back entries with the stored
+	// ones in order of key.
+	overlay := make(map[string]*txo.UtxoEntry, len(view.Entries()))
+	overlayKeys := make([]string, 0, len(view.Entries()))
+	for outpoint, entry := range view.Entries() {
+		if entry == nil || !entry.IsModified() {
+			continue
+		}
+		key := string(*outpointKey(outpoint))
+		overlay[key] = entry
+		overlayKeys = append(overlayKeys, key)
+	}
+	sort.Strings(overlayKeys)
+
+	commitment := sha256.New()
+	utxoHash := muhash.New()
+	writeUtxo := func(key, serialized, lockItem []byte) error {
+		info.Utxos++
+		hashSnapshotFields(commitment, key, serialized, lockItem)
+		utxoHash.Add(utxoHashElement(key, serialized))
+		return writeSnapshotRecord(w, snapshotUtxo, key, serialized, lockItem)
+	}
+	writeOverlay := func(key string) error {
+		entry := overlay[key]
+		if entry.IsSpent() {
+			return nil
+		}
+		serialized, err := SerializeUtxoEntry(entry)
+		if err != nil {
+			return err
+		}
+		var lockItem []byte
+		if entry.LockItem() != nil {
+			lockItem = entry.LockItem().Bytes()
+		}
+		return writeUtxo([]byte(key), serialized, lockItem)
+	}
+	err = db.View(func(dbTx database.Tx) error {
+		lockBucket := dbTx.Metadata().Bucket(lockSetBucketName)
+		cursor := dbTx.Metadata().Bucket(utxoSetBucketName).Cursor()
+		next := 0
+		for ok := cursor.First(); ok; ok = cursor.Next() {
+			key := cursor.Key()
+			for ; next < len(overlayKeys) && overlayKeys[next] < string(key); next++ {
+				if err := writeOverlay(overlayKeys[next]); err != nil {
+					return err
+				}
+			}
+			if next < len(overlayKeys) && overlayKeys[next] == string(key) {
+				continue
+			}
+			err := writeUtxo(key, cursor.Value(), lockBucket.Get(key))
+			if err != nil {
+				return err
+			}
+		}
+		for ; next < len(overlayKeys); next++ {
+			if err := writeOverlay(overlayKeys[next]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	copy(info.UtxoHash[:], commitment.Sum(nil))
+	info.UtxoSetHash = utxoHash.Finalize()
+
+	// The utxo set of the best block must match the hash of the chain state.
+	if height == tipHeight && best.utxoHash != nil {
+		stored, err := muhash.Deserialize(best.utxoHash)
+		if err != nil {
+			return nil, err
+		}
+		if hash := stored.Finalize(); hash != info.UtxoSetHash {
+			return nil, fmt.Errorf("utxo set hash %v does not match the one "+
+				"of the chain state %v", info.UtxoSetHash, hash)
+		}
+	}
+
+	// Write the asset, signature and additional buckets.
+	buckets := append([][]byte{assetsSetBucketName, signatureSetBucketName},
+		config.Buckets...)
+	err = db.View(func(dbTx database.Tx) error {
+		for _, name := range buckets {
+			bucket := dbTx.Metadata().Bucket(name)
+			if bucket == nil {
+				continue
+			}
+			isSignatures := bytes.Equal(name, signatureSetBucketName)
+			err := bucket.ForEach(func(k, v []byte) error {
+				// Skip the nested buckets and the signatures of
+				// the rolled back blocks.
+				if v == nil {
+					return nil
+				}
+				if isSignatures {
+					if _, ok := rolledBack[common.BytesToHash(k)]; ok {
+						return nil
+					}
+				}
+				return writeSnapshotRecord(w, snapshotBucketEntry, name, k, v)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Write the contract templates.
+	if config.Templates != nil {
+		err = config.Templates(func(hash *common.Hash, data []byte) error {
+			if _, ok := rolledBack[*hash]; ok {
+				return nil
+			}
+			info.Templates++
+			return writeSnapshotRecord(w, snapshotTemplate, hash[:], data)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Write the trie nodes and contract codes of the states.
+	sdb := state.NewDatabase(stateDB)
+	written := make(map[common.Hash]struct{})
+	for _, root := range roots {
+		statedb, err := state.New(root, sdb)
+		if err != nil {
+			return nil, fmt.Errorf("state %v is not available, a snapshot "+
+				"may only be exported from an archive node: %v", root, err)
+		}
+		it := state.NewNodeIterator(statedb)
+		for it.Next() {
+			// Nodes embedded in their parent have no hash of their own.
+			if it.Hash == (common.Hash{}) {
+				continue
+			}
+			if _, ok := written[it.Hash]; ok {
+				continue
+			}
+			blob, err := stateDB.Get(it.Hash[:])
+			if err != nil {
+				return nil, fmt.Errorf("state node %v is not available: %v",
+					it.Hash, err)
+			}
+			err = writeSnapshotRecord(w, snapshotStateNode, it.Hash[:], blob)
+			if err != nil {
+				return nil, err
+			}
+			written[it.Hash] = struct{}{}
+			info.StateNodes++
+		}
+		if it.Error != nil {
+			return nil, fmt.Errorf("failed to iterate state %v: %v", root, it.Error)
+		}
+	}
+
+	// Write the snapshot block, which is loaded on start up.
+	err = db.View(func(dbTx database.Tx) error {
+		for _, blockType := range []database.BlockType{database.BlockNormal, database.BlockVirtual} {
+			blockBytes, err := dbTx.FetchBlock(database.NewBlockKey(&info.Hash, blockType))
+			if err != nil {
+				return err
+			}
+			err = writeSnapshotRecord(w, snapshotBlock, []byte{byte(blockType)}, blockBytes)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var count [8]byte
+	byteOrder.PutUint64(count[:], info.Utxos)
+	err = writeSnapshotRecord(w, snapshotEnd, count[:], info.UtxoHash[:],
+		info.UtxoSetHash[:])
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// snapshotImporter writes the records of a chain state snapshot to the
+// databases of a node.
+type snapshotImporter struct {
+	db      database.Transactor
+	stateDB database.Database
+	batch   database.Batch
+
+	// The block database records are written in batches.
+	rows    []*snapshotRow
+	view    *txo.UtxoViewpoint
+	entries [][3][]byte
+	pending int
+
+	// utxoHash is the rolling hash of the utxos written so far.
+	utxoHash *muhash.MuHash
+}
+
+// flush writes the pending block database records.
+func (im *snapshotImporter) flush() error {
+	if im.pending == 0 {
+		return nil
+	}
+	err := im.db.Update(func(dbTx database.Tx) error {
+		meta := dbTx.Metadata()
+		blockIndexBucket := meta.Bucket(blockIndexBucketName)
+		for _, row := range im.rows {
+			if err := blockIndexBucket.Put(row.key, row.value); err != nil {
+				return err
+			}
+			hash := row.header.BlockHash()
+			err := dbPutBlockIndex(dbTx, &hash, row.header.Height)
+			if err != nil {
+				return err
+			}
+		}
+		if err := dbPutUtxoView(dbTx, im.view, im.utxoHash); err != nil {
+			return err
+		}
+		if err := dbPutLockItem(dbTx, im.view); err != nil {
+			return err
+		}
+		if err := dbPutBalance(dbTx, im.view); err != nil {
+			return err
+		}
+		for _, entry := range im.entries {
+			bucket, err := meta.CreateBucketIfNotExists(entry[0])
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put(entry[1], entry[2]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	im.rows = nil
+	im.view = txo.NewUtxoViewpoint()
+	im.entries = nil
+	im.pending = 0
+	return nil
+}
+
+// added notes a pending block database record and flushes the batch when it
+// is full.
+func (im *snapshotImporter) added() error {
+	im.pending++
+	if im.pending < snapshotBatchSize {
+		return nil
+	}
+	return im.flush()
+}
+
+// putStateNode writes a trie node or a contract code to the state database.
+func (im *snapshotImporter) putStateNode(key, blob []byte) error {
+	if err := im.batch.Put(key, blob); err != nil {
+		return err
+	}
+	if im.batch.ValueSize() < database.IdealBatchSize {
+		return nil
+	}
+	if err := im.batch.Write(); err != nil {
+		return err
+	}
+	im.batch.Reset()
+	return nil
+}
+
+// ImportSnapshot bootstraps the empty block database of a node from the chain
+// state snapshot read from the passed reader.  The trie nodes and contract
+// codes are added to the passed state database.
+//
+// The block index is verified to link the genesis block of the network to the
+// snapshot block, passing through its checkpoints, the contract states to be
+// complete and the utxo set to match the trusted rolling hash of the passed
+// config.  The bucket entries are only written to the buckets of the config
+// and to the asset and signature ones.  The snapshot block hash should still
+// be compared with a trusted source, since the state commitments are only as
+// trustworthy as the block itself.
+//
+// The chain state is only written once every record has been verified, so a
+// node whose import failed can not be started before its databases are
+// deleted.
+func ImportSnapshot(db database.Transactor, stateDB database.Database,
+	params *chaincfg.Params, config *SnapshotConfig, r io.Reader) (*SnapshotInfo, error) {
+
+	if config.UtxoSetHash == nil {
+		return nil, fmt.Errorf("the trusted utxo set hash of the snapshot " +
+			"block is required")
+	}
+	buckets := make(map[string]struct{})
+	for _, name := range append([][]byte{assetsSetBucketName, signatureSetBucketName},
+		config.Buckets...) {
+		buckets[string(name)] = struct{}{}
+	}
+
+	err := db.View(func(dbTx database.Tx) error {
+		meta := dbTx.Metadata()
+		if meta.Get(chainStateKeyName) != nil || meta.Bucket(blockIndexBucketName) != nil {
+			return fmt.Errorf("the block database is not empty")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Read and check the header.
+	var magic, version, net, height uint32
+	for _, field := range []*uint32{&magic, &version, &net} {
+		if err := serialization.ReadUint32(r, field); err != nil {
+			return nil, err
+		}
+	}
+	if magic != snapshotMagic {
+		return nil, fmt.Errorf("not a chain state snapshot")
+	}
+	if version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", version)
+	}
+	if common.AsimovNet(net) != params.Net {
+		return nil, fmt.Errorf("the snapshot is for network %v, not %v",
+			common.AsimovNet(net), params.Net)
+	}
+	info := &SnapshotInfo{}
+	if err := serialization.ReadNBytes(r, info.Hash[:], common.HashLength); err != nil {
+		return nil, err
+	}
+	if err := serialization.ReadUint32(r, &height); err != nil {
+		return nil, err
+	}
+	info.Height = int32(height)
+	var totalTxns uint64
+	if err := serialization.ReadUint64(r, &totalTxns); err != nil {
+		return nil, err
+	}
+	numRoots, err := serialization.ReadVarInt(r, 0)
+	if err != nil {
+		return nil, err
+	}
+	if numRoots == 0 || numRoots > 16 {
+		return nil, fmt.Errorf("invalid number of state roots %d", numRoots)
+	}
+	roots := make([]common.Hash, numRoots)
+	for i := range roots {
+		if err := serialization.ReadNBytes(r, roots[i][:], common.HashLength); err != nil {
+			return nil, err
+		}
+	}
+	info.StateRoot = roots[0]
+
+	checkpoints := make(map[int32]*common.Hash)
+	for _, checkpoint := range params.Checkpoints {
+		checkpoints[checkpoint.Height] = checkpoint.Hash
+	}
+
+	err = db.Update(func(dbTx database.Tx) error {
+		meta := dbTx.Metadata()
+		for _, name := range [][]byte{blockIndexBucketName, roundIndexBucketName,
+			hashIndexBucketName, spendJournalBucketName, utxoSetBucketName,
+			assetsSetBucketName, lockSetBucketName, snapshotTemplateBucketName} {
+			if _, err := meta.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	im := &snapshotImporter{
+		db:       db,
+		stateDB:  stateDB,
+		batch:    stateDB.NewBatch(),
+		view:     txo.NewUtxoViewpoint(),
+		utxoHash: muhash.New(),
+	}
+	genesisHash := params.GenesisBlock.Header.BlockHash()
+	var lastHash common.Hash
+	var lastHeader *protos.BlockHeader
+	var lastKey []byte
+	var blocks [][]byte
+	commitment := sha256.New()
+	var utxoSetHash []byte
+	for done := false; !done; {
+		var kind uint8
+		if err := serialization.ReadUint8(r, &kind); err != nil {
+			return nil, err
+		}
+		switch kind {
+		case snapshotBlockRow:
+			fields, err := readSnapshotFields(r, 2)
+			if err != nil {
+				return nil, err
+			}
+			header, _, _, err := deserializeBlockRow(fields[1])
+			if err != nil {
+				return nil, err
+			}
+			hash := header.BlockHash()
+			rowHeight := int32(0)
+			if lastHeader != nil {
+				rowHeight = lastHeader.Height + 1
+			}
+			switch {
+			case rowHeight > info.Height || header.Height != rowHeight:
+				return nil, fmt.Errorf("unexpected block %v at height %d",
+					hash, header.Height)
+			case rowHeight == 0 && hash != genesisHash:
+				return nil, fmt.Errorf("block index does not start with "+
+					"the genesis block %v", genesisHash)
+			case rowHeight > 0 && header.PrevBlock != lastHash:
+				return nil, fmt.Errorf("block %v does not link to block %v",
+					hash, lastHash)
+			case !bytes.Equal(fields[0], blockIndexKey(&hash, uint32(header.Height))):
+				return nil, fmt.Errorf("invalid block index key for block %v", hash)
+			}
+			if checkpoint, ok := checkpoints[rowHeight]; ok && *checkpoint != hash {
+				return nil, fmt.Errorf("block %v at height %d does not match "+
+					"checkpoint %v", hash, rowHeight, checkpoint)
+			}
+
+			lastHash, lastHeader = hash, header
+			im.rows = append(im.rows, &snapshotRow{key: fields[0], value: fields[1], header: header})
+			err = im.added()
+			if err != nil {
+				return nil, err
+			}
+
+		case snapshotUtxo:
+			fields, err := readSnapshotFields(r, 3)
+			if err != nil {
+				return nil, err
+			}
+			key := fields[0]
+			if lastKey != nil && bytes.Compare(lastKey, key) >= 0 {
+				return nil, fmt.Errorf("utxos are not in order of key")
+			}
+			lastKey = key
+			if len(key) <= common.HashLength {
+				return nil, fmt.Errorf("invalid utxo key %x", key)
+			}
+			index, _ := deserializeVLQ(key[common.HashLength:])
+			outpoint := protos.OutPoint{
+				Hash:  common.BytesToHash(key[:common.HashLength]),
+				Index: uint32(index),
+			}
+			stored, err := DeserializeUtxoEntry(fields[1])
+			if err != nil {
+				return nil, err
+			}
+			var lockItem *txo.LockItem
+			if len(fields[2]) > 0 {
+				lockItem, err = txo.DeserializeLockItem(fields[2])
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			// Mark the entry as modified so it gets written.
+			entry := new(txo.UtxoEntry)
+			entry.Update(stored.Amount(), stored.PkScript(), stored.BlockHeight(),
+				stored.IsCoinBase(), stored.Asset(), lockItem)
+			im.view.AddEntry(outpoint, entry)
+			info.Utxos++
+			hashSnapshotFields(commitment, fields...)
+			err = im.added()
+			if err != nil {
+				return nil, err
+			}
+
+		case snapshotBucketEntry:
+			fields, err := readSnapshotFields(r, 3)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := buckets[string(fields[0])]; !ok {
+				return nil, fmt.Errorf("the snapshot writes to bucket %q",
+					fields[0])
+			}
+			im.entries = append(im.entries, [3][]byte{fields[0], fields[1], fields[2]})
+			err = im.added()
+			if err != nil {
+				return nil, err
+			}
+
+		case snapshotTemplate:
+			fields, err := readSnapshotFields(r, 2)
+			if err != nil {
+				return nil, err
+			}
+			im.entries = append(im.entries, [3][]byte{snapshotTemplateBucketName,
+				fields[0], fields[1]})
+			info.Templates++
+			err = im.added()
+			if err != nil {
+				return nil, err
+			}
+
+		case snapshotStateNode:
+			fields, err := readSnapshotFields(r, 2)
+			if err != nil {
+				return nil, err
+			}
+			if crypto.Keccak256Hash(fields[1]) != common.BytesToHash(fields[0]) {
+				return nil, fmt.Errorf("state node %x does not match its hash",
+					fields[0])
+			}
+			if err := im.putStateNode(fields[0], fields[1]); err != nil {
+				return nil, err
+			}
+			info.StateNodes++
+
+		case snapshotBlock:
+			fields, err := readSnapshotFields(r, 2)
+			if err != nil {
+				return nil, err
+			}
+			if len(fields[0]) != 1 {
+				return nil, fmt.Errorf("invalid snapshot block type")
+			}
+			blocks = append(blocks, fields[0], fields[1])
+
+		case snapshotEnd:
+			fields, err := readSnapshotFields(r, 3)
+			if err != nil {
+				return nil, err
+			}
+			if len(fields[0]) != 8 || byteOrder.Uint64(fields[0]) != info.Utxos {
+				return nil, fmt.Errorf("the snapshot holds %d utxos, not the "+
+					"expected number", info.Utxos)
+			}
+			copy(info.UtxoHash[:], commitment.Sum(nil))
+			if !bytes.Equal(fields[1], info.UtxoHash[:]) {
+				return nil, fmt.Errorf("utxo set hash %v does not match the "+
+					"commitment %x", info.UtxoHash, fields[1])
+			}
+			utxoSetHash = fields[2]
+			done = true
+
+		default:
+			return nil, fmt.Errorf("unknown snapshot record type %d", kind)
+		}
+	}
+	if err := im.flush(); err != nil {
+		return nil, err
+	}
+	if err := im.batch.Write(); err != nil {
+		return nil, err
+	}
+
+	// The rolling hash is only known once every utxo has been written.  It
+	// must match the one of the snapshot and the trusted one.
+	info.UtxoSetHash = im.utxoHash.Clone().Finalize()
+	if !bytes.Equal(utxoSetHash, info.UtxoSetHash[:]) {
+		return nil, fmt.Errorf("utxo set hash %v does not match the "+
+			"rolling hash %x", info.UtxoSetHash, utxoSetHash)
+	}
+	if info.UtxoSetHash != *config.UtxoSetHash {
+		return nil, fmt.Errorf("utxo set hash %v does not match the "+
+			"trusted hash %v", info.UtxoSetHash, config.UtxoSetHash)
+	}
+
+	// Check the snapshot block against the block index.
+	if lastHeader == nil || lastHash != info.Hash {
+		return nil, fmt.Errorf("the block index does not end with the "+
+			"snapshot block %v", info.Hash)
+	}
+	if lastHeader.StateRoot != info.StateRoot {
+		return nil, fmt.Errorf("state root %v does not match the one of "+
+			"the snapshot block %v", info.StateRoot, lastHeader.StateRoot)
+	}
+
+	// Check the states are complete.
+	sdb := state.NewDatabase(stateDB)
+	for _, root := range roots {
+		statedb, err := state.New(root, sdb)
+		if err != nil {
+			return nil, fmt.Errorf("state %v is incomplete: %v", root, err)
+		}
+		it := state.NewNodeIterator(statedb)
+		for it.Next() {
+		}
+		if it.Error != nil {
+			return nil, fmt.Errorf("state %v is incomplete: %v", root, it.Error)
+		}
+	}
+
+	// Store the genesis and the snapshot blocks, then the chain state.
+	err = db.Update(func(dbTx database.Tx) error {
+		if err := dbStoreBlock(dbTx, asiutil.NewBlock(params.GenesisBlock)); err != nil {
+			return err
+		}
+		var haveBlock, haveVBlock bool
+		for i := 0; i < len(blocks); i += 2 {
+			blockType := database.BlockType(blocks[i][0])
+			switch blockType {
+			case database.BlockNormal:
+				block, err := asiutil.NewBlockFromBytes(blocks[i+1])
+				if err != nil {
+					return err
+				}
+				if *block.Hash() != info.Hash {
+					return fmt.Errorf("block %v is not the snapshot block",
+						block.Hash())
+				}
+				haveBlock = true
+			case database.BlockVirtual:
+				if _, err := asiutil.NewVBlockFromBytes(blocks[i+1], &info.Hash); err != nil {
+					return err
+				}
+				haveVBlock = true
+			default:
+				return fmt.Errorf("invalid snapshot block type %d", blockType)
+			}
+			err := dbTx.StoreBlock(database.NewBlockKey(&info.Hash, blockType), blocks[i+1])
+			if err != nil {
+				return err
+			}
+		}
+		if !haveBlock || !haveVBlock {
+			return fmt.Errorf("the snapshot block %v is missing", info.Hash)
+		}
+
+		serializedData := serializeBestChainState(bestChainState{
+			hash:      info.Hash,
+			height:    uint32(info.Height),
+			totalTxns: totalTxns,
+			utxoHash:  im.utxoHash.Serialize(),
+		})
+		if err := dbTx.Metadata().Put(snapshotBaseKeyName, serializedData); err != nil {
+			return err
+		}
+		return dbTx.Metadata().Put(chainStateKeyName, serializedData)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// dbFetchSnapshotHeight returns the height of the block the chain was
+// bootstrapped from, or zero when it was synced from the genesis block.
+func dbFetchSnapshotHeight(dbTx database.Tx) (int32, error) {
+	serializedData := dbTx.Metadata().Get(snapshotBaseKeyName)
+	if serializedData == nil {
+		return 0, nil
+	}
+	base, err := deserializeBestChainState(serializedData)
+	if err != nil {
+		return 0, err
+	}
+	return int32(base.height), nil
+}
+
+// dbFetchSnapshotTemplate returns the data of the contract template with the
+// passed hash which was imported from a snapshot, or nil when there is none.
+func dbFetchSnapshotTemplate(dbTx database.Tx, hash *common.Hash) []byte {
+	bucket := dbTx.Metadata().Bucket(snapshotTemplateBucketName)
+	if bucket == nil {
+		return nil
+	}
+	return bucket.Get(hash[:])
+}
+
+// SnapshotHeight returns the height of the block the chain was bootstrapped
+// from when it was imported from a snapshot, or zero when it was synced from
+// the genesis block.  The blocks preceding it are not available.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) SnapshotHeight() int32 {
+	return b.snapshotHeight
+}
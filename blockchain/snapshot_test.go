@@ -0,0 +1,195 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/database/dbdriver"
+	"github.com/AsimovNetwork/asimov/database/dbimpl/ethdb"
+	"github.com/AsimovNetwork/asimov/protos"
+)
+
+func TestSnapshot(t *testing.T) {
+	parivateKeyList := []string{
+		"0xd0f0461b7b4d26cf370e6c73b58ef7fa26e8e30853a8cee901ed42cf0879cb6e", //privateKey0
+	}
+	accList, netParam, chain, teardownFunc, err := createFakeChainByPrivateKeys(parivateKeyList, 10)
+	defer teardownFunc()
+	if err != nil {
+		t.Fatalf("create fake chain error %v", err)
+	}
+
+	validators, filters, _ := chain.GetValidatorsByNode(1, chain.bestChain.tip())
+	var blocks []*asiutil.Block
+	for i := 0; i < 3; i++ {
+		block, _, err := createAndSignBlock(netParam, accList, validators, filters, chain, 1,
+			uint16(i), chain.bestChain.height(), protos.Asset{}, 0,
+			validators[i], nil, 0, chain.bestChain.tip())
+		if err != nil {
+			t.Fatalf("create block error %v", err)
+		}
+		if _, _, err = chain.ProcessBlock(block, nil, nil, nil, common.BFNone); err != nil {
+			t.Fatalf("ProcessBlock err %v", err)
+		}
+		blocks = append(blocks, block)
+	}
+	tip := chain.bestChain.tip()
+
+	// Export the state of the parent of the tip, which rolls the tip back.
+	var snapshot bytes.Buffer
+	exported, err := ExportSnapshot(chain.db, chain.ethDB, &netParam,
+		&SnapshotConfig{Height: tip.height - 1}, &snapshot)
+	if err != nil {
+		t.Fatalf("ExportSnapshot err %v", err)
+	}
+	if exported.Hash != tip.parent.hash || exported.StateRoot != tip.parent.stateRoot {
+		t.Errorf("ExportSnapshot got block %v with root %v, want %v with root %v",
+			exported.Hash, exported.StateRoot, tip.parent.hash, tip.parent.stateRoot)
+	}
+	if exported.Utxos == 0 || exported.StateNodes == 0 {
+		t.Errorf("ExportSnapshot got %d utxos and %d state nodes", exported.Utxos,
+			exported.StateNodes)
+	}
+
+	// A node which is already initialized can not be bootstrapped.
+	trusted := &SnapshotConfig{UtxoSetHash: &exported.UtxoSetHash}
+	_, err = ImportSnapshot(chain.db, chain.ethDB, &netParam, trusted,
+		bytes.NewReader(snapshot.Bytes()))
+	if err == nil {
+		t.Errorf("ImportSnapshot into an initialized node succeeded")
+	}
+
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatalf("TempDir err %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A snapshot whose utxo set does not match the commitment is rejected.
+	tampered := common.CopyBytes(snapshot.Bytes())
+	tampered[len(tampered)-1] ^= 0xff
+	importInto := func(name string, config *SnapshotConfig, data []byte) error {
+		db, err := dbdriver.Create(testDbType, filepath.Join(dir, name), netParam.Net)
+		if err != nil {
+			t.Fatalf("Create err %v", err)
+		}
+		defer db.Close()
+		stateDB, err := ethdb.NewLDBDatabase(filepath.Join(dir, name+"state"), 16, 16)
+		if err != nil {
+			t.Fatalf("NewLDBDatabase err %v", err)
+		}
+		defer stateDB.Close()
+		_, err = ImportSnapshot(db, stateDB, &netParam, config, bytes.NewReader(data))
+		return err
+	}
+	if err := importInto("tampered", trusted, tampered); err == nil {
+		t.Errorf("ImportSnapshot of a tampered snapshot succeeded")
+	}
+
+	// A snapshot is only imported against a trusted utxo set hash.
+	if err := importInto("untrusted", &SnapshotConfig{}, snapshot.Bytes()); err == nil {
+		t.Errorf("ImportSnapshot without a trusted utxo set hash succeeded")
+	}
+	wrongHash := common.Hash{0x01}
+	err = importInto("wronghash", &SnapshotConfig{UtxoSetHash: &wrongHash}, snapshot.Bytes())
+	if err == nil {
+		t.Errorf("ImportSnapshot against a different utxo set hash succeeded")
+	}
+
+	// A snapshot may not write to the buckets of the chain state.
+	var crafted bytes.Buffer
+	_, err = ExportSnapshot(chain.db, chain.ethDB, &netParam, &SnapshotConfig{
+		Height:  tip.height - 1,
+		Buckets: [][]byte{utxoSetBucketName},
+	}, &crafted)
+	if err != nil {
+		t.Fatalf("ExportSnapshot err %v", err)
+	}
+	if err := importInto("crafted", trusted, crafted.Bytes()); err == nil {
+		t.Errorf("ImportSnapshot writing to the utxo set bucket succeeded")
+	}
+
+	db, err := dbdriver.Create(testDbType, filepath.Join(dir, "blocks"), netParam.Net)
+	if err != nil {
+		t.Fatalf("Create err %v", err)
+	}
+	defer db.Close()
+	stateDB, err := ethdb.NewLDBDatabase(filepath.Join(dir, "state"), 16, 16)
+	if err != nil {
+		t.Fatalf("NewLDBDatabase err %v", err)
+	}
+	defer stateDB.Close()
+
+	imported, err := ImportSnapshot(db, stateDB, &netParam, trusted,
+		bytes.NewReader(snapshot.Bytes()))
+	if err != nil {
+		t.Fatalf("ImportSnapshot err %v", err)
+	}
+	if *imported != *exported {
+		t.Errorf("ImportSnapshot got %+v, want %+v", imported, exported)
+	}
+
+	// The bootstrapped chain starts at the snapshot block and keeps syncing.
+	contractManager := NewContractManagerTmp()
+	bootstrapped, err := New(&Config{
+		DB:          db,
+		ChainParams: &netParam,
+		TimeSource:  NewMedianTime(),
+		StateDB:     stateDB,
+		BtcClient: NewFakeBtcClient(netParam.CollectHeight,
+			netParam.CollectInterval+int32(netParam.BtcBlocksPerRound)*24),
+		RoundManager:    NewRoundManager(),
+		ContractManager: contractManager,
+	}, nil)
+	if err != nil {
+		t.Fatalf("New err %v", err)
+	}
+	if err := contractManager.Init(bootstrapped, netParam.GenesisBlock.Transactions[0].TxOut[0].Data); err != nil {
+		t.Fatalf("contract manager Init err %v", err)
+	}
+	if bootstrapped.SnapshotHeight() != tip.height-1 {
+		t.Errorf("SnapshotHeight got %d, want %d", bootstrapped.SnapshotHeight(), tip.height-1)
+	}
+	best := bootstrapped.BestSnapshot()
+	if best.Hash != tip.parent.hash {
+		t.Errorf("bootstrapped chain starts at %v, want %v", best.Hash, tip.parent.hash)
+	}
+
+	if _, _, err = bootstrapped.ProcessBlock(blocks[len(blocks)-1], nil, nil, nil, common.BFNone); err != nil {
+		t.Fatalf("ProcessBlock err %v", err)
+	}
+	best = bootstrapped.BestSnapshot()
+	if best.Hash != tip.hash || best.TotalTxns != chain.BestSnapshot().TotalTxns {
+		t.Errorf("bootstrapped chain got tip %v with %d txns, want %v with %d txns",
+			best.Hash, best.TotalTxns, tip.hash, chain.BestSnapshot().TotalTxns)
+	}
+
+	// Both chains agree on the utxo set of the tip.
+	var discard bytes.Buffer
+	want, err := ExportSnapshot(chain.db, chain.ethDB, &netParam, &SnapshotConfig{}, &discard)
+	if err != nil {
+		t.Fatalf("ExportSnapshot err %v", err)
+	}
+	discard.Reset()
+	got, err := ExportSnapshot(db, stateDB, &netParam, &SnapshotConfig{}, &discard)
+	if err != nil {
+		t.Fatalf("ExportSnapshot err %v", err)
+	}
+	if got.UtxoHash != want.UtxoHash || got.Utxos != want.Utxos {
+		t.Errorf("bootstrapped chain got %d utxos with hash %v, want %d with hash %v",
+			got.Utxos, got.UtxoHash, want.Utxos, want.UtxoHash)
+	}
+	if got.UtxoSetHash != want.UtxoSetHash {
+		t.Errorf("bootstrapped chain got utxo set hash %v, want %v",
+			got.UtxoSetHash, want.UtxoSetHash)
+	}
+}
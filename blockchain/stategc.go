@@ -0,0 +1,202 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"fmt"
+
+	"github.com/AsimovNetwork/asimov/blockchain/txo"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/database"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/state"
+)
+
+const (
+	// stateFlushInterval is the number of blocks after which the state at
+	// the edge of the retention window is persisted when the states are
+	// garbage collected.  It bounds the number of blocks re-executed to
+	// regenerate a state which is no longer available.
+	stateFlushInterval = 1024
+
+	// stateCacheLimit is the memory allowance of the trie nodes of the
+	// retained states.  The oldest nodes are flushed to disk beyond it.
+	stateCacheLimit = 256 * 1024 * 1024
+)
+
+// stateGc tracks the contract states referenced in the in-memory trie
+// database when only the states of the recent blocks are kept.
+type stateGc struct {
+	// retention is the number of recent block states kept.
+	retention int32
+
+	// roots are the referenced state roots by the height of their block.
+	// The blocks connected by a reorganization add their roots at the same
+	// heights as the blocks they replaced.
+	roots  map[int32][]common.Hash
+	oldest int32
+
+	// flushed is the height of the block whose state was last persisted.
+	flushed int32
+}
+
+// newStateGc returns a state garbage collector which keeps the states of the
+// passed number of recent blocks.
+func newStateGc(retention int32) *stateGc {
+	return &stateGc{
+		retention: retention,
+		roots:     make(map[int32][]common.Hash),
+	}
+}
+
+// commitState stores the contract state with the passed root of the passed
+// block node when it is connected to the main chain.
+//
+// In archive mode the state is persisted.  Otherwise it is referenced in the
+// in-memory trie database and the states of the blocks which fell out of the
+// retention window are released.  The state at the edge of the window is
+// periodically persisted, and the oldest trie nodes are flushed to disk when
+// the memory allowance is exceeded.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) commitState(node *blockNode, root common.Hash) error {
+	triedb := b.stateCache.TrieDB()
+	gc := b.stateGc
+	if gc == nil {
+		return triedb.Commit(root, false)
+	}
+
+	triedb.Reference(root, common.Hash{})
+	gc.roots[node.height] = append(gc.roots[node.height], root)
+	if len(gc.roots) == 1 || node.height < gc.oldest {
+		gc.oldest = node.height
+	}
+
+	chosen := node.height - gc.retention
+	if chosen <= 0 {
+		return nil
+	}
+
+	if nodes, _ := triedb.Size(); nodes > stateCacheLimit {
+		if err := triedb.Cap(stateCacheLimit - database.IdealBatchSize); err != nil {
+			return err
+		}
+	}
+
+	// Persist the state at the edge of the retention window once in a
+	// while, so it can be regenerated after a restart or a reorganization
+	// deeper than the window.
+	if chosen >= gc.flushed+stateFlushInterval {
+		ancestor := node.Ancestor(chosen)
+		if err := triedb.Commit(ancestor.stateRoot, false); err != nil {
+			return err
+		}
+		gc.flushed = chosen
+		log.Debugf("Persisted the state of block %v (height %d)",
+			ancestor.hash, chosen)
+	}
+
+	for ; gc.oldest <= chosen; gc.oldest++ {
+		for _, root := range gc.roots[gc.oldest] {
+			triedb.Dereference(root)
+		}
+		delete(gc.roots, gc.oldest)
+	}
+	return nil
+}
+
+// hasState returns whether the contract state with the passed root is
+// available, either in memory or on disk.
+func (b *BlockChain) hasState(root common.Hash) bool {
+	_, err := b.stateCache.TrieDB().Node(root)
+	return err == nil
+}
+
+// regenerateState makes the contract state of the passed main chain block
+// node available.  When it was garbage collected, the blocks since its most
+// recent ancestor whose state is still available are re-executed.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) regenerateState(node *blockNode) error {
+	var nodes []*blockNode
+	for n := node; !b.hasState(n.stateRoot); n = n.parent {
+		if n.parent == nil || !b.bestChain.Contains(n) {
+			return fmt.Errorf("state of block %v is not available", node.hash)
+		}
+		nodes = append(nodes, n)
+	}
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	log.Infof("Regenerating the state of block %v (height %d) from %d blocks",
+		node.hash, node.height, len(nodes))
+	for i := len(nodes) - 1; i >= 0; i-- {
+		if err := b.replayState(nodes[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replayState recomputes the contract state of the passed main chain block
+// node by re-executing its transactions on top of the state of its parent.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) replayState(node *blockNode) error {
+	block, view, err := b.fetchReplayView(&node.hash)
+	if err != nil {
+		return err
+	}
+	statedb, err := state.New(node.parent.stateRoot, b.stateCache)
+	if err != nil {
+		return err
+	}
+
+	for i, tx := range block.Transactions() {
+		fee, _, err := CheckTransactionInputs(tx, node.height, view, b)
+		if err != nil {
+			return err
+		}
+		var spent []txo.SpentTxOut
+		statedb.Prepare(*tx.Hash(), *block.Hash(), i)
+		_, err, _, _, _ = b.connectTransaction(block, i, view, tx, &spent,
+			statedb, fee, &b.vmConfig)
+		if err != nil {
+			return err
+		}
+	}
+
+	stateRoot, err := statedb.Commit(true)
+	if err != nil {
+		return err
+	}
+	if stateRoot != node.stateRoot {
+		return fmt.Errorf("regenerated state root %v of block %v does not "+
+			"match %v", stateRoot, node.hash, node.stateRoot)
+	}
+	return b.commitState(node, stateRoot)
+}
+
+// Stop persists the contract state of the best chain when only the states of
+// the recent blocks are kept, so it does not have to be regenerated on the
+// next start.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) Stop() error {
+	b.chainLock.Lock()
+	defer b.chainLock.Unlock()
+
+	if b.stateGc == nil {
+		return nil
+	}
+	return b.stateCache.TrieDB().Commit(b.bestChain.Tip().stateRoot, true)
+}
+
+// IsArchive returns whether the contract state of every block is persisted.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) IsArchive() bool {
+	return b.stateGc == nil
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/database/dbimpl/ethdb"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/state"
+)
+
+// TestStateGc ensures only the states of the recent blocks are kept in memory
+// in full gc mode, and the state of the tip is persisted on stop.
+func TestStateGc(t *testing.T) {
+	const retention = 2
+	diskdb := ethdb.NewMemDatabase()
+	chain := &BlockChain{
+		stateCache: state.NewDatabase(diskdb),
+		stateGc:    newStateGc(retention),
+	}
+	if chain.IsArchive() {
+		t.Fatalf("chain is in archive mode")
+	}
+
+	// Every block changes the state of an account.
+	addr := common.Address{0x01}
+	var nodes []*blockNode
+	var parent *blockNode
+	root := common.Hash{}
+	for height := int32(0); height < 6; height++ {
+		statedb, err := state.New(root, chain.stateCache)
+		if err != nil {
+			t.Fatalf("state.New at height %d err %v", height, err)
+		}
+		statedb.SetNonce(addr, uint64(height+1))
+		if root, err = statedb.Commit(true); err != nil {
+			t.Fatalf("Commit at height %d err %v", height, err)
+		}
+		node := &blockNode{parent: parent, height: height, stateRoot: root}
+		if err = chain.commitState(node, root); err != nil {
+			t.Fatalf("commitState at height %d err %v", height, err)
+		}
+		nodes = append(nodes, node)
+		parent = node
+	}
+
+	tip := nodes[len(nodes)-1]
+	for _, node := range nodes {
+		want := node.height > tip.height-retention
+		if got := chain.hasState(node.stateRoot); got != want {
+			t.Errorf("state of height %d available %v, want %v",
+				node.height, got, want)
+		}
+	}
+	if got := len(chain.stateGc.roots); got != retention {
+		t.Errorf("%d heights referenced, want %d", got, retention)
+	}
+
+	// A side chain block at a retained height is released along with the
+	// main chain one.
+	statedb, _ := state.New(nodes[4].parent.stateRoot, chain.stateCache)
+	statedb.SetNonce(addr, 100)
+	sideRoot, _ := statedb.Commit(true)
+	side := &blockNode{parent: nodes[3], height: 4, stateRoot: sideRoot}
+	if err := chain.commitState(side, sideRoot); err != nil {
+		t.Fatalf("commitState of side block err %v", err)
+	}
+	if !chain.hasState(sideRoot) {
+		t.Errorf("state of side block not available")
+	}
+	statedb, _ = state.New(tip.stateRoot, chain.stateCache)
+	statedb.SetNonce(addr, 7)
+	root, _ = statedb.Commit(true)
+	if err := chain.commitState(&blockNode{parent: tip, height: 6, stateRoot: root}, root); err != nil {
+		t.Fatalf("commitState at height 6 err %v", err)
+	}
+	if chain.hasState(sideRoot) || chain.hasState(nodes[4].stateRoot) {
+		t.Errorf("states of height 4 not released")
+	}
+
+	// Stopping the chain persists the state of the tip.
+	chain.bestChain = newChainView(tip)
+	if err := chain.Stop(); err != nil {
+		t.Fatalf("Stop err %v", err)
+	}
+	if ok, _ := diskdb.Has(tip.stateRoot[:]); !ok {
+		t.Errorf("state of the tip not persisted")
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"fmt"
+
+	"github.com/AsimovNetwork/asimov/common"
+)
+
+// HasState returns whether the contract state with the passed root is
+// available.  The root of a state is only written once all of the nodes below
+// it are, so a state downloaded from the peers is complete when it returns
+// true.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) HasState(root common.Hash) bool {
+	return b.hasState(root)
+}
+
+// FetchNodeData returns the state trie node or the contract code with the
+// passed hash, either from the retained states in memory or from disk.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) FetchNodeData(hash common.Hash) ([]byte, error) {
+	return b.stateCache.TrieDB().Node(hash)
+}
+
+// StateSyncRoots returns the roots of the contract states needed to fully
+// execute the blocks after the passed block once it was connected without
+// executing it: its own state and the states the validators of the recent
+// rounds are elected from.
+//
+// The validators of a round are read from the state of the last block of the
+// previous round.  The round of the next block is either the round of the
+// passed block or a later one, which is elected from the passed block, and
+// its signatures refer to blocks down to common.BlockSignDepth blocks below.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) StateSyncRoots(hash *common.Hash) ([]common.Hash, error) {
+	b.chainLock.RLock()
+	defer b.chainLock.RUnlock()
+
+	node := b.index.LookupNode(hash)
+	if node == nil {
+		return nil, fmt.Errorf("block %v is unknown", hash)
+	}
+
+	roots := []common.Hash{node.stateRoot}
+	seen := map[common.Hash]struct{}{node.stateRoot: {}}
+	for n := node; n != nil && n.height+common.BlockSignDepth >= node.height; n = n.parent {
+		for prev := n.parent; prev != nil; prev = prev.parent {
+			if prev.round.Round >= n.round.Round {
+				continue
+			}
+			if _, ok := seen[prev.stateRoot]; !ok {
+				seen[prev.stateRoot] = struct{}{}
+				roots = append(roots, prev.stateRoot)
+			}
+			break
+		}
+	}
+	return roots, nil
+}
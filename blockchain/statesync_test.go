@@ -0,0 +1,169 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/chaincfg"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/database"
+	"github.com/AsimovNetwork/asimov/database/dbdriver"
+	"github.com/AsimovNetwork/asimov/database/dbimpl/ethdb"
+	"github.com/AsimovNetwork/asimov/protos"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/state"
+	"github.com/AsimovNetwork/asimov/vm/fvm/trie"
+)
+
+func TestStateSync(t *testing.T) {
+	parivateKeyList := []string{
+		"0xd0f0461b7b4d26cf370e6c73b58ef7fa26e8e30853a8cee901ed42cf0879cb6e", //privateKey0
+	}
+	accList, netParam, chain, teardownFunc, err := createFakeChainByPrivateKeys(parivateKeyList, 10)
+	defer teardownFunc()
+	if err != nil {
+		t.Fatalf("create fake chain error %v", err)
+	}
+
+	validators, filters, _ := chain.GetValidatorsByNode(1, chain.bestChain.tip())
+	var blocks []*asiutil.Block
+	for i := 0; i < 3; i++ {
+		block, _, err := createAndSignBlock(netParam, accList, validators, filters, chain, 1,
+			uint16(i), chain.bestChain.height(), protos.Asset{}, 0,
+			validators[i], nil, 0, chain.bestChain.tip())
+		if err != nil {
+			t.Fatalf("create block error %v", err)
+		}
+		if _, _, err = chain.ProcessBlock(block, nil, nil, nil, common.BFNone); err != nil {
+			t.Fatalf("ProcessBlock err %v", err)
+		}
+		blocks = append(blocks, block)
+	}
+
+	dir, err := ioutil.TempDir("", "statesync")
+	if err != nil {
+		t.Fatalf("TempDir err %v", err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := dbdriver.Create(testDbType, filepath.Join(dir, "blocks"), netParam.Net)
+	if err != nil {
+		t.Fatalf("Create err %v", err)
+	}
+	defer db.Close()
+	stateDB, err := ethdb.NewLDBDatabase(filepath.Join(dir, "state"), 16, 16)
+	if err != nil {
+		t.Fatalf("NewLDBDatabase err %v", err)
+	}
+	defer stateDB.Close()
+
+	// The pivot is a checkpoint of the fresh chain.
+	pivot := blocks[len(blocks)-2]
+	contractManager := NewContractManagerTmp()
+	fresh, err := New(&Config{
+		DB:          db,
+		ChainParams: &netParam,
+		Checkpoints: []chaincfg.Checkpoint{{Height: pivot.Height(), Hash: pivot.Hash()}},
+		TimeSource:  NewMedianTime(),
+		StateDB:     stateDB,
+		BtcClient: NewFakeBtcClient(netParam.CollectHeight,
+			netParam.CollectInterval+int32(netParam.BtcBlocksPerRound)*24),
+		RoundManager:    NewRoundManager(),
+		ContractManager: contractManager,
+	}, nil)
+	if err != nil {
+		t.Fatalf("New err %v", err)
+	}
+	if err := contractManager.Init(fresh, netParam.GenesisBlock.Transactions[0].TxOut[0].Data); err != nil {
+		t.Fatalf("contract manager Init err %v", err)
+	}
+
+	// Connect all blocks but the last one with the virtual blocks of the
+	// original chain, without executing them.
+	fetchVBlock := func(block *asiutil.Block) *asiutil.VBlock {
+		var vblock *asiutil.VBlock
+		err := chain.db.View(func(dbTx database.Tx) error {
+			data, err := dbTx.FetchBlock(database.NewVirtualBlockKey(block.Hash()))
+			if err != nil {
+				return err
+			}
+			vblock, err = asiutil.NewVBlockFromBytes(data, block.Hash())
+			return err
+		})
+		if err != nil {
+			t.Fatalf("fetch vblock err %v", err)
+		}
+		return vblock
+	}
+	for _, block := range blocks[:len(blocks)-1] {
+		_, _, err = fresh.ProcessBlock(block, fetchVBlock(block), nil, nil, common.BFNoExecute)
+		if err != nil {
+			t.Fatalf("ProcessBlock without execution err %v", err)
+		}
+	}
+	if best := fresh.BestSnapshot(); best.Hash != *pivot.Hash() {
+		t.Fatalf("fresh chain got tip %v, want %v", best.Hash, pivot.Hash())
+	}
+
+	// Download the states needed past the pivot from the original chain.
+	roots, err := fresh.StateSyncRoots(pivot.Hash())
+	if err != nil {
+		t.Fatalf("StateSyncRoots err %v", err)
+	}
+	if len(roots) == 0 || roots[0] != pivot.MsgBlock().Header.StateRoot {
+		t.Fatalf("StateSyncRoots got %v, want the pivot state root %v first",
+			roots, pivot.MsgBlock().Header.StateRoot)
+	}
+	if fresh.HasState(roots[0]) {
+		t.Fatalf("state of the pivot is available before the sync")
+	}
+	nodes := 0
+	for _, root := range roots {
+		sched := state.NewStateSync(root, stateDB)
+		for sched.Pending() > 0 {
+			for _, hash := range sched.Missing(protos.MaxNodeDataPerMsg) {
+				data, err := chain.FetchNodeData(hash)
+				if err != nil {
+					t.Fatalf("FetchNodeData %v err %v", hash, err)
+				}
+				if _, _, err := sched.Process([]trie.SyncResult{{Hash: hash, Data: data}}); err != nil {
+					t.Fatalf("Process %v err %v", hash, err)
+				}
+				nodes++
+			}
+			batch := stateDB.NewBatch()
+			if _, err := sched.Commit(batch); err != nil {
+				t.Fatalf("Commit err %v", err)
+			}
+			if err := batch.Write(); err != nil {
+				t.Fatalf("Write err %v", err)
+			}
+		}
+		if !fresh.HasState(root) {
+			t.Errorf("state %v is not available after the sync", root)
+		}
+	}
+	if nodes == 0 {
+		t.Errorf("no state node was downloaded")
+	}
+
+	// The blocks after the pivot are executed.
+	last := blocks[len(blocks)-1]
+	_, _, err = fresh.ProcessBlock(last, fetchVBlock(last), nil, nil, common.BFNoExecute)
+	if err == nil {
+		t.Fatalf("ProcessBlock without execution past the checkpoint succeeded")
+	}
+	if _, _, err = fresh.ProcessBlock(last, nil, nil, nil, common.BFNone); err != nil {
+		t.Fatalf("ProcessBlock after the pivot err %v", err)
+	}
+	best, want := fresh.BestSnapshot(), chain.BestSnapshot()
+	if best.Hash != want.Hash || best.TotalTxns != want.TotalTxns {
+		t.Errorf("fresh chain got tip %v with %d txns, want %v with %d txns",
+			best.Hash, best.TotalTxns, want.Hash, want.TotalTxns)
+	}
+}
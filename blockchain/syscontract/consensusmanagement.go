@@ -78,3 +78,51 @@ func (m *Manager) GetSignedUpValidators(
 
 	return validators, round32, nil
 }
+
+// GetAdminsAndValidators returns the admins and the validators registered in
+// the system contract of consensus_poa at the state of the block.
+func (m *Manager) GetAdminsAndValidators(
+	block *asiutil.Block,
+	stateDB vm.StateDB,
+	chainConfig *params.ChainConfig) ([]common.Address, []common.Address, error) {
+
+	gas := uint64(common.SystemContractReadOnlyGas)
+	officialAddr := chaincfg.OfficialAddress
+	contract := m.GetActiveContractByHeight(block.Height(), common.ConsensusPOA)
+	if contract == nil {
+		errStr := fmt.Sprintf("Failed to get active contract %s, %d", common.ConsensusPOA, block.Height())
+		log.Error(errStr)
+		return nil, nil, common.AssertError(errStr)
+	}
+	proxyAddr, abi := vm.ConvertSystemContractAddress(common.ConsensusPOA), contract.AbiInfo
+
+	// function name of contract consensus_poa
+	funcName := common.ContractConsensusPOA_GetAdminsAndValidatorsFunction()
+
+	runCode, err := fvm.PackFunctionArgs(abi, funcName)
+	if err != nil {
+		return nil, nil, err
+	}
+	result, _, err := fvm.CallReadOnlyFunction(officialAddr, block, m.chain, stateDB, chainConfig,
+		gas, proxyAddr, runCode)
+	if err != nil {
+		log.Errorf("Get admins and validators failed, error: %s", err)
+		return nil, nil, err
+	}
+
+	admins := make([]common.Address, 0)
+	validators := make([]common.Address, 0)
+	outData := []interface{}{
+		&admins,
+		&validators,
+	}
+
+	// unpack results
+	err = fvm.UnPackFunctionResult(abi, &outData, funcName, result)
+	if err != nil {
+		log.Errorf("Get admins and validators failed, error: %s", err)
+		return nil, nil, err
+	}
+
+	return admins, validators, nil
+}
@@ -37,6 +37,10 @@ func (m *Manager) Init(chain fvm.ChainContext, dataBytes [] byte) error {
 	if err != nil {
 		return err
 	}
+	err = UpgradeContracts(cMap, chaincfg.ActiveNetParams.ContractUpgrades)
+	if err != nil {
+		return err
+	}
 	m.chain = chain
 	m.genesisDataCache = cMap
 	m.assetsUnrestrictedCache = make(map[protos.Asset]struct{})
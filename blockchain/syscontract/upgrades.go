@@ -0,0 +1,104 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package syscontract
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/AsimovNetwork/asimov/chaincfg"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/crypto"
+)
+
+// contractUpgrade is an upgrade of a system contract which the chains activate
+// at the height set in the contractUpgrades of their genesis.json.  Upgrading
+// a system contract does not change its genesis block: the new version is
+// deployed at the activation height, and the proxy of the contract delegates
+// the calls to it from that height on, over the storage of the proxy.
+type contractUpgrade struct {
+	contract common.ContractCode
+
+	// version is the name of the contract of the new version, which is
+	// compiled from systemcontracts/files/upgrades with cmd/genesis.  It
+	// derives from the contract it upgrades, so it keeps its storage layout
+	// and its functions.
+	version string
+}
+
+// compiledUpgrade is the compiled contract of a new version of a system
+// contract.
+type compiledUpgrade struct {
+	Code    string
+	AbiInfo string
+}
+
+// contractUpgrades are the known upgrades of the system contracts by name.
+var contractUpgrades = map[string]contractUpgrade{
+	"rotateValidatorKey": {
+		contract: common.ConsensusPOA,
+		version:  "ConsensusPOAV2",
+	},
+	"submitEvidence": {
+		contract: common.ValidatorCommittee,
+		version:  "ValidatorCommitteeV2",
+	},
+}
+
+// UpgradeContracts appends the versions of the system contracts which the
+// upgrades activate at the given heights to the versions of the genesis block.
+// The chain deploys the code of an upgraded version the same way as the
+// genesis versions, at its activation height.
+func UpgradeContracts(contracts map[common.ContractCode][]chaincfg.ContractInfo,
+	heights map[string]int32) error {
+	names := make([]string, 0, len(heights))
+	for name := range heights {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if heights[names[i]] != heights[names[j]] {
+			return heights[names[i]] < heights[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	for _, name := range names {
+		upgrade, exists := contractUpgrades[name]
+		if !exists {
+			return fmt.Errorf("unknown system contract upgrade %q", name)
+		}
+		compiled, exists := upgradeContracts[upgrade.version]
+		if !exists || compiled.Code == "" {
+			return fmt.Errorf("upgrade %q is not compiled, compile %s with "+
+				"cmd/genesis", name, upgrade.version)
+		}
+		height := heights[name]
+		versions := contracts[upgrade.contract]
+		if len(versions) == 0 {
+			return fmt.Errorf("upgrade %q of the missing system contract %s",
+				name, upgrade.contract)
+		}
+		prev := versions[len(versions)-1]
+		if height <= prev.BlockHeight {
+			return fmt.Errorf("upgrade %q at height %d does not follow the "+
+				"version of %s at height %d", name, height, upgrade.contract,
+				prev.BlockHeight)
+		}
+
+		addr, err := crypto.CreateContractAddress(chaincfg.OfficialAddress[:],
+			nil, common.Hex2Bytes(compiled.Code))
+		if err != nil {
+			return err
+		}
+		contracts[upgrade.contract] = append(versions, chaincfg.ContractInfo{
+			Name:        upgrade.version,
+			Address:     addr.Bytes(),
+			Code:        compiled.Code,
+			AbiInfo:     compiled.AbiInfo,
+			BlockHeight: height,
+		})
+	}
+	return nil
+}
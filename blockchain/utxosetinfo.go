@@ -0,0 +1,162 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/AsimovNetwork/asimov/blockchain/txo"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/crypto/muhash"
+	"github.com/AsimovNetwork/asimov/database"
+	"github.com/AsimovNetwork/asimov/protos"
+)
+
+// AssetUtxoInfo describes the unspent outputs of an asset.
+type AssetUtxoInfo struct {
+	Asset protos.Asset
+	Utxos uint64
+
+	// Amount is the total amount of the outputs.  It is left zero for the
+	// indivisible assets, whose amounts are not quantities.
+	Amount int64
+
+	// Locked is the part of the amount locked by votes.
+	Locked int64
+}
+
+// UtxoSetInfo describes the utxo set as of a main chain block.
+type UtxoSetInfo struct {
+	Hash   common.Hash
+	Height int32
+
+	Txs   uint64
+	Utxos uint64
+
+	// UtxoHash is the rolling hash of the utxo set, which only depends on
+	// the content of the set.  Nodes with the same utxo set have the same
+	// hash.
+	UtxoHash common.Hash
+
+	// Assets are the outputs of every asset, ordered by asset.
+	Assets []*AssetUtxoInfo
+}
+
+// utxoHashElement returns the element the utxo set hash holds for the utxo
+// with the passed outpoint key and serialized entry.  The outpoint key has a
+// variable length encoding of the index, so the concatenation is unambiguous.
+func utxoHashElement(key, serialized []byte) []byte {
+	element := make([]byte, len(key)+len(serialized))
+	copy(element, key)
+	copy(element[len(key):], serialized)
+	return element
+}
+
+// dbComputeUtxoHash computes the rolling hash of the whole utxo set.
+func dbComputeUtxoHash(dbTx database.Tx) (*muhash.MuHash, error) {
+	utxoHash := muhash.New()
+	cursor := dbTx.Metadata().Bucket(utxoSetBucketName).Cursor()
+	for ok := cursor.First(); ok; ok = cursor.Next() {
+		utxoHash.Add(utxoHashElement(cursor.Key(), cursor.Value()))
+	}
+	return utxoHash, nil
+}
+
+// FetchUtxoSetInfo scans the utxo set and returns its statistics along with its
+// rolling hash, as of the best block.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) FetchUtxoSetInfo() (*UtxoSetInfo, error) {
+	info := &UtxoSetInfo{}
+	assets := make(map[protos.Asset]*AssetUtxoInfo)
+	err := b.db.View(func(dbTx database.Tx) error {
+		// The chain state is read from the same database transaction as
+		// the utxo set, so both match the same block.
+		state, err := deserializeBestChainState(
+			dbTx.Metadata().Get(chainStateKeyName))
+		if err != nil {
+			return err
+		}
+		if state.utxoHash == nil {
+			return fmt.Errorf("the utxo set hash is not available")
+		}
+		utxoHash, err := muhash.Deserialize(state.utxoHash)
+		if err != nil {
+			return err
+		}
+		info.Hash = state.hash
+		info.Height = int32(state.height)
+		info.UtxoHash = utxoHash.Finalize()
+
+		lockBucket := dbTx.Metadata().Bucket(lockSetBucketName)
+		cursor := dbTx.Metadata().Bucket(utxoSetBucketName).Cursor()
+		var lastTx []byte
+		for ok := cursor.First(); ok; ok = cursor.Next() {
+			key := cursor.Key()
+			entry, err := DeserializeUtxoEntry(cursor.Value())
+			if err != nil {
+				return err
+			}
+
+			// The outpoint keys start with the transaction hash, so
+			// the outputs of a transaction are next to each other.
+			info.Utxos++
+			if !bytes.Equal(key[:common.HashLength], lastTx) {
+				info.Txs++
+				lastTx = common.CopyBytes(key[:common.HashLength])
+			}
+
+			asset := assets[*entry.Asset()]
+			if asset == nil {
+				asset = &AssetUtxoInfo{Asset: *entry.Asset()}
+				assets[*entry.Asset()] = asset
+			}
+			asset.Utxos++
+			if entry.Asset().IsIndivisible() {
+				continue
+			}
+			asset.Amount += entry.Amount()
+
+			// The votes lock the amount concurrently, so the locked
+			// part is the largest amount locked by one of them.
+			serializedLockItem := lockBucket.Get(key)
+			if len(serializedLockItem) == 0 {
+				continue
+			}
+			lockItem, err := txo.DeserializeLockItem(serializedLockItem)
+			if err != nil {
+				return err
+			}
+			var locked int64
+			for _, lockEntry := range lockItem.Entries {
+				if lockEntry.Amount > locked {
+					locked = lockEntry.Amount
+				}
+			}
+			if locked > entry.Amount() {
+				locked = entry.Amount()
+			}
+			asset.Locked += locked
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, asset := range assets {
+		info.Assets = append(info.Assets, asset)
+	}
+	sort.Slice(info.Assets, func(i, j int) bool {
+		a, b := info.Assets[i].Asset, info.Assets[j].Asset
+		if a.Property != b.Property {
+			return a.Property < b.Property
+		}
+		return a.Id < b.Id
+	})
+	return info, nil
+}
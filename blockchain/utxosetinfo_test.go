@@ -0,0 +1,89 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/crypto/muhash"
+	"github.com/AsimovNetwork/asimov/database"
+	"github.com/AsimovNetwork/asimov/protos"
+)
+
+// TestFetchUtxoSetInfo ensures the utxo set hash kept up to date by the block
+// connections matches the one of the whole utxo set.
+func TestFetchUtxoSetInfo(t *testing.T) {
+	parivateKeyList := []string{
+		"0xd0f0461b7b4d26cf370e6c73b58ef7fa26e8e30853a8cee901ed42cf0879cb6e", //privateKey0
+	}
+	accList, netParam, chain, teardownFunc, err := createFakeChainByPrivateKeys(parivateKeyList, 10)
+	defer teardownFunc()
+	if err != nil {
+		t.Fatalf("create fake chain error %v", err)
+	}
+
+	validators, filters, _ := chain.GetValidatorsByNode(1, chain.bestChain.tip())
+	for i := 0; i < 3; i++ {
+		block, _, err := createAndSignBlock(netParam, accList, validators, filters, chain, 1,
+			uint16(i), chain.bestChain.height(), protos.Asset{}, 0,
+			validators[i], nil, 0, chain.bestChain.tip())
+		if err != nil {
+			t.Fatalf("create block error %v", err)
+		}
+		if _, _, err = chain.ProcessBlock(block, nil, nil, nil, common.BFNone); err != nil {
+			t.Fatalf("ProcessBlock err %v", err)
+		}
+	}
+
+	info, err := chain.FetchUtxoSetInfo()
+	if err != nil {
+		t.Fatalf("FetchUtxoSetInfo err %v", err)
+	}
+	best := chain.BestSnapshot()
+	if info.Hash != best.Hash || info.Height != best.Height {
+		t.Errorf("FetchUtxoSetInfo got block %v (height %d), want %v (height %d)",
+			info.Hash, info.Height, best.Hash, best.Height)
+	}
+
+	var want *muhash.MuHash
+	var utxos uint64
+	err = chain.db.View(func(dbTx database.Tx) error {
+		var err error
+		want, err = dbComputeUtxoHash(dbTx)
+		if err != nil {
+			return err
+		}
+		cursor := dbTx.Metadata().Bucket(utxoSetBucketName).Cursor()
+		for ok := cursor.First(); ok; ok = cursor.Next() {
+			utxos++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("compute utxo set hash err %v", err)
+	}
+	if info.UtxoHash != want.Finalize() {
+		t.Errorf("FetchUtxoSetInfo got utxo set hash %v, want %v",
+			info.UtxoHash, want.Finalize())
+	}
+	if info.Utxos != utxos || info.Txs == 0 || info.Txs > utxos {
+		t.Errorf("FetchUtxoSetInfo got %d utxos of %d txs, want %d utxos",
+			info.Utxos, info.Txs, utxos)
+	}
+
+	var assetUtxos uint64
+	for _, asset := range info.Assets {
+		assetUtxos += asset.Utxos
+		if asset.Locked > asset.Amount {
+			t.Errorf("asset %v has %d locked out of %d", asset.Asset,
+				asset.Locked, asset.Amount)
+		}
+	}
+	if assetUtxos != utxos {
+		t.Errorf("FetchUtxoSetInfo got %d utxos over the assets, want %d",
+			assetUtxos, utxos)
+	}
+}
@@ -549,6 +549,38 @@ func CheckBlockSanity(block *asiutil.Block, parent *blockNode) error {
 	return checkBlockSanity(block, parent, common.BFNone)
 }
 
+// CheckProposal performs the checks on a block proposed to extend the best
+// chain which do not require to execute its transactions, so the consensus
+// engines which vote on blocks before they are connected can tell whether the
+// block would be accepted.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) CheckProposal(block *asiutil.Block) error {
+	b.chainLock.Lock()
+	defer b.chainLock.Unlock()
+
+	tip := b.bestChain.Tip()
+	header := &block.MsgBlock().Header
+	if header.PrevBlock != tip.hash {
+		str := fmt.Sprintf("block %v does not extend the best chain %v",
+			block.Hash(), tip.hash)
+		return ruleError(ErrPrevBlockNotBest, str)
+	}
+	if err := checkBlockSanity(block, tip, common.BFNone); err != nil {
+		return err
+	}
+
+	var err error
+	round := tip.round
+	for round.Round < header.Round {
+		round, err = b.roundManager.GetNextRound(round)
+		if err != nil {
+			return err
+		}
+	}
+	return b.checkBlockContext(block, tip, round, common.BFNone)
+}
+
 // ExtractCoinbaseHeight attempts to extract the height of the block from the
 // scriptSig of a coinbase transaction.  Coinbase heights are only present in
 // blocks of version 2 or later.  This was added as part of BIP0034.
@@ -1193,17 +1225,40 @@ func IsActive(addr *common.Address, curNode *blockNode) bool {
 
 // GetValidators depends on current round miners and pre-round stateRoot.
 func (b *BlockChain) GetValidators(round uint32) ([]*common.Address, map[common.Address]uint16, error) {
+	return b.GetValidatorsByNode(round, b.validatorsNode(round))
+}
+
+// validatorsNode returns the node of the best chain whose state holds the
+// validators of the round, the last node of the pre-round.  The validators of
+// the consensus_poa contract are read ValidatorActivationDelay rounds earlier,
+// so a change of the validators activates at a round known in advance.
+func (b *BlockChain) validatorsNode(round uint32) *blockNode {
+	return b.validatorsNodeFrom(b.bestChain.Tip(), round)
+}
+
+// validatorsNodeFrom returns the node whose state holds the validators of the
+// round on the chain ending at the passed node, as validatorsNode does on the
+// best chain.
+func (b *BlockChain) validatorsNodeFrom(node *blockNode, round uint32) *blockNode {
 	// found preround's last node
-	node := b.bestChain.Tip()
-	if round > node.round.Round+1 {
+	if node != nil && round > node.round.Round+1 {
 		log.Warnf("target round is too bigger than latest round: %d, %d", round, node.round.Round)
 	}
+	target := round
+	delay := b.chainParams.ValidatorActivationDelay
+	if b.roundManager.GetContract() == common.ConsensusPOA && delay > 0 {
+		if target > delay {
+			target -= delay
+		} else {
+			target = 1
+		}
+	}
 	for ; node != nil; node = node.parent {
-		if node.round.Round < round {
+		if node.round.Round < target {
 			break
 		}
 	}
-	return b.GetValidatorsByNode(round, node)
+	return node
 }
 
 // GetValidatorsByNode depends on current round miners and pre-round last node.
@@ -1249,6 +1304,31 @@ func (b *BlockChain) GetValidatorsByNode(round uint32, preroundLastNode *blockNo
 	return b.roundManager.GetValidators(preroundLastNode.hash, round, fn)
 }
 
+// GetPOAValidators returns the validators registered in the consensus_poa
+// contract which are active in the round, read from the same state as the
+// validators of GetValidators.
+func (b *BlockChain) GetPOAValidators(round uint32) ([]common.Address, error) {
+	node := b.validatorsNode(round)
+	if node == nil {
+		str := fmt.Sprintf("no block before round %d", round)
+		return nil, ruleError(ErrPreviousBlockUnknown, str)
+	}
+	block := asiutil.NewBlock(&protos.MsgBlock{
+		Header: protos.BlockHeader{
+			Timestamp: node.timestamp,
+			Height:    node.height,
+			StateRoot: node.stateRoot,
+		},
+	})
+	stateDB, _ := state.New(node.stateRoot, b.stateCache)
+	if stateDB == nil {
+		return nil, common.AssertError("stateDB is nil")
+	}
+	_, validators, err := b.contractManager.GetAdminsAndValidators(block,
+		stateDB, chaincfg.ActiveNetParams.FvmParam)
+	return validators, err
+}
+
 // checkConnectBlock performs several checks to confirm connecting the passed
 // block to the chain represented by the passed view does not violate any rules.
 // In addition, the passed view is updated to spend all of the referenced
@@ -1281,6 +1361,11 @@ func (b *BlockChain) checkConnectBlock(node *blockNode, block *asiutil.Block, vi
 	}
 
 	//contract related statedb info.
+	if b.stateGc != nil {
+		if err := b.regenerateState(node.parent); err != nil {
+			return nil, nil, err
+		}
+	}
 	statedb, err := state.New(node.parent.stateRoot, b.stateCache)
 	if err != nil {
 		return nil, nil, err
@@ -1503,9 +1588,6 @@ func (b *BlockChain) checkConnectBlock(node *blockNode, block *asiutil.Block, vi
 	if err != nil {
 		return nil, nil, err
 	}
-	if err := statedb.Database().TrieDB().Commit(stateRoot, false); err != nil {
-		return nil, nil, err
-	}
 	if !bytes.Equal(node.stateRoot[:], stateRoot[:]) {
 		return nil, nil, ruleError(ErrStateRootNotMatch, "state root of the block is not matched.")
 	}
@@ -6,6 +6,7 @@ package blockchain
 
 import (
 	"crypto/ecdsa"
+	"github.com/AsimovNetwork/asimov/ainterface"
 	"github.com/AsimovNetwork/asimov/asiutil"
 	"github.com/AsimovNetwork/asimov/blockchain/txo"
 	"github.com/AsimovNetwork/asimov/chaincfg"
@@ -2642,3 +2643,50 @@ func TestCheckConnectBlock(t *testing.T) {
 		}
 	}
 }
+
+// poaRoundManager is the round manager of the tests with the contract of the
+// POA consensus.
+type poaRoundManager struct {
+	*RoundManager
+}
+
+func (m poaRoundManager) GetContract() common.ContractCode {
+	return common.ConsensusPOA
+}
+
+// TestValidatorsNodeFrom ensures the validators of a round are read from the
+// last node of the round ValidatorActivationDelay rounds before its pre-round.
+func TestValidatorsNodeFrom(t *testing.T) {
+	// The genesis block of round 0, then two blocks per round up to round 6.
+	var tip *blockNode
+	for i := 0; i < 13; i++ {
+		round := &ainterface.Round{Round: uint32((i + 1) / 2)}
+		tip = newBlockNode(round, &protos.BlockHeader{Height: int32(i)}, tip)
+	}
+
+	tests := []struct {
+		delay  uint32
+		round  uint32
+		height int32
+	}{
+		{0, 6, 10},
+		{0, 2, 2},
+		{2, 6, 6},
+		{2, 4, 2},
+		{2, 3, 0},
+		{4, 6, 2},
+	}
+	for _, test := range tests {
+		params := chaincfg.DevelopNetParams
+		params.ValidatorActivationDelay = test.delay
+		chain := &BlockChain{
+			chainParams:  &params,
+			roundManager: poaRoundManager{NewRoundManager()},
+		}
+		node := chain.validatorsNodeFrom(tip, test.round)
+		if node == nil || node.height != test.height {
+			t.Errorf("validatorsNodeFrom(round %d, delay %d) = %v, want height %d",
+				test.round, test.delay, node, test.height)
+		}
+	}
+}
@@ -5,6 +5,7 @@
 package chaincfg
 
 import (
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -38,6 +39,7 @@ const (
 	DefaultMaxRPCClients        = 10
 	DefaultMaxRPCWebsockets     = 25
 	DefaultMaxRPCConcurrentReqs = 20
+	DefaultMaxRPCLogRange       = 10000
 	// DefaultMinTxPrice is the minimum price in xing that is required
 	// for a transaction to be treated as free for relay and mining
 	// purposes.  It is also used to help determine if a transaction is
@@ -49,6 +51,14 @@ const (
 	DefaultAutoSignUpGasLimit    = 300000
 	DefaultMergeLimit            = 10
 
+	// DefaultGcMode is the default garbage collection mode of the contract
+	// state, which keeps the states of all blocks.
+	DefaultGcMode = GcModeArchive
+
+	// DefaultStateRetention is the default number of recent block states
+	// kept in memory when the contract state is garbage collected.
+	DefaultStateRetention = 128
+
 	// DefaultMaxTimeOffsetSeconds is the maximum number of seconds a block
 	// time is allowed to be ahead of the current time.
 	DefaultMaxTimeOffsetSeconds = 30
@@ -96,6 +106,16 @@ var (
 	DefaultWSModules        = []string{"net", "web3"}
 )
 
+// Garbage collection modes of the contract state.
+const (
+	// GcModeArchive persists the state of every connected block.
+	GcModeArchive = "archive"
+
+	// GcModeFull only keeps the states of the recent blocks, periodically
+	// persisting one of them.
+	GcModeFull = "full"
+)
+
 // runServiceCommand is only set to a real function on Windows.  It is used
 // to parse and execute service commands specified via the -s flag.
 var runServiceCommand func(string) error
@@ -129,6 +149,7 @@ type FConfig struct {
 	RPCMaxClients        int           `long:"rpcmaxclients" description:"Max number of RPC clients for standard connections"`
 	RPCMaxWebsockets     int           `long:"rpcmaxwebsockets" description:"Max number of RPC websocket connections"`
 	RPCMaxConcurrentReqs int           `long:"rpcmaxconcurrentreqs" description:"Max number of concurrent RPC requests that may be processed concurrently"`
+	RPCMaxLogRange       int32         `long:"rpcmaxlogrange" description:"Max number of blocks a single log query may cover"`
 	DisableRPC           bool          `long:"norpc" description:"Disable built-in RPC server -- NOTE: The RPC server is disabled by default if no rpcuser/rpcpass or rpclimituser/rpclimitpass is specified"`
 	DisableTLS           bool          `long:"notls" description:"Disable TLS for the RPC server -- NOTE: This is only allowed if the RPC server is bound to localhost"`
 	DisableDNSSeed       bool          `long:"nodnsseed" description:"Disable DNS seeding for peers"`
@@ -157,8 +178,10 @@ type FConfig struct {
 	UtxoValidateTimeOut  float64       `long:"utxovalidatetimeout" description:"the time for validating utxos,the value must be in range of (0, 1)"`
 	MaxOrphanTxs         int           `long:"maxorphantx" description:"Max number of orphan transactions to keep in memory"`
 	MaxOrphanTxSize      int           `long:"maxorphantxsize" description:"Max size of an orphan transaction to allow in memory"`
+	NoPersistMempool     bool          `long:"nopersistmempool" description:"Do not save the mempool on shutdown and load it on start up"`
 	Consensustype        string        `long:"consensustype" description:"Consensus type which the server uses"`
 	Privatekey           string        `long:"privatekey" description:"Add the private key which is used to assign block header for generated blocks"`
+	ValidatorKeys        string        `long:"validatorkeys" description:"File of additional private keys of the validator, one per line, reloaded when it changes"`
 	UserAgentComments    []string      `long:"uacomment" description:"Comment to add to the user agent -- See BIP 14 for more information."`
 	NoPeerBloomFilters   bool          `long:"nopeerbloomfilters" description:"Disable bloom filtering support"`
 	NoCFilters           bool          `long:"nocfilters" description:"Disable committed filtering (CF) support"`
@@ -167,8 +190,21 @@ type FConfig struct {
 	EmptyRound           bool          `long:"emptyround" description:"Allow round contains no blocks."`
 	DropTxIndex          bool          `long:"droptxindex" description:"Deletes the hash-based transaction index from the database on start up and then exits."`
 	DropAddrIndex        bool          `long:"dropaddrindex" description:"Deletes the address-based transaction index from the database on start up and then exits."`
+	BloomIndex           bool          `long:"bloomindex" description:"Maintain the bloom bits index used to speed up log queries"`
+	DropBloomIndex       bool          `long:"dropbloomindex" description:"Deletes the bloom bits index used to speed up log queries from the database on start up and then exits."`
 	MaxTimeOffset        int           `long:"maxtimeoffset" description:"The maximum number of seconds a block time is allowed to be ahead of the current time, it is allowd to take [5-30]."`
 	MergeLimit           int           `long:"mergeLimit" description:"It is a miner strategy that miner can merge its utxo and push into block."`
+	GcMode               string        `long:"gcmode" description:"Garbage collection mode of the contract state {full, archive} -- full only keeps the states of the recent blocks"`
+	StateRetention       int32         `long:"stateretention" description:"Number of recent block states kept in memory when the gcmode is full"`
+	ExportSnapshot       string        `long:"exportsnapshot" description:"Writes a snapshot of the chain state to the given file on start up and then exits."`
+	SnapshotHeight       int32         `long:"snapshotheight" description:"Height of the main chain block whose state is written by exportsnapshot (0 selects the best block)"`
+	ImportSnapshot       string        `long:"importsnapshot" description:"Bootstraps an empty node from the chain state snapshot in the given file on start up"`
+	SnapshotUtxoHash     string        `long:"snapshotutxohash" description:"Trusted utxo set hash of the snapshot block, as reported by gettxoutsetinfo, which the snapshot of importsnapshot must match"`
+	StateSync            bool          `long:"statesync" description:"Download the contract state of the latest checkpoint from the peers instead of executing the blocks preceding it"`
+	SPV                  bool          `long:"spv" description:"Run as a light client which only downloads the headers, and the blocks of the watched addresses from the --connect or --addpeer peers"`
+	SPVAddresses         []string      `long:"spvaddress" description:"Add an address watched by the light client"`
+	SPVValidators        []string      `long:"spvvalidator" description:"Add an address allowed to sign the headers followed by the light client, in the order of the consensus_poa contract which gives their slots -- the genesis candidates are used when none is given"`
+	SPVValidatorSets     []string      `long:"spvvalidatorset" description:"Set the ordered validators of the light client from a round on.  Format: '<round>:<address>[,<address>...]'"`
 	AddCheckpoints       []Checkpoint
 	Whitelists           []*net.IPNet
 
@@ -355,6 +391,7 @@ func LoadConfig() (*FConfig, []string, error) {
 		RPCMaxClients:        DefaultMaxRPCClients,
 		RPCMaxWebsockets:     DefaultMaxRPCWebsockets,
 		RPCMaxConcurrentReqs: DefaultMaxRPCConcurrentReqs,
+		RPCMaxLogRange:       DefaultMaxRPCLogRange,
 		DataDir:              DefaultDataDir,
 		LogDir:               DefaultLogDir,
 		StateDir:             DefaultStateDir,
@@ -369,6 +406,8 @@ func LoadConfig() (*FConfig, []string, error) {
 		EmptyRound:           false,
 		MaxTimeOffset:        DefaultMaxTimeOffsetSeconds,
 		MergeLimit:           DefaultMergeLimit,
+		GcMode:               DefaultGcMode,
+		StateRetention:       DefaultStateRetention,
 
 		HTTPEndpoint:     DefaultHTTPEndPoint,
 		HTTPModules:      DefaultHttpModules,
@@ -495,15 +534,6 @@ func LoadConfig() (*FConfig, []string, error) {
 	cfg.GenesisBlockFile = filepath.Join(cfg.GenesisPath, genesisBlock)
 	cfg.GenesisParamFile = filepath.Join(cfg.GenesisPath, DefaultGenesisFilename)
 
-	consensus := common.GetConsensus(cfg.Consensustype)
-	if consensus < 0 {
-		str := "%s: The consensus type is not support"
-		err := fmt.Errorf(str, funcName)
-		fmt.Fprintln(os.Stderr, err)
-		fmt.Fprintln(os.Stderr, usageMessage)
-		return nil, nil, err
-	}
-
 	// Append the network type to the data directory so it is "namespaced"
 	// per network.  In addition to the block database, there are other
 	// pieces of data that are saved to disk such as address manager state.
@@ -651,6 +681,15 @@ func LoadConfig() (*FConfig, []string, error) {
 		return nil, nil, err
 	}
 
+	if cfg.RPCMaxLogRange < 1 {
+		str := "%s: The rpcmaxlogrange option may not be less than 1 " +
+			"-- parsed [%d]"
+		err := fmt.Errorf(str, funcName, cfg.RPCMaxLogRange)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
 	if cfg.MinTxPrice < DefaultMinTxPrice {
 		str := "%s: MinTxPrice is too low, at least %f"
 		err := fmt.Errorf(str, funcName, DefaultMinTxPrice)
@@ -686,6 +725,89 @@ func LoadConfig() (*FConfig, []string, error) {
 		return nil, nil, err
 	}
 
+	// Validate the garbage collection mode of the contract state.
+	if cfg.GcMode != GcModeFull && cfg.GcMode != GcModeArchive {
+		str := "%s: The gcmode option must be either %s or %s " +
+			"-- parsed [%v]"
+		err := fmt.Errorf(str, funcName, GcModeFull, GcModeArchive,
+			cfg.GcMode)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+	if cfg.StateRetention < 1 {
+		str := "%s: The stateretention option may not be less than 1 " +
+			"-- parsed [%d]"
+		err := fmt.Errorf(str, funcName, cfg.StateRetention)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// Validate the chain state snapshot options.
+	if cfg.ExportSnapshot != "" && cfg.ImportSnapshot != "" {
+		str := "%s: The exportsnapshot and importsnapshot options may " +
+			"not be activated at the same time"
+		err := fmt.Errorf(str, funcName)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+	if cfg.SnapshotHeight < 0 {
+		str := "%s: The snapshotheight option may not be less than 0 " +
+			"-- parsed [%d]"
+		err := fmt.Errorf(str, funcName, cfg.SnapshotHeight)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+	if cfg.ExportSnapshot != "" {
+		cfg.ExportSnapshot = cleanAndExpandPath(cfg.ExportSnapshot)
+	}
+	if cfg.ImportSnapshot != "" {
+		cfg.ImportSnapshot = cleanAndExpandPath(cfg.ImportSnapshot)
+
+		utxoHash, err := hex.DecodeString(strings.TrimPrefix(cfg.SnapshotUtxoHash, "0x"))
+		if err != nil || len(utxoHash) != common.HashLength {
+			str := "%s: The importsnapshot option requires the trusted " +
+				"utxo set hash of the snapshot block with snapshotutxohash " +
+				"-- parsed [%s]"
+			err := fmt.Errorf(str, funcName, cfg.SnapshotUtxoHash)
+			fmt.Fprintln(os.Stderr, err)
+			fmt.Fprintln(os.Stderr, usageMessage)
+			return nil, nil, err
+		}
+	}
+
+	// Validate the light client options.
+	if cfg.SPV && len(cfg.AddPeers) == 0 && len(cfg.ConnectPeers) == 0 {
+		str := "%s: The spv option requires the full nodes to connect " +
+			"to with --connect or --addpeer"
+		err := fmt.Errorf(str, funcName)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+	if cfg.SPV && (cfg.ExportSnapshot != "" || cfg.ImportSnapshot != "" ||
+		cfg.StateSync) {
+		str := "%s: The spv option may not be activated along with the " +
+			"exportsnapshot, importsnapshot or statesync options"
+		err := fmt.Errorf(str, funcName)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// --bloomindex and --dropbloomindex do not mix.
+	if cfg.BloomIndex && cfg.DropBloomIndex {
+		err := fmt.Errorf("%s: the --bloomindex and --dropbloomindex "+
+			"options may not be activated at the same time",
+			funcName)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
 	// Limit the max orphan count to a sane vlue.
 	if cfg.MaxOrphanTxs < 0 {
 		str := "%s: The maxorphantx option may not be less than 0 " +
@@ -846,5 +968,14 @@ func LoadGenesis(path string) error {
 		return errors.New("ChainStartTime must be greater than 0")
 	}
 	ActiveNetParams.ChainStartTime = params.ChainStartTime
+	ActiveNetParams.ValidatorActivationDelay = params.ValidatorActivationDelay
+	ActiveNetParams.BFT = params.BFT
+	for name, height := range params.ContractUpgrades {
+		if height <= 0 {
+			return fmt.Errorf("the height of the contract upgrade %s must be "+
+				"greater than 0", name)
+		}
+	}
+	ActiveNetParams.ContractUpgrades = params.ContractUpgrades
 	return nil
 }
@@ -134,6 +134,20 @@ type Params struct {
 	// max number for a validator keep alive.
 	KeepAliveInterval uint32
 
+	// ValidatorActivationDelay is the number of block rounds a change of the
+	// validators of the consensus_poa contract waits before it activates.
+	// A change made in round r takes effect in round r+1+delay.
+	ValidatorActivationDelay uint32
+
+	// BFT requires the blocks following the genesis block to carry the
+	// precommits of more than two thirds of the validators, as gathered by
+	// the bft consensus.  The committed blocks can not be reorganized.
+	BFT bool
+
+	// ContractUpgrades maps the names of the upgrades of the system
+	// contracts the chain activates to the height of their activation.
+	ContractUpgrades map[string]int32
+
 	// Checkpoints ordered from oldest to newest.
 	Checkpoints []Checkpoint
 
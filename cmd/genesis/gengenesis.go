@@ -225,6 +225,7 @@ func main() {
 	// first step:
 	//fmt.Println("Step 1, write system contracts start...")
 	//writeSystemContract(*systemContractFolder, network)
+	//writeUpgradeContracts(*systemContractFolder)
 	//fmt.Println("Step 1, write system contracts end...")
 
 	// second step:
@@ -63,6 +63,62 @@ func writeSystemContract(systemContractFolder, network string) {
 	}
 }
 
+// writeUpgradeContracts compiles the new versions of the system contracts in
+// the upgrades folder, which the chains deploy at the heights of their
+// contract upgrades.  The genesis contracts are copied next to them, since
+// the new versions derive from them.
+func writeUpgradeContracts(systemContractFolder string) {
+	upgrades := getFiles(systemContractFolder + "/upgrades")
+	contracts := getFiles(systemContractFolder)
+
+	err := os.Chdir("cmd/genesis")
+	if err != nil {
+		panic(err)
+	}
+
+	contractSlice := make([]*compiledContract, 0)
+	for _, upgrade := range upgrades {
+		contractName, byteCode, abi := compileUpgrade(upgrade, contracts)
+		contractSlice = append(contractSlice, &compiledContract{
+			name:     contractName,
+			byteCode: byteCode,
+			abi:      abi,
+		})
+	}
+
+	writeUpgrades(contractSlice)
+
+	err = os.Chdir("../../")
+	if err != nil {
+		panic(err)
+	}
+}
+
+func writeUpgrades(contractSlice []*compiledContract) {
+	file, err := os.Create("../../blockchain/syscontract/upgradecontracts.go")
+	if err != nil {
+		panic(err)
+	}
+	defer file.Close()
+
+	imports := `// Code generated by github.com/AsimovNetwork/asimov/cmd/genesis/write_contract. DO NOT EDIT.
+
+package syscontract
+
+var upgradeContracts = map[string]compiledUpgrade{
+`
+	writeBytes(file, []byte(imports))
+	for _, v := range contractSlice {
+		temp := `	%q: {
+		Code:    "%s",
+		AbiInfo: "%s",
+	},
+`
+		writeBytes(file, []byte(fmt.Sprintf(temp, v.name, v.byteCode, strings.Replace(v.abi, "\"", "\\\"", -1))))
+	}
+	writeBytes(file, []byte("}\n"))
+}
+
 func writeContractsName(contractSlice []*compiledContract) {
 	file, err := os.Create("../../common/genesis_contracts.go")
 	if err != nil {
@@ -302,6 +358,35 @@ func compile(path string) (string, string, string, string) {
 	return contractName, byteCode, abi, delegateAddr
 }
 
+func compileUpgrade(path string, contracts []string) (string, string, string) {
+	os.Mkdir("temp", os.ModePerm)
+	os.Chdir("temp")
+	os.Mkdir("library", os.ModePerm)
+	os.Mkdir("upgrades", os.ModePerm)
+
+	// copy the genesis contracts which the upgrades derive from
+	for _, contract := range contracts {
+		copySource(contract)
+	}
+
+	os.Chdir("library")
+	copyImport()
+
+	// copy the files to be compiled to the upgrades of the temporary directory
+	os.Chdir("../upgrades")
+	fileName := "upgrades/" + copySource(path)
+
+	// compile file by solc compiler
+	os.Chdir("..")
+	contractName, byteCode, abi := callSolc(fileName)
+
+	// delete temporary folder
+	os.Chdir("../")
+	os.RemoveAll("temp")
+
+	return contractName, byteCode, abi
+}
+
 func copySource(source string) string {
 	src, err := os.Open(source)
 	if err != nil {
@@ -0,0 +1,100 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/AsimovNetwork/asimov/chaincfg"
+	"github.com/jessevdk/go-flags"
+)
+
+const (
+	// defaultBloomSize is the default size in MB of the bloom filter of
+	// the live state nodes.  It gives a false positive rate well below
+	// one percent for a hundred million nodes.
+	defaultBloomSize = 256
+)
+
+var (
+	activeNetParams = &chaincfg.MainNetParams
+)
+
+// config defines the configuration options for stateprune.
+//
+// See loadConfig for details on the configuration load process.
+type config struct {
+	DataDir    string `short:"b" long:"datadir" description:"Location of the asimovd data directory"`
+	StateDir   string `long:"statedir" description:"Location of the asimovd state directory"`
+	TestNet    bool   `long:"testnet" description:"Use the test network"`
+	DevelopNet bool   `long:"devnet" description:"Use the develop network"`
+	Retention  int32  `short:"r" long:"retention" description:"Number of recent block states to keep, including the best chain one"`
+	BloomSize  uint64 `long:"bloomsize" description:"Size in MB of the bloom filter of the live state nodes"`
+}
+
+// loadConfig initializes and parses the config using command line options.
+func loadConfig() (*config, []string, error) {
+	// Default config.
+	cfg := config{
+		DataDir:   chaincfg.DefaultDataDir,
+		StateDir:  chaincfg.DefaultStateDir,
+		Retention: chaincfg.DefaultStateRetention,
+		BloomSize: defaultBloomSize,
+	}
+
+	// Parse command line options.
+	parser := flags.NewParser(&cfg, flags.Default)
+	remainingArgs, err := parser.Parse()
+	if err != nil {
+		if e, ok := err.(*flags.Error); !ok || e.Type != flags.ErrHelp {
+			parser.WriteHelp(os.Stderr)
+		}
+		return nil, nil, err
+	}
+
+	// Multiple networks can't be selected simultaneously.
+	funcName := "loadConfig"
+	numNets := 0
+	if cfg.TestNet {
+		numNets++
+		activeNetParams = &chaincfg.TestNetParams
+	}
+	if cfg.DevelopNet {
+		numNets++
+		activeNetParams = &chaincfg.DevelopNetParams
+	}
+	if numNets > 1 {
+		str := "%s: The testnet and devnet params can't be used " +
+			"together -- choose one of the two"
+		err := fmt.Errorf(str, funcName)
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+
+	if cfg.Retention < 1 {
+		str := "%s: The retention may not be less than 1 -- parsed [%d]"
+		err := fmt.Errorf(str, funcName, cfg.Retention)
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+	if cfg.BloomSize < 1 {
+		str := "%s: The bloom size may not be less than 1 MB -- parsed [%d]"
+		err := fmt.Errorf(str, funcName, cfg.BloomSize)
+		fmt.Fprintln(os.Stderr, err)
+		parser.WriteHelp(os.Stderr)
+		return nil, nil, err
+	}
+
+	// The directories are namespaced per network the same way asimovd
+	// does.
+	cfg.DataDir = filepath.Join(cfg.DataDir, activeNetParams.Name())
+	cfg.StateDir = filepath.Join(cfg.StateDir, activeNetParams.Name())
+
+	return &cfg, remainingArgs, nil
+}
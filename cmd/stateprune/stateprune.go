@@ -0,0 +1,86 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// stateprune deletes from the state database of asimovd the trie nodes and
+// contract codes which are not reachable from the states of the most recent
+// blocks of the best chain.  It must be run while asimovd is stopped.
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/AsimovNetwork/asimov/blockchain"
+	"github.com/AsimovNetwork/asimov/database"
+	"github.com/AsimovNetwork/asimov/database/dbdriver"
+	"github.com/AsimovNetwork/asimov/database/dbimpl/ethdb"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/state/pruner"
+	"github.com/AsimovNetwork/asimov/vm/fvm/log"
+)
+
+const blockDbNamePrefix = "blocks"
+
+var (
+	cfg *config
+)
+
+// loadBlockDB opens the block database and returns a handle to it.
+func loadBlockDB() (database.Database, error) {
+	// The database name is based on the database type.
+	dbName := blockDbNamePrefix + "_" + database.FFLDB
+	dbPath := filepath.Join(cfg.DataDir, dbName)
+	fmt.Printf("Loading block database from '%s'\n", dbPath)
+	return dbdriver.Open(database.FFLDB, dbPath, activeNetParams.Net)
+}
+
+// realMain is the real main function for the utility.  It is necessary to work
+// around the fact that deferred functions do not run when os.Exit() is called.
+func realMain() error {
+	// Load configuration and parse command line.
+	tcfg, _, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	cfg = tcfg
+	log.Root().SetHandler(log.LvlFilterHandler(log.LvlInfo, log.StdoutHandler))
+
+	// Both databases are locked while asimovd runs, so failing to open
+	// them most likely means it is still running.
+	db, err := loadBlockDB()
+	if err != nil {
+		return fmt.Errorf("failed to open the block database, make sure "+
+			"asimovd is not running: %v", err)
+	}
+	defer db.Close()
+
+	fmt.Printf("Loading state database from '%s'\n", cfg.StateDir)
+	stateDB, err := ethdb.NewLDBDatabase(cfg.StateDir, 768, 1024)
+	if err != nil {
+		return fmt.Errorf("failed to open the state database, make sure "+
+			"asimovd is not running: %v", err)
+	}
+	defer stateDB.Close()
+
+	roots, err := blockchain.FetchStateRoots(db, cfg.Retention)
+	if err != nil {
+		return fmt.Errorf("failed to fetch the state roots of the best "+
+			"chain: %v", err)
+	}
+
+	stats, err := pruner.Prune(stateDB, roots, cfg.BloomSize*1024*1024)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Kept %d states of %d live nodes, deleted %d keys (%v)\n",
+		len(stats.Roots), stats.Live, stats.Deleted, stats.Size)
+	return nil
+}
+
+func main() {
+	if err := realMain(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -5,12 +5,29 @@
 package main
 
 import (
+	"fmt"
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/coinselect"
 	"github.com/jessevdk/go-flags"
 	"os"
+	"path/filepath"
 )
 
 const (
 	defaultFormat = "hex"
+	defaultWalletFileName = "wallet.json"
+	defaultRPCServer = "http://127.0.0.1:8545"
+	defaultGapLimit = 20
+	defaultGasLimit = 21000
+
+	// defaultCoinType is the BIP44 coin type of asimov accounts.  It is not
+	// a registered SLIP-0044 value, so it can be overridden to share a seed
+	// with other wallets.
+	defaultCoinType = 10888
+)
+
+var (
+	defaultWalletFile = filepath.Join(asiutil.AppDataDir("asimovwallet", false), defaultWalletFileName)
 )
 
 // config defines the configuration options for findcheckpoint.
@@ -18,9 +35,18 @@ const (
 // See loadConfig for details on the configuration load process.
 type config struct {
 	Help       bool `short:"h" long:"help" description:"Show usage."`
-	Cmd        string `short:"c" long:"cmd" description:"Command: genKey, genMultiSigAddress"`
+	Cmd        string `short:"c" long:"cmd" description:"Command: genKey, genMultiSigAddress, signPsbt, createWallet, importSeed, newAccount, newAddress, listAddresses, scan, getBalance, send"`
 	Format     string `short:"f" long:"format" description:"in/out format, currently, support hex, base64"`
 	Net        string `short:"n" long:"net" description:"support main,dev,test,regtest,default is main"`
+	WalletFile string `long:"walletfile" description:"Path to the HD wallet file"`
+	RPCServer  string `long:"rpcserver" description:"URL of the node RPC server, http or ws"`
+	Account    uint32 `long:"account" description:"BIP44 account index of the HD wallet"`
+	CoinType   uint32 `long:"cointype" description:"BIP44 coin type used when creating an HD wallet"`
+	GapLimit   uint32 `long:"gaplimit" description:"Number of consecutive unused addresses ending an address scan"`
+	FeeAsset   string `long:"feeasset" description:"Hex encoded asset the fee of send is paid with, default is asim"`
+	Fee        int64  `long:"fee" description:"Fee of send in the fee asset"`
+	GasLimit   uint32 `long:"gaslimit" description:"Gas limit of send"`
+	Strategy   string `long:"strategy" description:"Coin selection strategy of send: largestfirst, branchandbound, mininputcount"`
 }
 
 
@@ -28,7 +54,13 @@ type config struct {
 func loadConfig() (*config, []string, error) {
 	// Default config.
 	cfg := config{
-		Format: defaultFormat,
+		Format:     defaultFormat,
+		WalletFile: defaultWalletFile,
+		RPCServer:  defaultRPCServer,
+		CoinType:   defaultCoinType,
+		GapLimit:   defaultGapLimit,
+		GasLimit:   defaultGasLimit,
+		Strategy:   coinselect.LargestFirst.String(),
 	}
 
 	// Parse command line options.
@@ -50,6 +82,15 @@ func loadConfig() (*config, []string, error) {
 		parser.WriteHelp(os.Stderr)
 		return nil, nil, err
 	}
+	if cfg.GapLimit == 0 {
+		err := fmt.Errorf("gaplimit must be greater than 0")
+		fmt.Fprintln(os.Stderr, err)
+		return nil, nil, err
+	}
+	if _, err := coinselect.ParseStrategy(cfg.Strategy); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil, nil, err
+	}
 
 	return &cfg, remainingArgs, nil
 }
\ No newline at end of file
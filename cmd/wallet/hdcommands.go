@@ -0,0 +1,245 @@
+// Copyright (c) 2018-2020. The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/AsimovNetwork/asimov/coinselect"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/common/hexutil"
+	"github.com/AsimovNetwork/asimov/crypto/hdkeychain"
+)
+
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// errNotTerminal is returned by disableEcho when stdin is not a terminal.
+var errNotTerminal = errors.New("not a terminal")
+
+// readPassphrase prompts for a passphrase on stderr and reads it from stdin.
+// When confirm is set the passphrase has to be entered twice.
+func readPassphrase(confirm bool) (string, error) {
+	pass, err := readSecret("Passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if !confirm {
+		return pass, nil
+	}
+	again, err := readSecret("Repeat passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if again != pass {
+		return "", fmt.Errorf("passphrases do not match")
+	}
+	return pass, nil
+}
+
+// readSecret prompts on stderr and reads a line from stdin.  The terminal does
+// not echo the line, which is only read as is when stdin is not a terminal.
+func readSecret(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	restore, err := disableEcho(os.Stdin.Fd())
+	switch {
+	case err == nil:
+		defer func() {
+			restore()
+			fmt.Fprintln(os.Stderr)
+		}()
+	case err != errNotTerminal:
+		return "", err
+	}
+	line, err := stdinReader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// createWallet creates a new wallet file from the given seed, or from a
+// random seed when seed is nil, and derives its first account.
+func createWallet(cfg *config, seed []byte) error {
+	if _, err := os.Stat(cfg.WalletFile); err == nil {
+		return fmt.Errorf("wallet file %s already exists", cfg.WalletFile)
+	}
+	if seed == nil {
+		seed = make([]byte, hdkeychain.RecommendedSeedLen)
+		if _, err := rand.Read(seed); err != nil {
+			return err
+		}
+	}
+	master, err := hdkeychain.NewMaster(seed)
+	if err != nil {
+		return err
+	}
+	passphrase, err := readPassphrase(true)
+	if err != nil {
+		return err
+	}
+	ks, err := newKeystore(cfg.WalletFile, seed, passphrase, cfg.CoinType)
+	if err != nil {
+		return err
+	}
+	if _, err := ks.addAccount(master, cfg.Account); err != nil {
+		return err
+	}
+	if err := ks.save(); err != nil {
+		return err
+	}
+	fmt.Println("Wallet created:", cfg.WalletFile)
+	fmt.Println("    Seed (keep it safe, it restores the wallet):", hex.EncodeToString(seed))
+	return nil
+}
+
+// newAccount derives the account selected by --account into the wallet.
+func newAccount(cfg *config) error {
+	ks, err := loadKeystore(cfg.WalletFile)
+	if err != nil {
+		return err
+	}
+	passphrase, err := readPassphrase(false)
+	if err != nil {
+		return err
+	}
+	master, err := ks.masterKey(passphrase)
+	if err != nil {
+		return err
+	}
+	if _, err := ks.addAccount(master, cfg.Account); err != nil {
+		return err
+	}
+	if err := ks.save(); err != nil {
+		return err
+	}
+	fmt.Println("Account", cfg.Account, "created")
+	return nil
+}
+
+// runWalletCmd executes the commands working on an existing account.
+func runWalletCmd(cfg *config, args []string) error {
+	w, err := openWallet(cfg)
+	if err != nil {
+		return err
+	}
+	defer w.close()
+
+	switch cfg.Cmd {
+	case "newAddress":
+		addr, err := w.nextAddress(hdkeychain.ExternalBranch)
+		if err != nil {
+			return err
+		}
+		fmt.Println(addr.String())
+
+	case "listAddresses":
+		addrs, err := w.addresses()
+		if err != nil {
+			return err
+		}
+		for _, addr := range addrs {
+			fmt.Printf("m/%d'/%d'/%d'/%d/%d %s\n", hdkeychain.BIP44Purpose,
+				w.ks.CoinType, w.acct.Index, addr.branch, addr.index,
+				addr.address.String())
+		}
+
+	case "scan":
+		if err := w.scan(); err != nil {
+			return err
+		}
+		fmt.Println("Next receiving address index:", w.acct.NextExternal)
+		fmt.Println("Next change address index:", w.acct.NextInternal)
+
+	case "getBalance":
+		divisible, vouchers, err := w.balances()
+		if err != nil {
+			return err
+		}
+		for asset, value := range divisible {
+			fmt.Println(asset, value)
+		}
+		for asset, ids := range vouchers {
+			fmt.Println(asset, ids)
+		}
+
+	case "send":
+		return sendCmd(cfg, w, args)
+	}
+	return nil
+}
+
+// sendCmd parses the outputs given as address,amount[,asset] arguments and
+// sends them.  The amount of an indivisible asset is its voucher id.
+func sendCmd(cfg *config, w *hdWallet, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("send requires at least one address,amount[,asset] argument")
+	}
+	targets := make([]coinselect.Target, 0, len(args))
+	recipients := make([]*common.Address, 0, len(args))
+	for _, arg := range args {
+		fields := strings.Split(arg, ",")
+		if len(fields) < 2 || len(fields) > 3 {
+			return fmt.Errorf("invalid output %q", arg)
+		}
+		addrBytes, err := hexutil.Decode(fields[0])
+		if err != nil {
+			return fmt.Errorf("invalid address %q: %v", fields[0], err)
+		}
+		addr, err := common.NewAddress(addrBytes)
+		if err != nil {
+			return fmt.Errorf("invalid address %q: %v", fields[0], err)
+		}
+		amount, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil || amount <= 0 {
+			return fmt.Errorf("invalid amount %q", fields[1])
+		}
+		var assetStr string
+		if len(fields) == 3 {
+			assetStr = fields[2]
+		}
+		asset, err := parseAsset(assetStr)
+		if err != nil {
+			return err
+		}
+		targets = append(targets, coinselect.Target{Asset: asset, Amount: amount})
+		recipients = append(recipients, addr)
+	}
+
+	feeAsset, err := parseAsset(cfg.FeeAsset)
+	if err != nil {
+		return err
+	}
+	strategy, err := coinselect.ParseStrategy(cfg.Strategy)
+	if err != nil {
+		return err
+	}
+	opts := &coinselect.Options{
+		Strategy: strategy,
+		FeeAsset: feeAsset,
+		Fee:      cfg.Fee,
+	}
+
+	passphrase, err := readPassphrase(false)
+	if err != nil {
+		return err
+	}
+	master, err := w.ks.masterKey(passphrase)
+	if err != nil {
+		return err
+	}
+	txHash, err := w.send(master, targets, recipients, opts, cfg.GasLimit)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Transaction sent:", txHash)
+	return nil
+}
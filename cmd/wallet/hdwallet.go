@@ -0,0 +1,344 @@
+// Copyright (c) 2018-2020. The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/coinselect"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/crypto"
+	"github.com/AsimovNetwork/asimov/crypto/hdkeychain"
+	"github.com/AsimovNetwork/asimov/protos"
+	"github.com/AsimovNetwork/asimov/rpcs/rpc"
+	"github.com/AsimovNetwork/asimov/rpcs/rpcjson"
+	"github.com/AsimovNetwork/asimov/txscript"
+)
+
+// hdWallet is a BIP44 account of a wallet file connected to a node.
+type hdWallet struct {
+	ks       *keystore
+	acct     *accountJSON
+	acctKey  *hdkeychain.ExtendedKey
+	client   *rpc.Client
+	gapLimit uint32
+}
+
+// walletAddress is an address of the account together with its derivation.
+type walletAddress struct {
+	branch  uint32
+	index   uint32
+	address *common.Address
+}
+
+// openWallet loads the account of the wallet file and connects to the node.
+// The connection is only established when rpcServer is not empty.
+func openWallet(cfg *config) (*hdWallet, error) {
+	ks, err := loadKeystore(cfg.WalletFile)
+	if err != nil {
+		return nil, err
+	}
+	acct := ks.account(cfg.Account)
+	if acct == nil {
+		return nil, fmt.Errorf("account %d does not exist, create it with "+
+			"the newAccount command", cfg.Account)
+	}
+	acctKey, err := acct.extendedKey()
+	if err != nil {
+		return nil, err
+	}
+	w := &hdWallet{
+		ks:       ks,
+		acct:     acct,
+		acctKey:  acctKey,
+		gapLimit: cfg.GapLimit,
+	}
+	if cfg.RPCServer != "" {
+		w.client, err = rpc.Dial(cfg.RPCServer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to %s: %v", cfg.RPCServer, err)
+		}
+	}
+	return w, nil
+}
+
+// close releases the connection to the node.
+func (w *hdWallet) close() {
+	if w.client != nil {
+		w.client.Close()
+	}
+}
+
+// deriveAddress returns the address at the given branch and index.
+func (w *hdWallet) deriveAddress(branch, index uint32) (*common.Address, error) {
+	key, err := hdkeychain.DeriveAddressKey(w.acctKey, branch, index)
+	if err != nil {
+		return nil, err
+	}
+	return key.Address()
+}
+
+// addresses returns every address handed out so far on both branches.
+func (w *hdWallet) addresses() ([]*walletAddress, error) {
+	var addrs []*walletAddress
+	branches := []struct {
+		branch uint32
+		next   uint32
+	}{
+		{hdkeychain.ExternalBranch, w.acct.NextExternal},
+		{hdkeychain.InternalBranch, w.acct.NextInternal},
+	}
+	for _, b := range branches {
+		for i := uint32(0); i < b.next; i++ {
+			addr, err := w.deriveAddress(b.branch, i)
+			if err != nil {
+				return nil, err
+			}
+			addrs = append(addrs, &walletAddress{branch: b.branch, index: i, address: addr})
+		}
+	}
+	return addrs, nil
+}
+
+// nextAddress hands out the next unused address of the branch and persists
+// the new index.
+func (w *hdWallet) nextAddress(branch uint32) (*common.Address, error) {
+	next := &w.acct.NextExternal
+	if branch == hdkeychain.InternalBranch {
+		next = &w.acct.NextInternal
+	}
+	addr, err := w.deriveAddress(branch, *next)
+	if err != nil {
+		return nil, err
+	}
+	*next++
+	return addr, w.ks.save()
+}
+
+// scan discovers the used addresses of both branches.  Addresses are looked
+// up in batches of the gap limit through the address index of the node, and
+// the scan of a branch stops once gapLimit consecutive addresses have no
+// transactions.
+func (w *hdWallet) scan() error {
+	if w.client == nil {
+		return fmt.Errorf("scan requires a connection to a node (--rpcserver)")
+	}
+	for _, branch := range []uint32{hdkeychain.ExternalBranch, hdkeychain.InternalBranch} {
+		next := &w.acct.NextExternal
+		if branch == hdkeychain.InternalBranch {
+			next = &w.acct.NextInternal
+		}
+
+		var start, unused uint32
+		for unused < w.gapLimit {
+			batch := make([]string, 0, w.gapLimit)
+			for i := start; i < start+w.gapLimit; i++ {
+				addr, err := w.deriveAddress(branch, i)
+				if err != nil {
+					return err
+				}
+				batch = append(batch, addr.String())
+			}
+
+			var txs map[string][]rpcjson.TxResult
+			err := w.client.Call(&txs, "asimov_getTransactionsByAddresses", batch, 0, 1)
+			if err != nil {
+				return err
+			}
+			for i, addr := range batch {
+				if len(txs[addr]) == 0 {
+					unused++
+					continue
+				}
+				unused = 0
+				if index := start + uint32(i) + 1; index > *next {
+					*next = index
+				}
+			}
+			start += w.gapLimit
+		}
+	}
+	return w.ks.save()
+}
+
+// balances returns the total balance of every asset held by the addresses of
+// the account.  Divisible assets are summed, indivisible assets list their
+// voucher ids.
+func (w *hdWallet) balances() (map[string]int64, map[string][]int64, error) {
+	if w.client == nil {
+		return nil, nil, fmt.Errorf("balance requires a connection to a node (--rpcserver)")
+	}
+	addrs, err := w.addresses()
+	if err != nil {
+		return nil, nil, err
+	}
+	strs := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		strs = append(strs, addr.address.String())
+	}
+
+	var results []rpcjson.GetBalancesResult
+	if err := w.client.Call(&results, "asimov_getBalances", strs); err != nil {
+		return nil, nil, err
+	}
+
+	divisible := make(map[string]int64)
+	vouchers := make(map[string][]int64)
+	for _, result := range results {
+		for _, balance := range result.Assets {
+			value, err := strconv.ParseInt(balance.Value, 10, 64)
+			if err != nil {
+				return nil, nil, err
+			}
+			assetBytes, err := hex.DecodeString(balance.Asset)
+			if err != nil {
+				return nil, nil, err
+			}
+			if protos.AssetFromBytes(assetBytes).IsIndivisible() {
+				vouchers[balance.Asset] = append(vouchers[balance.Asset], value)
+				continue
+			}
+			divisible[balance.Asset] += value
+		}
+	}
+	for _, ids := range vouchers {
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	}
+	return divisible, vouchers, nil
+}
+
+// send funds the outputs out of the unspent outputs of the account, signs the
+// transaction with the keys of the account and broadcasts it.  The change of
+// every divisible asset goes to a new change address.  It returns the hash of
+// the broadcast transaction.
+func (w *hdWallet) send(master *hdkeychain.ExtendedKey, targets []coinselect.Target,
+	recipients []*common.Address, opts *coinselect.Options, gasLimit uint32) (string, error) {
+
+	if w.client == nil {
+		return "", fmt.Errorf("send requires a connection to a node (--rpcserver)")
+	}
+	addrs, err := w.addresses()
+	if err != nil {
+		return "", err
+	}
+	byAddress := make(map[string]*walletAddress, len(addrs))
+	strs := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		byAddress[addr.address.String()] = addr
+		strs = append(strs, addr.address.String())
+	}
+
+	var utxos []*rpcjson.ListUnspentResult
+	if err := w.client.Call(&utxos, "asimov_getUtxoByAddress", strs, ""); err != nil {
+		return "", err
+	}
+	coins := make([]*coinselect.Coin, 0, len(utxos))
+	for _, utxo := range utxos {
+		if !utxo.Spendable || len(utxo.ListLockEntry) > 0 {
+			continue
+		}
+		hash, err := common.NewHashFromStr(utxo.TxID)
+		if err != nil {
+			return "", err
+		}
+		assetBytes, err := hex.DecodeString(utxo.Assets)
+		if err != nil {
+			return "", err
+		}
+		pkScript, err := hex.DecodeString(utxo.ScriptPubKey)
+		if err != nil {
+			return "", err
+		}
+		coins = append(coins, &coinselect.Coin{
+			OutPoint: *protos.NewOutPoint(hash, utxo.Vout),
+			Asset:    *protos.AssetFromBytes(assetBytes),
+			Amount:   utxo.Amount,
+			PkScript: pkScript,
+		})
+	}
+
+	selection, err := coinselect.Select(coins, targets, opts)
+	if err != nil {
+		return "", err
+	}
+
+	tx := protos.NewMsgTx(protos.TxVersion)
+	for _, coin := range selection.Inputs {
+		tx.AddTxIn(protos.NewTxIn(&coin.OutPoint, nil))
+	}
+	for i, target := range targets {
+		pkScript, err := txscript.PayToAddrScript(recipients[i])
+		if err != nil {
+			return "", err
+		}
+		tx.AddTxOut(protos.NewContractTxOut(target.Amount, pkScript, target.Asset, nil))
+	}
+	if len(selection.Change) > 0 {
+		changeAddr, err := w.nextAddress(hdkeychain.InternalBranch)
+		if err != nil {
+			return "", err
+		}
+		pkScript, err := txscript.PayToAddrScript(changeAddr)
+		if err != nil {
+			return "", err
+		}
+		for _, change := range selection.Change {
+			tx.AddTxOut(protos.NewContractTxOut(change.Amount, pkScript, change.Asset, nil))
+		}
+	}
+	tx.TxContract.GasLimit = gasLimit
+
+	// Sign every input with the key of the address owning it.
+	acctKey, err := hdkeychain.DeriveAccount(master, w.ks.CoinType, w.acct.Index)
+	if err != nil {
+		return "", err
+	}
+	lookupKey := func(a common.IAddress) (*crypto.PrivateKey, bool, error) {
+		addr, ok := byAddress[a.String()]
+		if !ok {
+			return nil, false, fmt.Errorf("no key for address %s", a.String())
+		}
+		key, err := hdkeychain.DeriveAddressKey(acctKey, addr.branch, addr.index)
+		if err != nil {
+			return nil, false, err
+		}
+		privKey, err := key.ECPrivKey()
+		return privKey, true, err
+	}
+	for i, coin := range selection.Inputs {
+		sigScript, err := txscript.SignTxOutput(tx, i, coin.PkScript,
+			txscript.SigHashAll, txscript.KeyClosure(lookupKey), nil, nil)
+		if err != nil {
+			return "", err
+		}
+		tx.TxIn[i].SignatureScript = sigScript
+	}
+
+	var buf bytes.Buffer
+	if err := tx.VVSEncode(&buf, 0, protos.BaseEncoding); err != nil {
+		return "", err
+	}
+	var txHash string
+	err = w.client.Call(&txHash, "asimov_sendRawTransaction", hex.EncodeToString(buf.Bytes()))
+	return txHash, err
+}
+
+// parseAsset decodes a hex encoded asset.  An empty string is the asim
+// asset.
+func parseAsset(s string) (protos.Asset, error) {
+	if s == "" {
+		return asiutil.AsimovAsset, nil
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != common.AssetLength {
+		return protos.Asset{}, fmt.Errorf("invalid asset %q", s)
+	}
+	return *protos.AssetFromBytes(b), nil
+}
@@ -0,0 +1,152 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/AsimovNetwork/asimov/crypto/hdkeychain"
+)
+
+// testSeed is the seed of the test wallets.
+var testSeed, _ = hex.DecodeString("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+
+// testCoinType is the BIP44 coin type of the test wallets.
+const testCoinType = 10003
+
+// newTestWallet returns a wallet of the keystore at path, created from the
+// seed with its account 0.
+func newTestWallet(t *testing.T, path string, seed []byte, passphrase string) *hdWallet {
+	master, err := hdkeychain.NewMaster(seed)
+	if err != nil {
+		t.Fatalf("NewMaster err %v", err)
+	}
+	ks, err := newKeystore(path, seed, passphrase, testCoinType)
+	if err != nil {
+		t.Fatalf("newKeystore err %v", err)
+	}
+	acct, err := ks.addAccount(master, 0)
+	if err != nil {
+		t.Fatalf("addAccount err %v", err)
+	}
+	if err := ks.save(); err != nil {
+		t.Fatalf("save err %v", err)
+	}
+	acctKey, err := acct.extendedKey()
+	if err != nil {
+		t.Fatalf("extendedKey err %v", err)
+	}
+	return &hdWallet{ks: ks, acct: acct, acctKey: acctKey}
+}
+
+// TestDeriveAddress ensures the addresses derived from the account extended
+// public key of the wallet file are the ones of the private keys derived from
+// the seed at m/44'/coin'/account'/branch/index.
+func TestDeriveAddress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wallet")
+	if err != nil {
+		t.Fatalf("TempDir err %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	w := newTestWallet(t, filepath.Join(dir, "wallet.json"), testSeed, "pass")
+	master, err := hdkeychain.NewMaster(testSeed)
+	if err != nil {
+		t.Fatalf("NewMaster err %v", err)
+	}
+	account, err := hdkeychain.DeriveAccount(master, testCoinType, 0)
+	if err != nil {
+		t.Fatalf("DeriveAccount err %v", err)
+	}
+
+	seen := make(map[string]struct{})
+	for _, branch := range []uint32{hdkeychain.ExternalBranch, hdkeychain.InternalBranch} {
+		for index := uint32(0); index < 3; index++ {
+			got, err := w.deriveAddress(branch, index)
+			if err != nil {
+				t.Fatalf("deriveAddress(%d, %d) err %v", branch, index, err)
+			}
+			key, err := hdkeychain.DeriveAddressKey(account, branch, index)
+			if err != nil {
+				t.Fatalf("DeriveAddressKey(%d, %d) err %v", branch, index, err)
+			}
+			if !key.IsPrivate() {
+				t.Fatalf("DeriveAddressKey(%d, %d) is not private", branch, index)
+			}
+			want, err := key.Address()
+			if err != nil {
+				t.Fatalf("Address err %v", err)
+			}
+			if *got != *want {
+				t.Errorf("address %d/%d is %v, want %v", branch, index, got, want)
+			}
+			if _, ok := seen[got.String()]; ok {
+				t.Errorf("address %d/%d %v is derived twice", branch, index, got)
+			}
+			seen[got.String()] = struct{}{}
+		}
+	}
+}
+
+// TestKeystoreRoundTrip ensures a wallet file restores the seed and the
+// account it was created with, and that a wallet imported from the exported
+// seed derives the same addresses.
+func TestKeystoreRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wallet")
+	if err != nil {
+		t.Fatalf("TempDir err %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "wallet.json")
+	w := newTestWallet(t, path, testSeed, "pass")
+	addr, err := w.nextAddress(hdkeychain.ExternalBranch)
+	if err != nil {
+		t.Fatalf("nextAddress err %v", err)
+	}
+
+	ks, err := loadKeystore(path)
+	if err != nil {
+		t.Fatalf("loadKeystore err %v", err)
+	}
+	if ks.CoinType != testCoinType {
+		t.Errorf("got coin type %d, want %d", ks.CoinType, testCoinType)
+	}
+	if !reflect.DeepEqual(ks.account(0), w.acct) {
+		t.Errorf("got account %+v, want %+v", ks.account(0), w.acct)
+	}
+	if ks.account(0).NextExternal != 1 {
+		t.Errorf("got next receiving index %d, want 1", ks.account(0).NextExternal)
+	}
+	seed, err := ks.decryptSeed("pass")
+	if err != nil {
+		t.Fatalf("decryptSeed err %v", err)
+	}
+	if !bytes.Equal(seed, testSeed) {
+		t.Errorf("got seed %x, want %x", seed, testSeed)
+	}
+	if _, err := ks.decryptSeed("wrong"); err != ErrDecrypt {
+		t.Errorf("decryptSeed with a wrong passphrase got err %v, want %v", err, ErrDecrypt)
+	}
+
+	// Import the seed in another wallet, under another passphrase.
+	imported := newTestWallet(t, filepath.Join(dir, "imported.json"), seed, "other")
+	if imported.acct.PubKey != w.acct.PubKey || imported.acct.ChainCode != w.acct.ChainCode {
+		t.Errorf("imported account %+v does not match %+v", imported.acct, w.acct)
+	}
+	importedAddr, err := imported.deriveAddress(hdkeychain.ExternalBranch, 0)
+	if err != nil {
+		t.Fatalf("deriveAddress err %v", err)
+	}
+	if *importedAddr != *addr {
+		t.Errorf("imported wallet derives %v, want %v", importedAddr, addr)
+	}
+}
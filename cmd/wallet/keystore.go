@@ -0,0 +1,280 @@
+// Copyright (c) 2018-2020. The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/AsimovNetwork/asimov/crypto"
+	"github.com/AsimovNetwork/asimov/crypto/hdkeychain"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// keystoreVersion is the version of the wallet file format.
+	keystoreVersion = 1
+
+	// scryptN, scryptR and scryptP are the scrypt parameters used to derive
+	// the key encrypting the seed.  They match the standard parameters of
+	// the ethereum keystore.
+	scryptN     = 1 << 18
+	scryptR     = 8
+	scryptP     = 1
+	scryptDKLen = 32
+)
+
+// ErrDecrypt is returned when the passphrase does not match the wallet file.
+var ErrDecrypt = errors.New("could not decrypt wallet with given passphrase")
+
+// cryptoJSON holds the encrypted seed and the parameters to decrypt it.
+type cryptoJSON struct {
+	Cipher       string       `json:"cipher"`
+	CipherText   string       `json:"ciphertext"`
+	CipherParams cipherParams `json:"cipherparams"`
+	KDF          string       `json:"kdf"`
+	KDFParams    kdfParams    `json:"kdfparams"`
+	MAC          string       `json:"mac"`
+}
+
+type cipherParams struct {
+	IV string `json:"iv"`
+}
+
+type kdfParams struct {
+	N     int    `json:"n"`
+	R     int    `json:"r"`
+	P     int    `json:"p"`
+	DKLen int    `json:"dklen"`
+	Salt  string `json:"salt"`
+}
+
+// accountJSON is the public state of a BIP44 account.  The account extended
+// public key lets the wallet derive and watch addresses without the
+// passphrase.
+type accountJSON struct {
+	Index     uint32 `json:"index"`
+	PubKey    string `json:"pubkey"`
+	ChainCode string `json:"chaincode"`
+	ParentFP  string `json:"parentfp"`
+
+	// NextExternal and NextInternal are the indices of the next unused
+	// receiving and change addresses.
+	NextExternal uint32 `json:"nextexternal"`
+	NextInternal uint32 `json:"nextinternal"`
+}
+
+// keystore is the content of the wallet file.
+type keystore struct {
+	Version  int            `json:"version"`
+	CoinType uint32         `json:"cointype"`
+	Crypto   cryptoJSON     `json:"crypto"`
+	Accounts []*accountJSON `json:"accounts"`
+
+	path string
+}
+
+// newKeystore encrypts the seed with the passphrase and returns a keystore
+// with no accounts.
+func newKeystore(path string, seed []byte, passphrase string, coinType uint32) (*keystore, error) {
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	derivedKey, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, scryptDKLen)
+	if err != nil {
+		return nil, err
+	}
+	encryptKey := derivedKey[:16]
+
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+	cipherText, err := aesCTRXOR(encryptKey, seed, iv)
+	if err != nil {
+		return nil, err
+	}
+	mac := crypto.Keccak256(derivedKey[16:32], cipherText)
+
+	return &keystore{
+		Version:  keystoreVersion,
+		CoinType: coinType,
+		Crypto: cryptoJSON{
+			Cipher:       "aes-128-ctr",
+			CipherText:   hex.EncodeToString(cipherText),
+			CipherParams: cipherParams{IV: hex.EncodeToString(iv)},
+			KDF:          "scrypt",
+			KDFParams: kdfParams{
+				N:     scryptN,
+				R:     scryptR,
+				P:     scryptP,
+				DKLen: scryptDKLen,
+				Salt:  hex.EncodeToString(salt),
+			},
+			MAC: hex.EncodeToString(mac),
+		},
+		path: path,
+	}, nil
+}
+
+// loadKeystore reads the wallet file at path.
+func loadKeystore(path string) (*keystore, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ks := &keystore{}
+	if err := json.Unmarshal(data, ks); err != nil {
+		return nil, fmt.Errorf("failed to parse wallet file %s: %v", path, err)
+	}
+	if ks.Version != keystoreVersion {
+		return nil, fmt.Errorf("unsupported wallet file version %d", ks.Version)
+	}
+	ks.path = path
+	return ks, nil
+}
+
+// save writes the keystore back to its file.  The file is replaced
+// atomically so an interrupted write never loses the seed.
+func (ks *keystore) save() error {
+	data, err := json.MarshalIndent(ks, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(ks.path), 0700); err != nil {
+		return err
+	}
+	tmp := ks.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, ks.path)
+}
+
+// decryptSeed returns the seed of the wallet.
+func (ks *keystore) decryptSeed(passphrase string) ([]byte, error) {
+	c := ks.Crypto
+	if c.Cipher != "aes-128-ctr" {
+		return nil, fmt.Errorf("cipher not supported: %v", c.Cipher)
+	}
+	if c.KDF != "scrypt" {
+		return nil, fmt.Errorf("kdf not supported: %v", c.KDF)
+	}
+	mac, err := hex.DecodeString(c.MAC)
+	if err != nil {
+		return nil, err
+	}
+	iv, err := hex.DecodeString(c.CipherParams.IV)
+	if err != nil {
+		return nil, err
+	}
+	cipherText, err := hex.DecodeString(c.CipherText)
+	if err != nil {
+		return nil, err
+	}
+	salt, err := hex.DecodeString(c.KDFParams.Salt)
+	if err != nil {
+		return nil, err
+	}
+	derivedKey, err := scrypt.Key([]byte(passphrase), salt,
+		c.KDFParams.N, c.KDFParams.R, c.KDFParams.P, c.KDFParams.DKLen)
+	if err != nil {
+		return nil, err
+	}
+	calculatedMAC := crypto.Keccak256(derivedKey[16:32], cipherText)
+	if !bytes.Equal(calculatedMAC, mac) {
+		return nil, ErrDecrypt
+	}
+	return aesCTRXOR(derivedKey[:16], cipherText, iv)
+}
+
+// masterKey decrypts the seed and returns the master extended key.
+func (ks *keystore) masterKey(passphrase string) (*hdkeychain.ExtendedKey, error) {
+	seed, err := ks.decryptSeed(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return hdkeychain.NewMaster(seed)
+}
+
+// account returns the account with the given index, or nil if the account
+// was not created yet.
+func (ks *keystore) account(index uint32) *accountJSON {
+	for _, acct := range ks.Accounts {
+		if acct.Index == index {
+			return acct
+		}
+	}
+	return nil
+}
+
+// addAccount derives the account with the given index from the master key
+// and records its extended public key.
+func (ks *keystore) addAccount(master *hdkeychain.ExtendedKey, index uint32) (*accountJSON, error) {
+	if acct := ks.account(index); acct != nil {
+		return acct, nil
+	}
+	key, err := hdkeychain.DeriveAccount(master, ks.CoinType, index)
+	if err != nil {
+		return nil, err
+	}
+	pub := key.Neuter()
+	pubKey, err := pub.ECPubKey()
+	if err != nil {
+		return nil, err
+	}
+	parentFP := make([]byte, 4)
+	binary.BigEndian.PutUint32(parentFP, pub.ParentFingerprint())
+
+	acct := &accountJSON{
+		Index:     index,
+		PubKey:    hex.EncodeToString(pubKey.SerializeCompressed()),
+		ChainCode: hex.EncodeToString(pub.ChainCode()),
+		ParentFP:  hex.EncodeToString(parentFP),
+	}
+	ks.Accounts = append(ks.Accounts, acct)
+	return acct, nil
+}
+
+// extendedKey rebuilds the account extended public key.
+func (acct *accountJSON) extendedKey() (*hdkeychain.ExtendedKey, error) {
+	pubKey, err := hex.DecodeString(acct.PubKey)
+	if err != nil {
+		return nil, err
+	}
+	chainCode, err := hex.DecodeString(acct.ChainCode)
+	if err != nil {
+		return nil, err
+	}
+	parentFP, err := hex.DecodeString(acct.ParentFP)
+	if err != nil {
+		return nil, err
+	}
+	return hdkeychain.NewExtendedKey(pubKey, chainCode, parentFP, 3,
+		hdkeychain.HardenedKeyStart+acct.Index, false), nil
+}
+
+func aesCTRXOR(key, inText, iv []byte) ([]byte, error) {
+	// AES-128 is selected due to size of encryptKey.
+	aesBlock, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	stream := cipher.NewCTR(aesBlock, iv)
+	outText := make([]byte, len(inText))
+	stream.XORKeyStream(outText, inText)
+	return outText, err
+}
@@ -0,0 +1,13 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!windows
+
+package main
+
+// disableEcho reports the terminal as unsupported, so the passphrases are
+// read as is.
+func disableEcho(fd uintptr) (func(), error) {
+	return nil, errNotTerminal
+}
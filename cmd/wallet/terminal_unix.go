@@ -0,0 +1,36 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// +build linux darwin freebsd netbsd openbsd
+
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// disableEcho turns off the echo of the terminal of the file descriptor, as
+// ssh/terminal does to read a password, and returns the function restoring
+// it.  It returns errNotTerminal when the descriptor is not a terminal.
+func disableEcho(fd uintptr) (func(), error) {
+	var oldState syscall.Termios
+	if _, _, e := syscall.Syscall6(syscall.SYS_IOCTL, fd, ioctlReadTermios,
+		uintptr(unsafe.Pointer(&oldState)), 0, 0, 0); e != 0 {
+		return nil, errNotTerminal
+	}
+
+	newState := oldState
+	newState.Lflag &^= syscall.ECHO
+	newState.Lflag |= syscall.ICANON | syscall.ISIG
+	newState.Iflag |= syscall.ICRNL
+	if _, _, e := syscall.Syscall6(syscall.SYS_IOCTL, fd, ioctlWriteTermios,
+		uintptr(unsafe.Pointer(&newState)), 0, 0, 0); e != 0 {
+		return nil, e
+	}
+	return func() {
+		syscall.Syscall6(syscall.SYS_IOCTL, fd, ioctlWriteTermios,
+			uintptr(unsafe.Pointer(&oldState)), 0, 0, 0)
+	}, nil
+}
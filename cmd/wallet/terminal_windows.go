@@ -0,0 +1,38 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+const enableEchoInput = 0x4
+
+var (
+	kernel32           = syscall.NewLazyDLL("kernel32.dll")
+	procGetConsoleMode = kernel32.NewProc("GetConsoleMode")
+	procSetConsoleMode = kernel32.NewProc("SetConsoleMode")
+)
+
+// disableEcho turns off the echo of the console of the handle, and returns
+// the function restoring it.  It returns errNotTerminal when the handle is not
+// a console.
+func disableEcho(fd uintptr) (func(), error) {
+	var oldMode uint32
+	r, _, _ := syscall.Syscall(procGetConsoleMode.Addr(), 2, fd,
+		uintptr(unsafe.Pointer(&oldMode)), 0)
+	if r == 0 {
+		return nil, errNotTerminal
+	}
+	r, _, e := syscall.Syscall(procSetConsoleMode.Addr(), 2, fd,
+		uintptr(oldMode&^enableEchoInput), 0)
+	if r == 0 {
+		return nil, e
+	}
+	return func() {
+		syscall.Syscall(procSetConsoleMode.Addr(), 2, fd, uintptr(oldMode), 0)
+	}, nil
+}
@@ -6,7 +6,9 @@ package main
 
 import (
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
+	"github.com/AsimovNetwork/asimov/asiutil/psbt"
 	"github.com/AsimovNetwork/asimov/common"
 	"github.com/AsimovNetwork/asimov/common/address"
 	"github.com/AsimovNetwork/asimov/common/bitcoinaddress"
@@ -15,6 +17,7 @@ import (
 	"github.com/AsimovNetwork/asimov/txscript"
 	"os"
 	"strconv"
+	"strings"
 )
 
 func main() {
@@ -42,6 +45,37 @@ func main() {
 		genMultiSigAddress(cfg, remainArgs, decodefunc, encodefunc)
 		os.Exit(1)
 	}
+
+	if cfg.Cmd == "signPsbt" {
+		signPsbt(remainArgs, decodefunc)
+		os.Exit(1)
+	}
+
+	var err error
+	switch cfg.Cmd {
+	case "createWallet":
+		err = createWallet(cfg, nil)
+	case "importSeed":
+		if len(remainArgs) != 1 {
+			err = fmt.Errorf("importSeed requires the hex encoded seed")
+			break
+		}
+		var seed []byte
+		seed, err = hex.DecodeString(remainArgs[0])
+		if err == nil {
+			err = createWallet(cfg, seed)
+		}
+	case "newAccount":
+		err = newAccount(cfg)
+	case "newAddress", "listAddresses", "scan", "getBalance", "send":
+		err = runWalletCmd(cfg, remainArgs)
+	default:
+		err = fmt.Errorf("unknown command %s", cfg.Cmd)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 }
 
 func genkeys(cfg *config, decodef func(string) ([]byte, error), encodef func(b []byte) string)  {
@@ -128,4 +162,45 @@ func genMultiSigAddress(cfg *config, args []string, decodef func(string) ([]byte
 	fmt.Println("    {\n        Address:", encodef(addr.ScriptAddress()), ",")
 	fmt.Println("        Script:", encodef(script))
 	fmt.Println("    }")
-}
\ No newline at end of file
+}
+
+// signPsbt adds the signature of the given private key to every input of a
+// base64 encoded partially signed transaction it can sign.
+func signPsbt(args []string, decodef func(string) ([]byte, error)) {
+	if len(args) != 2 {
+		fmt.Println("arguments invalid, expect <psbt> <privkey>")
+		return
+	}
+	p, err := psbt.NewFromRawBytes(strings.NewReader(args[0]), true)
+	if err != nil {
+		fmt.Println("arguments invalid, failed decode psbt:", err)
+		return
+	}
+	keyBytes, err := decodef(args[1])
+	if err != nil {
+		fmt.Println("arguments invalid, failed decode privkey")
+		return
+	}
+	privKey, _ := crypto.PrivKeyFromBytes(crypto.S256(), keyBytes)
+
+	updater, err := psbt.NewUpdater(p)
+	if err != nil {
+		fmt.Println("invalid psbt:", err)
+		return
+	}
+	signed := 0
+	for i := range p.Inputs {
+		if err := updater.Sign(i, privKey); err != nil {
+			fmt.Printf("    input %d not signed: %v\n", i, err)
+			continue
+		}
+		signed++
+	}
+	b64, err := p.B64Encode()
+	if err != nil {
+		fmt.Println("failed to encode psbt:", err)
+		return
+	}
+	fmt.Println("Signed", signed, "inputs:")
+	fmt.Println(b64)
+}
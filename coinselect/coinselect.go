@@ -0,0 +1,414 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// Package coinselect implements asset aware selection of the unspent outputs
+// used to fund a transaction.
+//
+// Every asset of a transaction is funded on its own.  For divisible assets the
+// inputs must cover the sum of the outputs, plus the fee for the fee asset,
+// and the remainder is returned through a change output.  Indivisible assets
+// carry a voucher id instead of an amount, so an output of such an asset can
+// only be funded by the unspent output holding the very same voucher and never
+// produces change.
+package coinselect
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/AsimovNetwork/asimov/protos"
+)
+
+// Strategy identifies the algorithm used to pick the inputs of a divisible
+// asset.
+type Strategy int
+
+const (
+	// LargestFirst picks the largest unspent outputs until the target is
+	// covered.
+	LargestFirst Strategy = iota
+
+	// BranchAndBound searches for a set of unspent outputs which covers the
+	// target without producing change, and falls back to LargestFirst when
+	// there is no such set.
+	BranchAndBound
+
+	// MinInputCount picks the fewest unspent outputs covering the target and,
+	// among those, the set with the smallest change.
+	MinInputCount
+)
+
+// strategyStrings is a map of strategies back to their constant names for
+// pretty printing.
+var strategyStrings = map[Strategy]string{
+	LargestFirst:   "largestfirst",
+	BranchAndBound: "branchandbound",
+	MinInputCount:  "mininputcount",
+}
+
+// String returns the Strategy as a human-readable name.
+func (s Strategy) String() string {
+	if str, ok := strategyStrings[s]; ok {
+		return str
+	}
+	return fmt.Sprintf("Unknown Strategy (%d)", int(s))
+}
+
+// ParseStrategy returns the strategy with the given name.
+func ParseStrategy(name string) (Strategy, error) {
+	for s, str := range strategyStrings {
+		if str == name {
+			return s, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown coin selection strategy %q", name)
+}
+
+// maxBranchAndBoundTries bounds the number of nodes visited by the branch and
+// bound search.
+const maxBranchAndBoundTries = 100000
+
+// Coin is an unspent output which can be spent by the transaction.
+type Coin struct {
+	OutPoint protos.OutPoint
+	Asset    protos.Asset
+	Amount   int64
+	PkScript []byte
+}
+
+// Target is an amount of an asset the transaction has to pay.  For an
+// indivisible asset the amount is the voucher id.
+type Target struct {
+	Asset  protos.Asset
+	Amount int64
+}
+
+// Options control how the inputs are selected.
+type Options struct {
+	// Strategy is the algorithm used for divisible assets.
+	Strategy Strategy
+
+	// FeeAsset is the asset the fee is paid with.  It must be divisible.
+	FeeAsset protos.Asset
+
+	// Fee is the amount of FeeAsset paid as fee.
+	Fee int64
+
+	// CostOfChange is the excess the branch and bound search accepts over
+	// the target in exchange for not creating a change output.  The excess
+	// is added to the fee.
+	CostOfChange int64
+}
+
+// Selection is the result of a coin selection.
+type Selection struct {
+	// Inputs are the selected unspent outputs.
+	Inputs []*Coin
+
+	// Change holds the change of every divisible asset which needs a change
+	// output.
+	Change []Target
+}
+
+// InsufficientFundsError describes an asset that can not be funded by the
+// available unspent outputs.
+type InsufficientFundsError struct {
+	Asset     protos.Asset
+	Needed    int64
+	Available int64
+}
+
+// Error satisfies the error interface and prints human-readable errors.
+func (e InsufficientFundsError) Error() string {
+	if e.Asset.IsIndivisible() {
+		return fmt.Sprintf("voucher %d of asset %s is not available",
+			e.Needed, e.Asset.String())
+	}
+	return fmt.Sprintf("insufficient funds of asset %s: need %d, available %d",
+		e.Asset.String(), e.Needed, e.Available)
+}
+
+// Select picks the inputs funding the given targets out of the available
+// coins and computes the change of each divisible asset.  Coins of assets
+// which are not needed are ignored.
+func Select(coins []*Coin, targets []Target, opts *Options) (*Selection, error) {
+	if opts.FeeAsset.IsIndivisible() {
+		return nil, fmt.Errorf("fee asset %s is indivisible", opts.FeeAsset.String())
+	}
+	if opts.Fee < 0 {
+		return nil, fmt.Errorf("negative fee %d", opts.Fee)
+	}
+
+	// Sum up the needs of every asset, keeping the order the assets first
+	// appear in so the result is deterministic.
+	var assets []protos.Asset
+	needs := make(map[protos.Asset]int64)
+	vouchers := make(map[protos.Asset]map[int64]struct{})
+	addAsset := func(asset protos.Asset) {
+		if _, ok := needs[asset]; ok {
+			return
+		}
+		if _, ok := vouchers[asset]; ok {
+			return
+		}
+		assets = append(assets, asset)
+		if asset.IsIndivisible() {
+			vouchers[asset] = make(map[int64]struct{})
+		} else {
+			needs[asset] = 0
+		}
+	}
+	for _, target := range targets {
+		if target.Amount < 0 {
+			return nil, fmt.Errorf("negative amount %d of asset %s",
+				target.Amount, target.Asset.String())
+		}
+		if target.Amount == 0 {
+			continue
+		}
+		addAsset(target.Asset)
+		if target.Asset.IsIndivisible() {
+			if _, ok := vouchers[target.Asset][target.Amount]; ok {
+				return nil, fmt.Errorf("duplicated voucher %d of asset %s",
+					target.Amount, target.Asset.String())
+			}
+			vouchers[target.Asset][target.Amount] = struct{}{}
+			continue
+		}
+		needs[target.Asset] += target.Amount
+	}
+	if opts.Fee > 0 {
+		addAsset(opts.FeeAsset)
+		needs[opts.FeeAsset] += opts.Fee
+	}
+
+	// Group the coins by asset.
+	available := make(map[protos.Asset][]*Coin)
+	for _, coin := range coins {
+		if coin.Amount <= 0 {
+			continue
+		}
+		available[coin.Asset] = append(available[coin.Asset], coin)
+	}
+
+	selection := &Selection{}
+	for _, asset := range assets {
+		if asset.IsIndivisible() {
+			inputs, err := selectVouchers(available[asset], asset, vouchers[asset])
+			if err != nil {
+				return nil, err
+			}
+			selection.Inputs = append(selection.Inputs, inputs...)
+			continue
+		}
+
+		inputs, change, err := selectDivisible(available[asset], asset,
+			needs[asset], opts)
+		if err != nil {
+			return nil, err
+		}
+		selection.Inputs = append(selection.Inputs, inputs...)
+		if change > 0 {
+			selection.Change = append(selection.Change,
+				Target{Asset: asset, Amount: change})
+		}
+	}
+	return selection, nil
+}
+
+// selectVouchers picks the coins holding the wanted vouchers of an
+// indivisible asset.
+func selectVouchers(coins []*Coin, asset protos.Asset, wanted map[int64]struct{}) ([]*Coin, error) {
+	byVoucher := make(map[int64]*Coin, len(coins))
+	for _, coin := range coins {
+		byVoucher[coin.Amount] = coin
+	}
+
+	ids := make([]int64, 0, len(wanted))
+	for id := range wanted {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	inputs := make([]*Coin, 0, len(ids))
+	for _, id := range ids {
+		coin, ok := byVoucher[id]
+		if !ok {
+			return nil, InsufficientFundsError{Asset: asset, Needed: id}
+		}
+		inputs = append(inputs, coin)
+	}
+	return inputs, nil
+}
+
+// selectDivisible picks the coins of a divisible asset covering the target
+// according to the strategy and returns them with the change.
+func selectDivisible(coins []*Coin, asset protos.Asset, target int64, opts *Options) ([]*Coin, int64, error) {
+	if target == 0 {
+		return nil, 0, nil
+	}
+
+	// Sort the coins by descending amount.  Ties are broken by outpoint so
+	// the selection does not depend on the order of the input.
+	sorted := make([]*Coin, len(coins))
+	copy(sorted, coins)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Amount != sorted[j].Amount {
+			return sorted[i].Amount > sorted[j].Amount
+		}
+		if sorted[i].OutPoint.Hash != sorted[j].OutPoint.Hash {
+			return sorted[i].OutPoint.Hash.String() < sorted[j].OutPoint.Hash.String()
+		}
+		return sorted[i].OutPoint.Index < sorted[j].OutPoint.Index
+	})
+
+	var total int64
+	for _, coin := range sorted {
+		total += coin.Amount
+	}
+	if total < target {
+		return nil, 0, InsufficientFundsError{Asset: asset, Needed: target,
+			Available: total}
+	}
+
+	var inputs []*Coin
+	switch opts.Strategy {
+	case BranchAndBound:
+		inputs = branchAndBound(sorted, target, opts.CostOfChange)
+		if inputs != nil {
+			// The excess over the target is left to the fee.
+			return inputs, 0, nil
+		}
+		inputs = largestFirst(sorted, target)
+	case MinInputCount:
+		inputs = minInputCount(sorted, target)
+	default:
+		inputs = largestFirst(sorted, target)
+	}
+
+	var sum int64
+	for _, coin := range inputs {
+		sum += coin.Amount
+	}
+	return inputs, sum - target, nil
+}
+
+// largestFirst picks coins from the sorted list until the target is covered.
+func largestFirst(sorted []*Coin, target int64) []*Coin {
+	var sum int64
+	for i, coin := range sorted {
+		sum += coin.Amount
+		if sum >= target {
+			return sorted[: i+1 : i+1]
+		}
+	}
+	return nil
+}
+
+// minInputCount picks the fewest coins covering the target.  The largest
+// coins give the minimum count, then every picked coin is swapped for the
+// smallest unused coin which still covers the target to reduce the change.
+func minInputCount(sorted []*Coin, target int64) []*Coin {
+	picked := largestFirst(sorted, target)
+	if picked == nil {
+		return nil
+	}
+	inputs := make([]*Coin, len(picked))
+	copy(inputs, picked)
+
+	var sum int64
+	for _, coin := range inputs {
+		sum += coin.Amount
+	}
+
+	used := make(map[*Coin]bool, len(inputs))
+	for _, coin := range inputs {
+		used[coin] = true
+	}
+	// Swap the smallest picked coins first, they leave the least room.
+	for i := len(inputs) - 1; i >= 0; i-- {
+		for j := len(sorted) - 1; j >= 0; j-- {
+			candidate := sorted[j]
+			if used[candidate] || candidate.Amount >= inputs[i].Amount {
+				continue
+			}
+			if sum-inputs[i].Amount+candidate.Amount < target {
+				continue
+			}
+			sum = sum - inputs[i].Amount + candidate.Amount
+			delete(used, inputs[i])
+			used[candidate] = true
+			inputs[i] = candidate
+			break
+		}
+	}
+	return inputs
+}
+
+// branchAndBound searches depth first for a set of the sorted coins whose sum
+// lies within [target, target+costOfChange], preferring the smallest excess.
+// It returns nil when no such set is found within maxBranchAndBoundTries.
+func branchAndBound(sorted []*Coin, target, costOfChange int64) []*Coin {
+	// remaining[i] is the sum of the coins from i to the end.
+	remaining := make([]int64, len(sorted)+1)
+	for i := len(sorted) - 1; i >= 0; i-- {
+		remaining[i] = remaining[i+1] + sorted[i].Amount
+	}
+
+	var (
+		best      []bool
+		bestSum   int64 = -1
+		selection       = make([]bool, len(sorted))
+		tries     int
+	)
+	var search func(depth int, sum int64) bool
+	search = func(depth int, sum int64) bool {
+		tries++
+		if tries > maxBranchAndBoundTries {
+			return true
+		}
+		// Prune branches which overshoot or can no longer reach the
+		// target.
+		if sum > target+costOfChange || sum+remaining[depth] < target {
+			return false
+		}
+		if sum >= target {
+			if bestSum < 0 || sum < bestSum {
+				bestSum = sum
+				best = append(best[:0], selection...)
+			}
+			return sum == target
+		}
+		if depth == len(sorted) {
+			return false
+		}
+
+		// Inclusion branch first, then omission.
+		selection[depth] = true
+		if search(depth+1, sum+sorted[depth].Amount) {
+			return true
+		}
+		selection[depth] = false
+
+		// Skip equal amounts on the omission branch, they would only
+		// produce duplicated solutions.
+		next := depth + 1
+		for next < len(sorted) && sorted[next].Amount == sorted[depth].Amount {
+			next++
+		}
+		return search(next, sum)
+	}
+	search(0, 0)
+
+	if bestSum < 0 {
+		return nil
+	}
+	var inputs []*Coin
+	for i, picked := range best {
+		if picked {
+			inputs = append(inputs, sorted[i])
+		}
+	}
+	return inputs
+}
@@ -0,0 +1,162 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package coinselect
+
+import (
+	"testing"
+
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/protos"
+)
+
+var (
+	asim    = *protos.NewAsset(protos.DivisibleAsset, protos.DefaultOrgId, protos.DefaultCoinId)
+	token   = *protos.NewAsset(protos.DivisibleAsset, 1, 1)
+	voucher = *protos.NewAsset(protos.InDivisibleAsset, 1, 2)
+)
+
+// newCoins creates one coin per amount of the given asset.
+func newCoins(asset protos.Asset, amounts ...int64) []*Coin {
+	coins := make([]*Coin, 0, len(amounts))
+	for i, amount := range amounts {
+		hash := common.HexToHash(asset.String())
+		coins = append(coins, &Coin{
+			OutPoint: *protos.NewOutPoint(&hash, uint32(i)),
+			Asset:    asset,
+			Amount:   amount,
+		})
+	}
+	return coins
+}
+
+// sumOf returns the total amount of the given asset in the coins.
+func sumOf(coins []*Coin, asset protos.Asset) (int64, int) {
+	var sum int64
+	var count int
+	for _, coin := range coins {
+		if coin.Asset == asset {
+			sum += coin.Amount
+			count++
+		}
+	}
+	return sum, count
+}
+
+// changeOf returns the change of the given asset.
+func changeOf(selection *Selection, asset protos.Asset) int64 {
+	for _, change := range selection.Change {
+		if change.Asset == asset {
+			return change.Amount
+		}
+	}
+	return 0
+}
+
+func TestStrategies(t *testing.T) {
+	coins := newCoins(asim, 1000, 500, 300, 200, 70, 30)
+
+	tests := []struct {
+		name       string
+		strategy   Strategy
+		target     int64
+		fee        int64
+		wantInputs int
+		wantChange int64
+	}{
+		{"largest first", LargestFirst, 1100, 50, 2, 350},
+		{"min input count", MinInputCount, 1100, 50, 2, 50},
+		{"branch and bound exact", BranchAndBound, 1530, 70, 4, 0},
+		{"branch and bound fallback", BranchAndBound, 2024, 1, 5, 45},
+		{"min input count single", MinInputCount, 250, 0, 1, 50},
+	}
+	for _, test := range tests {
+		selection, err := Select(coins, []Target{{asim, test.target}},
+			&Options{Strategy: test.strategy, FeeAsset: asim, Fee: test.fee})
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		sum, count := sumOf(selection.Inputs, asim)
+		if count != test.wantInputs {
+			t.Errorf("%s: got %d inputs, want %d", test.name, count, test.wantInputs)
+		}
+		change := changeOf(selection, asim)
+		if change != test.wantChange {
+			t.Errorf("%s: got change %d, want %d", test.name, change, test.wantChange)
+		}
+		if sum != test.target+test.fee+change {
+			t.Errorf("%s: inputs %d do not balance outputs %d, fee %d and change %d",
+				test.name, sum, test.target, test.fee, change)
+		}
+	}
+}
+
+func TestMultipleAssets(t *testing.T) {
+	var coins []*Coin
+	coins = append(coins, newCoins(asim, 100, 50)...)
+	coins = append(coins, newCoins(token, 7, 5, 3)...)
+	coins = append(coins, newCoins(voucher, 11, 12, 13)...)
+
+	targets := []Target{{token, 6}, {voucher, 12}, {voucher, 13}}
+	selection, err := Select(coins, targets, &Options{FeeAsset: asim, Fee: 20})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if sum, _ := sumOf(selection.Inputs, token); sum != 7 {
+		t.Errorf("got token inputs %d, want 7", sum)
+	}
+	if change := changeOf(selection, token); change != 1 {
+		t.Errorf("got token change %d, want 1", change)
+	}
+	if change := changeOf(selection, asim); change != 80 {
+		t.Errorf("got fee asset change %d, want 80", change)
+	}
+	if changeOf(selection, voucher) != 0 {
+		t.Errorf("indivisible asset must not produce change")
+	}
+	found := make(map[int64]bool)
+	for _, coin := range selection.Inputs {
+		if coin.Asset == voucher {
+			found[coin.Amount] = true
+		}
+	}
+	if len(found) != 2 || !found[12] || !found[13] {
+		t.Errorf("got vouchers %v, want 12 and 13", found)
+	}
+}
+
+func TestInsufficientFunds(t *testing.T) {
+	var coins []*Coin
+	coins = append(coins, newCoins(asim, 100)...)
+	coins = append(coins, newCoins(voucher, 11)...)
+
+	_, err := Select(coins, []Target{{asim, 90}}, &Options{FeeAsset: asim, Fee: 20})
+	if e, ok := err.(InsufficientFundsError); !ok || e.Needed != 110 || e.Available != 100 {
+		t.Errorf("got error %v, want insufficient funds of 110", err)
+	}
+
+	_, err = Select(coins, []Target{{voucher, 12}}, &Options{FeeAsset: asim})
+	if _, ok := err.(InsufficientFundsError); !ok {
+		t.Errorf("got error %v, want missing voucher", err)
+	}
+
+	_, err = Select(coins, []Target{{asim, 10}}, &Options{FeeAsset: voucher, Fee: 1})
+	if err == nil {
+		t.Errorf("expected error for indivisible fee asset")
+	}
+}
+
+func TestParseStrategy(t *testing.T) {
+	for s := range strategyStrings {
+		parsed, err := ParseStrategy(s.String())
+		if err != nil || parsed != s {
+			t.Errorf("ParseStrategy(%q) = %v, %v", s.String(), parsed, err)
+		}
+	}
+	if _, err := ParseStrategy("random"); err == nil {
+		t.Errorf("expected error for unknown strategy")
+	}
+}
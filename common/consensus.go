@@ -9,6 +9,7 @@ const (
 	SOLO           = iota
 	POA
 	SATOSHIPLUS
+	BFT
 	ConsensusCount
 )
 
@@ -20,6 +21,7 @@ var consensusArray = [ConsensusCount]string{
 	SOLO: "solo",
 	POA:  "poa",
 	SATOSHIPLUS:"satoshiplus",
+	BFT:        "bft",
 }
 
 // GetConsensus returns consensus type according to the given name
@@ -30,6 +32,14 @@ func GetConsensus(name string) int32 {
 	return -1
 }
 
+// GetConsensusName returns the name of the given consensus type
+func GetConsensusName(consensus int32) string {
+	if consensus < 0 || consensus >= ConsensusCount {
+		return ""
+	}
+	return consensusArray[consensus]
+}
+
 func init() {
 	for k, v := range consensusArray {
 		consensusByName[v] = int32(k)
@@ -56,6 +56,12 @@ const (
 	// state db.  This is primarily used for miner.
 	BFFastAdd BehaviorFlags = 1 << iota
 
+	// BFNoExecute may be set to indicate that the contracts of the block
+	// are not executed.  The virtual block passed along with it is connected
+	// as is, and its state is not available until it is downloaded from the
+	// peers.  This is used for the blocks up to the pivot of a state sync.
+	BFNoExecute
+
 	// BFNone is a convenience value to specifically indicate no flags.
 	BFNone BehaviorFlags = 0
 )
@@ -74,13 +80,25 @@ const (
 	// SFNodeCF is a flag used to indicate a peer supports committed
 	// filters (CFs).
 	SFNodeCF
+
+	// SFNodeState is a flag used to indicate a peer serves the state trie
+	// nodes and the virtual blocks a node needs to sync the state of a
+	// recent pivot block.
+	SFNodeState
+
+	// SFNodeContractCF is a flag used to indicate a peer serves the
+	// contract committed filters and their headers down to the genesis,
+	// which a peer whose state was synced lacks.
+	SFNodeContractCF
 )
 
 // Map of service flags back to their constant names for pretty printing.
 var sfStrings = map[ServiceFlag]string{
-	SFNodeNetwork: "SFNodeNetwork",
-	SFNodeBloom:   "SFNodeBloom",
-	SFNodeCF:      "SFNodeCF",
+	SFNodeNetwork:    "SFNodeNetwork",
+	SFNodeBloom:      "SFNodeBloom",
+	SFNodeCF:         "SFNodeCF",
+	SFNodeState:      "SFNodeState",
+	SFNodeContractCF: "SFNodeContractCF",
 }
 
 // orderedSFStrings is an ordered list of service flags from highest to
@@ -89,6 +107,8 @@ var orderedSFStrings = []ServiceFlag{
 	SFNodeNetwork,
 	SFNodeBloom,
 	SFNodeCF,
+	SFNodeState,
+	SFNodeContractCF,
 }
 
 // String returns the ServiceFlag in human-readable form.
@@ -18,7 +18,9 @@ func TestServiceFlagStringer(t *testing.T) {
 		{SFNodeNetwork, "SFNodeNetwork"},
 		{SFNodeBloom, "SFNodeBloom"},
 		{SFNodeCF, "SFNodeCF"},
-		{0xffffffff, "SFNodeNetwork|SFNodeBloom|SFNodeCF|0xfffffff8"},
+		{SFNodeState, "SFNodeState"},
+		{SFNodeContractCF, "SFNodeContractCF"},
+		{0xffffffff, "SFNodeNetwork|SFNodeBloom|SFNodeCF|SFNodeState|SFNodeContractCF|0xffffffe0"},
 	}
 
 	t.Logf("Running %d tests", len(tests))
@@ -0,0 +1,600 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package bft
+
+import (
+	"time"
+
+	"github.com/AsimovNetwork/asimov/blockchain"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/consensus/params"
+	"github.com/AsimovNetwork/asimov/protos"
+)
+
+const (
+	// maxRoundsAhead is the number of rounds after the current one the
+	// messages are kept for, so the nodes whose clock is a little late do
+	// not miss the votes of the nodes whose clock is a little early.
+	maxRoundsAhead = 1
+
+	// maxFutureMessages is the maximum number of messages of the next height
+	// kept until the node reaches that height.
+	maxFutureMessages = 1024
+)
+
+// step is a step of a round of the consensus.
+type step uint8
+
+const (
+	// stepNewRound waits for the start of the slot of the round.
+	stepNewRound step = iota
+
+	// stepPropose waits for the proposal of the round.
+	stepPropose
+
+	// stepPrevote waits for the prevotes of the round.
+	stepPrevote
+
+	// stepPrecommit waits for the precommits of the round.
+	stepPrecommit
+)
+
+// timeout is a deadline of a step of a round.  When it expires, the state
+// machine moves to the step, unless it is already past it.  The timeout of
+// stepNewRound ends the round.
+type timeout struct {
+	height int32
+	round  int32
+	step   step
+}
+
+// Backend is the environment the state machine of the consensus runs in.  The
+// state machine is not safe for concurrent access, the backend must call it
+// from a single goroutine.
+type Backend interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// Schedule calls back the state machine with the timeout at the given
+	// time, or as soon as possible when it has passed.
+	Schedule(at time.Time, t timeout)
+
+	// Keys returns the addresses of the keys of the validator of the node,
+	// which include the keys it rotates to.
+	Keys() []common.Address
+
+	// Sign signs the hash with the key of the node with the given address.
+	Sign(signer common.Address, hash common.Hash) ([protos.HashSignLen]byte, error)
+
+	// Broadcast sends the message to the peers.
+	Broadcast(msg protos.Message)
+
+	// Tip returns the state of the block the chain ends at.
+	Tip() *blockchain.BestState
+
+	// Proposers returns the validators of the slots of the round of the
+	// chain.
+	Proposers(round uint32) ([]*common.Address, error)
+
+	// Voters returns the validators voting in the round of the chain.
+	Voters(round uint32) ([]common.Address, error)
+
+	// Propose returns a new block of the proposer, one of the keys of the
+	// node, at the slot of the round of the chain following the tip.
+	Propose(proposer common.Address, round uint32, slot uint16,
+		timestamp int64) (*protos.MsgBlock, error)
+
+	// Validate returns whether the block can follow the tip.
+	Validate(block *protos.MsgBlock) error
+
+	// Commit connects the block, which gathered the given precommits, to
+	// the chain.
+	Commit(block *protos.MsgBlock, precommits []*protos.MsgVote) error
+}
+
+// roundState holds the messages received in a round.
+type roundState struct {
+	proposal   *protos.MsgProposal
+	prevotes   map[common.Address]*protos.MsgVote
+	precommits map[common.Address]*protos.MsgVote
+
+	// polSeen is set once the proposal gathered the prevotes of more than
+	// two thirds of the validators.
+	polSeen bool
+}
+
+// core is the state machine of the consensus, a variant of Tendermint whose
+// rounds are the slots of the chain.  Round r of a height is the slot r+1
+// after the one of the parent block, so the blocks keep the rounds and the
+// slots the chain rules of poa expect, and the proposer of a round is the
+// validator of its slot.
+type core struct {
+	backend   Backend
+	scheduler *params.Scheduler
+	roundSize int64
+	interval  time.Duration
+
+	// The height being decided, the block it follows and the slot of that
+	// block counted from the genesis block.
+	height int32
+	parent common.Hash
+	base   int64
+
+	// The validators voting at the height, each with a weight of 1, and
+	// the keys of the node, reloaded at each round.
+	voters map[common.Address]struct{}
+	keys   []common.Address
+
+	round       int32
+	step        step
+	lockedRound int32
+	lockedBlock *protos.MsgBlock
+	validRound  int32
+	validBlock  *protos.MsgBlock
+
+	rounds  map[int32]*roundState
+	checked map[common.Hash]error
+	future  []protos.Message
+}
+
+// newCore returns the state machine of a node over rounds of roundSize slots
+// lasting blockInterval seconds each.
+func newCore(backend Backend, chainStartTime, blockInterval int64,
+	roundSize uint16) *core {
+	return &core{
+		backend:   backend,
+		scheduler: params.NewScheduler(chainStartTime, blockInterval, roundSize),
+		roundSize: int64(roundSize),
+		interval:  time.Duration(blockInterval) * time.Second,
+	}
+}
+
+// globalSlot returns the number of slots from the genesis block to the slot
+// of the round of the chain.
+func (c *core) globalSlot(round uint32, slot uint16) int64 {
+	if round == 0 {
+		return 0
+	}
+	return (int64(round)-1)*c.roundSize + int64(slot) + 1
+}
+
+// chainSlot returns the round and the slot of the chain of the given round of
+// the height.
+func (c *core) chainSlot(round int32) (uint32, uint16) {
+	g := c.base + 1 + int64(round)
+	return uint32(1 + (g-1)/c.roundSize), uint16((g - 1) % c.roundSize)
+}
+
+// roundStart returns the time the given round of the height starts at.
+func (c *core) roundStart(round int32) time.Time {
+	chainRound, slot := c.chainSlot(round)
+	return time.Unix(c.scheduler.SlotStartTime(int64(chainRound), int64(slot)), 0)
+}
+
+// proposer returns the validator proposing in the given round of the height.
+func (c *core) proposer(round int32) (*common.Address, error) {
+	chainRound, slot := c.chainSlot(round)
+	proposers, err := c.backend.Proposers(chainRound)
+	if err != nil {
+		return nil, err
+	}
+	if int(slot) >= len(proposers) || proposers[slot] == nil {
+		return nil, common.AssertError("no proposer for the slot")
+	}
+	return proposers[slot], nil
+}
+
+// hasKey returns whether the node has the key of the validator.
+func (c *core) hasKey(validator common.Address) bool {
+	for _, key := range c.keys {
+		if key == validator {
+			return true
+		}
+	}
+	return false
+}
+
+// voter returns the key of the node which votes at the height.
+func (c *core) voter() (common.Address, bool) {
+	for _, key := range c.keys {
+		if _, exists := c.voters[key]; exists {
+			return key, true
+		}
+	}
+	return common.Address{}, false
+}
+
+// isQuorum returns whether the number of votes is more than two thirds of
+// the voters.
+func (c *core) isQuorum(votes int) bool {
+	return len(c.voters) > 0 && votes*3 > len(c.voters)*2
+}
+
+// roundState returns the messages of the given round of the height.
+func (c *core) roundState(round int32) *roundState {
+	rs, exists := c.rounds[round]
+	if !exists {
+		rs = &roundState{
+			prevotes:   make(map[common.Address]*protos.MsgVote),
+			precommits: make(map[common.Address]*protos.MsgVote),
+		}
+		c.rounds[round] = rs
+	}
+	return rs
+}
+
+// newHeight starts the height following the tip of the chain, at the round of
+// the current slot.
+func (c *core) newHeight() {
+	tip := c.backend.Tip()
+	c.height = tip.Height + 1
+	c.parent = tip.Hash
+	c.base = c.globalSlot(tip.Round, tip.SlotIndex)
+	c.loadVoters()
+
+	c.lockedRound, c.lockedBlock = -1, nil
+	c.validRound, c.validBlock = -1, nil
+	c.rounds = make(map[int32]*roundState)
+	c.checked = make(map[common.Hash]error)
+
+	round, slot := c.scheduler.SlotAt(c.backend.Now().Unix())
+	first := int32(0)
+	if current := c.globalSlot(uint32(round), uint16(slot)); current > c.base+1 {
+		first = int32(current - c.base - 1)
+	}
+	log.Debugf("BFT new height %d after %v at round %d", c.height, c.parent, first)
+	c.startRound(first)
+
+	future := c.future
+	c.future = nil
+	for _, msg := range future {
+		c.handleMessage(msg)
+	}
+}
+
+// loadVoters loads the validators voting at the height.
+func (c *core) loadVoters() {
+	chainRound, _ := c.chainSlot(0)
+	voters, err := c.backend.Voters(chainRound)
+	if err != nil {
+		log.Errorf("BFT failed to get the validators of height %d: %v", c.height, err)
+		c.voters = nil
+		return
+	}
+	c.voters = make(map[common.Address]struct{}, len(voters))
+	for _, voter := range voters {
+		c.voters[voter] = struct{}{}
+	}
+}
+
+// startRound starts the given round of the height, unless the chain moved to
+// another block in the meantime.
+func (c *core) startRound(round int32) {
+	if c.backend.Tip().Hash != c.parent {
+		c.newHeight()
+		return
+	}
+	if len(c.voters) == 0 {
+		c.loadVoters()
+	}
+	c.keys = c.backend.Keys()
+
+	c.round = round
+	c.step = stepNewRound
+	start := c.roundStart(round)
+	c.backend.Schedule(start, timeout{c.height, round, stepPropose})
+	c.backend.Schedule(start.Add(c.interval/3), timeout{c.height, round, stepPrevote})
+	c.backend.Schedule(start.Add(c.interval*2/3), timeout{c.height, round, stepPrecommit})
+	c.backend.Schedule(start.Add(c.interval), timeout{c.height, round, stepNewRound})
+}
+
+// handleTimeout moves the state machine to the step of the timeout.
+func (c *core) handleTimeout(t timeout) {
+	if t.height != c.height || t.round != c.round {
+		return
+	}
+	switch t.step {
+	case stepPropose:
+		if c.step == stepNewRound {
+			c.step = stepPropose
+			c.propose()
+		}
+	case stepPrevote:
+		if c.step <= stepPropose {
+			c.step = stepPrevote
+			c.castVote(protos.VotePrevote, common.Hash{})
+		}
+	case stepPrecommit:
+		if c.step <= stepPrevote {
+			c.step = stepPrecommit
+			c.castVote(protos.VotePrecommit, common.Hash{})
+		}
+	case stepNewRound:
+		c.startRound(c.round + 1)
+		return
+	}
+	c.process()
+}
+
+// propose sends the proposal of the round when the node is its proposer.  The
+// block which gathered the prevotes of a previous round is proposed again.
+func (c *core) propose() {
+	proposer, err := c.proposer(c.round)
+	if err != nil || !c.hasKey(*proposer) {
+		return
+	}
+
+	proposal := &protos.MsgProposal{
+		Round:    c.round,
+		POLRound: -1,
+	}
+	if c.validBlock != nil {
+		proposal.POLRound = c.validRound
+		proposal.Block = *c.validBlock
+	} else {
+		chainRound, slot := c.chainSlot(c.round)
+		block, err := c.backend.Propose(*proposer, chainRound, slot, c.backend.Now().Unix())
+		if err != nil {
+			log.Errorf("BFT failed to propose a block at height %d round %d: %v",
+				c.height, c.round, err)
+			return
+		}
+		proposal.Block = *block
+	}
+	proposal.Signature, err = c.backend.Sign(*proposer, proposal.SignHash())
+	if err != nil {
+		log.Errorf("BFT failed to sign the proposal: %v", err)
+		return
+	}
+
+	log.Infof("BFT propose block %v at height %d round %d", proposal.Block.BlockHash(),
+		c.height, c.round)
+	c.roundState(c.round).proposal = proposal
+	c.backend.Broadcast(proposal)
+}
+
+// castVote sends the vote of the node for the block with the given hash in
+// the round, when the node is a validator.
+func (c *core) castVote(voteType protos.VoteType, blockHash common.Hash) {
+	voter, exists := c.voter()
+	if !exists {
+		return
+	}
+	vote := &protos.MsgVote{
+		Type:      voteType,
+		Height:    c.height,
+		Round:     c.round,
+		BlockHash: blockHash,
+		Validator: voter,
+	}
+	var err error
+	vote.Signature, err = c.backend.Sign(voter, vote.SignHash())
+	if err != nil {
+		log.Errorf("BFT failed to sign the %v: %v", voteType, err)
+		return
+	}
+	if c.addVote(vote) {
+		c.backend.Broadcast(vote)
+	}
+}
+
+// addVote adds the vote to the votes of its round, and returns whether it
+// was not known.  The first vote of a validator of each type in a round is
+// kept.
+func (c *core) addVote(vote *protos.MsgVote) bool {
+	rs := c.roundState(vote.Round)
+	votes := rs.prevotes
+	if vote.Type == protos.VotePrecommit {
+		votes = rs.precommits
+	}
+	if _, exists := votes[vote.Validator]; exists {
+		return false
+	}
+	votes[vote.Validator] = vote
+	return true
+}
+
+// handleMessage processes a message received from a peer, and relays it to
+// the other peers when it was not known.
+func (c *core) handleMessage(msg protos.Message) {
+	var height, round int32
+	switch m := msg.(type) {
+	case *protos.MsgVote:
+		height, round = m.Height, m.Round
+	case *protos.MsgProposal:
+		height, round = m.Height(), m.Round
+	default:
+		return
+	}
+
+	if height == c.height+1 && len(c.future) < maxFutureMessages {
+		c.future = append(c.future, msg)
+		return
+	}
+	if height != c.height || round < 0 || round > c.round+maxRoundsAhead {
+		return
+	}
+
+	var added bool
+	switch m := msg.(type) {
+	case *protos.MsgVote:
+		added = c.handleVote(m)
+	case *protos.MsgProposal:
+		added = c.handleProposal(m)
+	}
+	if added {
+		c.backend.Broadcast(msg)
+		c.process()
+	}
+}
+
+// handleVote adds the vote of a validator, and returns whether it was not
+// known.
+func (c *core) handleVote(vote *protos.MsgVote) bool {
+	if _, exists := c.voters[vote.Validator]; !exists {
+		return false
+	}
+	signHash := vote.SignHash()
+	err := blockchain.AddressVerifySignature(signHash[:], &vote.Validator, vote.Signature[:])
+	if err != nil {
+		log.Debugf("BFT invalid %v of %v: %v", vote.Type, vote.Validator, err)
+		return false
+	}
+	return c.addVote(vote)
+}
+
+// handleProposal adds the proposal of a round, and returns whether it was
+// not known.
+func (c *core) handleProposal(proposal *protos.MsgProposal) bool {
+	rs := c.roundState(proposal.Round)
+	if rs.proposal != nil || proposal.POLRound < -1 || proposal.POLRound >= proposal.Round {
+		return false
+	}
+	proposer, err := c.proposer(proposal.Round)
+	if err != nil {
+		log.Debugf("BFT failed to get the proposer of round %d: %v", proposal.Round, err)
+		return false
+	}
+	signHash := proposal.SignHash()
+	err = blockchain.AddressVerifySignature(signHash[:], proposer, proposal.Signature[:])
+	if err != nil {
+		log.Debugf("BFT invalid proposal of round %d: %v", proposal.Round, err)
+		return false
+	}
+	rs.proposal = proposal
+	return true
+}
+
+// isValid returns whether the block of the proposal can follow the parent of
+// the height.  A new block must be at the slot of the round it is proposed in,
+// a block proposed again at a slot before it.
+func (c *core) isValid(proposal *protos.MsgProposal) bool {
+	header := &proposal.Block.Header
+	if header.Height != c.height || header.PrevBlock != c.parent {
+		return false
+	}
+	slot := c.globalSlot(header.Round, header.SlotIndex)
+	roundSlot := c.base + 1 + int64(proposal.Round)
+	if proposal.POLRound == -1 && slot != roundSlot ||
+		proposal.POLRound != -1 && (slot <= c.base || slot >= roundSlot) {
+		return false
+	}
+
+	blockHash := proposal.Block.BlockHash()
+	err, exists := c.checked[blockHash]
+	if !exists {
+		err = c.backend.Validate(&proposal.Block)
+		if err != nil {
+			log.Infof("BFT invalid block %v at height %d: %v", blockHash, c.height, err)
+		}
+		c.checked[blockHash] = err
+	}
+	return err == nil
+}
+
+// countVotes returns the number of votes for the block with the given hash.
+func countVotes(votes map[common.Address]*protos.MsgVote, blockHash common.Hash) int {
+	count := 0
+	for _, vote := range votes {
+		if vote.BlockHash == blockHash {
+			count++
+		}
+	}
+	return count
+}
+
+// process applies the rules of the consensus until none applies any longer.
+func (c *core) process() {
+	for c.processOnce() {
+	}
+}
+
+// processOnce applies the first rule of the consensus which applies to the
+// messages received, and returns whether one did.
+func (c *core) processOnce() bool {
+	// Commit the block of a proposal which gathered the precommits of more
+	// than two thirds of the validators in any round.
+	for _, rs := range c.rounds {
+		if rs.proposal == nil {
+			continue
+		}
+		blockHash := rs.proposal.Block.BlockHash()
+		if c.isQuorum(countVotes(rs.precommits, blockHash)) && c.isValid(rs.proposal) {
+			c.commit(&rs.proposal.Block, rs.precommits)
+			return false
+		}
+	}
+
+	if c.step == stepNewRound {
+		return false
+	}
+	rs := c.roundState(c.round)
+	proposal := rs.proposal
+
+	switch {
+	// Prevote the proposal unless locked on another block.  A block
+	// proposed again is prevoted once its prevotes in the previous round
+	// are received.
+	case c.step == stepPropose && proposal != nil:
+		blockHash := proposal.Block.BlockHash()
+		lockedOn := c.lockedBlock != nil && c.lockedBlock.BlockHash() == blockHash
+		vote := common.Hash{}
+		if proposal.POLRound == -1 {
+			if c.isValid(proposal) && (c.lockedRound == -1 || lockedOn) {
+				vote = blockHash
+			}
+		} else {
+			pol, exists := c.rounds[proposal.POLRound]
+			if !exists || !c.isQuorum(countVotes(pol.prevotes, blockHash)) {
+				return false
+			}
+			if c.isValid(proposal) && (c.lockedRound <= proposal.POLRound || lockedOn) {
+				vote = blockHash
+			}
+		}
+		c.step = stepPrevote
+		c.castVote(protos.VotePrevote, vote)
+		return true
+
+	// Lock on the proposal which gathered the prevotes of more than two
+	// thirds of the validators, and precommit it.
+	case c.step >= stepPrevote && proposal != nil && !rs.polSeen &&
+		c.isQuorum(countVotes(rs.prevotes, proposal.Block.BlockHash())) &&
+		c.isValid(proposal):
+		rs.polSeen = true
+		c.validRound, c.validBlock = c.round, &proposal.Block
+		if c.step == stepPrevote {
+			c.lockedRound, c.lockedBlock = c.round, &proposal.Block
+			c.step = stepPrecommit
+			c.castVote(protos.VotePrecommit, proposal.Block.BlockHash())
+		}
+		return true
+
+	// Precommit nil once more than two thirds of the validators prevoted
+	// nil.
+	case c.step == stepPrevote && c.isQuorum(countVotes(rs.prevotes, common.Hash{})):
+		c.step = stepPrecommit
+		c.castVote(protos.VotePrecommit, common.Hash{})
+		return true
+	}
+	return false
+}
+
+// commit connects the decided block to the chain and starts the next height.
+func (c *core) commit(block *protos.MsgBlock, votes map[common.Address]*protos.MsgVote) {
+	blockHash := block.BlockHash()
+	precommits := make([]*protos.MsgVote, 0, len(votes))
+	for _, vote := range votes {
+		if vote.BlockHash == blockHash {
+			precommits = append(precommits, vote)
+		}
+	}
+	log.Infof("BFT commit block %v at height %d round %d with %d precommits",
+		blockHash, c.height, c.round, len(precommits))
+	if err := c.backend.Commit(block, precommits); err != nil {
+		log.Errorf("BFT failed to commit block %v: %v", blockHash, err)
+	}
+	c.newHeight()
+}
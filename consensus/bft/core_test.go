@@ -0,0 +1,528 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package bft
+
+import (
+	"bytes"
+	"container/heap"
+	"crypto/ecdsa"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/AsimovNetwork/asimov/blockchain"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/common/hexutil"
+	"github.com/AsimovNetwork/asimov/crypto"
+	"github.com/AsimovNetwork/asimov/protos"
+)
+
+const (
+	simChainStartTime = 1500000000
+	simBlockInterval  = 5
+	simRoundSize      = 4
+	simLatency        = 100 * time.Millisecond
+)
+
+// simEvent is an event of the simulation, run at its time.
+type simEvent struct {
+	at  time.Time
+	seq int
+	fn  func()
+}
+
+// simEvents is a queue of events ordered by time, then by the order they were
+// added in.
+type simEvents []*simEvent
+
+func (q simEvents) Len() int { return len(q) }
+func (q simEvents) Less(i, j int) bool {
+	if q[i].at.Equal(q[j].at) {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].at.Before(q[j].at)
+}
+func (q simEvents) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *simEvents) Push(x interface{}) { *q = append(*q, x.(*simEvent)) }
+func (q *simEvents) Pop() interface{} {
+	old := *q
+	event := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return event
+}
+
+// simNetwork runs the nodes of the simulation on a virtual clock, and delivers
+// their messages to each other after simLatency unless the link between them
+// is cut.
+type simNetwork struct {
+	t          *testing.T
+	now        time.Time
+	seq        int
+	events     simEvents
+	nodes      []*simNode
+	validators []common.Address
+	cut        map[[2]int]bool
+
+	// The key validator 0 rotates to, from the round rotateAt.
+	rotateAt uint32
+	rotated  common.Address
+}
+
+// simNode is a node of the simulation.  It implements the Backend interface.
+type simNode struct {
+	net     *simNetwork
+	index   int
+	account *crypto.Account
+	keys    []*crypto.Account
+	core    *core
+	chain   []*protos.MsgBlock
+	crashed bool
+}
+
+// newSimNetwork returns a network of the given number of validators, all of
+// them on the genesis block.
+func newSimNetwork(t *testing.T, validators int) *simNetwork {
+	net := &simNetwork{
+		t:   t,
+		now: time.Unix(simChainStartTime+1, 0),
+		cut: make(map[[2]int]bool),
+	}
+	genesis := &protos.MsgBlock{
+		Header: protos.BlockHeader{
+			Timestamp: simChainStartTime,
+			Round:     0,
+			SlotIndex: simRoundSize - 1,
+		},
+	}
+	for i := 0; i < validators; i++ {
+		account := newSimAccount(t)
+		node := &simNode{
+			net:     net,
+			index:   i,
+			account: account,
+			keys:    []*crypto.Account{account},
+			chain:   []*protos.MsgBlock{genesis},
+		}
+		node.core = newCore(node, simChainStartTime, simBlockInterval, simRoundSize)
+		net.nodes = append(net.nodes, node)
+		net.validators = append(net.validators, *account.Address)
+	}
+	for _, node := range net.nodes {
+		node.core.newHeight()
+	}
+	return net
+}
+
+// newSimAccount returns the account of a new key.
+func newSimAccount(t *testing.T) *crypto.Account {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey error %v", err)
+	}
+	account, err := crypto.NewAccount(hexutil.Encode(crypto.FromECDSA(key)))
+	if err != nil {
+		t.Fatalf("NewAccount error %v", err)
+	}
+	return account
+}
+
+// validatorsAt returns the validators active in the round of the chain.
+func (net *simNetwork) validatorsAt(round uint32) []common.Address {
+	validators := make([]common.Address, len(net.validators))
+	copy(validators, net.validators)
+	if net.rotateAt > 0 && round >= net.rotateAt {
+		validators[0] = net.rotated
+	}
+	return validators
+}
+
+// at runs the function at the given time.
+func (net *simNetwork) at(at time.Time, fn func()) {
+	net.seq++
+	heap.Push(&net.events, &simEvent{at: at, seq: net.seq, fn: fn})
+}
+
+// run runs the events of the given number of slots.
+func (net *simNetwork) run(slots int) {
+	end := net.now.Add(time.Duration(slots*simBlockInterval) * time.Second)
+	for net.events.Len() > 0 && !net.events[0].at.After(end) {
+		event := heap.Pop(&net.events).(*simEvent)
+		if event.at.After(net.now) {
+			net.now = event.at
+		}
+		event.fn()
+	}
+	net.now = end
+}
+
+// partition cuts the links between the nodes of the two groups.
+func (net *simNetwork) partition(a, b []int, cut bool) {
+	for _, i := range a {
+		for _, j := range b {
+			net.cut[[2]int{i, j}] = cut
+			net.cut[[2]int{j, i}] = cut
+		}
+	}
+}
+
+// checkAgreement checks all the nodes committed the same block at each
+// height.
+func (net *simNetwork) checkAgreement() {
+	for height := 1; ; height++ {
+		var hash *common.Hash
+		committed := false
+		for _, node := range net.nodes {
+			if height >= len(node.chain) {
+				continue
+			}
+			committed = true
+			blockHash := node.chain[height].BlockHash()
+			if hash == nil {
+				hash = &blockHash
+			} else if *hash != blockHash {
+				net.t.Fatalf("node %d committed %v at height %d, another "+
+					"node committed %v", node.index, blockHash, height, hash)
+			}
+		}
+		if !committed {
+			return
+		}
+	}
+}
+
+// height returns the height of the chain of the node.
+func (node *simNode) height() int32 {
+	return int32(len(node.chain) - 1)
+}
+
+func (node *simNode) Now() time.Time {
+	return node.net.now
+}
+
+func (node *simNode) Schedule(at time.Time, t timeout) {
+	node.net.at(at, func() {
+		if !node.crashed {
+			node.core.handleTimeout(t)
+		}
+	})
+}
+
+func (node *simNode) Keys() []common.Address {
+	keys := make([]common.Address, 0, len(node.keys))
+	for _, acc := range node.keys {
+		keys = append(keys, *acc.Address)
+	}
+	return keys
+}
+
+func (node *simNode) Sign(signer common.Address, hash common.Hash) ([protos.HashSignLen]byte, error) {
+	var sig [protos.HashSignLen]byte
+	var account *crypto.Account
+	for _, acc := range node.keys {
+		if *acc.Address == signer {
+			account = acc
+		}
+	}
+	if account == nil {
+		return sig, errors.New("no key of the signer")
+	}
+	signature, err := crypto.Sign(hash[:], (*ecdsa.PrivateKey)(&account.PrivateKey))
+	if err != nil {
+		return sig, err
+	}
+	copy(sig[:], signature)
+	return sig, nil
+}
+
+// Broadcast delivers the message, encoded and decoded again, to the other
+// nodes the node is linked to.
+func (node *simNode) Broadcast(msg protos.Message) {
+	var buf bytes.Buffer
+	if err := msg.VVSEncode(&buf, common.ProtocolVersion, protos.BaseEncoding); err != nil {
+		node.net.t.Fatalf("VVSEncode error %v", err)
+	}
+	for _, peer := range node.net.nodes {
+		if peer == node || node.net.cut[[2]int{node.index, peer.index}] {
+			continue
+		}
+		var received protos.Message
+		switch msg.(type) {
+		case *protos.MsgVote:
+			received = &protos.MsgVote{}
+		case *protos.MsgProposal:
+			received = &protos.MsgProposal{}
+		}
+		err := received.VVSDecode(bytes.NewReader(buf.Bytes()),
+			common.ProtocolVersion, protos.BaseEncoding)
+		if err != nil {
+			node.net.t.Fatalf("VVSDecode error %v", err)
+		}
+		peer := peer
+		node.net.at(node.net.now.Add(simLatency), func() {
+			if !peer.crashed {
+				peer.core.handleMessage(received)
+			}
+		})
+	}
+}
+
+func (node *simNode) Tip() *blockchain.BestState {
+	tip := node.chain[len(node.chain)-1]
+	return &blockchain.BestState{
+		Hash:      tip.BlockHash(),
+		Height:    tip.Header.Height,
+		Round:     tip.Header.Round,
+		SlotIndex: tip.Header.SlotIndex,
+		TimeStamp: tip.Header.Timestamp,
+	}
+}
+
+// Proposers returns the validators in turn, as the round manager of poa does.
+func (node *simNode) Proposers(round uint32) ([]*common.Address, error) {
+	validators := node.net.validatorsAt(round)
+	proposers := make([]*common.Address, simRoundSize)
+	for i := range proposers {
+		proposers[i] = &validators[i%len(validators)]
+	}
+	return proposers, nil
+}
+
+func (node *simNode) Voters(round uint32) ([]common.Address, error) {
+	return node.net.validatorsAt(round), nil
+}
+
+func (node *simNode) Propose(proposer common.Address, round uint32, slot uint16,
+	timestamp int64) (*protos.MsgBlock, error) {
+	tip := node.chain[len(node.chain)-1]
+	return &protos.MsgBlock{
+		Header: protos.BlockHeader{
+			PrevBlock: tip.BlockHash(),
+			Timestamp: timestamp,
+			Height:    tip.Header.Height + 1,
+			Round:     round,
+			SlotIndex: slot,
+			CoinBase:  proposer,
+		},
+	}, nil
+}
+
+// Validate checks the block follows the tip and is produced by the validator
+// of its slot, as the chain rules of poa do.
+func (node *simNode) Validate(block *protos.MsgBlock) error {
+	tip := node.chain[len(node.chain)-1]
+	if block.Header.PrevBlock != tip.BlockHash() {
+		return errors.New("block does not follow the tip")
+	}
+	proposers, _ := node.Proposers(block.Header.Round)
+	if *proposers[block.Header.SlotIndex] != block.Header.CoinBase {
+		return errors.New("block is not produced by the validator of its slot")
+	}
+	return nil
+}
+
+func (node *simNode) Commit(block *protos.MsgBlock, precommits []*protos.MsgVote) error {
+	if err := node.Validate(block); err != nil {
+		node.net.t.Fatalf("node %d committed an invalid block: %v", node.index, err)
+	}
+	if !node.core.isQuorum(len(precommits)) {
+		node.net.t.Fatalf("node %d committed a block with %d precommits",
+			node.index, len(precommits))
+	}
+	node.chain = append(node.chain, block)
+	return nil
+}
+
+// TestConsensus tests the validators of an in-process network agree on the
+// blocks they commit, each slot in turn.
+func TestConsensus(t *testing.T) {
+	net := newSimNetwork(t, 4)
+	net.run(20)
+	net.checkAgreement()
+
+	for _, node := range net.nodes {
+		// The node may be deciding the block of the last slot.
+		if node.height() < 19 {
+			t.Errorf("node %d committed %d blocks in 20 slots", node.index,
+				node.height())
+		}
+		for height := 1; height < len(node.chain); height++ {
+			header := &node.chain[height].Header
+			proposers, _ := node.Proposers(header.Round)
+			if node.core.globalSlot(header.Round, header.SlotIndex) != int64(height) ||
+				*proposers[header.SlotIndex] != header.CoinBase {
+				t.Errorf("node %d: block at height %d is at round %d slot %d "+
+					"by %v", node.index, height, header.Round,
+					header.SlotIndex, header.CoinBase)
+			}
+		}
+	}
+}
+
+// TestConsensusCrashedValidator tests the validators keep committing blocks
+// while one of four is down, skipping its slots.
+func TestConsensusCrashedValidator(t *testing.T) {
+	net := newSimNetwork(t, 4)
+	net.nodes[3].crashed = true
+	net.run(20)
+	net.checkAgreement()
+
+	crashed := *net.nodes[3].account.Address
+	for _, node := range net.nodes[:3] {
+		if node.height() < 14 {
+			t.Errorf("node %d committed %d blocks in 20 slots", node.index,
+				node.height())
+		}
+		for _, block := range node.chain[1:] {
+			if block.Header.CoinBase == crashed {
+				t.Errorf("node %d committed a block of the crashed validator",
+					node.index)
+			}
+		}
+	}
+	if net.nodes[3].height() != 0 {
+		t.Errorf("crashed node committed %d blocks", net.nodes[3].height())
+	}
+}
+
+// TestConsensusPartition tests no block is committed while the validators are
+// split in two halves, none of them holding more than two thirds of the
+// votes, and that they agree again once the partition heals.
+func TestConsensusPartition(t *testing.T) {
+	net := newSimNetwork(t, 4)
+	net.run(4)
+	net.checkAgreement()
+	before := net.nodes[0].height()
+	if before < 3 {
+		t.Fatalf("committed %d blocks in 4 slots", before)
+	}
+
+	net.partition([]int{0, 1}, []int{2, 3}, true)
+	net.run(12)
+	net.checkAgreement()
+	for _, node := range net.nodes {
+		// A block being decided when the partition started may still be
+		// committed by some nodes.
+		if node.height() > before+1 {
+			t.Errorf("node %d committed %d blocks during the partition",
+				node.index, node.height()-before)
+		}
+	}
+	during := net.nodes[0].height()
+
+	net.partition([]int{0, 1}, []int{2, 3}, false)
+	net.run(12)
+	net.checkAgreement()
+	for _, node := range net.nodes {
+		if node.height() < during+8 {
+			t.Errorf("node %d committed %d blocks after the partition healed",
+				node.index, node.height()-during)
+		}
+	}
+}
+
+// TestConsensusLock tests a validator locked on a block does not prevote
+// another block of a later round, unless the block gathered the prevotes of
+// more than two thirds of the validators in a round after the lock.
+func TestConsensusLock(t *testing.T) {
+	net := newSimNetwork(t, 4)
+	node := net.nodes[0]
+	c := node.core
+
+	// Lock the node on a block at round 0.
+	locked := &protos.MsgBlock{Header: protos.BlockHeader{Height: 1, CoinBase: common.Address{0x01}}}
+	c.lockedRound, c.lockedBlock = 0, locked
+
+	other, _ := net.nodes[1].Propose(*net.nodes[1].account.Address, 1, 1, simChainStartTime+2*simBlockInterval)
+	proposal := &protos.MsgProposal{Round: 1, POLRound: -1, Block: *other}
+	proposal.Signature, _ = net.nodes[1].Sign(*net.nodes[1].account.Address, proposal.SignHash())
+	c.round, c.step = 1, stepPropose
+	if !c.handleProposal(proposal) {
+		t.Fatalf("proposal of round 1 refused")
+	}
+	c.process()
+	prevote := c.roundState(1).prevotes[*node.account.Address]
+	if prevote == nil || prevote.BlockHash != (common.Hash{}) {
+		t.Fatalf("locked node prevoted %v, want nil", prevote)
+	}
+
+	// The block gathers the prevotes of the other validators in round 1, so
+	// the node locks on it and prevotes it when proposed again in round 2.
+	for _, voter := range net.nodes[1:] {
+		vote := &protos.MsgVote{
+			Type:      protos.VotePrevote,
+			Height:    1,
+			Round:     1,
+			BlockHash: other.BlockHash(),
+			Validator: *voter.account.Address,
+		}
+		vote.Signature, _ = voter.Sign(*voter.account.Address, vote.SignHash())
+		if !c.handleVote(vote) {
+			t.Fatalf("prevote of node %d refused", voter.index)
+		}
+	}
+	c.process()
+	if c.validRound != 1 || c.validBlock.BlockHash() != other.BlockHash() ||
+		c.lockedRound != 1 || c.lockedBlock.BlockHash() != other.BlockHash() {
+		t.Fatalf("node locked on %v of round %d", c.lockedBlock, c.lockedRound)
+	}
+
+	again := &protos.MsgProposal{Round: 2, POLRound: 1, Block: *other}
+	again.Signature, _ = net.nodes[2].Sign(*net.nodes[2].account.Address, again.SignHash())
+	c.round, c.step = 2, stepPropose
+	if !c.handleProposal(again) {
+		t.Fatalf("proposal of round 2 refused")
+	}
+	c.process()
+	prevote = c.roundState(2).prevotes[*node.account.Address]
+	if prevote == nil || prevote.BlockHash != other.BlockHash() {
+		t.Fatalf("node prevoted %v, want the block proposed again", prevote)
+	}
+
+	// A proposal signed by another validator than the one of the slot is
+	// refused.
+	forged := &protos.MsgProposal{Round: 3, POLRound: -1, Block: *other}
+	forged.Signature, _ = net.nodes[1].Sign(*net.nodes[1].account.Address, forged.SignHash())
+	if c.handleProposal(forged) {
+		t.Errorf("proposal signed by another validator than the proposer accepted")
+	}
+}
+
+// TestConsensusKeyRotation tests a validator which rotates its key keeps
+// proposing and voting at its slots once the new key is active, without a
+// failed round.
+func TestConsensusKeyRotation(t *testing.T) {
+	net := newSimNetwork(t, 4)
+	net.run(4)
+
+	// The validator adds the key it rotates to, which activates at the
+	// next round of the chain.
+	node := net.nodes[0]
+	rotated := newSimAccount(t)
+	node.keys = append(node.keys, rotated)
+	net.rotateAt, net.rotated = 3, *rotated.Address
+	net.run(16)
+	net.checkAgreement()
+
+	byRotated := 0
+	for height := 1; height < len(node.chain); height++ {
+		header := &node.chain[height].Header
+		if node.core.globalSlot(header.Round, header.SlotIndex) != int64(height) {
+			t.Fatalf("block at height %d is at round %d slot %d, a round failed",
+				height, header.Round, header.SlotIndex)
+		}
+		switch {
+		case header.Round >= net.rotateAt && header.CoinBase == *node.account.Address:
+			t.Errorf("block at height %d is produced with the old key", height)
+		case header.CoinBase == *rotated.Address:
+			byRotated++
+		}
+	}
+	if byRotated == 0 {
+		t.Errorf("no block produced with the rotated key")
+	}
+	if node.height() < 19 {
+		t.Errorf("node committed %d blocks in 20 slots", node.height())
+	}
+}
@@ -0,0 +1,281 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// Package bft implements a byzantine fault tolerant consensus, in the style of
+// Tendermint, for the permissioned chains.  The validators registered in the
+// consensus_poa contract propose the blocks in turn, as with poa, but a block
+// is only connected once more than two thirds of the validators prevoted and
+// then precommitted it, so the committed blocks are final.
+package bft
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/blockchain"
+	"github.com/AsimovNetwork/asimov/chaincfg"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/consensus/params"
+	"github.com/AsimovNetwork/asimov/crypto"
+	"github.com/AsimovNetwork/asimov/mining"
+	"github.com/AsimovNetwork/asimov/protos"
+)
+
+// maxPendingMessages is the number of messages received from the peers which
+// wait for the state machine before new ones are dropped.
+const maxPendingMessages = 1024
+
+// Service runs the state machine of the bft consensus for the validator of
+// the node.  It implements the Backend interface of the state machine, on top
+// of the chain.
+type Service struct {
+	sync.Mutex
+	wg        sync.WaitGroup
+	existCh   chan interface{}
+	msgCh     chan protos.Message
+	timeoutCh chan timeout
+
+	core     *core
+	template *mining.BlockTemplate
+	config   *params.Config
+	keys     *crypto.KeyRing
+}
+
+/*
+ * create BFT Service
+ */
+func NewService(config *params.Config) (*Service, error) {
+	if config == nil {
+		return nil, errors.New("config can't be nil")
+	}
+	if config.Chain == nil {
+		return nil, errors.New("config.chain can't be nil")
+	}
+	service := &Service{
+		config: config,
+		keys:   config.KeyRing(),
+	}
+	return service, nil
+}
+
+/*
+ * start the Service
+ */
+func (s *Service) Start() error {
+	s.Lock()
+	defer s.Unlock()
+	if s.existCh != nil {
+		return errors.New("bft consensus is already started")
+	}
+	if s.config.Account == nil && len(s.keys.Accounts()) == 0 {
+		log.Warn("bft service exit when account is nil")
+		return nil
+	}
+	log.Info("BFT consensus start")
+
+	s.core = newCore(s, chaincfg.ActiveNetParams.ChainStartTime,
+		common.DefaultBlockInterval, chaincfg.ActiveNetParams.RoundSize)
+	s.existCh = make(chan interface{})
+	s.msgCh = make(chan protos.Message, maxPendingMessages)
+	s.timeoutCh = make(chan timeout, maxPendingMessages)
+
+	existCh, msgCh, timeoutCh := s.existCh, s.msgCh, s.timeoutCh
+	s.wg.Add(1)
+	go func() {
+		s.core.newHeight()
+	mainloop:
+		for {
+			select {
+			case msg := <-msgCh:
+				s.core.handleMessage(msg)
+			case t := <-timeoutCh:
+				s.core.handleTimeout(t)
+			case <-existCh:
+				break mainloop
+			}
+		}
+		s.wg.Done()
+	}()
+
+	return nil
+}
+
+func (s *Service) Halt() error {
+	s.Lock()
+	defer s.Unlock()
+	log.Info("BFT Service Stop")
+
+	if s.existCh != nil {
+		close(s.existCh)
+		s.existCh = nil
+	}
+	s.wg.Wait()
+	return nil
+}
+
+// HandleMessage queues a message of the consensus received from a peer.  The
+// message is dropped when the service is not running or too many messages
+// are waiting.  This is part of the ainterface.MessageHandler interface.
+func (s *Service) HandleMessage(msg protos.Message) {
+	s.Lock()
+	msgCh := s.msgCh
+	running := s.existCh != nil
+	s.Unlock()
+	if !running {
+		return
+	}
+	select {
+	case msgCh <- msg:
+	default:
+		log.Debugf("BFT drop %s message, too many pending", msg.Command())
+	}
+}
+
+func (s *Service) GetRoundInterval() int64 {
+	return common.DefaultBlockInterval * int64(chaincfg.ActiveNetParams.RoundSize)
+}
+
+// Now returns the current time.  This is part of the Backend interface.
+func (s *Service) Now() time.Time {
+	return time.Now()
+}
+
+// Schedule queues the timeout to the state machine at the given time.  This
+// is part of the Backend interface.
+func (s *Service) Schedule(at time.Time, t timeout) {
+	timeoutCh := s.timeoutCh
+	time.AfterFunc(time.Until(at), func() {
+		select {
+		case timeoutCh <- t:
+		default:
+			log.Warnf("BFT drop timeout of height %d round %d", t.height, t.round)
+		}
+	})
+}
+
+// Keys reloads the keys of the validator when they changed, and returns their
+// addresses.  This is part of the Backend interface.
+func (s *Service) Keys() []common.Address {
+	if reloaded, err := s.keys.Reload(); err != nil {
+		log.Warnf("BFT failed to reload the validator keys: %v", err)
+	} else if reloaded {
+		log.Infof("BFT reloaded %d validator keys", len(s.keys.Accounts()))
+	}
+	accounts := s.keys.Accounts()
+	keys := make([]common.Address, 0, len(accounts))
+	for _, acc := range accounts {
+		keys = append(keys, *acc.Address)
+	}
+	return keys
+}
+
+// Sign signs the hash with the account of the node with the given address.
+// This is part of the Backend interface.
+func (s *Service) Sign(signer common.Address, hash common.Hash) ([protos.HashSignLen]byte, error) {
+	var sig [protos.HashSignLen]byte
+	acc := s.keys.Lookup(signer)
+	if acc == nil {
+		return sig, fmt.Errorf("no key of %v", signer)
+	}
+	signature, err := crypto.Sign(hash[:], (*ecdsa.PrivateKey)(&acc.PrivateKey))
+	if err != nil {
+		return sig, err
+	}
+	copy(sig[:], signature)
+	return sig, nil
+}
+
+// Broadcast sends the message to all the peers.  This is part of the Backend
+// interface.
+func (s *Service) Broadcast(msg protos.Message) {
+	if s.config.Broadcast != nil {
+		s.config.Broadcast(msg)
+	}
+}
+
+// Tip returns the state of the best block.  This is part of the Backend
+// interface.
+func (s *Service) Tip() *blockchain.BestState {
+	return s.config.Chain.BestSnapshot()
+}
+
+// Proposers returns the validators of the slots of the round, according to
+// the round manager of poa the chain is validated with.  This is part of the
+// Backend interface.
+func (s *Service) Proposers(round uint32) ([]*common.Address, error) {
+	validators, _, err := s.config.Chain.GetValidators(round)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get validators %v", err.Error())
+	}
+	return validators, nil
+}
+
+// Voters returns the validators of the consensus_poa contract active in the
+// round.  This is part of the Backend interface.
+func (s *Service) Voters(round uint32) ([]common.Address, error) {
+	return s.config.Chain.GetPOAValidators(round)
+}
+
+// Propose produces a new block of the proposer on the best block.  This is
+// part of the Backend interface.
+func (s *Service) Propose(proposer common.Address, round uint32, slot uint16,
+	timestamp int64) (*protos.MsgBlock, error) {
+	acc := s.keys.Lookup(proposer)
+	if acc == nil {
+		return nil, fmt.Errorf("no key of %v", proposer)
+	}
+	best := s.config.Chain.BestSnapshot()
+	if !s.config.IsCurrent() && best.Height > 0 {
+		return nil, errors.New("downloading blocks")
+	}
+	blockInterval := float64(s.GetRoundInterval()) / float64(chaincfg.ActiveNetParams.RoundSize) * 1000
+	template, err := s.config.BlockTemplateGenerator.ProduceNewBlock(
+		acc, s.config.GasFloor, s.config.GasCeil,
+		timestamp, round, slot, blockInterval)
+	if err != nil {
+		return nil, err
+	}
+	s.template = template
+	return template.Block.MsgBlock(), nil
+}
+
+// Validate checks the block can extend the best chain.  This is part of the
+// Backend interface.
+func (s *Service) Validate(block *protos.MsgBlock) error {
+	return s.config.Chain.CheckProposal(asiutil.NewBlock(block))
+}
+
+// Commit processes the block, the template produced for it when the node
+// proposed it, with the precommits as its commit certificate.  This is part of
+// the Backend interface.
+func (s *Service) Commit(block *protos.MsgBlock, precommits []*protos.MsgVote) error {
+	template, flags := s.template, common.BFFastAdd
+	s.template = nil
+	if template == nil || *template.Block.Hash() != block.BlockHash() {
+		template, flags = &mining.BlockTemplate{}, common.BFNone
+	}
+
+	// The commit certificate is not covered by the block hash, the block
+	// is wrapped again so its serialized bytes include it.
+	msgBlock := *block
+	msgBlock.Commit = precommits
+	committed := *template
+	committed.Block = asiutil.NewBlock(&msgBlock)
+	template = &committed
+
+	_, err := s.config.ProcessBlock(template, flags)
+	if err != nil {
+		if ruleErr, ok := err.(blockchain.RuleError); ok && ruleErr.ErrorCode == blockchain.ErrDuplicateBlock {
+			return nil
+		}
+		return err
+	}
+	log.Infof("BFT block accept, height=%d, hash=%v, precommits=%d, txNum=%d",
+		block.Header.Height, block.BlockHash(), len(precommits), len(block.Transactions))
+	return nil
+}
@@ -0,0 +1,243 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// Package conformance provides the tests every consensus engine registered
+// with the consensus package must pass.  An engine runs them from its own
+// tests against a simulated chain, first on its round manager alone, then on
+// its service running on the nodes of a simulated network:
+//
+//	func TestConformance(t *testing.T) {
+//		conformance.Run(t, &conformance.Harness{
+//			Engine:     "myengine",
+//			Validators: validators,
+//		})
+//	}
+package conformance
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/AsimovNetwork/asimov/ainterface"
+	"github.com/AsimovNetwork/asimov/chaincfg"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/consensus"
+	"github.com/AsimovNetwork/asimov/consensus/params"
+	"github.com/AsimovNetwork/asimov/crypto"
+)
+
+// defaultRounds is the number of rounds simulated when none is given.
+const defaultRounds = 3
+
+// Harness describes the simulated chain an engine runs against.
+type Harness struct {
+	// Engine is the name the engine is registered with.
+	Engine string
+
+	// Validators are the validators signed up in the validator committee
+	// of the simulated chain.
+	Validators []common.Address
+
+	// Account is the account of the node, which is passed to the round
+	// manager of the engine.
+	Account *crypto.Account
+
+	// Rounds is the number of rounds simulated, 3 by default.
+	Rounds int
+
+	// Init is the optional function to initialize the round manager of the
+	// engine, which defaults to Init(0, nil, nil).
+	Init func(roundManager ainterface.IRoundManager) error
+
+	// Keys are the accounts of the validators run by the nodes of the
+	// simulated network, a node each.  A single node runs the Account when
+	// there is none.
+	Keys []*crypto.Account
+
+	// Blocks is the number of blocks the nodes produce, 2 by default.
+	Blocks int
+
+	// GenesisFile is the genesis block of the develop network the chains of
+	// the nodes start from, ../../genesisbin/devnet.block by default.
+	GenesisFile string
+
+	// Params is the optional function to adjust the parameters of the
+	// chains of the nodes, which are the ones of the develop network.
+	Params func(params *chaincfg.Params)
+
+	// BtcClient is the Bitcoin client of the chains of the nodes.
+	BtcClient ainterface.IBtcClient
+}
+
+// simBlock is a block of the simulated chain.
+type simBlock struct {
+	round int64
+	slot  int64
+	time  int64
+	hash  common.Hash
+}
+
+// Run runs the conformance tests of the engine described by the harness.
+func Run(t *testing.T, h *Harness) {
+	engine := consensus.LookupEngine(h.Engine)
+	if engine == nil {
+		t.Fatalf("consensus %q is not registered, supported %v", h.Engine,
+			consensus.SupportedEngines())
+	}
+	if engine.NewRoundManager == nil || engine.NewService == nil {
+		t.Fatalf("consensus %q is registered without its constructors", h.Engine)
+	}
+
+	roundManager := engine.NewRoundManager(h.Account)
+	if roundManager == nil {
+		t.Fatalf("consensus %q returned no round manager", h.Engine)
+	}
+	var err error
+	if h.Init != nil {
+		err = h.Init(roundManager)
+	} else {
+		err = roundManager.Init(0, nil, nil)
+	}
+	if err != nil {
+		t.Fatalf("Init round manager err %v", err)
+	}
+	roundManager.Start()
+	defer roundManager.Halt()
+
+	rounds := h.Rounds
+	if rounds == 0 {
+		rounds = defaultRounds
+	}
+	roundSize := int64(chaincfg.ActiveNetParams.RoundSize)
+
+	// The simulated validator committee returns the signed up validators,
+	// all of them active since round 1.
+	committee := func([]string) ([]common.Address, []int32, error) {
+		filters := make([]int32, len(h.Validators))
+		for i := range filters {
+			filters[i] = 1
+		}
+		return h.Validators, filters, nil
+	}
+	signedUp := make(map[common.Address]struct{}, len(h.Validators))
+	for _, validator := range h.Validators {
+		signedUp[validator] = struct{}{}
+	}
+
+	interval := roundManager.GetRoundInterval(1)
+	if interval <= 0 || interval%roundSize != 0 {
+		t.Fatalf("round interval %d is not a multiple of the round size %d",
+			interval, roundSize)
+	}
+	scheduler := params.NewScheduler(chaincfg.ActiveNetParams.ChainStartTime,
+		interval/roundSize, chaincfg.ActiveNetParams.RoundSize)
+
+	chain := []simBlock{{
+		round: 0,
+		slot:  roundSize - 1,
+		time:  chaincfg.ActiveNetParams.ChainStartTime,
+		hash:  common.Hash{0x01},
+	}}
+	for round := int64(1); round <= int64(rounds); round++ {
+		tip := chain[len(chain)-1]
+		checkRounds(t, roundManager, round)
+
+		validators, weightMap, err := roundManager.GetValidators(tip.hash,
+			uint32(round), committee)
+		if err != nil {
+			t.Fatalf("round %d: GetValidators err %v", round, err)
+		}
+		if int64(len(validators)) != roundSize {
+			t.Fatalf("round %d: got %d validators, want one for each of "+
+				"the %d slots", round, len(validators), roundSize)
+		}
+		again, againWeights, err := roundManager.GetValidators(tip.hash,
+			uint32(round), committee)
+		if err != nil || !reflect.DeepEqual(again, validators) ||
+			!reflect.DeepEqual(againWeights, weightMap) {
+			t.Errorf("round %d: GetValidators is not deterministic", round)
+		}
+
+		for slot, validator := range validators {
+			if validator == nil {
+				t.Fatalf("round %d: no validator at slot %d", round, slot)
+			}
+			if _, exists := signedUp[*validator]; !exists {
+				t.Errorf("round %d: validator %v at slot %d is not signed up",
+					round, validator, slot)
+			}
+			if weightMap[*validator] == 0 {
+				t.Errorf("round %d: validator %v at slot %d has no weight",
+					round, validator, slot)
+			}
+			if !roundManager.HasValidator(*validator) {
+				t.Errorf("round %d: HasValidator of %v is false", round,
+					validator)
+			}
+		}
+		for validator := range weightMap {
+			if !containsValidator(validators, validator) {
+				t.Errorf("round %d: validator %v has a weight without "+
+					"a slot", round, validator)
+			}
+		}
+
+		// Produce the blocks of the round, each at the start of its slot.
+		for slot := int64(0); slot < roundSize; slot++ {
+			blockTime := scheduler.SlotStartTime(round, slot)
+			gotRound, gotSlot := scheduler.SlotAt(blockTime)
+			if gotRound != round || gotSlot != slot {
+				t.Fatalf("block time %d is at round %d slot %d, want "+
+					"round %d slot %d", blockTime, gotRound, gotSlot,
+					round, slot)
+			}
+			prev := chain[len(chain)-1]
+			if blockTime <= prev.time {
+				t.Fatalf("round %d slot %d: block time %d is not after "+
+					"%d", round, slot, blockTime, prev.time)
+			}
+			chain = append(chain, simBlock{
+				round: round,
+				slot:  slot,
+				time:  blockTime,
+				hash:  common.Hash{0x02, byte(round), byte(slot), byte(slot >> 8)},
+			})
+		}
+	}
+
+	runNetwork(t, h, engine)
+}
+
+// checkRounds checks the round manager chains the rounds one after another.
+func checkRounds(t *testing.T, roundManager ainterface.IRoundManager, round int64) {
+	interval := roundManager.GetRoundInterval(round)
+	if interval <= 0 {
+		t.Fatalf("round %d: round interval is %d", round, interval)
+	}
+	current := &ainterface.Round{
+		Round:          uint32(round),
+		RoundStartUnix: chaincfg.ActiveNetParams.ChainStartTime,
+		Duration:       interval,
+	}
+	next, err := roundManager.GetNextRound(current)
+	if err != nil {
+		t.Fatalf("round %d: GetNextRound err %v", round, err)
+	}
+	if int64(next.Round) != round+1 ||
+		next.RoundStartUnix != current.RoundStartUnix+current.Duration ||
+		next.Duration != roundManager.GetRoundInterval(round+1) {
+		t.Errorf("round %d: got next round %d starting at %d lasting %d",
+			round, next.Round, next.RoundStartUnix, next.Duration)
+	}
+}
+
+// containsValidator returns whether the validator is scheduled in a slot.
+func containsValidator(validators []*common.Address, validator common.Address) bool {
+	for _, scheduled := range validators {
+		if scheduled != nil && *scheduled == validator {
+			return true
+		}
+	}
+	return false
+}
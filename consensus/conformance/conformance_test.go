@@ -0,0 +1,61 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package conformance
+
+import (
+	"testing"
+
+	"github.com/AsimovNetwork/asimov/chaincfg"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/crypto"
+)
+
+// TestBuiltinEngines runs the conformance tests of the engines which do not
+// depend on the Bitcoin miners.
+func TestBuiltinEngines(t *testing.T) {
+	privateKeys := []string{
+		"0xd0f0461b7b4d26cf370e6c73b58ef7fa26e8e30853a8cee901ed42cf0879cb6e",
+		"0xd07f68f78fc58e3dc8ea72ff69784aa9542c452a4ee66b2665fa3cccb48441c2",
+		"0x77366e621236e71a77236e0858cd652e92c3af0908527b5bd1542992c4d7cace",
+		"0x224828e95689e30a8e668418f968260edc6aa78ae03eed607f49288d99123c25",
+	}
+	keys := make([]*crypto.Account, 0, len(privateKeys))
+	validators := make([]common.Address, 0, len(privateKeys))
+	for _, privateKey := range privateKeys {
+		acc, err := crypto.NewAccount(privateKey)
+		if err != nil {
+			t.Fatalf("NewAccount err %v", err)
+		}
+		keys = append(keys, acc)
+		validators = append(validators, *acc.Address)
+	}
+
+	t.Run("solo", func(t *testing.T) {
+		Run(t, &Harness{
+			Engine:     "solo",
+			Validators: validators[:1],
+			Account:    keys[0],
+		})
+	})
+	t.Run("poa", func(t *testing.T) {
+		Run(t, &Harness{
+			Engine:     "poa",
+			Validators: validators[:3],
+			Account:    keys[0],
+			Keys:       keys[:3],
+		})
+	})
+	t.Run("bft", func(t *testing.T) {
+		Run(t, &Harness{
+			Engine:     "bft",
+			Validators: validators,
+			Account:    keys[0],
+			Keys:       keys,
+			Params: func(params *chaincfg.Params) {
+				params.BFT = true
+			},
+		})
+	})
+}
@@ -0,0 +1,386 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package conformance
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AsimovNetwork/asimov/ainterface"
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/blockchain"
+	"github.com/AsimovNetwork/asimov/blockchain/syscontract"
+	"github.com/AsimovNetwork/asimov/chaincfg"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/consensus"
+	"github.com/AsimovNetwork/asimov/consensus/params"
+	"github.com/AsimovNetwork/asimov/crypto"
+	"github.com/AsimovNetwork/asimov/database/dbdriver"
+	"github.com/AsimovNetwork/asimov/database/dbimpl/ethdb"
+	"github.com/AsimovNetwork/asimov/mempool"
+	"github.com/AsimovNetwork/asimov/mining"
+	"github.com/AsimovNetwork/asimov/protos"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/vm"
+	fvmparams "github.com/AsimovNetwork/asimov/vm/fvm/params"
+)
+
+const (
+	// defaultBlocks is the number of blocks produced when none is given.
+	defaultBlocks = 2
+
+	// defaultGenesisFile is the genesis block of the simulated chains, from
+	// the packages of the engines under consensus.
+	defaultGenesisFile = "../../genesisbin/devnet.block"
+
+	// testDbType is the database of the blocks of the simulated chains.
+	testDbType = "ffldb"
+)
+
+// committeeManager is the contract manager of a simulated chain.  The
+// validators signed up in the consensus contracts are the ones of the
+// harness, the other calls go to the system contracts.
+type committeeManager struct {
+	ainterface.ContractManager
+	validators []common.Address
+}
+
+// GetSignedUpValidators returns the validators of the harness, all of them
+// signed up since round 0.
+func (m *committeeManager) GetSignedUpValidators(consensus common.ContractCode,
+	block *asiutil.Block, stateDB vm.StateDB, chainConfig *fvmparams.ChainConfig,
+	miners []string) ([]common.Address, []uint32, error) {
+	return m.validators, make([]uint32, len(m.validators)), nil
+}
+
+// GetAdminsAndValidators returns the admins of the consensus_poa contract and
+// the validators of the harness.
+func (m *committeeManager) GetAdminsAndValidators(block *asiutil.Block,
+	stateDB vm.StateDB, chainConfig *fvmparams.ChainConfig) ([]common.Address, []common.Address, error) {
+	admins, _, err := m.ContractManager.GetAdminsAndValidators(block, stateDB, chainConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	return admins, m.validators, nil
+}
+
+// simNode is a node of the simulated network, running the service of the
+// engine on its own chain.
+type simNode struct {
+	chain        *blockchain.BlockChain
+	roundManager ainterface.IRoundManager
+	service      ainterface.Consensus
+	teardown     func()
+}
+
+// simNetwork runs the nodes of the validators of the harness.  The blocks a
+// node produces are validated by the other nodes, and the messages of the
+// consensus a node broadcasts are handled by the other nodes.
+type simNetwork struct {
+	t     *testing.T
+	nodes []*simNode
+}
+
+// newSimNode returns a node with a new chain from the genesis block, with the
+// round manager of the engine and the validators of the harness.
+func newSimNode(t *testing.T, h *Harness, engine *consensus.Engine) *simNode {
+	dir, err := ioutil.TempDir("", "conformance")
+	if err != nil {
+		t.Fatalf("TempDir err %v", err)
+	}
+	db, err := dbdriver.Create(testDbType, filepath.Join(dir, "blocks_"+testDbType),
+		chaincfg.ActiveNetParams.Net)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("create block database err %v", err)
+	}
+	stateDB, err := ethdb.NewLDBDatabase(filepath.Join(dir, "state"), 768, 1024)
+	if err != nil {
+		db.Close()
+		os.RemoveAll(dir)
+		t.Fatalf("create state database err %v", err)
+	}
+	teardown := func() {
+		db.Close()
+		stateDB.Close()
+		os.RemoveAll(dir)
+	}
+
+	roundManager := engine.NewRoundManager(h.Account)
+	chain, err := blockchain.New(&blockchain.Config{
+		DB:           db,
+		ChainParams:  chaincfg.ActiveNetParams.Params,
+		TimeSource:   blockchain.NewMedianTime(),
+		StateDB:      stateDB,
+		BtcClient:    h.BtcClient,
+		RoundManager: roundManager,
+		ContractManager: &committeeManager{
+			ContractManager: syscontract.NewContractManager(),
+			validators:      h.Validators,
+		},
+	}, chaincfg.Cfg)
+	if err != nil {
+		teardown()
+		t.Fatalf("create chain err %v", err)
+	}
+	return &simNode{chain: chain, roundManager: roundManager, teardown: teardown}
+}
+
+// newSimNetwork returns the network of a node for each key of the harness,
+// which run the service of the engine.
+func newSimNetwork(t *testing.T, h *Harness, engine *consensus.Engine) *simNetwork {
+	keys := h.Keys
+	if len(keys) == 0 {
+		keys = []*crypto.Account{h.Account}
+	}
+	net := &simNetwork{t: t}
+	for _, key := range keys {
+		node := newSimNode(t, h, engine)
+		chain := node.chain
+		net.nodes = append(net.nodes, node)
+
+		txPool := mempool.New(&mempool.Config{
+			Policy: mempool.Policy{
+				MaxOrphanTxs:    chaincfg.DefaultMaxOrphanTransactions,
+				MaxOrphanTxSize: chaincfg.DefaultMaxOrphanTxSize,
+				MinRelayTxPrice: chaincfg.DefaultMinTxPrice,
+				MaxTxVersion:    2,
+			},
+			FetchUtxoView:          chain.FetchUtxoView,
+			Chain:                  chain,
+			BestHeight:             func() int32 { return chain.BestSnapshot().Height },
+			MedianTimePast:         func() int64 { return chain.BestSnapshot().TimeStamp },
+			CheckTransactionInputs: blockchain.CheckTransactionInputs,
+		})
+		sigPool := mempool.NewSigPool(nil)
+		service, err := engine.NewService(&params.Config{
+			BlockTemplateGenerator: mining.NewBlkTmplGenerator(&mining.Policy{},
+				txPool, sigPool, chain),
+			ProcessBlock: func(template *mining.BlockTemplate, flags common.BehaviorFlags) (bool, error) {
+				return net.processBlock(node, template, flags)
+			},
+			// The nodes of the network are the only ones, they are always
+			// current.
+			IsCurrent:    func() bool { return true },
+			ProcessSig:   sigPool.ProcessSig,
+			Chain:        chain,
+			GasFloor:     common.GasFloor,
+			GasCeil:      common.GasCeil,
+			RoundManager: node.roundManager,
+			Account:      key,
+			Broadcast: func(msg protos.Message) {
+				net.broadcast(node, msg)
+			},
+		})
+		if err != nil {
+			net.teardown()
+			t.Fatalf("consensus %q NewService err %v", h.Engine, err)
+		}
+		node.service = service
+	}
+	return net
+}
+
+// processBlock processes the block the node produced on its chain, and has the
+// other nodes validate it.
+func (net *simNetwork) processBlock(node *simNode, template *mining.BlockTemplate,
+	flags common.BehaviorFlags) (bool, error) {
+	_, isOrphan, err := node.chain.ProcessBlock(template.Block, template.VBlock,
+		template.Receipts, template.Logs, flags)
+	if err != nil || isOrphan {
+		return isOrphan, err
+	}
+	for _, other := range net.nodes {
+		if other == node {
+			continue
+		}
+		block := asiutil.NewBlock(template.Block.MsgBlock())
+		_, _, err := other.chain.ProcessBlock(block, nil, nil, nil, common.BFNone)
+		if ruleErr, ok := err.(blockchain.RuleError); ok && ruleErr.ErrorCode == blockchain.ErrDuplicateBlock {
+			continue
+		}
+		if err != nil {
+			net.t.Errorf("block %v at height %d is rejected by another node: %v",
+				block.Hash(), block.Height(), err)
+		}
+	}
+	return false, nil
+}
+
+// broadcast delivers the message of the node to the services of the other
+// nodes which handle the messages of the consensus.
+func (net *simNetwork) broadcast(node *simNode, msg protos.Message) {
+	for _, other := range net.nodes {
+		if other == node {
+			continue
+		}
+		if handler, ok := other.service.(ainterface.MessageHandler); ok {
+			handler.HandleMessage(msg)
+		}
+	}
+}
+
+// start starts the services of the nodes.
+func (net *simNetwork) start() {
+	for _, node := range net.nodes {
+		if err := node.service.Start(); err != nil {
+			net.t.Fatalf("Start service err %v", err)
+		}
+	}
+}
+
+// halt halts the services of the nodes.
+func (net *simNetwork) halt() {
+	for _, node := range net.nodes {
+		if node.service != nil {
+			node.service.Halt()
+		}
+	}
+}
+
+// teardown removes the chains of the nodes.
+func (net *simNetwork) teardown() {
+	for _, node := range net.nodes {
+		node.teardown()
+	}
+}
+
+// runNetwork starts the service of the engine on the nodes of a simulated
+// network, and checks they produce a chain of blocks another node validates.
+func runNetwork(t *testing.T, h *Harness, engine *consensus.Engine) {
+	genesisFile := h.GenesisFile
+	if genesisFile == "" {
+		genesisFile = defaultGenesisFile
+	}
+	genesisBlock, err := asiutil.LoadBlockFromFile(genesisFile)
+	if err != nil {
+		t.Fatalf("load genesis block err %v", err)
+	}
+
+	// The chains start now, round 1 starts at the next slot.
+	netParams := chaincfg.DevelopNetParams
+	netParams.GenesisBlock = genesisBlock
+	netParams.GenesisCandidates = h.Validators
+	netParams.ChainStartTime = time.Now().Unix()
+	if h.Params != nil {
+		h.Params(&netParams)
+	}
+	if *asiutil.NewBlock(genesisBlock).Hash() != *netParams.GenesisHash {
+		t.Fatalf("genesis block %v does not match the chain parameters",
+			genesisFile)
+	}
+	activeParams, cfg := chaincfg.ActiveNetParams.Params, chaincfg.Cfg
+	chaincfg.ActiveNetParams.Params = &netParams
+	if cfg == nil {
+		chaincfg.Cfg = &chaincfg.FConfig{
+			MaxTimeOffset: chaincfg.DefaultMaxTimeOffsetSeconds,
+		}
+	}
+	defer func() {
+		chaincfg.ActiveNetParams.Params = activeParams
+		chaincfg.Cfg = cfg
+	}()
+
+	blocks := h.Blocks
+	if blocks == 0 {
+		blocks = defaultBlocks
+	}
+
+	net := newSimNetwork(t, h, engine)
+	defer net.teardown()
+	net.start()
+
+	// Wait for the blocks, allowing a round of the bft engines to fail at
+	// each height.
+	roundSize := int64(netParams.RoundSize)
+	blockInterval := net.nodes[0].roundManager.GetRoundInterval(1) / roundSize
+	deadline := time.Unix(netParams.ChainStartTime+
+		blockInterval*int64(2*blocks+1), 0)
+	for time.Now().Before(deadline) && !net.produced(int32(blocks)) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	net.halt()
+	if !net.produced(int32(blocks)) {
+		t.Fatalf("the nodes did not produce %d blocks before %v", blocks, deadline)
+	}
+
+	// A node which did not take part in the consensus validates the blocks,
+	// and rejects the ones signed by another validator than the one of the
+	// slot.
+	observer := newSimNode(t, h, engine)
+	defer observer.teardown()
+	scheduler := params.NewScheduler(netParams.ChainStartTime, blockInterval,
+		netParams.RoundSize)
+	prevRound, prevSlot := int64(0), roundSize-1
+	for height := int32(1); height <= int32(blocks); height++ {
+		block, err := net.nodes[0].chain.BlockByHeight(height)
+		if err != nil {
+			t.Fatalf("BlockByHeight %d err %v", height, err)
+		}
+		header := &block.MsgBlock().Header
+		round, slot := scheduler.SlotAt(header.Timestamp)
+		if int64(header.Round) != round || int64(header.SlotIndex) != slot {
+			t.Errorf("block %d at round %d slot %d has the time %d of round "+
+				"%d slot %d", height, header.Round, header.SlotIndex,
+				header.Timestamp, round, slot)
+		}
+		if round < prevRound || round == prevRound && slot <= prevSlot {
+			t.Errorf("block %d at round %d slot %d is not after round %d "+
+				"slot %d", height, round, slot, prevRound, prevSlot)
+		}
+		prevRound, prevSlot = round, slot
+
+		validators, _, err := observer.chain.GetValidators(header.Round)
+		if err != nil {
+			t.Fatalf("GetValidators err %v", err)
+		}
+		if int(header.SlotIndex) >= len(validators) ||
+			*validators[header.SlotIndex] != header.CoinBase {
+			t.Errorf("block %d is produced by %v, which is not the "+
+				"validator of round %d slot %d", height, header.CoinBase,
+				header.Round, header.SlotIndex)
+		}
+		for _, node := range net.nodes[1:] {
+			hash, err := node.chain.BlockHashByHeight(height)
+			if err != nil || *hash != *block.Hash() {
+				t.Errorf("the nodes have different blocks at height %d", height)
+			}
+		}
+
+		forged := *block.MsgBlock()
+		forged.Header.CoinBase = common.Address{0x66, 0xff}
+		for _, validator := range h.Validators {
+			if validator != header.CoinBase {
+				forged.Header.CoinBase = validator
+				break
+			}
+		}
+		_, _, err = observer.chain.ProcessBlock(asiutil.NewBlock(&forged), nil, nil, nil,
+			common.BFNone)
+		if _, ok := err.(blockchain.RuleError); !ok {
+			t.Errorf("block %d of another validator got err %v, want a rule "+
+				"error", height, err)
+		}
+
+		_, isOrphan, err := observer.chain.ProcessBlock(asiutil.NewBlock(block.MsgBlock()),
+			nil, nil, nil, common.BFNone)
+		if err != nil || isOrphan {
+			t.Fatalf("block %d is rejected, orphan %v err %v", height,
+				isOrphan, err)
+		}
+	}
+}
+
+// produced returns whether all the nodes reached the height.
+func (net *simNetwork) produced(height int32) bool {
+	for _, node := range net.nodes {
+		if node.chain.BestSnapshot().Height < height {
+			return false
+		}
+	}
+	return true
+}
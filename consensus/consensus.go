@@ -6,8 +6,12 @@ package consensus
 
 import (
 	"errors"
+	"fmt"
+	"sort"
+
 	"github.com/AsimovNetwork/asimov/ainterface"
 	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/consensus/bft"
 	"github.com/AsimovNetwork/asimov/consensus/params"
 	"github.com/AsimovNetwork/asimov/consensus/poa"
 	"github.com/AsimovNetwork/asimov/consensus/satoshiplus"
@@ -15,30 +19,118 @@ import (
 	"github.com/AsimovNetwork/asimov/crypto"
 )
 
+// Engine defines a structure for consensus engines to use when they registered
+// themselves as an engine which can be selected with --consensustype.
+type Engine struct {
+	// Name is the identifier used to uniquely identify a specific engine.
+	// There can be only one engine with the same name.
+	Name string
+
+	// NewRoundManager is the function that will be invoked to create the
+	// round manager of the engine, which decides the validators of each
+	// round.  The account is the one of the node, which may be nil.
+	NewRoundManager func(acc *crypto.Account) ainterface.IRoundManager
+
+	// NewService is the function that will be invoked to create the
+	// service of the engine, which produces the blocks of the node at its
+	// slots.
+	NewService func(cfg *params.Config) (ainterface.Consensus, error)
+}
+
+// engines holds all of the registered consensus engines.
+var engines = make(map[string]*Engine)
+
+// RegisterEngine adds a consensus engine to the available ones.  An error is
+// returned if an engine with the same name has already been registered.
+func RegisterEngine(engine *Engine) error {
+	if _, exists := engines[engine.Name]; exists {
+		return fmt.Errorf("consensus %q is already registered", engine.Name)
+	}
+
+	engines[engine.Name] = engine
+	return nil
+}
+
+// SupportedEngines returns the names of the consensus engines which have been
+// registered and are therefore supported, in alphabetical order.
+func SupportedEngines() []string {
+	names := make([]string, 0, len(engines))
+	for name := range engines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LookupEngine returns the consensus engine registered with the given name,
+// or nil when there is none.
+func LookupEngine(consensusName string) *Engine {
+	return engines[consensusName]
+}
+
 // Create a new consensus service via pass config match consensus name
 func NewConsensusService(consensusName string, cfg *params.Config) (ainterface.Consensus, error) {
-	consensusType := common.GetConsensus(consensusName)
-	switch consensusType {
-	case common.SOLO:
-		return solo.NewSoloService(cfg)
-	case common.SATOSHIPLUS:
-		return satoshiplus.NewSatoshiPlusService(cfg)
-	case common.POA:
-		return poa.NewService(cfg)
+	engine, exists := engines[consensusName]
+	if !exists {
+		return nil, errors.New("unknown consensus " + consensusName)
 	}
-	return nil, errors.New("unknown consensus " + consensusName)
+	return engine.NewService(cfg)
 }
 
 // Create a new round manager under consensus name
 func NewRoundManager(consensusName string, acc *crypto.Account) ainterface.IRoundManager {
-	consensusType := common.GetConsensus(consensusName)
-	switch consensusType {
-	case common.SOLO:
-		return solo.NewRoundManager([]*common.Address{acc.Address})
-	case common.SATOSHIPLUS:
-		return satoshiplus.NewRoundManager()
-	case common.POA:
-		return poa.NewRoundManager()
+	engine, exists := engines[consensusName]
+	if !exists {
+		return nil
+	}
+	return engine.NewRoundManager(acc)
+}
+
+func init() {
+	// Register the built in engines.
+	builtins := []*Engine{
+		{
+			Name: common.GetConsensusName(common.SOLO),
+			NewRoundManager: func(acc *crypto.Account) ainterface.IRoundManager {
+				return solo.NewRoundManager([]*common.Address{acc.Address})
+			},
+			NewService: func(cfg *params.Config) (ainterface.Consensus, error) {
+				return solo.NewSoloService(cfg)
+			},
+		},
+		{
+			Name: common.GetConsensusName(common.POA),
+			NewRoundManager: func(*crypto.Account) ainterface.IRoundManager {
+				return poa.NewRoundManager()
+			},
+			NewService: func(cfg *params.Config) (ainterface.Consensus, error) {
+				return poa.NewService(cfg)
+			},
+		},
+		{
+			Name: common.GetConsensusName(common.SATOSHIPLUS),
+			NewRoundManager: func(*crypto.Account) ainterface.IRoundManager {
+				return satoshiplus.NewRoundManager()
+			},
+			NewService: func(cfg *params.Config) (ainterface.Consensus, error) {
+				return satoshiplus.NewSatoshiPlusService(cfg)
+			},
+		},
+		{
+			Name: common.GetConsensusName(common.BFT),
+			NewRoundManager: func(*crypto.Account) ainterface.IRoundManager {
+				// The blocks of bft follow the chain rules of poa.
+				return poa.NewRoundManager()
+			},
+			NewService: func(cfg *params.Config) (ainterface.Consensus, error) {
+				return bft.NewService(cfg)
+			},
+		},
+	}
+	for _, engine := range builtins {
+		if err := RegisterEngine(engine); err != nil {
+			panic(fmt.Sprintf("Failed to register consensus '%s': %v",
+				engine.Name, err))
+		}
 	}
-	return nil
 }
@@ -10,6 +10,7 @@ import (
 	"github.com/AsimovNetwork/asimov/common"
 	"github.com/AsimovNetwork/asimov/crypto"
 	"github.com/AsimovNetwork/asimov/mining"
+	"github.com/AsimovNetwork/asimov/protos"
 )
 
 type Config struct {
@@ -41,4 +42,20 @@ type Config struct {
 
 	// Account provide a private key to sign a new produced block.
 	Account *crypto.Account
+
+	// Keys holds the accounts of the validator, the Account and the ones
+	// it rotates its key to.  The Account alone is used when it is nil.
+	Keys *crypto.KeyRing
+
+	// Broadcast sends a message of the consensus to all the peers.
+	Broadcast func(protos.Message)
+}
+
+// KeyRing returns the accounts the validator signs with.
+func (c *Config) KeyRing() *crypto.KeyRing {
+	if c.Keys != nil {
+		return c.Keys
+	}
+	keys, _ := crypto.NewKeyRing(c.Account, "")
+	return keys
 }
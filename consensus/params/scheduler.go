@@ -0,0 +1,104 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package params
+
+import (
+	"time"
+)
+
+// Scheduler tells the round and the slot of a time for the consensus engines
+// whose rounds all have the same number of slots of the same interval, and
+// drives a timer which fires at the start of the slots.
+//
+// The genesis block is at the last slot of round 0, which starts at the chain
+// start time, and round 1 starts at the next slot.
+type Scheduler struct {
+	chainStartTime int64
+	blockInterval  int64
+	roundSize      int64
+	timer          *time.Timer
+}
+
+// NewScheduler returns a scheduler of rounds of roundSize slots, each lasting
+// blockInterval seconds, from the chain start time.
+func NewScheduler(chainStartTime, blockInterval int64, roundSize uint16) *Scheduler {
+	return &Scheduler{
+		chainStartTime: chainStartTime,
+		blockInterval:  blockInterval,
+		roundSize:      int64(roundSize),
+	}
+}
+
+// RoundInterval returns the interval of a round, in seconds.
+func (s *Scheduler) RoundInterval() int64 {
+	return s.blockInterval * s.roundSize
+}
+
+// SlotAt returns the round and the slot at the given unix time.  The times
+// before round 1 are at the last slot of round 0.
+func (s *Scheduler) SlotAt(t int64) (int64, int64) {
+	slotCount := (t - s.chainStartTime) / s.blockInterval
+	if t < s.chainStartTime || slotCount < 1 {
+		return 0, s.roundSize - 1
+	}
+	return 1 + (slotCount-1)/s.roundSize, (slotCount - 1) % s.roundSize
+}
+
+// SlotStartTime returns the unix time the slot of the round starts at.
+func (s *Scheduler) SlotStartTime(round, slot int64) int64 {
+	return s.chainStartTime + s.blockInterval*(1+(round-1)*s.roundSize+slot)
+}
+
+// Context returns the consensus context of the slot at the given unix time.
+func (s *Scheduler) Context(t int64) Context {
+	round, slot := s.SlotAt(t)
+	return Context{
+		RoundInterval:  s.RoundInterval(),
+		Round:          round,
+		Slot:           slot,
+		RoundStartTime: s.SlotStartTime(round, 0),
+		RoundSize:      s.roundSize,
+	}
+}
+
+// Start creates the timer, which fires at the start of the next slot.
+func (s *Scheduler) Start() {
+	s.timer = time.NewTimer(time.Hour)
+	s.Next()
+}
+
+// C returns the channel of the timer.
+func (s *Scheduler) C() <-chan time.Time {
+	return s.timer.C
+}
+
+// Next resets the timer to fire at the start of the slot following the
+// current one, and returns the duration until then.
+func (s *Scheduler) Next() time.Duration {
+	now := time.Now()
+	round, slot := s.SlotAt(now.Unix())
+	start := time.Unix(s.SlotStartTime(round, slot+1), 0)
+	offset := start.Add(time.Millisecond).Sub(now)
+	s.timer.Reset(offset)
+	return offset
+}
+
+// Retry resets the timer to fire after the given duration, in the middle of
+// a slot.
+func (s *Scheduler) Retry(d time.Duration) {
+	s.timer.Reset(d)
+}
+
+// Stop stops the timer and drains its channel.
+func (s *Scheduler) Stop() {
+	s.timer.Stop()
+	for {
+		select {
+		case <-s.timer.C:
+		default:
+			return
+		}
+	}
+}
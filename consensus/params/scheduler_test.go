@@ -0,0 +1,56 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package params
+
+import (
+	"testing"
+)
+
+// TestScheduler ensures the rounds and the slots follow the genesis block,
+// which is at the last slot of round 0.
+func TestScheduler(t *testing.T) {
+	const chainStartTime = 1000
+	scheduler := NewScheduler(chainStartTime, 5, 4)
+	if scheduler.RoundInterval() != 20 {
+		t.Errorf("RoundInterval = %d, want 20", scheduler.RoundInterval())
+	}
+
+	tests := []struct {
+		time  int64
+		round int64
+		slot  int64
+	}{
+		{0, 0, 3},
+		{chainStartTime, 0, 3},
+		{chainStartTime + 4, 0, 3},
+		{chainStartTime + 5, 1, 0},
+		{chainStartTime + 9, 1, 0},
+		{chainStartTime + 10, 1, 1},
+		{chainStartTime + 24, 1, 3},
+		{chainStartTime + 25, 2, 0},
+		{chainStartTime + 64, 3, 3},
+	}
+	for _, test := range tests {
+		round, slot := scheduler.SlotAt(test.time)
+		if round != test.round || slot != test.slot {
+			t.Errorf("SlotAt(%d) = round %d slot %d, want round %d slot %d",
+				test.time, round, slot, test.round, test.slot)
+		}
+		if test.time < chainStartTime {
+			continue
+		}
+		start := scheduler.SlotStartTime(round, slot)
+		if start > test.time || start+5 <= test.time {
+			t.Errorf("SlotStartTime(%d, %d) = %d, want the slot of %d",
+				round, slot, start, test.time)
+		}
+		context := scheduler.Context(test.time)
+		if context.Round != round || context.Slot != slot ||
+			context.RoundStartTime != scheduler.SlotStartTime(round, 0) ||
+			context.RoundInterval != 20 || context.RoundSize != 4 {
+			t.Errorf("Context(%d) = %+v", test.time, context)
+		}
+	}
+}
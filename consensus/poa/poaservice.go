@@ -12,6 +12,7 @@ import (
 	"github.com/AsimovNetwork/asimov/chaincfg"
 	"github.com/AsimovNetwork/asimov/common"
 	"github.com/AsimovNetwork/asimov/consensus/params"
+	"github.com/AsimovNetwork/asimov/crypto"
 	"sync"
 	"time"
 )
@@ -23,12 +24,13 @@ import (
 type Service struct {
 	sync.Mutex
 	wg      sync.WaitGroup
-	existCh chan interface{}
-	context params.Context
-	timer   *time.Timer
+	existCh   chan interface{}
+	context   params.Context
+	scheduler *params.Scheduler
 
 	started bool
 	config  *params.Config
+	keys    *crypto.KeyRing
 }
 
 /*
@@ -43,6 +45,7 @@ func NewService(config *params.Config) (*Service, error) {
 	}
 	service := &Service{
 		config: config,
+		keys:   config.KeyRing(),
 	}
 	return service, nil
 }
@@ -56,20 +59,16 @@ func (s *Service) Start() error {
 	if s.existCh != nil {
 		return errors.New("region consensus is already started")
 	}
-	if s.config.Account == nil {
+	if s.config.Account == nil && len(s.keys.Accounts()) == 0 {
 		log.Warn("region service exit when account is nil")
 		return nil
 	}
 	log.Info("Region consensus start")
 
 	// current block maybe do not at the best block height:
-	s.timer = time.NewTimer(common.DefaultBlockInterval * 1000000 * time.Second)
-	if err := s.initializeConsensus(); err != nil {
-		log.Errorf("Start poa service failed:", err)
-		s.timer.Stop()
-		s.timer = nil
-		return err
-	}
+	s.scheduler = params.NewScheduler(chaincfg.ActiveNetParams.ChainStartTime,
+		common.DefaultBlockInterval, chaincfg.ActiveNetParams.RoundSize)
+	s.initializeConsensus()
 	s.started = true
 
 	s.existCh = make(chan interface{})
@@ -79,21 +78,13 @@ func (s *Service) Start() error {
 	mainloop:
 		for {
 			select {
-			case <-s.timer.C:
+			case <-s.scheduler.C():
 				s.genBlock()
 			case <-existCh:
 				break mainloop
 			}
 		}
-		s.timer.Stop()
-	cleanup:
-		for {
-			select {
-			case <-s.timer.C:
-			default:
-				break cleanup
-			}
-		}
+		s.scheduler.Stop()
 		s.wg.Done()
 	}()
 
@@ -142,74 +133,67 @@ func (s *Service) getValidators(round uint32, verbose bool) ([]*common.Address,
 /*
  * Initialize Consensus
  */
-func (s *Service) initializeConsensus() error {
-	s.context.RoundInterval = common.DefaultBlockInterval * int64(chaincfg.ActiveNetParams.RoundSize)
-	chainStartTime := int64(chaincfg.ActiveNetParams.ChainStartTime)
-
-	d := time.Now().Unix() - chainStartTime
-	roundSizei64 := int64(chaincfg.ActiveNetParams.RoundSize)
-	if d < common.DefaultBlockInterval {
-		s.context.Round = 0
-		s.context.Slot = roundSizei64 - 1
-	} else {
-		slotCount := d * roundSizei64 / s.context.RoundInterval
-		s.context.Round = 1 + slotCount/roundSizei64
-		s.context.Slot = slotCount % roundSizei64
-	}
-	s.context.RoundStartTime = chainStartTime + common.DefaultBlockInterval + s.context.RoundInterval*int64(s.context.Round-1)
-
-	s.resetTimer(false)
+func (s *Service) initializeConsensus() {
+	s.context = s.scheduler.Context(time.Now().Unix())
+	s.scheduler.Start()
 
 	log.Infof("POA initializeConsensus round: %v, slot: %v, roundStartTime: %v", s.context.Round, s.context.Slot, s.context.RoundStartTime)
-	return nil
 }
 
-//sync control of local slot:
-func (s *Service) slotControl() (int64, int64, bool) {
+//sync control of local slot, returns the account of the validator of the slot
+//when it is one of the node.
+func (s *Service) slotControl() (int64, int64, *crypto.Account) {
 	config := s.config
 	best := config.Chain.BestSnapshot()
 	if config.IsCurrent() != true && best.Height > 0 {
 		log.Infof("downloading blocks: wait!!!!!!!!!!!")
-		return 0, 0, false
+		return 0, 0, nil
 	}
 
-	slot := s.context.Slot + 1
-	round := s.context.Round
-	roundSizei64 := int64(chaincfg.ActiveNetParams.RoundSize)
-	if slot == roundSizei64 {
-		s.context.RoundStartTime = s.context.RoundStartTime + s.context.RoundInterval
-		slot = 0
-		round = round + 1
+	context := s.scheduler.Context(time.Now().Unix())
+	round, slot := context.Round, context.Slot
+	if round == 0 {
+		return 0, 0, nil
+	}
+
+	// pick up the keys the validator rotates to before its slot.
+	if reloaded, err := s.keys.Reload(); err != nil {
+		log.Warnf("[slotControl] failed to reload the validator keys: %v", err)
+	} else if reloaded {
+		log.Infof("[slotControl] reloaded %d validator keys", len(s.keys.Accounts()))
 	}
 
 	verbose := round != s.context.Round
 	validators, _, err := s.getValidators(uint32(round), verbose)
 	if err != nil {
 		log.Errorf("[slotControl] %v", err.Error())
-		return 0, 0, false
+		return 0, 0, nil
 	}
 
-	isTurn := *validators[slot] == *s.config.Account.Address
+	account := s.keys.Lookup(*validators[slot])
 	log.Infof("[slotControl] slot change slot=%d, round=%d, height=%d, isTurn=%v, interval=%v",
-		slot, round, best.Height+1, isTurn,
+		slot, round, best.Height+1, account != nil,
 		float64(s.context.RoundInterval)/float64(chaincfg.ActiveNetParams.RoundSize))
-	s.context.Slot = slot
-	s.context.Round = round
-	return round, slot, isTurn
+	s.context = context
+	return round, slot, account
 }
 
 // validators create block.
 func (s *Service) genBlock() {
-	round, slot, isTurn := s.slotControl()
-	s.resetTimer(round == 0)
-	if !isTurn {
+	round, slot, account := s.slotControl()
+	if round == 0 {
+		s.scheduler.Retry(time.Second)
+	} else {
+		s.scheduler.Next()
+	}
+	if account == nil {
 		return
 	}
 	blockInterval := float64(s.GetRoundInterval()) / float64(chaincfg.ActiveNetParams.RoundSize) * 1000
 	log.Infof("try to gen block at round=%d, slot=%d", round, slot)
 
 	template, err := s.config.BlockTemplateGenerator.ProduceNewBlock(
-		s.config.Account, s.config.GasFloor, s.config.GasCeil,
+		account, s.config.GasFloor, s.config.GasCeil,
 		time.Now().Unix(), uint32(round), uint16(slot), blockInterval)
 	if err != nil {
 		log.Errorf("Consensus POA Failed to gen a block: %v", err)
@@ -236,13 +220,3 @@ func (s *Service) genBlock() {
 func (s *Service) GetRoundInterval() int64 {
 	return s.context.RoundInterval
 }
-
-func (s *Service) resetTimer(isErr bool) {
-	if isErr {
-		s.timer.Reset(time.Second)
-		return
-	}
-	d := time.Duration(int64(s.context.Slot+1)*s.context.RoundInterval) * time.Second / time.Duration(chaincfg.ActiveNetParams.RoundSize)
-	offset := time.Unix(s.context.RoundStartTime, 0).Add(d + time.Millisecond).Sub(time.Now())
-	s.timer.Reset(offset)
-}
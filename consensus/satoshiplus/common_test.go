@@ -283,7 +283,7 @@ func createPoaConfig(privateKey string, paramstmp *chaincfg.Params) (*params.Con
 		CheckTransactionInputs: blockchain.CheckTransactionInputs,
 	}
 	txMemPool := mempool.New(&txC)
-	sigMemPool := mempool.NewSigPool()
+	sigMemPool := mempool.NewSigPool(nil)
 
 	syncManager, err := netsync.New(&netsync.Config{
 		PeerNotifier:       nil,
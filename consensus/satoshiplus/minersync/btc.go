@@ -33,6 +33,12 @@ var (
 	validatorSerializedBytes = []byte{1}
 )
 
+// SnapshotBuckets returns the names of the database buckets housing the
+// bitcoin miner information, which are part of the chain state snapshots.
+func SnapshotBuckets() [][]byte {
+	return [][]byte{bitcoinMinerBucketName, btcaddr2nameBuckerName, validatorBucketName}
+}
+
 // ValidatorTx is a candidate for mapped transactions.
 type ValidatorTx struct {
 	TxId       string
@@ -20,10 +20,10 @@ import (
 type SoloService struct {
 	sync.Mutex
 	wg      sync.WaitGroup
-	existCh chan interface{}
-	context params.Context
-	timer   *time.Timer
-	config  *params.Config
+	existCh   chan interface{}
+	context   params.Context
+	scheduler *params.Scheduler
+	config    *params.Config
 }
 
 /*
@@ -48,17 +48,17 @@ func (s *SoloService) Start() error {
 		return nil
 	}
 
-	// temp timer, need reset
-	s.timer = time.NewTimer(common.DefaultBlockInterval * 10000)
+	s.scheduler = params.NewScheduler(chaincfg.ActiveNetParams.ChainStartTime,
+		common.DefaultBlockInterval, chaincfg.ActiveNetParams.RoundSize)
 	s.initializeConsensus()
 	s.existCh = make(chan interface{})
 	s.wg.Add(1)
 	go func() {
-		defer s.timer.Stop()
+		defer s.scheduler.Stop()
 		existCh := s.existCh
 		for {
 			select {
-			case <-s.timer.C:
+			case <-s.scheduler.C():
 				s.genBlock()
 			case <-existCh:
 				s.wg.Done()
@@ -82,7 +82,7 @@ func (s *SoloService) Halt() error {
 
 func (s *SoloService) genBlock() {
 	round, slot := s.slotControl()
-	s.resetTimer()
+	s.scheduler.Next()
 	blockInterval := float64(s.GetRoundInterval()) / float64(chaincfg.ActiveNetParams.RoundSize) * 1000
 
 	// Create a new block using the available transactions
@@ -118,19 +118,7 @@ func (s *SoloService) GetRoundInterval() int64 {
 
 //sync control of local slot:
 func (s *SoloService) slotControl() (int64, int64) {
-
-	duration := time.Now().Unix() - s.context.RoundStartTime
-	roundsizei64 := int64(chaincfg.ActiveNetParams.RoundSize)
-	slotCount := duration * roundsizei64 / s.context.RoundInterval
-
-	if slotCount < roundsizei64 {
-		s.context.Slot = slotCount
-	} else {
-		roundCount := slotCount / roundsizei64
-		s.context.Round += roundCount
-		s.context.Slot = slotCount % roundsizei64
-		s.context.RoundStartTime = s.context.RoundStartTime + s.context.RoundInterval * roundCount
-	}
+	s.context = s.scheduler.Context(time.Now().Unix())
 	best := s.config.Chain.BestSnapshot()
 	if s.config.IsCurrent() != true {
 		log.Infof("waiting blocks")
@@ -142,31 +130,8 @@ func (s *SoloService) slotControl() (int64, int64) {
 }
 
 func (s *SoloService) initializeConsensus() {
-
-	s.context.RoundInterval = common.DefaultBlockInterval * int64(chaincfg.ActiveNetParams.RoundSize)
-	chainStartTime := chaincfg.ActiveNetParams.ChainStartTime
-
-	d := time.Now().Unix() - chainStartTime
-	roundSizei64 := int64(chaincfg.ActiveNetParams.RoundSize)
-	if d < common.DefaultBlockInterval {
-		s.context.Round = 0
-		s.context.Slot = roundSizei64 - 1
-		s.context.RoundStartTime = chainStartTime - (roundSizei64 - 1) * common.DefaultBlockInterval
-	} else {
-		// blockinterval = RoundInterval / RoundSize
-		// slotCount = d / blockinterval
-		slotCount := d * roundSizei64 / s.context.RoundInterval
-		s.context.Round = 1 + slotCount / roundSizei64
-		s.context.Slot = slotCount % roundSizei64
-		s.context.RoundStartTime = chainStartTime + common.DefaultBlockInterval + s.context.RoundInterval * (s.context.Round-1)
-	}
+	s.context = s.scheduler.Context(time.Now().Unix())
 	log.Infof("Solo initializeConsensus round: %v, slot: %v, roundStartTime: %v",
 		s.context.Round, s.context.Slot, s.context.RoundStartTime)
-	s.resetTimer()
-}
-
-func (s *SoloService) resetTimer() {
-	d := time.Duration(int64(s.context.Slot+1) * s.context.RoundInterval) * time.Second / time.Duration(chaincfg.ActiveNetParams.RoundSize)
-	offset := time.Unix(s.context.RoundStartTime, 0).Add(d + time.Millisecond).Sub(time.Now())
-	s.timer.Reset(offset)
+	s.scheduler.Start()
 }
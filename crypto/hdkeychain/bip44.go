@@ -0,0 +1,47 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package hdkeychain
+
+const (
+	// BIP44Purpose is the purpose field of a BIP44 path.
+	BIP44Purpose = 44
+
+	// ExternalBranch is the branch of the addresses given out to receive
+	// funds.
+	ExternalBranch = 0
+
+	// InternalBranch is the branch of the change addresses.
+	InternalBranch = 1
+)
+
+// DeriveAccount derives the extended key of a BIP44 account from the master
+// node:
+//
+//	m / purpose' / coin_type' / account'
+func DeriveAccount(master *ExtendedKey, coinType, account uint32) (*ExtendedKey, error) {
+	purpose, err := master.Child(HardenedKeyStart + BIP44Purpose)
+	if err != nil {
+		return nil, err
+	}
+	coin, err := purpose.Child(HardenedKeyStart + coinType)
+	if err != nil {
+		return nil, err
+	}
+	return coin.Child(HardenedKeyStart + account)
+}
+
+// DeriveAddressKey derives the extended key of an address of a BIP44 account:
+//
+//	m / purpose' / coin_type' / account' / branch / index
+//
+// The account key may be a public extended key, in which case the returned
+// key is a public extended key as well.
+func DeriveAddressKey(account *ExtendedKey, branch, index uint32) (*ExtendedKey, error) {
+	branchKey, err := account.Child(branch)
+	if err != nil {
+		return nil, err
+	}
+	return branchKey.Child(index)
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Copyright (c) 2014-2016 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package hdkeychain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// TestBIP0032Vectors tests the master node and the first derivations of the
+// BIP0032 test vector 1.
+func TestBIP0032Vectors(t *testing.T) {
+	seed, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	master, err := NewMaster(seed)
+	if err != nil {
+		t.Fatalf("NewMaster: unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		path      []uint32
+		chainCode string
+		privKey   string
+		pubKey    string
+	}{
+		{
+			name:      "m",
+			chainCode: "873dff81c02f525623fd1fe5167eac3a55a049de3d314bb42ee227ffed37d508",
+			privKey:   "e8f32e723decf4051aefac8e2c93c9c5b214313817cdb01a1494b917c8436b35",
+			pubKey:    "0339a36013301597daef41fbe593a02cc513d0b55527ec2df1050e2e8ff49c85c2",
+		},
+		{
+			name:      "m/0H",
+			path:      []uint32{HardenedKeyStart},
+			chainCode: "47fdacbd0f1097043b78c63c20c34ef4ed9a111d980047ad16282c7ae6236141",
+			privKey:   "edb2e14f9ee77d26dd93b4ecede8d16ed408ce149b6cd80b0715a2d911a0afea",
+			pubKey:    "035a784662a4a20a65bf6aab9ae98a6c068a81c52e4b032c0fb5400c706cfccc56",
+		},
+		{
+			name:      "m/0H/1",
+			path:      []uint32{HardenedKeyStart, 1},
+			chainCode: "2a7857631386ba23dacac34180dd1983734e444fdbf774041578e9b6adb37c19",
+			privKey:   "3c6cb8d0f6a264c91ea8b5030fadaa8e538b020f0a387421a12de9319dc93368",
+			pubKey:    "03501e454bf00751f24b1b489aa925215d66af2234e3891c3b21a52bedb3cd711c",
+		},
+	}
+
+	for _, test := range tests {
+		key := master
+		for _, i := range test.path {
+			key, err = key.Child(i)
+			if err != nil {
+				t.Fatalf("%s: Child: unexpected error: %v", test.name, err)
+			}
+		}
+		if got := hex.EncodeToString(key.ChainCode()); got != test.chainCode {
+			t.Errorf("%s: chain code mismatch: got %s, want %s", test.name,
+				got, test.chainCode)
+		}
+		privKey, err := key.ECPrivKey()
+		if err != nil {
+			t.Fatalf("%s: ECPrivKey: unexpected error: %v", test.name, err)
+		}
+		if got := hex.EncodeToString(privKey.D.Bytes()); got != test.privKey {
+			t.Errorf("%s: private key mismatch: got %s, want %s", test.name,
+				got, test.privKey)
+		}
+		if got := hex.EncodeToString(key.pubKeyBytes()); got != test.pubKey {
+			t.Errorf("%s: public key mismatch: got %s, want %s", test.name,
+				got, test.pubKey)
+		}
+	}
+}
+
+// TestPublicDerivation ensures that deriving a normal child from a neutered
+// key gives the same public key as deriving it from the private key.
+func TestPublicDerivation(t *testing.T) {
+	seed := bytes.Repeat([]byte{0x42}, RecommendedSeedLen)
+	master, err := NewMaster(seed)
+	if err != nil {
+		t.Fatalf("NewMaster: unexpected error: %v", err)
+	}
+	account, err := DeriveAccount(master, 0, 0)
+	if err != nil {
+		t.Fatalf("DeriveAccount: unexpected error: %v", err)
+	}
+
+	for i := uint32(0); i < 5; i++ {
+		priv, err := DeriveAddressKey(account, ExternalBranch, i)
+		if err != nil {
+			t.Fatalf("DeriveAddressKey: unexpected error: %v", err)
+		}
+		pub, err := DeriveAddressKey(account.Neuter(), ExternalBranch, i)
+		if err != nil {
+			t.Fatalf("DeriveAddressKey: unexpected error: %v", err)
+		}
+		if pub.IsPrivate() {
+			t.Fatalf("child of a public key must be public")
+		}
+		if !bytes.Equal(priv.pubKeyBytes(), pub.pubKeyBytes()) {
+			t.Errorf("index %d: public keys differ", i)
+		}
+		privAddr, _ := priv.Address()
+		pubAddr, _ := pub.Address()
+		if *privAddr != *pubAddr {
+			t.Errorf("index %d: addresses differ", i)
+		}
+	}
+
+	if _, err := account.Neuter().Child(HardenedKeyStart); err != ErrDeriveHardFromPublic {
+		t.Errorf("got error %v, want %v", err, ErrDeriveHardFromPublic)
+	}
+	if _, err := account.Neuter().ECPrivKey(); err != ErrNotPrivExtKey {
+		t.Errorf("got error %v, want %v", err, ErrNotPrivExtKey)
+	}
+	if _, err := NewMaster(seed[:MinSeedBytes-1]); err != ErrInvalidSeedLen {
+		t.Errorf("got error %v, want %v", err, ErrInvalidSeedLen)
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package crypto
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AsimovNetwork/asimov/common"
+)
+
+// KeyRing holds the accounts a validator signs with: the account of
+// --privatekey and the ones of a key file, which holds a private key in hex
+// per line.  The key file is reloaded when it changes, so a validator adds the
+// key it rotates to without restarting the node, and signs with it as soon as
+// the key becomes active.
+//
+// KeyRing is safe for concurrent access.
+type KeyRing struct {
+	mtx      sync.RWMutex
+	primary  *Account
+	path     string
+	modTime  time.Time
+	size     int64
+	accounts []*Account
+}
+
+// NewKeyRing returns a key ring holding the primary account, which may be nil,
+// and the accounts of the key file at path, unless path is empty.
+func NewKeyRing(primary *Account, path string) (*KeyRing, error) {
+	k := &KeyRing{
+		primary: primary,
+		path:    path,
+	}
+	if primary != nil {
+		k.accounts = []*Account{primary}
+	}
+	if path != "" {
+		if _, err := k.Reload(); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+// Reload reads the key file again when it changed since it was last read, and
+// returns whether it did.  The accounts are left unchanged when the file can
+// not be read or holds an invalid key.
+func (k *KeyRing) Reload() (bool, error) {
+	if k.path == "" {
+		return false, nil
+	}
+	info, err := os.Stat(k.path)
+	if err != nil {
+		return false, err
+	}
+
+	k.mtx.RLock()
+	unchanged := info.ModTime().Equal(k.modTime) && info.Size() == k.size
+	k.mtx.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	data, err := ioutil.ReadFile(k.path)
+	if err != nil {
+		return false, err
+	}
+	accounts := make([]*Account, 0)
+	known := make(map[common.Address]struct{})
+	if k.primary != nil {
+		accounts = append(accounts, k.primary)
+		known[*k.primary.Address] = struct{}{}
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		key := strings.TrimSpace(scanner.Text())
+		if key == "" || strings.HasPrefix(key, "#") {
+			continue
+		}
+		acc, err := NewAccount(key)
+		if err != nil {
+			return false, fmt.Errorf("invalid key at line %d of %s: %v",
+				line, k.path, err)
+		}
+		if _, exists := known[*acc.Address]; exists {
+			continue
+		}
+		known[*acc.Address] = struct{}{}
+		accounts = append(accounts, acc)
+	}
+	if err := scanner.Err(); err != nil {
+		return false, err
+	}
+
+	k.mtx.Lock()
+	k.accounts = accounts
+	k.modTime = info.ModTime()
+	k.size = info.Size()
+	k.mtx.Unlock()
+	return true, nil
+}
+
+// Accounts returns the accounts of the key ring, the primary one first.
+func (k *KeyRing) Accounts() []*Account {
+	k.mtx.RLock()
+	defer k.mtx.RUnlock()
+	accounts := make([]*Account, len(k.accounts))
+	copy(accounts, k.accounts)
+	return accounts
+}
+
+// Lookup returns the account of the key ring with the given address, or nil
+// when there is none.
+func (k *KeyRing) Lookup(address common.Address) *Account {
+	k.mtx.RLock()
+	defer k.mtx.RUnlock()
+	for _, acc := range k.accounts {
+		if *acc.Address == address {
+			return acc
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package crypto
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AsimovNetwork/asimov/common/hexutil"
+)
+
+// newTestKey returns a new private key in hex and its account.
+func newTestKey(t *testing.T) (string, *Account) {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey error %v", err)
+	}
+	hexKey := hexutil.Encode(FromECDSA(key))
+	acc, err := NewAccount(hexKey)
+	if err != nil {
+		t.Fatalf("NewAccount error %v", err)
+	}
+	return hexKey, acc
+}
+
+// TestKeyRing tests the key ring holds the primary account and the accounts
+// of the key file, which it reloads when the file changes.
+func TestKeyRing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keyring")
+	if err != nil {
+		t.Fatalf("TempDir error %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keys")
+
+	primaryKey, primary := newTestKey(t)
+	rotatedKey, rotated := newTestKey(t)
+	if err := ioutil.WriteFile(path, []byte("# validator keys\n"+primaryKey+"\n"), 0600); err != nil {
+		t.Fatalf("WriteFile error %v", err)
+	}
+
+	keys, err := NewKeyRing(primary, path)
+	if err != nil {
+		t.Fatalf("NewKeyRing error %v", err)
+	}
+	if accounts := keys.Accounts(); len(accounts) != 1 || accounts[0] != primary {
+		t.Fatalf("got %d accounts, want the primary one", len(accounts))
+	}
+	if reloaded, err := keys.Reload(); reloaded || err != nil {
+		t.Errorf("Reload of an unchanged file: %v, %v", reloaded, err)
+	}
+
+	// Add the key the validator rotates to.
+	data := []byte(primaryKey + "\n\n" + rotatedKey + "\n")
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("WriteFile error %v", err)
+	}
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatalf("Chtimes error %v", err)
+	}
+	if reloaded, err := keys.Reload(); !reloaded || err != nil {
+		t.Fatalf("Reload of a changed file: %v, %v", reloaded, err)
+	}
+	if acc := keys.Lookup(*rotated.Address); acc == nil || acc.PrivateKey.D.Cmp(rotated.PrivateKey.D) != 0 {
+		t.Errorf("rotated key is not loaded")
+	}
+	if keys.Lookup(*primary.Address) != primary || len(keys.Accounts()) != 2 {
+		t.Errorf("got accounts %v", keys.Accounts())
+	}
+
+	// An invalid key leaves the accounts unchanged.
+	if err := ioutil.WriteFile(path, []byte("0xzz\n"), 0600); err != nil {
+		t.Fatalf("WriteFile error %v", err)
+	}
+	future = future.Add(time.Minute)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatalf("Chtimes error %v", err)
+	}
+	if _, err := keys.Reload(); err == nil {
+		t.Errorf("Reload accepted an invalid key")
+	}
+	if keys.Lookup(*rotated.Address) == nil {
+		t.Errorf("invalid key file dropped the loaded keys")
+	}
+
+	// Without a key file, the key ring only holds the primary account.
+	keys, err = NewKeyRing(nil, "")
+	if err != nil || len(keys.Accounts()) != 0 {
+		t.Errorf("empty key ring: %v, %v", keys.Accounts(), err)
+	}
+}
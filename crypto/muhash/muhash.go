@@ -0,0 +1,149 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// Package muhash implements a rolling hash of a multiset of byte strings.
+//
+// Each element is mapped to a number of the multiplicative group of integers
+// modulo the prime 2^3072 - 1103717, and the multiset to the product of the
+// numbers of its elements.  Adding and removing elements are multiplications,
+// so the hash of a set is updated in constant time whichever order the
+// changes are applied in, and does not depend on how the set was built.
+//
+// Removals are accumulated in a separate denominator, which avoids computing a
+// modular inverse for every removed element.  The single inverse needed is
+// only computed when the hash is finalized.
+package muhash
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math/big"
+
+	"github.com/AsimovNetwork/asimov/common"
+)
+
+const (
+	// ElementSize is the size in bytes of the numbers of the group.
+	ElementSize = 384
+
+	// SerializedSize is the size in bytes of a serialized MuHash.
+	SerializedSize = 2 * ElementSize
+)
+
+// prime is the modulus of the group, 2^3072 - 1103717.
+var prime = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 3072),
+	big.NewInt(1103717))
+
+// MuHash is the rolling hash of a multiset.  The zero value is not usable, use
+// New to create one.
+type MuHash struct {
+	numerator   *big.Int
+	denominator *big.Int
+}
+
+// New returns the MuHash of the empty set.
+func New() *MuHash {
+	return &MuHash{
+		numerator:   big.NewInt(1),
+		denominator: big.NewInt(1),
+	}
+}
+
+// toElement maps the passed data to a number of the group.  The sha256 hash of
+// the data is expanded to ElementSize bytes by hashing it with a counter.
+func toElement(data []byte) *big.Int {
+	seed := sha256.Sum256(data)
+	var buf [ElementSize]byte
+	var block [sha256.Size + 4]byte
+	copy(block[:], seed[:])
+	for i := 0; i < ElementSize/sha256.Size; i++ {
+		binary.BigEndian.PutUint32(block[sha256.Size:], uint32(i))
+		sum := sha256.Sum256(block[:])
+		copy(buf[i*sha256.Size:], sum[:])
+	}
+	n := new(big.Int).SetBytes(buf[:])
+	n.Mod(n, prime)
+
+	// The probability of hitting zero is negligible, but it has no inverse.
+	if n.Sign() == 0 {
+		n.SetInt64(1)
+	}
+	return n
+}
+
+// Add adds the passed data to the set.
+func (h *MuHash) Add(data []byte) {
+	h.numerator.Mul(h.numerator, toElement(data))
+	h.numerator.Mod(h.numerator, prime)
+}
+
+// Remove removes the passed data from the set.  The data is expected to be in
+// the set, otherwise the hash no longer matches any set with non-negative
+// multiplicities.
+func (h *MuHash) Remove(data []byte) {
+	h.denominator.Mul(h.denominator, toElement(data))
+	h.denominator.Mod(h.denominator, prime)
+}
+
+// Combine adds the elements of the set hashed by other to the set.
+func (h *MuHash) Combine(other *MuHash) {
+	h.numerator.Mul(h.numerator, other.numerator)
+	h.numerator.Mod(h.numerator, prime)
+	h.denominator.Mul(h.denominator, other.denominator)
+	h.denominator.Mod(h.denominator, prime)
+}
+
+// Clone returns a copy of the hash.
+func (h *MuHash) Clone() *MuHash {
+	return &MuHash{
+		numerator:   new(big.Int).Set(h.numerator),
+		denominator: new(big.Int).Set(h.denominator),
+	}
+}
+
+// normalize folds the denominator into the numerator.
+func (h *MuHash) normalize() {
+	if h.denominator.Cmp(common.Big1) == 0 {
+		return
+	}
+	inverse := new(big.Int).ModInverse(h.denominator, prime)
+	h.numerator.Mul(h.numerator, inverse)
+	h.numerator.Mod(h.numerator, prime)
+	h.denominator.SetInt64(1)
+}
+
+// Finalize returns the sha256 hash of the number the set maps to.  Equal sets
+// have the same hash, whichever order their elements were added and removed.
+func (h *MuHash) Finalize() common.Hash {
+	h.normalize()
+	var buf [ElementSize]byte
+	h.numerator.FillBytes(buf[:])
+	return common.Hash(sha256.Sum256(buf[:]))
+}
+
+// Serialize returns the serialization of the hash, which keeps the removed
+// elements apart so no modular inverse is computed.
+func (h *MuHash) Serialize() []byte {
+	serialized := make([]byte, SerializedSize)
+	h.numerator.FillBytes(serialized[:ElementSize])
+	h.denominator.FillBytes(serialized[ElementSize:])
+	return serialized
+}
+
+// Deserialize returns the hash serialized with Serialize.
+func Deserialize(serialized []byte) (*MuHash, error) {
+	if len(serialized) != SerializedSize {
+		return nil, errors.New("invalid serialized muhash size")
+	}
+	h := &MuHash{
+		numerator:   new(big.Int).SetBytes(serialized[:ElementSize]),
+		denominator: new(big.Int).SetBytes(serialized[ElementSize:]),
+	}
+	if h.numerator.Sign() == 0 || h.numerator.Cmp(prime) >= 0 ||
+		h.denominator.Sign() == 0 || h.denominator.Cmp(prime) >= 0 {
+		return nil, errors.New("invalid serialized muhash")
+	}
+	return h, nil
+}
@@ -0,0 +1,92 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package muhash
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestMuHash ensures the hash of a set does not depend on the order its
+// elements were added and removed in, and survives a serialization round trip.
+func TestMuHash(t *testing.T) {
+	a, b, c := []byte("a"), []byte("b"), []byte("c")
+
+	empty := New().Finalize()
+
+	h1 := New()
+	h1.Add(a)
+	h1.Add(b)
+
+	h2 := New()
+	h2.Add(c)
+	h2.Add(b)
+	h2.Add(a)
+	h2.Remove(c)
+
+	if h1.Finalize() != h2.Finalize() {
+		t.Fatalf("hash of {a, b} depends on the order of the changes")
+	}
+	if h1.Finalize() == empty {
+		t.Fatalf("hash of {a, b} is the one of the empty set")
+	}
+
+	// Multiplicities matter.
+	h3 := h1.Clone()
+	h3.Add(a)
+	if h3.Finalize() == h1.Finalize() {
+		t.Fatalf("hash of {a, a, b} is the one of {a, b}")
+	}
+	if h1.Finalize() != h2.Finalize() {
+		t.Fatalf("Clone shares state with the original hash")
+	}
+
+	// Removing every element yields the empty set.
+	h2.Remove(a)
+	h2.Remove(b)
+	if h2.Finalize() != empty {
+		t.Fatalf("hash of the emptied set is not the one of the empty set")
+	}
+
+	// Combining the hashes of disjoint sets hashes their union.
+	h4 := New()
+	h4.Add(a)
+	h5 := New()
+	h5.Add(c)
+	h5.Add(b)
+	h5.Remove(c)
+	h4.Combine(h5)
+	if h4.Finalize() != h1.Finalize() {
+		t.Fatalf("combined hash of {a} and {b} is not the one of {a, b}")
+	}
+
+	// The serialization keeps pending removals.
+	h6 := New()
+	h6.Add(a)
+	h6.Add(b)
+	h6.Add(c)
+	h6.Remove(c)
+	serialized := h6.Serialize()
+	if len(serialized) != SerializedSize {
+		t.Fatalf("serialized size %d, want %d", len(serialized), SerializedSize)
+	}
+	h7, err := Deserialize(serialized)
+	if err != nil {
+		t.Fatalf("Deserialize error %v", err)
+	}
+	if !bytes.Equal(h7.Serialize(), serialized) {
+		t.Fatalf("serialization round trip mismatch")
+	}
+	if h7.Finalize() != h1.Finalize() {
+		t.Fatalf("deserialized hash is not the one of {a, b}")
+	}
+
+	if _, err := Deserialize(serialized[1:]); err == nil {
+		t.Fatalf("Deserialize accepted a short serialization")
+	}
+	if _, err := Deserialize(make([]byte, SerializedSize)); err == nil {
+		t.Fatalf("Deserialize accepted a zero serialization")
+	}
+}
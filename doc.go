@@ -28,6 +28,35 @@ Application Options:
   -b, --datadir=            Directory to store data
       --logdir=             Directory to logger output.
       --statedir=           Directory to store state.
+      --gcmode=             Garbage collection mode of the contract state
+                            {full, archive} -- full only keeps the states of
+                            the recent blocks (archive)
+      --stateretention=     Number of recent block states kept in memory when
+                            the gcmode is full (128)
+      --exportsnapshot=     Writes a snapshot of the chain state to the given
+                            file on start up and then exits.
+      --snapshotheight=     Height of the main chain block whose state is
+                            written by exportsnapshot (0 selects the best
+                            block)
+      --importsnapshot=     Bootstraps an empty node from the chain state
+                            snapshot in the given file on start up
+      --snapshotutxohash=   Trusted utxo set hash of the snapshot block, as
+                            reported by gettxoutsetinfo, which the snapshot of
+                            importsnapshot must match
+      --statesync           Download the contract state of the latest
+                            checkpoint from the peers instead of executing the
+                            blocks preceding it
+      --spv                 Run as a light client which only downloads the
+                            headers, and the blocks of the watched addresses
+                            from the --connect or --addpeer peers
+      --spvaddress=         Add an address watched by the light client
+      --spvvalidator=       Add an address allowed to sign the headers followed
+                            by the light client, in the order of the
+                            consensus_poa contract which gives their slots --
+                            the genesis candidates are used when none is given
+      --spvvalidatorset=    Set the ordered validators of the light client from
+                            a round on.  Format:
+                            '<round>:<address>[,<address>...]'
   -a, --addpeer=            Add a peer to connect with at startup
       --connect=            Connect only to the specified peers at startup
       --nolisten            Disable listening for incoming connections -- NOTE:
@@ -53,6 +82,8 @@ Application Options:
       --rpcmaxclients=      Max number of RPC clients for standard connections
                             (10)
       --rpcmaxwebsockets=   Max number of RPC websocket connections (25)
+      --rpcmaxlogrange=     Max number of blocks a single log query may cover
+                            (10000)
       --norpc               Disable built-in RPC server -- NOTE: The RPC server
                             is disabled by default if no rpcuser/rpcpass or
                             rpclimituser/rpclimitpass is specified
@@ -91,6 +122,8 @@ Application Options:
       --upnp                Use UPnP to map our listening port outside of NAT
       --maxorphantx=        Max number of orphan transactions to keep in memory
                             (100)
+      --nopersistmempool    Do not save the mempool on shutdown and load it on
+                            start up
       --nopeerbloomfilters  Disable bloom filtering support.
       --nocfilters          Disable committed filtering (CF) support.
       --blocksonly          Do not accept transactions from remote peers.
@@ -64,6 +64,7 @@ var (
 	contLog  = backendLog.Logger("CONT")
 	nodeLog  = backendLog.Logger("NODE")
 	contractLog = backendLog.Logger("CNTR")
+	spvcLog  = backendLog.Logger("SPVC")
 )
 
 // Initialize package-global logger variables.
@@ -93,6 +94,7 @@ var subsystemLoggers = map[string]Logger{
 	"CONT":     contLog,
 	"NODE":     nodeLog,
 	"CNTR":     contractLog,
+	"SPVC":     spvcLog,
 }
 
 func GetLog() Logger {
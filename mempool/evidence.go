@@ -0,0 +1,152 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/protos"
+)
+
+// maxEvidence is the maximum number of evidences kept in the pool.  The
+// oldest ones are evicted to make room for the new ones.
+const maxEvidence = 1000
+
+// EvidenceConfig is a descriptor containing the evidence pool configuration.
+type EvidenceConfig struct {
+	// CheckEvidence defines the function to use to check an evidence
+	// before it is added to the pool.
+	CheckEvidence func(evidence *protos.MsgEvidence) error
+
+	// AnnounceEvidence defines the optional function to call with the
+	// evidences added to the pool, to relay them to the peers.
+	AnnounceEvidence func(evidence *protos.MsgEvidence)
+}
+
+// EvidenceDesc is a descriptor of an evidence in the pool.
+type EvidenceDesc struct {
+	Evidence *protos.MsgEvidence
+	Hash     common.Hash
+	Added    time.Time
+}
+
+// EvidencePool keeps the evidences of the validators which produced or
+// signed conflicting blocks, so that they can be relayed to the peers and
+// submitted to the validator committee.
+type EvidencePool struct {
+	cfg  EvidenceConfig
+	mtx  sync.RWMutex
+	pool map[common.Hash]*EvidenceDesc
+}
+
+// NewEvidencePool returns a new evidence pool.
+func NewEvidencePool(cfg *EvidenceConfig) *EvidencePool {
+	return &EvidencePool{
+		cfg:  *cfg,
+		pool: make(map[common.Hash]*EvidenceDesc),
+	}
+}
+
+// HaveEvidence returns whether the evidence is in the pool.
+//
+// This function is safe for concurrent access.
+func (ep *EvidencePool) HaveEvidence(hash *common.Hash) bool {
+	ep.mtx.RLock()
+	_, exists := ep.pool[*hash]
+	ep.mtx.RUnlock()
+	return exists
+}
+
+// FetchEvidence returns the evidence of the passed hash from the pool.
+//
+// This function is safe for concurrent access.
+func (ep *EvidencePool) FetchEvidence(hash *common.Hash) (*protos.MsgEvidence, error) {
+	ep.mtx.RLock()
+	desc, exists := ep.pool[*hash]
+	ep.mtx.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("evidence is not in the pool")
+	}
+	return desc.Evidence, nil
+}
+
+// EvidenceDescs returns the descriptors of the evidences in the pool, in the
+// order they were added.
+//
+// This function is safe for concurrent access.
+func (ep *EvidencePool) EvidenceDescs() []*EvidenceDesc {
+	ep.mtx.RLock()
+	descs := make([]*EvidenceDesc, 0, len(ep.pool))
+	for _, desc := range ep.pool {
+		descs = append(descs, desc)
+	}
+	ep.mtx.RUnlock()
+
+	sort.Slice(descs, func(i, j int) bool {
+		return descs[i].Added.Before(descs[j].Added)
+	})
+	return descs
+}
+
+// ProcessEvidence checks the passed evidence and adds it to the pool, then
+// announces it.  It returns whether the evidence was not already in the
+// pool.
+//
+// This function is safe for concurrent access.
+func (ep *EvidencePool) ProcessEvidence(evidence *protos.MsgEvidence) (bool, error) {
+	hash := evidence.Hash()
+	if ep.HaveEvidence(&hash) {
+		return false, nil
+	}
+	if ep.cfg.CheckEvidence != nil {
+		if err := ep.cfg.CheckEvidence(evidence); err != nil {
+			return false, err
+		}
+	}
+
+	ep.mtx.Lock()
+	if _, exists := ep.pool[hash]; exists {
+		ep.mtx.Unlock()
+		return false, nil
+	}
+	if len(ep.pool) >= maxEvidence {
+		var oldest *EvidenceDesc
+		for _, desc := range ep.pool {
+			if oldest == nil || desc.Added.Before(oldest.Added) {
+				oldest = desc
+			}
+		}
+		delete(ep.pool, oldest.Hash)
+	}
+	ep.pool[hash] = &EvidenceDesc{
+		Evidence: evidence,
+		Hash:     hash,
+		Added:    time.Now(),
+	}
+	ep.mtx.Unlock()
+
+	log.Warnf("Validator %v %s at height %d, evidence %v", evidence.Offender(),
+		evidenceSummary(evidence.Type), evidence.Height(), hash)
+
+	if ep.cfg.AnnounceEvidence != nil {
+		ep.cfg.AnnounceEvidence(evidence)
+	}
+	return true, nil
+}
+
+// evidenceSummary describes the misbehavior proven by an evidence type.
+func evidenceSummary(evidenceType protos.EvidenceType) string {
+	switch evidenceType {
+	case protos.EvidenceDoubleProposal:
+		return "produced two blocks for the same slot"
+	case protos.EvidenceDoubleSign:
+		return "signed two blocks"
+	}
+	return evidenceType.String()
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/protos"
+)
+
+// TestEvidencePool ensures the signature pool detects the signers which sign
+// two blocks at the same height, and the evidences are only added and
+// announced once.
+func TestEvidencePool(t *testing.T) {
+	var announced []*protos.MsgEvidence
+	var checkErr error
+	evidencePool := NewEvidencePool(&EvidenceConfig{
+		CheckEvidence: func(*protos.MsgEvidence) error { return checkErr },
+		AnnounceEvidence: func(evidence *protos.MsgEvidence) {
+			announced = append(announced, evidence)
+		},
+	})
+	sigPool := NewSigPool(evidencePool)
+
+	signer := common.Address{0x66, 0x01}
+	newSign := func(height int32, hash common.Hash, signer common.Address) *asiutil.BlockSign {
+		return asiutil.NewBlockSign(&protos.MsgBlockSign{
+			BlockHeight: height,
+			BlockHash:   hash,
+			Signer:      signer,
+		})
+	}
+
+	// Signatures of different heights, or of different signers at the same
+	// height, or the same signature again are not a misbehavior.
+	for _, sig := range []*asiutil.BlockSign{
+		newSign(5, common.Hash{0x01}, signer),
+		newSign(6, common.Hash{0x02}, signer),
+		newSign(5, common.Hash{0x03}, common.Address{0x66, 0x02}),
+		newSign(5, common.Hash{0x01}, signer),
+	} {
+		if err := sigPool.ProcessSig(sig); err != nil {
+			t.Fatalf("ProcessSig err %v", err)
+		}
+	}
+	if len(announced) != 0 || len(evidencePool.EvidenceDescs()) != 0 {
+		t.Fatalf("got %d evidences without misbehavior", len(announced))
+	}
+
+	// A rejected evidence is not added.
+	checkErr = errors.New("rejected")
+	if err := sigPool.ProcessSig(newSign(5, common.Hash{0x04}, signer)); err != nil {
+		t.Fatalf("ProcessSig err %v", err)
+	}
+	if len(announced) != 0 || len(evidencePool.EvidenceDescs()) != 0 {
+		t.Fatalf("got %d evidences rejected by the check", len(announced))
+	}
+
+	// A second block signed at the same height is detected once.
+	checkErr = nil
+	sig := newSign(5, common.Hash{0x05}, signer)
+	if err := sigPool.ProcessSig(sig); err != nil {
+		t.Fatalf("ProcessSig err %v", err)
+	}
+	if err := sigPool.ProcessSig(sig); err != nil {
+		t.Fatalf("ProcessSig err %v", err)
+	}
+	if len(announced) != 1 {
+		t.Fatalf("got %d evidences of a double sign, want 1", len(announced))
+	}
+	evidence := announced[0]
+	if evidence.Type != protos.EvidenceDoubleSign || evidence.Offender() != signer ||
+		evidence.Signs[0].BlockHash != (common.Hash{0x01}) ||
+		evidence.Signs[1].BlockHash != (common.Hash{0x05}) {
+		t.Errorf("got evidence %v", evidence)
+	}
+
+	hash := evidence.Hash()
+	if !evidencePool.HaveEvidence(&hash) {
+		t.Errorf("HaveEvidence of the detected evidence is false")
+	}
+	if got, err := evidencePool.FetchEvidence(&hash); err != nil || got != evidence {
+		t.Errorf("FetchEvidence got %v, err %v", got, err)
+	}
+	if isNew, err := evidencePool.ProcessEvidence(evidence); err != nil || isNew {
+		t.Errorf("ProcessEvidence of a known evidence got %v, err %v", isNew, err)
+	}
+	if len(announced) != 1 {
+		t.Errorf("a known evidence was announced again")
+	}
+}
@@ -8,9 +8,11 @@ package mempool
 import (
 	"container/list"
 	"fmt"
+	"os"
 	"github.com/AsimovNetwork/asimov/blockchain/txo"
 	"github.com/AsimovNetwork/asimov/common"
 	"github.com/AsimovNetwork/asimov/rpcs/rpcjson"
+	"github.com/AsimovNetwork/asimov/vm/fvm/event"
 	"sync"
 	"time"
 
@@ -41,6 +43,11 @@ const (
 	MaxReplacementEvictions = 100
 )
 
+// NewTxsEvent is posted when a batch of transactions enter the main pool.
+type NewTxsEvent struct {
+	Txs []*asiutil.Tx
+}
+
 // Tag represents an identifier to use for tagging orphan transactions.  The
 // caller may choose any scheme it desires, however it is common to use peer IDs
 // so that orphans can be identified by which peer first relayed them.
@@ -81,6 +88,11 @@ type Config struct {
 
 	CheckTransactionInputs func(tx *asiutil.Tx, txHeight int32, utxoView *txo.UtxoViewpoint,
 		b *blockchain.BlockChain) (int64, *map[protos.Asset]int64, error)
+
+	// PersistFile is the path of the file the pool is saved to when it is
+	// halted, and loaded from when it is started.  It is not persisted
+	// when empty.
+	PersistFile string
 }
 
 // Policy houses the policy (configuration parameters) which is used to
@@ -144,6 +156,17 @@ type TxPool struct {
 	nextExpireScan time.Time
 
 	fees map[protos.Asset]int32
+
+	txFeed event.Feed
+	scope  event.SubscriptionScope
+
+	// txEvents queues the NewTxsEvent which are not sent yet, in the order
+	// the transactions entered the pool.  A single goroutine sends them,
+	// while txEventsSending is set, so a slow subscriber does not hold up
+	// the pool.
+	txEventsMtx     sync.Mutex
+	txEvents        []NewTxsEvent
+	txEventsSending bool
 }
 
 // Ensure the TxPool type implements the mining.TxSource interface.
@@ -534,9 +557,49 @@ func (mp *TxPool) addTransaction(utxoView *txo.UtxoViewpoint, tx *asiutil.Tx, he
 		mp.cfg.AddrIndex.AddUnconfirmedTx(tx, utxoView)
 	}
 
+	// Notify subscribers in order without holding up the pool, the feed
+	// blocks until every subscriber has received the event.
+	mp.queueTxsEvent(NewTxsEvent{Txs: []*asiutil.Tx{tx}})
+
 	return txD
 }
 
+// queueTxsEvent queues the event to be sent to the subscribers after the ones
+// queued before, and starts sending them if no goroutine does already.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) queueTxsEvent(ev NewTxsEvent) {
+	mp.txEventsMtx.Lock()
+	defer mp.txEventsMtx.Unlock()
+
+	mp.txEvents = append(mp.txEvents, ev)
+	if !mp.txEventsSending {
+		mp.txEventsSending = true
+		go mp.sendTxsEvents()
+	}
+}
+
+// sendTxsEvents sends the queued events in order until the queue is empty.
+//
+// This function MUST be run in a single goroutine at a time, as marked by
+// txEventsSending.
+func (mp *TxPool) sendTxsEvents() {
+	for {
+		mp.txEventsMtx.Lock()
+		if len(mp.txEvents) == 0 {
+			mp.txEventsSending = false
+			mp.txEventsMtx.Unlock()
+			return
+		}
+		ev := mp.txEvents[0]
+		mp.txEvents[0] = NewTxsEvent{}
+		mp.txEvents = mp.txEvents[1:]
+		mp.txEventsMtx.Unlock()
+
+		mp.txFeed.Send(ev)
+	}
+}
+
 // checkPoolDoubleSpend checks whether or not the passed transaction is
 // attempting to spend coins already spent by other transactions in the pool.
 // Note it does not check for double spends against transactions already in the
@@ -1227,6 +1290,14 @@ func (mp *TxPool) ProcessTransaction(tx *asiutil.Tx, allowOrphan, rateLimit bool
 	return nil, err
 }
 
+// SubscribeNewTxsEvent registers a subscription of NewTxsEvent, which is
+// posted whenever a transaction is accepted into the main pool.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) SubscribeNewTxsEvent(ch chan<- NewTxsEvent) event.Subscription {
+	return mp.scope.Track(mp.txFeed.Subscribe(ch))
+}
+
 // Count returns the number of transactions in the main pool.  It does not
 // include the orphan pool.
 //
@@ -1274,6 +1345,30 @@ func (mp *TxPool) TxDescs() mining.TxDescList {
 	return descs
 }
 
+// TxAncestors returns the unconfirmed ancestors of the passed transaction in
+// the pool.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) TxAncestors(tx *asiutil.Tx) map[common.Hash]*asiutil.Tx {
+	mp.mtx.RLock()
+	ancestors := mp.txAncestors(tx, nil)
+	mp.mtx.RUnlock()
+
+	return ancestors
+}
+
+// TxDescendants returns the unconfirmed descendants of the passed transaction
+// in the pool.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) TxDescendants(tx *asiutil.Tx) map[common.Hash]*asiutil.Tx {
+	mp.mtx.RLock()
+	descendants := mp.txDescendants(tx, nil)
+	mp.mtx.RUnlock()
+
+	return descendants
+}
+
 // UpdateForbiddenTxs put given txhashes into forbiddenTxs.
 // If size of forbiddenTxs exceed limit, clear some olders.
 //
@@ -1374,6 +1469,18 @@ func (mp *TxPool) Start() {
 	log.Info("TxPool start")
 	mp.existCh = make(chan interface{})
 	go mp.handleUpdateFees()
+
+	if mp.cfg.PersistFile != "" {
+		if _, err := os.Stat(mp.cfg.PersistFile); err == nil {
+			accepted, failed, err := mp.Load(mp.cfg.PersistFile)
+			if err != nil {
+				log.Errorf("Failed to load the mempool: %v", err)
+			} else {
+				log.Infof("Loaded %d transactions of the mempool from %s, "+
+					"%d were dropped", accepted, mp.cfg.PersistFile, failed)
+			}
+		}
+	}
 }
 
 func (mp *TxPool) Halt() {
@@ -1384,6 +1491,16 @@ func (mp *TxPool) Halt() {
 	if mp.existCh != nil {
 		close(mp.existCh)
 		mp.existCh = nil
+
+		if mp.cfg.PersistFile != "" {
+			count, err := mp.Save(mp.cfg.PersistFile)
+			if err != nil {
+				log.Errorf("Failed to save the mempool: %v", err)
+			} else {
+				log.Infof("Saved %d transactions of the mempool to %s",
+					count, mp.cfg.PersistFile)
+			}
+		}
 	}
 }
 
@@ -1409,9 +1526,20 @@ type SignatureDesc struct {
 	packageHeight int32
 }
 
+// signerHeight identifies the signatures of a signer at a height.
+type signerHeight struct {
+	signer common.Address
+	height int32
+}
+
 type SigPool struct {
 	mtx  sync.RWMutex
 	pool map[common.Hash]SignatureDesc
+
+	// signed keeps the first signature of each signer at a height, to
+	// detect the signers which sign two different blocks.
+	signed       map[signerHeight]*asiutil.BlockSign
+	evidencePool *EvidencePool
 }
 
 // Ensure the TxPool type implements the mining.TxSource interface.
@@ -1434,9 +1562,14 @@ func (mp *SigPool) MiningDescs(height int32) []*asiutil.BlockSign {
 	return descs
 }
 
-func NewSigPool() *SigPool {
+// NewSigPool returns a new block signature pool.  The evidences of the
+// signers which sign two different blocks at the same height are added to
+// the passed evidence pool, unless it is nil.
+func NewSigPool(evidencePool *EvidencePool) *SigPool {
 	return &SigPool{
-		pool: make(map[common.Hash]SignatureDesc),
+		pool:         make(map[common.Hash]SignatureDesc),
+		signed:       make(map[signerHeight]*asiutil.BlockSign),
+		evidencePool: evidencePool,
 	}
 }
 
@@ -1474,6 +1607,11 @@ func (mp *SigPool) ConnectSigns(sigs []*asiutil.BlockSign, height int32) {
 	for _, sig := range deprecatedlist {
 		delete(mp.pool, *sig.Hash())
 	}
+	for key := range mp.signed {
+		if key.height < height - common.BlockSignDepth * 2 {
+			delete(mp.signed, key)
+		}
+	}
 	for _, v := range sigs {
 		mp.pool[*v.Hash()] = SignatureDesc{
 			sig :         v,
@@ -1527,9 +1665,9 @@ func (mp *SigPool) ProcessSig(sig *asiutil.BlockSign) error {
 		return nil
 	}
 	mp.mtx.Lock()
-	defer mp.mtx.Unlock()
 	_, ok := mp.pool[*sig.Hash()]
 	if ok {
+		mp.mtx.Unlock()
 		return nil
 	}
 
@@ -1538,5 +1676,32 @@ func (mp *SigPool) ProcessSig(sig *asiutil.BlockSign) error {
 	log.Debugf("[SigPool] Accepted signature height: %v, hash:%v (pool size: %v)",
 		sig.MsgSign.BlockHeight, sig.Hash(), len(mp.pool))
 
+	evidence := mp.detectDoubleSign(sig)
+	mp.mtx.Unlock()
+
+	if evidence != nil && mp.evidencePool != nil {
+		if _, err := mp.evidencePool.ProcessEvidence(evidence); err != nil {
+			log.Debugf("Rejected evidence of double sign by %v at height %d: %v",
+				sig.MsgSign.Signer, sig.MsgSign.BlockHeight, err)
+		}
+	}
 	return nil
 }
+
+// detectDoubleSign returns the evidence of a double sign when the signer of
+// the passed signature already signed another block at the same height, or
+// nil otherwise.
+//
+// This function MUST be called with the signature pool lock held (for writes).
+func (mp *SigPool) detectDoubleSign(sig *asiutil.BlockSign) *protos.MsgEvidence {
+	key := signerHeight{signer: sig.MsgSign.Signer, height: sig.MsgSign.BlockHeight}
+	signed, exists := mp.signed[key]
+	if !exists {
+		mp.signed[key] = sig
+		return nil
+	}
+	if signed.MsgSign.BlockHash == sig.MsgSign.BlockHash {
+		return nil
+	}
+	return protos.NewDoubleSignEvidence(signed.MsgSign, sig.MsgSign)
+}
@@ -1009,7 +1009,7 @@ func TestMultiInputOrphanDoubleSpend(t *testing.T) {
 func TestSigPool(t *testing.T) {
 
 	blockHashs := make(map[int32]*common.Hash)
-	sigPool := NewSigPool()
+	sigPool := NewSigPool(nil)
 	sigArray := make([]*asiutil.BlockSign, 0)
 	for i := 1; i < 101; i++ {
 
@@ -1729,4 +1729,57 @@ func TestRBP(t *testing.T) {
 			break
 		}
 	}
-}
\ No newline at end of file
+}
+
+// TestSubscribeNewTxsEvent ensures subscribers are notified about every
+// transaction entering the main pool, in the order they entered it, including
+// orphans which are moved to the main pool once their parent is accepted.
+func TestSubscribeNewTxsEvent(t *testing.T) {
+	t.Parallel()
+
+	harness, spendableOuts, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+
+	chainedTxns, err := harness.CreateTxChain(spendableOuts[0], 5)
+	if err != nil {
+		t.Fatalf("unable to create transaction chain: %v", err)
+	}
+
+	txsCh := make(chan NewTxsEvent)
+	sub := harness.txPool.SubscribeNewTxsEvent(txsCh)
+	defer sub.Unsubscribe()
+
+	// Add the orphans first, they must not be announced until they make
+	// their way into the main pool after their parent.
+	for _, tx := range chainedTxns[1:] {
+		_, err = harness.txPool.ProcessTransaction(tx, true, false, 0)
+		if err != nil {
+			t.Fatalf("ProcessTransaction: failed to accept orphan: %v", err)
+		}
+	}
+	_, err = harness.txPool.ProcessTransaction(chainedTxns[0], false, false, 0)
+	if err != nil {
+		t.Fatalf("ProcessTransaction: failed to accept parent: %v", err)
+	}
+
+	var got []common.Hash
+	for len(got) < len(chainedTxns) {
+		select {
+		case ev := <-txsCh:
+			for _, tx := range ev.Txs {
+				got = append(got, *tx.Hash())
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for %d transactions",
+				len(chainedTxns)-len(got))
+		}
+	}
+	for i, tx := range chainedTxns {
+		if got[i] != *tx.Hash() {
+			t.Fatalf("transaction %d announced is %v, want %v", i,
+				got[i], tx.Hash())
+		}
+	}
+}
@@ -0,0 +1,251 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/common/serialization"
+	"github.com/AsimovNetwork/asimov/protos"
+)
+
+const (
+	// mempoolFileMagic identifies a file the pool is saved to.
+	mempoolFileMagic uint32 = 0x4c4f4f50
+
+	// mempoolFileVersion is the version of the format of the file.
+	mempoolFileVersion uint32 = 1
+
+	// MempoolFileName is the name of the file in the data directory the
+	// pool is saved to on shutdown, and loaded from on start up.
+	MempoolFileName = "mempool.dat"
+)
+
+const (
+	// persistedPoolTx marks a transaction of the main pool in the file.
+	persistedPoolTx uint8 = iota
+
+	// persistedOrphanTx marks an orphan transaction in the file.
+	persistedOrphanTx
+)
+
+// persistedTx is a transaction saved to the file, along with the time it was
+// accepted and the tag of an orphan.
+type persistedTx struct {
+	kind  uint8
+	added time.Time
+	tag   Tag
+	tx    *asiutil.Tx
+}
+
+// persistedTxs returns the transactions of the pool with their parents first,
+// followed by the orphans.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *TxPool) persistedTxs() []*persistedTx {
+	txs := make([]*persistedTx, 0, len(mp.pool)+len(mp.orphans))
+	ancestorCounts := make(map[common.Hash]int, len(mp.pool))
+	cache := make(map[common.Hash]map[common.Hash]*asiutil.Tx)
+	for hash, desc := range mp.pool {
+		ancestorCounts[hash] = len(mp.txAncestors(desc.Tx, cache))
+		txs = append(txs, &persistedTx{
+			kind:  persistedPoolTx,
+			added: desc.Added,
+			tx:    desc.Tx,
+		})
+	}
+	sort.Slice(txs, func(i, j int) bool {
+		ci := ancestorCounts[*txs[i].tx.Hash()]
+		cj := ancestorCounts[*txs[j].tx.Hash()]
+		if ci != cj {
+			return ci < cj
+		}
+		return txs[i].added.Before(txs[j].added)
+	})
+
+	for _, otx := range mp.orphans {
+		txs = append(txs, &persistedTx{
+			kind:  persistedOrphanTx,
+			added: otx.expiration.Add(-orphanTTL),
+			tag:   otx.tag,
+			tx:    otx.tx,
+		})
+	}
+	return txs
+}
+
+// writePersistedTxs writes the passed transactions in the format of the file.
+func writePersistedTxs(w io.Writer, txs []*persistedTx) error {
+	for _, field := range []uint32{mempoolFileMagic, mempoolFileVersion} {
+		if err := serialization.WriteUint32(w, field); err != nil {
+			return err
+		}
+	}
+	if err := serialization.WriteVarInt(w, 0, uint64(len(txs))); err != nil {
+		return err
+	}
+	for _, ptx := range txs {
+		if err := serialization.WriteUint8(w, ptx.kind); err != nil {
+			return err
+		}
+		if err := serialization.WriteUint64(w, uint64(ptx.added.Unix())); err != nil {
+			return err
+		}
+		if err := serialization.WriteUint64(w, uint64(ptx.tag)); err != nil {
+			return err
+		}
+		if err := ptx.tx.MsgTx().Serialize(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readPersistedTxs reads the transactions written by writePersistedTxs.
+func readPersistedTxs(r io.Reader) ([]*persistedTx, error) {
+	var magic, version uint32
+	if err := serialization.ReadUint32(r, &magic); err != nil {
+		return nil, err
+	}
+	if magic != mempoolFileMagic {
+		return nil, fmt.Errorf("not a mempool file")
+	}
+	if err := serialization.ReadUint32(r, &version); err != nil {
+		return nil, err
+	}
+	if version != mempoolFileVersion {
+		return nil, fmt.Errorf("unsupported mempool file version %d", version)
+	}
+	count, err := serialization.ReadVarInt(r, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	txs := make([]*persistedTx, 0)
+	for i := uint64(0); i < count; i++ {
+		var kind uint8
+		var added, tag uint64
+		if err := serialization.ReadUint8(r, &kind); err != nil {
+			return nil, err
+		}
+		if kind != persistedPoolTx && kind != persistedOrphanTx {
+			return nil, fmt.Errorf("unknown kind %d of transaction %d", kind, i)
+		}
+		if err := serialization.ReadUint64(r, &added); err != nil {
+			return nil, err
+		}
+		if err := serialization.ReadUint64(r, &tag); err != nil {
+			return nil, err
+		}
+		var msgTx protos.MsgTx
+		if err := msgTx.Deserialize(r); err != nil {
+			return nil, fmt.Errorf("failed to read transaction %d: %v", i, err)
+		}
+		txs = append(txs, &persistedTx{
+			kind:  kind,
+			added: time.Unix(int64(added), 0),
+			tag:   Tag(tag),
+			tx:    asiutil.NewTx(&msgTx),
+		})
+	}
+	return txs, nil
+}
+
+// Save writes the transactions of the pool and the orphans to the file at the
+// passed path, along with the time they were accepted and the tag of the
+// orphans.  The file is replaced at once, so that a failure leaves the
+// previous one.  It returns the number of transactions written.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) Save(path string) (int, error) {
+	mp.mtx.RLock()
+	txs := mp.persistedTxs()
+	mp.mtx.RUnlock()
+
+	tmpPath := path + ".new"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return 0, err
+	}
+	w := bufio.NewWriter(file)
+	err = writePersistedTxs(w, txs)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return 0, err
+	}
+	return len(txs), nil
+}
+
+// Load reads the transactions written by Save from the file at the passed
+// path, and validates them again against the current chain.  The
+// transactions of the pool keep the time they were accepted, the orphans keep
+// their tag.  It returns the number of transactions accepted to the pool or
+// the orphans, and the number of the ones which were not.
+//
+// This function is safe for concurrent access.
+func (mp *TxPool) Load(path string) (int, int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+	txs, err := readPersistedTxs(bufio.NewReader(file))
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+
+	accepted, failed := 0, 0
+	for _, ptx := range txs {
+		if mp.HaveTransaction(ptx.tx.Hash()) {
+			continue
+		}
+		if ptx.kind == persistedOrphanTx {
+			if time.Since(ptx.added) > orphanTTL {
+				failed++
+				continue
+			}
+			if _, err := mp.ProcessTransaction(ptx.tx, true, false, ptx.tag); err != nil {
+				log.Debugf("Failed to load orphan transaction %v: %v",
+					ptx.tx.Hash(), err)
+				failed++
+				continue
+			}
+			accepted++
+			continue
+		}
+
+		_, txD, err := mp.MaybeAcceptTransaction(ptx.tx, false)
+		if err != nil || txD == nil {
+			log.Debugf("Failed to load transaction %v: %v",
+				ptx.tx.Hash(), err)
+			failed++
+			continue
+		}
+		mp.mtx.Lock()
+		txD.Added = ptx.added
+		mp.mtx.Unlock()
+		accepted++
+	}
+	return accepted, failed, nil
+}
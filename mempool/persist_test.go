@@ -0,0 +1,102 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mempool
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AsimovNetwork/asimov/chaincfg"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/protos"
+)
+
+// TestSaveLoad ensures the transactions of the pool and the orphans are saved
+// to a file and loaded back, with the time they were accepted and the tag of
+// the orphans.
+func TestSaveLoad(t *testing.T) {
+	t.Parallel()
+
+	harness, spendableOuts, err := newPoolHarness(&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create test pool: %v", err)
+	}
+	dir, err := ioutil.TempDir("", "mempool")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, MempoolFileName)
+
+	chainedTxns, err := harness.CreateTxChain(spendableOuts[0], 3)
+	if err != nil {
+		t.Fatalf("unable to create transaction chain: %v", err)
+	}
+	for _, tx := range chainedTxns {
+		if _, err := harness.txPool.ProcessTransaction(tx, false, false, 0); err != nil {
+			t.Fatalf("ProcessTransaction: failed to accept valid tx %v", err)
+		}
+	}
+	orphan, err := harness.CreateSignedTx([]spendableOutput{{
+		amount:   common.Amount(5000000000),
+		outPoint: protos.OutPoint{Hash: common.Hash{0x01}, Index: 0},
+	}}, 1, 0)
+	if err != nil {
+		t.Fatalf("unable to create signed tx: %v", err)
+	}
+	if _, err := harness.txPool.ProcessTransaction(orphan, true, false, 7); err != nil {
+		t.Fatalf("ProcessTransaction: failed to accept valid orphan %v", err)
+	}
+
+	// Make the transaction accepted first the last one of the map, so that
+	// the parents have to be written before the children.
+	added := time.Unix(time.Now().Unix()-3600, 0)
+	harness.txPool.pool[*chainedTxns[0].Hash()].Added = added
+
+	count, err := harness.txPool.Save(path)
+	if err != nil || count != len(chainedTxns)+1 {
+		t.Fatalf("Save got %d, err %v, want %d", count, err, len(chainedTxns)+1)
+	}
+
+	// Load the file into a new pool bound to the same chain.
+	harness.txPool = New(&harness.txPool.cfg)
+	tc := &testContext{t, harness}
+	accepted, failed, err := harness.txPool.Load(path)
+	if err != nil || accepted != count || failed != 0 {
+		t.Fatalf("Load got %d accepted and %d failed, err %v", accepted, failed, err)
+	}
+	for _, tx := range chainedTxns {
+		testPoolMembership(tc, tx, false, true)
+	}
+	testPoolMembership(tc, orphan, true, false)
+	if got := harness.txPool.pool[*chainedTxns[0].Hash()].Added; !got.Equal(added) {
+		t.Errorf("loaded transaction was accepted at %v, want %v", got, added)
+	}
+	if got := harness.txPool.orphans[*orphan.Hash()].tag; got != 7 {
+		t.Errorf("loaded orphan has tag %d, want 7", got)
+	}
+
+	// The transactions already in the pool are skipped.
+	harness.txPool = New(&harness.txPool.cfg)
+	tc.harness = harness
+	if _, err := harness.txPool.ProcessTransaction(chainedTxns[0], false, false, 0); err != nil {
+		t.Fatalf("ProcessTransaction: failed to accept valid tx %v", err)
+	}
+	accepted, failed, err = harness.txPool.Load(path)
+	if err != nil || accepted != count-1 || failed != 0 {
+		t.Fatalf("Load got %d accepted and %d failed, err %v", accepted, failed, err)
+	}
+
+	// A file which is not a mempool file is refused.
+	if err := ioutil.WriteFile(path, []byte("not a mempool"), 0644); err != nil {
+		t.Fatalf("unable to write file: %v", err)
+	}
+	if _, _, err := harness.txPool.Load(path); err == nil {
+		t.Errorf("Load accepted a file which is not a mempool file")
+	}
+}
@@ -6,6 +6,7 @@
 package mining
 
 import (
+	"bytes"
 	"container/heap"
 	"crypto/ecdsa"
 	"errors"
@@ -109,6 +110,14 @@ type TxSource interface {
 	// UpdateForbiddenTxs put given txhashes into forbiddenTxs.
 	// If size of forbiddenTxs exceed limit, clear some olders.
 	UpdateForbiddenTxs(txHashes []*common.Hash, height int64)
+
+	// TxAncestors returns the unconfirmed ancestors of the transaction in
+	// the source pool.
+	TxAncestors(tx *asiutil.Tx) map[common.Hash]*asiutil.Tx
+
+	// TxDescendants returns the unconfirmed descendants of the transaction
+	// in the source pool.
+	TxDescendants(tx *asiutil.Tx) map[common.Hash]*asiutil.Tx
 }
 
 type SigSource interface {
@@ -119,14 +128,41 @@ type SigSource interface {
 // transaction to be prioritized and track dependencies on other transactions
 // which have not been mined into a block yet.
 type TxPrioItem struct {
-	tx       *asiutil.Tx
+	tx *asiutil.Tx
+
+	// gasPrice is the fee rate in the asimov asset of the package made of
+	// the transaction and its ancestors which are not in the block yet,
+	// which is the priority of the item.
 	gasPrice float64
 
-	// dependsOn holds a map of transaction hashes which this one depends
-	// on.  It will only be set when the transaction references other
-	// transactions in the source pool and hence must come after them in
-	// a block.
-	dependsOn map[common.Hash]struct{}
+	// assetPrices are the fee rates of the package in the other assets of
+	// the fee list, in the order of feeAssets.  They order the items with
+	// the same gasPrice.
+	assetPrices []float64
+
+	// fees are the fees the transaction pays in each asset of the fee
+	// list, in the order of feeAssets, and gas is the gas it is weighted
+	// by.
+	fees []float64
+	gas  float64
+
+	// packageFees and packageGas are the sums of fees and gas over the
+	// package.
+	packageFees []float64
+	packageGas  float64
+
+	// ancestors holds the transactions in the source pool which this one
+	// depends on and which are not in the block yet.  They must come
+	// before it in a block.
+	ancestors map[common.Hash]*TxPrioItem
+
+	// ancestorCount is the number of ancestors in the source pool, which
+	// orders a package so that parents come first.
+	ancestorCount int
+
+	// index is the index of the item in the priority queue, or -1 when it
+	// is not in the queue.
+	index int
 }
 
 // txPriorityQueue implements a priority queue of TxPrioItem elements that
@@ -145,19 +181,32 @@ func (pq *txPriorityQueue) Len() int {
 // before the item with index j by deferring to the assigned less function.  It
 // is part of the heap.Interface implementation.
 func (pq *txPriorityQueue) Less(i, j int) bool {
-	return pq.items[i].gasPrice > pq.items[j].gasPrice
+	a, b := pq.items[i], pq.items[j]
+	if a.gasPrice != b.gasPrice {
+		return a.gasPrice > b.gasPrice
+	}
+	for k := 0; k < len(a.assetPrices) && k < len(b.assetPrices); k++ {
+		if a.assetPrices[k] != b.assetPrices[k] {
+			return a.assetPrices[k] > b.assetPrices[k]
+		}
+	}
+	return false
 }
 
 // Swap swaps the items at the passed indices in the priority queue.  It is
 // part of the heap.Interface implementation.
 func (pq *txPriorityQueue) Swap(i, j int) {
 	pq.items[i], pq.items[j] = pq.items[j], pq.items[i]
+	pq.items[i].index = i
+	pq.items[j].index = j
 }
 
 // Push pushes the passed item onto the priority queue.  It is part of the
 // heap.Interface implementation.
 func (pq *txPriorityQueue) Push(x interface{}) {
-	pq.items = append(pq.items, x.(*TxPrioItem))
+	item := x.(*TxPrioItem)
+	item.index = len(pq.items)
+	pq.items = append(pq.items, item)
 }
 
 // Pop removes the highest priority item (according to Less) from the priority
@@ -165,6 +214,7 @@ func (pq *txPriorityQueue) Push(x interface{}) {
 func (pq *txPriorityQueue) Pop() interface{} {
 	n := len(pq.items)
 	item := pq.items[n-1]
+	item.index = -1
 	pq.items[n-1] = nil
 	pq.items = pq.items[0 : n-1]
 	return item
@@ -258,6 +308,72 @@ func CreateCoinbaseTx(params *chaincfg.Params, nextBlockHeight int32, addr commo
 	return asiutil.NewTx(tx), stdTxOut, nil
 }
 
+// feeAssets returns the assets of the fee list of the validator committee
+// indexed by their position in the fee rates of the packages.  The asimov
+// asset comes first, then the assets by the height they were accepted at.
+//
+// The fee list has no exchange rate between the assets, so the fees paid in
+// different assets are not added up: the packages are ordered by their fee
+// rate in the asimov asset, then in each other asset in turn.  Normalizing the
+// fees over the assets needs a rate source, which the validator committee does
+// not provide yet.
+func feeAssets(feepool map[protos.Asset]int32) map[protos.Asset]int {
+	assets := make([]protos.Asset, 0, len(feepool))
+	for asset := range feepool {
+		if asset != asiutil.AsimovAsset {
+			assets = append(assets, asset)
+		}
+	}
+	sort.Slice(assets, func(i, j int) bool {
+		if feepool[assets[i]] != feepool[assets[j]] {
+			return feepool[assets[i]] < feepool[assets[j]]
+		}
+		return bytes.Compare(assets[i].Bytes(), assets[j].Bytes()) < 0
+	})
+	indexes := make(map[protos.Asset]int, len(assets)+1)
+	indexes[asiutil.AsimovAsset] = 0
+	for i, asset := range assets {
+		indexes[asset] = i + 1
+	}
+	return indexes
+}
+
+// txFeesAndGas returns the fees the transaction of the passed descriptor pays
+// in each asset of the fee list, indexed as given by feeAssets, and the gas it
+// is weighted by in the fee rates of a package.  Descriptors without the fees
+// per asset pay their gas price in the asimov asset.  It returns false when the
+// transaction pays fees in an asset out of the fee list, which can't be
+// included in the block.
+func txFeesAndGas(desc *TxDesc, assets map[protos.Asset]int) ([]float64, float64, bool) {
+	gas := float64(desc.Tx.MsgTx().TxContract.GasLimit)
+	if gas < 1 {
+		gas = 1
+	}
+	fees := make([]float64, len(assets))
+	if desc.FeeList == nil {
+		fees[0] = desc.GasPrice * gas
+		return fees, gas, true
+	}
+	for asset, value := range *desc.FeeList {
+		index, ok := assets[asset]
+		if !ok {
+			return nil, 0, false
+		}
+		fees[index] += float64(value)
+	}
+	return fees, gas, true
+}
+
+// setPrices sets the fee rates of the package of the item from its fees and
+// gas.
+func (item *TxPrioItem) setPrices() {
+	item.gasPrice = item.packageFees[0] / item.packageGas
+	item.assetPrices = item.assetPrices[:0]
+	for _, fee := range item.packageFees[1:] {
+		item.assetPrices = append(item.assetPrices, fee/item.packageGas)
+	}
+}
+
 // logSkippedDeps logs any dependencies which are also skipped as a result of
 // skipping a transaction while generating a block template at the trace level.
 func logSkippedDeps(tx *asiutil.Tx, deps map[common.Hash]*TxPrioItem) {
@@ -332,18 +448,17 @@ func NewBlkTmplGenerator(policy *Policy,
 // coinbase which will replace the one generated for the block template.  Thus
 // the need to have configured address can be avoided.
 //
-// The transactions selected and included are prioritized according to several
-// factors.  First, each transaction has a priority calculated based on its
-// value, age of inputs, and size.  Transactions which consist of larger
-// amounts, older inputs, and small sizes have the highest priority.  Second, a
-// fee per kilobyte is calculated for each transaction.  Transactions with a
-// higher fee per kilobyte are preferred.  Finally, the block generation related
-// policy settings are all taken into account.
-//
-// Once the high-priority area (if configured) has been filled with
-// transactions, or the priority falls below what is considered high-priority,
-// the priority queue is updated to prioritize by fees per kilobyte (then
-// priority).
+// The transactions are selected by packages, a package being made of a
+// transaction along with its unconfirmed ancestors which are not in the block
+// yet.  The package with the highest fee rate, which is the sum of the fees
+// over the sum of the gas limits of its transactions, is included first, with
+// the parents before their children.  Thus a child paying a high fee gets its
+// low fee parents mined along with it.  The fees paid in the assets of the fee
+// list of the validator committee are not added up, since there is no exchange
+// rate between the assets: the packages are ordered by their fee rate in the
+// asimov asset, then in each other asset in turn.  Once a package is included, the fee rates
+// of the packages of its descendants are updated.  Finally, the block
+// generation related policy settings are all taken into account.
 //
 // Given the above, a block generated by this function is of the following form:
 //
@@ -377,7 +492,6 @@ func (g *BlkTmplGenerator) ProduceNewBlock(account *crypto.Account, gasFloor, ga
 	sort.Sort(sourceTxns)
 
 	forbiddenTxHashes := make([]*common.Hash, 0, len(sourceTxns))
-	priorityQueue := NewTxPriorityQueue(len(sourceTxns))
 	txpool := make(map[common.Hash]int)
 	for _, tx := range sourceTxns {
 		txpool[*tx.Tx.Hash()] = MiningTxInit
@@ -502,12 +616,11 @@ func (g *BlkTmplGenerator) ProduceNewBlock(account *crypto.Account, gasFloor, ga
 
 	blockUtxos := txo.NewUtxoViewpoint()
 
-	// dependers is used to track transactions which depend on another
-	// transaction in the source pool.  This, in conjunction with the
-	// dependsOn map kept with each dependent transaction helps quickly
-	// determine which dependent transactions are now eligible for inclusion
-	// in the block once each transaction has been included.
-	dependers := make(map[common.Hash]map[common.Hash]*TxPrioItem)
+	// candidates holds the transactions which are ready for inclusion in
+	// the block, or which only depend on other candidates in the source
+	// pool.
+	candidates := make(map[common.Hash]*TxPrioItem, len(sourceTxns))
+	assets := feeAssets(feepool)
 
 	// Create slices to hold the fees and number of signature operations
 	// for each of the selected transactions and add an entry for the
@@ -536,6 +649,12 @@ mempoolLoop:
 			log.Tracef("Skipping non-finalized tx %s", tx.Hash())
 			continue
 		}
+		fees, gas, ok := txFeesAndGas(txDesc, assets)
+		if !ok {
+			log.Tracef("Skipping tx %s because it pays fees in an "+
+				"unsupported asset", tx.Hash())
+			continue
+		}
 
 		// Fetch all of the utxos referenced by the this transaction.
 		// NOTE: This intentionally does not fetch inputs from the
@@ -550,11 +669,9 @@ mempoolLoop:
 		}
 		txDesc.UtxoFetchCount++
 
-		// Setup dependencies for any transactions which reference
-		// other transactions in the mempool so they can be properly
-		// ordered below.
-		prioItem := &TxPrioItem{
-			tx: tx}
+		// Transactions referencing other transactions in the source
+		// pool are ordered after them below, along with their other
+		// ancestors.
 		for _, txIn := range tx.MsgTx().TxIn {
 			originHash := &txIn.PreviousOutPoint.Hash
 			entry := utxos.LookupEntry(txIn.PreviousOutPoint)
@@ -566,43 +683,72 @@ mempoolLoop:
 						tx.Hash(), txIn.PreviousOutPoint)
 					continue mempoolLoop
 				}
-
-				// The transaction is referencing another
-				// transaction in the source pool, so setup an
-				// ordering dependency.
-				deps, exists := dependers[*originHash]
-				if !exists {
-					deps = make(map[common.Hash]*TxPrioItem)
-					dependers[*originHash] = deps
-				}
-				deps[*prioItem.tx.Hash()] = prioItem
-				if prioItem.dependsOn == nil {
-					prioItem.dependsOn = make(
-						map[common.Hash]struct{})
-				}
-				prioItem.dependsOn[*originHash] = struct{}{}
-
-				// Skip the check below. We already know the
-				// referenced transaction is available.
-				continue
 			}
 		}
 
-		prioItem.gasPrice = txDesc.GasPrice
-
 		// Merge the referenced outputs from the input transactions to
 		// this transaction into the block utxo view.  This allows the
 		// code below to avoid a second lookup.
 		mergeUtxoView(blockUtxos, utxos)
 
-		// Add the transaction to the priority queue to mark it ready
-		// for inclusion in the block unless it has dependencies.
-		if prioItem.dependsOn == nil {
-			heap.Push(priorityQueue, prioItem)
+		candidates[*tx.Hash()] = &TxPrioItem{
+			tx:    tx,
+			fees:  fees,
+			gas:   gas,
+			index: -1,
 		}
 	}
+
+	// Score each candidate by the fee rate of its package, which is made
+	// of the candidate and all of its unconfirmed ancestors, so that a
+	// transaction paying a high fee gets its low fee parents mined along
+	// with it.  A transaction whose ancestors are not all candidates can't
+	// be included.
+	priorityQueue := NewTxPriorityQueue(len(candidates))
+	var ineligible []common.Hash
+	for hash, item := range candidates {
+		ancestors := g.txSource.TxAncestors(item.tx)
+		item.ancestors = make(map[common.Hash]*TxPrioItem, len(ancestors))
+		item.packageFees = append([]float64(nil), item.fees...)
+		item.packageGas = item.gas
+		for ancestorHash := range ancestors {
+			ancestor, ok := candidates[ancestorHash]
+			if !ok {
+				log.Tracef("Skipping tx %s because its ancestor %s "+
+					"can't be included", hash, ancestorHash)
+				ineligible = append(ineligible, hash)
+				break
+			}
+			item.ancestors[ancestorHash] = ancestor
+			for k, fee := range ancestor.fees {
+				item.packageFees[k] += fee
+			}
+			item.packageGas += ancestor.gas
+		}
+		item.ancestorCount = len(item.ancestors)
+		item.setPrices()
+	}
+	for _, hash := range ineligible {
+		delete(candidates, hash)
+	}
+	for _, item := range candidates {
+		heap.Push(priorityQueue, item)
+	}
 	utxoEnd := getMilliSecond()
 
+	// descendantItems returns the candidates descending from the passed
+	// transaction which have not been processed yet.
+	descendantItems := func(tx *asiutil.Tx) map[common.Hash]*TxPrioItem {
+		items := make(map[common.Hash]*TxPrioItem)
+		for hash := range g.txSource.TxDescendants(tx) {
+			item, ok := candidates[hash]
+			if ok && txpool[hash] != MiningTxProcessed {
+				items[hash] = item
+			}
+		}
+		return items
+	}
+
 	blockSigOpCost := coinbaseSigOpCost
 	allFees := map[protos.Asset]int64 {
 		asiutil.AsimovAsset: 0,
@@ -614,25 +760,11 @@ mempoolLoop:
 	var msgvblock protos.MsgVBlock
 	stxos := make([]txo.SpentTxOut, 0, 1000)
 
-	// Choose which transactions make it into the block.
-	processTxStartTime := getMilliSecond()
-	log.Debug("Start priorityQueue", priorityQueue.Len())
-priorityQueueLoop:
-	for priorityQueue.Len() > 0 {
-		interval := float64(getMilliSecond() - produceBlockStartTime)
-		if interval > produceBlockTimeInterval || interval > produceTxTimeInterval {
-			log.Debug("mine time out ")
-			break
-		}
-
-		// Grab the highest priority (or highest fee per kilobyte
-		// depending on the sort order) transaction.
-		prioItem := heap.Pop(priorityQueue).(*TxPrioItem)
+	// addTx validates and connects a transaction whose ancestors are
+	// already in the block, and adds it to the block.  It returns whether
+	// the transaction was added.
+	addTx := func(prioItem *TxPrioItem) bool {
 		tx := prioItem.tx
-		txpool[*tx.Hash()] = MiningTxProcessed
-
-		// Grab any transactions which depend on this one.
-		deps := dependers[*tx.Hash()]
 
 		// Enforce maximum block size.  Also check for overflow.
 		txSize := tx.MsgTx().SerializeSize()
@@ -641,8 +773,7 @@ priorityQueueLoop:
 			blockPlusTxSize >= common.MaxBlockSize {
 			log.Tracef("Skipping tx %s because it would exceed "+
 				"the max block size", tx.Hash())
-			logSkippedDeps(tx, deps)
-			continue
+			return false
 		}
 
 		// Enforce maximum gaslimit. Also check for overflow
@@ -652,8 +783,7 @@ priorityQueueLoop:
 			blockPlusGaslimit >= int(header.GasLimit) {
 			log.Tracef("Skipping tx %s because it would exceed "+
 				"the max gas limit", tx.Hash())
-			logSkippedDeps(tx, deps)
-			continue
+			return false
 		}
 
 		// Enforce maximum signature operation cost per block.  Also
@@ -662,15 +792,13 @@ priorityQueueLoop:
 		if err != nil {
 			log.Tracef("Skipping tx %s due to error in "+
 				"GetSigOpCost: %v", tx.Hash(), err)
-			logSkippedDeps(tx, deps)
-			continue
+			return false
 		}
 		if blockSigOpCost+int64(sigOpCost) < blockSigOpCost ||
 			blockSigOpCost+int64(sigOpCost) > blockchain.MaxBlockSigOpsCost {
 			log.Tracef("Skipping tx %s because it would "+
 				"exceed the maximum sigops per block", tx.Hash())
-			logSkippedDeps(tx, deps)
-			continue
+			return false
 		}
 
 		// Ensure the transaction inputs pass all of the necessary
@@ -679,8 +807,7 @@ priorityQueueLoop:
 		if err != nil {
 			log.Tracef("Skipping tx %s due to error in "+
 				"CheckTransactionInputs: %v", tx.Hash(), err)
-			logSkippedDeps(tx, deps)
-			continue
+			return false
 		}
 
 		for asset := range *feeList {
@@ -688,8 +815,7 @@ priorityQueueLoop:
 				log.Tracef("Skipping tx %s because its "+
 					"fee %v is unsupported",
 					tx.Hash(), asset)
-				logSkippedDeps(tx, deps)
-				continue priorityQueueLoop
+				return false
 			}
 			if _, ok := allFees[asset]; !ok {
 				txSize += txoutSizePerAsset
@@ -697,8 +823,7 @@ priorityQueueLoop:
 				if blockPlusTxSize < blockSize || blockPlusTxSize >= common.MaxBlockSize {
 					log.Tracef("Skipping tx %s because it would exceed "+
 						"the max block size", tx.Hash())
-					logSkippedDeps(tx, deps)
-					continue priorityQueueLoop
+					return false
 				}
 			}
 		}
@@ -708,8 +833,7 @@ priorityQueueLoop:
 		if err != nil {
 			log.Tracef("Skipping tx %s due to error in "+
 				"ValidateTransactionScripts: %v", tx.Hash(), err)
-			logSkippedDeps(tx, deps)
-			continue
+			return false
 		}
 
 		// try connect transaction
@@ -720,7 +844,6 @@ priorityQueueLoop:
 		if err != nil {
 			log.Debugf("Skipping tx %s because it failed to connect",
 				tx.Hash())
-			logSkippedDeps(tx, deps)
 			forbiddenTxHashes = append(forbiddenTxHashes, tx.Hash())
 			for _, txIn := range tx.MsgTx().TxIn {
 				// Ensure the referenced utxo exists in the view.  This should
@@ -730,7 +853,7 @@ priorityQueueLoop:
 					entry.UnSpent()
 				}
 			}
-			continue
+			return false
 		}
 		if receipt != nil {
 			receipts = append(receipts, receipt)
@@ -754,17 +877,83 @@ priorityQueueLoop:
 		txSigOpCosts = append(txSigOpCosts, int64(sigOpCost))
 
 		log.Tracef("Adding tx %s (gasPrice %.2f)",
-			tx.Hash(), prioItem.gasPrice)
-
-		// Add transactions which depend on this one (and also do not
-		// have any other unsatisified dependencies) to the priority
-		// queue.
-		for _, item := range deps {
-			// Add the transaction to the priority queue if there
-			// are no more dependencies after this one.
-			delete(item.dependsOn, *tx.Hash())
-			if len(item.dependsOn) == 0 && txpool[*item.tx.Hash()] != MiningTxProcessed {
-				heap.Push(priorityQueue, item)
+			tx.Hash(), prioItem.fees[0]/prioItem.gas)
+		return true
+	}
+
+	// Choose which transactions make it into the block.
+	processTxStartTime := getMilliSecond()
+	log.Debug("Start priorityQueue", priorityQueue.Len())
+	for priorityQueue.Len() > 0 {
+		interval := float64(getMilliSecond() - produceBlockStartTime)
+		if interval > produceBlockTimeInterval || interval > produceTxTimeInterval {
+			log.Debug("mine time out ")
+			break
+		}
+
+		// Grab the package with the highest fee rate, and order the
+		// ancestors which are not in the block yet so that parents
+		// come first.
+		prioItem := heap.Pop(priorityQueue).(*TxPrioItem)
+		pkg := make([]*TxPrioItem, 0, len(prioItem.ancestors)+1)
+		for _, ancestor := range prioItem.ancestors {
+			pkg = append(pkg, ancestor)
+		}
+		sort.Slice(pkg, func(i, j int) bool {
+			return pkg[i].ancestorCount < pkg[j].ancestorCount
+		})
+		pkg = append(pkg, prioItem)
+
+		// Skip the package at once when it does not fit in the block.
+		// The ancestors are still considered on their own.
+		pkgSize, pkgGasLimit := 0, 0
+		for _, item := range pkg {
+			pkgSize += item.tx.MsgTx().SerializeSize()
+			pkgGasLimit += int(item.tx.MsgTx().TxContract.GasLimit)
+		}
+		if blockSize+pkgSize >= common.MaxBlockSize ||
+			blockGasLimit+pkgGasLimit >= int(header.GasLimit) {
+			log.Tracef("Skipping tx %s because its package would "+
+				"exceed the max block size or gas limit", prioItem.tx.Hash())
+			txpool[*prioItem.tx.Hash()] = MiningTxProcessed
+			continue
+		}
+
+		for _, item := range pkg {
+			tx := item.tx
+			txpool[*tx.Hash()] = MiningTxProcessed
+			if item.index >= 0 {
+				heap.Remove(priorityQueue, item.index)
+			}
+
+			// Skip the transactions which depend on a skipped one,
+			// including the rest of the package.
+			deps := descendantItems(tx)
+			if !addTx(item) {
+				logSkippedDeps(tx, deps)
+				for hash, dep := range deps {
+					txpool[hash] = MiningTxProcessed
+					if dep.index >= 0 {
+						heap.Remove(priorityQueue, dep.index)
+					}
+				}
+				break
+			}
+
+			// The packages of the descendants no longer include
+			// the transaction.
+			for hash, dep := range deps {
+				delete(dep.ancestors, *tx.Hash())
+				for k, fee := range item.fees {
+					dep.packageFees[k] -= fee
+				}
+				dep.packageGas -= item.gas
+				dep.setPrices()
+				if dep.index >= 0 {
+					heap.Fix(priorityQueue, dep.index)
+				}
+				log.Tracef("Updating package of tx %s (gasPrice %.2f)",
+					hash, dep.gasPrice)
 			}
 		}
 	}
@@ -872,15 +1061,14 @@ func rebuildFunder(tx *asiutil.Tx, stdTxout *protos.TxOut, fees *map[protos.Asse
 	}
 }
 
-// commit state and signature the given block
+// commit state and signature the given block.  The state is kept in memory
+// until the block is connected, which stores it according to the gc mode of
+// the chain.
 func commit(block *protos.MsgBlock, stateDB *state.StateDB, account *crypto.Account) error {
 	stateRoot, err := stateDB.Commit(true)
 	if err != nil {
 		return err
 	}
-	if err := stateDB.Database().TrieDB().Commit(stateRoot, false); err != nil {
-		return err
-	}
 	block.Header.StateRoot = stateRoot
 
 	blockHash := block.BlockHash()
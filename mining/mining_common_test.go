@@ -139,7 +139,11 @@ func (m *RoundManager) GetRoundInterval(round int64) int64 {
 }
 
 func (m *RoundManager) GetNextRound(round *ainterface.Round) (*ainterface.Round, error) {
-	return nil, nil
+	return &ainterface.Round{
+		Round:          round.Round + 1,
+		RoundStartUnix: round.RoundStartUnix + round.Duration,
+		Duration:       m.GetRoundInterval(int64(round.Round) + 1),
+	}, nil
 }
 
 var _ ainterface.IRoundManager = (*RoundManager)(nil)
@@ -266,7 +270,9 @@ func cleanAndExpandPath(path string) string {
 	return filepath.Clean(os.ExpandEnv(path))
 }
 
-func newFakeChain(paramstmp *chaincfg.Params) (*blockchain.BlockChain, func(), error) {
+// newFakeChain returns a chain of the passed params keeping the contract
+// states according to the passed gc mode.
+func newFakeChain(paramstmp *chaincfg.Params, gcMode string, retention int32) (*blockchain.BlockChain, func(), error) {
 	cfg := &chaincfg.FConfig{
 		ConfigFile:           chaincfg.DefaultConfigFile,
 		DebugLevel:           chaincfg.DefaultLogLevel,
@@ -291,6 +297,8 @@ func newFakeChain(paramstmp *chaincfg.Params) (*blockchain.BlockChain, func(), e
 		WSModules:            chaincfg.DefaultWSModules,
 		DevelopNet:           true,
 		Consensustype:        "poa",
+		GcMode:               gcMode,
+		StateRetention:       retention,
 	}
 
 	consensus := common.GetConsensus(cfg.Consensustype)
@@ -363,7 +371,7 @@ func newFakeChain(paramstmp *chaincfg.Params) (*blockchain.BlockChain, func(), e
 		BtcClient:       btcClient,
 		RoundManager:    roundManger,
 		ContractManager: contractManager,
-	}, nil)
+	}, cfg)
 	if err != nil {
 		teardown = func() {
 			db.Close()
@@ -467,6 +475,38 @@ func (fts *fakeTxSource) TxDescs() TxDescList {
 func (fts *fakeTxSource) UpdateForbiddenTxs(txHashes []*common.Hash, height int64) {
 }
 
+func (fts *fakeTxSource) TxAncestors(tx *asiutil.Tx) map[common.Hash]*asiutil.Tx {
+	ancestors := make(map[common.Hash]*asiutil.Tx)
+	for _, txIn := range tx.MsgTx().TxIn {
+		parent, ok := fts.pool[txIn.PreviousOutPoint.Hash]
+		if !ok {
+			continue
+		}
+		ancestors[*parent.Tx.Hash()] = parent.Tx
+		for hash, ancestor := range fts.TxAncestors(parent.Tx) {
+			ancestors[hash] = ancestor
+		}
+	}
+	return ancestors
+}
+
+func (fts *fakeTxSource) TxDescendants(tx *asiutil.Tx) map[common.Hash]*asiutil.Tx {
+	descendants := make(map[common.Hash]*asiutil.Tx)
+	for _, desc := range fts.pool {
+		for _, txIn := range desc.Tx.MsgTx().TxIn {
+			if txIn.PreviousOutPoint.Hash != *tx.Hash() {
+				continue
+			}
+			descendants[*desc.Tx.Hash()] = desc.Tx
+			for hash, descendant := range fts.TxDescendants(desc.Tx) {
+				descendants[hash] = descendant
+			}
+			break
+		}
+	}
+	return descendants
+}
+
 type fakeSigSource struct {
 	pool []*asiutil.BlockSign
 }
@@ -73,6 +73,62 @@ func TestTxPriceHeap(t *testing.T) {
 	}
 }
 
+// TestTxPriceHeapAssets ensures the priority queue orders the items with the
+// same fee rate in the asimov asset by their fee rates in the other assets of
+// the fee list, without adding up the fees in different assets.
+func TestTxPriceHeapAssets(t *testing.T) {
+	assetA := protos.Asset{Property: 1, Id: 1}
+	assetB := protos.Asset{Property: 1, Id: 2}
+	assets := feeAssets(map[protos.Asset]int32{
+		asiutil.AsimovAsset: 0,
+		assetB:              3,
+		assetA:              5,
+	})
+	if assets[asiutil.AsimovAsset] != 0 || assets[assetB] != 1 || assets[assetA] != 2 {
+		t.Fatalf("unexpected fee asset indexes %v", assets)
+	}
+
+	newItem := func(name int64, feeList map[protos.Asset]int64) *TxPrioItem {
+		msgTx := protos.NewMsgTx(protos.TxVersion)
+		msgTx.TxContract.GasLimit = 10
+		msgTx.LockTime = uint32(name)
+		fees, gas, ok := txFeesAndGas(&TxDesc{Tx: asiutil.NewTx(msgTx), FeeList: &feeList}, assets)
+		if !ok {
+			t.Fatalf("txFeesAndGas rejected the fees %v", feeList)
+		}
+		item := &TxPrioItem{tx: asiutil.NewTx(msgTx), fees: fees, gas: gas,
+			packageFees: fees, packageGas: gas}
+		item.setPrices()
+		return item
+	}
+	testItems := []*TxPrioItem{
+		newItem(0, map[protos.Asset]int64{assetA: 1e6}),
+		newItem(1, map[protos.Asset]int64{asiutil.AsimovAsset: 10, assetA: 1e6}),
+		newItem(2, map[protos.Asset]int64{asiutil.AsimovAsset: 100}),
+		newItem(3, map[protos.Asset]int64{assetB: 10}),
+		newItem(4, map[protos.Asset]int64{asiutil.AsimovAsset: 10}),
+	}
+	want := []uint32{2, 1, 4, 3, 0}
+
+	priorityQueue := NewTxPriorityQueue(len(testItems))
+	for _, item := range testItems {
+		heap.Push(priorityQueue, item)
+	}
+	for i, name := range want {
+		item := heap.Pop(priorityQueue).(*TxPrioItem)
+		if item.tx.MsgTx().LockTime != name {
+			t.Fatalf("item %d: got tx %d, want %d", i,
+				item.tx.MsgTx().LockTime, name)
+		}
+	}
+
+	feeList := map[protos.Asset]int64{{Property: 1, Id: 3}: 1}
+	msgTx := protos.NewMsgTx(protos.TxVersion)
+	if _, _, ok := txFeesAndGas(&TxDesc{Tx: asiutil.NewTx(msgTx), FeeList: &feeList}, assets); ok {
+		t.Fatalf("txFeesAndGas accepted a fee out of the fee list")
+	}
+}
+
 func TestCreateCoinbaseTx(t *testing.T) {
 	privKey, _ := crypto.NewPrivateKey(crypto.S256())
 	pkaddr, _ := address.NewAddressPubKey(privKey.PubKey().SerializeCompressed())
@@ -112,7 +168,7 @@ func TestNewBlockTemplate(t *testing.T) {
 		TxConnectTimeOut: chaincfg.DefaultTxConnectTimeOut,
 		UtxoValidateTimeOut: chaincfg.DefaultUtxoValidateTimeOut,
 	}
-	chain, teardownFunc, err := newFakeChain(&chaincfg.DevelopNetParams)
+	chain, teardownFunc, err := newFakeChain(&chaincfg.DevelopNetParams, chaincfg.GcModeArchive, 0)
 	if err != nil {
 		t.Error("newFakeChain error: ", err)
 		return
@@ -262,6 +318,36 @@ func TestNewBlockTemplate(t *testing.T) {
 		},
 	}, nil), GasPrice: 7})
 
+	// A low fee parent with a high fee child is mined before a
+	// transaction paying less than the package of both.
+	cpfpTxs := TxDescList{{Tx: createFakeTx([]*fakeIn{
+		{
+			keys[0], 1e8, &asiutil.AsimovAsset, 0, false, 0, common.HexToHash("9"),
+		},
+	}, []*fakeOut{
+		{
+			keys[7].Address, 1e8 - 1e3, &asiutil.AsimovAsset,
+		},
+	}, global_view), GasPrice: 1}}
+	cpfpTxs = append(cpfpTxs, &TxDesc{Tx: createFakeTx([]*fakeIn{
+		{
+			keys[7], 1e8 - 1e3, &asiutil.AsimovAsset, 0, false, 0x7FFFFFFF, *cpfpTxs[0].Tx.Hash(),
+		},
+	}, []*fakeOut{
+		{
+			keys[0].Address, 1e8 - 1e3 - 1e5, &asiutil.AsimovAsset,
+		},
+	}, nil), GasPrice: 9})
+	cpfpTxs = append(cpfpTxs, &TxDesc{Tx: createFakeTx([]*fakeIn{
+		{
+			keys[0], 1e8, &asiutil.AsimovAsset, 0, false, 0, common.HexToHash("a"),
+		},
+	}, []*fakeOut{
+		{
+			keys[1].Address, 1e8 - 1e4, &asiutil.AsimovAsset,
+		},
+	}, global_view), GasPrice: 4})
+
 	invalidFakeTxs := TxDescList{
 		{Tx: createFakeTx([]*fakeIn{
 			{
@@ -356,6 +442,11 @@ func TestNewBlockTemplate(t *testing.T) {
 			[]*common.Hash{fakeTxs[5].Tx.Hash(), fakeTxs[6].Tx.Hash(), fakeTxs[4].Tx.Hash(), fakeTxs[3].Tx.Hash(), fakeTxs[2].Tx.Hash(), fakeTxs[1].Tx.Hash()},
 			getFees(1 + 1 + 1e12 + 1e4 + 1 + 1e4 + 3),
 			[]int64{1, 6, 1, 5, 1, 1, 1}, 120, false,
+		}, {
+			account, 160000000, 160000000, 1, 0, cpfpTxs,
+			[]*common.Hash{cpfpTxs[0].Tx.Hash(), cpfpTxs[1].Tx.Hash(), cpfpTxs[2].Tx.Hash()},
+			getFees(1e3 + 1e5 + 1e4),
+			[]int64{1, 1, 1, 1}, 120, false,
 		}, {
 			account, 160000000, 160000000, 1, 0, invalidFakeTxs,
 			[]*common.Hash{},
@@ -435,3 +526,56 @@ func TestNewBlockTemplate(t *testing.T) {
 		}
 	}
 }
+
+// TestProduceBlockFullGcMode ensures the states of the blocks produced from
+// templates and connected with BFFastAdd are kept for the retained blocks
+// only when the chain is in full gc mode.
+func TestProduceBlockFullGcMode(t *testing.T) {
+	const retention = 2
+	policy := Policy{
+		BlockProductedTimeOut: chaincfg.DefaultBlockProductedTimeOut,
+		TxConnectTimeOut:      chaincfg.DefaultTxConnectTimeOut,
+		UtxoValidateTimeOut:   chaincfg.DefaultUtxoValidateTimeOut,
+	}
+	chain, teardownFunc, err := newFakeChain(&chaincfg.DevelopNetParams,
+		chaincfg.GcModeFull, retention)
+	if err != nil {
+		t.Fatalf("newFakeChain error: %v", err)
+	}
+	defer teardownFunc()
+	if chain.IsArchive() {
+		t.Fatalf("chain is in archive mode")
+	}
+
+	g := NewBlkTmplGenerator(&policy, &fakeTxSource{make(map[common.Hash]*TxDesc)},
+		&fakeSigSource{make([]*asiutil.BlockSign, 0)}, chain)
+	account, _ := crypto.NewAccount("0xd0f0461b7b4d26cf370e6c73b58ef7fa26e8e30853a8cee901ed42cf0879cb6e")
+
+	triedb := chain.GetStateCache().TrieDB()
+	var roots []common.Hash
+	for round := uint32(1); round <= retention+3; round++ {
+		template, err := g.ProduceNewBlock(account, 160000000, 160000000,
+			time.Now().Unix(), round, 0, 5*100000)
+		if err != nil {
+			t.Fatalf("ProduceNewBlock in round %d error %v", round, err)
+		}
+		_, _, err = chain.ProcessBlock(template.Block, template.VBlock,
+			template.Receipts, template.Logs, common.BFFastAdd)
+		if err != nil {
+			t.Fatalf("ProcessBlock in round %d error %v", round, err)
+		}
+		roots = append(roots, template.Block.MsgBlock().Header.StateRoot)
+	}
+
+	for i, root := range roots {
+		want := i >= len(roots)-retention
+		if _, err := triedb.Node(root); (err == nil) != want {
+			t.Errorf("state of block #%d available %v, want %v", i, err == nil, want)
+		}
+	}
+
+	// Stopping the chain persists the state of the tip.
+	if err := chain.Stop(); err != nil {
+		t.Fatalf("Stop error %v", err)
+	}
+}
@@ -38,11 +38,17 @@ type Config struct {
 	Chain        *blockchain.BlockChain
 	TxMemPool    *mempool.TxPool
 	SigMemPool   *mempool.SigPool
+	EvidencePool *mempool.EvidencePool
 	ChainParams  *chaincfg.Params
 
 	DisableCheckpoints bool
+	StateSync          bool
 	MaxPeers           int
 
 	Account *crypto.Account
+
+	// Keys holds the accounts of the validator, the Account and the ones
+	// it rotates its key to.  The Account alone is used when it is nil.
+	Keys *crypto.KeyRing
 	BroadcastMessage func(msg protos.Message, exclPeers ...interface{})
 }
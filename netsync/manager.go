@@ -56,6 +56,10 @@ const (
 
 	maxRequestedSigns = protos.MaxInvPerMsg
 
+	maxRejectedEvidence = 1000
+
+	maxRequestedEvidence = protos.MaxInvPerMsg
+
 	maxOrphanBlock = 20
 )
 
@@ -108,6 +112,14 @@ type sigMsg struct {
 	reply chan struct{}
 }
 
+// evidenceMsg packages an evidence message and the peer it came from together
+// so the block handler has access to that information.
+type evidenceMsg struct {
+	evidence *protos.MsgEvidence
+	peer     *peerpkg.Peer
+	reply    chan struct{}
+}
+
 // getSyncPeerMsg is a message type to be sent across the message channel for
 // retrieving the current sync peer.
 type getSyncPeerMsg struct {
@@ -165,8 +177,11 @@ type peerSyncState struct {
 	requestedTxns   map[common.Hash]struct{}
 	requestedBlocks map[common.Hash]struct{}
 	requestedSigns  map[common.Hash]struct{}
+	requestedEvidence map[common.Hash]struct{}
 	syncCandidate   bool
 	orphanBlocks    int32
+
+	requestedVBlocks map[common.Hash]struct{}
 }
 
 // SyncManager is used to communicate block related messages with peers. The
@@ -181,6 +196,7 @@ type SyncManager struct {
 	chain          *blockchain.BlockChain
 	txMemPool      *mempool.TxPool
 	sigMemPool     *mempool.SigPool
+	evidencePool   *mempool.EvidencePool
 	chainParams    *chaincfg.Params
 	progressLogger *blockProgressLogger
 	msgChan        chan interface{}
@@ -192,6 +208,8 @@ type SyncManager struct {
 	requestedTxns    map[common.Hash]struct{}
 	rejectedSigns    map[common.Hash]struct{}
 	requestedSigns   map[common.Hash]struct{}
+	rejectedEvidence  map[common.Hash]struct{}
+	requestedEvidence map[common.Hash]struct{}
 	requestedBlocks  map[common.Hash]struct{}
 	syncPeer         *peerpkg.Peer
 	peerStates       map[*peerpkg.Peer]*peerSyncState
@@ -203,7 +221,11 @@ type SyncManager struct {
 	startHeader      *list.Element
 	nextCheckpoint   *chaincfg.Checkpoint
 
-	account      *crypto.Account
+	// The following fields are used for state sync.
+	stateSyncEnabled bool
+	stateSync        *stateSync
+
+	keys         *crypto.KeyRing
 	signedHeight map[int32]interface{}
 	tipHeight    int32
 	BroadcastMessage func(msg protos.Message, exclPeers ...interface{})
@@ -314,6 +336,10 @@ func (sm *SyncManager) startSync() {
 
 	// Start syncing from the best peer if one was selected.
 	if bestPeer != nil {
+		if !sm.maybeStartStateSync(bestPeer, best) {
+			return
+		}
+
 		// Clear the requestedBlocks if the sync peer changes, otherwise
 		// we may ignore blocks we need that the last sync peer failed
 		// to send.
@@ -346,7 +372,10 @@ func (sm *SyncManager) startSync() {
 		// and fully validate them.  Finally, regression test mode does
 		// not support the headers-first approach so do normal block
 		// downloads when in regression test mode.
-		if sm.nextCheckpoint != nil &&
+		if sm.downloadingState() {
+			// The blocks are requested again once the state is
+			// downloaded.
+		} else if sm.nextCheckpoint != nil &&
 			best.Height < sm.nextCheckpoint.Height {
 
 			bestPeer.PushGetHeadersMsg(locator, sm.nextCheckpoint.Hash)
@@ -399,12 +428,20 @@ func (sm *SyncManager) handleNewPeerMsg(peer *peerpkg.Peer) {
 		requestedTxns:   make(map[common.Hash]struct{}),
 		requestedBlocks: make(map[common.Hash]struct{}),
 		requestedSigns:  make(map[common.Hash]struct{}),
+		requestedEvidence: make(map[common.Hash]struct{}),
+
+		requestedVBlocks: make(map[common.Hash]struct{}),
 	}
 
 	// Start syncing by choosing the best candidate if needed.
 	if isSyncCandidate && sm.syncPeer == nil {
 		sm.startSync()
 	}
+
+	// Download the state from the new peer too.
+	if sm.downloadingState() {
+		sm.requestNodeData()
+	}
 }
 
 // handleStallSample will switch to a new sync peer if the current one has
@@ -416,6 +453,14 @@ func (sm *SyncManager) handleStallSample() {
 		return
 	}
 
+	// Request the state nodes which were not delivered in time again, or
+	// the virtual block the next block waits for.
+	if sm.downloadingState() {
+		sm.expireNodeDataRequests()
+	} else if sm.stateSync != nil {
+		sm.requestMissingVBlocks()
+	}
+
 	// If we don't have an active sync peer, exit early.
 	if sm.syncPeer == nil {
 		return
@@ -476,6 +521,9 @@ func (sm *SyncManager) handleDonePeerMsg(peer *peerpkg.Peer) {
 
 	log.Infof("Lost peer %s", peer)
 	sm.clearRequestedState(state)
+	if sm.stateSync != nil {
+		sm.stateSyncDonePeer(peer)
+	}
 
 	if peer == sm.syncPeer {
 		// Update the sync peer. The server has already disconnected the
@@ -498,6 +546,10 @@ func (sm *SyncManager) clearRequestedState(state *peerSyncState) {
 		delete(sm.requestedSigns, sigHah)
 	}
 
+	for evidenceHash := range state.requestedEvidence {
+		delete(sm.requestedEvidence, evidenceHash)
+	}
+
 	// Remove requested blocks from the global map so that they will be
 	// fetched from elsewhere next time we get an inv.
 	// TODO: we could possibly here check which peers have these blocks
@@ -655,6 +707,56 @@ func (sm *SyncManager) pushErrorMsg(peer *peerpkg.Peer, rejectMap map[common.Has
 	peer.PushRejectMsg(protos.CmdSig, code, reason, hash, false)
 }
 
+// handleEvidenceMsg handles evidence messages from all peers.
+func (sm *SyncManager) handleEvidenceMsg(emsg *evidenceMsg) {
+	peer := emsg.peer
+	state, exists := sm.peerStates[peer]
+	if !exists {
+		log.Warnf("Received evidence message from unknown peer %s", peer)
+		return
+	}
+
+	evidenceHash := emsg.evidence.Hash()
+	if _, exists = sm.rejectedEvidence[evidenceHash]; exists {
+		log.Debugf("Ignoring unsolicited previously rejected "+
+			"evidence %v from %s", evidenceHash, peer)
+		return
+	}
+
+	delete(state.requestedEvidence, evidenceHash)
+	delete(sm.requestedEvidence, evidenceHash)
+
+	if sm.evidencePool == nil {
+		return
+	}
+	if _, err := sm.evidencePool.ProcessEvidence(emsg.evidence); err != nil {
+		sm.rejectedEvidence[evidenceHash] = struct{}{}
+		sm.limitMap(sm.rejectedEvidence, maxRejectedEvidence)
+
+		log.Debugf("Rejected evidence %v from %s: %v", evidenceHash, peer, err)
+		code, reason := mempool.ErrToRejectErr(err)
+		peer.PushRejectMsg(protos.CmdEvidence, code, reason, &evidenceHash, false)
+	}
+}
+
+// detectDoubleProposal adds the evidence of a double proposal to the evidence
+// pool when the producer of the passed block already produced another block
+// of the main chain for the same round and slot.
+func (sm *SyncManager) detectDoubleProposal(block *asiutil.Block) {
+	if sm.evidencePool == nil {
+		return
+	}
+	evidence := sm.chain.DetectDoubleProposal(&block.MsgBlock().Header)
+	if evidence == nil {
+		return
+	}
+	if _, err := sm.evidencePool.ProcessEvidence(evidence); err != nil {
+		log.Debugf("Rejected evidence of double proposal by %v at round "+
+			"%d slot %d: %v", evidence.Offender(), evidence.Headers[0].Round,
+			evidence.Headers[0].SlotIndex, err)
+	}
+}
+
 //current returns the result by checkCurrent and store it.
 func (sm *SyncManager) current() bool{
 	cur := sm.checkCurrent(false)
@@ -741,6 +843,22 @@ func (sm *SyncManager) handleBlockMsg(bmsg *blockMsg) {
 	delete(state.requestedBlocks, *blockHash)
 	delete(sm.requestedBlocks, *blockHash)
 
+	sm.detectDoubleProposal(bmsg.block)
+
+	// During state sync, the blocks up to the pivot are queued until enough
+	// peers agree on their virtual block, then connected without executing
+	// them.
+	if sm.stateSync != nil {
+		if sm.queueStateSyncBlock(bmsg.block, peer) {
+			if peer == sm.syncPeer {
+				sm.lastProgressTime = time.Now()
+			}
+			sm.connectStateSyncBlocks()
+			sm.continueHeadersFirst(peer, state, blockHash, isCheckpointBlock)
+		}
+		return
+	}
+
 	prevBlock := &bmsg.block.MsgBlock().Header.PrevBlock
 	if !sm.chain.MainChainHasBlock(prevBlock) && !sm.chain.IsCurrent() {
 		state.orphanBlocks++
@@ -845,6 +963,13 @@ func (sm *SyncManager) handleBlockMsg(bmsg *blockMsg) {
 		}
 	}
 
+	sm.continueHeadersFirst(peer, state, blockHash, isCheckpointBlock)
+}
+
+// continueHeadersFirst requests the next blocks or headers from the passed
+// peer after it sent the passed block, when in headers-first mode.
+func (sm *SyncManager) continueHeadersFirst(peer *peerpkg.Peer, state *peerSyncState,
+	blockHash *common.Hash, isCheckpointBlock bool) {
 	// Nothing more to do if we aren't in headers-first mode.
 	if !sm.headersFirstMode {
 		return
@@ -889,7 +1014,7 @@ func (sm *SyncManager) handleBlockMsg(bmsg *blockMsg) {
 	sm.headerList.Init()
 	log.Infof("Reached the final checkpoint -- switching to normal mode")
 	locator := blockchain.BlockLocator([]*common.Hash{blockHash})
-	err = peer.PushGetBlocksMsg(locator, &zeroHash)
+	err := peer.PushGetBlocksMsg(locator, &zeroHash)
 	if err != nil {
 		log.Warnf("Failed to send getblocks message to peer %s: %v",
 			peer.Addr(), err)
@@ -906,10 +1031,16 @@ func (sm *SyncManager) fetchHeaderBlocks() {
 		return
 	}
 
+	// The blocks are fetched again once the state is downloaded.
+	if sm.downloadingState() {
+		return
+	}
+
 	// Build up a getdata request for the list of blocks the headers
 	// describe.  The size hint will be limited to protos.MaxInvPerMsg by
 	// the function, so no need to double check it here.
 	gdmsg := protos.NewMsgGetDataSizeHint(uint(sm.headerList.Len()))
+	var vblockHashes []*common.Hash
 	numRequested := 0
 	for e := sm.startHeader; e != nil; e = e.Next() {
 		node, ok := e.Value.(*headerNode)
@@ -918,6 +1049,11 @@ func (sm *SyncManager) fetchHeaderBlocks() {
 			continue
 		}
 
+		// Stop at the pivot during state sync.
+		if sm.stateSync != nil && node.height > sm.stateSync.pivot {
+			break
+		}
+
 		iv := protos.NewInvVect(protos.InvTypeBlock, node.hash)
 		haveInv, err := sm.haveInventory(iv)
 		if err != nil {
@@ -931,6 +1067,12 @@ func (sm *SyncManager) fetchHeaderBlocks() {
 			sm.requestedBlocks[*node.hash] = struct{}{}
 			syncPeerState.requestedBlocks[*node.hash] = struct{}{}
 
+			// The virtual block is sent before the block.
+			if sm.stateSync != nil {
+				syncPeerState.requestedVBlocks[*node.hash] = struct{}{}
+				gdmsg.AddInvVect(protos.NewInvVect(protos.InvTypeVBlock, node.hash))
+				vblockHashes = append(vblockHashes, node.hash)
+			}
 			gdmsg.AddInvVect(iv)
 			numRequested++
 		}
@@ -941,6 +1083,9 @@ func (sm *SyncManager) fetchHeaderBlocks() {
 	}
 	if len(gdmsg.InvList) > 0 {
 		sm.syncPeer.QueueMessage(gdmsg, nil)
+		if sm.stateSync != nil {
+			sm.requestVBlockWitnesses(vblockHashes, sm.syncPeer)
+		}
 	}
 }
 
@@ -1097,6 +1242,13 @@ func (sm *SyncManager) haveInventory(invVect *protos.InvVect) (bool, error) {
 			return true, nil
 		}
 
+		return false, nil
+
+	case protos.InvTypeEvidence:
+		if sm.evidencePool == nil || sm.evidencePool.HaveEvidence(&invVect.Hash) {
+			return true, nil
+		}
+
 		return false, nil
 	}
 
@@ -1152,6 +1304,11 @@ func (sm *SyncManager) handleInvMsg(imsg *invMsg) {
 
 	// Don't get the data of a single inventory when the chain is not current.
 	if lastBlock != -1 && !sm.chain.IsCurrent() && len(invVects) == 1  {
+		// The blocks are requested again once the state is downloaded.
+		if sm.downloadingState() {
+			return
+		}
+
 		// Send the getblock message when the number of blocks currently being
 		// requested is 0.
 		if len(state.requestedBlocks) == 0 {
@@ -1172,6 +1329,7 @@ func (sm *SyncManager) handleInvMsg(imsg *invMsg) {
 		case protos.InvTypeBlock:
 		case protos.InvTypeTx:
 		case protos.InvTypeSignature:
+		case protos.InvTypeEvidence:
 		case protos.InvTypeTxForbidden:
 			sm.txMemPool.UpdateForbiddenTxs([]*common.Hash{&iv.Hash}, -1)
 			continue
@@ -1188,6 +1346,12 @@ func (sm *SyncManager) handleInvMsg(imsg *invMsg) {
 			continue
 		}
 
+		// Ignore blocks while downloading the state, they are
+		// requested again once it is complete.
+		if iv.Type == protos.InvTypeBlock && sm.downloadingState() {
+			continue
+		}
+
 		// Request the inventory if we don't already have it.
 		haveInv, err := sm.haveInventory(iv)
 		if err != nil {
@@ -1204,6 +1368,13 @@ func (sm *SyncManager) handleInvMsg(imsg *invMsg) {
 					continue
 				}
 			}
+			if iv.Type == protos.InvTypeEvidence {
+				// Skip the evidence if it has already been
+				// rejected.
+				if _, exists := sm.rejectedEvidence[iv.Hash]; exists {
+					continue
+				}
+			}
 
 			// Add it to the request queue.
 			state.requestQueue = append(state.requestQueue, iv)
@@ -1255,6 +1426,7 @@ func (sm *SyncManager) handleInvMsg(imsg *invMsg) {
 	// the request will be requested on the next inv message.
 	numRequested := 0
 	gdmsg := protos.NewMsgGetData()
+	var vblockHashes []*common.Hash
 	requestQueue := state.requestQueue
 	for len(requestQueue) != 0 {
 		iv := requestQueue[0]
@@ -1270,6 +1442,12 @@ func (sm *SyncManager) handleInvMsg(imsg *invMsg) {
 				sm.limitMap(sm.requestedBlocks, maxRequestedBlocks)
 				state.requestedBlocks[iv.Hash] = struct{}{}
 
+				// The virtual block is sent before the block.
+				if sm.stateSync != nil {
+					state.requestedVBlocks[iv.Hash] = struct{}{}
+					gdmsg.AddInvVect(protos.NewInvVect(protos.InvTypeVBlock, &iv.Hash))
+					vblockHashes = append(vblockHashes, &iv.Hash)
+				}
 				gdmsg.AddInvVect(iv)
 				numRequested++
 			}
@@ -1297,6 +1475,18 @@ func (sm *SyncManager) handleInvMsg(imsg *invMsg) {
 				numRequested++
 			}
 
+		case protos.InvTypeEvidence:
+			// Request the evidence if there is not already a
+			// pending request.
+			if _, exists := sm.requestedEvidence[iv.Hash]; !exists {
+				sm.requestedEvidence[iv.Hash] = struct{}{}
+				sm.limitMap(sm.requestedEvidence, maxRequestedEvidence)
+				state.requestedEvidence[iv.Hash] = struct{}{}
+
+				gdmsg.AddInvVect(iv)
+				numRequested++
+			}
+
 		}
 
 		if numRequested >= protos.MaxInvPerMsg {
@@ -1306,6 +1496,9 @@ func (sm *SyncManager) handleInvMsg(imsg *invMsg) {
 	state.requestQueue = requestQueue
 	if len(gdmsg.InvList) > 0 {
 		peer.QueueMessage(gdmsg, nil)
+		if sm.stateSync != nil {
+			sm.requestVBlockWitnesses(vblockHashes, peer)
+		}
 	}
 }
 
@@ -1352,10 +1545,20 @@ out:
 				sm.handleSigMsg(msg)
 				msg.reply <- struct{}{}
 
+			case *evidenceMsg:
+				sm.handleEvidenceMsg(msg)
+				msg.reply <- struct{}{}
+
 			case *blockMsg:
 				sm.handleBlockMsg(msg)
 				msg.reply <- struct{}{}
 
+			case *vblockMsg:
+				sm.handleVBlockMsg(msg)
+
+			case *nodeDataMsg:
+				sm.handleNodeDataMsg(msg)
+
 			case *invMsg:
 				sm.handleInvMsg(msg)
 
@@ -1484,7 +1687,8 @@ func (sm *SyncManager) handleBlockchainNotification(notification *blockchain.Not
 			sm.peerNotifier.AnnounceNewTransactions(acceptedTxs)
 		}
 
-		if sm.account != nil {
+		// Only the nodes holding a validator key sign the blocks.
+		if sm.keys != nil && len(sm.keys.Accounts()) > 0 {
 			sm.makeSignature(block)
 		}
 		sm.tipHeight = block.Height()
@@ -1564,6 +1768,19 @@ func (sm *SyncManager) QueueSig(sig *asiutil.BlockSign, peer *peerpkg.Peer, done
 	sm.msgChan <- &sigMsg{sig: sig, peer: peer, reply: done}
 }
 
+// QueueEvidence adds the passed evidence message and peer to the block
+// handling queue. Responds to the done channel argument after the evidence
+// message is processed.
+func (sm *SyncManager) QueueEvidence(evidence *protos.MsgEvidence, peer *peerpkg.Peer, done chan struct{}) {
+	// Don't accept more evidences if we're shutting down.
+	if atomic.LoadInt32(&sm.shutdown) != 0 {
+		done <- struct{}{}
+		return
+	}
+
+	sm.msgChan <- &evidenceMsg{evidence: evidence, peer: peer, reply: done}
+}
+
 // QueueBlock adds the passed block message and peer to the block handling
 // queue. Responds to the done channel argument after the block message is
 // processed.
@@ -1689,11 +1906,15 @@ func New(config *Config) (*SyncManager, error) {
 		chain:            config.Chain,
 		txMemPool:        config.TxMemPool,
 		sigMemPool:       config.SigMemPool,
+		evidencePool:     config.EvidencePool,
 		chainParams:      config.ChainParams,
+		stateSyncEnabled: config.StateSync,
 		rejectedTxns:     make(map[common.Hash]struct{}),
 		requestedTxns:    make(map[common.Hash]struct{}),
 		rejectedSigns:    make(map[common.Hash]struct{}),
 		requestedSigns:   make(map[common.Hash]struct{}),
+		rejectedEvidence:  make(map[common.Hash]struct{}),
+		requestedEvidence: make(map[common.Hash]struct{}),
 		requestedBlocks:  make(map[common.Hash]struct{}),
 		peerStates:       make(map[*peerpkg.Peer]*peerSyncState),
 		progressLogger:   newBlockProgressLogger("Processed", log),
@@ -1701,9 +1922,12 @@ func New(config *Config) (*SyncManager, error) {
 		headerList:       list.New(),
 		quit:             make(chan struct{}),
 		signedHeight:     make(map[int32]interface{}),
-		account:          config.Account,
+		keys:             config.Keys,
 		BroadcastMessage: config.BroadcastMessage,
 	}
+	if sm.keys == nil && config.Account != nil {
+		sm.keys, _ = crypto.NewKeyRing(config.Account, "")
+	}
 
 	best := sm.chain.BestSnapshot()
 	if !config.DisableCheckpoints {
@@ -1745,7 +1969,7 @@ func (sm *SyncManager) makeSignature(block *asiutil.Block) {
 		return
 	}
 	// self mined block is needn't make signature
-	if header.CoinBase == *sm.account.Address {
+	if sm.keys.Lookup(header.CoinBase) != nil {
 		return
 	}
 	//get the validators of current block:
@@ -1757,13 +1981,21 @@ func (sm *SyncManager) makeSignature(block *asiutil.Block) {
 		return
 	}
 
-	if _, ok := weightMap[*sm.account.Address]; !ok {
+	// sign with the key of the validator which is active in the round
+	var account *crypto.Account
+	for _, acc := range sm.keys.Accounts() {
+		if _, ok := weightMap[*acc.Address]; ok {
+			account = acc
+			break
+		}
+	}
+	if account == nil {
 		return
 	}
 
 	blockHash := block.MsgBlock().BlockHash()
 
-	signature, err := crypto.Sign(blockHash[:], (*ecdsa.PrivateKey)(&sm.account.PrivateKey))
+	signature, err := crypto.Sign(blockHash[:], (*ecdsa.PrivateKey)(&account.PrivateKey))
 	if err != nil {
 		log.Errorf("Sign error:%s.", err)
 		return
@@ -1773,7 +2005,7 @@ func (sm *SyncManager) makeSignature(block *asiutil.Block) {
 	copy(sigMsg.Signature[:], signature)
 	sigMsg.BlockHeight = header.Height
 	sigMsg.BlockHash = blockHash
-	sigMsg.Signer = *sm.account.Address
+	sigMsg.Signer = *account.Address
 
 	sig := asiutil.NewBlockSign(&sigMsg)
 
@@ -0,0 +1,603 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package netsync
+
+import (
+	"bytes"
+	"sync/atomic"
+	"time"
+
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/blockchain"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/crypto"
+	peerpkg "github.com/AsimovNetwork/asimov/peer"
+	"github.com/AsimovNetwork/asimov/protos"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/state"
+	"github.com/AsimovNetwork/asimov/vm/fvm/trie"
+)
+
+const (
+	// stateSyncVBlockPeers is the number of peers serving the state which
+	// must send the same virtual block before the block it belongs to is
+	// connected without executing it, since the headers do not commit to
+	// the virtual blocks.
+	stateSyncVBlockPeers = 2
+
+	// nodeDataTimeout is the time after which a getnodedata request which
+	// is not answered is sent to another peer.
+	nodeDataTimeout = 30 * time.Second
+
+	// stateLogInterval is the interval at which the progress of the state
+	// download is logged.
+	stateLogInterval = 10 * time.Second
+)
+
+// vblockMsg packages a virtual block and the peer it came from together so the
+// block handler has access to that information.
+type vblockMsg struct {
+	vblock *asiutil.VBlock
+	peer   *peerpkg.Peer
+}
+
+// nodeDataMsg packages a nodedata message and the peer it came from together
+// so the block handler has access to that information.
+type nodeDataMsg struct {
+	nodeData *protos.MsgNodeData
+	peer     *peerpkg.Peer
+}
+
+// nodeDataRequest is a getnodedata request waiting for its reply.
+type nodeDataRequest struct {
+	hashes []common.Hash
+	sent   time.Time
+}
+
+// stateSync tracks the download of the contract state of a pivot block.
+//
+// The pivot is the latest checkpoint, so the chain only connects the blocks up
+// to it which the checkpoint commits to.  They are connected without executing
+// them, using the virtual blocks sent by the peers, once stateSyncVBlockPeers
+// peers sent the same one.  Once the pivot is the best block, the states
+// needed to execute the next blocks are downloaded node by node from the peers
+// signalling common.SFNodeState.  The download of a state is driven by a
+// trie.Sync, which only accepts the nodes hashing to the ones it requested
+// starting from the root in the header of the pivot, and writes a node after
+// all of its children, so a state is complete once its root is written.
+type stateSync struct {
+	pivot int32
+
+	// vblocks are the virtual blocks sent by each peer, and blocks the
+	// blocks waiting for them, by hash of their parent.
+	vblocks    map[common.Hash]map[*peerpkg.Peer]*asiutil.VBlock
+	blocks     map[common.Hash]*asiutil.Block
+	distrusted map[*peerpkg.Peer]struct{}
+
+	roots    []common.Hash
+	sched    *trie.Sync
+	tasks    []common.Hash
+	requests map[*peerpkg.Peer]*nodeDataRequest
+	stale    map[*peerpkg.Peer]struct{}
+
+	nodes   int
+	size    int
+	start   time.Time
+	lastLog time.Time
+}
+
+// downloadingState returns whether the blocks are connected up to the pivot
+// and its state is being downloaded.
+func (sm *SyncManager) downloadingState() bool {
+	return sm.stateSync != nil && sm.stateSync.sched != nil
+}
+
+// stateSyncPeers returns the number of peers serving the state.
+func (sm *SyncManager) stateSyncPeers() int {
+	count := 0
+	for peer := range sm.peerStates {
+		if peer.Services()&common.SFNodeState == common.SFNodeState {
+			count++
+		}
+	}
+	return count
+}
+
+// maybeStartStateSync decides whether the state of a pivot block is downloaded
+// from the peers while syncing from the passed peer.  It is only the case when
+// state sync is enabled and the node either only has the genesis block or has
+// not the state of its best block, such as after a restart in the middle of a
+// state sync.  It returns false when the sync must wait for more peers serving
+// the state.
+func (sm *SyncManager) maybeStartStateSync(peer *peerpkg.Peer, best *blockchain.BestState) bool {
+	if !sm.stateSyncEnabled || sm.stateSync != nil {
+		return true
+	}
+
+	hasState := sm.chain.HasState(best.StateRoot)
+	if best.Height > 0 && hasState {
+		sm.stateSyncEnabled = false
+		return true
+	}
+
+	// The pivot is the latest checkpoint, which anchors the blocks which
+	// are not executed.
+	checkpoint := sm.chain.LatestCheckpoint()
+	if checkpoint == nil || checkpoint.Height < best.Height ||
+		(best.Height == 0 && checkpoint.Height > peer.LastBlock()) {
+		if hasState {
+			log.Infof("Not syncing the state, there is no checkpoint " +
+				"to anchor it to")
+			sm.stateSyncEnabled = false
+			return true
+		}
+		log.Errorf("The best block %v was connected without executing "+
+			"it and the checkpoint of the state sync is missing", best.Hash)
+		return false
+	}
+
+	// The best block was connected without executing it, so its state is
+	// downloaded right away.
+	if checkpoint.Height == best.Height {
+		sm.stateSync = newStateSync(best.Height)
+		sm.beginStateDownload(&best.Hash)
+		return true
+	}
+
+	// The virtual blocks of the blocks which are not executed are checked
+	// against several peers serving the state.
+	if peers := sm.stateSyncPeers(); peers < stateSyncVBlockPeers {
+		log.Infof("Waiting for %d peers serving the state, %d connected",
+			stateSyncVBlockPeers, peers)
+		return false
+	}
+
+	log.Infof("Syncing blocks up to checkpoint %v at height %d without "+
+		"executing them before downloading their state", checkpoint.Hash,
+		checkpoint.Height)
+	sm.stateSync = newStateSync(checkpoint.Height)
+	return true
+}
+
+// newStateSync returns a state sync with the passed pivot height.
+func newStateSync(pivot int32) *stateSync {
+	return &stateSync{
+		pivot:      pivot,
+		vblocks:    make(map[common.Hash]map[*peerpkg.Peer]*asiutil.VBlock),
+		blocks:     make(map[common.Hash]*asiutil.Block),
+		distrusted: make(map[*peerpkg.Peer]struct{}),
+		requests:   make(map[*peerpkg.Peer]*nodeDataRequest),
+		stale:      make(map[*peerpkg.Peer]struct{}),
+	}
+}
+
+// requestVBlockWitnesses requests the virtual blocks of the passed blocks from
+// other peers serving the state than the passed one, so they can be checked
+// against the ones it sends.
+func (sm *SyncManager) requestVBlockWitnesses(hashes []*common.Hash, from *peerpkg.Peer) {
+	if len(hashes) == 0 {
+		return
+	}
+	witnesses := 1
+	for peer, state := range sm.peerStates {
+		if witnesses >= stateSyncVBlockPeers {
+			return
+		}
+		if peer == from || peer.Services()&common.SFNodeState != common.SFNodeState {
+			continue
+		}
+		if _, exists := sm.stateSync.distrusted[peer]; exists {
+			continue
+		}
+
+		gdmsg := protos.NewMsgGetDataSizeHint(uint(len(hashes)))
+		for _, hash := range hashes {
+			state.requestedVBlocks[*hash] = struct{}{}
+			gdmsg.AddInvVect(protos.NewInvVect(protos.InvTypeVBlock, hash))
+		}
+		peer.QueueMessage(gdmsg, nil)
+		witnesses++
+	}
+}
+
+// requestMissingVBlocks requests the virtual block of the block waiting to be
+// connected again from the peers serving the state which did not send it yet,
+// such as when a peer did not have it.
+func (sm *SyncManager) requestMissingVBlocks() {
+	ss := sm.stateSync
+	block, exists := ss.blocks[sm.chain.BestSnapshot().Hash]
+	if !exists {
+		return
+	}
+	hash := block.Hash()
+	for peer, state := range sm.peerStates {
+		if peer.Services()&common.SFNodeState != common.SFNodeState {
+			continue
+		}
+		if _, exists := ss.distrusted[peer]; exists {
+			continue
+		}
+		if _, exists := ss.vblocks[*hash][peer]; exists {
+			continue
+		}
+		state.requestedVBlocks[*hash] = struct{}{}
+		gdmsg := protos.NewMsgGetData()
+		gdmsg.AddInvVect(protos.NewInvVect(protos.InvTypeVBlock, hash))
+		peer.QueueMessage(gdmsg, nil)
+	}
+}
+
+// handleVBlockMsg handles vblock messages from all peers.  The virtual block is
+// kept until enough peers sent it and the block it belongs to arrives.
+func (sm *SyncManager) handleVBlockMsg(vmsg *vblockMsg) {
+	peer := vmsg.peer
+	state, exists := sm.peerStates[peer]
+	if !exists {
+		log.Warnf("Received vblock message from unknown peer %s", peer)
+		return
+	}
+
+	// If we didn't ask for this virtual block then the peer is misbehaving.
+	blockHash := vmsg.vblock.Hash()
+	if _, exists = state.requestedVBlocks[*blockHash]; !exists {
+		log.Warnf("Got unrequested virtual block %v from %s -- "+
+			"disconnecting", blockHash, peer.Addr())
+		peer.Disconnect()
+		return
+	}
+	delete(state.requestedVBlocks, *blockHash)
+
+	if sm.stateSync == nil || sm.downloadingState() ||
+		sm.chain.MainChainHasBlock(blockHash) {
+		return
+	}
+	vblocks, exists := sm.stateSync.vblocks[*blockHash]
+	if !exists {
+		vblocks = make(map[*peerpkg.Peer]*asiutil.VBlock)
+		sm.stateSync.vblocks[*blockHash] = vblocks
+	}
+	vblocks[peer] = vmsg.vblock
+	sm.connectStateSyncBlocks()
+}
+
+// queueStateSyncBlock queues the passed block until it can be connected
+// without executing it.  It returns false when the block is past the pivot, in
+// which case it is downloaded again once the state is available.
+func (sm *SyncManager) queueStateSyncBlock(block *asiutil.Block, peer *peerpkg.Peer) bool {
+	ss := sm.stateSync
+	if ss.sched != nil || block.Height() > ss.pivot {
+		return false
+	}
+	if block.Height() <= sm.chain.BestSnapshot().Height {
+		log.Debugf("Ignoring block %v from %s which is already "+
+			"connected during state sync", block.Hash(), peer)
+		return false
+	}
+	ss.blocks[block.MsgBlock().Header.PrevBlock] = block
+	return true
+}
+
+// agreedVBlock returns the virtual block of the passed block once
+// stateSyncVBlockPeers peers sent it.  When the peers sent different virtual
+// blocks, the ones lying can not be told apart, so all of them are
+// disconnected and the virtual block is requested from the other peers.
+func (sm *SyncManager) agreedVBlock(hash *common.Hash) (*asiutil.VBlock, bool) {
+	ss := sm.stateSync
+	var agreed *asiutil.VBlock
+	var agreedBytes []byte
+	conflict := false
+	for _, vblock := range ss.vblocks[*hash] {
+		serialized, err := vblock.Bytes()
+		if err != nil {
+			conflict = true
+			break
+		}
+		if agreed == nil {
+			agreed, agreedBytes = vblock, serialized
+			continue
+		}
+		if !bytes.Equal(serialized, agreedBytes) {
+			conflict = true
+			break
+		}
+	}
+
+	if conflict {
+		for peer := range ss.vblocks[*hash] {
+			log.Warnf("Peers sent different virtual blocks of block %v, "+
+				"including %s -- disconnecting", hash, peer.Addr())
+			ss.distrusted[peer] = struct{}{}
+			peer.Disconnect()
+		}
+		delete(ss.vblocks, *hash)
+		sm.requestMissingVBlocks()
+		return nil, false
+	}
+	if len(ss.vblocks[*hash]) < stateSyncVBlockPeers {
+		return nil, false
+	}
+	return agreed, true
+}
+
+// connectStateSyncBlocks connects the queued blocks extending the best chain
+// whose virtual block enough peers agree on, without executing them.  The
+// state download begins once the pivot is connected.
+func (sm *SyncManager) connectStateSyncBlocks() {
+	ss := sm.stateSync
+	for sm.stateSync == ss && ss.sched == nil {
+		best := sm.chain.BestSnapshot()
+		block, exists := ss.blocks[best.Hash]
+		if !exists {
+			return
+		}
+		vblock, ok := sm.agreedVBlock(block.Hash())
+		if !ok {
+			return
+		}
+		delete(ss.blocks, best.Hash)
+		delete(ss.vblocks, *block.Hash())
+
+		_, _, err := sm.chain.ProcessBlock(block, vblock, nil, nil, common.BFNoExecute)
+		if err != nil {
+			log.Warnf("Failed to connect block %v without executing it: %v",
+				block.Hash(), err)
+			return
+		}
+		sm.lastProgressTime = time.Now()
+		sm.progressLogger.LogBlockHeight(block)
+
+		if block.Height() == ss.pivot {
+			sm.beginStateDownload(block.Hash())
+		}
+	}
+}
+
+// beginStateDownload starts downloading the states needed to execute the
+// blocks after the passed pivot block.
+func (sm *SyncManager) beginStateDownload(hash *common.Hash) {
+	ss := sm.stateSync
+	roots, err := sm.chain.StateSyncRoots(hash)
+	if err != nil {
+		log.Errorf("Failed to get the states to download for block %v: %v",
+			hash, err)
+		sm.stateSync = nil
+		sm.stateSyncEnabled = false
+		return
+	}
+
+	log.Infof("Downloading the state of block %v at height %d from the "+
+		"peers", hash, ss.pivot)
+	ss.vblocks = nil
+	ss.blocks = nil
+	ss.roots = roots
+	ss.start = time.Now()
+	ss.lastLog = ss.start
+	sm.nextStateRoot()
+}
+
+// nextStateRoot starts downloading the next state which is not available yet,
+// or finishes the state sync when there is none left.
+func (sm *SyncManager) nextStateRoot() {
+	ss := sm.stateSync
+	for len(ss.roots) > 0 {
+		root := ss.roots[0]
+		ss.roots = ss.roots[1:]
+		if sm.chain.HasState(root) {
+			continue
+		}
+
+		log.Debugf("Downloading state %v", root)
+		ss.sched = state.NewStateSync(root, sm.chain.EthDB())
+		ss.tasks = nil
+		sm.requestNodeData()
+		return
+	}
+	sm.finishStateSync()
+}
+
+// requestNodeData sends a getnodedata request for the missing nodes of the
+// state being downloaded to every idle peer serving the state.
+func (sm *SyncManager) requestNodeData() {
+	ss := sm.stateSync
+	for peer := range sm.peerStates {
+		if peer.Services()&common.SFNodeState != common.SFNodeState {
+			continue
+		}
+		if _, exists := ss.requests[peer]; exists {
+			continue
+		}
+		if _, exists := ss.stale[peer]; exists {
+			continue
+		}
+
+		// Request the nodes which were not delivered first.
+		var hashes []common.Hash
+		if len(ss.tasks) > protos.MaxNodeDataPerMsg {
+			hashes = ss.tasks[:protos.MaxNodeDataPerMsg]
+			ss.tasks = ss.tasks[protos.MaxNodeDataPerMsg:]
+		} else {
+			hashes = ss.tasks
+			ss.tasks = nil
+		}
+		if len(hashes) < protos.MaxNodeDataPerMsg {
+			hashes = append(hashes, ss.sched.Missing(
+				protos.MaxNodeDataPerMsg-len(hashes))...)
+		}
+		if len(hashes) == 0 {
+			return
+		}
+
+		msg := protos.NewMsgGetNodeData()
+		for i := range hashes {
+			msg.AddHash(&hashes[i])
+		}
+		peer.QueueMessage(msg, nil)
+		ss.requests[peer] = &nodeDataRequest{hashes: hashes, sent: time.Now()}
+	}
+
+	if len(ss.requests) == 0 {
+		log.Warnf("No peer to download the state from")
+	}
+}
+
+// handleNodeDataMsg handles nodedata messages from all peers.  The delivered
+// nodes are written to the state database and the ones which were not are
+// requested again.
+func (sm *SyncManager) handleNodeDataMsg(nmsg *nodeDataMsg) {
+	peer := nmsg.peer
+	if !sm.downloadingState() {
+		log.Debugf("Ignoring node data from %s while not downloading "+
+			"the state", peer)
+		return
+	}
+	ss := sm.stateSync
+	req, exists := ss.requests[peer]
+	if !exists {
+		log.Debugf("Ignoring unrequested node data from %s", peer)
+		return
+	}
+	delete(ss.requests, peer)
+
+	delivered := make(map[common.Hash]struct{}, len(nmsg.nodeData.Data))
+	for _, data := range nmsg.nodeData.Data {
+		hash := crypto.Keccak256Hash(data)
+		_, _, err := ss.sched.Process([]trie.SyncResult{{Hash: hash, Data: data}})
+		switch err {
+		case nil:
+			ss.nodes++
+			ss.size += len(data)
+			delivered[hash] = struct{}{}
+		case trie.ErrAlreadyProcessed:
+			delivered[hash] = struct{}{}
+		case trie.ErrNotRequested:
+		default:
+			log.Warnf("Failed to process node data %v from %s: %v "+
+				"-- disconnecting", hash, peer.Addr(), err)
+			peer.Disconnect()
+		}
+	}
+	for _, hash := range req.hashes {
+		if _, exists := delivered[hash]; !exists {
+			ss.tasks = append(ss.tasks, hash)
+		}
+	}
+
+	// Stop asking a peer which has none of the requested nodes, it most
+	// likely does not keep this state anymore.
+	if len(delivered) == 0 {
+		log.Debugf("Peer %s has none of the requested state nodes", peer)
+		ss.stale[peer] = struct{}{}
+	}
+
+	batch := sm.chain.EthDB().NewBatch()
+	if _, err := ss.sched.Commit(batch); err != nil {
+		log.Errorf("Failed to commit the state nodes: %v", err)
+		return
+	}
+	if err := batch.Write(); err != nil {
+		log.Errorf("Failed to write the state nodes: %v", err)
+		return
+	}
+	sm.lastProgressTime = time.Now()
+
+	if time.Since(ss.lastLog) >= stateLogInterval {
+		log.Infof("Downloaded %d state nodes (%d bytes), %d pending",
+			ss.nodes, ss.size, ss.sched.Pending())
+		ss.lastLog = time.Now()
+	}
+
+	if ss.sched.Pending() == 0 {
+		sm.nextStateRoot()
+		return
+	}
+	sm.requestNodeData()
+}
+
+// expireNodeDataRequests requests the nodes of the getnodedata requests which
+// were not answered in time again, from other peers when possible.
+func (sm *SyncManager) expireNodeDataRequests() {
+	ss := sm.stateSync
+	for peer, req := range ss.requests {
+		if time.Since(req.sent) <= nodeDataTimeout {
+			continue
+		}
+		log.Debugf("Getnodedata request to %s timed out", peer)
+		ss.tasks = append(ss.tasks, req.hashes...)
+		ss.stale[peer] = struct{}{}
+		delete(ss.requests, peer)
+	}
+
+	// Give the peers another chance when all of them failed.
+	if len(ss.requests) == 0 {
+		ss.stale = make(map[*peerpkg.Peer]struct{})
+	}
+	sm.requestNodeData()
+}
+
+// stateSyncDonePeer requests the nodes in flight from the passed disconnected
+// peer again.
+func (sm *SyncManager) stateSyncDonePeer(peer *peerpkg.Peer) {
+	ss := sm.stateSync
+	delete(ss.distrusted, peer)
+	if !sm.downloadingState() {
+		return
+	}
+	if req, exists := ss.requests[peer]; exists {
+		ss.tasks = append(ss.tasks, req.hashes...)
+		delete(ss.requests, peer)
+	}
+	delete(ss.stale, peer)
+	sm.requestNodeData()
+}
+
+// finishStateSync ends the state sync and resumes downloading the blocks,
+// which are fully executed from now on.
+func (sm *SyncManager) finishStateSync() {
+	ss := sm.stateSync
+	log.Infof("Downloaded the state of height %d: %d nodes (%d bytes) in %v",
+		ss.pivot, ss.nodes, ss.size, time.Since(ss.start).Truncate(time.Second))
+	sm.stateSync = nil
+	sm.stateSyncEnabled = false
+
+	if sm.syncPeer == nil {
+		sm.startSync()
+		return
+	}
+	sm.lastProgressTime = time.Now()
+	if sm.headersFirstMode {
+		if sm.startHeader != nil {
+			sm.fetchHeaderBlocks()
+		}
+		return
+	}
+	locator, err := sm.chain.LatestBlockLocator()
+	if err != nil {
+		log.Errorf("Failed to get block locator for the latest block: %v",
+			err)
+		return
+	}
+	sm.syncPeer.PushGetBlocksMsg(locator, &zeroHash)
+}
+
+// QueueVBlock adds the passed virtual block and peer to the block handling
+// queue.  The virtual block must be queued before the block it belongs to.
+func (sm *SyncManager) QueueVBlock(vblock *asiutil.VBlock, peer *peerpkg.Peer) {
+	// No channel handling here because the block queued afterwards
+	// blocks the peer.
+	if atomic.LoadInt32(&sm.shutdown) != 0 {
+		return
+	}
+
+	sm.msgChan <- &vblockMsg{vblock: vblock, peer: peer}
+}
+
+// QueueNodeData adds the passed nodedata message and peer to the block handling
+// queue.
+func (sm *SyncManager) QueueNodeData(nodeData *protos.MsgNodeData, peer *peerpkg.Peer) {
+	if atomic.LoadInt32(&sm.shutdown) != 0 {
+		return
+	}
+
+	sm.msgChan <- &nodeDataMsg{nodeData: nodeData, peer: peer}
+}
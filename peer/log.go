@@ -85,6 +85,8 @@ func invSummary(invList []*protos.InvVect) string {
 			return fmt.Sprintf("tx %s", iv.Hash)
 		case protos.InvTypeSignature:
 			return fmt.Sprintf("signature %s", iv.Hash)
+		case protos.InvTypeEvidence:
+			return fmt.Sprintf("evidence %s", iv.Hash)
 		}
 
 		return fmt.Sprintf("unknown (%d) %s", uint32(iv.Type), iv.Hash)
@@ -165,6 +167,10 @@ func messageSummary(msg protos.Message) string {
 			msg.Header.Height, msg.BlockHash(),
 			header.Version, len(msg.Transactions), header.Timestamp)
 
+	case *protos.MsgEvidence:
+		return fmt.Sprintf("%s by %s at height %d, hash %s",
+			msg.Type, msg.Offender(), msg.Height(), msg.Hash())
+
 	case *protos.MsgInv:
 		return invSummary(msg.InvList)
 
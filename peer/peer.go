@@ -125,9 +125,25 @@ type MessageListeners struct {
 	// OnSig is invoked when a peer receives a signature bitcoin message.
 	OnSig func(p *Peer, msg *protos.MsgBlockSign)
 
+	// OnEvidence is invoked when a peer receives an evidence message.
+	OnEvidence func(p *Peer, msg *protos.MsgEvidence)
+
+	// OnVote is invoked when a peer receives a vote of the bft consensus.
+	OnVote func(p *Peer, msg *protos.MsgVote)
+
+	// OnProposal is invoked when a peer receives a proposal of the bft
+	// consensus.
+	OnProposal func(p *Peer, msg *protos.MsgProposal)
+
 	// OnBlock is invoked when a peer receives a block bitcoin message.
 	OnBlock func(p *Peer, msg *protos.MsgBlock, buf []byte)
 
+	// OnVBlock is invoked when a peer receives a vblock message.
+	OnVBlock func(p *Peer, msg *protos.MsgVirtualBlock)
+
+	// OnNodeData is invoked when a peer receives a nodedata message.
+	OnNodeData func(p *Peer, msg *protos.MsgNodeData)
+
 	// OnCFilter is invoked when a peer receives a cfilter bitcoin message.
 	OnCFilter func(p *Peer, msg *protos.MsgCFilter)
 
@@ -160,6 +176,9 @@ type MessageListeners struct {
 	// message.
 	OnGetHeaders func(p *Peer, msg *protos.MsgGetHeaders)
 
+	// OnGetNodeData is invoked when a peer receives a getnodedata message.
+	OnGetNodeData func(p *Peer, msg *protos.MsgGetNodeData)
+
 	// OnGetCFilters is invoked when a peer receives a getcfilters bitcoin
 	// message.
 	OnGetCFilters func(p *Peer, msg *protos.MsgGetCFilters)
@@ -1516,6 +1535,18 @@ out:
 			if p.cfg.Listeners.OnSig != nil {
 				p.cfg.Listeners.OnSig(p, msg)
 			}
+		case *protos.MsgEvidence:
+			if p.cfg.Listeners.OnEvidence != nil {
+				p.cfg.Listeners.OnEvidence(p, msg)
+			}
+		case *protos.MsgVote:
+			if p.cfg.Listeners.OnVote != nil {
+				p.cfg.Listeners.OnVote(p, msg)
+			}
+		case *protos.MsgProposal:
+			if p.cfg.Listeners.OnProposal != nil {
+				p.cfg.Listeners.OnProposal(p, msg)
+			}
 		case *protos.MsgBlock:
 			if p.cfg.Listeners.OnBlock != nil {
 				p.cfg.Listeners.OnBlock(p, msg, buf)
@@ -1551,6 +1582,21 @@ out:
 				p.cfg.Listeners.OnGetHeaders(p, msg)
 			}
 
+		case *protos.MsgGetNodeData:
+			if p.cfg.Listeners.OnGetNodeData != nil {
+				p.cfg.Listeners.OnGetNodeData(p, msg)
+			}
+
+		case *protos.MsgNodeData:
+			if p.cfg.Listeners.OnNodeData != nil {
+				p.cfg.Listeners.OnNodeData(p, msg)
+			}
+
+		case *protos.MsgVirtualBlock:
+			if p.cfg.Listeners.OnVBlock != nil {
+				p.cfg.Listeners.OnVBlock(p, msg)
+			}
+
 		case *protos.MsgGetCFilters:
 			if p.cfg.Listeners.OnGetCFilters != nil {
 				p.cfg.Listeners.OnGetCFilters(p, msg)
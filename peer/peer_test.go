@@ -390,6 +390,15 @@ func TestPeerListeners(t *testing.T) {
 			OnGetHeaders: func(p *peer.Peer, msg *protos.MsgGetHeaders) {
 				ok <- msg
 			},
+			OnGetNodeData: func(p *peer.Peer, msg *protos.MsgGetNodeData) {
+				ok <- msg
+			},
+			OnNodeData: func(p *peer.Peer, msg *protos.MsgNodeData) {
+				ok <- msg
+			},
+			OnVBlock: func(p *peer.Peer, msg *protos.MsgVirtualBlock) {
+				ok <- msg
+			},
 			OnGetCFilters: func(p *peer.Peer, msg *protos.MsgGetCFilters) {
 				ok <- msg
 			},
@@ -519,6 +528,18 @@ func TestPeerListeners(t *testing.T) {
 			"OnGetHeaders",
 			protos.NewMsgGetHeaders(),
 		},
+		{
+			"OnGetNodeData",
+			protos.NewMsgGetNodeData(),
+		},
+		{
+			"OnNodeData",
+			protos.NewMsgNodeData(),
+		},
+		{
+			"OnVBlock",
+			protos.NewMsgVirtualBlock(&common.Hash{}, &protos.MsgVBlock{}),
+		},
 		{
 			"OnGetCFilters",
 			protos.NewMsgGetCFilters(protos.GCSFilterRegular, 0, &common.Hash{}),
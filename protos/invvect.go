@@ -33,6 +33,8 @@ const (
 	InvTypeFilteredBlock        InvType = 3
 	InvTypeSignature			InvType = 4
 	InvTypeTxForbidden          InvType = 5
+	InvTypeVBlock               InvType = 6
+	InvTypeEvidence             InvType = 7
 )
 
 // Map of service flags back to their constant names for pretty printing.
@@ -42,6 +44,8 @@ var ivStrings = map[InvType]string{
 	InvTypeBlock:                "MSG_BLOCK",
 	InvTypeFilteredBlock:        "MSG_FILTERED_BLOCK",
 	InvTypeSignature:			 "MSG_SIGNATURE",
+	InvTypeVBlock:               "MSG_VBLOCK",
+	InvTypeEvidence:             "MSG_EVIDENCE",
 }
 
 // String returns the InvType in human-readable form.
@@ -24,6 +24,8 @@ func TestInvTypeStringer(t *testing.T) {
 		{InvTypeBlock, "MSG_BLOCK"},
 		{InvTypeFilteredBlock,"MSG_FILTERED_BLOCK"},
 		{InvTypeSignature,"MSG_SIGNATURE"},
+		{InvTypeVBlock, "MSG_VBLOCK"},
+		{InvTypeEvidence, "MSG_EVIDENCE"},
 		{0xffffffff, "Unknown InvType (4294967295)"},
 	}
 
@@ -54,6 +54,12 @@ const (
 	CmdCFilter      = "cfilter"
 	CmdCFHeaders    = "cfheaders"
 	CmdCFCheckpt    = "cfcheckpt"
+	CmdGetNodeData  = "getnodedata"
+	CmdNodeData     = "nodedata"
+	CmdVBlock       = "vblock"
+	CmdEvidence     = "evidence"
+	CmdVote         = "vote"
+	CmdProposal     = "proposal"
 )
 
 // MessageEncoding represents the protos message encoding format to be used.
@@ -118,6 +124,15 @@ func makeEmptyMessage(command string) (Message, error) {
 	case CmdSig:
 		msg = &MsgBlockSign{}
 
+	case CmdEvidence:
+		msg = &MsgEvidence{}
+
+	case CmdVote:
+		msg = &MsgVote{}
+
+	case CmdProposal:
+		msg = &MsgProposal{}
+
 	case CmdPing:
 		msg = &MsgPing{}
 
@@ -172,6 +187,15 @@ func makeEmptyMessage(command string) (Message, error) {
 	case CmdCFCheckpt:
 		msg = &MsgCFCheckpt{}
 
+	case CmdGetNodeData:
+		msg = &MsgGetNodeData{}
+
+	case CmdNodeData:
+		msg = &MsgNodeData{}
+
+	case CmdVBlock:
+		msg = &MsgVirtualBlock{}
+
 	default:
 		return nil, fmt.Errorf("unhandled command [%s]", command)
 	}
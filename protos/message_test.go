@@ -86,6 +86,10 @@ func TestMessage(t *testing.T) {
 		[]byte("payload"))
 	msgCFHeaders := NewMsgCFHeaders()
 	msgCFCheckpt := NewMsgCFCheckpt(GCSFilterRegular, &common.Hash{}, 0)
+	msgGetNodeData := NewMsgGetNodeData()
+	msgNodeData := &MsgNodeData{Data: [][]byte{}}
+	msgVirtualBlock := NewMsgVirtualBlock(&common.Hash{}, &MsgVBlock{
+		VTransactions: []*MsgTx{}})
 
 	tests := []struct {
 		in     Message          // Value to encode
@@ -121,6 +125,9 @@ func TestMessage(t *testing.T) {
 		{msgCFilter, msgCFilter, pver, common.MainNet, 61},
 		{msgCFHeaders, msgCFHeaders, pver, common.MainNet, 86},
 		{msgCFCheckpt, msgCFCheckpt, pver, common.MainNet, 54},
+		{msgGetNodeData, msgGetNodeData, pver, common.MainNet, 21},
+		{msgNodeData, msgNodeData, pver, common.MainNet, 21},
+		{msgVirtualBlock, msgVirtualBlock, pver, common.MainNet, 89},
 	}
 
 	t.Logf("Running %d tests", len(tests))
@@ -0,0 +1,257 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package protos
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/common/serialization"
+)
+
+// VoteType identifies the step of the bft consensus a vote is cast at.
+type VoteType uint8
+
+const (
+	// VotePrevote is a vote for the proposal of a round.
+	VotePrevote VoteType = 1
+
+	// VotePrecommit is a vote to commit the block which gathered the
+	// prevotes of more than two thirds of the validators in a round.
+	VotePrecommit VoteType = 2
+)
+
+// Map of vote types back to their constant names for pretty printing.
+var voteTypeStrings = map[VoteType]string{
+	VotePrevote:   "prevote",
+	VotePrecommit: "precommit",
+}
+
+// String returns the VoteType in human-readable form.
+func (t VoteType) String() string {
+	if s, ok := voteTypeStrings[t]; ok {
+		return s
+	}
+	return fmt.Sprintf("Unknown VoteType (%d)", uint8(t))
+}
+
+// voteSignedPayload is the length of the fields of a vote covered by its
+// signature.
+const voteSignedPayload = 1 + 4 + 4 + common.HashLength + common.AddressLength
+
+// votePayload is the length of a vote.
+const votePayload = voteSignedPayload + HashSignLen
+
+// MsgVote implements the Message interface and represents a vote of a
+// validator in a round of the bft consensus.  A vote for no block has the
+// zero block hash.
+type MsgVote struct {
+	Type      VoteType
+	Height    int32
+	Round     int32
+	BlockHash common.Hash
+	Validator common.Address
+	Signature [HashSignLen]byte
+}
+
+// SignHash returns the hash the validator signs, which covers all the fields
+// of the vote but the signature.
+func (msg *MsgVote) SignHash() common.Hash {
+	buf := bytes.NewBuffer(make([]byte, 0, voteSignedPayload))
+	_ = msg.serializeSigned(buf)
+	return common.DoubleHashH(buf.Bytes())
+}
+
+// Hash returns the hash of the serialized vote.
+func (msg *MsgVote) Hash() common.Hash {
+	buf := bytes.NewBuffer(make([]byte, 0, votePayload))
+	_ = msg.Serialize(buf)
+	return common.DoubleHashH(buf.Bytes())
+}
+
+// serializeSigned encodes the fields of the vote covered by its signature.
+func (msg *MsgVote) serializeSigned(w io.Writer) error {
+	if err := serialization.WriteUint8(w, uint8(msg.Type)); err != nil {
+		return err
+	}
+	if err := serialization.WriteUint32(w, uint32(msg.Height)); err != nil {
+		return err
+	}
+	if err := serialization.WriteUint32(w, uint32(msg.Round)); err != nil {
+		return err
+	}
+	if err := serialization.WriteNBytes(w, msg.BlockHash[:]); err != nil {
+		return err
+	}
+	return serialization.WriteNBytes(w, msg.Validator[:])
+}
+
+// Serialize encodes the vote to w using a format that is suitable for
+// long-term storage such as a database.
+func (msg *MsgVote) Serialize(w io.Writer) error {
+	if msg.Type != VotePrevote && msg.Type != VotePrecommit {
+		str := fmt.Sprintf("unknown vote type %d", msg.Type)
+		return messageError("MsgVote.Serialize", str)
+	}
+	if err := msg.serializeSigned(w); err != nil {
+		return err
+	}
+	return serialization.WriteNBytes(w, msg.Signature[:])
+}
+
+// Deserialize decodes a vote from r into the receiver using the format of
+// Serialize.
+func (msg *MsgVote) Deserialize(r io.Reader) error {
+	var voteType uint8
+	if err := serialization.ReadUint8(r, &voteType); err != nil {
+		return err
+	}
+	msg.Type = VoteType(voteType)
+	if msg.Type != VotePrevote && msg.Type != VotePrecommit {
+		str := fmt.Sprintf("unknown vote type %d", msg.Type)
+		return messageError("MsgVote.Deserialize", str)
+	}
+	var height, round uint32
+	if err := serialization.ReadUint32(r, &height); err != nil {
+		return err
+	}
+	if err := serialization.ReadUint32(r, &round); err != nil {
+		return err
+	}
+	msg.Height, msg.Round = int32(height), int32(round)
+	if err := serialization.ReadNBytes(r, msg.BlockHash[:], common.HashLength); err != nil {
+		return err
+	}
+	if err := serialization.ReadNBytes(r, msg.Validator[:], common.AddressLength); err != nil {
+		return err
+	}
+	return serialization.ReadNBytes(r, msg.Signature[:], HashSignLen)
+}
+
+// SerializeSize returns the number of bytes it would take to serialize the
+// vote.
+func (msg *MsgVote) SerializeSize() int {
+	return votePayload
+}
+
+// VVSDecode decodes r using the asimov protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgVote) VVSDecode(r io.Reader, pver uint32, enc MessageEncoding) error {
+	return msg.Deserialize(r)
+}
+
+// VVSEncode encodes the receiver to w using the asimov protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgVote) VVSEncode(w io.Writer, pver uint32, enc MessageEncoding) error {
+	return msg.Serialize(w)
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgVote) Command() string {
+	return CmdVote
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgVote) MaxPayloadLength(pver uint32) uint32 {
+	return votePayload
+}
+
+// proposalSignedPayload is the length of the fields of a proposal covered by
+// its signature.
+const proposalSignedPayload = 4 + 4 + common.HashLength
+
+// MsgProposal implements the Message interface and represents the block a
+// validator proposes in a round of the bft consensus.  POLRound is the round
+// in which the block gathered the prevotes of more than two thirds of the
+// validators when it is proposed again, or -1.  The proposal is signed by the
+// producer of the block.
+type MsgProposal struct {
+	Round     int32
+	POLRound  int32
+	Block     MsgBlock
+	Signature [HashSignLen]byte
+}
+
+// Height returns the height of the proposed block.
+func (msg *MsgProposal) Height() int32 {
+	return msg.Block.Header.Height
+}
+
+// SignHash returns the hash the proposer signs, which covers the rounds and
+// the hash of the block.
+func (msg *MsgProposal) SignHash() common.Hash {
+	buf := bytes.NewBuffer(make([]byte, 0, proposalSignedPayload))
+	_ = serialization.WriteUint32(buf, uint32(msg.Round))
+	_ = serialization.WriteUint32(buf, uint32(msg.POLRound))
+	blockHash := msg.Block.BlockHash()
+	_ = serialization.WriteNBytes(buf, blockHash[:])
+	return common.DoubleHashH(buf.Bytes())
+}
+
+// Serialize encodes the proposal to w using a format that is suitable for
+// long-term storage such as a database.
+func (msg *MsgProposal) Serialize(w io.Writer) error {
+	if err := serialization.WriteUint32(w, uint32(msg.Round)); err != nil {
+		return err
+	}
+	if err := serialization.WriteUint32(w, uint32(msg.POLRound)); err != nil {
+		return err
+	}
+	if err := serialization.WriteNBytes(w, msg.Signature[:]); err != nil {
+		return err
+	}
+	return msg.Block.Serialize(w)
+}
+
+// Deserialize decodes a proposal from r into the receiver using the format of
+// Serialize.
+func (msg *MsgProposal) Deserialize(r io.Reader) error {
+	var round, polRound uint32
+	if err := serialization.ReadUint32(r, &round); err != nil {
+		return err
+	}
+	if err := serialization.ReadUint32(r, &polRound); err != nil {
+		return err
+	}
+	msg.Round, msg.POLRound = int32(round), int32(polRound)
+	if err := serialization.ReadNBytes(r, msg.Signature[:], HashSignLen); err != nil {
+		return err
+	}
+	return msg.Block.Deserialize(r)
+}
+
+// SerializeSize returns the number of bytes it would take to serialize the
+// proposal.
+func (msg *MsgProposal) SerializeSize() int {
+	return 8 + HashSignLen + msg.Block.SerializeSize()
+}
+
+// VVSDecode decodes r using the asimov protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgProposal) VVSDecode(r io.Reader, pver uint32, enc MessageEncoding) error {
+	return msg.Deserialize(r)
+}
+
+// VVSEncode encodes the receiver to w using the asimov protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgProposal) VVSEncode(w io.Writer, pver uint32, enc MessageEncoding) error {
+	return msg.Serialize(w)
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgProposal) Command() string {
+	return CmdProposal
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgProposal) MaxPayloadLength(pver uint32) uint32 {
+	return 8 + HashSignLen + MaxBlockPayload
+}
@@ -0,0 +1,180 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package protos
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/AsimovNetwork/asimov/common"
+)
+
+// TestMsgVote tests the encoding of the votes and the fields covered by their
+// signature.
+func TestMsgVote(t *testing.T) {
+	vote := &MsgVote{
+		Type:      VotePrecommit,
+		Height:    10,
+		Round:     2,
+		BlockHash: common.Hash{0x01},
+		Validator: common.Address{0x66, 0x01},
+		Signature: [HashSignLen]byte{0x02},
+	}
+
+	var buf bytes.Buffer
+	if err := vote.VVSEncode(&buf, common.ProtocolVersion, BaseEncoding); err != nil {
+		t.Fatalf("VVSEncode error %v", err)
+	}
+	if buf.Len() != vote.SerializeSize() ||
+		uint32(buf.Len()) != vote.MaxPayloadLength(common.ProtocolVersion) {
+		t.Errorf("encoded %d bytes, SerializeSize %d", buf.Len(), vote.SerializeSize())
+	}
+	var msg MsgVote
+	if err := msg.VVSDecode(bytes.NewReader(buf.Bytes()), common.ProtocolVersion, BaseEncoding); err != nil {
+		t.Fatalf("VVSDecode error %v", err)
+	}
+	if !reflect.DeepEqual(&msg, vote) {
+		t.Errorf("decoded %v, want %v", msg, vote)
+	}
+
+	// The signature is not covered by the signed hash, every other field
+	// is.
+	signHash := vote.SignHash()
+	msg.Signature[0] = 0x03
+	if msg.SignHash() != signHash || msg.Hash() == vote.Hash() {
+		t.Errorf("the signature changed the signed hash")
+	}
+	for _, tamper := range []func(v *MsgVote){
+		func(v *MsgVote) { v.Type = VotePrevote },
+		func(v *MsgVote) { v.Height++ },
+		func(v *MsgVote) { v.Round++ },
+		func(v *MsgVote) { v.BlockHash = common.Hash{} },
+		func(v *MsgVote) { v.Validator[1] ^= 0xff },
+	} {
+		tampered := *vote
+		tamper(&tampered)
+		if tampered.SignHash() == signHash {
+			t.Errorf("vote %v has the signed hash of %v", tampered, vote)
+		}
+	}
+
+	// An unknown type is refused.
+	if err := msg.Deserialize(bytes.NewReader([]byte{0x03})); err == nil {
+		t.Errorf("Deserialize accepted an unknown vote type")
+	}
+	msg.Type = 0
+	if err := msg.Serialize(&bytes.Buffer{}); err == nil {
+		t.Errorf("Serialize accepted an unknown vote type")
+	}
+}
+
+// TestMsgProposal tests the encoding of the proposals.
+func TestMsgProposal(t *testing.T) {
+	proposal := &MsgProposal{
+		Round:    3,
+		POLRound: -1,
+		Block: MsgBlock{
+			Header: BlockHeader{Height: 10, Round: 4, SlotIndex: 5},
+		},
+		Signature: [HashSignLen]byte{0x02},
+	}
+
+	var buf bytes.Buffer
+	if err := proposal.VVSEncode(&buf, common.ProtocolVersion, BaseEncoding); err != nil {
+		t.Fatalf("VVSEncode error %v", err)
+	}
+	if buf.Len() != proposal.SerializeSize() {
+		t.Errorf("encoded %d bytes, SerializeSize %d", buf.Len(), proposal.SerializeSize())
+	}
+	var msg MsgProposal
+	if err := msg.VVSDecode(bytes.NewReader(buf.Bytes()), common.ProtocolVersion, BaseEncoding); err != nil {
+		t.Fatalf("VVSDecode error %v", err)
+	}
+	if msg.Round != proposal.Round || msg.POLRound != proposal.POLRound ||
+		msg.Signature != proposal.Signature || msg.Height() != 10 ||
+		msg.Block.BlockHash() != proposal.Block.BlockHash() {
+		t.Errorf("decoded %v, want %v", msg, proposal)
+	}
+	if msg.SignHash() != proposal.SignHash() {
+		t.Errorf("decoded proposal has another signed hash")
+	}
+	msg.POLRound = 1
+	if msg.SignHash() == proposal.SignHash() {
+		t.Errorf("the POL round is not covered by the signed hash")
+	}
+}
+
+// TestMsgBlockCommit tests the encoding of the commit certificate of a block,
+// which is only written when it holds votes.
+func TestMsgBlockCommit(t *testing.T) {
+	block := &MsgBlock{
+		Header: BlockHeader{Height: 10, Round: 4, SlotIndex: 5},
+	}
+	var plain bytes.Buffer
+	if err := block.Serialize(&plain); err != nil {
+		t.Fatalf("Serialize error %v", err)
+	}
+
+	block.Commit = []*MsgVote{{
+		Type:      VotePrecommit,
+		Height:    10,
+		BlockHash: block.BlockHash(),
+		Validator: common.Address{0x66, 0x01},
+		Signature: [HashSignLen]byte{0x02},
+	}, {
+		Type:      VotePrecommit,
+		Height:    10,
+		BlockHash: block.BlockHash(),
+		Validator: common.Address{0x66, 0x02},
+		Signature: [HashSignLen]byte{0x03},
+	}}
+	var buf bytes.Buffer
+	if err := block.Serialize(&buf); err != nil {
+		t.Fatalf("Serialize error %v", err)
+	}
+	if buf.Len() != block.SerializeSize() {
+		t.Errorf("encoded %d bytes, SerializeSize %d", buf.Len(), block.SerializeSize())
+	}
+	if !bytes.HasPrefix(buf.Bytes(), plain.Bytes()) {
+		t.Errorf("the commit certificate changed the encoding of the block")
+	}
+
+	var msg MsgBlock
+	if err := msg.Deserialize(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("Deserialize error %v", err)
+	}
+	if !reflect.DeepEqual(msg.Commit, block.Commit) {
+		t.Errorf("decoded commit %v, want %v", msg.Commit, block.Commit)
+	}
+	if msg.BlockHash() != block.BlockHash() {
+		t.Errorf("the commit certificate changed the block hash")
+	}
+	var txLocBlock MsgBlock
+	if _, err := txLocBlock.DeserializeTxLoc(bytes.NewBuffer(buf.Bytes())); err != nil {
+		t.Fatalf("DeserializeTxLoc error %v", err)
+	}
+	if !reflect.DeepEqual(txLocBlock.Commit, block.Commit) {
+		t.Errorf("DeserializeTxLoc decoded commit %v, want %v", txLocBlock.Commit, block.Commit)
+	}
+
+	// A block without certificate decodes without one.
+	if err := msg.Deserialize(bytes.NewReader(plain.Bytes())); err != nil {
+		t.Fatalf("Deserialize error %v", err)
+	}
+	if msg.Commit != nil {
+		t.Errorf("decoded commit %v, want none", msg.Commit)
+	}
+
+	// An empty or truncated certificate is refused.
+	empty := append(append([]byte{}, plain.Bytes()...), 0x00)
+	if err := msg.Deserialize(bytes.NewReader(empty)); err == nil {
+		t.Errorf("Deserialize accepted an empty commit certificate")
+	}
+	truncated := buf.Bytes()[:buf.Len()-1]
+	if err := msg.Deserialize(bytes.NewReader(truncated)); err == nil {
+		t.Errorf("Deserialize accepted a truncated commit certificate")
+	}
+}
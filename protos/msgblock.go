@@ -47,8 +47,18 @@ type MsgBlock struct {
 	Bloom        types.Bloom
 	Transactions []*MsgTx
 	PreBlockSigs BlockSignList // collect signatures for ancestors
+
+	// Commit holds the precommits of more than two thirds of the validators
+	// for the block on chains using the bft consensus.  It is not covered by
+	// the block hash, since the precommits sign it, and it is only encoded
+	// when it is not empty.
+	Commit []*MsgVote
 }
 
+// maxCommitVotes is the maximum number of votes a commit certificate could
+// possibly hold.
+const maxCommitVotes = MaxBlockPayload / votePayload
+
 // AddTransaction adds a transaction to the message.
 func (msg *MsgBlock) AddTransaction(tx *MsgTx) {
 	msg.Transactions = append(msg.Transactions, tx)
@@ -99,6 +109,12 @@ func (msg *MsgBlock) VVSDecode(r io.Reader, pver uint32, enc MessageEncoding) er
 		msg.Transactions = append(msg.Transactions, &tx)
 	}
 
+	return msg.readSigns(r, "MsgBlock.VVSDecode")
+}
+
+// readSigns decodes the signatures of the ancestors and the optional commit
+// certificate which follow the transactions of a block.
+func (msg *MsgBlock) readSigns(r io.Reader, op string) error {
 	n, err := serialization.ReadVarUint(r)
 	if err != nil {
 		return err
@@ -114,6 +130,31 @@ func (msg *MsgBlock) VVSDecode(r io.Reader, pver uint32, enc MessageEncoding) er
 		msg.PreBlockSigs[i] = &msgSigns[i]
 	}
 
+	// The commit certificate is optional, so a block may end here.
+	n, err = serialization.ReadVarUint(r)
+	if err == io.EOF {
+		msg.Commit = nil
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if n == 0 || n > maxCommitVotes {
+		str := fmt.Sprintf("invalid number of votes in the commit "+
+			"certificate [count %d, max %d]", n, maxCommitVotes)
+		return messageError(op, str)
+	}
+
+	votes := make([]MsgVote, n)
+	msg.Commit = make([]*MsgVote, n)
+	for i := 0; i < int(n); i++ {
+		err = votes[i].Deserialize(r)
+		if err != nil {
+			return err
+		}
+		msg.Commit[i] = &votes[i]
+	}
+
 	return nil
 }
 
@@ -188,6 +229,10 @@ func (msg *MsgBlock) DeserializeTxLoc(r *bytes.Buffer) ([]TxLoc, error) {
 		txLocs[i].TxLen = (fullLen - r.Len()) - txLocs[i].TxStart
 	}
 
+	if err := msg.readSigns(r, "MsgBlock.DeserializeTxLoc"); err != nil {
+		return nil, err
+	}
+
 	return txLocs, nil
 }
 
@@ -231,6 +276,20 @@ func (msg *MsgBlock) VVSEncode(w io.Writer, pver uint32, enc MessageEncoding) er
 		}
 	}
 
+	if len(msg.Commit) == 0 {
+		return nil
+	}
+	err = serialization.WriteVarUint(w, uint64(len(msg.Commit)))
+	if err != nil {
+		return err
+	}
+	for _, vote := range msg.Commit {
+		err := vote.Serialize(w)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -271,6 +330,11 @@ func (msg *MsgBlock) SerializeSize() int {
 		n += sig.SerializeSize()
 	}
 
+	if len(msg.Commit) > 0 {
+		n += serialization.VarIntSerializeSize(uint64(len(msg.Commit))) +
+			len(msg.Commit)*votePayload
+	}
+
 	return n
 }
 
@@ -19,6 +19,11 @@ type FilterType uint8
 const (
 	// GCSFilterRegular is the regular filter type.
 	GCSFilterRegular FilterType = iota
+
+	// GCSFilterContract is the filter type which also commits to the
+	// contracts called and created, the logs they emitted and the outputs
+	// of the virtual transactions.
+	GCSFilterContract
 )
 
 const (
@@ -0,0 +1,193 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package protos
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/common/serialization"
+)
+
+// EvidenceType identifies the misbehavior an evidence proves.
+type EvidenceType uint8
+
+const (
+	// EvidenceDoubleProposal proves a validator produced two different
+	// blocks for the same round and slot.
+	EvidenceDoubleProposal EvidenceType = 1
+
+	// EvidenceDoubleSign proves a validator signed two different blocks at
+	// the same height.
+	EvidenceDoubleSign EvidenceType = 2
+)
+
+// Map of evidence types back to their constant names for pretty printing.
+var evidenceTypeStrings = map[EvidenceType]string{
+	EvidenceDoubleProposal: "doubleproposal",
+	EvidenceDoubleSign:     "doublesign",
+}
+
+// String returns the EvidenceType in human-readable form.
+func (t EvidenceType) String() string {
+	if s, ok := evidenceTypeStrings[t]; ok {
+		return s
+	}
+	return fmt.Sprintf("Unknown EvidenceType (%d)", uint8(t))
+}
+
+// maxEvidencePayload is the maximum length of an evidence, the one of two
+// block headers.
+const maxEvidencePayload = 1 + 2*BlockHeaderPayload
+
+// MsgEvidence implements the Message interface and represents an evidence
+// of the misbehavior of a validator.  It holds the two conflicting block
+// headers of a double proposal, or the two conflicting block signatures of a
+// double sign, both signed by the validator.  The pair is ordered by hash so
+// that an evidence has a single encoding.
+type MsgEvidence struct {
+	Type    EvidenceType
+	Headers [2]BlockHeader
+	Signs   [2]MsgBlockSign
+}
+
+// NewDoubleProposalEvidence returns an evidence of the two passed headers
+// produced for the same round and slot.
+func NewDoubleProposalEvidence(first, second *BlockHeader) *MsgEvidence {
+	msg := &MsgEvidence{
+		Type:    EvidenceDoubleProposal,
+		Headers: [2]BlockHeader{*first, *second},
+	}
+	firstHash, secondHash := first.BlockHash(), second.BlockHash()
+	if bytes.Compare(firstHash[:], secondHash[:]) > 0 {
+		msg.Headers[0], msg.Headers[1] = msg.Headers[1], msg.Headers[0]
+	}
+	return msg
+}
+
+// NewDoubleSignEvidence returns an evidence of the two passed signatures of
+// blocks at the same height.
+func NewDoubleSignEvidence(first, second *MsgBlockSign) *MsgEvidence {
+	msg := &MsgEvidence{
+		Type:  EvidenceDoubleSign,
+		Signs: [2]MsgBlockSign{*first, *second},
+	}
+	if bytes.Compare(first.BlockHash[:], second.BlockHash[:]) > 0 {
+		msg.Signs[0], msg.Signs[1] = msg.Signs[1], msg.Signs[0]
+	}
+	return msg
+}
+
+// Offender returns the address of the validator the evidence is against.
+func (msg *MsgEvidence) Offender() common.Address {
+	if msg.Type == EvidenceDoubleProposal {
+		return msg.Headers[0].CoinBase
+	}
+	return msg.Signs[0].Signer
+}
+
+// Height returns the height of the first of the conflicting blocks.
+func (msg *MsgEvidence) Height() int32 {
+	if msg.Type == EvidenceDoubleProposal {
+		return msg.Headers[0].Height
+	}
+	return msg.Signs[0].BlockHeight
+}
+
+// Hash returns the hash of the serialized evidence.
+func (msg *MsgEvidence) Hash() common.Hash {
+	buf := bytes.NewBuffer(make([]byte, 0, msg.SerializeSize()))
+	_ = msg.Serialize(buf)
+	return common.DoubleHashH(buf.Bytes())
+}
+
+// Serialize encodes the evidence to w using a format that is suitable for
+// long-term storage such as a database.
+func (msg *MsgEvidence) Serialize(w io.Writer) error {
+	if err := serialization.WriteUint8(w, uint8(msg.Type)); err != nil {
+		return err
+	}
+	switch msg.Type {
+	case EvidenceDoubleProposal:
+		for i := range msg.Headers {
+			if err := msg.Headers[i].Serialize(w); err != nil {
+				return err
+			}
+		}
+	case EvidenceDoubleSign:
+		for i := range msg.Signs {
+			if err := msg.Signs[i].Serialize(w); err != nil {
+				return err
+			}
+		}
+	default:
+		str := fmt.Sprintf("unknown evidence type %d", msg.Type)
+		return messageError("MsgEvidence.Serialize", str)
+	}
+	return nil
+}
+
+// Deserialize decodes an evidence from r into the receiver using the format
+// of Serialize.
+func (msg *MsgEvidence) Deserialize(r io.Reader) error {
+	var evidenceType uint8
+	if err := serialization.ReadUint8(r, &evidenceType); err != nil {
+		return err
+	}
+	msg.Type = EvidenceType(evidenceType)
+	switch msg.Type {
+	case EvidenceDoubleProposal:
+		for i := range msg.Headers {
+			if err := msg.Headers[i].Deserialize(r); err != nil {
+				return err
+			}
+		}
+	case EvidenceDoubleSign:
+		for i := range msg.Signs {
+			if err := msg.Signs[i].Deserialize(r); err != nil {
+				return err
+			}
+		}
+	default:
+		str := fmt.Sprintf("unknown evidence type %d", msg.Type)
+		return messageError("MsgEvidence.Deserialize", str)
+	}
+	return nil
+}
+
+// SerializeSize returns the number of bytes it would take to serialize the
+// evidence.
+func (msg *MsgEvidence) SerializeSize() int {
+	if msg.Type == EvidenceDoubleProposal {
+		return 1 + 2*BlockHeaderPayload
+	}
+	return 1 + 2*fixedBlockSignPayloadLen
+}
+
+// VVSDecode decodes r using the asimov protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgEvidence) VVSDecode(r io.Reader, pver uint32, enc MessageEncoding) error {
+	return msg.Deserialize(r)
+}
+
+// VVSEncode encodes the receiver to w using the asimov protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgEvidence) VVSEncode(w io.Writer, pver uint32, enc MessageEncoding) error {
+	return msg.Serialize(w)
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgEvidence) Command() string {
+	return CmdEvidence
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgEvidence) MaxPayloadLength(pver uint32) uint32 {
+	return maxEvidencePayload
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package protos
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/AsimovNetwork/asimov/common"
+)
+
+// TestMsgEvidence tests the encoding of both evidence types and the order of
+// the conflicting pair.
+func TestMsgEvidence(t *testing.T) {
+	offender := common.Address{0x66, 0x01}
+	first := BlockHeader{Round: 3, SlotIndex: 2, Height: 10, CoinBase: offender}
+	second := first
+	second.Timestamp = 1
+	sign := MsgBlockSign{BlockHeight: 10, BlockHash: common.Hash{0x02}, Signer: offender}
+	otherSign := sign
+	otherSign.BlockHash = common.Hash{0x01}
+
+	tests := []struct {
+		name string
+		in   *MsgEvidence
+		swap *MsgEvidence
+	}{
+		{
+			"double proposal",
+			NewDoubleProposalEvidence(&first, &second),
+			NewDoubleProposalEvidence(&second, &first),
+		},
+		{
+			"double sign",
+			NewDoubleSignEvidence(&sign, &otherSign),
+			NewDoubleSignEvidence(&otherSign, &sign),
+		},
+	}
+	for _, test := range tests {
+		// The order of the pair does not change the evidence.
+		if !reflect.DeepEqual(test.in, test.swap) || test.in.Hash() != test.swap.Hash() {
+			t.Errorf("%s: the order of the pair changed the evidence", test.name)
+		}
+		if test.in.Offender() != offender || test.in.Height() != 10 {
+			t.Errorf("%s: got offender %v at height %d", test.name,
+				test.in.Offender(), test.in.Height())
+		}
+
+		var buf bytes.Buffer
+		if err := test.in.VVSEncode(&buf, common.ProtocolVersion, BaseEncoding); err != nil {
+			t.Errorf("%s: VVSEncode error %v", test.name, err)
+			continue
+		}
+		if buf.Len() != test.in.SerializeSize() ||
+			uint32(buf.Len()) > test.in.MaxPayloadLength(common.ProtocolVersion) {
+			t.Errorf("%s: encoded %d bytes, SerializeSize %d", test.name,
+				buf.Len(), test.in.SerializeSize())
+		}
+		var msg MsgEvidence
+		if err := msg.VVSDecode(bytes.NewReader(buf.Bytes()), common.ProtocolVersion, BaseEncoding); err != nil {
+			t.Errorf("%s: VVSDecode error %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(&msg, test.in) {
+			t.Errorf("%s: decoded %v, want %v", test.name, msg, test.in)
+		}
+	}
+	if tests[0].in.Headers[0].BlockHash() == tests[0].in.Headers[1].BlockHash() {
+		t.Errorf("double proposal headers have the same hash")
+	}
+	if tests[1].in.Signs[0].BlockHash != otherSign.BlockHash {
+		t.Errorf("double sign is not ordered by block hash")
+	}
+
+	// An unknown type is refused.
+	var msg MsgEvidence
+	if err := msg.Deserialize(bytes.NewReader([]byte{0x03})); err == nil {
+		t.Errorf("Deserialize accepted an unknown evidence type")
+	}
+	msg.Type = 0
+	if err := msg.Serialize(&bytes.Buffer{}); err == nil {
+		t.Errorf("Serialize accepted an unknown evidence type")
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package protos
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/common/serialization"
+)
+
+// MaxNodeDataPerMsg is the maximum number of state trie nodes and contract
+// codes that can be requested in a single getnodedata message.
+const MaxNodeDataPerMsg = 384
+
+// MsgGetNodeData implements the Message interface and represents an asimov
+// getnodedata message.  It is used to request the state trie nodes and the
+// contract codes with the given hashes while a node is syncing the state of
+// a pivot block.  The peer responds with a nodedata message (MsgNodeData).
+type MsgGetNodeData struct {
+	Hashes []common.Hash
+}
+
+// AddHash adds a new hash to the message.
+func (msg *MsgGetNodeData) AddHash(hash *common.Hash) error {
+	if len(msg.Hashes)+1 > MaxNodeDataPerMsg {
+		str := fmt.Sprintf("too many hashes in message [max %v]",
+			MaxNodeDataPerMsg)
+		return messageError("MsgGetNodeData.AddHash", str)
+	}
+
+	msg.Hashes = append(msg.Hashes, *hash)
+	return nil
+}
+
+// VVSDecode decodes r using the asimov protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgGetNodeData) VVSDecode(r io.Reader, pver uint32, _ MessageEncoding) error {
+	count, err := serialization.ReadVarInt(r, pver)
+	if err != nil {
+		return err
+	}
+
+	// Limit to max hashes per message.
+	if count > MaxNodeDataPerMsg {
+		str := fmt.Sprintf("too many hashes for message "+
+			"[count %v, max %v]", count, MaxNodeDataPerMsg)
+		return messageError("MsgGetNodeData.VVSDecode", str)
+	}
+
+	msg.Hashes = make([]common.Hash, count)
+	for i := range msg.Hashes {
+		err := serialization.ReadNBytes(r, msg.Hashes[i][:], common.HashLength)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VVSEncode encodes the receiver to w using the asimov protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgGetNodeData) VVSEncode(w io.Writer, pver uint32, _ MessageEncoding) error {
+	// Limit to max hashes per message.
+	count := len(msg.Hashes)
+	if count > MaxNodeDataPerMsg {
+		str := fmt.Sprintf("too many hashes for message "+
+			"[count %v, max %v]", count, MaxNodeDataPerMsg)
+		return messageError("MsgGetNodeData.VVSEncode", str)
+	}
+
+	err := serialization.WriteVarInt(w, pver, uint64(count))
+	if err != nil {
+		return err
+	}
+
+	for i := range msg.Hashes {
+		err := serialization.WriteNBytes(w, msg.Hashes[i][:])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgGetNodeData) Command() string {
+	return CmdGetNodeData
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgGetNodeData) MaxPayloadLength(pver uint32) uint32 {
+	// Num hashes (varInt) + max allowed hashes.
+	return serialization.MaxVarIntPayload + (MaxNodeDataPerMsg * common.HashLength)
+}
+
+// NewMsgGetNodeData returns a new asimov getnodedata message that conforms to
+// the Message interface.  See MsgGetNodeData for details.
+func NewMsgGetNodeData() *MsgGetNodeData {
+	return &MsgGetNodeData{
+		Hashes: make([]common.Hash, 0, MaxNodeDataPerMsg),
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package protos
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/AsimovNetwork/asimov/common"
+)
+
+// TestGetNodeData tests the MsgGetNodeData API.
+func TestGetNodeData(t *testing.T) {
+	pver := common.ProtocolVersion
+
+	// Ensure the command is expected value.
+	wantCmd := "getnodedata"
+	msg := NewMsgGetNodeData()
+	if cmd := msg.Command(); cmd != wantCmd {
+		t.Errorf("NewMsgGetNodeData: wrong command - got %v want %v",
+			cmd, wantCmd)
+	}
+
+	// Ensure max payload is expected value for latest protocol version.
+	// Num hashes (varInt) + max allowed hashes.
+	wantPayload := uint32(12297)
+	maxPayload := msg.MaxPayloadLength(pver)
+	if maxPayload != wantPayload {
+		t.Errorf("MaxPayloadLength: wrong max payload length for "+
+			"protocol version %d - got %v, want %v", pver,
+			maxPayload, wantPayload)
+	}
+
+	// Ensure hashes are added properly.
+	hash := common.Hash{0x01}
+	err := msg.AddHash(&hash)
+	if err != nil {
+		t.Errorf("AddHash: %v", err)
+	}
+	if msg.Hashes[0] != hash {
+		t.Errorf("AddHash: wrong hash added - got %v, want %v",
+			msg.Hashes[0], hash)
+	}
+
+	// Ensure adding more than the max allowed hashes per message returns
+	// error.
+	for i := 0; i < MaxNodeDataPerMsg; i++ {
+		err = msg.AddHash(&hash)
+	}
+	if err == nil {
+		t.Errorf("AddHash: expected error on too many hashes " +
+			"not received")
+	}
+}
+
+// TestGetNodeDataWire tests the MsgGetNodeData protos encode and decode.
+func TestGetNodeDataWire(t *testing.T) {
+	hash1 := common.Hash{0x01, 0x02}
+	hash2 := common.Hash{0xfe, 0xff}
+
+	noHashes := NewMsgGetNodeData()
+	noHashesEncoded := []byte{
+		0x00, // Varint for number of hashes
+	}
+
+	multiHashes := NewMsgGetNodeData()
+	multiHashes.AddHash(&hash1)
+	multiHashes.AddHash(&hash2)
+	multiHashesEncoded := []byte{
+		0x02, // Varint for number of hashes
+		0x01, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // Hash 1
+		0xfe, 0xff, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // Hash 2
+	}
+
+	tests := []struct {
+		in   *MsgGetNodeData // Message to encode
+		out  *MsgGetNodeData // Expected decoded message
+		buf  []byte          // Wire encoding
+		pver uint32          // Protocol version for protos encoding
+		enc  MessageEncoding // Message encoding format
+	}{
+		// Latest protocol version with no hashes.
+		{
+			noHashes,
+			&MsgGetNodeData{Hashes: []common.Hash{}},
+			noHashesEncoded,
+			common.ProtocolVersion,
+			BaseEncoding,
+		},
+
+		// Latest protocol version with multiple hashes.
+		{
+			multiHashes,
+			multiHashes,
+			multiHashesEncoded,
+			common.ProtocolVersion,
+			BaseEncoding,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode the message to protos format.
+		var buf bytes.Buffer
+		err := test.in.VVSEncode(&buf, test.pver, test.enc)
+		if err != nil {
+			t.Errorf("VVSEncode #%d error %v", i, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), test.buf) {
+			t.Errorf("VVSEncode #%d\n got: %v want: %v", i,
+				buf.Bytes(), test.buf)
+			continue
+		}
+
+		// Decode the message from protos format.
+		var msg MsgGetNodeData
+		rbuf := bytes.NewReader(test.buf)
+		err = msg.VVSDecode(rbuf, test.pver, test.enc)
+		if err != nil {
+			t.Errorf("VVSDecode #%d error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(&msg, test.out) {
+			t.Errorf("VVSDecode #%d\n got: %v want: %v", i,
+				&msg, test.out)
+			continue
+		}
+	}
+}
+
+// TestGetNodeDataWireErrors performs negative tests against protos encode and
+// decode of MsgGetNodeData to confirm error paths work correctly.
+func TestGetNodeDataWireErrors(t *testing.T) {
+	pver := common.ProtocolVersion
+
+	// Message that forces an error by having more than the max allowed
+	// hashes.
+	maxHashes := NewMsgGetNodeData()
+	for i := 0; i < MaxNodeDataPerMsg; i++ {
+		maxHashes.AddHash(&common.Hash{})
+	}
+	maxHashes.Hashes = append(maxHashes.Hashes, common.Hash{})
+	maxHashesEncoded := []byte{
+		0xfd, 0x81, 0x01, // Varint for number of hashes (385)
+	}
+
+	tests := []struct {
+		in       *MsgGetNodeData // Value to encode
+		buf      []byte          // Wire encoding
+		pver     uint32          // Protocol version for protos encoding
+		enc      MessageEncoding // Message encoding format
+		max      int             // Max size of fixed buffer to induce errors
+		writeErr error           // Expected write error
+		readErr  error           // Expected read error
+	}{
+		// Force error with greater than max hashes.
+		{maxHashes, maxHashesEncoded, pver, BaseEncoding, 3, &MessageError{}, &MessageError{}},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode to protos format.
+		w := newFixedWriter(test.max)
+		err := test.in.VVSEncode(w, test.pver, test.enc)
+		if reflect.TypeOf(err) != reflect.TypeOf(test.writeErr) {
+			t.Errorf("VVSEncode #%d wrong error got: %v, want: %v",
+				i, err, test.writeErr)
+			continue
+		}
+
+		// Decode from protos format.
+		var msg MsgGetNodeData
+		r := newFixedReader(test.max, test.buf)
+		err = msg.VVSDecode(r, test.pver, test.enc)
+		if reflect.TypeOf(err) != reflect.TypeOf(test.readErr) {
+			t.Errorf("VVSDecode #%d wrong error got: %v, want: %v",
+				i, err, test.readErr)
+			continue
+		}
+	}
+}
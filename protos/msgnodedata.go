@@ -0,0 +1,105 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package protos
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/AsimovNetwork/asimov/common/serialization"
+)
+
+// MsgNodeData implements the Message interface and represents an asimov
+// nodedata message.  It is used to deliver state trie nodes and contract
+// codes in response to a getnodedata message (MsgGetNodeData).
+//
+// The entries are identified by their hash, so they are not required to be
+// in the order they were requested in, and the ones the peer does not have
+// are simply left out.
+type MsgNodeData struct {
+	Data [][]byte
+}
+
+// AddData adds a new state trie node or contract code to the message.
+func (msg *MsgNodeData) AddData(data []byte) error {
+	if len(msg.Data)+1 > MaxNodeDataPerMsg {
+		str := fmt.Sprintf("too many entries in message [max %v]",
+			MaxNodeDataPerMsg)
+		return messageError("MsgNodeData.AddData", str)
+	}
+
+	msg.Data = append(msg.Data, data)
+	return nil
+}
+
+// VVSDecode decodes r using the asimov protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgNodeData) VVSDecode(r io.Reader, pver uint32, _ MessageEncoding) error {
+	count, err := serialization.ReadVarInt(r, pver)
+	if err != nil {
+		return err
+	}
+
+	// Limit to max entries per message.
+	if count > MaxNodeDataPerMsg {
+		str := fmt.Sprintf("too many entries for message "+
+			"[count %v, max %v]", count, MaxNodeDataPerMsg)
+		return messageError("MsgNodeData.VVSDecode", str)
+	}
+
+	msg.Data = make([][]byte, 0, count)
+	for i := uint64(0); i < count; i++ {
+		data, err := serialization.ReadVarBytes(r, pver, MaxMessagePayload,
+			"node data")
+		if err != nil {
+			return err
+		}
+		msg.Data = append(msg.Data, data)
+	}
+	return nil
+}
+
+// VVSEncode encodes the receiver to w using the asimov protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgNodeData) VVSEncode(w io.Writer, pver uint32, _ MessageEncoding) error {
+	// Limit to max entries per message.
+	count := len(msg.Data)
+	if count > MaxNodeDataPerMsg {
+		str := fmt.Sprintf("too many entries for message "+
+			"[count %v, max %v]", count, MaxNodeDataPerMsg)
+		return messageError("MsgNodeData.VVSEncode", str)
+	}
+
+	err := serialization.WriteVarInt(w, pver, uint64(count))
+	if err != nil {
+		return err
+	}
+
+	for _, data := range msg.Data {
+		err := serialization.WriteVarBytes(w, pver, data)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgNodeData) Command() string {
+	return CmdNodeData
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgNodeData) MaxPayloadLength(pver uint32) uint32 {
+	return MaxMessagePayload
+}
+
+// NewMsgNodeData returns a new asimov nodedata message that conforms to the
+// Message interface.  See MsgNodeData for details.
+func NewMsgNodeData() *MsgNodeData {
+	return &MsgNodeData{}
+}
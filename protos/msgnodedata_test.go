@@ -0,0 +1,171 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package protos
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/AsimovNetwork/asimov/common"
+)
+
+// TestNodeData tests the MsgNodeData API.
+func TestNodeData(t *testing.T) {
+	pver := common.ProtocolVersion
+
+	// Ensure the command is expected value.
+	wantCmd := "nodedata"
+	msg := NewMsgNodeData()
+	if cmd := msg.Command(); cmd != wantCmd {
+		t.Errorf("NewMsgNodeData: wrong command - got %v want %v",
+			cmd, wantCmd)
+	}
+
+	// Ensure max payload is expected value for latest protocol version.
+	wantPayload := uint32(MaxMessagePayload)
+	maxPayload := msg.MaxPayloadLength(pver)
+	if maxPayload != wantPayload {
+		t.Errorf("MaxPayloadLength: wrong max payload length for "+
+			"protocol version %d - got %v, want %v", pver,
+			maxPayload, wantPayload)
+	}
+
+	// Ensure adding more than the max allowed entries per message returns
+	// error.
+	var err error
+	for i := 0; i < MaxNodeDataPerMsg+1; i++ {
+		err = msg.AddData([]byte{0x01})
+	}
+	if err == nil {
+		t.Errorf("AddData: expected error on too many entries " +
+			"not received")
+	}
+}
+
+// TestNodeDataWire tests the MsgNodeData protos encode and decode.
+func TestNodeDataWire(t *testing.T) {
+	noData := NewMsgNodeData()
+	noDataEncoded := []byte{
+		0x00, // Varint for number of entries
+	}
+
+	multiData := NewMsgNodeData()
+	multiData.AddData([]byte{0xc2, 0x80, 0x80})
+	multiData.AddData([]byte{0x60, 0x80})
+	multiDataEncoded := []byte{
+		0x02,                   // Varint for number of entries
+		0x03, 0xc2, 0x80, 0x80, // Entry 1
+		0x02, 0x60, 0x80, // Entry 2
+	}
+
+	tests := []struct {
+		in   *MsgNodeData    // Message to encode
+		out  *MsgNodeData    // Expected decoded message
+		buf  []byte          // Wire encoding
+		pver uint32          // Protocol version for protos encoding
+		enc  MessageEncoding // Message encoding format
+	}{
+		// Latest protocol version with no entries.
+		{
+			noData,
+			&MsgNodeData{Data: [][]byte{}},
+			noDataEncoded,
+			common.ProtocolVersion,
+			BaseEncoding,
+		},
+
+		// Latest protocol version with multiple entries.
+		{
+			multiData,
+			multiData,
+			multiDataEncoded,
+			common.ProtocolVersion,
+			BaseEncoding,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode the message to protos format.
+		var buf bytes.Buffer
+		err := test.in.VVSEncode(&buf, test.pver, test.enc)
+		if err != nil {
+			t.Errorf("VVSEncode #%d error %v", i, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), test.buf) {
+			t.Errorf("VVSEncode #%d\n got: %v want: %v", i,
+				buf.Bytes(), test.buf)
+			continue
+		}
+
+		// Decode the message from protos format.
+		var msg MsgNodeData
+		rbuf := bytes.NewReader(test.buf)
+		err = msg.VVSDecode(rbuf, test.pver, test.enc)
+		if err != nil {
+			t.Errorf("VVSDecode #%d error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(&msg, test.out) {
+			t.Errorf("VVSDecode #%d\n got: %v want: %v", i,
+				&msg, test.out)
+			continue
+		}
+	}
+}
+
+// TestNodeDataWireErrors performs negative tests against protos encode and
+// decode of MsgNodeData to confirm error paths work correctly.
+func TestNodeDataWireErrors(t *testing.T) {
+	pver := common.ProtocolVersion
+
+	// Message that forces an error by having more than the max allowed
+	// entries.
+	maxData := NewMsgNodeData()
+	for i := 0; i < MaxNodeDataPerMsg; i++ {
+		maxData.AddData([]byte{0x01})
+	}
+	maxData.Data = append(maxData.Data, []byte{0x01})
+	maxDataEncoded := []byte{
+		0xfd, 0x81, 0x01, // Varint for number of entries (385)
+	}
+
+	tests := []struct {
+		in       *MsgNodeData    // Value to encode
+		buf      []byte          // Wire encoding
+		pver     uint32          // Protocol version for protos encoding
+		enc      MessageEncoding // Message encoding format
+		max      int             // Max size of fixed buffer to induce errors
+		writeErr error           // Expected write error
+		readErr  error           // Expected read error
+	}{
+		// Force error with greater than max entries.
+		{maxData, maxDataEncoded, pver, BaseEncoding, 3, &MessageError{}, &MessageError{}},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode to protos format.
+		w := newFixedWriter(test.max)
+		err := test.in.VVSEncode(w, test.pver, test.enc)
+		if reflect.TypeOf(err) != reflect.TypeOf(test.writeErr) {
+			t.Errorf("VVSEncode #%d wrong error got: %v, want: %v",
+				i, err, test.writeErr)
+			continue
+		}
+
+		// Decode from protos format.
+		var msg MsgNodeData
+		r := newFixedReader(test.max, test.buf)
+		err = msg.VVSDecode(r, test.pver, test.enc)
+		if reflect.TypeOf(err) != reflect.TypeOf(test.readErr) {
+			t.Errorf("VVSDecode #%d wrong error got: %v, want: %v",
+				i, err, test.readErr)
+			continue
+		}
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package protos
+
+import (
+	"io"
+
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/common/serialization"
+)
+
+// MsgVirtualBlock implements the Message interface and represents an asimov
+// vblock message.  It is used to deliver the virtual transactions of a block
+// in response to a getdata message (MsgGetData) for an InvTypeVBlock
+// inventory vector.
+//
+// The virtual transactions are the result of executing the contracts of the
+// block and are not committed to by its header, so a node only uses them to
+// connect the blocks it does not execute while syncing the state of a pivot
+// block.
+type MsgVirtualBlock struct {
+	BlockHash common.Hash
+	VBlock    MsgVBlock
+}
+
+// VVSDecode decodes r using the asimov protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgVirtualBlock) VVSDecode(r io.Reader, pver uint32, _ MessageEncoding) error {
+	err := serialization.ReadNBytes(r, msg.BlockHash[:], common.HashLength)
+	if err != nil {
+		return err
+	}
+	return msg.VBlock.Deserialize(r)
+}
+
+// VVSEncode encodes the receiver to w using the asimov protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgVirtualBlock) VVSEncode(w io.Writer, pver uint32, _ MessageEncoding) error {
+	err := serialization.WriteNBytes(w, msg.BlockHash[:])
+	if err != nil {
+		return err
+	}
+	return msg.VBlock.Serialize(w)
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgVirtualBlock) Command() string {
+	return CmdVBlock
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgVirtualBlock) MaxPayloadLength(pver uint32) uint32 {
+	// The virtual block is only bounded by the gas of the block it belongs
+	// to, so the general message limit applies.
+	return MaxMessagePayload
+}
+
+// NewMsgVirtualBlock returns a new asimov vblock message that conforms to the
+// Message interface.  See MsgVirtualBlock for details.
+func NewMsgVirtualBlock(blockHash *common.Hash, vblock *MsgVBlock) *MsgVirtualBlock {
+	return &MsgVirtualBlock{
+		BlockHash: *blockHash,
+		VBlock:    *vblock,
+	}
+}
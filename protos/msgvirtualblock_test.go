@@ -0,0 +1,51 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package protos
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/AsimovNetwork/asimov/common"
+)
+
+// TestVirtualBlockWire tests the MsgVirtualBlock protos encode and decode.
+func TestVirtualBlockWire(t *testing.T) {
+	blockHash := common.Hash{0x01, 0x02}
+	msg := NewMsgVirtualBlock(&blockHash, &vblockOne)
+	if cmd := msg.Command(); cmd != "vblock" {
+		t.Errorf("NewMsgVirtualBlock: wrong command - got %v want %v",
+			cmd, "vblock")
+	}
+
+	encoded := append(common.CopyBytes(blockHash[:]), vblockOneBytes...)
+
+	// Encode the message to protos format.
+	var buf bytes.Buffer
+	err := msg.VVSEncode(&buf, common.ProtocolVersion, BaseEncoding)
+	if err != nil {
+		t.Fatalf("VVSEncode error %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), encoded) {
+		t.Errorf("VVSEncode\n got: %v want: %v", buf.Bytes(), encoded)
+	}
+
+	// Decode the message from protos format.
+	var decoded MsgVirtualBlock
+	err = decoded.VVSDecode(bytes.NewReader(encoded), common.ProtocolVersion, BaseEncoding)
+	if err != nil {
+		t.Fatalf("VVSDecode error %v", err)
+	}
+	if !reflect.DeepEqual(&decoded, msg) {
+		t.Errorf("VVSDecode\n got: %v want: %v", &decoded, msg)
+	}
+
+	// A truncated block hash is rejected.
+	err = decoded.VVSDecode(bytes.NewReader(encoded[:16]), common.ProtocolVersion, BaseEncoding)
+	if err == nil {
+		t.Errorf("VVSDecode of a truncated message succeeded")
+	}
+}
@@ -8,7 +8,13 @@
 // a chain server.
 package rpcjson
 
-import "math/big"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/AsimovNetwork/asimov/common"
+	"math/big"
+)
 
 
 // AddNodeSubCmd defines the type used in the addnode JSON-RPC command for the
@@ -54,3 +60,93 @@ type TestCallData struct {
 	AmountB   big.Int `json:"amountb"`
 	VoteValue string  `json:"voteValue"`
 }
+
+// FilterCriteria represents a request to create a log filter or to subscribe
+// to contract event logs.
+//
+// Addresses may be a single address or a list of addresses.  Topics is a list
+// of positions, each position may be null (wildcard), a single topic or a list
+// of alternative topics.
+type FilterCriteria struct {
+	BlockHash *common.Hash     `json:"blockHash"`
+	FromBlock *int32           `json:"fromBlock"`
+	ToBlock   *int32           `json:"toBlock"`
+	Addresses []common.Address `json:"address"`
+	Topics    [][]common.Hash  `json:"topics"`
+
+	// Decode requests the logs to be returned with their events decoded
+	// through the ABI of the template of the emitting contract.
+	Decode bool `json:"decode"`
+}
+
+// UnmarshalJSON sets *args fields with given data.
+func (args *FilterCriteria) UnmarshalJSON(data []byte) error {
+	type input struct {
+		BlockHash *common.Hash      `json:"blockHash"`
+		FromBlock *int32            `json:"fromBlock"`
+		ToBlock   *int32            `json:"toBlock"`
+		Addresses json.RawMessage   `json:"address"`
+		Topics    []json.RawMessage `json:"topics"`
+		Decode    bool              `json:"decode"`
+	}
+
+	var raw input
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	if raw.BlockHash != nil && (raw.FromBlock != nil || raw.ToBlock != nil) {
+		return errors.New("cannot specify both blockHash and fromBlock/toBlock")
+	}
+	args.BlockHash = raw.BlockHash
+	args.FromBlock = raw.FromBlock
+	args.ToBlock = raw.ToBlock
+	args.Decode = raw.Decode
+
+	args.Addresses = nil
+	if len(raw.Addresses) > 0 && string(raw.Addresses) != "null" {
+		var addresses []common.Address
+		if err := json.Unmarshal(raw.Addresses, &addresses); err != nil {
+			var address common.Address
+			if err := json.Unmarshal(raw.Addresses, &address); err != nil {
+				return fmt.Errorf("invalid address: %v", err)
+			}
+			addresses = []common.Address{address}
+		}
+		args.Addresses = addresses
+	}
+
+	args.Topics = nil
+	if len(raw.Topics) > 0 {
+		args.Topics = make([][]common.Hash, len(raw.Topics))
+		for i, rawTopic := range raw.Topics {
+			// null matches any topic at this position.
+			if string(rawTopic) == "null" {
+				continue
+			}
+			var topics []common.Hash
+			if err := json.Unmarshal(rawTopic, &topics); err != nil {
+				var topic common.Hash
+				if err := json.Unmarshal(rawTopic, &topic); err != nil {
+					return fmt.Errorf("invalid topic at position %d: %v", i, err)
+				}
+				topics = []common.Hash{topic}
+			}
+			args.Topics[i] = topics
+		}
+	}
+
+	return nil
+}
+
+// TraceConfig holds the options of the debug trace commands.  Tracer selects
+// the tracer, either the default opcode level struct logger when empty,
+// "callTracer" for the tree of calls and asset transfers or "stateTracer" for
+// the state changes only.  The other options only apply to the struct logger.
+type TraceConfig struct {
+	Tracer         string `json:"tracer"`
+	DisableStorage bool   `json:"disableStorage"`
+	DisableMemory  bool   `json:"disableMemory"`
+	DisableStack   bool   `json:"disableStack"`
+	Limit          int    `json:"limit"`
+}
@@ -16,6 +16,15 @@ type CreateRawTransactionResult struct {
 	ContractAddr map[uint64]string `json:"contractaddr"`
 }
 
+// CreateFundedTransactionResult models the data returned from the
+// createfundedtransaction command.
+type CreateFundedTransactionResult struct {
+	Hex          string              `json:"hex"`
+	ContractAddr map[uint64]string   `json:"contractaddr"`
+	Inputs       []TransactionInput  `json:"inputs"`
+	Change       []TransactionOutput `json:"change"`
+}
+
 // GetRawMempoolVerboseResult models the data returned from the getrawmempool
 // command when the verbose flag is set.  When the verbose flag is not set,
 // getrawmempool returns an array of transaction hashes.
@@ -27,6 +36,32 @@ type GetRawMempoolVerboseResult struct {
 	Depends []string `json:"depends"`
 }
 
+// EvidenceResult models an evidence returned from the getEvidence command.
+// The blocks are the two conflicting blocks produced or signed by the
+// offender.  SubmitData is the input of the call to the validator committee
+// which submits the evidence, once the chain activated the submitEvidence
+// upgrade of the contract.
+type EvidenceResult struct {
+	Hash       string   `json:"hash"`
+	Type       string   `json:"type"`
+	Offender   string   `json:"offender"`
+	Height     int32    `json:"height"`
+	Round      uint32   `json:"round,omitempty"`
+	Slot       uint16   `json:"slot,omitempty"`
+	Blocks     []string `json:"blocks"`
+	Time       int64    `json:"time"`
+	Hex        string   `json:"hex"`
+	SubmitData string   `json:"submitdata,omitempty"`
+}
+
+// MempoolFileResult models the data returned from the saveMempool and
+// loadMempool commands.
+type MempoolFileResult struct {
+	File     string `json:"file"`
+	Size     int    `json:"size"`
+	Rejected int    `json:"rejected,omitempty"`
+}
+
 // GetBlockTemplateResultAux models the coinbaseaux field of the
 // getblocktemplate command.
 type GetBlockTemplateResultAux struct {
@@ -121,6 +156,29 @@ type LogResult struct {
 	// The Removed field is true if this log was reverted due to a chain reorganisation.
 	// You must pay attention to this field if you receive logs through a filter query.
 	Removed bool `json:"removed"`
+
+	// Event is the log decoded through the ABI of the template of the
+	// contract, only set when decoding is requested and the template is
+	// known.
+	Event *DecodedEventResult `json:"event,omitempty"`
+}
+
+// DecodedEventResult models a contract log decoded through the ABI of the
+// template the emitting contract is deployed from.
+type DecodedEventResult struct {
+	Name      string           `json:"name"`
+	Signature string           `json:"signature"`
+	Template  string           `json:"template"`
+	Args      []EventArgResult `json:"args"`
+}
+
+// EventArgResult models a typed argument of a decoded contract event.
+// Indexed arguments of reference types only carry the hash of their value.
+type EventArgResult struct {
+	Name    string      `json:"name"`
+	Type    string      `json:"type"`
+	Indexed bool        `json:"indexed"`
+	Value   interface{} `json:"value"`
 }
 
 type ReceiptResult struct {
@@ -135,6 +193,26 @@ type ReceiptResult struct {
 	TxHash          string `json:"transactionHash" gencodec:"required"`
 	ContractAddress string `json:"contractAddress"`
 	GasUsed         uint64 `json:"gasUsed" gencodec:"required"`
+
+	RevertReason *RevertReasonResult `json:"revertReason,omitempty"`
+}
+
+// RevertReasonResult models the data returned by a reverted contract
+// execution.  Reason is set for the builtin Error(string) and Panic(uint256)
+// errors, Error for custom errors declared in the ABI of the template.
+type RevertReasonResult struct {
+	Data   string              `json:"data"`
+	Reason string              `json:"reason,omitempty"`
+	Error  *DecodedErrorResult `json:"error,omitempty"`
+}
+
+// DecodedErrorResult models a custom contract error decoded through the ABI
+// of a template.
+type DecodedErrorResult struct {
+	Name      string           `json:"name"`
+	Signature string           `json:"signature"`
+	Template  string           `json:"template,omitempty"`
+	Args      []EventArgResult `json:"args"`
 }
 
 // GetBlockVerboseResult models the data from the getblock command when the
@@ -183,6 +261,17 @@ type GetBlockChainInfoResult struct {
 	MedianTime    int64  `json:"mediantime"`
 	Round         int32  `json:"round"`
 	Slot          int16  `json:"slot"`
+	Finalized     int32  `json:"finalized"`
+	FinalizedHash string `json:"finalizedhash,omitempty"`
+}
+
+// GetFinalizedBlockResult models the data returned from the getfinalizedblock
+// command.  Confirmations is the number of blocks of the main chain from the
+// finalized block to the best one.
+type GetFinalizedBlockResult struct {
+	Hash          string `json:"hash"`
+	Height        int32  `json:"height"`
+	Confirmations int32  `json:"confirmations"`
 }
 
 type GetConsensusMiningInfoResult struct {
@@ -373,6 +462,58 @@ type GetBestBlockResult struct {
 	Height int32  `json:"height"`
 }
 
+// GetTxOutSetInfoResult models the data from the gettxoutsetinfo command.
+type GetTxOutSetInfoResult struct {
+	Height       int32                 `json:"height"`
+	BestBlock    string                `json:"bestblock"`
+	Transactions uint64                `json:"transactions"`
+	TxOuts       uint64                `json:"txouts"`
+	UtxoSetHash  string                `json:"utxosethash"`
+	Assets       []TxOutSetAssetResult `json:"assets"`
+}
+
+// TxOutSetAssetResult models the unspent outputs of an asset in the data from
+// the gettxoutsetinfo command.
+type TxOutSetAssetResult struct {
+	Asset  string `json:"asset"`
+	TxOuts uint64 `json:"txouts"`
+	Amount string `json:"amount"`
+	Locked string `json:"locked"`
+}
+
+// GetTxProofResult models the data from the gettxproof command.
+type GetTxProofResult struct {
+	BlockHash  string   `json:"blockhash"`
+	Height     int32    `json:"height"`
+	MerkleRoot string   `json:"merkleroot"`
+	TxID       string   `json:"txid"`
+	Hex        string   `json:"hex"`
+	Index      uint32   `json:"index"`
+	Branch     []string `json:"branch"`
+}
+
+// GetProofResult models the data from the getproof command.
+type GetProofResult struct {
+	BlockHash    string               `json:"blockhash"`
+	Height       int32                `json:"height"`
+	StateRoot    string               `json:"stateroot"`
+	Address      string               `json:"address"`
+	AccountProof []string             `json:"accountproof"`
+	Balance      string               `json:"balance"`
+	Nonce        uint64               `json:"nonce"`
+	CodeHash     string               `json:"codehash"`
+	StorageHash  string               `json:"storagehash"`
+	StorageProof []StorageProofResult `json:"storageproof"`
+}
+
+// StorageProofResult models the proof of a storage slot in the data from the
+// getproof command.
+type StorageProofResult struct {
+	Key   string   `json:"key"`
+	Value string   `json:"value"`
+	Proof []string `json:"proof"`
+}
+
 type GetBalanceResult struct {
 	Asset string `json:"asset"`
 	Value string `json:"value"`
@@ -381,3 +522,152 @@ type GetBalancesResult struct {
 	Address string             `json:"address"`
 	Assets  []GetBalanceResult `json:"assets"`
 }
+
+// PsbtPartialSigResult models a partial signature of a partially signed
+// transaction input.
+type PsbtPartialSigResult struct {
+	PubKey    string `json:"pubkey"`
+	Signature string `json:"signature"`
+}
+
+// DecodePsbtInputResult models an input of a partially signed transaction.
+type DecodePsbtInputResult struct {
+	PrevOut        *Vout                  `json:"prevout,omitempty"`
+	PartialSigs    []PsbtPartialSigResult `json:"partialsigs,omitempty"`
+	SighashType    uint32                 `json:"sighashtype,omitempty"`
+	RedeemScript   *ScriptPubKeyResult    `json:"redeemscript,omitempty"`
+	FinalScriptSig *ScriptSig             `json:"finalscriptsig,omitempty"`
+	Unknown        map[string]string      `json:"unknown,omitempty"`
+}
+
+// DecodePsbtOutputResult models an output of a partially signed transaction.
+type DecodePsbtOutputResult struct {
+	RedeemScript *ScriptPubKeyResult `json:"redeemscript,omitempty"`
+	Unknown      map[string]string   `json:"unknown,omitempty"`
+}
+
+// DecodePsbtResult models the data returned from the decodepsbt command.
+type DecodePsbtResult struct {
+	Tx      TxRawDecodeResult        `json:"tx"`
+	Unknown map[string]string        `json:"unknown,omitempty"`
+	Inputs  []DecodePsbtInputResult  `json:"inputs"`
+	Outputs []DecodePsbtOutputResult `json:"outputs"`
+	Fee     []FeeResult              `json:"fee,omitempty"`
+}
+
+// AnalyzePsbtMissingResult models the data still missing to finalize an
+// input of a partially signed transaction.
+type AnalyzePsbtMissingResult struct {
+	PubKeys      []string `json:"pubkeys,omitempty"`
+	PubKeyHash   string   `json:"pubkeyhash,omitempty"`
+	Signatures   int      `json:"signatures,omitempty"`
+	RedeemScript bool     `json:"redeemscript,omitempty"`
+}
+
+// AnalyzePsbtInputResult models the state of an input of a partially signed
+// transaction.
+type AnalyzePsbtInputResult struct {
+	HasUtxo bool                      `json:"has_utxo"`
+	IsFinal bool                      `json:"is_final"`
+	Missing *AnalyzePsbtMissingResult `json:"missing,omitempty"`
+	Next    string                    `json:"next,omitempty"`
+}
+
+// AnalyzePsbtResult models the data returned from the analyzepsbt command.
+type AnalyzePsbtResult struct {
+	Inputs []AnalyzePsbtInputResult `json:"inputs"`
+	Fee    []FeeResult              `json:"fee,omitempty"`
+	Next   string                   `json:"next"`
+	Error  string                   `json:"error,omitempty"`
+}
+
+// FinalizePsbtResult models the data returned from the finalizepsbt command.
+// Hex is only set when the transaction is complete and was extracted.
+type FinalizePsbtResult struct {
+	Psbt     string `json:"psbt,omitempty"`
+	Hex      string `json:"hex,omitempty"`
+	Complete bool   `json:"complete"`
+}
+
+// StructLogResult models an opcode executed by the FVM in the trace of the
+// struct logger.
+type StructLogResult struct {
+	Pc      uint64            `json:"pc"`
+	Op      string            `json:"op"`
+	Gas     uint64            `json:"gas"`
+	GasCost uint64            `json:"gasCost"`
+	Depth   int               `json:"depth"`
+	Error   string            `json:"error,omitempty"`
+	Stack   []string          `json:"stack,omitempty"`
+	Memory  []string          `json:"memory,omitempty"`
+	Storage map[string]string `json:"storage,omitempty"`
+}
+
+// VTransferResult models an asset transfer recorded by a contract execution.
+// Type is one of transfer, creation or mint.
+type VTransferResult struct {
+	Type   string `json:"type"`
+	From   string `json:"from,omitempty"`
+	To     string `json:"to"`
+	Amount int64  `json:"amount"`
+	Asset  string `json:"asset"`
+}
+
+// CallFrameResult models a message call or contract creation in the trace of
+// the call tracer.
+type CallFrameResult struct {
+	Type      string            `json:"type"`
+	From      string            `json:"from"`
+	To        string            `json:"to,omitempty"`
+	Value     string            `json:"value"`
+	Asset     string            `json:"asset,omitempty"`
+	Gas       uint64            `json:"gas"`
+	GasUsed   uint64            `json:"gasUsed"`
+	Input     string            `json:"input"`
+	Output    string            `json:"output,omitempty"`
+	Error     string            `json:"error,omitempty"`
+	Transfers []VTransferResult `json:"transfers,omitempty"`
+	Calls     []CallFrameResult `json:"calls,omitempty"`
+}
+
+// StorageChangeResult models a contract storage slot changed by a traced
+// transaction.
+type StorageChangeResult struct {
+	Address string `json:"address"`
+	Key     string `json:"key"`
+	Pre     string `json:"pre"`
+	Post    string `json:"post"`
+}
+
+// BalanceChangeResult models a balance changed by a traced transaction.  The
+// balance of an indivisible asset is the number of vouchers held.
+type BalanceChangeResult struct {
+	Address string `json:"address"`
+	Asset   string `json:"asset"`
+	Pre     int64  `json:"pre"`
+	Post    int64  `json:"post"`
+}
+
+// TxTraceResult models the trace of a transaction returned from the trace
+// commands.  StructLogs is set by the struct logger, Calls by the call tracer
+// with a frame for each contract execution of the transaction.  Storage and
+// Balances are the state changed by the transaction and Vtx is the virtual
+// transaction generated by its executions.
+type TxTraceResult struct {
+	TxId        string                `json:"txid"`
+	Gas         uint64                `json:"gas"`
+	Failed      bool                  `json:"failed"`
+	ReturnValue string                `json:"returnValue"`
+	StructLogs  []StructLogResult     `json:"structLogs,omitempty"`
+	Calls       []CallFrameResult     `json:"calls,omitempty"`
+	Storage     []StorageChangeResult `json:"storage,omitempty"`
+	Balances    []BalanceChangeResult `json:"balances,omitempty"`
+	Vtx         *TxRawResult          `json:"vtx,omitempty"`
+}
+
+// BlockTraceResult models the data returned from the traceBlock commands.
+type BlockTraceResult struct {
+	Hash   string           `json:"hash"`
+	Height int32            `json:"height"`
+	Txs    []*TxTraceResult `json:"txs"`
+}
@@ -34,5 +34,8 @@ const (
 	ErrRPCNoTxInfo            RPCErrorCode = -204
 	ErrRPCInvalidTxVout       RPCErrorCode = -205
 	ErrRPCDecodeHexString     RPCErrorCode = -206
+	ErrRPCFilterNotFound      RPCErrorCode = -207
+	ErrRPCInsufficientFunds   RPCErrorCode = -208
+	ErrRPCExecutionReverted   RPCErrorCode = -209
 )
 
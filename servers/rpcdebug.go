@@ -0,0 +1,383 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package servers
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/blockchain"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/protos"
+	"github.com/AsimovNetwork/asimov/rpcs/rpcjson"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/types"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/virtualtx"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/vm"
+	"github.com/AsimovNetwork/asimov/vm/fvm/math"
+)
+
+const (
+	// callTracerName is the name of the call tracer in the trace config.
+	callTracerName = "callTracer"
+
+	// stateTracerName is the name in the trace config which only reports
+	// the state changed by the transactions.
+	stateTracerName = "stateTracer"
+)
+
+// PrivateDebugAPI is the collection of debugging RPC methods served under
+// the debug namespace.  They re-execute blocks and are not exposed unless
+// the namespace is enabled explicitly.
+type PrivateDebugAPI struct {
+	cfg *rpcserverConfig
+}
+
+// NewPrivateDebugAPI creates a new PrivateDebugAPI instance.
+func NewPrivateDebugAPI(config *rpcserverConfig) *PrivateDebugAPI {
+	return &PrivateDebugAPI{cfg: config}
+}
+
+// TraceTransaction re-executes the transaction with the given id on top of
+// the state of the parent of its block, after the transactions preceding it
+// in the block, and returns the trace of its contract executions.
+func (api *PrivateDebugAPI) TraceTransaction(txId string, config *rpcjson.TraceConfig) (interface{}, error) {
+	if err := checkTraceConfig(config); err != nil {
+		return nil, err
+	}
+	if api.cfg.TxIndex == nil {
+		return nil, &rpcjson.RPCError{
+			Code: rpcjson.ErrRPCNoTxInfo,
+			Message: "The transaction index must be " +
+				"enabled to query the blockchain " +
+				"(specify --txindex)",
+		}
+	}
+
+	txHash := common.HexToHash(txId)
+	blockRegion, err := api.cfg.TxIndex.FetchBlockRegion(txHash[:])
+	if err != nil {
+		context := "Failed to retrieve transaction location"
+		return nil, internalRPCError(err.Error(), context)
+	}
+	if blockRegion == nil {
+		return nil, &rpcjson.RPCError{
+			Code:    rpcjson.ErrRPCNoTxInfo,
+			Message: fmt.Sprintf("No information available about transaction %v", &txHash),
+		}
+	}
+	if blockRegion.Key.IsVirtual() {
+		return nil, &rpcjson.RPCError{
+			Code: rpcjson.ErrRPCInvalidParameter,
+			Message: fmt.Sprintf("Transaction %v is virtual, trace the "+
+				"transaction which generated it instead", &txHash),
+		}
+	}
+
+	var blkHash common.Hash
+	copy(blkHash[:], blockRegion.Key[:common.HashLength])
+	block, err := api.cfg.Chain.BlockByHash(&blkHash)
+	if err != nil {
+		return nil, internalRPCError(err.Error(), "Failed to get block by hash")
+	}
+	txIdx := -1
+	for i, tx := range block.Transactions() {
+		if tx.Hash().IsEqual(&txHash) {
+			txIdx = i
+			break
+		}
+	}
+	if txIdx < 0 {
+		return nil, internalRPCError(fmt.Sprintf("transaction %v not found in "+
+			"block %v", &txHash, &blkHash), "")
+	}
+
+	results, err := api.traceBlock(block, txIdx, func(i int) bool {
+		return i == txIdx
+	}, config)
+	if err != nil {
+		return nil, err
+	}
+	return results[0], nil
+}
+
+// TraceBlockByHash re-executes all the transactions of the main chain block
+// with the given hash on top of the state of its parent and returns their
+// traces, along with the state they changed and the virtual transactions
+// they generated.  Nothing is committed to the chain.
+func (api *PrivateDebugAPI) TraceBlockByHash(blockHash string, config *rpcjson.TraceConfig) (interface{}, error) {
+	if err := checkTraceConfig(config); err != nil {
+		return nil, err
+	}
+	hash := common.HexToHash(blockHash)
+	block, err := api.cfg.Chain.BlockByHash(&hash)
+	if err != nil {
+		return nil, &rpcjson.RPCError{
+			Code:    rpcjson.ErrRPCBlockNotFound,
+			Message: "Block not found",
+		}
+	}
+	return api.traceBlockResult(block, config)
+}
+
+// TraceBlockByHeight re-executes all the transactions of the main chain block
+// at the given height, see TraceBlockByHash.
+func (api *PrivateDebugAPI) TraceBlockByHeight(height int32, config *rpcjson.TraceConfig) (interface{}, error) {
+	if err := checkTraceConfig(config); err != nil {
+		return nil, err
+	}
+	block, err := api.cfg.Chain.BlockByHeight(height)
+	if err != nil {
+		return nil, &rpcjson.RPCError{
+			Code:    rpcjson.ErrRPCBlockNotFound,
+			Message: "Block not found",
+		}
+	}
+	return api.traceBlockResult(block, config)
+}
+
+// traceBlockResult traces all the transactions of the passed block.
+func (api *PrivateDebugAPI) traceBlockResult(block *asiutil.Block, config *rpcjson.TraceConfig) (*rpcjson.BlockTraceResult, error) {
+	txs, err := api.traceBlock(block, -1, func(int) bool { return true }, config)
+	if err != nil {
+		return nil, err
+	}
+	return &rpcjson.BlockTraceResult{
+		Hash:   block.Hash().String(),
+		Height: block.Height(),
+		Txs:    txs,
+	}, nil
+}
+
+// checkTraceConfig ensures the passed trace config names a known tracer.
+func checkTraceConfig(config *rpcjson.TraceConfig) error {
+	if config == nil {
+		return nil
+	}
+	switch config.Tracer {
+	case "", callTracerName, stateTracerName:
+		return nil
+	}
+	return &rpcjson.RPCError{
+		Code:    rpcjson.ErrRPCInvalidParameter,
+		Message: fmt.Sprintf("Unknown tracer %q", config.Tracer),
+	}
+}
+
+// newTracer returns a tracer as selected by the passed trace config, or nil
+// when only the state changes are reported.
+func newTracer(config *rpcjson.TraceConfig) vm.Tracer {
+	if config == nil {
+		return vm.NewStructLogger(nil)
+	}
+	switch config.Tracer {
+	case callTracerName:
+		return vm.NewCallTracer()
+	case stateTracerName:
+		return nil
+	}
+	return vm.NewStructLogger(&vm.LogConfig{
+		DisableMemory:  config.DisableMemory,
+		DisableStack:   config.DisableStack,
+		DisableStorage: config.DisableStorage,
+		Limit:          config.Limit,
+	})
+}
+
+// traceBlock replays the transactions of the passed block up to and including
+// the one at index last, or all of them when last is negative, and returns
+// the traces of those selected by traced.
+func (api *PrivateDebugAPI) traceBlock(block *asiutil.Block, last int,
+	traced func(i int) bool, config *rpcjson.TraceConfig) ([]*rpcjson.TxTraceResult, error) {
+
+	tracers := make(map[int]vm.Tracer)
+	replayed, err := api.cfg.Chain.ReplayBlock(block.Hash(), last,
+		func(i int, tx *asiutil.Tx) vm.Tracer {
+			if !traced(i) {
+				return nil
+			}
+			tracers[i] = newTracer(config)
+			return tracers[i]
+		})
+	if err != nil {
+		return nil, internalRPCError(err.Error(), "Failed to replay block")
+	}
+
+	chainHeight := api.cfg.Chain.BestSnapshot().Height
+	results := make([]*rpcjson.TxTraceResult, 0, len(tracers))
+	for i, tx := range replayed {
+		tracer, ok := tracers[i]
+		if !ok {
+			continue
+		}
+		result, err := createTxTraceResult(tx, tracer, block.Hash().String(),
+			block.Height(), chainHeight)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// createTxTraceResult converts the trace of a replayed transaction into its
+// JSON-RPC representation.
+func createTxTraceResult(replayed *blockchain.ReplayedTx, tracer vm.Tracer,
+	blkHash string, blkHeight int32, chainHeight int32) (*rpcjson.TxTraceResult, error) {
+
+	result := &rpcjson.TxTraceResult{
+		TxId: replayed.Tx.Hash().UnprefixString(),
+		Gas:  replayed.GasUsed,
+	}
+	if replayed.Receipt != nil {
+		result.Failed = replayed.Receipt.Status == types.ReceiptStatusFailed
+	}
+
+	switch tracer := tracer.(type) {
+	case *vm.StructLogger:
+		result.ReturnValue = common.Bytes2Hex(tracer.Output())
+		result.StructLogs = createStructLogResults(tracer.StructLogs())
+	case *vm.CallTracer:
+		frames := tracer.Frames()
+		result.Calls = make([]rpcjson.CallFrameResult, 0, len(frames))
+		for _, frame := range frames {
+			result.Calls = append(result.Calls, createCallFrameResult(frame))
+		}
+		if len(frames) > 0 {
+			result.ReturnValue = common.Bytes2Hex(frames[len(frames)-1].Output)
+		}
+	}
+
+	for _, change := range replayed.Storage {
+		result.Storage = append(result.Storage, rpcjson.StorageChangeResult{
+			Address: change.Address.String(),
+			Key:     change.Key.UnprefixString(),
+			Pre:     change.Pre.UnprefixString(),
+			Post:    change.Post.UnprefixString(),
+		})
+	}
+	for _, change := range replayed.Balances {
+		result.Balances = append(result.Balances, rpcjson.BalanceChangeResult{
+			Address: change.Address.String(),
+			Asset:   assetString(&change.Asset),
+			Pre:     change.Pre,
+			Post:    change.Post,
+		})
+	}
+
+	if replayed.Vtx != nil {
+		vtx, err := createTxRawResult(replayed.Vtx, replayed.Vtx.TxHash().UnprefixString(),
+			nil, blkHash, blkHeight, chainHeight)
+		if err != nil {
+			return nil, err
+		}
+		result.Vtx = vtx
+	}
+	return result, nil
+}
+
+// createStructLogResults converts the opcodes captured by the struct logger
+// into their JSON-RPC representation.  Stack items and memory words are
+// encoded as 32 byte hex strings.
+func createStructLogResults(logs []vm.StructLog) []rpcjson.StructLogResult {
+	results := make([]rpcjson.StructLogResult, len(logs))
+	for i, log := range logs {
+		result := &results[i]
+		result.Pc = log.Pc
+		result.Op = log.Op.String()
+		result.Gas = log.Gas
+		result.GasCost = log.GasCost
+		result.Depth = log.Depth
+		result.Error = log.ErrorString()
+		if log.Stack != nil {
+			result.Stack = make([]string, len(log.Stack))
+			for j, item := range log.Stack {
+				result.Stack[j] = common.Bytes2Hex(math.PaddedBigBytes(item, 32))
+			}
+		}
+		if log.Memory != nil {
+			result.Memory = make([]string, 0, (len(log.Memory)+31)/32)
+			for j := 0; j < len(log.Memory); j += 32 {
+				end := j + 32
+				if end > len(log.Memory) {
+					end = len(log.Memory)
+				}
+				result.Memory = append(result.Memory, common.Bytes2Hex(log.Memory[j:end]))
+			}
+		}
+		if log.Storage != nil {
+			result.Storage = make(map[string]string, len(log.Storage))
+			for key, value := range log.Storage {
+				result.Storage[key.UnprefixString()] = value.UnprefixString()
+			}
+		}
+	}
+	return results
+}
+
+// createCallFrameResult converts a frame captured by the call tracer, and the
+// frames of the calls it made, into its JSON-RPC representation.
+func createCallFrameResult(frame *vm.CallFrame) rpcjson.CallFrameResult {
+	result := rpcjson.CallFrameResult{
+		Type:    frame.Type.String(),
+		From:    frame.From.String(),
+		Value:   valueString(frame.Value),
+		Asset:   assetString(frame.Asset),
+		Gas:     frame.Gas,
+		GasUsed: frame.GasUsed,
+		Input:   common.Bytes2Hex(frame.Input),
+		Output:  common.Bytes2Hex(frame.Output),
+		Error:   frame.Error,
+	}
+	if frame.To != (common.Address{}) {
+		result.To = frame.To.String()
+	}
+	for _, transfer := range frame.Transfers {
+		result.Transfers = append(result.Transfers, createVTransferResult(transfer))
+	}
+	for _, call := range frame.Calls {
+		result.Calls = append(result.Calls, createCallFrameResult(call))
+	}
+	return result
+}
+
+// createVTransferResult converts an asset transfer recorded in a virtual
+// transaction into its JSON-RPC representation.
+func createVTransferResult(transfer *virtualtx.VTransfer) rpcjson.VTransferResult {
+	result := rpcjson.VTransferResult{
+		To:     common.BytesToAddress(transfer.To).String(),
+		Amount: transfer.Amount,
+		Asset:  assetString(transfer.Asset),
+	}
+	switch transfer.VTransferType {
+	case virtualtx.VTransferTypeCreation:
+		result.Type = "creation"
+	case virtualtx.VTransferTypeMint:
+		result.Type = "mint"
+	default:
+		result.Type = "transfer"
+	}
+	if transfer.From != nil {
+		result.From = common.BytesToAddress(transfer.From).String()
+	}
+	return result
+}
+
+// valueString returns the decimal representation of the passed value.
+func valueString(value *big.Int) string {
+	if value == nil {
+		return "0"
+	}
+	return value.String()
+}
+
+// assetString returns the hex representation of the passed asset, or an
+// empty string when there is none.
+func assetString(asset *protos.Asset) string {
+	if asset == nil {
+		return ""
+	}
+	return common.Bytes2Hex(asset.Bytes())
+}
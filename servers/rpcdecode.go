@@ -0,0 +1,320 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package servers
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/blockchain"
+	"github.com/AsimovNetwork/asimov/chaincfg"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/common/hexutil"
+	"github.com/AsimovNetwork/asimov/protos"
+	"github.com/AsimovNetwork/asimov/rpcs/rpcjson"
+	"github.com/AsimovNetwork/asimov/txscript"
+	"github.com/AsimovNetwork/asimov/vm/fvm/abi"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/state"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/types"
+)
+
+// templateABI is the parsed ABI of the template a contract is deployed from.
+type templateABI struct {
+	name string
+	abi  abi.ABI
+}
+
+// eventDecoder decodes contract logs and revert reasons through the ABI of
+// the template the contract is deployed from.  The templates are resolved
+// against the state of the current tip and cached for the lifetime of the
+// decoder, which is meant to serve a single request.
+type eventDecoder struct {
+	cfg       *rpcserverConfig
+	block     *asiutil.Block
+	stateDB   *state.StateDB
+	templates map[common.Address]*templateABI
+	names     map[string]*templateABI
+}
+
+// newEventDecoder returns an event decoder for a single request.
+func newEventDecoder(cfg *rpcserverConfig) *eventDecoder {
+	return &eventDecoder{
+		cfg:       cfg,
+		templates: make(map[common.Address]*templateABI),
+		names:     make(map[string]*templateABI),
+	}
+}
+
+// tipState lazily creates the block and state of the current tip used to
+// resolve templates.  It returns false when the state is not available.
+func (d *eventDecoder) tipState() bool {
+	if d.stateDB == nil {
+		d.block, d.stateDB = createTempBlockState(d.cfg)
+	}
+	return d.stateDB != nil
+}
+
+// template returns the ABI of the template of the contract at the passed
+// address, or nil when the address is no contract deployed from a template
+// of the warehouse.
+func (d *eventDecoder) template(addr common.Address) *templateABI {
+	if t, ok := d.templates[addr]; ok {
+		return t
+	}
+	d.templates[addr] = nil
+	if addr[0] != common.ContractHashAddrID || !d.tipState() {
+		return nil
+	}
+
+	category, name, _ := d.cfg.Chain.GetTemplateInfo(addr.Bytes(),
+		common.SystemContractReadOnlyGas, d.block, d.stateDB,
+		chaincfg.ActiveNetParams.FvmParam)
+	if name == "" {
+		return nil
+	}
+	t := d.templateByName(category, name)
+	d.templates[addr] = t
+	return t
+}
+
+// templateByName returns the ABI of the template registered in the warehouse
+// with the passed category and name, or nil when there is none.
+func (d *eventDecoder) templateByName(category uint16, name string) *templateABI {
+	id := fmt.Sprintf("%d/%s", category, name)
+	if t, ok := d.names[id]; ok {
+		return t
+	}
+	d.names[id] = nil
+	if !d.tipState() {
+		return nil
+	}
+
+	content, ok, _ := d.cfg.ContractMgr.GetTemplate(d.block,
+		common.SystemContractReadOnlyGas, d.stateDB,
+		chaincfg.ActiveNetParams.FvmParam, category, name)
+	if !ok {
+		return nil
+	}
+	key := common.HexToHash(content.Key)
+	_, _, _, abiBytes, _, err := d.cfg.Chain.FetchTemplate(nil, &key)
+	if err != nil {
+		rpcsLog.Debugf("Failed to fetch template %s: %v", name, err)
+		return nil
+	}
+	definition, err := abi.JSON(bytes.NewReader(abiBytes))
+	if err != nil {
+		rpcsLog.Debugf("Failed to parse abi of template %s: %v", name, err)
+		return nil
+	}
+
+	t := &templateABI{name: name, abi: definition}
+	d.names[id] = t
+	return t
+}
+
+// txTemplate returns the template of the contract called or created by the
+// passed transaction, or nil when it is unknown.
+func (d *eventDecoder) txTemplate(tx *protos.MsgTx) *templateABI {
+	if len(tx.TxOut) == 0 {
+		return nil
+	}
+	out := tx.TxOut[0]
+	class, addrs, _, err := txscript.ExtractPkScriptAddrs(out.PkScript)
+	if err != nil {
+		return nil
+	}
+	switch class {
+	case txscript.CreateTy:
+		category, name, _, ok := blockchain.DecodeCreateContractData(out.Data)
+		if !ok {
+			return nil
+		}
+		return d.templateByName(category, name)
+	case txscript.CallTy, txscript.VoteTy:
+		if len(addrs) == 0 {
+			return nil
+		}
+		return d.template(addrs[0].StandardAddress())
+	}
+	return nil
+}
+
+// decode decodes the passed log.  It returns nil when the template of the
+// contract is unknown or its ABI does not describe the log.
+func (d *eventDecoder) decode(log *types.Log) *rpcjson.DecodedEventResult {
+	t := d.template(log.Address)
+	if t == nil {
+		return nil
+	}
+
+	var event *abi.Event
+	if len(log.Topics) > 0 {
+		event, _ = t.abi.EventByID(log.Topics[0])
+	}
+	if event == nil {
+		// Anonymous events carry no signature topic, so it is only
+		// possible to pick one when the template has a single one.
+		for _, e := range t.abi.Events {
+			if !e.Anonymous {
+				continue
+			}
+			if event != nil {
+				return nil
+			}
+			e := e
+			event = &e
+		}
+		if event == nil {
+			return nil
+		}
+	}
+
+	values, err := event.UnpackLog(log.Topics, log.Data)
+	if err != nil {
+		rpcsLog.Debugf("Failed to decode event %s of log %d in tx %s: %v",
+			event.Name, log.Index, log.TxHash.String(), err)
+		return nil
+	}
+
+	args := make([]rpcjson.EventArgResult, 0, len(values))
+	for i, input := range event.Inputs {
+		args = append(args, rpcjson.EventArgResult{
+			Name:    input.Name,
+			Type:    input.Type.String(),
+			Indexed: input.Indexed,
+			Value:   eventValue(reflect.ValueOf(values[i])),
+		})
+	}
+	return &rpcjson.DecodedEventResult{
+		Name:      event.RawName,
+		Signature: event.Sig(),
+		Template:  t.name,
+		Args:      args,
+	}
+}
+
+// decodeLogResults sets the decoded event of every log result.  The results
+// must be created from the passed logs, in the same order.
+func (d *eventDecoder) decodeLogResults(results []*rpcjson.LogResult, logs []*types.Log) {
+	for i, log := range logs {
+		results[i].Event = d.decode(log)
+	}
+}
+
+// eventValue converts a value unpacked from a log into its JSON-RPC
+// representation.  Integers are encoded as decimal strings so no precision
+// is lost by clients, addresses, hashes and byte strings as hex.
+func eventValue(v reflect.Value) interface{} {
+	switch value := v.Interface().(type) {
+	case *big.Int:
+		return value.String()
+	case common.Address:
+		return value.String()
+	case common.Hash:
+		return value.String()
+	case []byte:
+		return hexutil.Encode(value)
+	case string, bool:
+		return value
+	}
+
+	switch v.Kind() {
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return fmt.Sprintf("%d", v.Int())
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return fmt.Sprintf("%d", v.Uint())
+	case reflect.Array:
+		// Fixed size byte arrays.
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			return hexutil.Encode(b)
+		}
+		fallthrough
+	case reflect.Slice:
+		values := make([]interface{}, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			values = append(values, eventValue(v.Index(i)))
+		}
+		return values
+	case reflect.Struct:
+		fields := make(map[string]interface{}, v.NumField())
+		for i := 0; i < v.NumField(); i++ {
+			name := v.Type().Field(i).Tag.Get("json")
+			if name == "" {
+				name = v.Type().Field(i).Name
+			}
+			fields[name] = eventValue(v.Field(i))
+		}
+		return fields
+	}
+	return v.Interface()
+}
+
+// decodeRevert decodes the data returned by a reverted contract execution.
+// The builtin solidity errors are always decoded, custom errors only when
+// the ABI of the template of the contract is known.
+func (d *eventDecoder) decodeRevert(data []byte, t *templateABI) *rpcjson.RevertReasonResult {
+	if len(data) == 0 {
+		return nil
+	}
+	result := &rpcjson.RevertReasonResult{Data: common.Bytes2Hex(data)}
+	if reason, err := abi.UnpackRevert(data); err == nil {
+		result.Reason = reason
+		return result
+	}
+	if t == nil {
+		return result
+	}
+	e, err := t.abi.ErrorByID(data)
+	if err != nil {
+		return result
+	}
+	values, err := e.Unpack(data)
+	if err != nil {
+		rpcsLog.Debugf("Failed to decode error %s: %v", e.Name, err)
+		return result
+	}
+
+	args := make([]rpcjson.EventArgResult, 0, len(values))
+	for i, input := range e.Inputs {
+		args = append(args, rpcjson.EventArgResult{
+			Name:  input.Name,
+			Type:  input.Type.String(),
+			Value: eventValue(reflect.ValueOf(values[i])),
+		})
+	}
+	result.Error = &rpcjson.DecodedErrorResult{
+		Name:      e.RawName,
+		Signature: e.Sig(),
+		Template:  t.name,
+		Args:      args,
+	}
+	return result
+}
+
+// revertError returns the error of a reverted contract execution, describing
+// the decoded revert reason when there is one.
+func revertError(revert *rpcjson.RevertReasonResult) *rpcjson.RPCError {
+	msg := "execution reverted"
+	switch {
+	case revert == nil:
+	case revert.Reason != "":
+		msg += ": " + revert.Reason
+	case revert.Error != nil:
+		values := make([]string, 0, len(revert.Error.Args))
+		for _, arg := range revert.Error.Args {
+			values = append(values, fmt.Sprint(arg.Value))
+		}
+		msg += fmt.Sprintf(": %s(%s)", revert.Error.Name, strings.Join(values, ", "))
+	default:
+		msg += ": 0x" + revert.Data
+	}
+	return rpcjson.NewRPCError(rpcjson.ErrRPCExecutionReverted, msg)
+}
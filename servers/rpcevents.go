@@ -0,0 +1,382 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Copyright 2015 The go-ethereum Authors
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package servers
+
+import (
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/blockchain"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/mempool"
+	"github.com/AsimovNetwork/asimov/rpcs/rpc"
+	"github.com/AsimovNetwork/asimov/rpcs/rpcjson"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/types"
+	"github.com/AsimovNetwork/asimov/vm/fvm/event"
+	"sync"
+	"time"
+)
+
+// subscriptionType determines the kind of events a subscription receives.
+type subscriptionType byte
+
+const (
+	// logsSubscription queries for new logs and logs removed by a chain
+	// reorganization.
+	logsSubscription subscriptionType = iota
+
+	// removedLogsSubscription queries only for logs removed by a chain
+	// reorganization.
+	removedLogsSubscription
+
+	// pendingTransactionsSubscription queries for hashes of transactions
+	// entering the memory pool.
+	pendingTransactionsSubscription
+
+	// blocksSubscription queries for blocks connected to the main chain.
+	blocksSubscription
+)
+
+const (
+	// txChanSize is the size of the channel listening to NewTxsEvent.
+	txChanSize = 4096
+
+	// chainHeadChanSize is the size of the channel listening to
+	// ChainHeadEvent.
+	chainHeadChanSize = 10
+
+	// logsChanSize is the size of the channel listening to new logs.
+	logsChanSize = 10
+
+	// rmLogsChanSize is the size of the channel listening to
+	// RemovedLogsEvent.
+	rmLogsChanSize = 10
+)
+
+// subscription is a single installed filter of the event system.
+type subscription struct {
+	id        rpc.ID
+	typ       subscriptionType
+	created   time.Time
+	logsCrit  rpcjson.FilterCriteria
+	logs      chan []*types.Log
+	hashes    chan []common.Hash
+	headers   chan *asiutil.Block
+	installed chan struct{} // closed when the filter is installed
+	err       chan error    // closed when the filter is uninstalled
+}
+
+// EventSystem creates subscriptions, processes chain and memory pool events
+// and broadcasts them to the subscriptions which match the event.
+type EventSystem struct {
+	chain  *blockchain.BlockChain
+	txPool *mempool.TxPool
+
+	// Subscriptions
+	txsSub    event.Subscription
+	logsSub   event.Subscription
+	rmLogsSub event.Subscription
+	chainSub  event.Subscription
+
+	// Channels
+	install   chan *subscription
+	uninstall chan *subscription
+	txsCh     chan mempool.NewTxsEvent
+	logsCh    chan []*types.Log
+	rmLogsCh  chan core.RemovedLogsEvent
+	chainCh   chan blockchain.ChainHeadEvent
+	quit      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// NewEventSystem creates a new manager that listens for events on the given
+// chain and memory pool.  The returned manager has a loop that needs to be
+// stopped with the Stop function.
+func NewEventSystem(chain *blockchain.BlockChain, txPool *mempool.TxPool) *EventSystem {
+	es := &EventSystem{
+		chain:     chain,
+		txPool:    txPool,
+		install:   make(chan *subscription),
+		uninstall: make(chan *subscription),
+		txsCh:     make(chan mempool.NewTxsEvent, txChanSize),
+		logsCh:    make(chan []*types.Log, logsChanSize),
+		rmLogsCh:  make(chan core.RemovedLogsEvent, rmLogsChanSize),
+		chainCh:   make(chan blockchain.ChainHeadEvent, chainHeadChanSize),
+		quit:      make(chan struct{}),
+	}
+
+	// Subscribe events
+	es.txsSub = es.txPool.SubscribeNewTxsEvent(es.txsCh)
+	es.logsSub = es.chain.SubscribeLogsEvent(es.logsCh)
+	es.rmLogsSub = es.chain.SubscribeRemovedLogsEvent(es.rmLogsCh)
+	es.chainSub = es.chain.SubscribeChainHeadEvent(es.chainCh)
+
+	es.wg.Add(1)
+	go es.eventLoop()
+	return es
+}
+
+// Stop unsubscribes from the chain and memory pool feeds and waits for the
+// event loop to exit.
+func (es *EventSystem) Stop() {
+	close(es.quit)
+	es.wg.Wait()
+}
+
+// eventSubscription is returned to callers of the Subscribe functions so they
+// can release the subscription once they are no longer interested in events.
+type eventSubscription struct {
+	es        *EventSystem
+	f         *subscription
+	unsubOnce sync.Once
+}
+
+// ID returns the unique identifier of the subscription.
+func (sub *eventSubscription) ID() rpc.ID {
+	return sub.f.id
+}
+
+// Err returns a channel that is closed when the subscription is uninstalled.
+func (sub *eventSubscription) Err() <-chan error {
+	return sub.f.err
+}
+
+// Unsubscribe uninstalls the subscription from the event loop.  It is safe
+// to call Unsubscribe multiple times.
+func (sub *eventSubscription) Unsubscribe() {
+	sub.unsubOnce.Do(func() {
+	uninstallLoop:
+		for {
+			// Write the uninstall request and consume logs/hashes.  This
+			// prevents the event loop broadcast method from deadlocking
+			// when writing to the subscription channel while the
+			// subscription is being uninstalled.
+			select {
+			case sub.es.uninstall <- sub.f:
+				break uninstallLoop
+			case <-sub.es.quit:
+				break uninstallLoop
+			case <-sub.f.logs:
+			case <-sub.f.hashes:
+			case <-sub.f.headers:
+			}
+		}
+
+		// Wait for the filter to be uninstalled in the event loop.
+		select {
+		case <-sub.f.err:
+		case <-sub.es.quit:
+		}
+	})
+}
+
+// subscribe installs the subscription in the event loop.
+func (es *EventSystem) subscribe(sub *subscription) *eventSubscription {
+	select {
+	case es.install <- sub:
+		<-sub.installed
+	case <-es.quit:
+	}
+	return &eventSubscription{es: es, f: sub}
+}
+
+// newSubscription creates an uninstalled subscription of the given type.
+func newSubscription(typ subscriptionType, crit rpcjson.FilterCriteria) *subscription {
+	return &subscription{
+		id:        rpc.NewID(),
+		typ:       typ,
+		created:   time.Now(),
+		logsCrit:  crit,
+		logs:      make(chan []*types.Log),
+		hashes:    make(chan []common.Hash),
+		headers:   make(chan *asiutil.Block),
+		installed: make(chan struct{}),
+		err:       make(chan error),
+	}
+}
+
+// SubscribeLogs creates a subscription that will write all logs matching the
+// given criteria to the given logs channel.  Logs removed by a chain
+// reorganization are delivered with their Removed field set.
+func (es *EventSystem) SubscribeLogs(crit rpcjson.FilterCriteria, logs chan []*types.Log) *eventSubscription {
+	sub := newSubscription(logsSubscription, crit)
+	sub.logs = logs
+	return es.subscribe(sub)
+}
+
+// SubscribeRemovedLogs creates a subscription that will write all logs
+// matching the given criteria which are removed by a chain reorganization.
+func (es *EventSystem) SubscribeRemovedLogs(crit rpcjson.FilterCriteria, logs chan []*types.Log) *eventSubscription {
+	sub := newSubscription(removedLogsSubscription, crit)
+	sub.logs = logs
+	return es.subscribe(sub)
+}
+
+// SubscribePendingTxs creates a subscription that writes transaction hashes
+// for transactions that enter the memory pool.
+func (es *EventSystem) SubscribePendingTxs(hashes chan []common.Hash) *eventSubscription {
+	sub := newSubscription(pendingTransactionsSubscription, rpcjson.FilterCriteria{})
+	sub.hashes = hashes
+	return es.subscribe(sub)
+}
+
+// SubscribeNewHeads creates a subscription that writes every block connected
+// to the main chain.
+func (es *EventSystem) SubscribeNewHeads(headers chan *asiutil.Block) *eventSubscription {
+	sub := newSubscription(blocksSubscription, rpcjson.FilterCriteria{})
+	sub.headers = headers
+	return es.subscribe(sub)
+}
+
+type filterIndex map[subscriptionType]map[rpc.ID]*subscription
+
+// broadcast dispatches the passed event to all subscriptions interested in it.
+func (es *EventSystem) broadcast(filters filterIndex, ev interface{}) {
+	if ev == nil {
+		return
+	}
+
+	switch e := ev.(type) {
+	case []*types.Log:
+		if len(e) > 0 {
+			for _, f := range filters[logsSubscription] {
+				if matched := filterLogs(e, nil, f.logsCrit.FromBlock,
+					f.logsCrit.ToBlock, f.logsCrit.Addresses,
+					f.logsCrit.Topics); len(matched) > 0 {
+					f.logs <- matched
+				}
+			}
+		}
+	case core.RemovedLogsEvent:
+		for _, typ := range []subscriptionType{logsSubscription, removedLogsSubscription} {
+			for _, f := range filters[typ] {
+				if matched := filterLogs(e.Logs, nil, f.logsCrit.FromBlock,
+					f.logsCrit.ToBlock, f.logsCrit.Addresses,
+					f.logsCrit.Topics); len(matched) > 0 {
+					f.logs <- matched
+				}
+			}
+		}
+	case mempool.NewTxsEvent:
+		hashes := make([]common.Hash, 0, len(e.Txs))
+		for _, tx := range e.Txs {
+			hashes = append(hashes, *tx.Hash())
+		}
+		for _, f := range filters[pendingTransactionsSubscription] {
+			f.hashes <- hashes
+		}
+	case blockchain.ChainHeadEvent:
+		for _, f := range filters[blocksSubscription] {
+			f.headers <- e.Block
+		}
+	}
+}
+
+// eventLoop (un)installs filters and processes chain and memory pool events.
+func (es *EventSystem) eventLoop() {
+	defer es.wg.Done()
+
+	// Ensure all subscriptions get cleaned up
+	defer func() {
+		es.txsSub.Unsubscribe()
+		es.logsSub.Unsubscribe()
+		es.rmLogsSub.Unsubscribe()
+		es.chainSub.Unsubscribe()
+	}()
+
+	index := make(filterIndex)
+	for typ := logsSubscription; typ <= blocksSubscription; typ++ {
+		index[typ] = make(map[rpc.ID]*subscription)
+	}
+
+	for {
+		select {
+		case ev := <-es.txsCh:
+			es.broadcast(index, ev)
+		case ev := <-es.logsCh:
+			es.broadcast(index, ev)
+		case ev := <-es.rmLogsCh:
+			es.broadcast(index, ev)
+		case ev := <-es.chainCh:
+			es.broadcast(index, ev)
+
+		case f := <-es.install:
+			index[f.typ][f.id] = f
+			close(f.installed)
+
+		case f := <-es.uninstall:
+			delete(index[f.typ], f.id)
+			close(f.err)
+
+		// System stopped
+		case <-es.txsSub.Err():
+			return
+		case <-es.logsSub.Err():
+			return
+		case <-es.rmLogsSub.Err():
+			return
+		case <-es.chainSub.Err():
+			return
+		case <-es.quit:
+			return
+		}
+	}
+}
+
+// filterLogs returns the logs which match the given block range, contract
+// addresses and topics.  A nil blockHash, fromBlock or toBlock is not
+// checked.
+func filterLogs(logs []*types.Log, blockHash *common.Hash, fromBlock, toBlock *int32,
+	addresses []common.Address, topics [][]common.Hash) []*types.Log {
+
+	var ret []*types.Log
+Logs:
+	for _, log := range logs {
+		if blockHash != nil && log.BlockHash != *blockHash {
+			continue
+		}
+		if fromBlock != nil && *fromBlock >= 0 && log.BlockNumber < uint64(*fromBlock) {
+			continue
+		}
+		if toBlock != nil && *toBlock >= 0 && log.BlockNumber > uint64(*toBlock) {
+			continue
+		}
+
+		if len(addresses) > 0 && !includesAddress(addresses, log.Address) {
+			continue
+		}
+
+		// If the to filtered topics is greater than the amount of topics in
+		// logs, skip.
+		if len(topics) > len(log.Topics) {
+			continue
+		}
+		for i, sub := range topics {
+			// Empty rule set matches any topic at this position.
+			match := len(sub) == 0
+			for _, topic := range sub {
+				if log.Topics[i] == topic {
+					match = true
+					break
+				}
+			}
+			if !match {
+				continue Logs
+			}
+		}
+		ret = append(ret, log)
+	}
+	return ret
+}
+
+// includesAddress returns whether the address is contained in the list.
+func includesAddress(addresses []common.Address, a common.Address) bool {
+	for _, addr := range addresses {
+		if addr == a {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,546 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package servers
+
+import (
+	"context"
+	"fmt"
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/blockchain/indexers"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/rpcs/rawdb"
+	"github.com/AsimovNetwork/asimov/rpcs/rpc"
+	"github.com/AsimovNetwork/asimov/rpcs/rpcjson"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/types"
+	"time"
+)
+
+const (
+	// filterTimeout is the amount of time a polling filter is kept alive
+	// without being polled by GetFilterChanges.
+	filterTimeout = 5 * time.Minute
+)
+
+// filter is a polling filter installed through NewFilter, NewBlockFilter or
+// NewPendingTransactionFilter.  Events are buffered until they are fetched
+// with GetFilterChanges.
+type filter struct {
+	typ      subscriptionType
+	deadline *time.Timer // filter is inactive when deadline triggers
+	hashes   []common.Hash
+	crit     rpcjson.FilterCriteria
+	logs     []*types.Log
+	s        *eventSubscription // associated subscription in event system
+}
+
+// timeoutLoop runs every filterTimeout and deletes filters that have not
+// been polled recently.
+func (s *PublicRpcAPI) timeoutLoop() {
+	ticker := time.NewTicker(filterTimeout)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.events.quit:
+			return
+		}
+
+		s.filtersMu.Lock()
+		for id, f := range s.filters {
+			select {
+			case <-f.deadline.C:
+				delete(s.filters, id)
+				f.s.Unsubscribe()
+			default:
+				continue
+			}
+		}
+		s.filtersMu.Unlock()
+	}
+}
+
+// NewPendingTransactionFilter creates a filter that fetches hashes of the
+// transactions entering the memory pool.  Use GetFilterChanges to poll it.
+func (s *PublicRpcAPI) NewPendingTransactionFilter() rpc.ID {
+	pendingTxs := make(chan []common.Hash)
+	pendingTxSub := s.events.SubscribePendingTxs(pendingTxs)
+
+	s.filtersMu.Lock()
+	s.filters[pendingTxSub.ID()] = &filter{typ: pendingTransactionsSubscription,
+		deadline: time.NewTimer(filterTimeout), s: pendingTxSub}
+	s.filtersMu.Unlock()
+
+	go func() {
+		for {
+			select {
+			case hashes := <-pendingTxs:
+				s.filtersMu.Lock()
+				if f, found := s.filters[pendingTxSub.ID()]; found {
+					f.hashes = append(f.hashes, hashes...)
+				}
+				s.filtersMu.Unlock()
+			case <-pendingTxSub.Err():
+				return
+			}
+		}
+	}()
+
+	return pendingTxSub.ID()
+}
+
+// NewBlockFilter creates a filter that fetches hashes of the blocks connected
+// to the main chain.  Use GetFilterChanges to poll it.
+func (s *PublicRpcAPI) NewBlockFilter() rpc.ID {
+	headers := make(chan *asiutil.Block)
+	headerSub := s.events.SubscribeNewHeads(headers)
+
+	s.filtersMu.Lock()
+	s.filters[headerSub.ID()] = &filter{typ: blocksSubscription,
+		deadline: time.NewTimer(filterTimeout), s: headerSub}
+	s.filtersMu.Unlock()
+
+	go func() {
+		for {
+			select {
+			case block := <-headers:
+				s.filtersMu.Lock()
+				if f, found := s.filters[headerSub.ID()]; found {
+					f.hashes = append(f.hashes, *block.Hash())
+				}
+				s.filtersMu.Unlock()
+			case <-headerSub.Err():
+				return
+			}
+		}
+	}()
+
+	return headerSub.ID()
+}
+
+// NewFilter creates a filter that collects the contract logs matching the
+// given criteria as they are connected to, or removed from, the main chain.
+// Use GetFilterChanges to poll it, or GetFilterLogs to query the full range
+// described by the criteria.
+func (s *PublicRpcAPI) NewFilter(crit rpcjson.FilterCriteria) (rpc.ID, error) {
+	logs := make(chan []*types.Log)
+	logsSub := s.events.SubscribeLogs(crit, logs)
+
+	s.filtersMu.Lock()
+	s.filters[logsSub.ID()] = &filter{typ: logsSubscription, crit: crit,
+		deadline: time.NewTimer(filterTimeout), s: logsSub}
+	s.filtersMu.Unlock()
+
+	go func() {
+		for {
+			select {
+			case l := <-logs:
+				s.filtersMu.Lock()
+				if f, found := s.filters[logsSub.ID()]; found {
+					f.logs = append(f.logs, l...)
+				}
+				s.filtersMu.Unlock()
+			case <-logsSub.Err():
+				return
+			}
+		}
+	}()
+
+	return logsSub.ID(), nil
+}
+
+// UninstallFilter removes the filter with the given id.  It returns whether
+// the filter existed.
+func (s *PublicRpcAPI) UninstallFilter(id rpc.ID) bool {
+	s.filtersMu.Lock()
+	f, found := s.filters[id]
+	if found {
+		delete(s.filters, id)
+	}
+	s.filtersMu.Unlock()
+	if found {
+		f.s.Unsubscribe()
+	}
+
+	return found
+}
+
+// GetFilterChanges returns the events collected by the filter with the given
+// id since the last poll.  For block and pending transaction filters it
+// returns a list of hashes, for log filters a list of logs.
+func (s *PublicRpcAPI) GetFilterChanges(id rpc.ID) (interface{}, error) {
+	s.filtersMu.Lock()
+	f, found := s.filters[id]
+	if !found {
+		s.filtersMu.Unlock()
+		return nil, filterNotFoundError(id)
+	}
+
+	if !f.deadline.Stop() {
+		// timer expired but filter is not yet removed in timeout loop
+		// receive timer value and reset timer
+		<-f.deadline.C
+	}
+	f.deadline.Reset(filterTimeout)
+
+	switch f.typ {
+	case pendingTransactionsSubscription, blocksSubscription:
+		hashes := make([]string, 0, len(f.hashes))
+		for _, hash := range f.hashes {
+			hashes = append(hashes, hash.UnprefixString())
+		}
+		f.hashes = nil
+		s.filtersMu.Unlock()
+		return hashes, nil
+	case logsSubscription:
+		logs, crit := f.logs, f.crit
+		f.logs = nil
+		// Decoding executes contract calls, so it is done without
+		// holding the filters lock.
+		s.filtersMu.Unlock()
+		return s.createLogResults(logs, crit.Decode), nil
+	}
+	s.filtersMu.Unlock()
+
+	return []interface{}{}, nil
+}
+
+// GetFilterLogs returns all logs matching the criteria of the log filter with
+// the given id over the block range of that criteria.
+func (s *PublicRpcAPI) GetFilterLogs(id rpc.ID) ([]*rpcjson.LogResult, error) {
+	s.filtersMu.Lock()
+	f, found := s.filters[id]
+	s.filtersMu.Unlock()
+
+	if !found || f.typ != logsSubscription {
+		return nil, filterNotFoundError(id)
+	}
+
+	return s.GetLogs(f.crit)
+}
+
+// GetLogs returns the contract logs matching the given criteria.  Either a
+// single block is queried through the block hash, or the inclusive range
+// between fromBlock and toBlock, which default to the current best height.
+// Blocks whose blooms cannot contain a match are skipped without inspecting
+// their logs, and when the bloom bits index is enabled most of them are not
+// even loaded.  A range covering more blocks than the configured limit is
+// rejected.
+func (s *PublicRpcAPI) GetLogs(crit rpcjson.FilterCriteria) ([]*rpcjson.LogResult, error) {
+	logs, err := s.getLogs(&crit)
+	if err != nil {
+		return nil, err
+	}
+	return s.createLogResults(logs, crit.Decode), nil
+}
+
+// getLogs collects the logs matching the passed criteria from the receipts
+// stored in the chain.
+func (s *PublicRpcAPI) getLogs(crit *rpcjson.FilterCriteria) ([]*types.Log, error) {
+	chain := s.cfg.Chain
+
+	if crit.BlockHash != nil {
+		height, err := chain.BlockHeightByHash(crit.BlockHash)
+		if err != nil {
+			return nil, &rpcjson.RPCError{
+				Code:    rpcjson.ErrRPCBlockNotFound,
+				Message: "Block not found",
+			}
+		}
+		return s.blockLogs(crit.BlockHash, height, crit), nil
+	}
+
+	best := chain.BestSnapshot().Height
+	from, to := best, best
+	if crit.FromBlock != nil && *crit.FromBlock >= 0 {
+		from = *crit.FromBlock
+	}
+	if crit.ToBlock != nil && *crit.ToBlock >= 0 {
+		to = *crit.ToBlock
+	}
+	if to > best {
+		to = best
+	}
+	if from > to {
+		return nil, &rpcjson.RPCError{
+			Code: rpcjson.ErrRPCInvalidParameter,
+			Message: fmt.Sprintf("Invalid block range, fromBlock %d "+
+				"is greater than toBlock %d", from, to),
+		}
+	}
+	if to-from >= s.cfg.MaxLogRange {
+		return nil, &rpcjson.RPCError{
+			Code: rpcjson.ErrRPCInvalidParameter,
+			Message: fmt.Sprintf("Invalid block range, %d blocks "+
+				"exceed the limit of %d", to-from+1, s.cfg.MaxLogRange),
+		}
+	}
+
+	// Use the bloom bits index to find the candidate blocks of the range
+	// covered by its complete sections, the rest is scanned block by block.
+	var heights []int32
+	next := from
+	if s.cfg.BloomIndex != nil {
+		var err error
+		filters := indexers.BloomFilterKeys(crit.Addresses, crit.Topics)
+		heights, next, err = s.cfg.BloomIndex.MatchHeights(from, to, filters)
+		if err != nil {
+			context := "Failed to search bloom bits index"
+			return nil, internalRPCError(err.Error(), context)
+		}
+	}
+	for height := next; height <= to; height++ {
+		heights = append(heights, height)
+	}
+
+	var logs []*types.Log
+	for _, height := range heights {
+		hash, err := chain.BlockHashByHeight(height)
+		if err != nil {
+			context := "Failed to get block hash by height"
+			return nil, internalRPCError(err.Error(), context)
+		}
+		logs = append(logs, s.blockLogs(hash, height, crit)...)
+	}
+	return logs, nil
+}
+
+// blockLogs returns the logs of the given block which match the criteria.
+// The receipt blooms are checked first, so the logs are only decoded and
+// filtered for blocks which may contain a match.
+func (s *PublicRpcAPI) blockLogs(hash *common.Hash, height int32, crit *rpcjson.FilterCriteria) []*types.Log {
+	receipts := rawdb.ReadReceipts(s.cfg.Chain.EthDB(), *hash, uint64(height))
+	if len(receipts) == 0 {
+		return nil
+	}
+	if !bloomFilter(types.CreateBloom(receipts), crit.Addresses, crit.Topics) {
+		return nil
+	}
+
+	var unfiltered []*types.Log
+	for _, receipt := range receipts {
+		if !bloomFilter(receipt.Bloom, crit.Addresses, crit.Topics) {
+			continue
+		}
+		unfiltered = append(unfiltered, receipt.Logs...)
+	}
+	return filterLogs(unfiltered, nil, nil, nil, crit.Addresses, crit.Topics)
+}
+
+// bloomFilter returns whether the bloom may contain logs matching the given
+// addresses and topics.
+func bloomFilter(bloom types.Bloom, addresses []common.Address, topics [][]common.Hash) bool {
+	if len(addresses) > 0 {
+		var included bool
+		for _, addr := range addresses {
+			if types.BloomLookup(bloom, addr) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+
+	for _, sub := range topics {
+		included := len(sub) == 0 // empty rule set == wildcard
+		for _, topic := range sub {
+			if types.BloomLookup(bloom, topic) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+	return true
+}
+
+// filterNotFoundError returns the RPC error for an unknown filter id.
+func filterNotFoundError(id rpc.ID) *rpcjson.RPCError {
+	return rpcjson.NewRPCError(rpcjson.ErrRPCFilterNotFound,
+		fmt.Sprintf("Filter %s not found", id))
+}
+
+// NewHeads sends a notification each time a block is connected to the main
+// chain.  It is served as the "newHeads" kind of asimov_subscribe.
+func (s *PublicRpcAPI) NewHeads(ctx context.Context) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
+	}
+
+	rpcSub := notifier.CreateSubscription()
+
+	go func() {
+		headers := make(chan *asiutil.Block)
+		headersSub := s.events.SubscribeNewHeads(headers)
+		defer headersSub.Unsubscribe()
+
+		for {
+			select {
+			case block := <-headers:
+				notifier.Notify(rpcSub.ID, createBlockHeaderResult(block))
+			case <-rpcSub.Err():
+				return
+			case <-notifier.Closed():
+				return
+			}
+		}
+	}()
+
+	return rpcSub, nil
+}
+
+// Logs sends a notification for every contract log matching the given
+// criteria.  Logs removed by a chain reorganization are sent again with the
+// removed flag set.  It is served as the "logs" kind of asimov_subscribe.
+func (s *PublicRpcAPI) Logs(ctx context.Context, crit rpcjson.FilterCriteria) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
+	}
+
+	rpcSub := notifier.CreateSubscription()
+
+	go func() {
+		matchedLogs := make(chan []*types.Log)
+		logsSub := s.events.SubscribeLogs(crit, matchedLogs)
+		defer logsSub.Unsubscribe()
+
+		for {
+			select {
+			case logs := <-matchedLogs:
+				for _, result := range s.createLogResults(logs, crit.Decode) {
+					notifier.Notify(rpcSub.ID, result)
+				}
+			case <-rpcSub.Err():
+				return
+			case <-notifier.Closed():
+				return
+			}
+		}
+	}()
+
+	return rpcSub, nil
+}
+
+// RemovedLogs sends a notification for every contract log matching the given
+// criteria which is removed from the main chain by a reorganization.  It is
+// served as the "removedLogs" kind of asimov_subscribe.
+func (s *PublicRpcAPI) RemovedLogs(ctx context.Context, crit rpcjson.FilterCriteria) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
+	}
+
+	rpcSub := notifier.CreateSubscription()
+
+	go func() {
+		removedLogs := make(chan []*types.Log)
+		logsSub := s.events.SubscribeRemovedLogs(crit, removedLogs)
+		defer logsSub.Unsubscribe()
+
+		for {
+			select {
+			case logs := <-removedLogs:
+				for _, result := range s.createLogResults(logs, crit.Decode) {
+					notifier.Notify(rpcSub.ID, result)
+				}
+			case <-rpcSub.Err():
+				return
+			case <-notifier.Closed():
+				return
+			}
+		}
+	}()
+
+	return rpcSub, nil
+}
+
+// NewPendingTransactions sends a notification with the transaction hash each
+// time a transaction is accepted into the memory pool.  It is served as the
+// "newPendingTransactions" kind of asimov_subscribe.
+func (s *PublicRpcAPI) NewPendingTransactions(ctx context.Context) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
+	}
+
+	rpcSub := notifier.CreateSubscription()
+
+	go func() {
+		txHashes := make(chan []common.Hash)
+		pendingTxSub := s.events.SubscribePendingTxs(txHashes)
+		defer pendingTxSub.Unsubscribe()
+
+		for {
+			select {
+			case hashes := <-txHashes:
+				for _, h := range hashes {
+					notifier.Notify(rpcSub.ID, h.UnprefixString())
+				}
+			case <-rpcSub.Err():
+				return
+			case <-notifier.Closed():
+				return
+			}
+		}
+	}()
+
+	return rpcSub, nil
+}
+
+// createBlockHeaderResult converts a freshly connected block into the verbose
+// header result used by the newHeads subscription.
+func createBlockHeaderResult(block *asiutil.Block) *rpcjson.GetBlockHeaderVerboseResult {
+	header := &block.MsgBlock().Header
+	return &rpcjson.GetBlockHeaderVerboseResult{
+		Hash:          block.Hash().UnprefixString(),
+		Confirmations: 1,
+		Height:        block.Height(),
+		Version:       header.Version,
+		MerkleRoot:    header.MerkleRoot.String(),
+		StateRoot:     header.StateRoot.String(),
+		PreviousHash:  header.PrevBlock.String(),
+		Time:          header.Timestamp,
+		GasLimit:      int64(header.GasLimit),
+		GasUsed:       int64(header.GasUsed),
+	}
+}
+
+// createLogResults converts a list of contract logs into their JSON-RPC
+// representation, decoding their events when requested.  It never returns nil
+// so an empty result is encoded as an empty list.
+func (s *PublicRpcAPI) createLogResults(logs []*types.Log, decode bool) []*rpcjson.LogResult {
+	results := make([]*rpcjson.LogResult, 0, len(logs))
+	for _, log := range logs {
+		results = append(results, createLogResult(log))
+	}
+	if decode {
+		newEventDecoder(s.cfg).decodeLogResults(results, logs)
+	}
+	return results
+}
+
+// createLogResult converts a contract log into its JSON-RPC representation.
+func createLogResult(log *types.Log) *rpcjson.LogResult {
+	topics := make([]string, 0, len(log.Topics))
+	for _, topic := range log.Topics {
+		topics = append(topics, topic.String())
+	}
+	return &rpcjson.LogResult{
+		Address:     log.Address.String(),
+		Topics:      topics,
+		Data:        common.Bytes2Hex(log.Data),
+		BlockNumber: log.BlockNumber,
+		TxHash:      log.TxHash.String(),
+		TxIndex:     log.TxIndex,
+		BlockHash:   log.BlockHash.String(),
+		Index:       log.Index,
+		Removed:     log.Removed,
+	}
+}
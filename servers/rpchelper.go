@@ -731,11 +731,19 @@ type rpcserverConfig struct {
 	// TxMemPool defines the transaction memory pool to interact with.
 	TxMemPool *mempool.TxPool
 
+	// EvidencePool defines the pool of the evidences of misbehaving
+	// validators.
+	EvidencePool *mempool.EvidencePool
+
 	// These fields define any optional indexes the RPC NodeServer can make use
 	// of to provide additional data when queried.
-	TxIndex   *indexers.TxIndex
-	AddrIndex *indexers.AddrIndex
-	CfIndex   *indexers.CfIndex
+	TxIndex    *indexers.TxIndex
+	AddrIndex  *indexers.AddrIndex
+	CfIndex    *indexers.CfIndex
+	BloomIndex *indexers.BloomIndex
+
+	// MaxLogRange is the maximum number of blocks a log query may cover.
+	MaxLogRange int32
 
 	Nap fnet.NetAdapter
 
@@ -0,0 +1,364 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package servers
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/AsimovNetwork/asimov/asiutil/psbt"
+	"github.com/AsimovNetwork/asimov/protos"
+	"github.com/AsimovNetwork/asimov/rpcs/rpcjson"
+	"github.com/AsimovNetwork/asimov/txscript"
+)
+
+// Roles of BIP 174 reported by AnalyzePsbt as the next step of a partially
+// signed transaction, in the order they act.
+const (
+	psbtRoleUpdater   = "updater"
+	psbtRoleSigner    = "signer"
+	psbtRoleFinalizer = "finalizer"
+	psbtRoleExtractor = "extractor"
+)
+
+var psbtRoleOrder = map[string]int{
+	psbtRoleUpdater:   0,
+	psbtRoleSigner:    1,
+	psbtRoleFinalizer: 2,
+	psbtRoleExtractor: 3,
+}
+
+// decodePsbt parses a base64 encoded partially signed transaction.
+func decodePsbt(b64 string) (*psbt.Packet, error) {
+	p, err := psbt.NewFromRawBytes(strings.NewReader(b64), true)
+	if err != nil {
+		return nil, &rpcjson.RPCError{
+			Code:    rpcjson.ErrRPCDeserialization,
+			Message: "PSBT decode failed: " + err.Error(),
+		}
+	}
+	return p, nil
+}
+
+// encodePsbt returns the base64 encoding of a partially signed transaction.
+func encodePsbt(p *psbt.Packet) (string, error) {
+	b64, err := p.B64Encode()
+	if err != nil {
+		return "", internalRPCError(err.Error(), "Failed to encode PSBT")
+	}
+	return b64, nil
+}
+
+// createScriptResult returns the JSON object describing a script.
+func createScriptResult(script []byte) *rpcjson.ScriptPubKeyResult {
+	// The disassembled string will contain [error] inline if the script
+	// doesn't fully parse, so ignore the error here.
+	disbuf, _ := txscript.DisasmString(script)
+	scriptClass, addrs, reqSigs, _ := txscript.ExtractPkScriptAddrs(script)
+	encodedAddrs := make([]string, len(addrs))
+	for i, addr := range addrs {
+		encodedAddrs[i] = addr.EncodeAddress()
+	}
+	return &rpcjson.ScriptPubKeyResult{
+		Asm:       disbuf,
+		Hex:       hex.EncodeToString(script),
+		ReqSigs:   int32(reqSigs),
+		Type:      scriptClass.String(),
+		Addresses: encodedAddrs,
+	}
+}
+
+// createUnknownResult returns the unknown key-value pairs of a partially
+// signed transaction, hex encoded.
+func createUnknownResult(keys, values [][]byte) map[string]string {
+	if len(keys) == 0 {
+		return nil
+	}
+	result := make(map[string]string, len(keys))
+	for i := range keys {
+		result[hex.EncodeToString(keys[i])] = hex.EncodeToString(values[i])
+	}
+	return result
+}
+
+// psbtFee returns the fee paid by a partially signed transaction for every
+// divisible asset, or nil when the previous output of an input is unknown.
+func psbtFee(p *psbt.Packet) []rpcjson.FeeResult {
+	var assets []protos.Asset
+	amounts := make(map[protos.Asset]int64)
+	add := func(asset protos.Asset, value int64) {
+		if asset.IsIndivisible() {
+			return
+		}
+		if _, ok := amounts[asset]; !ok {
+			assets = append(assets, asset)
+		}
+		amounts[asset] += value
+	}
+	for _, pInput := range p.Inputs {
+		if pInput.PrevOut == nil {
+			return nil
+		}
+		add(pInput.PrevOut.Asset, pInput.PrevOut.Value)
+	}
+	for _, txOut := range p.UnsignedTx.TxOut {
+		add(txOut.Asset, -txOut.Value)
+	}
+
+	fees := make([]rpcjson.FeeResult, 0, len(assets))
+	for _, asset := range assets {
+		if amounts[asset] > 0 {
+			fees = append(fees, rpcjson.FeeResult{
+				Value: amounts[asset],
+				Asset: hex.EncodeToString(asset.Bytes()),
+			})
+		}
+	}
+	return fees
+}
+
+// ConvertToPsbt converts an unsigned raw transaction into a partially signed
+// transaction.  The previous outputs of the inputs are filled in from the
+// UTXO set when they are found there.
+func (s *PublicRpcAPI) ConvertToPsbt(hexTx string) (interface{}, error) {
+	if len(hexTx)%2 != 0 {
+		hexTx = "0" + hexTx
+	}
+	serializedTx, err := hex.DecodeString(hexTx)
+	if err != nil {
+		return nil, rpcDecodeHexError(hexTx)
+	}
+	var mtx protos.MsgTx
+	err = mtx.Deserialize(bytes.NewReader(serializedTx))
+	if err != nil {
+		return nil, &rpcjson.RPCError{
+			Code:    rpcjson.ErrRPCDeserialization,
+			Message: "TX decode failed: " + err.Error(),
+		}
+	}
+
+	p, err := psbt.NewFromUnsignedTx(&mtx)
+	if err != nil {
+		return nil, &rpcjson.RPCError{
+			Code:    rpcjson.ErrRPCDeserialization,
+			Message: err.Error(),
+		}
+	}
+	for i, txIn := range mtx.TxIn {
+		entry, err := s.cfg.Chain.FetchUtxoEntry(txIn.PreviousOutPoint)
+		if err != nil || entry == nil || entry.IsSpent() {
+			continue
+		}
+		p.Inputs[i].PrevOut = protos.NewTxOut(entry.Amount(), entry.PkScript(),
+			*entry.Asset())
+	}
+	return encodePsbt(p)
+}
+
+// DecodePsbt returns a JSON object representing the base64 encoded partially
+// signed transaction.
+func (s *PublicRpcAPI) DecodePsbt(psbtB64 string) (interface{}, error) {
+	p, err := decodePsbt(psbtB64)
+	if err != nil {
+		return nil, err
+	}
+
+	mtx := p.UnsignedTx
+	result := rpcjson.DecodePsbtResult{
+		Tx: rpcjson.TxRawDecodeResult{
+			Txid:     mtx.TxHash().UnprefixString(),
+			Version:  mtx.Version,
+			Locktime: mtx.LockTime,
+			Vin:      createVinList(mtx),
+			Vout:     createVoutList(mtx, nil),
+		},
+		Inputs:  make([]rpcjson.DecodePsbtInputResult, 0, len(p.Inputs)),
+		Outputs: make([]rpcjson.DecodePsbtOutputResult, 0, len(p.Outputs)),
+		Fee:     psbtFee(p),
+	}
+
+	var keys, values [][]byte
+	for _, kv := range p.Unknowns {
+		keys, values = append(keys, kv.Key), append(values, kv.Value)
+	}
+	result.Unknown = createUnknownResult(keys, values)
+
+	for _, pInput := range p.Inputs {
+		var input rpcjson.DecodePsbtInputResult
+		if pInput.PrevOut != nil {
+			// The previous output is described like an output of a
+			// transaction, including its asset and data.
+			prevTx := protos.MsgTx{TxOut: []*protos.TxOut{pInput.PrevOut}}
+			input.PrevOut = &createVoutList(&prevTx, nil)[0]
+		}
+		for _, ps := range pInput.PartialSigs {
+			input.PartialSigs = append(input.PartialSigs, rpcjson.PsbtPartialSigResult{
+				PubKey:    hex.EncodeToString(ps.PubKey),
+				Signature: hex.EncodeToString(ps.Signature),
+			})
+		}
+		input.SighashType = uint32(pInput.SighashType)
+		if pInput.RedeemScript != nil {
+			input.RedeemScript = createScriptResult(pInput.RedeemScript)
+		}
+		if pInput.FinalScriptSig != nil {
+			disbuf, _ := txscript.DisasmString(pInput.FinalScriptSig)
+			input.FinalScriptSig = &rpcjson.ScriptSig{
+				Asm: disbuf,
+				Hex: hex.EncodeToString(pInput.FinalScriptSig),
+			}
+		}
+		keys, values = nil, nil
+		for _, kv := range pInput.Unknowns {
+			keys, values = append(keys, kv.Key), append(values, kv.Value)
+		}
+		input.Unknown = createUnknownResult(keys, values)
+		result.Inputs = append(result.Inputs, input)
+	}
+
+	for _, pOutput := range p.Outputs {
+		var output rpcjson.DecodePsbtOutputResult
+		if pOutput.RedeemScript != nil {
+			output.RedeemScript = createScriptResult(pOutput.RedeemScript)
+		}
+		keys, values = nil, nil
+		for _, kv := range pOutput.Unknowns {
+			keys, values = append(keys, kv.Key), append(values, kv.Value)
+		}
+		output.Unknown = createUnknownResult(keys, values)
+		result.Outputs = append(result.Outputs, output)
+	}
+
+	return result, nil
+}
+
+// AnalyzePsbt reports which data every input of a partially signed
+// transaction still misses and which role has to act next.
+func (s *PublicRpcAPI) AnalyzePsbt(psbtB64 string) (interface{}, error) {
+	p, err := decodePsbt(psbtB64)
+	if err != nil {
+		return nil, err
+	}
+
+	result := rpcjson.AnalyzePsbtResult{
+		Inputs: make([]rpcjson.AnalyzePsbtInputResult, 0, len(p.Inputs)),
+		Fee:    psbtFee(p),
+		Next:   psbtRoleExtractor,
+	}
+	for i, pInput := range p.Inputs {
+		input := rpcjson.AnalyzePsbtInputResult{
+			HasUtxo: pInput.PrevOut != nil,
+			IsFinal: pInput.FinalScriptSig != nil,
+		}
+		switch {
+		case input.IsFinal:
+		case !input.HasUtxo:
+			input.Next = psbtRoleUpdater
+		default:
+			status, err := p.Status(i)
+			if err != nil {
+				if txscript.IsPayToScriptHash(pInput.PrevOut.PkScript) &&
+					pInput.RedeemScript == nil {
+					input.Missing = &rpcjson.AnalyzePsbtMissingResult{
+						RedeemScript: true,
+					}
+					input.Next = psbtRoleUpdater
+					break
+				}
+				result.Error = fmt.Sprintf("input %d: %v", i, err)
+				input.Next = psbtRoleUpdater
+				break
+			}
+			if status.Signed < status.Required {
+				missing := &rpcjson.AnalyzePsbtMissingResult{
+					Signatures: status.Required - status.Signed,
+				}
+				for _, key := range status.MissingPubKeys {
+					missing.PubKeys = append(missing.PubKeys, hex.EncodeToString(key))
+				}
+				if status.MissingPubKeyHash != nil {
+					missing.PubKeyHash = hex.EncodeToString(status.MissingPubKeyHash)
+				}
+				input.Missing = missing
+				input.Next = psbtRoleSigner
+				break
+			}
+			input.Next = psbtRoleFinalizer
+		}
+		if input.Next != "" && psbtRoleOrder[input.Next] < psbtRoleOrder[result.Next] {
+			result.Next = input.Next
+		}
+		result.Inputs = append(result.Inputs, input)
+	}
+
+	return result, nil
+}
+
+// CombinePsbt merges the signatures and data of several partially signed
+// versions of the same transaction into one.
+func (s *PublicRpcAPI) CombinePsbt(psbts []string) (interface{}, error) {
+	if len(psbts) == 0 {
+		return nil, &rpcjson.RPCError{
+			Code:    rpcjson.ErrRPCInvalidParameter,
+			Message: "At least one PSBT is required",
+		}
+	}
+	packets := make([]*psbt.Packet, 0, len(psbts))
+	for _, b64 := range psbts {
+		p, err := decodePsbt(b64)
+		if err != nil {
+			return nil, err
+		}
+		packets = append(packets, p)
+	}
+
+	combined, err := psbt.Combine(packets...)
+	if err != nil {
+		return nil, &rpcjson.RPCError{
+			Code:    rpcjson.ErrRPCInvalidParameter,
+			Message: err.Error(),
+		}
+	}
+	return encodePsbt(combined)
+}
+
+// FinalizePsbt finalizes every input of a partially signed transaction that
+// has all of its signatures.  When all inputs are final and extract is not
+// false, the signed transaction is returned ready to be sent with
+// SendRawTransaction, otherwise the updated partially signed transaction is
+// returned.
+func (s *PublicRpcAPI) FinalizePsbt(psbtB64 string, extract *bool) (interface{}, error) {
+	p, err := decodePsbt(psbtB64)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range p.Inputs {
+		// Inputs which can not be finalized yet are left as they are.
+		psbt.MaybeFinalize(p, i)
+	}
+
+	result := rpcjson.FinalizePsbtResult{Complete: p.IsComplete()}
+	if result.Complete && (extract == nil || *extract) {
+		tx, err := psbt.Extract(p)
+		if err != nil {
+			return nil, internalRPCError(err.Error(), "Failed to extract transaction")
+		}
+		var buf bytes.Buffer
+		if err := tx.Serialize(&buf); err != nil {
+			return nil, internalRPCError(err.Error(), "Failed to serialize transaction")
+		}
+		result.Hex = hex.EncodeToString(buf.Bytes())
+		return result, nil
+	}
+
+	result.Psbt, err = encodePsbt(p)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -11,10 +11,12 @@ import (
 	"fmt"
 	"github.com/AsimovNetwork/asimov/ainterface"
 	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/asiutil/proof"
 	"github.com/AsimovNetwork/asimov/blockchain"
 	"github.com/AsimovNetwork/asimov/blockchain/txo"
 	"github.com/AsimovNetwork/asimov/cache"
 	"github.com/AsimovNetwork/asimov/chaincfg"
+	"github.com/AsimovNetwork/asimov/coinselect"
 	"github.com/AsimovNetwork/asimov/common"
 	"github.com/AsimovNetwork/asimov/common/hexutil"
 	fnet "github.com/AsimovNetwork/asimov/common/net"
@@ -34,22 +36,32 @@ import (
 	"github.com/AsimovNetwork/asimov/vm/fvm/core/vm"
 	"math"
 	"math/big"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 type PublicRpcAPI struct {
-	stack *node.Node
-	cfg   *rpcserverConfig
+	stack  *node.Node
+	cfg    *rpcserverConfig
+	events *EventSystem
+
+	filtersMu sync.Mutex
+	filters   map[rpc.ID]*filter
 }
 
 // NewPublicWeb3API creates a new Web3Service instance
-func NewPublicRpcAPI(stack *node.Node, config *rpcserverConfig) *PublicRpcAPI {
-	return &PublicRpcAPI{
-		stack: stack,
-		cfg:   config,
+func NewPublicRpcAPI(stack *node.Node, config *rpcserverConfig, events *EventSystem) *PublicRpcAPI {
+	api := &PublicRpcAPI{
+		stack:   stack,
+		cfg:     config,
+		events:  events,
+		filters: make(map[rpc.ID]*filter),
 	}
+	go api.timeoutLoop()
+	return api
 }
 
 func (s *PublicRpcAPI) GetBlockChainInfo() (interface{}, error) {
@@ -67,10 +79,29 @@ func (s *PublicRpcAPI) GetBlockChainInfo() (interface{}, error) {
 		Round:         int32(chainSnapshot.Round),
 		Slot:          int16(chainSnapshot.SlotIndex),
 	}
+	finalizedHash, finalized := chain.FinalizedBlock()
+	chainInfo.Finalized = finalized
+	chainInfo.FinalizedHash = finalizedHash.UnprefixString()
 
 	return chainInfo, nil
 }
 
+// GetFinalizedBlock returns the latest finalized block, which can not be
+// detached from the main chain anymore.
+func (s *PublicRpcAPI) GetFinalizedBlock() (*rpcjson.GetFinalizedBlockResult, error) {
+	best := s.cfg.Chain.BestSnapshot()
+	hash, height := s.cfg.Chain.FinalizedBlock()
+	confirmations := best.Height - height + 1
+	if confirmations < 1 {
+		confirmations = 1
+	}
+	return &rpcjson.GetFinalizedBlockResult{
+		Hash:          hash.UnprefixString(),
+		Height:        height,
+		Confirmations: confirmations,
+	}, nil
+}
+
 func (s *PublicRpcAPI) GetBlockHash(blockHeight int32) (string, error) {
 	hash, err := s.cfg.Chain.BlockHashByHeight(blockHeight)
 	if err != nil {
@@ -122,6 +153,201 @@ func (s *PublicRpcAPI) GetBestBlock() (*rpcjson.GetBestBlockResult, error) {
 	return result, nil
 }
 
+// GetTxOutSetInfo returns statistics about the unspent transaction output set
+// of the best block, along with its rolling hash.
+func (s *PublicRpcAPI) GetTxOutSetInfo() (*rpcjson.GetTxOutSetInfoResult, error) {
+	info, err := s.cfg.Chain.FetchUtxoSetInfo()
+	if err != nil {
+		return nil, internalRPCError(err.Error(), "Failed to fetch the utxo set info")
+	}
+	result := &rpcjson.GetTxOutSetInfoResult{
+		Height:       info.Height,
+		BestBlock:    info.Hash.UnprefixString(),
+		Transactions: info.Txs,
+		TxOuts:       info.Utxos,
+		UtxoSetHash:  info.UtxoHash.UnprefixString(),
+		Assets:       make([]rpcjson.TxOutSetAssetResult, 0, len(info.Assets)),
+	}
+	for _, asset := range info.Assets {
+		result.Assets = append(result.Assets, rpcjson.TxOutSetAssetResult{
+			Asset:  hex.EncodeToString(asset.Asset.Bytes()),
+			TxOuts: asset.Utxos,
+			Amount: strconv.FormatInt(asset.Amount, 10),
+			Locked: strconv.FormatInt(asset.Locked, 10),
+		})
+	}
+	return result, nil
+}
+
+// GetTxProof returns the merkle branch which proves a transaction is included
+// in a block of the main chain, against the merkle root of the block header.
+// The block is looked up in the transaction index when blockHash is empty.
+// The proofs are checked by the asiutil/proof package.
+func (s *PublicRpcAPI) GetTxProof(txId string, blockHash string) (*rpcjson.GetTxProofResult, error) {
+	txHash := common.HexToHash(txId)
+	var hash common.Hash
+	if blockHash != "" {
+		hash = common.HexToHash(blockHash)
+	} else {
+		if s.cfg.TxIndex == nil {
+			return nil, &rpcjson.RPCError{
+				Code: rpcjson.ErrRPCNoTxInfo,
+				Message: "The transaction index must be " +
+					"enabled to query the blockchain " +
+					"(specify --txindex)",
+			}
+		}
+		blockRegion, err := s.cfg.TxIndex.FetchBlockRegion(txHash[:])
+		if err != nil {
+			context := "Failed to retrieve transaction location"
+			return nil, internalRPCError(err.Error(), context)
+		}
+		if blockRegion == nil {
+			return nil, &rpcjson.RPCError{
+				Code:    rpcjson.ErrRPCNoTxInfo,
+				Message: fmt.Sprintf("No information available about transaction %v", txHash),
+			}
+		}
+		if blockRegion.Key.IsVirtual() {
+			return nil, &rpcjson.RPCError{
+				Code: rpcjson.ErrRPCInvalidParameter,
+				Message: fmt.Sprintf("Transaction %v is virtual, which is not "+
+					"committed to the merkle root", txHash),
+			}
+		}
+		copy(hash[:], blockRegion.Key[:common.HashLength])
+	}
+
+	if !s.cfg.Chain.MainChainHasBlock(&hash) {
+		return nil, &rpcjson.RPCError{
+			Code:    rpcjson.ErrRPCBlockNotFound,
+			Message: fmt.Sprintf("Block %v is not in the main chain", hash),
+		}
+	}
+	block, err := s.cfg.Chain.BlockByHash(&hash)
+	if err != nil {
+		return nil, internalRPCError(err.Error(), "Failed to fetch block")
+	}
+	index := -1
+	for i, tx := range block.Transactions() {
+		if *tx.Hash() == txHash {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return nil, &rpcjson.RPCError{
+			Code:    rpcjson.ErrRPCNoTxInfo,
+			Message: fmt.Sprintf("Transaction %v is not in block %v", txHash, hash),
+		}
+	}
+	txHex, err := messageToHex(block.Transactions()[index].MsgTx())
+	if err != nil {
+		return nil, err
+	}
+
+	merkles := blockchain.BuildMerkleTreeStore(block.Transactions())
+	branch := blockchain.BuildMerkleBranch(merkles, index)
+	result := &rpcjson.GetTxProofResult{
+		BlockHash:  hash.UnprefixString(),
+		Height:     block.Height(),
+		MerkleRoot: block.MsgBlock().Header.MerkleRoot.UnprefixString(),
+		TxID:       txHash.UnprefixString(),
+		Hex:        txHex,
+		Index:      uint32(index),
+		Branch:     make([]string, 0, len(branch)),
+	}
+	for _, sibling := range branch {
+		result.Branch = append(result.Branch, sibling.UnprefixString())
+	}
+	return result, nil
+}
+
+// GetProof returns the merkle proof of an account against the state root of
+// a block of the main chain, along with the proofs of the passed storage
+// slots of the account against its storage root.  The best block is used
+// when blockHash is empty.  The state of the block must not be pruned.
+// The proofs are checked by the asiutil/proof package.
+func (s *PublicRpcAPI) GetProof(address string, storageKeys []string, blockHash string) (*rpcjson.GetProofResult, error) {
+	b, err := hexutil.Decode(address)
+	if err != nil {
+		return nil, rpcjson.NewRPCError(rpcjson.ErrRPCInvalidAddressOrKey,
+			"Failed to decode address: "+err.Error())
+	}
+	addr, err := common.NewAddress(b)
+	if err != nil {
+		return nil, rpcjson.NewRPCError(rpcjson.ErrRPCInvalidAddressOrKey,
+			"Failed to create ADDRESS object: "+err.Error())
+	}
+	hash := s.cfg.Chain.BestSnapshot().Hash
+	if blockHash != "" {
+		hash = common.HexToHash(blockHash)
+	}
+	if !s.cfg.Chain.MainChainHasBlock(&hash) {
+		return nil, &rpcjson.RPCError{
+			Code:    rpcjson.ErrRPCBlockNotFound,
+			Message: fmt.Sprintf("Block %v is not in the main chain", hash),
+		}
+	}
+	header, err := s.cfg.Chain.FetchHeader(&hash)
+	if err != nil {
+		return nil, internalRPCError(err.Error(), "Failed to fetch block header")
+	}
+	stateDB, err := state.New(header.StateRoot, s.cfg.Chain.GetStateCache())
+	if err != nil {
+		return nil, rpcjson.NewRPCError(rpcjson.ErrRPCMisc,
+			fmt.Sprintf("State of block %v is not available: %v", hash, err))
+	}
+
+	accountProof, err := stateDB.GetProof(*addr)
+	if err != nil {
+		return nil, internalRPCError(err.Error(), "Failed to prove account")
+	}
+	codeHash := stateDB.GetCodeHash(*addr)
+	storageHash := proof.EmptyRoot
+	storageTrie := stateDB.StorageTrie(*addr)
+	if storageTrie != nil {
+		storageHash = storageTrie.Hash()
+	}
+	result := &rpcjson.GetProofResult{
+		BlockHash:    hash.UnprefixString(),
+		Height:       header.Height,
+		StateRoot:    header.StateRoot.UnprefixString(),
+		Address:      addr.String(),
+		AccountProof: make([]string, 0, len(accountProof)),
+		Balance:      stateDB.GetBalance(*addr).String(),
+		Nonce:        stateDB.GetNonce(*addr),
+		CodeHash:     codeHash.UnprefixString(),
+		StorageHash:  storageHash.UnprefixString(),
+		StorageProof: make([]rpcjson.StorageProofResult, 0, len(storageKeys)),
+	}
+	for _, node := range accountProof {
+		result.AccountProof = append(result.AccountProof, hex.EncodeToString(node))
+	}
+
+	for _, storageKey := range storageKeys {
+		key := common.HexToHash(storageKey)
+		var nodes [][]byte
+		if storageTrie != nil {
+			nodes, err = stateDB.GetStorageProof(*addr, key)
+			if err != nil {
+				return nil, internalRPCError(err.Error(), "Failed to prove storage")
+			}
+		}
+		value := stateDB.GetState(*addr, key)
+		storageProof := rpcjson.StorageProofResult{
+			Key:   key.UnprefixString(),
+			Value: value.UnprefixString(),
+			Proof: make([]string, 0, len(nodes)),
+		}
+		for _, node := range nodes {
+			storageProof.Proof = append(storageProof.Proof, hex.EncodeToString(node))
+		}
+		result.StorageProof = append(result.StorageProof, storageProof)
+	}
+	return result, nil
+}
+
 func (s *PublicRpcAPI) GetBlock(blockHash string, verbose bool, verboseTx bool) (interface{}, error) {
 	// Load the raw block bytes from the database.
 	hash := common.HexToHash(blockHash)
@@ -855,6 +1081,140 @@ func (s *PublicRpcAPI) CreateRawTransaction(inputs []rpcjson.TransactionInput, o
 	}, nil
 }
 
+// CreateFundedTransaction creates a raw transaction paying the given outputs
+// from the unspent outputs of address.  The inputs are picked per asset with
+// the given coin selection strategy, one of largestfirst (default),
+// branchandbound or mininputcount, the fee is paid in feeAsset and the change
+// of every divisible asset is returned to address.  Outputs of indivisible
+// assets take the voucher id as amount.
+func (s *PublicRpcAPI) CreateFundedTransaction(address string, outputs []rpcjson.TransactionOutput,
+	feeAsset string, fee int64, strategy *string, gasLimit *int32) (interface{}, error) {
+
+	addrBytes, err := hexutil.Decode(address)
+	if err != nil {
+		return nil, internalRPCError(err.Error(), "Failed to decode address")
+	}
+	addr, err := common.NewAddress(addrBytes)
+	if err != nil {
+		return nil, internalRPCError(err.Error(), "Failed to create ADDRESS object")
+	}
+
+	opts := &coinselect.Options{Fee: fee}
+	if strategy != nil && *strategy != "" {
+		opts.Strategy, err = coinselect.ParseStrategy(*strategy)
+		if err != nil {
+			return nil, &rpcjson.RPCError{
+				Code:    rpcjson.ErrRPCInvalidParameter,
+				Message: err.Error(),
+			}
+		}
+	}
+	feeAssetBytes, err := hex.DecodeString(feeAsset)
+	if err != nil {
+		return nil, rpcDecodeHexError(feeAsset)
+	}
+	opts.FeeAsset = *protos.AssetFromBytes(feeAssetBytes)
+
+	targets := make([]coinselect.Target, 0, len(outputs))
+	for _, output := range outputs {
+		amount, err := strconv.ParseInt(output.Amount, 10, 64)
+		if err != nil {
+			return nil, &rpcjson.RPCError{
+				Code:    rpcjson.ErrRPCInvalidOutPutAmount,
+				Message: "Invalid amount",
+			}
+		}
+		assets, err := hex.DecodeString(output.Assets)
+		if err != nil {
+			return nil, rpcDecodeHexError(output.Assets)
+		}
+		targets = append(targets, coinselect.Target{
+			Asset:  *protos.AssetFromBytes(assets),
+			Amount: amount,
+		})
+	}
+
+	// Collect the spendable coins of the address.  Immature coinbase
+	// outputs and outputs already spent by transactions in the memory pool
+	// are skipped.
+	view := txo.NewUtxoViewpoint()
+	_, err = s.cfg.Chain.FetchUtxoViewByAddress(view, addr.ScriptAddress())
+	if err != nil {
+		return nil, internalRPCError(err.Error(), "Failed to fetch utxo of address")
+	}
+	outpoints := make([]protos.OutPoint, 0, len(view.Entries()))
+	for out := range view.Entries() {
+		outpoints = append(outpoints, out)
+	}
+	spent := make(map[protos.OutPoint]struct{})
+	for _, out := range s.cfg.TxMemPool.HasSpentInTxPool(&outpoints) {
+		spent[out] = struct{}{}
+	}
+	tipHeight := s.cfg.Chain.BestSnapshot().Height
+	coins := make([]*coinselect.Coin, 0, len(outpoints))
+	for out, e := range view.Entries() {
+		if e == nil || e.IsSpent() {
+			continue
+		}
+		if _, ok := spent[out]; ok {
+			continue
+		}
+		if e.IsCoinBase() && tipHeight-e.BlockHeight() < chaincfg.ActiveNetParams.CoinbaseMaturity {
+			continue
+		}
+		coins = append(coins, &coinselect.Coin{
+			OutPoint: out,
+			Asset:    *e.Asset(),
+			Amount:   e.Amount(),
+			PkScript: e.PkScript(),
+		})
+	}
+
+	selection, err := coinselect.Select(coins, targets, opts)
+	if err != nil {
+		if _, ok := err.(coinselect.InsufficientFundsError); ok {
+			return nil, &rpcjson.RPCError{
+				Code:    rpcjson.ErrRPCInsufficientFunds,
+				Message: err.Error(),
+			}
+		}
+		return nil, &rpcjson.RPCError{
+			Code:    rpcjson.ErrRPCInvalidParameter,
+			Message: err.Error(),
+		}
+	}
+
+	inputs := make([]rpcjson.TransactionInput, 0, len(selection.Inputs))
+	for _, coin := range selection.Inputs {
+		inputs = append(inputs, rpcjson.TransactionInput{
+			Txid:         coin.OutPoint.Hash.UnprefixString(),
+			Vout:         coin.OutPoint.Index,
+			Assets:       hex.EncodeToString(coin.Asset.Bytes()),
+			ScriptPubKey: hex.EncodeToString(coin.PkScript),
+		})
+	}
+	change := make([]rpcjson.TransactionOutput, 0, len(selection.Change))
+	for _, c := range selection.Change {
+		change = append(change, rpcjson.TransactionOutput{
+			Amount:  strconv.FormatInt(c.Amount, 10),
+			Assets:  hex.EncodeToString(c.Asset.Bytes()),
+			Address: addr.String(),
+		})
+	}
+
+	result, err := s.CreateRawTransaction(inputs, append(outputs, change...), nil, gasLimit)
+	if err != nil {
+		return nil, err
+	}
+	raw := result.(*rpcjson.CreateRawTransactionResult)
+	return &rpcjson.CreateFundedTransactionResult{
+		Hex:          raw.Hex,
+		ContractAddr: raw.ContractAddr,
+		Inputs:       inputs,
+		Change:       change,
+	}, nil
+}
+
 func (s *PublicRpcAPI) DecodeRawTransaction(hexTx string) (interface{}, error) {
 	// Deserialize the transaction.
 	if len(hexTx)%2 != 0 {
@@ -1177,6 +1537,16 @@ func (s *PublicRpcAPI) Call(callerAddress string, contractAddress string, data s
 
 	ret, leftGas, _, err := vmInstance.Call(vm.AccountRef(callerAddr), contractAddr, input, uint64(1000000000), big.NewInt(amount), assets, true)
 	res.GasUsed = uint64(1000000000) - leftGas
+	if err == vm.ErrExecutionReverted {
+		// Custom errors are looked up in the passed abi, falling back to
+		// the one of the template of the contract.
+		decoder := newEventDecoder(s.cfg)
+		t := &templateABI{abi: definition}
+		if len(definition.Errors) == 0 {
+			t = decoder.template(contractAddr)
+		}
+		return nil, revertError(decoder.decodeRevert(ret, t))
+	}
 	if err != nil {
 		return nil, internalRPCError(err.Error(), "Failed to call contract function")
 	}
@@ -1427,7 +1797,12 @@ func (s *PublicRpcAPI) EstimateGas(caller string, contractAddress string, amount
 			return 0, internalRPCError("", "Failed to get contract template data")
 		}
 
-		_, addr, leftOverGas, _, err = vmInstance.Create(vm.AccountRef(callerAddr), byteCode, leftOverGas, big.NewInt(amount), assets, inputHash, constructor, false)
+		var ret []byte
+		ret, addr, leftOverGas, _, err = vmInstance.Create(vm.AccountRef(callerAddr), byteCode, leftOverGas, big.NewInt(amount), assets, inputHash, constructor, false)
+		if err == vm.ErrExecutionReverted {
+			decoder := newEventDecoder(s.cfg)
+			return 0, revertError(decoder.decodeRevert(ret, decoder.templateByName(category, templateName)))
+		}
 		if err != nil {
 			return 0, internalRPCError(err.Error(), "Failed to create contract")
 		}
@@ -1449,13 +1824,23 @@ func (s *PublicRpcAPI) EstimateGas(caller string, contractAddress string, amount
 			return 0, internalRPCError(err.Error(), "Failed to call PackFunctionArgs")
 		}
 
-		_, leftOverGas, _, err = vmInstance.Call(vm.AccountRef(callerAddr), createTemplateAddr, runCode, leftOverGas, big.NewInt(amount), assets, true)
+		var ret []byte
+		ret, leftOverGas, _, err = vmInstance.Call(vm.AccountRef(callerAddr), createTemplateAddr, runCode, leftOverGas, big.NewInt(amount), assets, true)
+		if err == vm.ErrExecutionReverted {
+			return 0, revertError(newEventDecoder(s.cfg).decodeRevert(ret, nil))
+		}
 		if err != nil {
 			return 0, internalRPCError(err.Error(), "Failed to create contract template")
 		}
 	} else {
 
-		_, leftOverGas, _, err = vmInstance.Call(vm.AccountRef(callerAddr), common.BytesToAddress(contractAddressBytes), dataBytes, leftOverGas, big.NewInt(amount), assets, false)
+		contractAddr := common.BytesToAddress(contractAddressBytes)
+		var ret []byte
+		ret, leftOverGas, _, err = vmInstance.Call(vm.AccountRef(callerAddr), contractAddr, dataBytes, leftOverGas, big.NewInt(amount), assets, false)
+		if err == vm.ErrExecutionReverted {
+			decoder := newEventDecoder(s.cfg)
+			return 0, revertError(decoder.decodeRevert(ret, decoder.template(contractAddr)))
+		}
 		if err != nil {
 			return 0, internalRPCError(err.Error(), "Failed to call contract")
 		}
@@ -1762,15 +2147,50 @@ func (s *PublicRpcAPI) GetVirtualTransactions(blkHashStr string, verbose bool, v
 
 }
 
-func (s *PublicRpcAPI) GetTransactionReceipt(txId string) (interface{}, error) {
+// GetTransactionReceipt returns the receipt of the transaction with the given
+// id.  When decodeEvents is set the logs are decoded through the ABI of the
+// template of the contract which emitted them, and the revert reason of a
+// failed execution is decoded as well.
+func (s *PublicRpcAPI) GetTransactionReceipt(txId string, decodeEvents *bool) (interface{}, error) {
+	receipt, block, err := s.fetchReceipt(txId)
+	if err != nil || receipt == nil {
+		return nil, err
+	}
+
+	result, err := createTxReceiptResult(receipt)
+	if err != nil {
+		return nil, internalRPCError(err.Error(), "Failed to create transaction receipt")
+	}
+	if decodeEvents == nil || !*decodeEvents {
+		return result, nil
+	}
+
+	decoder := newEventDecoder(s.cfg)
+	decoder.decodeLogResults(result.Logs, receipt.Logs)
+	if result.RevertReason != nil {
+		for _, tx := range block.Transactions() {
+			if *tx.Hash() == receipt.TxHash {
+				result.RevertReason = decoder.decodeRevert(receipt.RevertReason,
+					decoder.txTemplate(tx.MsgTx()))
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+// fetchReceipt returns the receipt of the transaction with the given id and
+// the block which contains it.  The receipt is nil when the transaction is
+// not mined yet.
+func (s *PublicRpcAPI) fetchReceipt(txId string) (*types.Receipt, *asiutil.Block, error) {
 	tx, err := s.GetRawTransaction(txId, true, false)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	txRawResult := tx.(rpcjson.TxRawResult)
 	if txRawResult.BlockHash == "" {
-		return nil, nil
+		return nil, nil, nil
 	}
 
 	// Fetch block hash
@@ -1778,7 +2198,7 @@ func (s *PublicRpcAPI) GetTransactionReceipt(txId string) (interface{}, error) {
 
 	block, err := s.cfg.Chain.BlockByHash(&blockHash)
 	if err != nil {
-		return nil, internalRPCError(err.Error(), "Failed to get block by hash")
+		return nil, nil, internalRPCError(err.Error(), "Failed to get block by hash")
 	}
 
 	receipts := rawdb.ReadReceipts(s.cfg.Chain.EthDB(), common.Hash(*block.Hash()), uint64(block.Height()))
@@ -1786,11 +2206,11 @@ func (s *PublicRpcAPI) GetTransactionReceipt(txId string) (interface{}, error) {
 	txHash := common.HexToHash(txId)
 	for _, receipt := range receipts {
 		if common.Hash(txHash) == receipt.TxHash {
-			return receipt, nil
+			return receipt, block, nil
 		}
 	}
 
-	return nil, internalRPCError("No receipt found", "Failed to GetTransactionReceipt")
+	return nil, nil, internalRPCError("No receipt found", "Failed to GetTransactionReceipt")
 }
 
 func (s *PublicRpcAPI) SendRawTransaction(hexTx string) (interface{}, error) {
@@ -2290,6 +2710,87 @@ func (s *PublicRpcAPI) GetMempoolTransactions(txIds []string) (interface{}, erro
 	return result, nil
 }
 
+// SaveMempool writes the transactions of the mempool to the mempool file of
+// the data directory, which is loaded on start up unless disabled.
+func (s *PublicRpcAPI) SaveMempool() (interface{}, error) {
+	file := filepath.Join(chaincfg.Cfg.DataDir, mempool.MempoolFileName)
+	size, err := s.cfg.TxMemPool.Save(file)
+	if err != nil {
+		return nil, internalRPCError(err.Error(), "Failed to save the mempool")
+	}
+	return &rpcjson.MempoolFileResult{File: file, Size: size}, nil
+}
+
+// LoadMempool validates the transactions of the mempool file of the data
+// directory, and adds the ones which are still valid to the mempool.
+func (s *PublicRpcAPI) LoadMempool() (interface{}, error) {
+	file := filepath.Join(chaincfg.Cfg.DataDir, mempool.MempoolFileName)
+	size, rejected, err := s.cfg.TxMemPool.Load(file)
+	if err != nil {
+		return nil, internalRPCError(err.Error(), "Failed to load the mempool")
+	}
+	return &rpcjson.MempoolFileResult{File: file, Size: size, Rejected: rejected}, nil
+}
+
+// submitEvidenceFunction is the function of the validator committee which
+// takes the serialized evidence of a misbehaving validator, once the chain
+// activated the submitEvidence upgrade of the contract.
+const submitEvidenceFunction = "submitEvidence"
+
+// GetEvidence returns the evidences of the validators which produced two
+// blocks for the same round and slot, or signed two blocks at the same height.
+func (s *PublicRpcAPI) GetEvidence() (interface{}, error) {
+	height := s.cfg.Chain.BestSnapshot().Height
+	contract := s.cfg.ContractMgr.GetActiveContractByHeight(height, common.ValidatorCommittee)
+	submit := false
+	if contract != nil {
+		definition, err := abi.JSON(strings.NewReader(contract.AbiInfo))
+		if err != nil {
+			return nil, internalRPCError(err.Error(), "Failed to parse the validator committee abi")
+		}
+		_, submit = definition.Methods[submitEvidenceFunction]
+	}
+
+	descs := s.cfg.EvidencePool.EvidenceDescs()
+	results := make([]*rpcjson.EvidenceResult, 0, len(descs))
+	for _, desc := range descs {
+		evidence := desc.Evidence
+		var buf bytes.Buffer
+		if err := evidence.Serialize(&buf); err != nil {
+			return nil, internalRPCError(err.Error(), "Failed to serialize evidence")
+		}
+		result := &rpcjson.EvidenceResult{
+			Hash:     desc.Hash.String(),
+			Type:     evidence.Type.String(),
+			Offender: evidence.Offender().String(),
+			Height:   evidence.Height(),
+			Time:     desc.Added.Unix(),
+			Hex:      hex.EncodeToString(buf.Bytes()),
+		}
+		if evidence.Type == protos.EvidenceDoubleProposal {
+			result.Round = evidence.Headers[0].Round
+			result.Slot = evidence.Headers[0].SlotIndex
+			for i := range evidence.Headers {
+				result.Blocks = append(result.Blocks, evidence.Headers[i].BlockHash().String())
+			}
+		} else {
+			for i := range evidence.Signs {
+				result.Blocks = append(result.Blocks, evidence.Signs[i].BlockHash.String())
+			}
+		}
+		if submit {
+			data, err := fvm.PackFunctionArgs(contract.AbiInfo, submitEvidenceFunction,
+				evidence.Offender(), buf.Bytes())
+			if err != nil {
+				return nil, internalRPCError(err.Error(), "Failed to pack the evidence")
+			}
+			result.SubmitData = hex.EncodeToString(data)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
 func (s *PublicRpcAPI) AddNode(_addr string, _subCmd rpcjson.AddNodeSubCmd) (interface{}, error) {
 	addr := fnet.NormalizeAddress(_addr, s.cfg.ChainParams.DefaultPort)
 	var err error
@@ -2367,12 +2868,21 @@ func (s *PublicRpcAPI) GetContractTemplateInfoByKey(key string) (interface{}, er
 	return result, nil
 }
 
+// Get the data returned by the reverted contract execution of a transaction.
+// Recent executions are served from the cache, older ones from the revert
+// reason stored in the receipt.
 func (s *PublicRpcAPI) GetContractExecuteError(txid string)(interface{},error){
 	data,err:=cache.GetExecuteError(txid)
 	if err!=nil{
 		return nil,internalRPCError(err.Error(),
 			"Failed to get contract execute error")
 	}
+	if data == "" {
+		receipt, _, err := s.fetchReceipt(txid)
+		if err == nil && receipt != nil {
+			data = common.Bytes2Hex(receipt.RevertReason)
+		}
+	}
 	return data,nil
 }
 
@@ -2397,12 +2907,14 @@ func getTemplateInfoByKey(key string, s *PublicRpcAPI) (interface{}, bool) {
 type AsimovRpcService struct {
 	stack  *node.Node
 	config *rpcserverConfig
+	events *EventSystem
 }
 
 func NewAsimovRpcService(ctx *node.ServiceContext, stack *node.Node, config *rpcserverConfig) (*AsimovRpcService, error) {
 	eth := &AsimovRpcService{
 		stack:  stack,
 		config: config,
+		events: NewEventSystem(config.Chain, config.TxMemPool),
 	}
 	return eth, nil
 }
@@ -2414,8 +2926,12 @@ func (s *AsimovRpcService) APIs() []rpc.API {
 		{
 			Namespace: "asimov",
 			Version:   "1.0",
-			Service:   NewPublicRpcAPI(s.stack, s.config),
+			Service:   NewPublicRpcAPI(s.stack, s.config, s.events),
 			Public:    true,
+		}, {
+			Namespace: "debug",
+			Version:   "1.0",
+			Service:   NewPrivateDebugAPI(s.config),
 		},
 	}
 }
@@ -2425,6 +2941,7 @@ func (s *AsimovRpcService) Start() error {
 }
 
 func (s *AsimovRpcService) Stop() error {
+	s.events.Stop()
 	return nil
 }
 
@@ -2432,23 +2949,7 @@ func createTxReceiptResult(receipt *types.Receipt) (*rpcjson.ReceiptResult, erro
 
 	logs := make([]*rpcjson.LogResult, 0)
 	for _, log := range receipt.Logs {
-
-		topics := make([]string, 0)
-		for _, topic := range log.Topics {
-			topics = append(topics, topic.String())
-		}
-		logs = append(logs, &rpcjson.LogResult{
-			Address:     log.Address.String(),
-			Topics:      topics,
-			Data:        common.Bytes2Hex(log.Data),
-			BlockNumber: log.BlockNumber,
-			TxHash:      log.TxHash.String(),
-			TxIndex:     log.TxIndex,
-			BlockHash:   log.BlockHash.String(),
-			Index:       log.Index,
-			Removed:     log.Removed,
-		})
-
+		logs = append(logs, createLogResult(log))
 	}
 
 	bloom := ""
@@ -2456,7 +2957,15 @@ func createTxReceiptResult(receipt *types.Receipt) (*rpcjson.ReceiptResult, erro
 		bloom = common.Bytes2Hex(receipt.Bloom.Bytes())
 	}
 
+	var revertReason *rpcjson.RevertReasonResult
+	if len(receipt.RevertReason) > 0 {
+		revertReason = &rpcjson.RevertReasonResult{
+			Data: common.Bytes2Hex(receipt.RevertReason),
+		}
+	}
+
 	return &rpcjson.ReceiptResult{
+		RevertReason:      revertReason,
 		PostState:         common.Bytes2Hex(receipt.PostState),
 		Status:            receipt.Status,
 		CumulativeGasUsed: receipt.CumulativeGasUsed,
@@ -22,6 +22,7 @@ import (
 	"math"
 	"net"
 	"os"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
@@ -51,7 +52,12 @@ import (
 const (
 	// defaultServices describes the default services that are supported by
 	// the NodeServer.
-	defaultServices = common.SFNodeNetwork | common.SFNodeBloom | common.SFNodeCF
+	defaultServices = common.SFNodeNetwork | common.SFNodeBloom | common.SFNodeCF |
+		common.SFNodeState | common.SFNodeContractCF
+
+	// maxNodeDataSize is the size of the node data after which the reply to
+	// a getnodedata message is cut short.
+	maxNodeDataSize = 1024 * 1024
 
 	// defaultRequiredServices describes the default services that are
 	// required to be supported by outbound peers.
@@ -173,6 +179,7 @@ type NodeServer struct {
 	chain                *blockchain.BlockChain
 	txMemPool            *mempool.TxPool
 	sigMemPool           *mempool.SigPool
+	evidencePool         *mempool.EvidencePool
 	consensus            ainterface.Consensus
 	cfg                  *params.Config
 	modifyRebroadcastInv chan interface{}
@@ -197,6 +204,7 @@ type NodeServer struct {
 	txIndex       *indexers.TxIndex
 	addrIndex     *indexers.AddrIndex
 	cfIndex       *indexers.CfIndex
+	bloomIndex    *indexers.BloomIndex
 	templateIndex blockchain.Indexer
 
 	// cfCheckptCaches stores a cached slice of filter headers for cfcheckpt
@@ -236,6 +244,7 @@ type serverPeer struct {
 	// The following chans are used to sync blockmanager and NodeServer.
 	txProcessed    chan struct{}
 	sigProcessed   chan struct{}
+	evidenceProcessed chan struct{}
 	blockProcessed chan struct{}
 }
 
@@ -250,6 +259,7 @@ func newServerPeer(s *NodeServer, isPersistent bool) *serverPeer {
 		quit:           make(chan struct{}),
 		txProcessed:    make(chan struct{}, 1),
 		sigProcessed:   make(chan struct{}, 1),
+		evidenceProcessed: make(chan struct{}, 1),
 		blockProcessed: make(chan struct{}, 1),
 	}
 }
@@ -468,6 +478,34 @@ func (sp *serverPeer) OnSig(_ *peer.Peer, msg *protos.MsgBlockSign) {
 	<-sp.sigProcessed
 }
 
+// OnEvidence is invoked when a peer receives an evidence message.  It blocks
+// until the evidence has been fully processed.
+func (sp *serverPeer) OnEvidence(_ *peer.Peer, msg *protos.MsgEvidence) {
+	hash := msg.Hash()
+	iv := protos.NewInvVect(protos.InvTypeEvidence, &hash)
+	sp.AddKnownInventory(iv)
+
+	sp.server.syncManager.QueueEvidence(msg, sp.Peer, sp.evidenceProcessed)
+	<-sp.evidenceProcessed
+}
+
+// OnVote is invoked when a peer receives a vote of the bft consensus, which is
+// handed over to the consensus service when it runs the bft consensus.
+func (sp *serverPeer) OnVote(_ *peer.Peer, msg *protos.MsgVote) {
+	if handler, ok := sp.server.consensus.(ainterface.MessageHandler); ok {
+		handler.HandleMessage(msg)
+	}
+}
+
+// OnProposal is invoked when a peer receives a proposal of the bft consensus,
+// which is handed over to the consensus service when it runs the bft
+// consensus.
+func (sp *serverPeer) OnProposal(_ *peer.Peer, msg *protos.MsgProposal) {
+	if handler, ok := sp.server.consensus.(ainterface.MessageHandler); ok {
+		handler.HandleMessage(msg)
+	}
+}
+
 // OnBlock is invoked when a peer receives a block bitcoin message.  It
 // blocks until the bitcoin block has been fully processed.
 func (sp *serverPeer) OnBlock(_ *peer.Peer, msg *protos.MsgBlock, buf []byte) {
@@ -494,6 +532,20 @@ func (sp *serverPeer) OnBlock(_ *peer.Peer, msg *protos.MsgBlock, buf []byte) {
 	<-sp.blockProcessed
 }
 
+// OnVBlock is invoked when a peer receives a vblock message.  The virtual block
+// is queued before the block it belongs to, which follows it in the reply to
+// the same getdata message.
+func (sp *serverPeer) OnVBlock(_ *peer.Peer, msg *protos.MsgVirtualBlock) {
+	vblock := asiutil.NewVBlock(&msg.VBlock, &msg.BlockHash)
+	sp.server.syncManager.QueueVBlock(vblock, sp.Peer)
+}
+
+// OnNodeData is invoked when a peer receives a nodedata message.  The message
+// is passed down to the sync manager.
+func (sp *serverPeer) OnNodeData(_ *peer.Peer, msg *protos.MsgNodeData) {
+	sp.server.syncManager.QueueNodeData(msg, sp.Peer)
+}
+
 // OnInv is invoked when a peer receives an inv bitcoin message and is
 // used to examine the inventory being advertised by the remote peer and react
 // accordingly.  We pass the message down to blockmanager which will call
@@ -569,10 +621,14 @@ func (sp *serverPeer) OnGetData(_ *peer.Peer, msg *protos.MsgGetData) {
 			err = sp.server.pushTxMsg(sp, &iv.Hash, c, waitChan, protos.BaseEncoding)
 		case protos.InvTypeBlock:
 			err = sp.server.pushBlockMsg(sp, &iv.Hash, c, waitChan, protos.BaseEncoding)
+		case protos.InvTypeVBlock:
+			err = sp.server.pushVBlockMsg(sp, &iv.Hash, c, waitChan, protos.BaseEncoding)
 		case protos.InvTypeFilteredBlock:
 			err = sp.server.pushMerkleBlockMsg(sp, &iv.Hash, c, waitChan, protos.BaseEncoding)
 		case protos.InvTypeSignature:
 			err = sp.server.pushSigMsg(sp, &iv.Hash, c, waitChan, protos.BaseEncoding)
+		case protos.InvTypeEvidence:
+			err = sp.server.pushEvidenceMsg(sp, &iv.Hash, c, waitChan, protos.BaseEncoding)
 		case protos.InvTypeTxForbidden:
 			if c != nil {
 				c <- struct{}{}
@@ -680,6 +736,34 @@ func (sp *serverPeer) OnGetHeaders(_ *peer.Peer, msg *protos.MsgGetHeaders) {
 	sp.QueueMessage(&protos.MsgHeaders{Headers: blockHeaders}, nil)
 }
 
+// OnGetNodeData is invoked when a peer receives a getnodedata message.  It
+// replies with the requested state trie nodes and contract codes which are
+// available, leaving out the others.
+func (sp *serverPeer) OnGetNodeData(_ *peer.Peer, msg *protos.MsgGetNodeData) {
+	// The same decaying ban score as getdata protects against peers
+	// requesting large amounts of node data in a short period of time.
+	sp.AddBanScore(0, uint32(len(msg.Hashes))*99/protos.MaxNodeDataPerMsg,
+		"getnodedata")
+
+	reply := protos.NewMsgNodeData()
+	size := 0
+	for _, hash := range msg.Hashes {
+		data, err := sp.server.chain.FetchNodeData(hash)
+		if err != nil || len(data) == 0 {
+			continue
+		}
+		reply.AddData(data)
+		size += len(data)
+		if size >= maxNodeDataSize {
+			break
+		}
+	}
+
+	done := make(chan struct{}, 1)
+	sp.QueueMessage(reply, done)
+	<-done
+}
+
 // OnGetCFilters is invoked when a peer receives a getcfilters bitcoin message.
 func (sp *serverPeer) OnGetCFilters(_ *peer.Peer, msg *protos.MsgGetCFilters) {
 	// Ignore getcfilters requests if not in sync.
@@ -690,7 +774,7 @@ func (sp *serverPeer) OnGetCFilters(_ *peer.Peer, msg *protos.MsgGetCFilters) {
 	// We'll also ensure that the remote party is requesting a set of
 	// filters that we actually currently maintain.
 	switch msg.FilterType {
-	case protos.GCSFilterRegular:
+	case protos.GCSFilterRegular, protos.GCSFilterContract:
 		break
 
 	default:
@@ -746,7 +830,7 @@ func (sp *serverPeer) OnGetCFHeaders(_ *peer.Peer, msg *protos.MsgGetCFHeaders)
 	// We'll also ensure that the remote party is requesting a set of
 	// headers for filters that we actually currently maintain.
 	switch msg.FilterType {
-	case protos.GCSFilterRegular:
+	case protos.GCSFilterRegular, protos.GCSFilterContract:
 		break
 
 	default:
@@ -852,7 +936,7 @@ func (sp *serverPeer) OnGetCFCheckpt(_ *peer.Peer, msg *protos.MsgGetCFCheckpt)
 	// We'll also ensure that the remote party is requesting a set of
 	// checkpoints for filters that we actually currently maintain.
 	switch msg.FilterType {
-	case protos.GCSFilterRegular:
+	case protos.GCSFilterRegular, protos.GCSFilterContract:
 		break
 
 	default:
@@ -1311,6 +1395,37 @@ func (s *NodeServer) pushSigMsg(sp *serverPeer, hash *common.Hash, doneChan chan
 	return nil
 }
 
+// pushEvidenceMsg sends an evidence message for the provided evidence hash to
+// the connected peer.  An error is returned if the evidence hash is not known.
+func (s *NodeServer) pushEvidenceMsg(sp *serverPeer, hash *common.Hash, doneChan chan<- struct{},
+	waitChan <-chan struct{}, encoding protos.MessageEncoding) error {
+
+	evidence, err := s.evidencePool.FetchEvidence(hash)
+	if err != nil {
+		peerLog.Tracef("Unable to fetch evidence %v from evidence "+
+			"pool: %v", hash, err)
+		if doneChan != nil {
+			doneChan <- struct{}{}
+		}
+		return err
+	}
+
+	if waitChan != nil {
+		<-waitChan
+	}
+
+	sp.QueueMessageWithEncoding(evidence, doneChan, encoding)
+	return nil
+}
+
+// AnnounceNewEvidence relays the inventory of an evidence added to the
+// evidence pool.
+func (s *NodeServer) AnnounceNewEvidence(evidence *protos.MsgEvidence) {
+	hash := evidence.Hash()
+	iv := protos.NewInvVect(protos.InvTypeEvidence, &hash)
+	s.RelayInventory(iv, evidence)
+}
+
 func (s *NodeServer) relaySignatures(sig *asiutil.BlockSign) {
 	iv := protos.NewInvVect(protos.InvTypeSignature, sig.Hash())
 	s.RelayInventory(iv, sig)
@@ -1395,6 +1510,52 @@ func (s *NodeServer) pushBlockMsg(sp *serverPeer, hash *common.Hash, doneChan ch
 	return nil
 }
 
+// pushVBlockMsg sends a vblock message with the virtual block of the provided
+// block hash to the connected peer.  An error is returned if the virtual block
+// is not known.
+func (s *NodeServer) pushVBlockMsg(sp *serverPeer, hash *common.Hash, doneChan chan<- struct{},
+	waitChan <-chan struct{}, encoding protos.MessageEncoding) error {
+
+	// Fetch the raw virtual block bytes from the database.
+	var vblockBytes []byte
+	err := sp.server.db.View(func(dbTx database.Tx) error {
+		var err error
+		vblockBytes, err = dbTx.FetchBlock(database.NewVirtualBlockKey(hash))
+		return err
+	})
+	if err != nil {
+		peerLog.Tracef("Unable to fetch requested virtual block hash %v: %v",
+			hash, err)
+
+		if doneChan != nil {
+			doneChan <- struct{}{}
+		}
+		return err
+	}
+
+	// Deserialize the virtual block.
+	var msgVBlock protos.MsgVBlock
+	err = msgVBlock.Deserialize(bytes.NewReader(vblockBytes))
+	if err != nil {
+		peerLog.Tracef("Unable to deserialize requested virtual block "+
+			"hash %v: %v", hash, err)
+
+		if doneChan != nil {
+			doneChan <- struct{}{}
+		}
+		return err
+	}
+
+	// Once we have fetched data wait for any previous operation to finish.
+	if waitChan != nil {
+		<-waitChan
+	}
+
+	sp.QueueMessageWithEncoding(protos.NewMsgVirtualBlock(hash, &msgVBlock),
+		doneChan, encoding)
+	return nil
+}
+
 // pushMerkleBlockMsg sends a merkleblock message for the provided block hash to
 // the connected peer.  Since a merkle block requires the peer to have a filter
 // loaded, this call will simply be ignored if there is no filter loaded.  An
@@ -1890,6 +2051,18 @@ func disconnectPeer(peerList map[int32]*serverPeer, compareFunc func(*serverPeer
 	return false
 }
 
+// peerServices returns the services advertised to a new peer.  The contract
+// filters are only advertised while the index holds them down to the genesis,
+// which it stops doing once blocks are connected without their receipts.
+func (s *NodeServer) peerServices() common.ServiceFlag {
+	services := s.services
+	if services&common.SFNodeContractCF == common.SFNodeContractCF &&
+		!s.cfIndex.HasContractFilters(&s.chain.BestSnapshot().Hash) {
+		services &^= common.SFNodeContractCF
+	}
+	return services
+}
+
 // newPeerConfig returns the configuration for the given serverPeer.
 func newPeerConfig(sp *serverPeer) *peer.Config {
 	return &peer.Config{
@@ -1899,12 +2072,18 @@ func newPeerConfig(sp *serverPeer) *peer.Config {
 			OnMemPool:      sp.OnMemPool,
 			OnTx:           sp.OnTx,
 			OnSig:          sp.OnSig,
+			OnEvidence:     sp.OnEvidence,
+			OnVote:         sp.OnVote,
+			OnProposal:     sp.OnProposal,
 			OnBlock:        sp.OnBlock,
+			OnVBlock:       sp.OnVBlock,
+			OnNodeData:     sp.OnNodeData,
 			OnInv:          sp.OnInv,
 			OnHeaders:      sp.OnHeaders,
 			OnGetData:      sp.OnGetData,
 			OnGetBlocks:    sp.OnGetBlocks,
 			OnGetHeaders:   sp.OnGetHeaders,
+			OnGetNodeData:  sp.OnGetNodeData,
 			OnGetCFilters:  sp.OnGetCFilters,
 			OnGetCFHeaders: sp.OnGetCFHeaders,
 			OnGetCFCheckpt: sp.OnGetCFCheckpt,
@@ -1925,7 +2104,7 @@ func newPeerConfig(sp *serverPeer) *peer.Config {
 		UserAgentVersion:  userAgentVersion,
 		UserAgentComments: chaincfg.Cfg.UserAgentComments,
 		ChainParams:       sp.server.chainParams,
-		Services:          sp.server.services,
+		Services:          sp.server.peerServices(),
 		DisableRelayTx:    chaincfg.Cfg.BlocksOnly,
 		ProtocolVersion:   peer.MaxProtocolVersion,
 	}
@@ -2289,6 +2468,9 @@ func (s *NodeServer) Stop() {
 // WaitForShutdown blocks until the main listener and peer handlers are stopped.
 func (s *NodeServer) WaitForShutdown() {
 	s.wg.Wait()
+	if err := s.chain.Stop(); err != nil {
+		srvrLog.Errorf("Failed to persist the chain state: %v", err)
+	}
 	srvrLog.Infof("Server shutdown complete")
 }
 
@@ -2397,7 +2579,7 @@ func NewServer(db database.Transactor, stateDB database.Database, agentBlacklist
 		services &^= common.SFNodeBloom
 	}
 	if chaincfg.Cfg.NoCFilters {
-		services &^= common.SFNodeCF
+		services &^= common.SFNodeCF | common.SFNodeContractCF
 	}
 
 	cfg := chaincfg.Cfg
@@ -2472,10 +2654,16 @@ func NewServer(db database.Transactor, stateDB database.Database, agentBlacklist
 	indexes = append(indexes, s.addrIndex)
 	// Create cf index if needed
 	if !chaincfg.Cfg.NoCFilters {
-		s.cfIndex = indexers.NewCfIndex(db)
+		s.cfIndex = indexers.NewCfIndex(db, stateDB)
 		indexes = append(indexes, s.cfIndex)
 	}
 
+	// Create bloom index if needed
+	if chaincfg.Cfg.BloomIndex {
+		s.bloomIndex = indexers.NewBloomIndex(db)
+		indexes = append(indexes, s.bloomIndex)
+	}
+
 	// Create an index manager if any of the optional indexes are enabled.
 	var indexManager blockchain.IndexManager
 	if len(indexes) > 0 {
@@ -2505,6 +2693,11 @@ func NewServer(db database.Transactor, stateDB database.Database, agentBlacklist
 		srvrLog.Infof("miner address=%v", acc.Address.String())
 	}
 
+	keys, err := crypto.NewKeyRing(acc, chaincfg.Cfg.ValidatorKeys)
+	if err != nil {
+		return nil, err
+	}
+
 	// Create a round manager.
 	roundManger := consensus.NewRoundManager(cfg.Consensustype, acc)
 	if roundManger == nil {
@@ -2551,18 +2744,28 @@ func NewServer(db database.Transactor, stateDB database.Database, agentBlacklist
 		FeesChan:               feesChan,
 		CheckTransactionInputs: blockchain.CheckTransactionInputs,
 	}
+	if !chaincfg.Cfg.NoPersistMempool {
+		txC.PersistFile = filepath.Join(chaincfg.Cfg.DataDir, mempool.MempoolFileName)
+	}
 	s.txMemPool = mempool.New(&txC)
-	s.sigMemPool = mempool.NewSigPool()
+	s.evidencePool = mempool.NewEvidencePool(&mempool.EvidenceConfig{
+		CheckEvidence:    s.chain.CheckEvidence,
+		AnnounceEvidence: s.AnnounceNewEvidence,
+	})
+	s.sigMemPool = mempool.NewSigPool(s.evidencePool)
 
 	s.syncManager, err = netsync.New(&netsync.Config{
 		PeerNotifier:       &s,
 		Chain:              s.chain,
 		TxMemPool:          s.txMemPool,
 		SigMemPool:         s.sigMemPool,
+		EvidencePool:       s.evidencePool,
 		ChainParams:        s.chainParams,
 		DisableCheckpoints: chaincfg.Cfg.DisableCheckpoints,
+		StateSync:          chaincfg.Cfg.StateSync,
 		MaxPeers:           chaincfg.Cfg.MaxPeers,
 		Account:            acc,
+		Keys:               keys,
 		BroadcastMessage: func(msg protos.Message, exclPeers ...interface{}) {
 			s.BroadcastMessage(msg)
 		},
@@ -2592,6 +2795,10 @@ func NewServer(db database.Transactor, stateDB database.Database, agentBlacklist
 		GasCeil:      common.GasCeil,
 		RoundManager: roundManger,
 		Account:      acc,
+		Keys:         keys,
+		Broadcast: func(msg protos.Message) {
+			s.BroadcastMessage(msg)
+		},
 	}
 
 	s.consensus, err = consensus.NewConsensusService(chaincfg.Cfg.Consensustype, &consensusConfig)
@@ -2700,9 +2907,12 @@ func NewServer(db database.Transactor, stateDB database.Database, agentBlacklist
 			ChainParams:     chainParams,
 			DB:              db,
 			TxMemPool:       s.txMemPool,
+			EvidencePool:    s.evidencePool,
 			TxIndex:         s.txIndex,
 			AddrIndex:       s.addrIndex,
 			CfIndex:         s.cfIndex,
+			BloomIndex:      s.bloomIndex,
+			MaxLogRange:     cfg.RPCMaxLogRange,
 			Nap:             nap,
 			ConsensusServer: s.consensus,
 			ContractMgr:     contractManager,
@@ -0,0 +1,81 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"os"
+
+	"github.com/AsimovNetwork/asimov/blockchain"
+	"github.com/AsimovNetwork/asimov/blockchain/indexers"
+	"github.com/AsimovNetwork/asimov/chaincfg"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/consensus/satoshiplus/minersync"
+	"github.com/AsimovNetwork/asimov/database"
+)
+
+// exportSnapshot writes a snapshot of the chain state at the configured height
+// to the configured file.
+func exportSnapshot(db database.Transactor, stateDB database.Database, cfg *chaincfg.FConfig) error {
+	f, err := os.Create(cfg.ExportSnapshot)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	mainLog.Infof("Exporting the chain state snapshot to %s", cfg.ExportSnapshot)
+	w := bufio.NewWriter(f)
+	info, err := blockchain.ExportSnapshot(db, stateDB, chaincfg.ActiveNetParams.Params,
+		&blockchain.SnapshotConfig{
+			Height:  cfg.SnapshotHeight,
+			Buckets: minersync.SnapshotBuckets(),
+			Templates: func(fn func(hash *common.Hash, data []byte) error) error {
+				return indexers.ForEachTemplate(db, fn)
+			},
+		}, w)
+	if err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+
+	mainLog.Infof("Exported the chain state at block %v (height %d): state root %v, "+
+		"%d utxos with hash %v and utxo set hash %v, %d state nodes, %d templates",
+		info.Hash, info.Height, info.StateRoot, info.Utxos, info.UtxoHash,
+		info.UtxoSetHash, info.StateNodes, info.Templates)
+	return nil
+}
+
+// importSnapshot bootstraps the empty databases of the node from the chain
+// state snapshot in the configured file.
+func importSnapshot(db database.Transactor, stateDB database.Database, cfg *chaincfg.FConfig) error {
+	f, err := os.Open(cfg.ImportSnapshot)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	mainLog.Infof("Importing the chain state snapshot from %s", cfg.ImportSnapshot)
+	utxoSetHash := common.HexToHash(cfg.SnapshotUtxoHash)
+	info, err := blockchain.ImportSnapshot(db, stateDB, chaincfg.ActiveNetParams.Params,
+		&blockchain.SnapshotConfig{
+			Buckets:     minersync.SnapshotBuckets(),
+			UtxoSetHash: &utxoSetHash,
+		}, bufio.NewReader(f))
+	if err != nil {
+		return err
+	}
+
+	mainLog.Infof("Imported the chain state at block %v (height %d): state root %v, "+
+		"%d utxos with hash %v and utxo set hash %v, %d state nodes, %d templates",
+		info.Hash, info.Height, info.StateRoot, info.Utxos, info.UtxoHash,
+		info.UtxoSetHash, info.StateNodes, info.Templates)
+	mainLog.Infof("Make sure block %v is part of the chain you expect", info.Hash)
+	return nil
+}
@@ -0,0 +1,470 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package spv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/AsimovNetwork/asimov/blockchain"
+	"github.com/AsimovNetwork/asimov/chaincfg"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/database"
+	"github.com/AsimovNetwork/asimov/protos"
+)
+
+var (
+	// headerBucketName is the name of the bucket which maps the heights of
+	// the main chain to their serialized headers.
+	headerBucketName = []byte("spvheaderidx")
+
+	// heightBucketName is the name of the bucket which maps the hashes of
+	// the main chain headers to their heights.
+	heightBucketName = []byte("spvheightidx")
+
+	// headerTipKeyName is the name of the key holding the hash and the
+	// height of the best header.
+	headerTipKeyName = []byte("spvheadertip")
+)
+
+// heightKey returns the key of the passed height in the header bucket.  The
+// heights are big endian so the headers are iterated in order.
+func heightKey(height int32) []byte {
+	var key [4]byte
+	binary.BigEndian.PutUint32(key[:], uint32(height))
+	return key[:]
+}
+
+// validatorSet is the ordered list of the validators of the rounds from a
+// round on.
+type validatorSet struct {
+	round      uint32
+	validators []common.Address
+}
+
+// headerChain is the chain of headers followed by the light client.  Only
+// the best chain is kept: the headers of a side chain are checked when a peer
+// announces them, and replace the main chain headers when they weigh more, as
+// the full nodes choose their best chain.
+type headerChain struct {
+	db     database.Transactor
+	params *chaincfg.Params
+
+	// validatorSets are the validator sets in the order of their rounds.
+	validatorSets []validatorSet
+
+	// checkpoints are the hashes the headers at their heights must have.
+	checkpoints map[int32]common.Hash
+
+	// maxTimeOffset is the number of seconds a header time is allowed to
+	// be ahead of the current time.
+	maxTimeOffset int64
+
+	mtx     sync.RWMutex
+	tip     *protos.BlockHeader
+	tipHash common.Hash
+}
+
+// newHeaderChain loads the header chain from the database, and initializes it
+// with the genesis header when it is empty.  The validators are the ordered
+// validators from the genesis on, and the validator sets replace them from
+// their rounds on.
+func newHeaderChain(db database.Transactor, params *chaincfg.Params,
+	validators []common.Address, validatorSets map[uint32][]common.Address,
+	checkpoints map[int32]common.Hash, maxTimeOffset int64) (*headerChain, error) {
+
+	c := &headerChain{
+		db:            db,
+		params:        params,
+		checkpoints:   checkpoints,
+		maxTimeOffset: maxTimeOffset,
+	}
+	if _, ok := validatorSets[0]; !ok {
+		c.validatorSets = append(c.validatorSets, validatorSet{0, validators})
+	}
+	for round, set := range validatorSets {
+		c.validatorSets = append(c.validatorSets, validatorSet{round, set})
+	}
+	sort.Slice(c.validatorSets, func(i, j int) bool {
+		return c.validatorSets[i].round < c.validatorSets[j].round
+	})
+	for _, set := range c.validatorSets {
+		if len(set.validators) == 0 {
+			return nil, fmt.Errorf("no validator from round %d", set.round)
+		}
+	}
+
+	err := db.Update(func(dbTx database.Tx) error {
+		meta := dbTx.Metadata()
+		headers, err := meta.CreateBucketIfNotExists(headerBucketName)
+		if err != nil {
+			return err
+		}
+		heights, err := meta.CreateBucketIfNotExists(heightBucketName)
+		if err != nil {
+			return err
+		}
+
+		serializedTip := meta.Get(headerTipKeyName)
+		if serializedTip == nil {
+			genesis := &params.GenesisBlock.Header
+			if err := dbPutHeader(headers, heights, genesis); err != nil {
+				return err
+			}
+			hash := genesis.BlockHash()
+			c.tip, c.tipHash = genesis, hash
+			return meta.Put(headerTipKeyName, serializeHeaderTip(&hash, 0))
+		}
+
+		if len(serializedTip) != common.HashLength+4 {
+			return fmt.Errorf("corrupt header tip of %d bytes",
+				len(serializedTip))
+		}
+		copy(c.tipHash[:], serializedTip)
+		height := int32(binary.BigEndian.Uint32(serializedTip[common.HashLength:]))
+		c.tip, err = dbFetchHeader(headers, height)
+		if err != nil {
+			return err
+		}
+		if c.tip.BlockHash() != c.tipHash {
+			return fmt.Errorf("header tip %v does not match the header "+
+				"at height %d", c.tipHash, height)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// serializeHeaderTip returns the serialization of the best header hash and
+// height.
+func serializeHeaderTip(hash *common.Hash, height int32) []byte {
+	serialized := make([]byte, common.HashLength+4)
+	copy(serialized, hash[:])
+	binary.BigEndian.PutUint32(serialized[common.HashLength:], uint32(height))
+	return serialized
+}
+
+// dbPutHeader stores the header in the main chain buckets.
+func dbPutHeader(headers, heights database.Bucket, header *protos.BlockHeader) error {
+	var buf bytes.Buffer
+	if err := header.Serialize(&buf); err != nil {
+		return err
+	}
+	if err := headers.Put(heightKey(header.Height), buf.Bytes()); err != nil {
+		return err
+	}
+	hash := header.BlockHash()
+	return heights.Put(hash[:], heightKey(header.Height))
+}
+
+// dbFetchHeader returns the main chain header at the passed height.
+func dbFetchHeader(headers database.Bucket, height int32) (*protos.BlockHeader, error) {
+	serialized := headers.Get(heightKey(height))
+	if serialized == nil {
+		return nil, fmt.Errorf("no header at height %d", height)
+	}
+	header := &protos.BlockHeader{}
+	if err := header.Deserialize(bytes.NewReader(serialized)); err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+// Tip returns the best header along with its hash.
+//
+// This function is safe for concurrent access.
+func (c *headerChain) Tip() (*protos.BlockHeader, common.Hash) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	return c.tip, c.tipHash
+}
+
+// HeaderByHeight returns the main chain header at the passed height.
+//
+// This function is safe for concurrent access.
+func (c *headerChain) HeaderByHeight(height int32) (*protos.BlockHeader, error) {
+	var header *protos.BlockHeader
+	err := c.db.View(func(dbTx database.Tx) error {
+		var err error
+		header, err = dbFetchHeader(dbTx.Metadata().Bucket(headerBucketName), height)
+		return err
+	})
+	return header, err
+}
+
+// HeightByHash returns the height of the main chain header with the passed
+// hash, and false when it is not in the main chain.
+//
+// This function is safe for concurrent access.
+func (c *headerChain) HeightByHash(hash *common.Hash) (int32, bool) {
+	height := int32(-1)
+	c.db.View(func(dbTx database.Tx) error {
+		serialized := dbTx.Metadata().Bucket(heightBucketName).Get(hash[:])
+		if serialized != nil {
+			height = int32(binary.BigEndian.Uint32(serialized))
+		}
+		return nil
+	})
+	return height, height >= 0
+}
+
+// HashesByHeightRange returns the hashes of the main chain headers from the
+// start height to the stop height, inclusive.
+//
+// This function is safe for concurrent access.
+func (c *headerChain) HashesByHeightRange(start, stop int32) ([]common.Hash, error) {
+	hashes := make([]common.Hash, 0, stop-start+1)
+	err := c.db.View(func(dbTx database.Tx) error {
+		headers := dbTx.Metadata().Bucket(headerBucketName)
+		for height := start; height <= stop; height++ {
+			header, err := dbFetchHeader(headers, height)
+			if err != nil {
+				return err
+			}
+			hashes = append(hashes, header.BlockHash())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return hashes, nil
+}
+
+// BlockLocator returns a block locator of the best chain: the hashes of the
+// ten last headers, followed by headers exponentially further back, down to
+// the genesis.
+//
+// This function is safe for concurrent access.
+func (c *headerChain) BlockLocator() ([]*common.Hash, error) {
+	tip, _ := c.Tip()
+	tipHeight := tip.Height
+
+	var locator []*common.Hash
+	err := c.db.View(func(dbTx database.Tx) error {
+		headers := dbTx.Metadata().Bucket(headerBucketName)
+		step := int32(1)
+		for height := tipHeight; ; height -= step {
+			if height < 0 {
+				height = 0
+			}
+			header, err := dbFetchHeader(headers, height)
+			if err != nil {
+				return err
+			}
+			hash := header.BlockHash()
+			locator = append(locator, &hash)
+			if height == 0 || len(locator) == protos.MaxBlockLocatorsPerMsg-1 {
+				break
+			}
+			if len(locator) > 10 {
+				step *= 2
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Always end with the genesis so peers find a common ancestor.
+	if *locator[len(locator)-1] != *c.params.GenesisHash {
+		locator = append(locator, c.params.GenesisHash)
+	}
+	return locator, nil
+}
+
+// validators returns the ordered validators of the round.
+func (c *headerChain) validators(round uint32) []common.Address {
+	i := sort.Search(len(c.validatorSets), func(i int) bool {
+		return c.validatorSets[i].round > round
+	})
+	return c.validatorSets[i-1].validators
+}
+
+// slotValidator returns the validator expected to produce the block of the
+// slot of the round.  As the POA round manager does, the slots of a round go
+// to the validators in turn.  The slots of the other consensus are not known
+// from the headers alone, which is why the light client only follows poa
+// chains.
+func (c *headerChain) slotValidator(round uint32, slot uint16) common.Address {
+	validators := c.validators(round)
+	return validators[int(slot)%len(validators)]
+}
+
+// maxHeaderWeight returns the highest weight of a header of the round: the
+// weight of its producer, plus the weight of the signatures of the previous
+// blocks it may hold, one per validator for each of the blocks within
+// BlockSignDepth.  Every POA validator weighs one.
+func (c *headerChain) maxHeaderWeight(round uint32) uint16 {
+	return uint16(1 + (common.BlockSignDepth+1)*len(c.validators(round)))
+}
+
+// checkHeader checks the header connects the passed parent header under the
+// consensus rules a light client is able to check without the chain state:
+// the height, the round and slot order, the time, the checkpoints, that the
+// header is signed by the validator of its slot, and that its weight is
+// possible.
+func (c *headerChain) checkHeader(header, parent *protos.BlockHeader) error {
+	hash := header.BlockHash()
+	if header.PrevBlock != parent.BlockHash() {
+		return fmt.Errorf("header %v does not connect its parent %v",
+			hash, parent.BlockHash())
+	}
+	if header.Height != parent.Height+1 {
+		return fmt.Errorf("header %v has height %d, want %d", hash,
+			header.Height, parent.Height+1)
+	}
+	if header.SlotIndex >= c.params.RoundSize {
+		return fmt.Errorf("header %v has slot %d, the round size is %d",
+			hash, header.SlotIndex, c.params.RoundSize)
+	}
+	if parent.Round > header.Round ||
+		parent.Round == header.Round && parent.SlotIndex >= header.SlotIndex {
+		return fmt.Errorf("header %v has old slot/round than parent: "+
+			"slot:%d/%d, round:%d/%d", hash, parent.SlotIndex,
+			header.SlotIndex, parent.Round, header.Round)
+	}
+	if header.Timestamp-time.Now().Unix() > c.maxTimeOffset {
+		return fmt.Errorf("header %v timestamp of %v is too far in the "+
+			"future", hash, header.Timestamp)
+	}
+	if checkpoint, ok := c.checkpoints[header.Height]; ok && checkpoint != hash {
+		return fmt.Errorf("header %v at height %d does not match the "+
+			"checkpoint %v", hash, header.Height, checkpoint)
+	}
+	if validator := c.slotValidator(header.Round, header.SlotIndex); header.CoinBase != validator {
+		return fmt.Errorf("header %v is produced by %v, the validator of "+
+			"round %d slot %d is %v", hash, header.CoinBase.String(),
+			header.Round, header.SlotIndex, validator.String())
+	}
+	if header.Weight < 1 || header.Weight > c.maxHeaderWeight(header.Round) {
+		return fmt.Errorf("header %v has weight %d, want from 1 to %d", hash,
+			header.Weight, c.maxHeaderWeight(header.Round))
+	}
+	if err := blockchain.AddressVerifySignature(hash[:], &header.CoinBase,
+		header.SigData[:]); err != nil {
+		return fmt.Errorf("header %v has an invalid signature: %v", hash, err)
+	}
+	return nil
+}
+
+// ConnectHeaders checks the passed consecutive headers and connects them.
+// The headers already in the main chain are skipped.  When the headers fork
+// from the main chain, they replace the main chain headers after the fork
+// point, provided they weigh more than them.
+//
+// It returns the height of the last header the main chain kept, which is
+// lower than the previous best height when the headers forked.
+//
+// This function is safe for concurrent access.
+func (c *headerChain) ConnectHeaders(headers []*protos.BlockHeader) (int32, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	tipHeight := c.tip.Height
+	if len(headers) == 0 {
+		return tipHeight, nil
+	}
+
+	forkHeight := tipHeight
+	err := c.db.Update(func(dbTx database.Tx) error {
+		meta := dbTx.Metadata()
+		headerBucket := meta.Bucket(headerBucketName)
+		heightBucket := meta.Bucket(heightBucketName)
+
+		// Find the parent of the first header and skip the headers which
+		// are already known.
+		serializedHeight := heightBucket.Get(headers[0].PrevBlock[:])
+		if serializedHeight == nil {
+			return fmt.Errorf("header %v does not connect the main chain",
+				headers[0].BlockHash())
+		}
+		parentHeight := int32(binary.BigEndian.Uint32(serializedHeight))
+		for len(headers) > 0 {
+			hash := headers[0].BlockHash()
+			if heightBucket.Get(hash[:]) == nil {
+				break
+			}
+			parentHeight++
+			headers = headers[1:]
+		}
+		if len(headers) == 0 {
+			return nil
+		}
+		parent, err := dbFetchHeader(headerBucket, parentHeight)
+		if err != nil {
+			return err
+		}
+		var sideWeight uint64
+		for _, header := range headers {
+			if err := c.checkHeader(header, parent); err != nil {
+				return err
+			}
+			sideWeight += uint64(header.Weight)
+			parent = header
+		}
+
+		// A side chain replaces the main chain headers after the fork
+		// point only when it weighs more.
+		replaced := make([]*protos.BlockHeader, 0, tipHeight-parentHeight)
+		var mainWeight uint64
+		for height := parentHeight + 1; height <= tipHeight; height++ {
+			header, err := dbFetchHeader(headerBucket, height)
+			if err != nil {
+				return err
+			}
+			replaced = append(replaced, header)
+			mainWeight += uint64(header.Weight)
+		}
+		if len(replaced) > 0 && sideWeight <= mainWeight {
+			return fmt.Errorf("side chain of %d headers after height %d "+
+				"weighs %d, not more than the %d of the main chain",
+				len(headers), parentHeight, sideWeight, mainWeight)
+		}
+
+		// Remove the main chain headers the new ones replace.
+		for _, header := range replaced {
+			hash := header.BlockHash()
+			height := header.Height
+			if err := heightBucket.Delete(hash[:]); err != nil {
+				return err
+			}
+			if err := headerBucket.Delete(heightKey(height)); err != nil {
+				return err
+			}
+		}
+		for _, header := range headers {
+			if err := dbPutHeader(headerBucket, heightBucket, header); err != nil {
+				return err
+			}
+		}
+		hash := parent.BlockHash()
+		if err := meta.Put(headerTipKeyName,
+			serializeHeaderTip(&hash, parent.Height)); err != nil {
+			return err
+		}
+
+		if parentHeight < tipHeight {
+			log.Infof("Header chain reorganized after height %d, the "+
+				"new best header is %v at height %d", parentHeight,
+				hash, parent.Height)
+		}
+		forkHeight = parentHeight
+		c.tip, c.tipHash = parent, hash
+		return nil
+	})
+	if err != nil {
+		return tipHeight, err
+	}
+	return forkHeight, nil
+}
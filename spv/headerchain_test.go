@@ -0,0 +1,309 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package spv
+
+import (
+	"crypto/ecdsa"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AsimovNetwork/asimov/chaincfg"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/crypto"
+	"github.com/AsimovNetwork/asimov/database"
+	"github.com/AsimovNetwork/asimov/database/dbdriver"
+	"github.com/AsimovNetwork/asimov/protos"
+)
+
+// newTestDB creates a database in a temporary directory, along with the
+// parameters of a network whose genesis is a fresh header.
+func newTestDB(t *testing.T) (database.Transactor, *chaincfg.Params, func()) {
+	dir, err := ioutil.TempDir("", "spv")
+	if err != nil {
+		t.Fatalf("TempDir err %v", err)
+	}
+	params := chaincfg.DevelopNetParams
+	genesis := &protos.MsgBlock{Header: protos.BlockHeader{
+		Timestamp: time.Now().Unix() - 3600,
+		SlotIndex: params.RoundSize - 1,
+	}}
+	genesisHash := genesis.Header.BlockHash()
+	params.GenesisBlock = genesis
+	params.GenesisHash = &genesisHash
+	params.Checkpoints = nil
+
+	db, err := dbdriver.Create(database.FFLDB, filepath.Join(dir, "spv"), params.Net)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("Create err %v", err)
+	}
+	return db, &params, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// newTestHeader returns the header of weight 1 following the parent at the
+// passed round and slot, signed by the passed account.
+func newTestHeader(t *testing.T, parent *protos.BlockHeader, round uint32,
+	slot uint16, acc *crypto.Account) *protos.BlockHeader {
+	return newWeightedTestHeader(t, parent, round, slot, 1, acc)
+}
+
+// newWeightedTestHeader returns the header of the passed weight following the
+// parent at the passed round and slot, signed by the passed account.
+func newWeightedTestHeader(t *testing.T, parent *protos.BlockHeader, round uint32,
+	slot uint16, weight uint16, acc *crypto.Account) *protos.BlockHeader {
+
+	header := &protos.BlockHeader{
+		PrevBlock: parent.BlockHash(),
+		Timestamp: parent.Timestamp + 5,
+		Round:     round,
+		SlotIndex: slot,
+		Height:    parent.Height + 1,
+		CoinBase:  *acc.Address,
+		Weight:    weight,
+	}
+	hash := header.BlockHash()
+	signature, err := crypto.Sign(hash[:], (*ecdsa.PrivateKey)(&acc.PrivateKey))
+	if err != nil {
+		t.Fatalf("Sign err %v", err)
+	}
+	copy(header.SigData[:], signature)
+	return header
+}
+
+func TestHeaderChain(t *testing.T) {
+	validator, _ := crypto.NewAccount("0xd0f0461b7b4d26cf370e6c73b58ef7fa26e8e30853a8cee901ed42cf0879cb6e")
+	stranger, _ := crypto.NewAccount("0x224828e95689e30a8e668418968260f7a01db9e21e6dc74b0de4ba4bd4d5fd6d")
+
+	db, params, teardown := newTestDB(t)
+	defer teardown()
+
+	chain, err := newHeaderChain(db, params, []common.Address{*validator.Address}, nil, nil, 30)
+	if err != nil {
+		t.Fatalf("newHeaderChain err %v", err)
+	}
+	genesis := &params.GenesisBlock.Header
+	if _, hash := chain.Tip(); hash != *params.GenesisHash {
+		t.Fatalf("got tip %v, want the genesis", hash)
+	}
+
+	// Connect the main chain.
+	h1 := newTestHeader(t, genesis, 1, 0, validator)
+	h2 := newTestHeader(t, h1, 1, 1, validator)
+	h3 := newTestHeader(t, h2, 1, 3, validator)
+	forkHeight, err := chain.ConnectHeaders([]*protos.BlockHeader{h1, h2, h3})
+	if err != nil {
+		t.Fatalf("ConnectHeaders err %v", err)
+	}
+	if tip, hash := chain.Tip(); tip.Height != 3 || hash != h3.BlockHash() || forkHeight != 0 {
+		t.Fatalf("got tip %v at height %d and fork height %d, want %v at "+
+			"height 3 and fork height 0", hash, tip.Height, forkHeight, h3.BlockHash())
+	}
+
+	// Known headers are skipped.
+	if forkHeight, err = chain.ConnectHeaders([]*protos.BlockHeader{h2, h3}); err != nil || forkHeight != 3 {
+		t.Fatalf("ConnectHeaders of known headers got fork height %d, err %v", forkHeight, err)
+	}
+
+	tests := []struct {
+		name   string
+		header *protos.BlockHeader
+	}{
+		{"not signed by a validator", newTestHeader(t, h3, 1, 4, stranger)},
+		{"old slot", newTestHeader(t, h3, 1, 3, validator)},
+		{"slot out of the round", newTestHeader(t, h3, 1, params.RoundSize, validator)},
+		{"bad height", func() *protos.BlockHeader {
+			header := newTestHeader(t, h3, 1, 4, validator)
+			header.Height++
+			return header
+		}()},
+		{"no weight", newWeightedTestHeader(t, h3, 1, 4, 0, validator)},
+		{"too heavy", newWeightedTestHeader(t, h3, 1, 4,
+			1+(common.BlockSignDepth+1)+1, validator)},
+		{"bad signature", func() *protos.BlockHeader {
+			header := newTestHeader(t, h3, 1, 4, validator)
+			header.SigData[0] ^= 0xff
+			return header
+		}()},
+		{"time too new", func() *protos.BlockHeader {
+			parent := *h3
+			parent.Timestamp = time.Now().Unix() + 60
+			return newTestHeader(t, &parent, 1, 4, validator)
+		}()},
+		{"unknown parent", newTestHeader(t, newTestHeader(t, h3, 1, 4, validator), 1, 5, validator)},
+	}
+	for _, test := range tests {
+		if _, err := chain.ConnectHeaders([]*protos.BlockHeader{test.header}); err == nil {
+			t.Errorf("%s: ConnectHeaders accepted the header", test.name)
+		}
+		if tip, _ := chain.Tip(); tip.Height != 3 {
+			t.Errorf("%s: tip moved to height %d", test.name, tip.Height)
+		}
+	}
+
+	// A side chain is only accepted when it weighs more.
+	f2 := newTestHeader(t, h1, 1, 2, validator)
+	f3 := newTestHeader(t, f2, 1, 4, validator)
+	if _, err := chain.ConnectHeaders([]*protos.BlockHeader{f2, f3}); err == nil {
+		t.Fatalf("ConnectHeaders accepted a side chain of the same weight")
+	}
+	f4 := newTestHeader(t, f3, 2, 0, validator)
+	forkHeight, err = chain.ConnectHeaders([]*protos.BlockHeader{f2, f3, f4})
+	if err != nil {
+		t.Fatalf("ConnectHeaders of the side chain err %v", err)
+	}
+	if tip, hash := chain.Tip(); tip.Height != 4 || hash != f4.BlockHash() || forkHeight != 1 {
+		t.Fatalf("got tip %v at height %d and fork height %d, want %v at "+
+			"height 4 and fork height 1", hash, tip.Height, forkHeight, f4.BlockHash())
+	}
+	h2Hash := h2.BlockHash()
+	if _, ok := chain.HeightByHash(&h2Hash); ok {
+		t.Errorf("replaced header %v is still in the main chain", h2Hash)
+	}
+	hashes, err := chain.HashesByHeightRange(1, 4)
+	if err != nil {
+		t.Fatalf("HashesByHeightRange err %v", err)
+	}
+	want := []common.Hash{h1.BlockHash(), f2.BlockHash(), f3.BlockHash(), f4.BlockHash()}
+	for i := range want {
+		if hashes[i] != want[i] {
+			t.Errorf("got hash %v at height %d, want %v", hashes[i], i+1, want[i])
+		}
+	}
+
+	locator, err := chain.BlockLocator()
+	if err != nil {
+		t.Fatalf("BlockLocator err %v", err)
+	}
+	if *locator[0] != f4.BlockHash() || *locator[len(locator)-1] != *params.GenesisHash {
+		t.Errorf("locator %v does not go from the tip to the genesis", locator)
+	}
+
+	// The checkpoints reject the headers of other chains.
+	checkpoints := map[int32]common.Hash{5: {0x01}}
+	chain, err = newHeaderChain(db, params, []common.Address{*validator.Address}, nil, checkpoints, 30)
+	if err != nil {
+		t.Fatalf("newHeaderChain err %v", err)
+	}
+	if tip, hash := chain.Tip(); tip.Height != 4 || hash != f4.BlockHash() {
+		t.Fatalf("reloaded chain got tip %v at height %d, want %v at height 4",
+			hash, tip.Height, f4.BlockHash())
+	}
+	if _, err := chain.ConnectHeaders([]*protos.BlockHeader{
+		newTestHeader(t, f4, 2, 1, validator)}); err == nil {
+		t.Errorf("ConnectHeaders accepted a header not matching the checkpoint")
+	}
+}
+
+// TestHeaderChainSlots ensures the headers are produced by the validator of
+// their slot in the validator set of their round.
+func TestHeaderChainSlots(t *testing.T) {
+	first, _ := crypto.NewAccount("0xd0f0461b7b4d26cf370e6c73b58ef7fa26e8e30853a8cee901ed42cf0879cb6e")
+	second, _ := crypto.NewAccount("0x224828e95689e30a8e668418968260f7a01db9e21e6dc74b0de4ba4bd4d5fd6d")
+
+	db, params, teardown := newTestDB(t)
+	defer teardown()
+
+	// The second validator is alone from round 2 on.
+	chain, err := newHeaderChain(db, params,
+		[]common.Address{*first.Address, *second.Address},
+		map[uint32][]common.Address{2: {*second.Address}}, nil, 30)
+	if err != nil {
+		t.Fatalf("newHeaderChain err %v", err)
+	}
+	genesis := &params.GenesisBlock.Header
+
+	h1 := newTestHeader(t, genesis, 1, 0, first)
+	h2 := newTestHeader(t, h1, 1, 1, second)
+	h3 := newTestHeader(t, h2, 1, 2, first)
+	if _, err := chain.ConnectHeaders([]*protos.BlockHeader{h1, h2, h3}); err != nil {
+		t.Fatalf("ConnectHeaders err %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		header *protos.BlockHeader
+	}{
+		{"validator of another slot", newTestHeader(t, h3, 1, 3, first)},
+		{"validator of a previous round", newTestHeader(t, h3, 2, 0, first)},
+	}
+	for _, test := range tests {
+		if _, err := chain.ConnectHeaders([]*protos.BlockHeader{test.header}); err == nil {
+			t.Errorf("%s: ConnectHeaders accepted the header", test.name)
+		}
+	}
+
+	h4 := newTestHeader(t, h3, 1, 3, second)
+	h5 := newTestHeader(t, h4, 2, 0, second)
+	h6 := newTestHeader(t, h5, 2, 1, second)
+	if _, err := chain.ConnectHeaders([]*protos.BlockHeader{h4, h5, h6}); err != nil {
+		t.Fatalf("ConnectHeaders err %v", err)
+	}
+	if tip, _ := chain.Tip(); tip.Height != 6 {
+		t.Fatalf("got tip at height %d, want 6", tip.Height)
+	}
+}
+
+// TestHeaderChainWeight ensures the light client follows the heaviest chain,
+// not the highest one.
+func TestHeaderChainWeight(t *testing.T) {
+	validator, _ := crypto.NewAccount("0xd0f0461b7b4d26cf370e6c73b58ef7fa26e8e30853a8cee901ed42cf0879cb6e")
+
+	db, params, teardown := newTestDB(t)
+	defer teardown()
+
+	chain, err := newHeaderChain(db, params, []common.Address{*validator.Address}, nil, nil, 30)
+	if err != nil {
+		t.Fatalf("newHeaderChain err %v", err)
+	}
+	genesis := &params.GenesisBlock.Header
+
+	h1 := newTestHeader(t, genesis, 1, 0, validator)
+	h2 := newTestHeader(t, h1, 1, 1, validator)
+	h3 := newTestHeader(t, h2, 1, 2, validator)
+	if _, err := chain.ConnectHeaders([]*protos.BlockHeader{h1, h2, h3}); err != nil {
+		t.Fatalf("ConnectHeaders err %v", err)
+	}
+
+	// A higher but lighter side chain is refused.
+	f2 := newTestHeader(t, h1, 1, 3, validator)
+	f3 := newTestHeader(t, f2, 1, 4, validator)
+	f4 := newTestHeader(t, f3, 1, 5, validator)
+	if _, err := chain.ConnectHeaders([]*protos.BlockHeader{
+		newWeightedTestHeader(t, h1, 1, 3, 1, validator)}); err == nil {
+		t.Fatalf("ConnectHeaders accepted a lighter side chain")
+	}
+
+	// A lower but heavier side chain replaces the main chain.
+	g2 := newWeightedTestHeader(t, h1, 1, 3, 3, validator)
+	forkHeight, err := chain.ConnectHeaders([]*protos.BlockHeader{g2})
+	if err != nil {
+		t.Fatalf("ConnectHeaders of the heavier side chain err %v", err)
+	}
+	if tip, hash := chain.Tip(); tip.Height != 2 || hash != g2.BlockHash() || forkHeight != 1 {
+		t.Fatalf("got tip %v at height %d and fork height %d, want %v at "+
+			"height 2 and fork height 1", hash, tip.Height, forkHeight, g2.BlockHash())
+	}
+
+	// The replaced chain needs more weight to come back.
+	if _, err := chain.ConnectHeaders([]*protos.BlockHeader{h2, h3}); err == nil {
+		t.Fatalf("ConnectHeaders accepted the lighter replaced chain")
+	}
+	if _, err := chain.ConnectHeaders([]*protos.BlockHeader{f2, f3, f4}); err == nil {
+		t.Fatalf("ConnectHeaders accepted a side chain of the same weight")
+	}
+	f5 := newTestHeader(t, f4, 2, 0, validator)
+	if _, err := chain.ConnectHeaders([]*protos.BlockHeader{f2, f3, f4, f5}); err != nil {
+		t.Fatalf("ConnectHeaders of the heavier side chain err %v", err)
+	}
+	if _, hash := chain.Tip(); hash != f5.BlockHash() {
+		t.Fatalf("got tip %v, want %v", hash, f5.BlockHash())
+	}
+}
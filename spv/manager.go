@@ -0,0 +1,768 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package spv
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/asiutil/gcs"
+	"github.com/AsimovNetwork/asimov/asiutil/gcs/builder"
+	"github.com/AsimovNetwork/asimov/blockchain"
+	"github.com/AsimovNetwork/asimov/chaincfg"
+	"github.com/AsimovNetwork/asimov/common"
+	fnet "github.com/AsimovNetwork/asimov/common/net"
+	"github.com/AsimovNetwork/asimov/connmgr"
+	"github.com/AsimovNetwork/asimov/database"
+	"github.com/AsimovNetwork/asimov/peer"
+	"github.com/AsimovNetwork/asimov/protos"
+)
+
+const (
+	// stallTickInterval is the interval of time between each check for
+	// a stalled sync peer.
+	stallTickInterval = 15 * time.Second
+
+	// stallTimeout is the time the sync peer is given to answer a request
+	// before it is disconnected.
+	stallTimeout = 2 * time.Minute
+
+	// connectionRetryInterval is the base amount of time to wait in between
+	// retries when connecting to persistent peers.
+	connectionRetryInterval = 5 * time.Second
+)
+
+// Config is the configuration of the light client.
+type Config struct {
+	// DB is the database the headers and the wallet are stored in.
+	DB database.Transactor
+
+	ChainParams *chaincfg.Params
+
+	// Validators are the validators from the genesis on, in the order of
+	// the consensus_poa contract, which gives their slots.
+	Validators []common.Address
+
+	// ValidatorSets are the ordered validators which replace Validators
+	// from the rounds they are keyed by.
+	ValidatorSets map[uint32][]common.Address
+
+	// Checkpoints are the hashes the headers at their heights must have.
+	Checkpoints map[int32]common.Hash
+
+	// MaxTimeOffset is the number of seconds a header time is allowed to be
+	// ahead of the current time.
+	MaxTimeOffset int64
+
+	// Addresses are the addresses the wallet watches.
+	Addresses []*common.Address
+
+	// Peers are the full nodes the light client connects to.  They must
+	// serve the committed filters.
+	Peers []net.Addr
+
+	Nap              fnet.NetAdapter
+	HostToNetAddress peer.HostToNetAddrFunc
+	Proxy            string
+
+	UserAgentName     string
+	UserAgentVersion  string
+	UserAgentComments []string
+}
+
+// pendingFilter is a block of the filter batch being scanned.
+type pendingFilter struct {
+	height int32
+	hash   common.Hash
+
+	received bool
+	matched  bool
+
+	// The matched blocks are downloaded along with their virtual blocks.
+	requested bool
+	block     *protos.MsgBlock
+	vblock    *protos.MsgVBlock
+}
+
+// Messages handled by the sync handler.
+type peerReadyMsg struct {
+	peer *peer.Peer
+}
+
+type peerDoneMsg struct {
+	peer *peer.Peer
+}
+
+type headersMsg struct {
+	peer    *peer.Peer
+	headers *protos.MsgHeaders
+}
+
+type cfilterMsg struct {
+	peer    *peer.Peer
+	cfilter *protos.MsgCFilter
+}
+
+type blockMsg struct {
+	peer  *peer.Peer
+	block *protos.MsgBlock
+}
+
+type vblockMsg struct {
+	peer   *peer.Peer
+	vblock *protos.MsgVirtualBlock
+}
+
+type invMsg struct {
+	peer *peer.Peer
+	inv  *protos.MsgInv
+}
+
+type notFoundMsg struct {
+	peer     *peer.Peer
+	notFound *protos.MsgNotFound
+}
+
+// Manager runs the light client.  It downloads the headers from a full node,
+// checks they are signed by the validators, then scans the committed filters
+// of the blocks and downloads the blocks matching the wallet addresses.
+type Manager struct {
+	cfg         Config
+	chain       *headerChain
+	wallet      *wallet
+	connManager *connmgr.ConnManager
+
+	started  int32
+	shutdown int32
+	msgChan  chan interface{}
+	wg       sync.WaitGroup
+	quit     chan struct{}
+
+	peersMtx sync.RWMutex
+	peers    map[*peer.Peer]struct{}
+
+	// The fields below are only accessed by the sync handler.
+	syncPeer       *peer.Peer
+	headersPending bool
+	filterQueue    []*pendingFilter
+	lastProgress   time.Time
+	current        int32
+}
+
+// New returns a light client manager for the passed configuration.
+func New(cfg *Config) (*Manager, error) {
+	chain, err := newHeaderChain(cfg.DB, cfg.ChainParams, cfg.Validators,
+		cfg.ValidatorSets, cfg.Checkpoints, cfg.MaxTimeOffset)
+	if err != nil {
+		return nil, err
+	}
+	w, err := newWallet(cfg.DB, cfg.Addresses, cfg.ChainParams.GenesisHash)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manager{
+		cfg:     *cfg,
+		chain:   chain,
+		wallet:  w,
+		msgChan: make(chan interface{}, cfg.ChainParams.RoundSize),
+		quit:    make(chan struct{}),
+		peers:   make(map[*peer.Peer]struct{}),
+	}
+
+	m.connManager, err = connmgr.New(&connmgr.Config{
+		RetryDuration:  connectionRetryInterval,
+		TargetOutbound: uint32(len(cfg.Peers)),
+		OnConnection:   m.outboundPeerConnected,
+	}, cfg.Nap)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Start begins connecting to the peers and syncing.
+func (m *Manager) Start() {
+	if atomic.AddInt32(&m.started, 1) != 1 {
+		return
+	}
+
+	tip, tipHash := m.chain.Tip()
+	_, walletHeight := m.wallet.SyncedTo()
+	log.Infof("Starting light client at header %v (height %d), wallet "+
+		"height %d", tipHash, tip.Height, walletHeight)
+
+	m.wg.Add(1)
+	go m.syncHandler()
+
+	m.connManager.Start()
+	for _, addr := range m.cfg.Peers {
+		go m.connManager.Connect(&connmgr.ConnReq{
+			Addr:      addr,
+			Permanent: true,
+		})
+	}
+}
+
+// Stop disconnects the peers and stops syncing.
+func (m *Manager) Stop() {
+	if atomic.AddInt32(&m.shutdown, 1) != 1 {
+		return
+	}
+
+	log.Infof("Light client shutting down")
+	m.connManager.Stop()
+	close(m.quit)
+	m.wg.Wait()
+}
+
+// IsCurrent returns whether the wallet scanned the blocks up to the best
+// header announced by the peers.
+//
+// This function is safe for concurrent access.
+func (m *Manager) IsCurrent() bool {
+	return atomic.LoadInt32(&m.current) != 0
+}
+
+// Tip returns the best header along with its hash.
+//
+// This function is safe for concurrent access.
+func (m *Manager) Tip() (*protos.BlockHeader, common.Hash) {
+	return m.chain.Tip()
+}
+
+// WalletSyncedTo returns the hash and the height of the last block scanned
+// by the wallet.
+//
+// This function is safe for concurrent access.
+func (m *Manager) WalletSyncedTo() (common.Hash, int32) {
+	return m.wallet.SyncedTo()
+}
+
+// IsWatched returns whether the passed address is watched by the wallet.
+//
+// This function is safe for concurrent access.
+func (m *Manager) IsWatched(address *common.Address) bool {
+	return m.wallet.IsWatched(address)
+}
+
+// Utxos returns the unspent outputs of the passed wallet address, or of all
+// the wallet addresses when it is nil.
+//
+// This function is safe for concurrent access.
+func (m *Manager) Utxos(address *common.Address) ([]*Utxo, error) {
+	return m.wallet.Utxos(address)
+}
+
+// SendTx sends the transaction to the connected peers.
+//
+// This function is safe for concurrent access.
+func (m *Manager) SendTx(tx *protos.MsgTx) error {
+	m.peersMtx.RLock()
+	defer m.peersMtx.RUnlock()
+
+	if len(m.peers) == 0 {
+		return errors.New("no connected peer")
+	}
+	for p := range m.peers {
+		p.QueueMessage(tx, nil)
+	}
+	return nil
+}
+
+// newPeerConfig returns the configuration of the peers.
+func (m *Manager) newPeerConfig() *peer.Config {
+	return &peer.Config{
+		Listeners: peer.MessageListeners{
+			OnVerAck: func(p *peer.Peer, _ *protos.MsgVerAck) {
+				m.queueMsg(&peerReadyMsg{peer: p})
+			},
+			OnHeaders: func(p *peer.Peer, msg *protos.MsgHeaders) {
+				m.queueMsg(&headersMsg{peer: p, headers: msg})
+			},
+			OnCFilter: func(p *peer.Peer, msg *protos.MsgCFilter) {
+				m.queueMsg(&cfilterMsg{peer: p, cfilter: msg})
+			},
+			OnBlock: func(p *peer.Peer, msg *protos.MsgBlock, _ []byte) {
+				m.queueMsg(&blockMsg{peer: p, block: msg})
+			},
+			OnVBlock: func(p *peer.Peer, msg *protos.MsgVirtualBlock) {
+				m.queueMsg(&vblockMsg{peer: p, vblock: msg})
+			},
+			OnInv: func(p *peer.Peer, msg *protos.MsgInv) {
+				m.queueMsg(&invMsg{peer: p, inv: msg})
+			},
+			OnNotFound: func(p *peer.Peer, msg *protos.MsgNotFound) {
+				m.queueMsg(&notFoundMsg{peer: p, notFound: msg})
+			},
+		},
+		NewestBlock: func() (*common.Hash, int32, uint64, error) {
+			tip, hash := m.chain.Tip()
+			return &hash, tip.Height, 0, nil
+		},
+		HostToNetAddress:  m.cfg.HostToNetAddress,
+		Proxy:             m.cfg.Proxy,
+		UserAgentName:     m.cfg.UserAgentName,
+		UserAgentVersion:  m.cfg.UserAgentVersion,
+		UserAgentComments: m.cfg.UserAgentComments,
+		ChainParams:       m.cfg.ChainParams,
+		Services:          0,
+		DisableRelayTx:    true,
+		ProtocolVersion:   peer.MaxProtocolVersion,
+	}
+}
+
+// queueMsg queues a message to the sync handler, unless it is shutting down.
+func (m *Manager) queueMsg(msg interface{}) {
+	select {
+	case m.msgChan <- msg:
+	case <-m.quit:
+	}
+}
+
+// outboundPeerConnected is invoked by the connection manager when a new
+// outbound connection is established.
+func (m *Manager) outboundPeerConnected(c *connmgr.ConnReq, conn net.Conn) {
+	p, err := peer.NewOutboundPeer(m.newPeerConfig(), c.Addr.String())
+	if err != nil {
+		log.Debugf("Cannot create outbound peer %s: %v", c.Addr, err)
+		m.connManager.Disconnect(c.ID())
+		return
+	}
+	p.AssociateConnection(conn)
+
+	go func() {
+		p.WaitForDisconnect()
+		m.queueMsg(&peerDoneMsg{peer: p})
+
+		// Reconnect the persistent peer.
+		m.connManager.Disconnect(c.ID())
+	}()
+}
+
+// syncHandler is the main handler of the light client.  It must be run as a
+// goroutine.  The requests to the sync peer and their answers are handled in
+// a single goroutine, so the sync state needs no lock.
+func (m *Manager) syncHandler() {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(stallTickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case msg := <-m.msgChan:
+			switch msg := msg.(type) {
+			case *peerReadyMsg:
+				m.handlePeerReady(msg.peer)
+			case *peerDoneMsg:
+				m.handlePeerDone(msg.peer)
+			case *headersMsg:
+				m.handleHeaders(msg.peer, msg.headers)
+			case *cfilterMsg:
+				m.handleCFilter(msg.peer, msg.cfilter)
+			case *blockMsg:
+				m.handleBlock(msg.peer, msg.block)
+			case *vblockMsg:
+				m.handleVBlock(msg.peer, msg.vblock)
+			case *invMsg:
+				m.handleInv(msg.peer, msg.inv)
+			case *notFoundMsg:
+				m.handleNotFound(msg.peer, msg.notFound)
+			}
+
+		case <-ticker.C:
+			m.checkStall()
+
+		case <-m.quit:
+			return
+		}
+	}
+}
+
+// isSyncCandidate returns whether the peer serves the headers, the blocks and
+// the committed filters the light client needs.
+func isSyncCandidate(p *peer.Peer) bool {
+	services := p.Services()
+	return services&common.SFNodeNetwork == common.SFNodeNetwork &&
+		services&common.SFNodeCF == common.SFNodeCF &&
+		services&common.SFNodeContractCF == common.SFNodeContractCF
+}
+
+// handlePeerReady registers a peer which completed the version negotiation,
+// and starts syncing from it when there is no sync peer.
+func (m *Manager) handlePeerReady(p *peer.Peer) {
+	log.Infof("New peer %v (services %v)", p, p.Services())
+	m.peersMtx.Lock()
+	m.peers[p] = struct{}{}
+	m.peersMtx.Unlock()
+
+	if m.syncPeer == nil {
+		m.startSync()
+	}
+}
+
+// handlePeerDone unregisters a disconnected peer, and switches to another
+// sync peer when it was the sync peer.
+func (m *Manager) handlePeerDone(p *peer.Peer) {
+	m.peersMtx.Lock()
+	delete(m.peers, p)
+	m.peersMtx.Unlock()
+
+	if p != m.syncPeer {
+		return
+	}
+	log.Infof("Lost sync peer %v", p)
+	m.syncPeer = nil
+	m.headersPending = false
+	m.filterQueue = nil
+	atomic.StoreInt32(&m.current, 0)
+	m.startSync()
+}
+
+// startSync selects a sync peer among the connected peers and requests the
+// headers from it.
+func (m *Manager) startSync() {
+	m.peersMtx.RLock()
+	for p := range m.peers {
+		if isSyncCandidate(p) {
+			m.syncPeer = p
+			break
+		}
+	}
+	m.peersMtx.RUnlock()
+
+	if m.syncPeer == nil {
+		log.Warnf("No sync peer candidate serving the committed filters")
+		return
+	}
+	log.Infof("Syncing from %v", m.syncPeer)
+	m.requestHeaders()
+}
+
+// requestHeaders requests the headers following the best header from the
+// sync peer.
+func (m *Manager) requestHeaders() {
+	locator, err := m.chain.BlockLocator()
+	if err != nil {
+		log.Errorf("Failed to build the block locator: %v", err)
+		return
+	}
+	msg := protos.NewMsgGetHeaders()
+	for _, hash := range locator {
+		msg.AddBlockLocatorHash(hash)
+	}
+	m.syncPeer.QueueMessage(msg, nil)
+	m.headersPending = true
+	m.lastProgress = time.Now()
+}
+
+// handleHeaders connects the headers sent by the sync peer, and scans the
+// filters once the best header is reached.
+func (m *Manager) handleHeaders(p *peer.Peer, msg *protos.MsgHeaders) {
+	if p != m.syncPeer || !m.headersPending {
+		return
+	}
+	m.headersPending = false
+	m.lastProgress = time.Now()
+
+	forkHeight, err := m.chain.ConnectHeaders(msg.Headers)
+	if err != nil {
+		log.Warnf("Rejected headers from %v: %v", p, err)
+		p.Disconnect()
+		return
+	}
+
+	// Disconnect the blocks the wallet scanned on the replaced headers.
+	if _, walletHeight := m.wallet.SyncedTo(); forkHeight < walletHeight {
+		m.filterQueue = nil
+		header, err := m.chain.HeaderByHeight(forkHeight)
+		if err != nil {
+			log.Errorf("Failed to fetch the header at height %d: %v",
+				forkHeight, err)
+			return
+		}
+		hash := header.BlockHash()
+		if err := m.wallet.Rollback(&hash, forkHeight); err != nil {
+			log.Errorf("Failed to roll back the wallet: %v", err)
+			return
+		}
+	}
+
+	if len(msg.Headers) == protos.MaxBlockHeadersPerMsg {
+		m.requestHeaders()
+		return
+	}
+	m.syncFilters()
+}
+
+// syncFilters requests the next batch of filters, unless a batch is being
+// scanned or the wallet scanned the blocks up to the best header.
+func (m *Manager) syncFilters() {
+	if len(m.filterQueue) > 0 || m.headersPending {
+		return
+	}
+
+	walletHash, walletHeight := m.wallet.SyncedTo()
+	tip, _ := m.chain.Tip()
+
+	// The wallet might have scanned blocks which are no longer in the
+	// main chain when the node stopped during a reorganization.
+	if walletHeight > 0 {
+		hashes, err := m.chain.HashesByHeightRange(walletHeight, walletHeight)
+		if err != nil || hashes[0] != walletHash {
+			height := walletHeight - spentKeepDepth
+			if height < 0 {
+				height = 0
+			}
+			header, err := m.chain.HeaderByHeight(height)
+			if err != nil {
+				log.Errorf("Failed to fetch the header at height %d: %v",
+					height, err)
+				return
+			}
+			hash := header.BlockHash()
+			if err := m.wallet.Rollback(&hash, height); err != nil {
+				log.Errorf("Failed to roll back the wallet: %v", err)
+				return
+			}
+			walletHeight = height
+		}
+	}
+
+	if walletHeight >= tip.Height {
+		if atomic.SwapInt32(&m.current, 1) == 0 {
+			log.Infof("Wallet synced to height %d", walletHeight)
+		}
+		return
+	}
+	atomic.StoreInt32(&m.current, 0)
+
+	start := walletHeight + 1
+	stop := start + protos.MaxGetCFiltersReqRange - 1
+	if stop > tip.Height {
+		stop = tip.Height
+	}
+	hashes, err := m.chain.HashesByHeightRange(start, stop)
+	if err != nil {
+		log.Errorf("Failed to fetch the headers from height %d to %d: %v",
+			start, stop, err)
+		return
+	}
+	for i := range hashes {
+		m.filterQueue = append(m.filterQueue, &pendingFilter{
+			height: start + int32(i),
+			hash:   hashes[i],
+		})
+	}
+	log.Debugf("Requesting the filters from height %d to %d", start, stop)
+	m.syncPeer.QueueMessage(protos.NewMsgGetCFilters(protos.GCSFilterContract,
+		uint32(start), &hashes[len(hashes)-1]), nil)
+	m.lastProgress = time.Now()
+}
+
+// findPending returns the pending filter of the passed block.
+func (m *Manager) findPending(hash *common.Hash) *pendingFilter {
+	for _, pending := range m.filterQueue {
+		if pending.hash == *hash {
+			return pending
+		}
+	}
+	return nil
+}
+
+// handleCFilter matches the filter sent by the sync peer against the wallet
+// addresses.
+func (m *Manager) handleCFilter(p *peer.Peer, msg *protos.MsgCFilter) {
+	if p != m.syncPeer || msg.FilterType != protos.GCSFilterContract {
+		return
+	}
+	pending := m.findPending(&msg.BlockHash)
+	if pending == nil || pending.received {
+		return
+	}
+	m.lastProgress = time.Now()
+
+	filter, err := gcs.FromNBytes(builder.DefaultP, builder.DefaultM, msg.Data)
+	if err != nil {
+		log.Warnf("Invalid filter of block %v from %v: %v",
+			msg.BlockHash, p, err)
+		p.Disconnect()
+		return
+	}
+	pending.received = true
+	if filter.N() > 0 && len(m.wallet.WatchList()) > 0 {
+		key := builder.DeriveKey(&msg.BlockHash)
+		pending.matched, err = filter.MatchAny(key, m.wallet.WatchList())
+		if err != nil {
+			log.Warnf("Invalid filter of block %v from %v: %v",
+				msg.BlockHash, p, err)
+			p.Disconnect()
+			return
+		}
+	}
+	m.processQueue()
+}
+
+// handleBlock records a matched block sent by the sync peer, once its
+// transactions are checked against the merkle root of its header.
+func (m *Manager) handleBlock(p *peer.Peer, msg *protos.MsgBlock) {
+	hash := msg.Header.BlockHash()
+	pending := m.findPending(&hash)
+	if p != m.syncPeer || pending == nil || !pending.requested ||
+		pending.block != nil {
+		return
+	}
+	m.lastProgress = time.Now()
+
+	merkles := blockchain.BuildMerkleTreeStore(asiutil.NewBlock(msg).Transactions())
+	if *merkles[len(merkles)-1] != msg.Header.MerkleRoot {
+		log.Warnf("Block %v from %v does not match its merkle root",
+			hash, p)
+		p.Disconnect()
+		return
+	}
+	pending.block = msg
+	m.processQueue()
+}
+
+// handleVBlock records the virtual block of a matched block.  The virtual
+// block is not committed by the header, so its transactions are taken from
+// the sync peer as they are.
+func (m *Manager) handleVBlock(p *peer.Peer, msg *protos.MsgVirtualBlock) {
+	pending := m.findPending(&msg.BlockHash)
+	if p != m.syncPeer || pending == nil || !pending.requested ||
+		pending.vblock != nil {
+		return
+	}
+	m.lastProgress = time.Now()
+	pending.vblock = &msg.VBlock
+	m.processQueue()
+}
+
+// handleNotFound handles the blocks the sync peer does not have.  A block
+// without virtual block has no virtual transaction.
+func (m *Manager) handleNotFound(p *peer.Peer, msg *protos.MsgNotFound) {
+	if p != m.syncPeer {
+		return
+	}
+	for _, iv := range msg.InvList {
+		pending := m.findPending(&iv.Hash)
+		if pending == nil || !pending.requested {
+			continue
+		}
+		switch iv.Type {
+		case protos.InvTypeBlock:
+			log.Warnf("Sync peer %v does not have the block %v",
+				p, iv.Hash)
+			p.Disconnect()
+			return
+		case protos.InvTypeVBlock:
+			if pending.vblock == nil {
+				pending.vblock = &protos.MsgVBlock{}
+			}
+		}
+	}
+	m.processQueue()
+}
+
+// processQueue scans the blocks of the filter batch in order, as long as their
+// filters and the matched blocks are received, and requests the next batch
+// once the batch is scanned.
+func (m *Manager) processQueue() {
+	var skipped *pendingFilter
+	for len(m.filterQueue) > 0 {
+		pending := m.filterQueue[0]
+		if !pending.received {
+			break
+		}
+		if pending.matched {
+			if !pending.requested {
+				getData := protos.NewMsgGetData()
+				getData.AddInvVect(protos.NewInvVect(protos.InvTypeBlock, &pending.hash))
+				getData.AddInvVect(protos.NewInvVect(protos.InvTypeVBlock, &pending.hash))
+				m.syncPeer.QueueMessage(getData, nil)
+				pending.requested = true
+				m.lastProgress = time.Now()
+			}
+			if pending.block == nil || pending.vblock == nil {
+				break
+			}
+			if !m.skipTo(skipped) {
+				return
+			}
+			skipped = nil
+			err := m.wallet.ConnectBlock(&pending.hash, pending.height,
+				pending.block, pending.vblock)
+			if err != nil {
+				log.Errorf("Failed to scan block %v: %v", pending.hash, err)
+				m.filterQueue = nil
+				return
+			}
+			log.Infof("Scanned block %v at height %d matching the "+
+				"wallet", pending.hash, pending.height)
+		} else {
+			skipped = pending
+		}
+		m.filterQueue = m.filterQueue[1:]
+	}
+	if !m.skipTo(skipped) {
+		return
+	}
+	if len(m.filterQueue) == 0 {
+		m.syncFilters()
+	}
+}
+
+// skipTo advances the wallet over the blocks whose filters did not match, up
+// to the passed block.
+func (m *Manager) skipTo(pending *pendingFilter) bool {
+	if pending == nil {
+		return true
+	}
+	if err := m.wallet.SkipTo(&pending.hash, pending.height); err != nil {
+		log.Errorf("Failed to advance the wallet: %v", err)
+		m.filterQueue = nil
+		return false
+	}
+	return true
+}
+
+// handleInv requests the headers of the blocks announced by the peers.
+func (m *Manager) handleInv(p *peer.Peer, msg *protos.MsgInv) {
+	if m.syncPeer == nil || m.headersPending {
+		return
+	}
+	for _, iv := range msg.InvList {
+		if iv.Type != protos.InvTypeBlock {
+			continue
+		}
+		if _, ok := m.chain.HeightByHash(&iv.Hash); ok {
+			continue
+		}
+		m.requestHeaders()
+		return
+	}
+}
+
+// checkStall disconnects the sync peer when it did not answer a request in
+// time.
+func (m *Manager) checkStall() {
+	if m.syncPeer == nil {
+		m.startSync()
+		return
+	}
+	if !m.headersPending && len(m.filterQueue) == 0 {
+		return
+	}
+	if time.Since(m.lastProgress) > stallTimeout {
+		log.Warnf("Sync peer %v stalled, disconnecting", m.syncPeer)
+		m.syncPeer.Disconnect()
+	}
+}
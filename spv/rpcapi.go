@@ -0,0 +1,214 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package spv
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/common/hexutil"
+	"github.com/AsimovNetwork/asimov/protos"
+	"github.com/AsimovNetwork/asimov/rpcs/rpc"
+	"github.com/AsimovNetwork/asimov/rpcs/rpcjson"
+)
+
+// RpcService serves the subset of the RPC a light client is able to answer.
+type RpcService struct {
+	manager *Manager
+}
+
+// NewRpcService returns the RPC service of the passed light client.
+func NewRpcService(manager *Manager) *RpcService {
+	return &RpcService{manager: manager}
+}
+
+// APIs returns the RPC services the light client offers.
+func (s *RpcService) APIs() []rpc.API {
+	return []rpc.API{
+		{
+			Namespace: "asimov",
+			Version:   "1.0",
+			Service:   &PublicRpcAPI{manager: s.manager},
+			Public:    true,
+		},
+	}
+}
+
+func (s *RpcService) Start() error {
+	return nil
+}
+
+func (s *RpcService) Stop() error {
+	return nil
+}
+
+// PublicRpcAPI is the "asimov" namespace of a light client.  The methods
+// have the names and the results of the full node ones.
+type PublicRpcAPI struct {
+	manager *Manager
+}
+
+// GetBlockChainInfo returns the best header.
+func (s *PublicRpcAPI) GetBlockChainInfo() (interface{}, error) {
+	tip, hash := s.manager.Tip()
+	chainInfo := &rpcjson.GetBlockChainInfoResult{
+		Chain:         s.manager.cfg.ChainParams.Name(),
+		Blocks:        tip.Height,
+		BestBlockHash: hash.UnprefixString(),
+		MedianTime:    tip.Timestamp,
+		Round:         int32(tip.Round),
+		Slot:          int16(tip.SlotIndex),
+	}
+	return chainInfo, nil
+}
+
+// GetBestBlock returns the last block scanned by the wallet, which the
+// balances and the unspent outputs are as of.
+func (s *PublicRpcAPI) GetBestBlock() (*rpcjson.GetBestBlockResult, error) {
+	hash, height := s.manager.WalletSyncedTo()
+	result := &rpcjson.GetBestBlockResult{
+		Hash:   hash.UnprefixString(),
+		Height: height,
+	}
+	return result, nil
+}
+
+// watchedAddress decodes the passed address, which must be watched by the
+// wallet.
+func (s *PublicRpcAPI) watchedAddress(address string) (*common.Address, error) {
+	b, err := hexutil.Decode(address)
+	if err != nil {
+		return nil, rpcjson.NewRPCError(rpcjson.ErrRPCInvalidAddressOrKey,
+			"Failed to decode address: "+err.Error())
+	}
+	addr, err := common.NewAddress(b)
+	if err != nil {
+		return nil, rpcjson.NewRPCError(rpcjson.ErrRPCInvalidAddressOrKey,
+			"Failed to create ADDRESS object: "+err.Error())
+	}
+	if !s.manager.IsWatched(addr) {
+		return nil, rpcjson.NewRPCError(rpcjson.ErrRPCInvalidAddressOrKey,
+			fmt.Sprintf("Address %s is not watched by the light client", address))
+	}
+	return addr, nil
+}
+
+// GetBalance returns the balance of a watched address.
+// The result contains a list of {asset, value} which the address owns.
+func (s *PublicRpcAPI) GetBalance(address string) ([]rpcjson.GetBalanceResult, error) {
+	addr, err := s.watchedAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	utxos, err := s.manager.Utxos(addr)
+	if err != nil {
+		return nil, rpcjson.NewRPCError(rpcjson.ErrRPCInternal.Code, err.Error())
+	}
+
+	var assets []string
+	assetsMap := make(map[string]int64)
+	balance := make([]rpcjson.GetBalanceResult, 0)
+	for _, utxo := range utxos {
+		e := utxo.Entry
+		asset := hex.EncodeToString(e.Asset().Bytes())
+		if e.Asset().IsIndivisible() {
+			balance = append(balance, rpcjson.GetBalanceResult{
+				Asset: asset,
+				Value: strconv.FormatInt(e.Amount(), 10),
+			})
+			continue
+		}
+		if _, ok := assetsMap[asset]; !ok {
+			assets = append(assets, asset)
+		}
+		assetsMap[asset] += e.Amount()
+	}
+	for _, asset := range assets {
+		balance = append(balance, rpcjson.GetBalanceResult{
+			Asset: asset,
+			Value: strconv.FormatInt(assetsMap[asset], 10),
+		})
+	}
+	return balance, nil
+}
+
+// GetUtxoByAddress returns the unspent outputs of a given asset for the
+// watched addresses in the array.  If asset is not specified, all assets are
+// returned.
+func (s *PublicRpcAPI) GetUtxoByAddress(addresses []string, asset string) (interface{}, error) {
+	var a protos.Asset
+	if asset != "" {
+		aa, err := hex.DecodeString(asset)
+		if err != nil {
+			return nil, rpcjson.NewRPCError(rpcjson.ErrRPCInvalidParameter,
+				"Failed to decode asset: "+err.Error())
+		}
+		a = *protos.AssetFromBytes(aa)
+	}
+
+	_, height := s.manager.WalletSyncedTo()
+	maturity := s.manager.cfg.ChainParams.CoinbaseMaturity
+	result := make([]*rpcjson.ListUnspentResult, 0)
+	for _, address := range addresses {
+		addr, err := s.watchedAddress(address)
+		if err != nil {
+			return nil, err
+		}
+		utxos, err := s.manager.Utxos(addr)
+		if err != nil {
+			return nil, rpcjson.NewRPCError(rpcjson.ErrRPCInternal.Code, err.Error())
+		}
+		for _, utxo := range utxos {
+			e := utxo.Entry
+			if asset != "" && !e.Asset().Equal(&a) {
+				continue
+			}
+			confirmations := height - e.BlockHeight()
+			result = append(result, &rpcjson.ListUnspentResult{
+				TxID:          utxo.OutPoint.Hash.UnprefixString(),
+				Vout:          utxo.OutPoint.Index,
+				Assets:        hex.EncodeToString(e.Asset().Bytes()),
+				ScriptPubKey:  hex.EncodeToString(e.PkScript()),
+				Amount:        e.Amount(),
+				Address:       utxo.Address.String(),
+				Confirmations: int64(confirmations),
+				Height:        e.BlockHeight(),
+				Spendable:     !e.IsCoinBase() || confirmations >= maturity,
+			})
+		}
+	}
+	return result, nil
+}
+
+// SendRawTransaction sends a signed transaction to the peers.  The light
+// client has no mempool, the transaction is checked by the full nodes.
+func (s *PublicRpcAPI) SendRawTransaction(hexTx string) (interface{}, error) {
+	hexStr := hexTx
+	if len(hexStr)%2 != 0 {
+		hexStr = "0" + hexStr
+	}
+	serializedTx, err := hex.DecodeString(hexStr)
+	if err != nil {
+		return nil, rpcjson.NewRPCError(rpcjson.ErrRPCDecodeHexString,
+			fmt.Sprintf("Argument must be hexadecimal string (not %q)", hexStr))
+	}
+
+	var msgTx protos.MsgTx
+	if err := msgTx.Deserialize(bytes.NewReader(serializedTx)); err != nil {
+		return nil, &rpcjson.RPCError{
+			Code:    rpcjson.ErrRPCDeserialization,
+			Message: "TX decode failed: " + err.Error(),
+		}
+	}
+	if err := s.manager.SendTx(&msgTx); err != nil {
+		return nil, rpcjson.NewRPCError(rpcjson.ErrRPCMisc,
+			"Failed to send transaction: "+err.Error())
+	}
+	hash := msgTx.TxHash()
+	return hash.String(), nil
+}
@@ -0,0 +1,451 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package spv
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/AsimovNetwork/asimov/blockchain"
+	"github.com/AsimovNetwork/asimov/blockchain/txo"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/database"
+	"github.com/AsimovNetwork/asimov/protos"
+	"github.com/AsimovNetwork/asimov/txscript"
+)
+
+const (
+	// spentKeepDepth is the number of blocks the spent outputs are kept
+	// for, so the blocks they were spent in can be disconnected when the
+	// header chain reorganizes.
+	spentKeepDepth = 100
+)
+
+var (
+	// walletUtxoBucketName is the name of the bucket which holds the
+	// unspent outputs of the wallet addresses, keyed by outpoint.
+	walletUtxoBucketName = []byte("spvutxoidx")
+
+	// walletSpentBucketName is the name of the bucket which holds the
+	// outputs of the wallet addresses spent in recent blocks, keyed by the
+	// height of the spending block followed by the outpoint.
+	walletSpentBucketName = []byte("spvspentidx")
+
+	// walletStateKeyName is the name of the key holding the hash and the
+	// height of the last block scanned by the wallet, followed by the hash
+	// of the watched addresses.
+	walletStateKeyName = []byte("spvwalletstate")
+)
+
+// walletOutpointKey returns the key of the passed outpoint in the utxo bucket.
+func walletOutpointKey(outpoint *protos.OutPoint) []byte {
+	key := make([]byte, common.HashLength+4)
+	copy(key, outpoint.Hash[:])
+	binary.BigEndian.PutUint32(key[common.HashLength:], outpoint.Index)
+	return key
+}
+
+// walletOutpointFromKey returns the outpoint of the passed utxo bucket key.
+func walletOutpointFromKey(key []byte) protos.OutPoint {
+	var outpoint protos.OutPoint
+	copy(outpoint.Hash[:], key)
+	outpoint.Index = binary.BigEndian.Uint32(key[common.HashLength:])
+	return outpoint
+}
+
+// Utxo is an unspent output of a wallet address.
+type Utxo struct {
+	OutPoint protos.OutPoint
+	Address  common.Address
+	Entry    *txo.UtxoEntry
+}
+
+// wallet tracks the unspent outputs of a set of addresses, from the blocks
+// whose filters matched them.
+type wallet struct {
+	db database.Transactor
+
+	// addresses are the watched addresses.
+	addresses map[common.Address]struct{}
+
+	// watchList holds the filter entries of the watched addresses.
+	watchList [][]byte
+
+	genesisHash common.Hash
+
+	mtx          sync.RWMutex
+	syncedHash   common.Hash
+	syncedHeight int32
+}
+
+// addressSetHash returns the hash identifying a set of addresses, which does
+// not depend on their order.
+func addressSetHash(addresses []*common.Address) common.Hash {
+	sorted := make([][]byte, 0, len(addresses))
+	for _, addr := range addresses {
+		sorted = append(sorted, addr.ScriptAddress())
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i], sorted[j]) < 0
+	})
+	return common.Hash(sha256.Sum256(bytes.Join(sorted, nil)))
+}
+
+// newWallet loads the wallet from the database.  The wallet scans the chain
+// from the genesis again when the watched addresses changed since it was
+// last run.
+func newWallet(db database.Transactor, addresses []*common.Address,
+	genesisHash *common.Hash) (*wallet, error) {
+
+	w := &wallet{
+		db:          db,
+		addresses:   make(map[common.Address]struct{}, len(addresses)),
+		genesisHash: *genesisHash,
+	}
+	for _, addr := range addresses {
+		if _, ok := w.addresses[*addr]; ok {
+			continue
+		}
+		w.addresses[*addr] = struct{}{}
+		pkScript, err := txscript.PayToAddrScript(addr)
+		if err != nil {
+			return nil, err
+		}
+		w.watchList = append(w.watchList, pkScript, addr.ScriptAddress())
+	}
+
+	setHash := addressSetHash(addresses)
+	err := db.Update(func(dbTx database.Tx) error {
+		meta := dbTx.Metadata()
+		state := meta.Get(walletStateKeyName)
+		if len(state) == 2*common.HashLength+4 &&
+			bytes.Equal(state[common.HashLength+4:], setHash[:]) {
+
+			copy(w.syncedHash[:], state)
+			w.syncedHeight = int32(binary.BigEndian.Uint32(state[common.HashLength:]))
+			return nil
+		}
+
+		if state != nil {
+			log.Infof("Watched addresses changed, rescanning the chain")
+		}
+		for _, name := range [][]byte{walletUtxoBucketName, walletSpentBucketName} {
+			if meta.Bucket(name) != nil {
+				if err := meta.DeleteBucket(name); err != nil {
+					return err
+				}
+			}
+			if _, err := meta.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+		w.syncedHash, w.syncedHeight = *genesisHash, 0
+		return dbPutWalletState(dbTx, &w.syncedHash, 0, &setHash)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// dbPutWalletState stores the last block scanned by the wallet.
+func dbPutWalletState(dbTx database.Tx, hash *common.Hash, height int32,
+	setHash *common.Hash) error {
+
+	state := make([]byte, 2*common.HashLength+4)
+	copy(state, hash[:])
+	binary.BigEndian.PutUint32(state[common.HashLength:], uint32(height))
+	copy(state[common.HashLength+4:], setHash[:])
+	return dbTx.Metadata().Put(walletStateKeyName, state)
+}
+
+// dbUpdateWalletState updates the last block scanned by the wallet, keeping
+// the hash of the watched addresses.
+func dbUpdateWalletState(dbTx database.Tx, hash *common.Hash, height int32) error {
+	state := common.CopyBytes(dbTx.Metadata().Get(walletStateKeyName))
+	copy(state, hash[:])
+	binary.BigEndian.PutUint32(state[common.HashLength:], uint32(height))
+	return dbTx.Metadata().Put(walletStateKeyName, state)
+}
+
+// WatchList returns the filter entries matching the transactions of the
+// watched addresses: their payment scripts and their raw addresses.
+func (w *wallet) WatchList() [][]byte {
+	return w.watchList
+}
+
+// SyncedTo returns the hash and the height of the last block scanned.
+//
+// This function is safe for concurrent access.
+func (w *wallet) SyncedTo() (common.Hash, int32) {
+	w.mtx.RLock()
+	defer w.mtx.RUnlock()
+	return w.syncedHash, w.syncedHeight
+}
+
+// owner returns the watched address the passed script pays to, if any.
+func (w *wallet) owner(pkScript []byte) (*common.Address, bool) {
+	_, addrs, _, err := txscript.ExtractPkScriptAddrs(pkScript)
+	if err != nil || len(addrs) == 0 {
+		return nil, false
+	}
+	addr := common.Address(addrs[0].StandardAddress())
+	_, ok := w.addresses[addr]
+	return &addr, ok
+}
+
+// connectTx spends the wallet outputs the passed transaction spends, and adds
+// the outputs it pays to the wallet addresses.
+func (w *wallet) connectTx(utxos, spent database.Bucket, tx *protos.MsgTx,
+	height int32, coinbase bool) error {
+
+	if !coinbase {
+		for _, txIn := range tx.TxIn {
+			key := walletOutpointKey(&txIn.PreviousOutPoint)
+			serialized := utxos.Get(key)
+			if serialized == nil {
+				continue
+			}
+			spentKey := append(heightKey(height), key...)
+			if err := spent.Put(spentKey, common.CopyBytes(serialized)); err != nil {
+				return err
+			}
+			if err := utxos.Delete(key); err != nil {
+				return err
+			}
+		}
+	}
+
+	txHash := tx.TxHash()
+	for i, txOut := range tx.TxOut {
+		if _, ok := w.owner(txOut.PkScript); !ok {
+			continue
+		}
+		asset := txOut.Asset
+		entry := txo.NewUtxoEntry(txOut.Value, txOut.PkScript, height,
+			coinbase, &asset, nil)
+		serialized, err := blockchain.SerializeUtxoEntry(entry)
+		if err != nil {
+			return err
+		}
+		outpoint := protos.NewOutPoint(&txHash, uint32(i))
+		if err := utxos.Put(walletOutpointKey(outpoint), serialized); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ConnectBlock scans the block at the passed height, which follows the last
+// block scanned.  The block and its virtual block are nil when the block
+// filter did not match the watched addresses, which only advances the wallet.
+//
+// This function is safe for concurrent access.
+func (w *wallet) ConnectBlock(hash *common.Hash, height int32,
+	block *protos.MsgBlock, vblock *protos.MsgVBlock) error {
+
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	if height != w.syncedHeight+1 {
+		return fmt.Errorf("block %v at height %d does not follow the "+
+			"wallet height %d", hash, height, w.syncedHeight)
+	}
+	err := w.db.Update(func(dbTx database.Tx) error {
+		meta := dbTx.Metadata()
+		utxos := meta.Bucket(walletUtxoBucketName)
+		spent := meta.Bucket(walletSpentBucketName)
+		if block != nil {
+			for _, tx := range block.Transactions {
+				err := w.connectTx(utxos, spent, tx, height,
+					blockchain.IsCoinBaseTx(tx))
+				if err != nil {
+					return err
+				}
+			}
+		}
+		if vblock != nil {
+			for _, vtx := range vblock.VTransactions {
+				if err := w.connectTx(utxos, spent, vtx, height, false); err != nil {
+					return err
+				}
+			}
+		}
+
+		// Forget the outputs spent deep enough for their blocks to no
+		// longer be disconnected.
+		if height > spentKeepDepth && height%spentKeepDepth == 0 {
+			var keys [][]byte
+			limit := heightKey(height - spentKeepDepth)
+			cursor := spent.Cursor()
+			for ok := cursor.First(); ok; ok = cursor.Next() {
+				if bytes.Compare(cursor.Key()[:4], limit) >= 0 {
+					break
+				}
+				keys = append(keys, common.CopyBytes(cursor.Key()))
+			}
+			for _, key := range keys {
+				if err := spent.Delete(key); err != nil {
+					return err
+				}
+			}
+		}
+		return dbUpdateWalletState(dbTx, hash, height)
+	})
+	if err != nil {
+		return err
+	}
+	w.syncedHash, w.syncedHeight = *hash, height
+	return nil
+}
+
+// SkipTo advances the wallet to the block at the passed height, when the
+// filters of the blocks up to it did not match the watched addresses.
+//
+// This function is safe for concurrent access.
+func (w *wallet) SkipTo(hash *common.Hash, height int32) error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	if height <= w.syncedHeight {
+		return fmt.Errorf("block %v at height %d does not follow the "+
+			"wallet height %d", hash, height, w.syncedHeight)
+	}
+	err := w.db.Update(func(dbTx database.Tx) error {
+		return dbUpdateWalletState(dbTx, hash, height)
+	})
+	if err != nil {
+		return err
+	}
+	w.syncedHash, w.syncedHeight = *hash, height
+	return nil
+}
+
+// Rollback disconnects the blocks scanned after the passed height, whose
+// hash is the passed one, when the header chain reorganized.  The wallet
+// scans the chain from the genesis again when more blocks than the spent
+// outputs are kept for are disconnected.
+//
+// This function is safe for concurrent access.
+func (w *wallet) Rollback(hash *common.Hash, height int32) error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	if height >= w.syncedHeight {
+		return nil
+	}
+	rescan := w.syncedHeight-height > spentKeepDepth
+	if rescan {
+		log.Warnf("Unable to disconnect %d blocks, only the last %d "+
+			"blocks are kept, rescanning the chain",
+			w.syncedHeight-height, spentKeepDepth)
+		hash, height = &w.genesisHash, 0
+	}
+	err := w.db.Update(func(dbTx database.Tx) error {
+		meta := dbTx.Metadata()
+		if rescan {
+			for _, name := range [][]byte{walletUtxoBucketName, walletSpentBucketName} {
+				if err := meta.DeleteBucket(name); err != nil {
+					return err
+				}
+				if _, err := meta.CreateBucket(name); err != nil {
+					return err
+				}
+			}
+			return dbUpdateWalletState(dbTx, hash, height)
+		}
+
+		utxos := meta.Bucket(walletUtxoBucketName)
+		spent := meta.Bucket(walletSpentBucketName)
+
+		// Restore the outputs spent by the disconnected blocks first, so
+		// the outputs created and spent in them are removed below.
+		var spentKeys [][]byte
+		cursor := spent.Cursor()
+		for ok := cursor.Seek(heightKey(height + 1)); ok; ok = cursor.Next() {
+			key := common.CopyBytes(cursor.Key())
+			if err := utxos.Put(key[4:], common.CopyBytes(cursor.Value())); err != nil {
+				return err
+			}
+			spentKeys = append(spentKeys, key)
+		}
+		for _, key := range spentKeys {
+			if err := spent.Delete(key); err != nil {
+				return err
+			}
+		}
+
+		var utxoKeys [][]byte
+		cursor = utxos.Cursor()
+		for ok := cursor.First(); ok; ok = cursor.Next() {
+			entry, err := blockchain.DeserializeUtxoEntry(cursor.Value())
+			if err != nil {
+				return err
+			}
+			if entry.BlockHeight() > height {
+				utxoKeys = append(utxoKeys, common.CopyBytes(cursor.Key()))
+			}
+		}
+		for _, key := range utxoKeys {
+			if err := utxos.Delete(key); err != nil {
+				return err
+			}
+		}
+		return dbUpdateWalletState(dbTx, hash, height)
+	})
+	if err != nil {
+		return err
+	}
+	log.Infof("Wallet rolled back from height %d to %d", w.syncedHeight, height)
+	w.syncedHash, w.syncedHeight = *hash, height
+	return nil
+}
+
+// Utxos returns the unspent outputs of the passed address, or of all the
+// wallet addresses when it is nil.  They are ordered by height.
+//
+// This function is safe for concurrent access.
+func (w *wallet) Utxos(address *common.Address) ([]*Utxo, error) {
+	w.mtx.RLock()
+	defer w.mtx.RUnlock()
+
+	var result []*Utxo
+	err := w.db.View(func(dbTx database.Tx) error {
+		cursor := dbTx.Metadata().Bucket(walletUtxoBucketName).Cursor()
+		for ok := cursor.First(); ok; ok = cursor.Next() {
+			entry, err := blockchain.DeserializeUtxoEntry(cursor.Value())
+			if err != nil {
+				return err
+			}
+			owner, _ := w.owner(entry.PkScript())
+			if owner == nil || address != nil && *owner != *address {
+				continue
+			}
+			result = append(result, &Utxo{
+				OutPoint: walletOutpointFromKey(cursor.Key()),
+				Address:  *owner,
+				Entry:    entry,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Entry.BlockHeight() < result[j].Entry.BlockHeight()
+	})
+	return result, nil
+}
+
+// IsWatched returns whether the passed address is watched by the wallet.
+func (w *wallet) IsWatched(address *common.Address) bool {
+	_, ok := w.addresses[*address]
+	return ok
+}
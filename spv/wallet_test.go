@@ -0,0 +1,150 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package spv
+
+import (
+	"math"
+	"testing"
+
+	"github.com/AsimovNetwork/asimov/asiutil/gcs/builder"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/crypto"
+	"github.com/AsimovNetwork/asimov/protos"
+	"github.com/AsimovNetwork/asimov/txscript"
+)
+
+// walletBalance returns the amount of the unspent outputs of the wallet.
+func walletBalance(t *testing.T, w *wallet) (int64, int) {
+	utxos, err := w.Utxos(nil)
+	if err != nil {
+		t.Fatalf("Utxos err %v", err)
+	}
+	var amount int64
+	for _, utxo := range utxos {
+		amount += utxo.Entry.Amount()
+	}
+	return amount, len(utxos)
+}
+
+func TestWallet(t *testing.T) {
+	acc, _ := crypto.NewAccount("0xd0f0461b7b4d26cf370e6c73b58ef7fa26e8e30853a8cee901ed42cf0879cb6e")
+	other, _ := crypto.NewAccount("0x224828e95689e30a8e668418968260f7a01db9e21e6dc74b0de4ba4bd4d5fd6d")
+	pkScript, _ := txscript.PayToAddrScript(acc.Address)
+	otherScript, _ := txscript.PayToAddrScript(other.Address)
+
+	db, params, teardown := newTestDB(t)
+	defer teardown()
+
+	w, err := newWallet(db, []*common.Address{acc.Address}, params.GenesisHash)
+	if err != nil {
+		t.Fatalf("newWallet err %v", err)
+	}
+	if hash, height := w.SyncedTo(); hash != *params.GenesisHash || height != 0 {
+		t.Fatalf("new wallet synced to %v at height %d, want the genesis", hash, height)
+	}
+
+	// Block 1 pays 100 to the wallet in its coinbase, and 50 to another
+	// address.
+	coinbase := protos.NewMsgTx(protos.TxVersion)
+	coinbase.AddTxIn(protos.NewTxIn(protos.NewOutPoint(&common.Hash{}, math.MaxUint32), nil))
+	coinbase.AddTxOut(protos.NewTxOut(100, pkScript, protos.Asset{}))
+	coinbase.AddTxOut(protos.NewTxOut(50, otherScript, protos.Asset{}))
+	block1 := &protos.MsgBlock{Transactions: []*protos.MsgTx{coinbase}}
+
+	// The filter of the block matches the wallet.
+	hash1 := block1.BlockHash()
+	filter, err := builder.BuildContractFilter(block1, nil, &protos.MsgVBlock{}, nil)
+	if err != nil {
+		t.Fatalf("BuildContractFilter err %v", err)
+	}
+	if matched, err := filter.MatchAny(builder.DeriveKey(&hash1), w.WatchList()); err != nil || !matched {
+		t.Fatalf("filter of a block paying to the wallet does not match")
+	}
+
+	if err := w.ConnectBlock(&hash1, 1, block1, nil); err != nil {
+		t.Fatalf("ConnectBlock err %v", err)
+	}
+	if amount, n := walletBalance(t, w); amount != 100 || n != 1 {
+		t.Fatalf("got %d in %d utxos after block 1, want 100 in 1 utxo", amount, n)
+	}
+
+	// Block 3 spends the coinbase output, paying 30 to another address
+	// and 70 back to the wallet, and a virtual transaction pays 5 to the
+	// wallet.
+	hash2, hash3 := common.Hash{0x02}, common.Hash{0x03}
+	if err := w.SkipTo(&hash2, 2); err != nil {
+		t.Fatalf("SkipTo err %v", err)
+	}
+	coinbaseHash := coinbase.TxHash()
+	spend := protos.NewMsgTx(protos.TxVersion)
+	spend.AddTxIn(protos.NewTxIn(protos.NewOutPoint(&coinbaseHash, 0), nil))
+	spend.AddTxOut(protos.NewTxOut(30, otherScript, protos.Asset{}))
+	spend.AddTxOut(protos.NewTxOut(70, pkScript, protos.Asset{}))
+	vtx := protos.NewMsgTx(protos.TxVersion)
+	vtx.AddTxOut(protos.NewTxOut(5, pkScript, protos.Asset{}))
+	block3 := &protos.MsgBlock{Transactions: []*protos.MsgTx{spend}}
+	vblock3 := &protos.MsgVBlock{VTransactions: []*protos.MsgTx{vtx}}
+	if err := w.ConnectBlock(&hash3, 2, block3, vblock3); err == nil {
+		t.Fatalf("ConnectBlock accepted a block not following the wallet")
+	}
+	if err := w.ConnectBlock(&hash3, 3, block3, vblock3); err != nil {
+		t.Fatalf("ConnectBlock err %v", err)
+	}
+	if amount, n := walletBalance(t, w); amount != 75 || n != 2 {
+		t.Fatalf("got %d in %d utxos after block 3, want 75 in 2 utxos", amount, n)
+	}
+	utxos, err := w.Utxos(other.Address)
+	if err != nil || len(utxos) != 0 {
+		t.Fatalf("got %d utxos of an unwatched address, err %v", len(utxos), err)
+	}
+
+	// Rolling back block 3 restores the coinbase output.
+	if err := w.Rollback(&hash2, 2); err != nil {
+		t.Fatalf("Rollback err %v", err)
+	}
+	if hash, height := w.SyncedTo(); hash != hash2 || height != 2 {
+		t.Fatalf("rolled back wallet synced to %v at height %d, want %v at "+
+			"height 2", hash, height, hash2)
+	}
+	utxos, err = w.Utxos(acc.Address)
+	if err != nil {
+		t.Fatalf("Utxos err %v", err)
+	}
+	if len(utxos) != 1 || utxos[0].OutPoint.Hash != coinbaseHash ||
+		utxos[0].Entry.Amount() != 100 || !utxos[0].Entry.IsCoinBase() ||
+		utxos[0].Address != *acc.Address {
+		t.Fatalf("rolled back wallet does not hold the coinbase output")
+	}
+
+	// The wallet state survives a restart, and is reset when the watched
+	// addresses change.
+	w, err = newWallet(db, []*common.Address{acc.Address}, params.GenesisHash)
+	if err != nil {
+		t.Fatalf("newWallet err %v", err)
+	}
+	if _, height := w.SyncedTo(); height != 2 {
+		t.Fatalf("reloaded wallet synced to height %d, want 2", height)
+	}
+	if amount, n := walletBalance(t, w); amount != 100 || n != 1 {
+		t.Fatalf("got %d in %d utxos after the restart, want 100 in 1 utxo", amount, n)
+	}
+	w, err = newWallet(db, []*common.Address{acc.Address, other.Address}, params.GenesisHash)
+	if err != nil {
+		t.Fatalf("newWallet err %v", err)
+	}
+	if _, height := w.SyncedTo(); height != 0 {
+		t.Fatalf("wallet with new addresses synced to height %d, want 0", height)
+	}
+	if amount, n := walletBalance(t, w); amount != 0 || n != 0 {
+		t.Fatalf("got %d in %d utxos with new addresses, want none", amount, n)
+	}
+
+	if err := w.ConnectBlock(&hash1, 1, block1, nil); err != nil {
+		t.Fatalf("ConnectBlock err %v", err)
+	}
+	if amount, n := walletBalance(t, w); amount != 150 || n != 2 {
+		t.Fatalf("got %d in %d utxos watching both addresses, want 150 in 2 utxos", amount, n)
+	}
+}
@@ -0,0 +1,238 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/AsimovNetwork/asimov/addrmgr"
+	"github.com/AsimovNetwork/asimov/asiutil"
+	"github.com/AsimovNetwork/asimov/chaincfg"
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/common/hexutil"
+	fnet "github.com/AsimovNetwork/asimov/common/net"
+	"github.com/AsimovNetwork/asimov/database"
+	"github.com/AsimovNetwork/asimov/database/dbdriver"
+	"github.com/AsimovNetwork/asimov/logger"
+	"github.com/AsimovNetwork/asimov/rpcs/node"
+	"github.com/AsimovNetwork/asimov/spv"
+)
+
+const (
+	// spvDbName is the name of the database of the light client.
+	spvDbName = "spv_" + database.FFLDB
+)
+
+// parseAddresses decodes the passed hex addresses.
+func parseAddresses(addresses []string) ([]*common.Address, error) {
+	result := make([]*common.Address, 0, len(addresses))
+	for _, address := range addresses {
+		b, err := hexutil.Decode(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %s: %v", address, err)
+		}
+		addr, err := common.NewAddress(b)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %s: %v", address, err)
+		}
+		result = append(result, addr)
+	}
+	return result, nil
+}
+
+// parseValidatorSets decodes the passed validator sets, in the
+// '<round>:<address>[,<address>...]' format.
+func parseValidatorSets(sets []string) (map[uint32][]common.Address, error) {
+	result := make(map[uint32][]common.Address, len(sets))
+	for _, set := range sets {
+		parts := strings.SplitN(set, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid validator set %s, the format "+
+				"is <round>:<address>[,<address>...]", set)
+		}
+		round, err := strconv.ParseUint(parts[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid round of the validator set %s: %v",
+				set, err)
+		}
+		addresses, err := parseAddresses(strings.Split(parts[1], ","))
+		if err != nil {
+			return nil, err
+		}
+		validators := make([]common.Address, 0, len(addresses))
+		for _, address := range addresses {
+			validators = append(validators, *address)
+		}
+		result[uint32(round)] = validators
+	}
+	return result, nil
+}
+
+// spvMain runs asimovd as a light client until the interrupt signal is
+// received.
+func spvMain(cfg *chaincfg.FConfig, interrupt <-chan struct{}) error {
+	params := chaincfg.ActiveNetParams.Params
+
+	// The light client checks the producer of each header against the round
+	// robin of the poa rounds, so it can not follow the chains of the other
+	// consensus.
+	if params.BFT || cfg.Consensustype != common.GetConsensusName(common.POA) {
+		return fmt.Errorf("the light client only follows poa chains, not %q",
+			cfg.Consensustype)
+	}
+
+	genesisBlock, err := asiutil.LoadBlockFromFile(cfg.GenesisBlockFile)
+	if err != nil {
+		return fmt.Errorf("load genesis block error, %v", err)
+	}
+	if genesisHash := genesisBlock.Header.BlockHash(); *params.GenesisHash != genesisHash {
+		return fmt.Errorf("load genesis block genesis hash mismatch "+
+			"expected %s, but %s", params.GenesisHash, genesisHash)
+	}
+	params.GenesisBlock = genesisBlock
+
+	addresses, err := parseAddresses(cfg.SPVAddresses)
+	if err != nil {
+		return err
+	}
+	if len(addresses) == 0 {
+		mainLog.Warnf("No address is watched, add some with --spvaddress")
+	}
+	validators := params.GenesisCandidates
+	if len(cfg.SPVValidators) > 0 {
+		parsed, err := parseAddresses(cfg.SPVValidators)
+		if err != nil {
+			return err
+		}
+		validators = make([]common.Address, 0, len(parsed))
+		for _, validator := range parsed {
+			validators = append(validators, *validator)
+		}
+	}
+	validatorSets, err := parseValidatorSets(cfg.SPVValidatorSets)
+	if err != nil {
+		return err
+	}
+	checkpoints := make(map[int32]common.Hash)
+	if !cfg.DisableCheckpoints {
+		for _, checkpoint := range params.Checkpoints {
+			checkpoints[checkpoint.Height] = *checkpoint.Hash
+		}
+		for _, checkpoint := range cfg.AddCheckpoints {
+			checkpoints[checkpoint.Height] = *checkpoint.Hash
+		}
+	}
+
+	nap := fnet.NewNetAdapter(cfg.Proxy, cfg.ProxyUser, cfg.ProxyPass,
+		cfg.OnionProxy, cfg.OnionProxyUser, cfg.ProxyPass, cfg.TorIsolation, cfg.NoOnion)
+	amgr := addrmgr.New(cfg.DataDir, nap)
+	peerAddrs := cfg.ConnectPeers
+	if len(peerAddrs) == 0 {
+		peerAddrs = cfg.AddPeers
+	}
+	var peers []net.Addr
+	for _, addr := range peerAddrs {
+		netAddr, err := amgr.AddrStringToNetAddr(addr)
+		if err != nil {
+			return err
+		}
+		peers = append(peers, netAddr)
+	}
+
+	dbPath := filepath.Join(cfg.DataDir, spvDbName)
+	mainLog.Infof("Loading light client database from '%s'", dbPath)
+	db, err := openDB(cfg.DataDir, dbPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		mainLog.Infof("Gracefully shutting down the database...")
+		db.Close()
+	}()
+
+	manager, err := spv.New(&spv.Config{
+		DB:                db,
+		ChainParams:       params,
+		Validators:        validators,
+		ValidatorSets:     validatorSets,
+		Checkpoints:       checkpoints,
+		MaxTimeOffset:     int64(cfg.MaxTimeOffset),
+		Addresses:         addresses,
+		Peers:             peers,
+		Nap:               nap,
+		HostToNetAddress:  amgr.HostToNetAddress,
+		Proxy:             cfg.Proxy,
+		UserAgentName:     "asimovd",
+		UserAgentVersion:  fmt.Sprintf("%d.%d.%d", chaincfg.AppMajor, chaincfg.AppMinor, chaincfg.AppPatch),
+		UserAgentComments: append(cfg.UserAgentComments, "spv"),
+	})
+	if err != nil {
+		return err
+	}
+	manager.Start()
+	defer manager.Stop()
+
+	if !cfg.DisableRPC {
+		nodeCfg := node.Config{
+			DataDir:      chaincfg.DefaultAppDataDir,
+			HTTPEndpoint: cfg.HTTPEndpoint,
+			HTTPModules:  append(cfg.HTTPModules, "asimov"),
+			HTTPTimeouts: cfg.HTTPTimeouts,
+			WSEndpoint:   cfg.WSEndpoint,
+			WSOrigins:    cfg.WSOrigins,
+			WSModules:    append(cfg.WSModules, "asimov"),
+		}
+		nodeCfg.IPCPath = "asimov.ipc"
+		nodeCfg.HTTPCors = []string{"*"}
+		nodeCfg.HTTPVirtualHosts = []string{"*"}
+		nodeCfg.Logger = logger.GetLogger("RPCS")
+		nodeCfg.NoUSB = true
+
+		stack, err := node.New(&nodeCfg)
+		if err != nil {
+			return fmt.Errorf("failed to create the protocol stack: %v", err)
+		}
+		stack.Register(func(ctx *node.ServiceContext) (node.Service, error) {
+			return spv.NewRpcService(manager), nil
+		})
+		if err := stack.Start(); err != nil {
+			return fmt.Errorf("failed to start the protocol stack: %v", err)
+		}
+		defer stack.Stop()
+	}
+
+	// Wait until the interrupt signal is received from an OS signal.
+	<-interrupt
+	return nil
+}
+
+// openDB opens the database at the passed path, and creates it when it does
+// not exist.
+func openDB(dataDir, dbPath string) (database.Transactor, error) {
+	db, err := dbdriver.Open(database.FFLDB, dbPath, chaincfg.ActiveNetParams.Net)
+	if err != nil {
+		// Return the error if it's not because the database doesn't
+		// exist.
+		if dbErr, ok := err.(database.Error); !ok || dbErr.ErrorCode !=
+			database.ErrDbDoesNotExist {
+			return nil, err
+		}
+
+		// Create the db if it does not exist.
+		if err := os.MkdirAll(dataDir, 0700); err != nil {
+			return nil, err
+		}
+		db, err = dbdriver.Create(database.FFLDB, dbPath, chaincfg.ActiveNetParams.Net)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return db, nil
+}
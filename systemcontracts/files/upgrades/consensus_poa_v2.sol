@@ -0,0 +1,27 @@
+pragma solidity 0.4.25;
+pragma experimental ABIEncoderV2;
+
+import "../consensus_poa.sol";
+
+/**
+ * @dev Upgrade of the POA consensus which lets the validators rotate their key
+ * Note that the chains activate it with the rotateValidatorKey contract upgrade,
+ * the calls to the consensus_poa proxy are delegated to it over the same storage
+ */
+contract ConsensusPOAV2 is ConsensusPOA {
+	/// rotate the key of the calling validator, the new key takes its place in the list of validators
+	/// the change activates at the round given by ValidatorActivationDelay of the chain
+	function rotateValidatorKey(address newKey) public {
+		require(existingValidatorsCheck[msg.sender], "only validators can rotate their key");
+		require(newKey != address(0) && !existingValidatorsCheck[newKey], "invalid new key");
+		uint validatorLength = validators.length;
+		for (uint i = 0; i < validatorLength; i++) {
+			if (validators[i] == msg.sender) {
+				validators[i] = newKey;
+				break;
+			}
+		}
+		delete existingValidatorsCheck[msg.sender];
+		existingValidatorsCheck[newKey] = true;
+	}
+}
@@ -0,0 +1,58 @@
+pragma solidity 0.4.25;
+
+import "../validator_committee.sol";
+
+/**
+ * @dev Upgrade of the validator committee which takes the evidences of the misbehaving validators
+ * Note that the chains activate it with the submitEvidence contract upgrade,
+ * the calls to the validator_committee proxy are delegated to it over the same storage
+ */
+contract ValidatorCommitteeV2 is ValidatorCommittee {
+	/// evidence hash => submitted or not
+	mapping(bytes32 => bool) submittedEvidences;
+	/// validator => number of evidences submitted against it
+	mapping(address => uint) evidenceCounts;
+
+	event SubmitEvidenceEvent(uint height, address offender, bytes32 evidenceHash, address submitter);
+
+	/**
+	 * @dev submit the evidence of a validator which produced two blocks for the same round and slot,
+	 * or signed two blocks at the same height. The evidence is the serialization returned by the
+	 * getEvidence rpc of the nodes, which check it against the signatures of the offender before
+	 * relaying it. The offender loses its sign up for the next round of the committee.
+	 *
+	 * @param offender the misbehaving validator
+	 * @param evidence serialized evidence
+	 */
+	function submitEvidence(address offender, bytes evidence) public {
+		require(chosenValidatorsCheck[msg.sender], "only the committee can submit evidences");
+		require(offender != msg.sender, "invalid offender");
+		require(evidence.length > 0, "invalid evidence");
+		bytes32 evidenceHash = keccak256(evidence);
+		require(!submittedEvidences[evidenceHash], "evidence already submitted");
+
+		submittedEvidences[evidenceHash] = true;
+		evidenceCounts[offender] = SafeMath.add(evidenceCounts[offender], 1);
+
+		if (signupValidatorsCheck[offender]) {
+			delete signupValidatorsCheck[offender];
+			uint length = signupValidators.length;
+			for (uint i = 0; i < length; i++) {
+				if (signupValidators[i] == offender) {
+					signupValidators[i] = signupValidators[length - 1];
+					signupValidators.length--;
+					break;
+				}
+			}
+		}
+
+		emit SubmitEvidenceEvent(block.number, offender, evidenceHash, msg.sender);
+	}
+
+	/**
+	 * @dev get the number of evidences submitted against a validator
+	 */
+	function getEvidenceCount(address validator) public view returns(uint) {
+		return evidenceCounts[validator];
+	}
+}
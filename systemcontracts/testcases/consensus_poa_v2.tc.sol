@@ -0,0 +1,50 @@
+pragma solidity 0.4.25;
+pragma experimental ABIEncoderV2;
+
+/**
+ * the consensus_poa proxy once the chain activated the rotateValidatorKey upgrade
+ */
+interface POAV2 {
+	function rotateValidatorKey(address newKey) external;
+	function getAdminsAndValidators() external view returns(address[], address[]);
+}
+
+contract TestConsensusPOAV2 {
+	POAV2 internal poa;
+
+	event LogResult(bool);
+	event LogExistingMember(bool);
+
+	function setUp() internal {
+		poa = POAV2(0x63000000000000000000000000000000000000006c);
+	}
+
+	function testRotateValidatorKey(address newKey) public {
+		setUp();
+
+		address[] memory validators;
+		(, validators) = poa.getAdminsAndValidators();
+
+		uint index = validators.length;
+		for (uint i = 0; i < validators.length; i++) {
+			if (address(this) == validators[i]) {
+				index = i;
+			}
+		}
+		if (index == validators.length) {
+			emit LogExistingMember(false);
+			return;
+		}
+
+		poa.rotateValidatorKey(newKey);
+
+		address[] memory returnValidators;
+		(, returnValidators) = poa.getAdminsAndValidators();
+		if (returnValidators.length == validators.length && newKey == returnValidators[index]) {
+			emit LogResult(true);
+		} else {
+			emit LogResult(false);
+		}
+	}
+
+}
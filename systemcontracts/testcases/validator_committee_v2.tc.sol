@@ -0,0 +1,28 @@
+pragma solidity 0.4.25;
+
+/**
+ * the validator_committee proxy once the chain activated the submitEvidence upgrade
+ */
+interface CommitteeV2 {
+	function submitEvidence(address offender, bytes evidence) external;
+	function getEvidenceCount(address validator) external view returns(uint);
+}
+
+contract TestCommitteeV2 {
+	CommitteeV2 internal committee;
+
+	event LogEvidenceCount(uint);
+
+	function setUp() internal {
+		committee = CommitteeV2(0x63000000000000000000000000000000000000006b);
+	}
+
+	function testSubmitEvidence(address offender, bytes evidence) public {
+		setUp();
+
+		uint count = committee.getEvidenceCount(offender);
+		committee.submitEvidence(offender, evidence);
+		require(committee.getEvidenceCount(offender) == count + 1, "evidence not counted");
+		emit LogEvidenceCount(count + 1);
+	}
+}
@@ -75,3 +75,53 @@ func (e Event) Sig() string {
 func (e Event) ID() common.Hash {
 	return common.BytesToHash(crypto.Keccak256([]byte(e.Sig())))
 }
+
+// UnpackLog unpacks the topics and the data of a log emitted by the event.
+// The values are returned in the order of the event inputs.  Indexed inputs
+// are read from the topics, skipping the signature topic of non anonymous
+// events.  Indexed inputs of reference types, like strings, bytes, arrays and
+// tuples, are only stored as the hash of their encoding, which is returned as
+// a common.Hash.
+func (e Event) UnpackLog(topics []common.Hash, data []byte) ([]interface{}, error) {
+	if !e.Anonymous {
+		if len(topics) == 0 || topics[0] != e.ID() {
+			return nil, fmt.Errorf("abi: log does not belong to event %v", e.Name)
+		}
+		topics = topics[1:]
+	}
+
+	var nonIndexed []interface{}
+	if e.Inputs.LengthNonIndexed() > 0 {
+		var err error
+		nonIndexed, err = e.Inputs.UnpackValues(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	values := make([]interface{}, 0, len(e.Inputs))
+	for _, input := range e.Inputs {
+		if !input.Indexed {
+			values = append(values, nonIndexed[0])
+			nonIndexed = nonIndexed[1:]
+			continue
+		}
+		if len(topics) == 0 {
+			return nil, fmt.Errorf("abi: missing topic of indexed input %v", input.Name)
+		}
+		topic := topics[0]
+		topics = topics[1:]
+
+		switch input.Type.T {
+		case IntTy, UintTy, BoolTy, AddressTy, HashTy, FixedBytesTy, FunctionTy:
+			value, err := toGoType(0, input.Type, topic[:])
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		default:
+			values = append(values, topic)
+		}
+	}
+	return values, nil
+}
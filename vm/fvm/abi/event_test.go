@@ -426,3 +426,28 @@ func TestEventIndexedWithArrayUnpack(t *testing.T) {
 	require.Equal(t, [2]uint8{0, 0}, rst.Value1)
 	require.Equal(t, stringOut, rst.Value2)
 }
+
+// TestEventUnpackLog verifies that indexed inputs are read from the topics
+// and the others from the data, in the order of the event inputs.
+func TestEventUnpackLog(t *testing.T) {
+	definition := `[{"name": "test", "type": "event", "inputs": [{"indexed": true, "name":"value1", "type":"uint8"},{"indexed": false, "name":"value2", "type":"string"},{"indexed": true, "name":"value3", "type":"string"}]}]`
+	abi, err := JSON(strings.NewReader(definition))
+	require.NoError(t, err)
+	event := abi.Events["test"]
+
+	var b bytes.Buffer
+	b.Write(packNum(reflect.ValueOf(32)))
+	b.Write(packNum(reflect.ValueOf(len("abc"))))
+	b.Write(common.RightPadBytes([]byte("abc"), 32))
+
+	value3 := common.BytesToHash(crypto.Keccak256([]byte("def")))
+	topics := []common.Hash{event.ID(), common.BytesToHash(packNum(reflect.ValueOf(uint8(8)))), value3}
+	values, err := event.UnpackLog(topics, b.Bytes())
+	require.NoError(t, err)
+	require.Equal(t, []interface{}{uint8(8), "abc", value3}, values)
+
+	_, err = event.UnpackLog(topics[1:], b.Bytes())
+	require.Error(t, err)
+	_, err = event.UnpackLog(topics[:2], b.Bytes())
+	require.Error(t, err)
+}
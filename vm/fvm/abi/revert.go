@@ -0,0 +1,113 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package abi
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/AsimovNetwork/asimov/crypto"
+)
+
+var (
+	// revertSelector is the selector of the Error(string) error which is
+	// raised by solidity for require and revert with a reason string.
+	revertSelector = crypto.Keccak256([]byte("Error(string)"))[:4]
+
+	// panicSelector is the selector of the Panic(uint256) error which is
+	// raised by solidity for failed assertions and arithmetic errors.
+	panicSelector = crypto.Keccak256([]byte("Panic(uint256)"))[:4]
+
+	// errNotBuiltinRevert is returned by UnpackRevert when the revert data
+	// is no Error(string) or Panic(uint256) payload.
+	errNotBuiltinRevert = errors.New("abi: revert data is no builtin error")
+)
+
+// Error is a custom error declared in a contract ABI and raised with the
+// solidity revert statement.  The revert data is the 4 byte selector of the
+// error followed by its ABI encoded inputs, like a method call.
+type Error struct {
+	// Name is the error name used for internal representation. Like for
+	// events a suffix is added to overloaded errors.
+	Name string
+	// RawName is the raw error name parsed from ABI.
+	RawName string
+	Inputs  Arguments
+}
+
+func (e Error) String() string {
+	inputs := make([]string, len(e.Inputs))
+	for i, input := range e.Inputs {
+		inputs[i] = fmt.Sprintf("%v %v", input.Type, input.Name)
+	}
+	return fmt.Sprintf("error %v(%v)", e.RawName, strings.Join(inputs, ", "))
+}
+
+// Sig returns the error string signature according to the ABI spec.
+func (e Error) Sig() string {
+	types := make([]string, len(e.Inputs))
+	for i, input := range e.Inputs {
+		types[i] = input.Type.String()
+	}
+	return fmt.Sprintf("%v(%v)", e.RawName, strings.Join(types, ","))
+}
+
+// ID returns the selector of the error, which prefixes the revert data.
+func (e Error) ID() []byte {
+	return crypto.Keccak256([]byte(e.Sig()))[:4]
+}
+
+// Unpack unpacks the inputs of the error from the revert data.
+func (e Error) Unpack(data []byte) ([]interface{}, error) {
+	if len(data) < 4 || !bytes.Equal(data[:4], e.ID()) {
+		return nil, fmt.Errorf("abi: revert data does not belong to error %v", e.Name)
+	}
+	if len(e.Inputs) == 0 {
+		return []interface{}{}, nil
+	}
+	return e.Inputs.UnpackValues(data[4:])
+}
+
+// ErrorByID looks an error up by the selector prefixing the revert data and
+// returns nil if none found.
+func (abi *ABI) ErrorByID(data []byte) (*Error, error) {
+	if len(data) < 4 {
+		return nil, fmt.Errorf("data too short (%d bytes) for abi error lookup", len(data))
+	}
+	for _, e := range abi.Errors {
+		if bytes.Equal(e.ID(), data[:4]) {
+			return &e, nil
+		}
+	}
+	return nil, fmt.Errorf("no error with id: %#x", data[:4])
+}
+
+// UnpackRevert resolves the reason of the revert data of the builtin solidity
+// errors.  An Error(string) payload results in its reason string and a
+// Panic(uint256) payload in a description of its code.
+func UnpackRevert(data []byte) (string, error) {
+	if len(data) < 4 {
+		return "", errNotBuiltinRevert
+	}
+	switch {
+	case bytes.Equal(data[:4], revertSelector):
+		value, err := toGoType(0, Type{T: StringTy}, data[4:])
+		if err != nil {
+			return "", err
+		}
+		return value.(string), nil
+
+	case bytes.Equal(data[:4], panicSelector):
+		if len(data) != 4+32 {
+			return "", errNotBuiltinRevert
+		}
+		code := new(big.Int).SetBytes(data[4:])
+		return fmt.Sprintf("panic code %#x", code), nil
+	}
+	return "", errNotBuiltinRevert
+}
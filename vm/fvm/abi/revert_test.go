@@ -0,0 +1,80 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package abi
+
+import (
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/AsimovNetwork/asimov/common"
+)
+
+func TestUnpackRevert(t *testing.T) {
+	stringTy, _ := NewType("string", "", nil)
+	reason, err := Arguments{{Type: stringTy}}.Pack("not enough balance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := UnpackRevert(append(common.CopyBytes(revertSelector), reason...))
+	if err != nil {
+		t.Fatalf("UnpackRevert: %v", err)
+	}
+	if got != "not enough balance" {
+		t.Fatalf("got reason %q", got)
+	}
+
+	code := common.LeftPadBytes([]byte{0x11}, 32)
+	got, err = UnpackRevert(append(common.CopyBytes(panicSelector), code...))
+	if err != nil {
+		t.Fatalf("UnpackRevert: %v", err)
+	}
+	if got != "panic code 0x11" {
+		t.Fatalf("got reason %q", got)
+	}
+
+	for _, data := range [][]byte{nil, {1, 2, 3, 4}, revertSelector} {
+		if _, err := UnpackRevert(data); err == nil {
+			t.Fatalf("expected error for revert data %x", data)
+		}
+	}
+}
+
+func TestCustomError(t *testing.T) {
+	definition := `[{"type": "error", "name": "InsufficientBalance", "inputs": [{"name": "available", "type": "uint256"}, {"name": "required", "type": "uint256"}]}]`
+	abi, err := JSON(strings.NewReader(definition))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, ok := abi.Errors["InsufficientBalance"]
+	if !ok {
+		t.Fatalf("error not parsed")
+	}
+	if e.Sig() != "InsufficientBalance(uint256,uint256)" {
+		t.Fatalf("unexpected signature %v", e.Sig())
+	}
+
+	args, err := e.Inputs.Pack(big.NewInt(3), big.NewInt(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := append(e.ID(), args...)
+	found, err := abi.ErrorByID(data)
+	if err != nil {
+		t.Fatalf("ErrorByID: %v", err)
+	}
+	values, err := found.Unpack(data)
+	if err != nil {
+		t.Fatalf("Unpack: %v", err)
+	}
+	if !reflect.DeepEqual(values, []interface{}{big.NewInt(3), big.NewInt(5)}) {
+		t.Fatalf("unexpected values %v", values)
+	}
+
+	if _, err := abi.ErrorByID(revertSelector); err == nil {
+		t.Fatalf("expected no error for the builtin selector")
+	}
+}
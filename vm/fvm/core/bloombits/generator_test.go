@@ -0,0 +1,136 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package bloombits
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/types"
+)
+
+// Tests that batched bloom bits are correctly rotated from the input bloom
+// filters and that the original blooms can be reassembled from them.
+func TestGenerator(t *testing.T) {
+	// Generate the input and the rotated output
+	var input, output [types.BloomBitLength][types.BloomByteLength]byte
+
+	for i := 0; i < types.BloomBitLength; i++ {
+		for j := 0; j < types.BloomBitLength; j++ {
+			bit := byte(rand.Int() % 2)
+
+			input[i][j/8] |= bit << byte(7-j%8)
+			output[types.BloomBitLength-1-j][i/8] |= bit << byte(7-i%8)
+		}
+	}
+	// Crunch the input through the generator and verify the result
+	gen, err := NewGenerator(types.BloomBitLength)
+	if err != nil {
+		t.Fatalf("failed to create bloombit generator: %v", err)
+	}
+	for i, bloom := range input {
+		if err := gen.AddBloom(uint(i), bloom); err != nil {
+			t.Fatalf("bloom %d: failed to add: %v", i, err)
+		}
+	}
+	var bitsets [types.BloomBitLength][]byte
+	for i, want := range output {
+		have, err := gen.Bitset(uint(i))
+		if err != nil {
+			t.Fatalf("output %d: failed to retrieve bits: %v", i, err)
+		}
+		if !bytes.Equal(have, want[:]) {
+			t.Errorf("output %d: bit vector mismatch have %x, want %x", i, have, want)
+		}
+		bitsets[i] = have
+	}
+	for i, want := range input {
+		if have := Bloom(bitsets, uint(i)); have != types.Bloom(want) {
+			t.Errorf("bloom %d: reassembled bloom mismatch", i)
+		}
+	}
+}
+
+// Tests that the matcher returns exactly the blocks whose blooms contain the
+// filtered address and topic.
+func TestMatcher(t *testing.T) {
+	const sectionSize = 64
+
+	addr := common.HexToAddress("0x66e3054b411051da5492aec7a823b00cb3add772d7")
+	other := common.HexToAddress("0x6632bc1dcd3c61de2b8aa83e1e26d2c31e1f7c9c8a")
+	topic := common.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef")
+
+	gen, err := NewGenerator(sectionSize)
+	if err != nil {
+		t.Fatalf("failed to create bloombit generator: %v", err)
+	}
+	for i := 0; i < sectionSize; i++ {
+		var logs []*types.Log
+		switch i % 4 {
+		case 0:
+			logs = append(logs, &types.Log{Address: addr, Topics: []common.Hash{topic}})
+		case 1:
+			logs = append(logs, &types.Log{Address: addr})
+		case 2:
+			logs = append(logs, &types.Log{Address: other, Topics: []common.Hash{topic}})
+		}
+		bloom := types.BytesToBloom(types.LogsBloom(logs).Bytes())
+		if err := gen.AddBloom(uint(i), bloom); err != nil {
+			t.Fatalf("bloom %d: failed to add: %v", i, err)
+		}
+	}
+	fetch := func(bit uint) ([]byte, error) {
+		return gen.Bitset(bit)
+	}
+
+	tests := []struct {
+		filters [][][]byte
+		want    int
+	}{
+		{[][][]byte{{addr.Bytes()}, {topic.Bytes()}}, sectionSize / 4},
+		{[][][]byte{{addr.Bytes()}}, sectionSize / 2},
+		{[][][]byte{{addr.Bytes(), other.Bytes()}}, 3 * sectionSize / 4},
+		{[][][]byte{nil, {topic.Bytes()}}, sectionSize / 2},
+		{nil, sectionSize},
+	}
+	for i, tt := range tests {
+		matches, err := NewMatcher(sectionSize, tt.filters).Match(fetch)
+		if err != nil {
+			t.Fatalf("test %d: failed to match: %v", i, err)
+		}
+		// Bloom false positives can only add candidates, never drop them.
+		if len(matches) < tt.want {
+			t.Errorf("test %d: match count mismatch: have %d, want at least %d",
+				i, len(matches), tt.want)
+		}
+	}
+	matches, err := NewMatcher(sectionSize, [][][]byte{{addr.Bytes()}, {topic.Bytes()}}).Match(fetch)
+	if err != nil {
+		t.Fatalf("failed to match: %v", err)
+	}
+	found := make(map[uint64]bool)
+	for _, m := range matches {
+		found[m] = true
+	}
+	for i := uint64(0); i < sectionSize; i += 4 {
+		if !found[i] {
+			t.Errorf("block %d: expected match is missing", i)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package bloombits
+
+import (
+	"github.com/AsimovNetwork/asimov/crypto"
+)
+
+// bloomIndexes represents the bit indexes inside the bloom filter that belong
+// to some key.
+type bloomIndexes [3]uint
+
+// calcBloomIndexes returns the bloom filter bit indexes belonging to the given
+// key, matching the bits set by types.Bloom9.
+func calcBloomIndexes(b []byte) bloomIndexes {
+	b = crypto.Keccak256(b)
+
+	var idxs bloomIndexes
+	for i := 0; i < len(idxs); i++ {
+		idxs[i] = (uint(b[2*i])<<8)&2047 + uint(b[2*i+1])
+	}
+	return idxs
+}
+
+// Matcher checks the rotated bloom bits of a section against a filter made of
+// groups of keys.  A block matches when, for every group, the bloom of at
+// least one key of the group is fully set.  Empty groups are wildcards.
+type Matcher struct {
+	sectionSize uint64
+	filters     [][]bloomIndexes
+}
+
+// NewMatcher creates a matcher for sections of sectionSize blocks.  The
+// filters are usually the contract addresses followed by one group per
+// topic position, as used by log queries.
+func NewMatcher(sectionSize uint64, filters [][][]byte) *Matcher {
+	m := &Matcher{sectionSize: sectionSize}
+	for _, filter := range filters {
+		if len(filter) == 0 {
+			continue
+		}
+		group := make([]bloomIndexes, 0, len(filter))
+		for _, clause := range filter {
+			group = append(group, calcBloomIndexes(clause))
+		}
+		m.filters = append(m.filters, group)
+	}
+	return m
+}
+
+// Match loads the needed bit vectors of a section through fetch, which must
+// return the decompressed vector of sectionSize/8 bytes for the given bit,
+// and returns the positions within the section of the blocks that may match.
+func (m *Matcher) Match(fetch func(bit uint) ([]byte, error)) ([]uint64, error) {
+	size := m.sectionSize / 8
+	cache := make(map[uint][]byte)
+	vector := func(bit uint) ([]byte, error) {
+		if v, ok := cache[bit]; ok {
+			return v, nil
+		}
+		v, err := fetch(bit)
+		if err != nil {
+			return nil, err
+		}
+		cache[bit] = v
+		return v, nil
+	}
+
+	// Start with all blocks as candidates and narrow them down group by
+	// group.
+	result := make([]byte, size)
+	for i := range result {
+		result[i] = 0xff
+	}
+	for _, group := range m.filters {
+		groupMatch := make([]byte, size)
+		for _, idxs := range group {
+			keyMatch := make([]byte, size)
+			copy(keyMatch, result)
+			for _, bit := range idxs {
+				v, err := vector(bit)
+				if err != nil {
+					return nil, err
+				}
+				for i := range keyMatch {
+					keyMatch[i] &= v[i]
+				}
+			}
+			for i := range groupMatch {
+				groupMatch[i] |= keyMatch[i]
+			}
+		}
+		result = groupMatch
+	}
+
+	var matches []uint64
+	for i, b := range result {
+		if b == 0 {
+			continue
+		}
+		for j := uint64(0); j < 8; j++ {
+			if b&(byte(1)<<byte(7-j)) != 0 {
+				matches = append(matches, uint64(i)*8+j)
+			}
+		}
+	}
+	return matches, nil
+}
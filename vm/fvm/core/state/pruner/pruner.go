@@ -0,0 +1,264 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// Package pruner implements the offline pruning of the state database, which
+// deletes the trie nodes and contract codes no longer reachable from the
+// states which are kept.
+package pruner
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/crypto"
+	"github.com/AsimovNetwork/asimov/database"
+	"github.com/AsimovNetwork/asimov/database/dbimpl/ethdb"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/state"
+	"github.com/AsimovNetwork/asimov/vm/fvm/log"
+	"github.com/AsimovNetwork/asimov/vm/fvm/rlp"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// logInterval is the interval between the progress logs of a pruning.
+const logInterval = 8 * time.Second
+
+var (
+	// emptyRoot is the root hash of an empty trie.
+	emptyRoot = common.HexToHash("56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421")
+
+	// emptyCodeHash is the code hash of the accounts without code.
+	emptyCodeHash = crypto.Keccak256(nil)
+)
+
+// Stats describes the outcome of a pruning.
+type Stats struct {
+	// Roots are the state roots which were kept.
+	Roots []common.Hash
+
+	// Live is the number of trie nodes and contract codes reachable from
+	// the kept states.
+	Live uint64
+
+	// Deleted is the number of deleted keys and Size their total size,
+	// keys and values included.
+	Deleted uint64
+	Size    common.StorageSize
+}
+
+// Prune deletes from the state database every trie node and contract code
+// which is not reachable from the states with the passed roots.
+//
+// The live nodes are recorded in a bloom filter of the passed size in bytes,
+// so a small fraction of the dead nodes may be kept.  Only the keys of the
+// length of a hash are considered, the receipts, logs and other data stored
+// alongside the state are left untouched.
+//
+// The roots whose state is not available are skipped, except for the first
+// one, which is expected to be the state of the best chain.
+//
+// The states share most of their nodes, so the subtries already walked are
+// skipped.  They are tracked by an exact set of the walked hashes, which
+// takes memory in proportion to the live nodes.  The bloom is only relied
+// upon by the sweep, where a false positive keeps a dead key.
+func Prune(db *ethdb.LDBDatabase, roots []common.Hash, bloomSize uint64) (*Stats, error) {
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("no state root to keep")
+	}
+
+	m := &marker{
+		stats:  &Stats{},
+		bloom:  newStateBloom(bloomSize),
+		walked: make(map[common.Hash]struct{}),
+		sdb:    state.NewDatabase(db),
+		start:  time.Now(),
+		logged: time.Now(),
+	}
+	for i, root := range roots {
+		accountTrie, err := m.sdb.OpenTrie(root)
+		if err != nil {
+			if i == 0 {
+				return nil, fmt.Errorf("state %v is not available: %v", root, err)
+			}
+			log.Warn("Skipping unavailable state", "root", root)
+			continue
+		}
+		if err := m.markTrie(accountTrie, m.markAccount); err != nil {
+			return nil, fmt.Errorf("failed to iterate state %v: %v", root, err)
+		}
+		m.stats.Roots = append(m.stats.Roots, root)
+	}
+	stats, bloom := m.stats, m.bloom
+	m.walked = nil
+	log.Info("Marked live state", "roots", len(stats.Roots), "nodes", stats.Live,
+		"elapsed", time.Since(m.start))
+
+	// Sweep the dead nodes.  The iterator reads from a snapshot of the
+	// database, so the deletions do not affect it.
+	start, logged := time.Now(), time.Now()
+	batch := db.NewBatch()
+	iter := db.NewIterator()
+	for iter.Next() {
+		key := iter.Key()
+		if len(key) != common.HashLength || bloom.contains(key) {
+			continue
+		}
+		stats.Deleted++
+		stats.Size += common.StorageSize(len(key) + len(iter.Value()))
+		if err := batch.Delete(key); err != nil {
+			iter.Release()
+			return nil, err
+		}
+		if batch.ValueSize() >= database.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				iter.Release()
+				return nil, err
+			}
+			batch.Reset()
+		}
+		if time.Since(logged) > logInterval {
+			log.Info("Deleting dead state", "keys", stats.Deleted, "size", stats.Size,
+				"elapsed", time.Since(start))
+			logged = time.Now()
+		}
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	if err := batch.Write(); err != nil {
+		return nil, err
+	}
+	log.Info("Deleted dead state", "keys", stats.Deleted, "size", stats.Size,
+		"elapsed", time.Since(start))
+
+	// Compact the database to reclaim the space of the deleted keys.
+	start = time.Now()
+	if err := db.LDB().CompactRange(util.Range{}); err != nil {
+		return nil, err
+	}
+	log.Info("Compacted state database", "elapsed", time.Since(start))
+
+	return stats, nil
+}
+
+// marker records the live nodes of the kept states in the bloom.
+type marker struct {
+	stats  *Stats
+	bloom  *stateBloom
+	walked map[common.Hash]struct{}
+	sdb    state.Database
+	start  time.Time
+	logged time.Time
+}
+
+// mark records the passed hash in the bloom, and returns whether the subtrie
+// it is the root of has to be walked, which is when it was not walked yet.
+func (m *marker) mark(hash common.Hash) bool {
+	if _, ok := m.walked[hash]; ok {
+		return false
+	}
+	m.walked[hash] = struct{}{}
+	m.bloom.add(hash[:])
+	m.stats.Live++
+	if time.Since(m.logged) > logInterval {
+		log.Info("Marking live state", "nodes", m.stats.Live,
+			"elapsed", time.Since(m.start))
+		m.logged = time.Now()
+	}
+	return true
+}
+
+// markTrie records the nodes of the trie in the bloom, and calls onLeaf with
+// the key and the value of each leaf which is walked.
+func (m *marker) markTrie(t state.Trie, onLeaf func(key, value []byte) error) error {
+	it := t.NodeIterator(nil)
+	for descend := true; it.Next(descend); {
+		descend = true
+		if it.Leaf() {
+			if onLeaf != nil {
+				if err := onLeaf(it.LeafKey(), it.LeafBlob()); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		// Nodes embedded in their parent have no hash of their own.
+		if hash := it.Hash(); hash != (common.Hash{}) {
+			descend = m.mark(hash)
+		}
+	}
+	return it.Error()
+}
+
+// markAccount records the storage trie and the contract code of the account
+// in the bloom.
+func (m *marker) markAccount(key, value []byte) error {
+	var account state.Account
+	if err := rlp.DecodeBytes(value, &account); err != nil {
+		return err
+	}
+	if account.Root != emptyRoot {
+		storageTrie, err := m.sdb.OpenStorageTrie(common.BytesToHash(key), account.Root)
+		if err != nil {
+			return err
+		}
+		if err := m.markTrie(storageTrie, nil); err != nil {
+			return err
+		}
+	}
+	if !bytes.Equal(account.CodeHash, emptyCodeHash) {
+		m.mark(common.BytesToHash(account.CodeHash))
+	}
+	return nil
+}
+
+// stateBloomHashes is the number of bits set in the state bloom per key.
+const stateBloomHashes = 4
+
+// stateBloom is a bloom filter of trie node and contract code hashes.  The
+// hashes are uniformly distributed, so the bit positions are taken from
+// their bytes directly.
+type stateBloom struct {
+	bits []uint64
+}
+
+// newStateBloom returns a state bloom of the passed size in bytes.
+func newStateBloom(size uint64) *stateBloom {
+	words := size / 8
+	if words == 0 {
+		words = 1
+	}
+	return &stateBloom{bits: make([]uint64, words)}
+}
+
+// positions returns the bit positions of the passed hash.
+func (b *stateBloom) positions(key []byte) [stateBloomHashes]uint64 {
+	var pos [stateBloomHashes]uint64
+	n := uint64(len(b.bits)) * 64
+	for i := range pos {
+		pos[i] = binary.BigEndian.Uint64(key[i*8:]) % n
+	}
+	return pos
+}
+
+// add records the passed hash in the bloom.
+func (b *stateBloom) add(key []byte) {
+	for _, p := range b.positions(key) {
+		b.bits[p/64] |= 1 << (p % 64)
+	}
+}
+
+// contains returns whether the passed hash may have been recorded in the
+// bloom.  False positives are possible, false negatives are not.
+func (b *stateBloom) contains(key []byte) bool {
+	for _, p := range b.positions(key) {
+		if b.bits[p/64]&(1<<(p%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
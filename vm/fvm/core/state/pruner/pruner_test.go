@@ -0,0 +1,196 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package pruner
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/database/dbimpl/ethdb"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/state"
+)
+
+// TestPrune ensures the states which are kept remain intact after a pruning
+// while the nodes of the other states and the data stored alongside them are
+// respectively deleted and kept.
+func TestPrune(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pruner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := ethdb.NewLDBDatabase(dir, 16, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Write a few states, each changing the storage of a contract.
+	sdb := state.NewDatabase(db)
+	addr := common.Address{0x01}
+	var roots []common.Hash
+	root := common.Hash{}
+	for i := 0; i < 4; i++ {
+		statedb, err := state.New(root, sdb)
+		if err != nil {
+			t.Fatal(err)
+		}
+		statedb.SetCode(addr, []byte{0x60, byte(i)})
+		for j := 0; j < 16; j++ {
+			statedb.SetState(addr, common.Hash{byte(j)}, common.Hash{byte(i + 1), byte(j)})
+		}
+		if root, err = statedb.Commit(true); err != nil {
+			t.Fatal(err)
+		}
+		if err = sdb.TrieDB().Commit(root, false); err != nil {
+			t.Fatal(err)
+		}
+		roots = append(roots, root)
+	}
+	other := []byte("receipts of a block")
+	if err := db.Put(other, []byte{0x01}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Keep the last two states.
+	stats, err := Prune(db, []common.Hash{roots[3], roots[2]}, 1024*1024)
+	if err != nil {
+		t.Fatalf("Prune err %v", err)
+	}
+	if len(stats.Roots) != 2 || stats.Deleted == 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	sdb = state.NewDatabase(db)
+	for i, root := range roots {
+		kept := i >= 2
+		if has, _ := db.Has(root[:]); has != kept {
+			t.Errorf("state %d available %v, want %v", i, has, kept)
+		}
+		if !kept {
+			continue
+		}
+		statedb, err := state.New(root, sdb)
+		if err != nil {
+			t.Fatalf("state %d: %v", i, err)
+		}
+		if code := statedb.GetCode(addr); len(code) != 2 || code[1] != byte(i) {
+			t.Errorf("state %d: code %x", i, code)
+		}
+		for j := 0; j < 16; j++ {
+			want := common.Hash{byte(i + 1), byte(j)}
+			if got := statedb.GetState(addr, common.Hash{byte(j)}); got != want {
+				t.Errorf("state %d: slot %d is %v, want %v", i, j, got, want)
+			}
+		}
+	}
+	if has, _ := db.Has(other); !has {
+		t.Errorf("data stored alongside the state was deleted")
+	}
+
+	// The state of the best chain must be available.
+	if _, err := Prune(db, []common.Hash{roots[0]}, 1024); err == nil {
+		t.Errorf("Prune of an unavailable state: expected error")
+	}
+}
+
+// TestMarkFalsePositive ensures a subtrie whose root is a false positive of
+// the bloom is walked, and a subtrie already walked is not walked again.
+func TestMarkFalsePositive(t *testing.T) {
+	m := &marker{
+		stats:  &Stats{},
+		bloom:  newStateBloom(1024),
+		walked: make(map[common.Hash]struct{}),
+		start:  time.Now(),
+		logged: time.Now(),
+	}
+	hash := common.Hash{0x01, 0x02, 0x03}
+	m.bloom.add(hash[:])
+	if !m.mark(hash) {
+		t.Errorf("subtrie in the bloom but not walked was skipped")
+	}
+	if m.mark(hash) {
+		t.Errorf("subtrie already walked was walked again")
+	}
+	if m.stats.Live != 1 {
+		t.Errorf("%d live nodes, want 1", m.stats.Live)
+	}
+}
+
+// TestPruneSharedSubtries ensures the subtries the kept states share are only
+// marked once.
+func TestPruneSharedSubtries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pruner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := ethdb.NewLDBDatabase(dir, 16, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Write two states which only differ by the balance of an account, so
+	// they share the storage and the code of the contract.
+	sdb := state.NewDatabase(db)
+	contract, account := common.Address{0x01}, common.Address{0x02}
+	var roots []common.Hash
+	root := common.Hash{}
+	for i := 0; i < 2; i++ {
+		statedb, err := state.New(root, sdb)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			statedb.SetCode(contract, []byte{0x60, 0x01})
+			for j := 0; j < 16; j++ {
+				statedb.SetState(contract, common.Hash{byte(j)}, common.Hash{0x01, byte(j)})
+			}
+		}
+		statedb.AddBalance(account, big.NewInt(1))
+		if root, err = statedb.Commit(true); err != nil {
+			t.Fatal(err)
+		}
+		if err = sdb.TrieDB().Commit(root, false); err != nil {
+			t.Fatal(err)
+		}
+		roots = append(roots, root)
+	}
+
+	// Keeping both states only marks the nodes of the account trie leading
+	// to the account in addition.
+	both, err := Prune(db, []common.Hash{roots[1], roots[0]}, 1024*1024)
+	if err != nil {
+		t.Fatalf("Prune err %v", err)
+	}
+	statedb, err := state.New(roots[0], state.NewDatabase(db))
+	if err != nil {
+		t.Fatalf("state 0: %v", err)
+	}
+	if got := statedb.GetState(contract, common.Hash{0x0f}); got != (common.Hash{0x01, 0x0f}) {
+		t.Errorf("state 0: slot 15 is %v", got)
+	}
+
+	first, err := Prune(db, roots[1:], 1024*1024)
+	if err != nil {
+		t.Fatalf("Prune err %v", err)
+	}
+	if both.Live <= first.Live || both.Live-first.Live > 2 {
+		t.Errorf("states sharing their contract have %d live nodes, want "+
+			"at most 2 more than the %d of one state", both.Live, first.Live)
+	}
+	again, err := Prune(db, []common.Hash{roots[1], roots[1]}, 1024*1024)
+	if err != nil {
+		t.Fatalf("Prune err %v", err)
+	}
+	if again.Live != first.Live {
+		t.Errorf("state kept twice has %d live nodes, want %d", again.Live, first.Live)
+	}
+}
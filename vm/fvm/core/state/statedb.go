@@ -263,6 +263,37 @@ func (self *StateDB) StorageTrie(addr common.Address) Trie {
 	return cpy.updateTrie(self.db)
 }
 
+// proofList collects the nodes of a merkle proof in the order they are
+// visited, from the root down.
+type proofList [][]byte
+
+func (n *proofList) Put(key []byte, value []byte) error {
+	*n = append(*n, value)
+	return nil
+}
+
+// GetProof returns the merkle proof of an account against the state root.
+// The proof is keyed by the keccak256 hash of the address, and proves the
+// absence of the account when it does not exist.
+func (self *StateDB) GetProof(addr common.Address) ([][]byte, error) {
+	var proof proofList
+	err := self.trie.Prove(crypto.Keccak256(addr[:]), 0, &proof)
+	return [][]byte(proof), err
+}
+
+// GetStorageProof returns the merkle proof of a storage slot of an account
+// against the storage root of the account.  The proof is keyed by the
+// keccak256 hash of the slot.
+func (self *StateDB) GetStorageProof(addr common.Address, key common.Hash) ([][]byte, error) {
+	tr := self.StorageTrie(addr)
+	if tr == nil {
+		return nil, fmt.Errorf("storage trie for address %x does not exist", addr[:])
+	}
+	var proof proofList
+	err := tr.Prove(crypto.Keccak256(key[:]), 0, &proof)
+	return [][]byte(proof), err
+}
+
 func (self *StateDB) HasSuicided(addr common.Address) bool {
 	stateObject := self.getStateObject(addr)
 	if stateObject != nil {
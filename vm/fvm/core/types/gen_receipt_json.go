@@ -22,6 +22,7 @@ func (r Receipt) MarshalJSON() ([]byte, error) {
 		TxHash            string         `json:"transactionHash" gencodec:"required"`
 		ContractAddress   common.Address `json:"contractAddress"`
 		GasUsed           hexutil.Uint64 `json:"gasUsed" gencodec:"required"`
+		RevertReason      hexutil.Bytes  `json:"revertReason,omitempty"`
 	}
 	var enc Receipt
 	enc.PostState = r.PostState
@@ -32,6 +33,7 @@ func (r Receipt) MarshalJSON() ([]byte, error) {
 	enc.TxHash = common.Hash(r.TxHash).String()
 	enc.ContractAddress = r.ContractAddress
 	enc.GasUsed = hexutil.Uint64(r.GasUsed)
+	enc.RevertReason = r.RevertReason
 	return json.Marshal(&enc)
 }
 
@@ -46,6 +48,7 @@ func (r *Receipt) UnmarshalJSON(input []byte) error {
 		TxHash            *common.Hash    `json:"transactionHash" gencodec:"required"`
 		ContractAddress   *common.Address `json:"contractAddress"`
 		GasUsed           *hexutil.Uint64 `json:"gasUsed" gencodec:"required"`
+		RevertReason      *hexutil.Bytes  `json:"revertReason,omitempty"`
 	}
 	var dec Receipt
 	if err := json.Unmarshal(input, &dec); err != nil {
@@ -80,5 +83,8 @@ func (r *Receipt) UnmarshalJSON(input []byte) error {
 		return errors.New("missing required field 'gasUsed' for Receipt")
 	}
 	r.GasUsed = uint64(*dec.GasUsed)
+	if dec.RevertReason != nil {
+		r.RevertReason = *dec.RevertReason
+	}
 	return nil
 }
@@ -55,6 +55,9 @@ type Receipt struct {
 	TxHash          common.Hash    `json:"transactionHash" gencodec:"required"`
 	ContractAddress common.Address `json:"contractAddress"`
 	GasUsed         uint64         `json:"gasUsed" gencodec:"required"`
+	// RevertReason is the data returned by a contract execution stopped by
+	// the REVERT opcode.
+	RevertReason []byte `json:"revertReason,omitempty"`
 }
 
 type receiptMarshaling struct {
@@ -62,6 +65,7 @@ type receiptMarshaling struct {
 	Status            hexutil.Uint64
 	CumulativeGasUsed hexutil.Uint64
 	GasUsed           hexutil.Uint64
+	RevertReason      hexutil.Bytes
 }
 
 // receiptRLP is the consensus encoding of a receipt.
@@ -81,6 +85,20 @@ type receiptStorageRLP struct {
 	Logs              []*LogForStorage
 	GasUsed           uint64
 	Status            uint64
+	RevertReason      []byte
+}
+
+// legacyReceiptStorageRLP is the storage encoding of receipts written before
+// the revert reason was stored.
+type legacyReceiptStorageRLP struct {
+	PostStateOrStatus []byte
+	CumulativeGasUsed uint64
+	Bloom             Bloom
+	TxHash            common.Hash
+	ContractAddress   common.Address
+	Logs              []*LogForStorage
+	GasUsed           uint64
+	Status            uint64
 }
 
 // NewReceipt creates a barebone transaction receipt, copying the init fields.
@@ -166,6 +184,7 @@ func (r *ReceiptForStorage) EncodeRLP(w io.Writer) error {
 		Logs:              make([]*LogForStorage, len(r.Logs)),
 		GasUsed:           r.GasUsed,
 		Status:            r.Status,
+		RevertReason:      r.RevertReason,
 	}
 	for i, log := range r.Logs {
 		enc.Logs[i] = (*LogForStorage)(log)
@@ -176,10 +195,27 @@ func (r *ReceiptForStorage) EncodeRLP(w io.Writer) error {
 // DecodeRLP implements rlp.Decoder, and loads both consensus and implementation
 // fields of a receipt from an RLP stream.
 func (r *ReceiptForStorage) DecodeRLP(s *rlp.Stream) error {
-	var dec receiptStorageRLP
-	if err := s.Decode(&dec); err != nil {
+	blob, err := s.Raw()
+	if err != nil {
 		return err
 	}
+	var dec receiptStorageRLP
+	if err := rlp.DecodeBytes(blob, &dec); err != nil {
+		var legacy legacyReceiptStorageRLP
+		if rlp.DecodeBytes(blob, &legacy) != nil {
+			return err
+		}
+		dec = receiptStorageRLP{
+			PostStateOrStatus: legacy.PostStateOrStatus,
+			CumulativeGasUsed: legacy.CumulativeGasUsed,
+			Bloom:             legacy.Bloom,
+			TxHash:            legacy.TxHash,
+			ContractAddress:   legacy.ContractAddress,
+			Logs:              legacy.Logs,
+			GasUsed:           legacy.GasUsed,
+			Status:            legacy.Status,
+		}
+	}
 	if err := (*Receipt)(r).setStatus(dec.PostStateOrStatus); err != nil {
 		return err
 	}
@@ -192,6 +228,9 @@ func (r *ReceiptForStorage) DecodeRLP(s *rlp.Stream) error {
 	}
 	// Assign the implementation fields
 	r.TxHash, r.ContractAddress, r.GasUsed = dec.TxHash, dec.ContractAddress, dec.GasUsed
+	if len(dec.RevertReason) > 0 {
+		r.RevertReason = dec.RevertReason
+	}
 	return nil
 }
 
@@ -0,0 +1,55 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package types
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/vm/fvm/rlp"
+)
+
+func TestReceiptStorageRevertReason(t *testing.T) {
+	receipt := NewReceipt(nil, true, 0)
+	receipt.TxHash = common.HexToHash("0x01")
+	receipt.GasUsed = 21000
+	receipt.Logs = []*Log{}
+	receipt.RevertReason = []byte{0x08, 0xc3, 0x79, 0xa0}
+
+	blob, err := rlp.EncodeToBytes((*ReceiptForStorage)(receipt))
+	if err != nil {
+		t.Fatalf("EncodeToBytes: %v", err)
+	}
+	var dec ReceiptForStorage
+	if err := rlp.DecodeBytes(blob, &dec); err != nil {
+		t.Fatalf("DecodeBytes: %v", err)
+	}
+	if !bytes.Equal(dec.RevertReason, receipt.RevertReason) ||
+		dec.TxHash != receipt.TxHash || dec.Status != ReceiptStatusFailed {
+		t.Fatalf("receipt did not survive storage: %+v", dec)
+	}
+
+	// Receipts stored without a revert reason still decode.
+	legacy := &legacyReceiptStorageRLP{
+		PostStateOrStatus: receiptStatusSuccessfulRLP,
+		TxHash:            receipt.TxHash,
+		Logs:              []*LogForStorage{},
+		GasUsed:           receipt.GasUsed,
+		Status:            ReceiptStatusSuccessful,
+	}
+	blob, err = rlp.EncodeToBytes(legacy)
+	if err != nil {
+		t.Fatalf("EncodeToBytes: %v", err)
+	}
+	dec = ReceiptForStorage{}
+	if err := rlp.DecodeBytes(blob, &dec); err != nil {
+		t.Fatalf("DecodeBytes legacy: %v", err)
+	}
+	if dec.RevertReason != nil || dec.GasUsed != receipt.GasUsed ||
+		dec.Status != ReceiptStatusSuccessful {
+		t.Fatalf("legacy receipt decoded wrongly: %+v", dec)
+	}
+}
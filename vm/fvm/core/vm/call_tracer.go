@@ -0,0 +1,266 @@
+// Copyright (c) 2018-2020 The asimov developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package vm
+
+import (
+	"math/big"
+	"time"
+
+	"github.com/AsimovNetwork/asimov/common"
+	"github.com/AsimovNetwork/asimov/protos"
+	"github.com/AsimovNetwork/asimov/vm/fvm/core/virtualtx"
+	"github.com/AsimovNetwork/asimov/vm/fvm/params"
+)
+
+// CallFrame is a message call or contract creation captured by the
+// CallTracer, along with the calls it made in turn.
+type CallFrame struct {
+	Type    OpCode
+	From    common.Address
+	To      common.Address
+	Value   *big.Int
+	Asset   *protos.Asset
+	Gas     uint64
+	GasUsed uint64
+	Input   []byte
+	Output  []byte
+	Error   string
+
+	// Calls are the frames of the calls made by this frame.
+	Calls []*CallFrame
+
+	// Transfers are the asset transfers recorded in the virtual
+	// transaction while this frame was executing.
+	Transfers []*virtualtx.VTransfer
+
+	// gasBase is the gas the caller kept once it was charged for the call,
+	// used to derive the gas used by the frame when it returns.
+	gasBase uint64
+	// remaining is the gas left after the last executed opcode.
+	remaining uint64
+	lastOp    OpCode
+	// inferred is set for frames which were not opened by a call opcode.
+	inferred bool
+}
+
+// CallTracer is an FVM tracer which records the tree of message calls and
+// contract creations of a transaction, and the asset transfers each of them
+// made.  Calls into accounts without code and precompiled contracts are
+// recorded too, as they are inferred from the call opcodes of the caller.
+type CallTracer struct {
+	// frames are the top level executions.  Only the coinbase transaction
+	// runs more than one.
+	frames []*CallFrame
+	// callstack is the path from the top level execution to the frame
+	// currently executing.
+	callstack []*CallFrame
+
+	vtx  *virtualtx.VirtualTransaction
+	seen int
+}
+
+// NewCallTracer returns a new call tracer.
+func NewCallTracer() *CallTracer {
+	return &CallTracer{}
+}
+
+// CaptureStart implements the Tracer interface to open a top level frame.
+func (t *CallTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	frame := &CallFrame{
+		Type:  CALL,
+		From:  from,
+		To:    to,
+		Value: new(big.Int),
+		Gas:   gas,
+		Input: common.CopyBytes(input),
+	}
+	if value != nil {
+		frame.Value.Set(value)
+	}
+	if create {
+		frame.Type = CREATE
+	}
+	t.frames = append(t.frames, frame)
+	t.callstack = []*CallFrame{frame}
+	return nil
+}
+
+// CaptureState implements the Tracer interface.  It opens a frame when a
+// call or creation opcode is about to run and closes the frames the
+// execution returned from.
+func (t *CallTracer) CaptureState(env *FVM, pc uint64, op OpCode, gas, cost uint64, memory *Memory, stack *Stack, contract *Contract, depth int, err error) error {
+	if len(t.callstack) == 0 {
+		return nil
+	}
+	t.collectTransfers(env)
+
+	// The execution returned to a caller.
+	for len(t.callstack) > depth {
+		t.exit(env, gas, stack)
+	}
+	// The execution entered a frame opened by an opcode other than the
+	// call opcodes, like the asset and deployment instructions.
+	for len(t.callstack) < depth {
+		caller := t.callstack[len(t.callstack)-1]
+		frame := &CallFrame{
+			Type:     caller.lastOp,
+			From:     contract.Caller(),
+			To:       contract.Address(),
+			Value:    new(big.Int).Set(contract.Value()),
+			Asset:    contract.Asset(),
+			Gas:      gas,
+			Input:    common.CopyBytes(contract.Input),
+			inferred: true,
+		}
+		caller.Calls = append(caller.Calls, frame)
+		t.callstack = append(t.callstack, frame)
+	}
+
+	current := t.callstack[len(t.callstack)-1]
+	if current.Asset == nil && len(t.callstack) == 1 {
+		current.Asset = contract.Asset()
+	}
+	current.lastOp = op
+	if cost <= gas {
+		current.remaining = gas - cost
+	}
+	if err != nil {
+		current.Error = err.Error()
+		return nil
+	}
+
+	frame := &CallFrame{Type: op, From: contract.Address(), Value: new(big.Int)}
+	switch op {
+	case CALL:
+		frame.To = common.BigToAddress(stack.Back(1))
+		frame.Value.Set(stack.Back(2))
+		frame.Asset = protos.AssetFromInt(stack.Back(3))
+		frame.Input = memory.Get(stack.Back(4).Int64(), stack.Back(5).Int64())
+	case CALLCODE:
+		frame.To = common.BigToAddress(stack.Back(1))
+		frame.Value.Set(stack.Back(2))
+		frame.Input = memory.Get(stack.Back(3).Int64(), stack.Back(4).Int64())
+	case DELEGATECALL, STATICCALL:
+		frame.To = common.BigToAddress(stack.Back(1))
+		frame.Input = memory.Get(stack.Back(2).Int64(), stack.Back(3).Int64())
+	case CREATE, CREATE2:
+		frame.Value.Set(stack.Back(0))
+		frame.Input = memory.Get(stack.Back(1).Int64(), stack.Back(2).Int64())
+	default:
+		return nil
+	}
+
+	if op == CREATE || op == CREATE2 {
+		// The creation receives all but one 64th of the gas left.
+		frame.Gas = gas - cost
+		frame.Gas -= frame.Gas / 64
+		frame.gasBase = gas - cost - frame.Gas
+	} else {
+		frame.Gas = env.callGasTemp
+		if frame.Value.Sign() != 0 && op != DELEGATECALL && op != STATICCALL {
+			frame.Gas += params.CallStipend
+		}
+		frame.gasBase = gas - cost
+	}
+	current.Calls = append(current.Calls, frame)
+	t.callstack = append(t.callstack, frame)
+	return nil
+}
+
+// CaptureFault implements the Tracer interface to record the error of the
+// frame currently executing.
+func (t *CallTracer) CaptureFault(env *FVM, pc uint64, op OpCode, gas, cost uint64, memory *Memory, stack *Stack, contract *Contract, depth int, err error) error {
+	if len(t.callstack) == 0 || err == nil {
+		return nil
+	}
+	t.callstack[len(t.callstack)-1].Error = err.Error()
+	return nil
+}
+
+// CaptureEnd implements the Tracer interface to close the top level frame.
+func (t *CallTracer) CaptureEnd(output []byte, gasUsed uint64, _ time.Duration, err error) error {
+	if len(t.callstack) == 0 {
+		return nil
+	}
+	t.collectTransfers(nil)
+	for _, frame := range t.callstack[1:] {
+		if frame.GasUsed == 0 {
+			frame.GasUsed = frame.Gas - frame.remaining
+		}
+	}
+
+	frame := t.callstack[0]
+	frame.Output = common.CopyBytes(output)
+	frame.GasUsed = gasUsed
+	if err != nil {
+		frame.Error = err.Error()
+	}
+	t.callstack = nil
+	return nil
+}
+
+// Frames returns the top level frames captured by the tracer, one for each
+// contract execution of the transaction.
+func (t *CallTracer) Frames() []*CallFrame { return t.frames }
+
+// collectTransfers attributes the transfers recorded in the virtual
+// transaction since the last call to the frame currently executing.  Passing
+// a nil environment keeps on following the last seen virtual transaction.
+func (t *CallTracer) collectTransfers(env *FVM) {
+	if env != nil && env.Vtx != t.vtx {
+		// The coinbase transaction threads the same virtual transaction
+		// through all of its executions, other transactions start afresh.
+		t.vtx, t.seen = env.Vtx, 0
+	}
+	if t.vtx == nil || t.seen >= len(t.vtx.VTransfer) {
+		return
+	}
+	current := t.callstack[len(t.callstack)-1]
+	current.Transfers = append(current.Transfers, t.vtx.VTransfer[t.seen:]...)
+	t.seen = len(t.vtx.VTransfer)
+}
+
+// exit closes the frame currently executing once the execution returned to
+// its caller, which is about to run the opcode with the passed gas and
+// stack.
+func (t *CallTracer) exit(env *FVM, gas uint64, stack *Stack) {
+	frame := t.callstack[len(t.callstack)-1]
+	t.callstack = t.callstack[:len(t.callstack)-1]
+
+	if frame.inferred {
+		// Frames not opened by a call opcode only know about the gas
+		// left by their last opcode.
+		frame.GasUsed = frame.Gas - frame.remaining
+		return
+	}
+	if returned := gas - frame.gasBase; gas >= frame.gasBase && returned <= frame.Gas {
+		frame.GasUsed = frame.Gas - returned
+	}
+
+	// The call opcodes push zero on failure, the creation opcodes the
+	// address of the new contract on success.
+	result := stack.Back(0)
+	if result.Sign() == 0 {
+		if frame.Error == "" {
+			if frame.lastOp == REVERT {
+				frame.Error = errExecutionReverted.Error()
+			} else {
+				frame.Error = "internal failure"
+			}
+		}
+	}
+
+	switch frame.Type {
+	case CREATE, CREATE2:
+		if result.Sign() != 0 {
+			frame.To = common.BigToAddress(result)
+			frame.Output = common.CopyBytes(env.StateDB.GetCode(frame.To))
+		}
+	default:
+		if in, ok := env.interpreter.(*FVMInterpreter); ok {
+			frame.Output = common.CopyBytes(in.returnData)
+		}
+	}
+}
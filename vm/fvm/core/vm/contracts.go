@@ -453,8 +453,16 @@ func (c *ValidatorCommittee) Run(fvm *FVM, input []byte, contract *Contract) ([]
 
 func handleDelegateCall(systemContractAddr common.ContractCode, fvm *FVM, input []byte, contract *Contract) (
 	ret []byte, leftOverGas uint64, err error) {
-	// actual address of the delegated system contract
-	delegateAddr, instanceAddr, _ := fvm.GetSystemContractInfo(systemContractAddr)
+	// actual address of the delegated system contract, the version active
+	// in the executed block whatever the best block is.
+	var delegateAddr common.Address
+	var instanceAddr []byte
+	if fvm.GetSystemContractInfoByHeight != nil {
+		delegateAddr, instanceAddr, _ = fvm.GetSystemContractInfoByHeight(
+			systemContractAddr, int32(fvm.BlockNumber.Int64()))
+	} else {
+		delegateAddr, instanceAddr, _ = fvm.GetSystemContractInfo(systemContractAddr)
+	}
 	instanceAddress := common.BytesToAddress(instanceAddr)
 
 	// current system contract
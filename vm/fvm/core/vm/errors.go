@@ -29,4 +29,9 @@ var (
 	ErrContractAddressCollision = errors.New("contract address collision")
 	ErrNoCompatibleInterpreter  = errors.New("no compatible interpreter")
 	ErrCalcGasFailed			= errors.New("calc gas failed")
+
+	// ErrExecutionReverted is returned when the execution is stopped by the
+	// REVERT opcode.  The return data of the call then holds the revert
+	// payload.
+	ErrExecutionReverted = errExecutionReverted
 )
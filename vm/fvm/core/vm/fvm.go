@@ -54,6 +54,8 @@ type (
 	UnPackFunctionResultFunc func(abiStr string, v interface{}, funcName string, output []byte) error
 	// Get system contract information
 	GetSystemContractInfoFunc func(delegateAddr common.ContractCode) (common.Address, []byte, string)
+	// Get the information of the version of a system contract active at a height
+	GetSystemContractInfoByHeightFunc func(delegateAddr common.ContractCode, height int32) (common.Address, []byte, string)
 	// Fetch a given template from template warehouse
 	FetchTemplateFunc func(view *txo.UtxoViewpoint, hash *common.Hash) (uint16, []byte, []byte, []byte, []byte, error)
 	// Get vote value
@@ -102,6 +104,7 @@ type Context struct {
 	PackFunctionArgs PackFunctionArgsFunc
 	UnPackFunctionResult UnPackFunctionResultFunc
 	GetSystemContractInfo GetSystemContractInfoFunc
+	GetSystemContractInfoByHeight GetSystemContractInfoByHeightFunc
 	FetchTemplate FetchTemplateFunc
 	VoteValue VoteValueFunc
 
@@ -22,6 +22,7 @@ type ChainContext interface {
 	GetVmConfig() *fvm.Config
 	GetTemplateWarehouseInfo() (common.Address, string)
 	GetSystemContractInfo(delegateAddr common.ContractCode) (common.Address, []byte, string)
+	GetSystemContractInfoByHeight(delegateAddr common.ContractCode, height int32) (common.Address, []byte, string)
 	GetTemplateInfo(contractAddr []byte, gas uint64, block *asiutil.Block, stateDB fvm.StateDB, chainConfig *params.ChainConfig)(uint16, string, uint64)
 	FetchTemplate(view *txo.UtxoViewpoint, hash *common.Hash) (uint16, []byte, []byte, []byte, []byte, error)
 	BlockHashByHeight(int32) (*common.Hash, error)
@@ -41,6 +42,7 @@ func NewFVMContext(from common.Address, gasPrice *big.Int, block *asiutil.Block,
 		PackFunctionArgs:         PackFunctionArgs,
 		UnPackFunctionResult:     UnPackFunctionResult,
 		GetSystemContractInfo:    chain.GetSystemContractInfo,
+		GetSystemContractInfoByHeight: chain.GetSystemContractInfoByHeight,
 		FetchTemplate:            chain.FetchTemplate,
 		VoteValue:                voteValue,
 		Origin:                   from,